@@ -0,0 +1,69 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReviewFuncServeHTTP(t *testing.T) {
+	review := ReviewFunc(func(_ context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+		resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+		if req.Namespace == "denied" {
+			Deny(resp, "denied namespace")
+		}
+		return resp
+	})
+	send := func(body []byte) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		review.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+		return rec
+	}
+
+	for namespace, allowed := range map[string]bool{"default": true, "denied": false} {
+		body, err := json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request:  &admissionv1.AdmissionRequest{UID: "uid", Namespace: namespace},
+		})
+		require.NoError(t, err)
+		rec := send(body)
+		require.Equal(t, http.StatusOK, rec.Code)
+		result := admissionv1.AdmissionReview{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		assert.Nil(t, result.Request)
+		require.NotNil(t, result.Response)
+		assert.Equal(t, "uid", string(result.Response.UID))
+		assert.Equal(t, allowed, result.Response.Allowed, namespace)
+		if !allowed {
+			assert.Equal(t, int32(http.StatusForbidden), result.Response.Result.Code)
+			assert.Equal(t, "denied namespace", result.Response.Result.Message)
+		}
+	}
+
+	assert.Equal(t, http.StatusBadRequest, send([]byte("invalid")).Code)
+	assert.Equal(t, http.StatusBadRequest, send([]byte("{}")).Code)
+}
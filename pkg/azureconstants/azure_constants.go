@@ -27,23 +27,33 @@ const (
 	DefaultCredFilePathLinux          = "/etc/kubernetes/azure.json"
 	DefaultCredFilePathWindows        = "C:\\k\\azure.json"
 	DefaultDriverName                 = "disk.csi.azure.com"
+	DriverRoleController              = "controller"
+	DriverRoleNode                    = "node"
 	DesIDField                        = "diskencryptionsetid"
 	DiskEncryptionTypeField           = "diskencryptiontype"
 	DiskAccessIDField                 = "diskaccessid"
 	DiskIOPSReadWriteField            = "diskiopsreadwrite"
 	DiskMBPSReadWriteField            = "diskmbpsreadwrite"
 	DiskNameField                     = "diskname"
+	DiskPoolField                     = "diskpool"
 	EnableBurstingField               = "enablebursting"
 	ErrDiskNotFound                   = "not found"
+	FsFreezeField                     = "fsfreeze"
 	FsTypeField                       = "fstype"
 	IncrementalField                  = "incremental"
+	IopsLimitField                    = "iopslimit"
 	KindField                         = "kind"
 	LocationField                     = "location"
 	LogicalSectorSizeField            = "logicalsectorsize"
+	LogicalSectorSize512              = 512
+	LogicalSectorSize4096             = 4096
 	LUN                               = "LUN"
 	MaxSharesField                    = "maxshares"
 	MinimumDiskSizeGiB                = 1
 	NetworkAccessPolicyField          = "networkaccesspolicy"
+	NodeClassLabelField               = "nodeclasslabel"
+	NodeClassDiskIOPSField            = "nodeclassdiskiopsreadwrite"
+	NodeClassDiskMBPSField            = "nodeclassdiskmbpsreadwrite"
 	PublicNetworkAccessField          = "publicnetworkaccess"
 	NotFound                          = "NotFound"
 	PerfProfileBasic                  = "basic"
@@ -58,18 +68,45 @@ const (
 	PvNameTag                         = "kubernetes.io-created-for-pv-name"
 	SnapshotNamespaceTag              = "kubernetes.io-created-for-snapshot-namespace"
 	SnapshotNameTag                   = "kubernetes.io-created-for-snapshot-name"
+	SnapshotRetentionDaysField        = "retentiondays"
+	SnapshotRetentionDaysTag          = "kubernetes.io-snapshot-retention-days"
+	MaxSnapshotsPerVolumeField        = "maxsnapshotspervolume"
+	MaxSnapshotsPerVolumeTag          = "kubernetes.io-max-snapshots-per-volume"
+	SourceVolumeIDTag                 = "source_volume_id"
 	PvNameKey                         = "csi.storage.k8s.io/pv/name"
+	SystemCriticalVolumePriority      = "system-critical"
+	VolumePriorityAnnotation          = "disk.csi.azure.com/volume-priority"
+	DiskSkuAnnotation                 = "disk.csi.azure.com/sku"
+	DiskTierAnnotation                = "disk.csi.azure.com/tier"
+	DiskZonesAnnotation               = "disk.csi.azure.com/zones"
+	DiskZoneAnnotation                = "disk.csi.azure.com/zone"
+	DiskEncryptionTypeAnnotation      = "disk.csi.azure.com/encryption-type"
+	DiskLogicalSectorSizeAnnotation   = "disk.csi.azure.com/logical-sector-size"
+	DiskBurstingEnabledAnnotation     = "disk.csi.azure.com/bursting-enabled"
+	DiskAdoptedAtAnnotation           = "disk.csi.azure.com/adopted-at"
+	DiskAdoptionChangesAnnotation     = "disk.csi.azure.com/adoption-changes"
+	VolumeMaintenanceAnnotation       = "disk.csi.azure.com/maintenance-until"
+	PVNodeAffinityUpdateAnnotation    = "disk.csi.azure.com/update-node-affinity"
+	NodeRemainingDiskIOPSAnnotation   = "disk.csi.azure.com/remaining-disk-iops"
+	NodeRemainingDiskMBpsAnnotation   = "disk.csi.azure.com/remaining-disk-mbps"
+	PerfProfileAnnotation             = "disk.csi.azure.com/perf-profile"
+	DeviceSettingsAnnotation          = "disk.csi.azure.com/device-settings"
 	VolumeSnapshotNameKey             = "csi.storage.k8s.io/volumesnapshot/name"
 	VolumeSnapshotNamespaceKey        = "csi.storage.k8s.io/volumesnapshot/namespace"
 	VolumeSnapshotContentNameKey      = "csi.storage.k8s.io/volumesnapshotcontent/name"
 	RateLimited                       = "rate limited"
 	RequestedSizeGib                  = "requestedsizegib"
+	ReservedBlocksPercentageField     = "reservedblockspercentage"
+	FsGroupChangePolicyField          = "fsgroupchangepolicy"
 	ResizeRequired                    = "resizeRequired"
 	SubscriptionIDField               = "subscriptionid"
 	ResourceGroupField                = "resourcegroup"
+	ResourceGroupTagsField            = "resourcegrouptags"
+	CreateResourceGroupIfNotExist     = "createresourcegroupifnotexist"
 	DataAccessAuthModeField           = "dataaccessauthmode"
 	ResourceNotFound                  = "ResourceNotFound"
 	SkuNameField                      = "skuname"
+	SkuFallbackField                  = "skufallback"
 	SourceDiskSearchMaxDepth          = 10
 	SourceSnapshot                    = "snapshot"
 	SourceVolume                      = "volume"
@@ -89,9 +126,15 @@ const (
 	EnableAsyncAttachField            = "enableasyncattach"
 	PerformancePlusField              = "enableperformanceplus"
 	PerformancePlusMinimumDiskSizeGiB = 513
+	SupportsHibernationField          = "supportshibernation"
+	OptimizedForFrequentAttachField   = "optimizedforfrequentattach"
 	AttachDiskInitialDelayField       = "attachdiskinitialdelay"
+	BandwidthLimitField               = "bandwidthlimit"
+	PodUIDKey                         = "csi.storage.k8s.io/pod.uid"
+	CgroupRootPathLinux               = "/sys/fs/cgroup"
 	TooManyRequests                   = "TooManyRequests"
 	ClientThrottled                   = "client throttled"
+	SkuNotAvailable                   = "SkuNotAvailable"
 	VolumeID                          = "volumeid"
 	Node                              = "node"
 	SourceResourceID                  = "source_resource_id"
@@ -103,10 +146,54 @@ const (
 	// define different sleep time when hit throttling
 	SnapshotOpThrottlingSleepSec    = 50
 	MaxThrottlingSleepSec           = 1200
+	NVMeDataDiskNamespaceOffset     = 2 // the namespace id of a data disk on the NVMe controller is its lun plus the offset
 	AgentNotReadyNodeTaintKeySuffix = "/agent-not-ready"
 	// define tag value delimiter and default is comma
 	TagValueDelimiterField = "tagvaluedelimiter"
 	AzureDiskDriverTag     = "kubernetes-azure-dd"
+	// ReFS filesystem on Windows, integrity streams of the new volume are set by the mount options
+	FsTypeReFS                    = "refs"
+	IntegrityStreamsMountOption   = "integritystreams"
+	NoIntegrityStreamsMountOption = "nointegritystreams"
+	// options of formatting a new volume, mkfs options of ext and xfs filesystems and the allocation unit size of NTFS
+	// and ReFS volumes, which is passed to the Windows mounter in the mount options as allocationunitsize=<bytes>
+	MkfsOptionsField              = "mkfsoptions"
+	AllocationUnitSizeField       = "allocationunitsize"
+	AllocationUnitSizeMountOption = "allocationunitsize"
+	// diagnostic setting exporting the metrics of a new disk to a Log Analytics workspace, the tag on the disk
+	// records the name of the setting removed before the disk is deleted
+	EnableAzureMonitorField = "enableazuremonitor"
+	WorkspaceIDField        = "workspaceid"
+	DiagnosticSettingName   = "azuredisk-csi-driver"
+	DiagnosticSettingTag    = "k8s-azure-diagnostic-setting"
+	// Azure Compute Gallery image version a new disk is created from, the lun selects a data disk image of the
+	// image version, the OS disk image is used if the lun is not set
+	GalleryImageReferenceIDField = "galleryimagereferenceid"
+	GalleryImageLunField         = "galleryimagelun"
+	// comma separated storage account names the volume populator imports VHD blobs from without a SAS token
+	ImportStorageAccountsField = "importstorageaccounts"
+	// keys of the provisioner and snapshotter secrets with the credentials of another tenant the disks and snapshots
+	// are managed in, subscriptionid and resourcegroup are optional
+	TenantIDSecretKey           = "tenantid"
+	ClientIDSecretKey           = "clientid"
+	ClientSecretSecretKey       = "clientsecret"
+	FederatedTokenFileSecretKey = "federatedtokenfile"
+	// dm-crypt/LUKS encryption of the volume on the node, the passphrase is in the node stage secret, the key slot of
+	// the previous passphrase is changed to the passphrase on NodeStageVolume to rotate the key
+	HostEncryptionField             = "hostencryption"
+	LUKSPassphraseSecretKey         = "passphrase"
+	LUKSPreviousPassphraseSecretKey = "previousPassphrase"
+	// security profile of the disks of trusted launch and confidential VMs, the disk encryption set is only used by
+	// ConfidentialVM_DiskEncryptedWithCustomerKey disks
+	SecurityTypeField                = "securitytype"
+	SecureVMDiskEncryptionSetIDField = "securevmdiskencryptionsetid"
+	// tag of the disks provisioned by the driver whose value is the UID of the kube-system namespace of the cluster,
+	// set on creation and repaired by the tag reconciler unless the PV has the skip annotation
+	OwnerTag                   = "k8s-azure-dd-owner"
+	SkipTagReconcileAnnotation = "disk.csi.azure.com/skip-tag-reconcile"
+	// disks with this tag are never deleted by the orphan disk GC, set by the GC on the disks of the PVs with Retain
+	// policy, so the disks are kept after the PVs are deleted
+	RetainDiskTag = "k8s-azure-dd-retain"
 )
 
 var (
@@ -0,0 +1,142 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// nodeAttachSlots are the in-flight attach slots of a node, sized by the VM size of the node
+type nodeAttachSlots struct {
+	instanceType     string
+	maxDataDiskCount int64
+	slots            chan struct{}
+}
+
+// getAttachConcurrency returns the in-flight attach limit of a VM size with maxDataDiskCount data disks, which is
+// its data disk count between 1 and limit. Attaches to a node in flight at the same time are batched into one VM
+// update by the disk controller, so the limit only keeps attach storms to small VM sizes within what the VM can hold.
+func getAttachConcurrency(maxDataDiskCount, limit int64) int {
+	concurrency := maxDataDiskCount
+	if concurrency > limit {
+		concurrency = limit
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return int(concurrency)
+}
+
+// getNodeAttachSlots returns the attach slots of nodeName, the VM size is read from the instance type label of the
+// node when the node is attached to for the first time, the default volume limit is used if the label is not set.
+// The slots are dropped by the node informer when the VM size of the node changes or the node is deleted.
+func (d *Driver) getNodeAttachSlots(ctx context.Context, nodeName types.NodeName) (*nodeAttachSlots, error) {
+	key := strings.ToLower(string(nodeName))
+	if slots, ok := d.nodeAttachSlots.Load(key); ok {
+		return slots.(*nodeAttachSlots), nil
+	}
+	_, instanceType, err := getNodeInfoFromLabels(ctx, string(nodeName), d.kubeClient)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to get VM size of node %s: %v", nodeName, err)
+	}
+	maxDataDiskCount := getMaxDataDiskCount(instanceType)
+	concurrency := getAttachConcurrency(maxDataDiskCount, d.maxAttachConcurrencyPerNode)
+	slots, loaded := d.nodeAttachSlots.LoadOrStore(key, &nodeAttachSlots{
+		instanceType:     instanceType,
+		maxDataDiskCount: maxDataDiskCount,
+		slots:            make(chan struct{}, concurrency),
+	})
+	if !loaded {
+		klog.V(2).Infof("node %s of VM size %q with %d data disks allows %d in-flight attaches", nodeName, instanceType, maxDataDiskCount, concurrency)
+	}
+	return slots.(*nodeAttachSlots), nil
+}
+
+// acquireNodeAttachSlot waits for an attach slot of nodeName, it returns a no-op release function if the limit is
+// disabled or the attach is system-critical, Unavailable if the VM size of the node could not be read, and
+// ResourceExhausted if no slot is free before ctx is done
+func (d *Driver) acquireNodeAttachSlot(ctx context.Context, nodeName types.NodeName) (func(), error) {
+	if d.maxAttachConcurrencyPerNode <= 0 || isSystemCriticalOperation(ctx) {
+		return func() {}, nil
+	}
+	slots, err := d.getNodeAttachSlots(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case slots.slots <- struct{}{}:
+		return func() { <-slots.slots }, nil
+	case <-ctx.Done():
+		return nil, status.Errorf(codes.ResourceExhausted, "all %d attach slots of node %s of VM size %q are in use: %v",
+			cap(slots.slots), nodeName, slots.instanceType, ctx.Err())
+	}
+}
+
+// runNodeAttachSlotsInformer drops the attach slots of a node when the VM size of the node changes or the node is
+// deleted, so that a resized node gets slots of its new VM size and deleted nodes do not leak. Attaches holding a
+// dropped slot release it to the dropped slots.
+func (d *Driver) runNodeAttachSlotsInformer(ctx context.Context) {
+	factory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	informer := factory.Core().V1().Nodes().Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: d.onNodeAttachSlotsUpdate,
+		DeleteFunc: d.onNodeAttachSlotsDelete,
+	}); err != nil {
+		klog.Errorf("failed to add node event handler: %v", err)
+		return
+	}
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
+
+// onNodeAttachSlotsUpdate drops the attach slots of a node whose instance type label changed
+func (d *Driver) onNodeAttachSlotsUpdate(oldObj, newObj interface{}) {
+	oldNode, ok := oldObj.(*v1.Node)
+	if !ok {
+		return
+	}
+	newNode, ok := newObj.(*v1.Node)
+	if !ok {
+		return
+	}
+	if oldNode.Labels[consts.InstanceTypeKey] != newNode.Labels[consts.InstanceTypeKey] {
+		klog.V(2).Infof("VM size of node %s changed from %q to %q, dropping its attach slots", newNode.Name, oldNode.Labels[consts.InstanceTypeKey], newNode.Labels[consts.InstanceTypeKey])
+		d.nodeAttachSlots.Delete(strings.ToLower(newNode.Name))
+	}
+}
+
+// onNodeAttachSlotsDelete drops the attach slots of a deleted node
+func (d *Driver) onNodeAttachSlotsDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if node, ok := obj.(*v1.Node); ok {
+		d.nodeAttachSlots.Delete(strings.ToLower(node.Name))
+	}
+}
@@ -0,0 +1,158 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func TestGetAttachConcurrency(t *testing.T) {
+	tests := []struct {
+		maxDataDiskCount int64
+		limit            int64
+		expected         int
+	}{
+		{maxDataDiskCount: 0, limit: 8, expected: 1},
+		{maxDataDiskCount: 4, limit: 8, expected: 4},
+		{maxDataDiskCount: 8, limit: 8, expected: 8},
+		{maxDataDiskCount: 64, limit: 8, expected: 8},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getAttachConcurrency(test.maxDataDiskCount, test.limit), "maxDataDiskCount: %d, limit: %d", test.maxDataDiskCount, test.limit)
+	}
+}
+
+func TestAcquireNodeAttachSlot(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// disabled
+	release, err := d.acquireNodeAttachSlot(ctx, "small-node")
+	require.NoError(t, err)
+	release()
+
+	d.maxAttachConcurrencyPerNode = 8
+	d.kubeClient = fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "small-node", Labels: map[string]string{consts.InstanceTypeKey: "Standard_B2s"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "large-node", Labels: map[string]string{consts.InstanceTypeKey: "Standard_D16s_v3"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled-node", Labels: map[string]string{"kubernetes.io/os": "linux"}}},
+	)
+
+	// the node could not be read, nothing is cached
+	_, err = d.acquireNodeAttachSlot(ctx, "missing-node")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, ok := d.nodeAttachSlots.Load("missing-node")
+	assert.False(t, ok)
+
+	tests := []struct {
+		nodeName            types.NodeName
+		expectedConcurrency int
+	}{
+		{nodeName: "small-node", expectedConcurrency: 4},
+		{nodeName: "large-node", expectedConcurrency: 8},
+		{nodeName: "unlabeled-node", expectedConcurrency: 8},
+	}
+	for _, test := range tests {
+		var releases []func()
+		for i := 0; i < test.expectedConcurrency; i++ {
+			release, err := d.acquireNodeAttachSlot(ctx, test.nodeName)
+			require.NoError(t, err, "node %s", test.nodeName)
+			releases = append(releases, release)
+		}
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		_, err := d.acquireNodeAttachSlot(timeoutCtx, test.nodeName)
+		cancel()
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err), "node %s", test.nodeName)
+
+		// a system-critical attach does not wait for a slot
+		release, err := d.acquireNodeAttachSlot(withSystemCriticalOperation(ctx), test.nodeName)
+		require.NoError(t, err, "node %s", test.nodeName)
+		release()
+
+		releases[0]()
+		release, err = d.acquireNodeAttachSlot(ctx, test.nodeName)
+		require.NoError(t, err, "node %s", test.nodeName)
+		release()
+		for _, release := range releases[1:] {
+			release()
+		}
+	}
+}
+
+func TestNodeAttachSlotsInvalidation(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	smallNode := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{consts.InstanceTypeKey: "Standard_B2s"}}}
+	d.maxAttachConcurrencyPerNode = 8
+	d.kubeClient = fake.NewSimpleClientset(smallNode)
+	slots, err := d.getNodeAttachSlots(ctx, "node")
+	require.NoError(t, err)
+	assert.Equal(t, 4, cap(slots.slots))
+
+	// an unrelated update keeps the slots
+	labeledNode := smallNode.DeepCopy()
+	labeledNode.Labels["foo"] = "bar"
+	d.onNodeAttachSlotsUpdate(smallNode, labeledNode)
+	_, ok := d.nodeAttachSlots.Load("node")
+	assert.True(t, ok)
+
+	// a resize drops the slots, the next attach gets slots of the new VM size
+	largeNode := smallNode.DeepCopy()
+	largeNode.Labels[consts.InstanceTypeKey] = "Standard_D16s_v3"
+	_, err = d.kubeClient.CoreV1().Nodes().Update(ctx, largeNode, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	d.onNodeAttachSlotsUpdate(smallNode, largeNode)
+	slots, err = d.getNodeAttachSlots(ctx, "node")
+	require.NoError(t, err)
+	assert.Equal(t, 8, cap(slots.slots))
+
+	// a deleted node drops the slots, also if only its tombstone is known
+	d.onNodeAttachSlotsDelete(largeNode)
+	_, ok = d.nodeAttachSlots.Load("node")
+	assert.False(t, ok)
+	_, err = d.getNodeAttachSlots(ctx, "node")
+	require.NoError(t, err)
+	d.onNodeAttachSlotsDelete(cache.DeletedFinalStateUnknown{Key: "node", Obj: largeNode})
+	_, ok = d.nodeAttachSlots.Load("node")
+	assert.False(t, ok)
+}
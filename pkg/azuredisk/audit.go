@@ -0,0 +1,117 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"k8s.io/klog/v2"
+)
+
+// auditRecord is a single entry of the audit log, one JSON object per line
+type auditRecord struct {
+	Time       string `json:"time"`
+	Method     string `json:"method"`
+	Peer       string `json:"peer,omitempty"`
+	Request    string `json:"request"`
+	Response   string `json:"response,omitempty"`
+	Code       string `json:"code"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// auditLogger writes an audit record for every CSI RPC served by the driver
+type auditLogger struct {
+	mu  sync.Mutex
+	out io.WriteCloser
+	now func() time.Time
+}
+
+// newAuditLogger returns an audit logger writing to stdout if path is empty,
+// otherwise to the file at path which is rotated once it reaches maxSizeMB
+func newAuditLogger(path string, maxSizeMB, maxBackups int) *auditLogger {
+	var out io.WriteCloser = nopWriteCloser{os.Stdout}
+	if path != "" {
+		out = &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSizeMB,
+			MaxBackups: maxBackups,
+		}
+	}
+	return &auditLogger{out: out, now: time.Now}
+}
+
+// UnaryServerInterceptor logs the sanitized request, response, result and duration of each RPC
+func (a *auditLogger) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := a.now()
+	resp, err := handler(ctx, req)
+
+	record := auditRecord{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		Method:     info.FullMethod,
+		Request:    protosanitizer.StripSecrets(req).String(),
+		Code:       status.Code(err).String(),
+		DurationMs: a.now().Sub(start).Milliseconds(),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		record.Peer = p.Addr.String()
+	}
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.Response = protosanitizer.StripSecrets(resp).String()
+	}
+	if werr := a.write(record); werr != nil {
+		klog.Errorf("failed to write audit log for %s: %v", info.FullMethod, werr)
+	}
+	return resp, err
+}
+
+func (a *auditLogger) write(record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.out.Write(append(line, '\n'))
+	return err
+}
+
+// Close flushes and closes the underlying audit log file
+func (a *auditLogger) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.out.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
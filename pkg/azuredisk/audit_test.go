@@ -0,0 +1,135 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuditLoggerUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      interface{}
+		resp     interface{}
+		err      error
+		expected auditRecord
+	}{
+		{
+			name: "successful call with secrets stripped",
+			req: &csi.CreateVolumeRequest{
+				Name:    "pvc-1",
+				Secrets: map[string]string{"key": "secret-value"},
+			},
+			resp: &csi.CreateVolumeResponse{Volume: &csi.Volume{VolumeId: "vol-1"}},
+			expected: auditRecord{
+				Time:       "2024-01-01T00:00:00Z",
+				Method:     "/csi.v1.Controller/CreateVolume",
+				Peer:       "127.0.0.1:8080",
+				Request:    `{"name":"pvc-1","secrets":"***stripped***"}`,
+				Response:   `{"volume":{"volume_id":"vol-1"}}`,
+				Code:       codes.OK.String(),
+				DurationMs: 1500,
+			},
+		},
+		{
+			name: "failed call",
+			req:  &csi.DeleteVolumeRequest{VolumeId: "vol-1"},
+			err:  status.Error(codes.Internal, "delete failed"),
+			expected: auditRecord{
+				Time:       "2024-01-01T00:00:00Z",
+				Method:     "/csi.v1.Controller/DeleteVolume",
+				Peer:       "127.0.0.1:8080",
+				Request:    `{"volume_id":"vol-1"}`,
+				Code:       codes.Internal.String(),
+				Error:      "rpc error: code = Internal desc = delete failed",
+				DurationMs: 1500,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			calls := 0
+			logger := &auditLogger{
+				out: nopWriteCloser{buf},
+				now: func() time.Time {
+					calls++
+					if calls == 1 {
+						return start
+					}
+					return start.Add(1500 * time.Millisecond)
+				},
+			}
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}})
+			info := &grpc.UnaryServerInfo{FullMethod: test.expected.Method}
+			handler := func(_ context.Context, _ interface{}) (interface{}, error) {
+				return test.resp, test.err
+			}
+
+			resp, err := logger.UnaryServerInterceptor(ctx, test.req, info, handler)
+			assert.Equal(t, test.resp, resp)
+			assert.Equal(t, test.err, err)
+
+			var record auditRecord
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+			assert.Equal(t, test.expected, record)
+			assert.NotContains(t, buf.String(), "secret-value")
+		})
+	}
+}
+
+func TestNewAuditLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger := newAuditLogger(path, 1, 1)
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Identity/Probe"}
+	handler := func(_ context.Context, _ interface{}) (interface{}, error) {
+		return &csi.ProbeResponse{}, nil
+	}
+	for i := 0; i < 2; i++ {
+		_, err := logger.UnaryServerInterceptor(context.Background(), &csi.ProbeRequest{}, info, handler)
+		assert.NoError(t, err)
+	}
+	require.NoError(t, logger.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+	for _, line := range lines {
+		var record auditRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		assert.Equal(t, info.FullMethod, record.Method)
+	}
+}
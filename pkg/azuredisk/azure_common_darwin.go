@@ -35,10 +35,11 @@ import (
 const sysClassBlockPath = "/sys/class/block/"
 
 // Note: This file is added only to ensure that the UTs can be run from MacOS.
-func scsiHostRescan(io azureutils.IOHandler, m *mount.SafeFormatAndMount) {
+func scsiHostRescan(io azureutils.IOHandler, m *mount.SafeFormatAndMount) error {
+	return nil
 }
 
-func formatAndMount(source, target, fstype string, options []string, m *mount.SafeFormatAndMount) error {
+func formatAndMount(source, target, fstype string, options []string, _ int, _ []string, m *mount.SafeFormatAndMount) error {
 	return nil
 }
 
@@ -46,6 +47,10 @@ func findDiskByLun(lun int, io azureutils.IOHandler, m *mount.SafeFormatAndMount
 	return "", fmt.Errorf("findDiskByLun not implemented")
 }
 
+func getDiskIdentity(devicePath string, _ azureutils.IOHandler) (string, error) {
+	return "", fmt.Errorf("getDiskIdentity not implemented")
+}
+
 func preparePublishPath(path string, m *mount.SafeFormatAndMount) error {
 	return nil
 }
@@ -119,6 +124,39 @@ func rescanAllVolumes(io azureutils.IOHandler) error {
 	return nil
 }
 
+func freezeFilesystem(mountPath string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("filesystem freeze is not supported on this platform")
+}
+
+func thawFilesystem(mountPath string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("filesystem freeze is not supported on this platform")
+}
+
+func setReservedBlocksPercentage(devicePath, percentage string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("reserved blocks percentage is not supported on this platform")
+}
+
+func setupLUKSDevice(devicePath, mapperName, passphrase, previousPassphrase string, m *mount.SafeFormatAndMount) (string, error) {
+	return "", fmt.Errorf("host encryption is not supported on this platform")
+}
+
+func closeLUKSDevice(mapperName string, m *mount.SafeFormatAndMount) error {
+	return nil
+}
+
+func resizeLUKSDevice(mapperName, passphrase string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("host encryption is not supported on this platform")
+}
+
+func setVolumeOwnership(dir string, fsGroup int64, policy string) error {
+	klog.V(2).Infof("skip changing ownership of %s to fsGroup(%d) since fsGroup is not supported on this platform", dir, fsGroup)
+	return nil
+}
+
 func (d *DriverCore) GetVolumeStats(ctx context.Context, m *mount.SafeFormatAndMount, volumeID, target string, hostutil hostUtil) ([]*csi.VolumeUsage, error) {
 	return []*csi.VolumeUsage{}, nil
 }
+
+func getDiskIOStats(devicePath string) (*diskIOStats, error) {
+	return nil, fmt.Errorf("IO statistics of %s are not supported on this platform", devicePath)
+}
@@ -26,17 +26,29 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"unsafe"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume"
 	mount "k8s.io/mount-utils"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 )
 
-const sysClassBlockPath = "/sys/class/block/"
+const (
+	sysClassBlockPath = "/sys/class/block/"
+	sysClassNVMePath  = "/sys/class/nvme/"
+	// model of the remote NVMe controller serving the managed disks on NVMe VM sizes, e.g. Ebsv5, Ebdsv5
+	azureNVMeControllerModel = "MSFT NVMe Accelerator v1.0"
+	// maximum time in seconds to wait for udev to create the device links of rescanned NVMe namespaces
+	udevSettleTimeoutInSeconds = 10
+)
 
 // exclude those used by azure as resource and OS root in /dev/disk/azure, /dev/disk/azure/scsi0
 // "/dev/disk/azure/scsi0" dir is populated in Standard_DC4s/DC2s on Ubuntu 18.04
@@ -81,7 +93,10 @@ func getDiskLinkByDevName(io azureutils.IOHandler, devLinkPath, devName string)
 	return "", fmt.Errorf("read %s error: %v", devLinkPath, err)
 }
 
-func scsiHostRescan(io azureutils.IOHandler, _ *mount.SafeFormatAndMount) {
+// scsiHostRescan rescans the scsi hosts and the remote NVMe controllers, the error of the scsi host rescan is returned
+// after all the hosts are rescanned
+func scsiHostRescan(io azureutils.IOHandler, m *mount.SafeFormatAndMount) error {
+	var rescanErr error
 	scsiPath := "/sys/class/scsi_host/"
 	if dirs, err := io.ReadDir(scsiPath); err == nil {
 		for _, f := range dirs {
@@ -89,11 +104,88 @@ func scsiHostRescan(io azureutils.IOHandler, _ *mount.SafeFormatAndMount) {
 			data := []byte("- - -")
 			if err = io.WriteFile(name, data, 0666); err != nil {
 				klog.Warningf("failed to rescan scsi host %s", name)
+				rescanErr = fmt.Errorf("failed to rescan scsi host %s: %w", name, err)
 			}
 		}
 	} else {
 		klog.Warningf("failed to read %s, err %v", scsiPath, err)
+		rescanErr = fmt.Errorf("failed to read %s: %w", scsiPath, err)
+	}
+	if nvmeControllerRescan(io) > 0 && m != nil && m.Exec != nil {
+		// wait until udev creates the /dev/disk/azure/data/by-lun links of the rescanned namespaces
+		if out, err := m.Exec.Command("udevadm", "settle", fmt.Sprintf("--timeout=%d", udevSettleTimeoutInSeconds)).CombinedOutput(); err != nil {
+			klog.Warningf("udevadm settle failed with %v, output: %s", err, string(out))
+		}
+	}
+	return rescanErr
+}
+
+// nvmeControllerRescan rescans the namespaces of the remote NVMe controllers and returns the number of rescanned controllers
+func nvmeControllerRescan(io azureutils.IOHandler) int {
+	rescanned := 0
+	for _, ctrl := range listAzureNVMeControllers(io) {
+		name := sysClassNVMePath + ctrl + "/rescan_controller"
+		if err := io.WriteFile(name, []byte("1"), 0666); err != nil {
+			klog.Warningf("failed to rescan nvme controller %s", name)
+			continue
+		}
+		rescanned++
+	}
+	return rescanned
+}
+
+// listAzureNVMeControllers returns the names of the remote NVMe controllers serving managed disks, e.g. nvme0
+func listAzureNVMeControllers(io azureutils.IOHandler) []string {
+	dirs, err := io.ReadDir(sysClassNVMePath)
+	if err != nil {
+		return nil
 	}
+	var controllers []string
+	for _, f := range dirs {
+		model, err := io.ReadFile(filepath.Join(sysClassNVMePath, f.Name(), "model"))
+		if err != nil {
+			klog.V(4).Infof("failed to read model of nvme controller %s, err: %v", f.Name(), err)
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(string(model)), azureNVMeControllerModel) {
+			controllers = append(controllers, f.Name())
+		}
+	}
+	return controllers
+}
+
+// findNVMeDiskByLun finds the namespace of a remote NVMe controller serving the disk attached at lun,
+// the namespace id of a data disk is its lun plus consts.NVMeDataDiskNamespaceOffset since the OS disk is namespace 1
+func findNVMeDiskByLun(lun int, io azureutils.IOHandler) (string, error) {
+	controllers := listAzureNVMeControllers(io)
+	if len(controllers) == 0 {
+		return "", fmt.Errorf("no remote nvme controller found under %s", sysClassNVMePath)
+	}
+	nsid := strconv.Itoa(lun + consts.NVMeDataDiskNamespaceOffset)
+	for _, ctrl := range controllers {
+		dirs, err := io.ReadDir(filepath.Join(sysClassNVMePath, ctrl))
+		if err != nil {
+			klog.Warningf("failed to read nvme controller %s, err: %v", ctrl, err)
+			continue
+		}
+		for _, f := range dirs {
+			// look for namespace path like /sys/class/nvme/nvme0/nvme0n3
+			name := f.Name()
+			if !strings.HasPrefix(name, ctrl+"n") {
+				continue
+			}
+			id, err := io.ReadFile(filepath.Join(sysClassNVMePath, ctrl, name, "nsid"))
+			if err != nil {
+				klog.V(4).Infof("failed to read nsid of nvme namespace %s, err: %v", name, err)
+				continue
+			}
+			if strings.TrimSpace(string(id)) == nsid {
+				klog.V(2).Infof("found nvme namespace %s(nsid: %s) by lun %d", name, nsid, lun)
+				return "/dev/" + name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("nvme namespace %s is not found", nsid)
 }
 
 func findDiskByLun(lun int, io azureutils.IOHandler, _ *mount.SafeFormatAndMount) (string, error) {
@@ -102,6 +194,9 @@ func findDiskByLun(lun int, io azureutils.IOHandler, _ *mount.SafeFormatAndMount
 	if err == nil && device != "" {
 		return device, nil
 	}
+	if device, nvmeErr := findNVMeDiskByLun(lun, io); nvmeErr == nil {
+		return device, nil
+	}
 
 	devPaths := []string{
 		fmt.Sprintf("/dev/disk/azure/scsi1/lun%d", lun),
@@ -119,8 +214,25 @@ func findDiskByLun(lun int, io azureutils.IOHandler, _ *mount.SafeFormatAndMount
 	return "", fmt.Errorf("failed to find disk by lun %d", lun)
 }
 
-func formatAndMount(source, target, fstype string, options []string, m *mount.SafeFormatAndMount) error {
-	return m.FormatAndMount(source, target, fstype, options)
+// mkfsOptions of the storage class are appended to the format options, mkfs takes the last value of a repeated option
+func formatAndMount(source, target, fstype string, options []string, logicalSectorSize int, mkfsOptions []string, m *mount.SafeFormatAndMount) error {
+	formatOptions := append(getFormatOptions(fstype, logicalSectorSize), mkfsOptions...)
+	return m.FormatAndMountSensitiveWithFormatOptions(source, target, fstype, options, nil, formatOptions)
+}
+
+// getFormatOptions returns the mkfs options which align the filesystem to a 4k logical sector size,
+// the block size of ext filesystems must not be smaller than the sector size of the device
+func getFormatOptions(fstype string, logicalSectorSize int) []string {
+	if logicalSectorSize != consts.LogicalSectorSize4096 {
+		return nil
+	}
+	switch fstype {
+	case "xfs":
+		return []string{"-s", fmt.Sprintf("size=%d", logicalSectorSize)}
+	case "ext2", "ext3", "ext4":
+		return []string{"-b", strconv.Itoa(logicalSectorSize)}
+	}
+	return nil
 }
 
 // finds a device mounted to "current" node
@@ -214,6 +326,23 @@ func findDiskByLunWithConstraint(lun int, io azureutils.IOHandler, azureDisks []
 	return "", err
 }
 
+// getDiskIdentity returns the wwid of the disk of devicePath, which is unique per disk, so that a disk attached at the
+// lun of a detached disk could be told apart from it
+func getDiskIdentity(devicePath string, io azureutils.IOHandler) (string, error) {
+	device, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", err
+	}
+	// the wwid of a SCSI disk is in its device directory, the one of an NVMe namespace is in its own directory
+	name := filepath.Base(device)
+	for _, path := range []string{filepath.Join(sysClassBlockPath, name, "device", "wwid"), filepath.Join(sysClassBlockPath, name, "wwid")} {
+		if content, err := io.ReadFile(path); err == nil && strings.TrimSpace(string(content)) != "" {
+			return strings.TrimSpace(string(content)), nil
+		}
+	}
+	return "", fmt.Errorf("wwid of %s is not found", device)
+}
+
 func preparePublishPath(_ string, _ *mount.SafeFormatAndMount) error {
 	return nil
 }
@@ -250,6 +379,20 @@ func getBlockSizeBytes(devicePath string, m *mount.SafeFormatAndMount) (int64, e
 	return gotSizeBytes, nil
 }
 
+// getBlockSizeBytesWithIoctl returns the size of the block device with the BLKGETSIZE64 ioctl
+func getBlockSizeBytesWithIoctl(devicePath string) (int64, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+	var size uint64
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return -1, fmt.Errorf("BLKGETSIZE64 ioctl on %s failed: %v", devicePath, errno)
+	}
+	return int64(size), nil
+}
+
 func resizeVolume(devicePath, volumePath string, m *mount.SafeFormatAndMount) error {
 	_, err := mount.NewResizeFs(m.Exec).Resize(devicePath, volumePath)
 	return err
@@ -287,6 +430,211 @@ func rescanAllVolumes(io azureutils.IOHandler) error {
 	return nil
 }
 
+// freezeFilesystem suspends new writes to the filesystem mounted at mountPath and flushes it to disk
+func freezeFilesystem(mountPath string, m *mount.SafeFormatAndMount) error {
+	if output, err := m.Exec.Command("fsfreeze", "--freeze", mountPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("fsfreeze --freeze %s failed: output: %s, err: %v", mountPath, string(output), err)
+	}
+	return nil
+}
+
+// thawFilesystem resumes writes to the filesystem mounted at mountPath
+func thawFilesystem(mountPath string, m *mount.SafeFormatAndMount) error {
+	if output, err := m.Exec.Command("fsfreeze", "--unfreeze", mountPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("fsfreeze --unfreeze %s failed: output: %s, err: %v", mountPath, string(output), err)
+	}
+	return nil
+}
+
+// setReservedBlocksPercentage sets the percentage of the ext filesystem blocks reserved for the super-user, tune2fs -m
+// is skipped if the filesystem already reserves the percentage, e.g. when the volume is staged again
+func setReservedBlocksPercentage(devicePath, percentage string, m *mount.SafeFormatAndMount) error {
+	reserved, err := hasReservedBlocksPercentage(devicePath, percentage, m)
+	if err != nil {
+		klog.Warningf("failed to read the reserved block count of %s: %v", devicePath, err)
+	} else if reserved {
+		klog.V(2).Infof("reserved blocks percentage of %s is already %s", devicePath, percentage)
+		return nil
+	}
+	if output, err := m.Exec.Command("tune2fs", "-m", percentage, devicePath).CombinedOutput(); err != nil {
+		return fmt.Errorf("tune2fs -m %s %s failed: output: %s, err: %v", percentage, devicePath, string(output), err)
+	}
+	return nil
+}
+
+// hasReservedBlocksPercentage returns whether the reserved block count of the ext filesystem on devicePath read by
+// tune2fs -l is the one set by tune2fs -m with percentage, i.e. percentage of the block count rounded down
+func hasReservedBlocksPercentage(devicePath, percentage string, m *mount.SafeFormatAndMount) (bool, error) {
+	ratio, err := strconv.ParseFloat(percentage, 64)
+	if err != nil {
+		return false, err
+	}
+	output, err := m.Exec.Command("tune2fs", "-l", devicePath).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("tune2fs -l %s failed: output: %s, err: %v", devicePath, string(output), err)
+	}
+	var blockCount, reservedBlockCount uint64
+	var foundReservedBlockCount bool
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Block count":
+			if blockCount, err = strconv.ParseUint(strings.TrimSpace(value), 10, 64); err != nil {
+				return false, fmt.Errorf("invalid block count %q: %v", value, err)
+			}
+		case "Reserved block count":
+			if reservedBlockCount, err = strconv.ParseUint(strings.TrimSpace(value), 10, 64); err != nil {
+				return false, fmt.Errorf("invalid reserved block count %q: %v", value, err)
+			}
+			foundReservedBlockCount = true
+		}
+	}
+	if blockCount == 0 || !foundReservedBlockCount {
+		return false, fmt.Errorf("block count or reserved block count not found in the output of tune2fs -l %s", devicePath)
+	}
+	return reservedBlockCount == uint64(ratio*float64(blockCount)/100), nil
+}
+
+// isExtFilesystem returns whether the filesystem mounted at path is ext2, ext3 or ext4
+func isExtFilesystem(path string) bool {
+	var statfs unix.Statfs_t
+	return unix.Statfs(path, &statfs) == nil && statfs.Type == unix.EXT4_SUPER_MAGIC
+}
+
+// runCryptsetup runs cryptsetup with args, the key is written to stdin so that it's not in the process arguments
+func runCryptsetup(m *mount.SafeFormatAndMount, key string, args ...string) error {
+	cmd := m.Exec.Command("cryptsetup", args...)
+	if key != "" {
+		cmd.SetStdin(strings.NewReader(key))
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cryptsetup %s failed: output: %s, err: %v", strings.Join(args, " "), string(output), err)
+	}
+	return nil
+}
+
+// setupLUKSDevice opens devicePath as the dm-crypt device mapperName with passphrase and returns the path of the
+// mapper device. An unformatted device is formatted with LUKS2 first, a device formatted with a filesystem is not
+// encrypted. If passphrase does not unlock the device, the key slot of previousPassphrase is changed to passphrase.
+func setupLUKSDevice(devicePath, mapperName, passphrase, previousPassphrase string, m *mount.SafeFormatAndMount) (string, error) {
+	if err := runCryptsetup(m, "", "isLuks", devicePath); err != nil {
+		format, err := m.GetDiskFormat(devicePath)
+		if err != nil {
+			return "", err
+		}
+		if format != "" {
+			return "", fmt.Errorf("%s is formatted as %s, only an unformatted disk could be encrypted", devicePath, format)
+		}
+		klog.V(2).Infof("formatting %s with LUKS2", devicePath)
+		if err := runCryptsetup(m, passphrase, "luksFormat", "--type", "luks2", "--batch-mode", "--key-file=-", devicePath); err != nil {
+			return "", err
+		}
+	} else if err := runCryptsetup(m, passphrase, "luksOpen", "--test-passphrase", "--key-file=-", devicePath); err != nil {
+		if previousPassphrase == "" {
+			return "", fmt.Errorf("passphrase does not unlock %s: %v", devicePath, err)
+		}
+		if err := changeLUKSKey(devicePath, previousPassphrase, passphrase, m); err != nil {
+			return "", err
+		}
+		klog.V(2).Infof("rotated the key of %s", devicePath)
+	}
+
+	mapperPath := getLUKSMapperPath(mapperName)
+	if _, err := os.Stat(mapperPath); err == nil {
+		return mapperPath, nil
+	}
+	if err := runCryptsetup(m, passphrase, "luksOpen", "--key-file=-", devicePath, mapperName); err != nil {
+		return "", err
+	}
+	return mapperPath, nil
+}
+
+// changeLUKSKey changes the key slot of oldPassphrase on devicePath to newPassphrase. Both passphrases are written
+// to stdin, one per line, since cryptsetup reads a passphrase prompt from a non-terminal stdin up to a newline; this
+// way the new passphrase is never written to disk.
+func changeLUKSKey(devicePath, oldPassphrase, newPassphrase string, m *mount.SafeFormatAndMount) error {
+	if strings.Contains(oldPassphrase, "\n") || strings.Contains(newPassphrase, "\n") {
+		return fmt.Errorf("the key of %s cannot be changed to or from a passphrase containing a newline", devicePath)
+	}
+	return runCryptsetup(m, oldPassphrase+"\n"+newPassphrase+"\n", "luksChangeKey", devicePath)
+}
+
+// closeLUKSDevice closes the dm-crypt device mapperName, nothing is done if it's not open
+func closeLUKSDevice(mapperName string, m *mount.SafeFormatAndMount) error {
+	if _, err := os.Stat(getLUKSMapperPath(mapperName)); os.IsNotExist(err) {
+		return nil
+	}
+	return runCryptsetup(m, "", "luksClose", mapperName)
+}
+
+// resizeLUKSDevice resizes the dm-crypt device mapperName to the size of its underlying device
+func resizeLUKSDevice(mapperName, passphrase string, m *mount.SafeFormatAndMount) error {
+	if passphrase == "" {
+		return runCryptsetup(m, "", "resize", mapperName)
+	}
+	return runCryptsetup(m, passphrase, "resize", "--key-file=-", mapperName)
+}
+
+// setVolumeOwnership changes the group of the files under dir to fsGroup and makes them group readable and writable,
+// with OnRootMismatch policy the change is skipped if the ownership and permissions of dir already match
+func setVolumeOwnership(dir string, fsGroup int64, policy string) error {
+	if policy == azureutils.FSGroupChangeNone {
+		return nil
+	}
+	if policy == string(v1.FSGroupChangeOnRootMismatch) {
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return err
+		}
+		if !requiresOwnershipChange(info, fsGroup) {
+			klog.V(2).Infof("skip changing ownership of %s since its root already matches fsGroup(%d)", dir, fsGroup)
+			return nil
+		}
+	}
+
+	klog.V(2).Infof("changing ownership of %s to fsGroup(%d)", dir, fsGroup)
+	return filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		// errors are logged and ignored the same way as kubelet does
+		if err := os.Lchown(path, -1, int(fsGroup)); err != nil {
+			klog.Errorf("failed to change group of %s to %d: %v", path, fsGroup, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		mode := info.Mode() | 0660
+		if info.IsDir() {
+			mode |= os.ModeSetgid | 0110
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			klog.Errorf("failed to change permissions of %s to %v: %v", path, mode, err)
+		}
+		return nil
+	})
+}
+
+// requiresOwnershipChange returns true if the group, setgid bit or group permissions of the volume root do not match fsGroup
+func requiresOwnershipChange(info os.FileInfo, fsGroup int64) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat == nil {
+		return true
+	}
+	if int64(stat.Gid) != fsGroup {
+		return true
+	}
+	mode := info.Mode()
+	return mode&os.ModeSetgid == 0 || mode&0770 != 0770
+}
+
 func (d *DriverCore) GetVolumeStats(_ context.Context, m *mount.SafeFormatAndMount, _, target string, hostutil hostUtil) ([]*csi.VolumeUsage, error) {
 	var volUsages []*csi.VolumeUsage
 	_, err := os.Stat(target)
@@ -302,7 +650,11 @@ func (d *DriverCore) GetVolumeStats(_ context.Context, m *mount.SafeFormatAndMou
 		return volUsages, status.Errorf(codes.NotFound, "failed to determine whether %s is block device: %v", target, err)
 	}
 	if isBlock {
-		bcap, err := getBlockSizeBytes(target, m)
+		bcap, err := getBlockSizeBytesWithIoctl(target)
+		if err != nil {
+			klog.V(4).Infof("failed to get block capacity of %s with ioctl, fall back to blockdev: %v", target, err)
+			bcap, err = getBlockSizeBytes(target, m)
+		}
 		if err != nil {
 			return volUsages, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", target, err)
 		}
@@ -345,6 +697,12 @@ func (d *DriverCore) GetVolumeStats(_ context.Context, m *mount.SafeFormatAndMou
 		return volUsages, status.Errorf(codes.Internal, "failed to transform disk inodes used(%v)", volumeMetrics.InodesUsed)
 	}
 
+	// blocks reserved for the super-user on ext filesystems are not usable by the workload, report the usable capacity
+	// as total. Other filesystems, e.g. btrfs, may not report their metadata in used, their capacity is kept.
+	if usable := used + available; usable < capacity && isExtFilesystem(target) {
+		capacity = usable
+	}
+
 	return []*csi.VolumeUsage{
 		{
 			Unit:      csi.VolumeUsage_BYTES,
@@ -360,3 +718,41 @@ func (d *DriverCore) GetVolumeStats(_ context.Context, m *mount.SafeFormatAndMou
 		},
 	}, nil
 }
+
+// getDiskIOStats reads the IO statistics of the block device from /sys/class/block/<device>/stat,
+// see https://www.kernel.org/doc/Documentation/block/stat.txt
+func getDiskIOStats(devicePath string) (*diskIOStats, error) {
+	device, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filepath.Join(sysClassBlockPath, filepath.Base(device), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	return parseDiskIOStats(string(content))
+}
+
+// parseDiskIOStats parses the content of the stat file of a block device, sectors are always 512 bytes in the stat file
+func parseDiskIOStats(content string) (*diskIOStats, error) {
+	fields := strings.Fields(content)
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("unexpected block device stat %q", content)
+	}
+	values := make([]uint64, 8)
+	for i := range values {
+		v, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse field %d of block device stat %q: %w", i, content, err)
+		}
+		values[i] = v
+	}
+	return &diskIOStats{
+		readsCompleted:   values[0],
+		bytesRead:        values[2] * 512,
+		readTimeSeconds:  float64(values[3]) / 1000,
+		writesCompleted:  values[4],
+		bytesWritten:     values[6] * 512,
+		writeTimeSeconds: float64(values[7]) / 1000,
+	}, nil
+}
@@ -17,9 +17,20 @@ limitations under the License.
 package azuredisk
 
 import (
+	"io"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mount "k8s.io/mount-utils"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 )
 
@@ -32,3 +43,321 @@ func TestRescanAllVolumes(t *testing.T) {
 		t.Errorf("rescanAllVolumes failed with error: %v", err)
 	}
 }
+
+func TestGetFormatOptions(t *testing.T) {
+	assert.Nil(t, getFormatOptions("xfs", 0))
+	assert.Nil(t, getFormatOptions("xfs", 512))
+	assert.Equal(t, []string{"-s", "size=4096"}, getFormatOptions("xfs", 4096))
+	assert.Equal(t, []string{"-b", "4096"}, getFormatOptions("ext4", 4096))
+	assert.Nil(t, getFormatOptions("btrfs", 4096))
+}
+
+func TestGetBlockSizeBytesWithIoctl(t *testing.T) {
+	_, err := getBlockSizeBytesWithIoctl("/not/a/real/device")
+	assert.Error(t, err)
+
+	file := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(file, []byte("data"), 0600))
+	_, err = getBlockSizeBytesWithIoctl(file)
+	assert.Error(t, err)
+}
+
+func TestGetBlockSizeBytesWithIoctlOnBlockDevice(t *testing.T) {
+	// the size of a block device in sysfs is in 512-byte sectors
+	devices, _ := os.ReadDir(sysClassBlockPath)
+	for _, device := range devices {
+		sectors, err := os.ReadFile(filepath.Join(sysClassBlockPath, device.Name(), "size"))
+		if err != nil {
+			continue
+		}
+		size, err := getBlockSizeBytesWithIoctl(filepath.Join("/dev", device.Name()))
+		if err != nil {
+			continue
+		}
+		expected, err := strconv.ParseInt(strings.TrimSpace(string(sectors)), 10, 64)
+		assert.NoError(t, err)
+		assert.Equal(t, expected*512, size, device.Name())
+		return
+	}
+	t.Skip("no readable block device")
+}
+
+func TestSetVolumeOwnership(t *testing.T) {
+	gid := int64(os.Getgid())
+	dir := t.TempDir()
+	subDir := filepath.Join(dir, "sub")
+	file := filepath.Join(subDir, "file")
+	require.NoError(t, os.Mkdir(subDir, 0700))
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0600))
+	require.NoError(t, os.Chmod(dir, 0700))
+
+	assert.NoError(t, setVolumeOwnership(dir, gid, azureutils.FSGroupChangeNone))
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	assert.NoError(t, setVolumeOwnership(dir, gid, "OnRootMismatch"))
+	for _, path := range []string{dir, subDir} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0770), info.Mode().Perm(), path)
+		assert.NotZero(t, info.Mode()&os.ModeSetgid, path)
+		assert.Equal(t, uint32(gid), info.Sys().(*syscall.Stat_t).Gid, path)
+	}
+	info, err = os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+
+	// the root matches fsGroup, the files under it are not changed with OnRootMismatch
+	require.NoError(t, os.Chmod(file, 0600))
+	assert.NoError(t, setVolumeOwnership(dir, gid, "OnRootMismatch"))
+	info, err = os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	assert.NoError(t, setVolumeOwnership(dir, gid, "Always"))
+	info, err = os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+
+	assert.Error(t, setVolumeOwnership(filepath.Join(dir, "notexist"), gid, "OnRootMismatch"))
+}
+
+type sysfsEntry string
+
+func (e sysfsEntry) Name() string               { return string(e) }
+func (e sysfsEntry) IsDir() bool                { return true }
+func (e sysfsEntry) Type() os.FileMode          { return os.ModeDir }
+func (e sysfsEntry) Info() (os.FileInfo, error) { return nil, os.ErrNotExist }
+
+// fakeSysfsIOHandler serves the directories and files of a fake sysfs tree
+type fakeSysfsIOHandler struct {
+	dirs    map[string][]string
+	files   map[string]string
+	written []string
+}
+
+func (h *fakeSysfsIOHandler) ReadDir(dirname string) ([]os.DirEntry, error) {
+	names, ok := h.dirs[filepath.Clean(dirname)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	entries := []os.DirEntry{}
+	for _, name := range names {
+		entries = append(entries, sysfsEntry(name))
+	}
+	return entries, nil
+}
+
+func (h *fakeSysfsIOHandler) WriteFile(filename string, _ []byte, _ os.FileMode) error {
+	h.written = append(h.written, filename)
+	return nil
+}
+
+func (h *fakeSysfsIOHandler) Readlink(name string) (string, error) {
+	return "", os.ErrNotExist
+}
+
+func (h *fakeSysfsIOHandler) ReadFile(filename string) ([]byte, error) {
+	content, ok := h.files[filename]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+func TestFindNVMeDiskByLun(t *testing.T) {
+	io := &fakeSysfsIOHandler{
+		dirs: map[string][]string{
+			"/sys/class/nvme":       {"nvme0", "nvme1"},
+			"/sys/class/nvme/nvme0": {"model", "nvme0n1", "nvme0n2", "nvme0n4", "rescan_controller"},
+			"/sys/class/nvme/nvme1": {"model", "nvme1n1"},
+		},
+		files: map[string]string{
+			"/sys/class/nvme/nvme0/model":        "MSFT NVMe Accelerator v1.0              \n",
+			"/sys/class/nvme/nvme0/nvme0n1/nsid": "1\n",
+			"/sys/class/nvme/nvme0/nvme0n2/nsid": "2\n",
+			"/sys/class/nvme/nvme0/nvme0n4/nsid": "4\n",
+			// local NVMe disks are not managed disks
+			"/sys/class/nvme/nvme1/model":        "Microsoft NVMe Direct Disk              \n",
+			"/sys/class/nvme/nvme1/nvme1n1/nsid": "2\n",
+		},
+	}
+
+	device, err := findNVMeDiskByLun(0, io)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/nvme0n2", device)
+	device, err = findNVMeDiskByLun(2, io)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/nvme0n4", device)
+	_, err = findNVMeDiskByLun(1, io)
+	assert.Error(t, err)
+	_, err = findNVMeDiskByLun(0, &fakeSysfsIOHandler{})
+	assert.Error(t, err)
+
+	// only the remote NVMe controllers are rescanned
+	assert.Equal(t, 1, nvmeControllerRescan(io))
+	assert.Equal(t, []string{"/sys/class/nvme/nvme0/rescan_controller"}, io.written)
+
+	// disks are found on the remote NVMe controller if there is no scsi disk at the lun
+	device, err = findDiskByLun(2, io, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/nvme0n4", device)
+}
+
+func TestGetDiskIdentity(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"sdc", "nvme0n2", "sdd"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "sdc"), filepath.Join(dir, "lun0")))
+	io := &fakeSysfsIOHandler{
+		files: map[string]string{
+			"/sys/class/block/sdc/device/wwid": "naa.60022480a1b2c3d4e5f6a7b8c9d0e1f2\n",
+			"/sys/class/block/nvme0n2/wwid":    "eui.0123456789abcdef\n",
+		},
+	}
+
+	identity, err := getDiskIdentity(filepath.Join(dir, "lun0"), io)
+	assert.NoError(t, err)
+	assert.Equal(t, "naa.60022480a1b2c3d4e5f6a7b8c9d0e1f2", identity)
+	identity, err = getDiskIdentity(filepath.Join(dir, "nvme0n2"), io)
+	assert.NoError(t, err)
+	assert.Equal(t, "eui.0123456789abcdef", identity)
+	_, err = getDiskIdentity(filepath.Join(dir, "sdd"), io)
+	assert.Error(t, err)
+	_, err = getDiskIdentity(filepath.Join(dir, "sde"), io)
+	assert.Error(t, err)
+}
+
+// fakeCommand is a command run by newFakeCommandExec, with the stdin it's run with
+type fakeCommand struct {
+	argv  string
+	stdin string
+}
+
+// newFakeCommandExec returns a mounter running commands with the results of errs in order, the commands are
+// appended to commands
+func newFakeCommandExec(commands *[]fakeCommand, errs ...error) *mount.SafeFormatAndMount {
+	fakeExec := &testingexec.FakeExec{}
+	for _, err := range errs {
+		err := err
+		fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) exec.Cmd {
+			fakeCmd := &testingexec.FakeCmd{}
+			fakeCmd.CombinedOutputScript = []testingexec.FakeAction{func() ([]byte, []byte, error) {
+				command := fakeCommand{argv: strings.Join(append([]string{cmd}, args...), " ")}
+				if fakeCmd.Stdin != nil {
+					stdin, _ := io.ReadAll(fakeCmd.Stdin)
+					command.stdin = string(stdin)
+				}
+				*commands = append(*commands, command)
+				return nil, nil, err
+			}}
+			return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+		})
+	}
+	return &mount.SafeFormatAndMount{Exec: fakeExec}
+}
+
+func TestSetupLUKSDevice(t *testing.T) {
+	luksMapperDir = t.TempDir()
+	defer func() { luksMapperDir = "/dev/mapper" }()
+	failed := &testingexec.FakeExitError{Status: 1}
+	unformatted := &testingexec.FakeExitError{Status: 2}
+
+	// an unformatted device is formatted with LUKS2 and opened
+	var commands []fakeCommand
+	path, err := setupLUKSDevice("/dev/sdc", "mapper", "key", "", newFakeCommandExec(&commands, failed, unformatted, nil, nil))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(luksMapperDir, "mapper"), path)
+	assert.Equal(t, []fakeCommand{
+		{argv: "cryptsetup isLuks /dev/sdc"},
+		{argv: "blkid -p -s TYPE -s PTTYPE -o export /dev/sdc"},
+		{argv: "cryptsetup luksFormat --type luks2 --batch-mode --key-file=- /dev/sdc", stdin: "key"},
+		{argv: "cryptsetup luksOpen --key-file=- /dev/sdc mapper", stdin: "key"},
+	}, commands)
+
+	// the key slot of the previous passphrase is changed, the open device is reused
+	require.NoError(t, os.WriteFile(filepath.Join(luksMapperDir, "mapper"), nil, 0600))
+	commands = nil
+	_, err = setupLUKSDevice("/dev/sdc", "mapper", "new-key", "key", newFakeCommandExec(&commands, nil, failed, nil))
+	require.NoError(t, err)
+	require.Len(t, commands, 3)
+	assert.Equal(t, fakeCommand{argv: "cryptsetup luksOpen --test-passphrase --key-file=- /dev/sdc", stdin: "new-key"}, commands[1])
+	assert.Equal(t, fakeCommand{argv: "cryptsetup luksChangeKey /dev/sdc", stdin: "key\nnew-key\n"}, commands[2])
+
+	// a passphrase with a newline could not be passed over stdin
+	commands = nil
+	_, err = setupLUKSDevice("/dev/sdc", "mapper", "new\nkey", "key", newFakeCommandExec(&commands, nil, failed))
+	assert.Error(t, err)
+	assert.Len(t, commands, 2)
+
+	// a wrong passphrase fails without a previous passphrase
+	commands = nil
+	_, err = setupLUKSDevice("/dev/sdc", "mapper", "wrong", "", newFakeCommandExec(&commands, nil, failed))
+	assert.Error(t, err)
+
+	// a device with a filesystem is not encrypted
+	commands = nil
+	fakeExec := newFakeCommandExec(&commands, failed)
+	fakeExec.Exec.(*testingexec.FakeExec).CommandScript = append(fakeExec.Exec.(*testingexec.FakeExec).CommandScript,
+		func(cmd string, args ...string) exec.Cmd {
+			return testingexec.InitFakeCmd(&testingexec.FakeCmd{CombinedOutputScript: []testingexec.FakeAction{func() ([]byte, []byte, error) {
+				return []byte("DEVNAME=/dev/sdc\nTYPE=ext4\n"), nil, nil
+			}}}, cmd, args...)
+		})
+	_, err = setupLUKSDevice("/dev/sdc", "mapper", "key", "", fakeExec)
+	assert.ErrorContains(t, err, "formatted as ext4")
+}
+
+func TestCloseLUKSDevice(t *testing.T) {
+	luksMapperDir = t.TempDir()
+	defer func() { luksMapperDir = "/dev/mapper" }()
+
+	var commands []fakeCommand
+	assert.NoError(t, closeLUKSDevice("mapper", newFakeCommandExec(&commands)))
+	assert.Empty(t, commands)
+
+	require.NoError(t, os.WriteFile(filepath.Join(luksMapperDir, "mapper"), nil, 0600))
+	assert.NoError(t, closeLUKSDevice("mapper", newFakeCommandExec(&commands, nil)))
+	assert.Equal(t, []fakeCommand{{argv: "cryptsetup luksClose mapper"}}, commands)
+}
+
+func TestHasReservedBlocksPercentage(t *testing.T) {
+	newTune2fsExec := func(commands *[]fakeCommand, output string) *mount.SafeFormatAndMount {
+		fakeExec := newFakeCommandExec(commands)
+		fakeExec.Exec.(*testingexec.FakeExec).CommandScript = append(fakeExec.Exec.(*testingexec.FakeExec).CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				*commands = append(*commands, fakeCommand{argv: strings.Join(append([]string{cmd}, args...), " ")})
+				return testingexec.InitFakeCmd(&testingexec.FakeCmd{CombinedOutputScript: []testingexec.FakeAction{func() ([]byte, []byte, error) {
+					return []byte(output), nil, nil
+				}}}, cmd, args...)
+			})
+		return fakeExec
+	}
+	const output = "Filesystem volume name:   <none>\nBlock count:              262144\nReserved block count:     2621\n"
+
+	var commands []fakeCommand
+	reserved, err := hasReservedBlocksPercentage("/dev/sdc", "1", newTune2fsExec(&commands, output))
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	assert.Equal(t, []fakeCommand{{argv: "tune2fs -l /dev/sdc"}}, commands)
+
+	reserved, err = hasReservedBlocksPercentage("/dev/sdc", "0", newTune2fsExec(&commands, output))
+	require.NoError(t, err)
+	assert.False(t, reserved)
+
+	reserved, err = hasReservedBlocksPercentage("/dev/sdc", "0.5", newTune2fsExec(&commands, "Block count: 262144\nReserved block count: 1310\n"))
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	_, err = hasReservedBlocksPercentage("/dev/sdc", "1", newTune2fsExec(&commands, "Block count: 262144\n"))
+	assert.Error(t, err)
+}
+
+func TestIsExtFilesystem(t *testing.T) {
+	var statfs syscall.Statfs_t
+	require.NoError(t, syscall.Statfs(t.TempDir(), &statfs))
+	assert.Equal(t, statfs.Type == 0xEF53, isExtFilesystem(t.TempDir()))
+	assert.False(t, isExtFilesystem(filepath.Join(t.TempDir(), "not-exist")))
+}
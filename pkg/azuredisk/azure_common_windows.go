@@ -35,24 +35,26 @@ import (
 	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
 )
 
-func formatAndMount(source, target, fstype string, options []string, m *mount.SafeFormatAndMount) error {
+// the logical sector size of the disk is detected by the mounter when it partitions and formats the disk,
+// the allocation unit size is passed to the mounter in the mount options
+func formatAndMount(source, target, fstype string, options []string, _ int, _ []string, m *mount.SafeFormatAndMount) error {
 	if proxy, ok := m.Interface.(mounter.CSIProxyMounter); ok {
 		return proxy.FormatAndMount(source, target, fstype, options)
 	}
 	return fmt.Errorf("could not cast to csi proxy class")
 }
 
-func scsiHostRescan(io azureutils.IOHandler, m *mount.SafeFormatAndMount) {
-	var err error
-	if proxy, ok := m.Interface.(mounter.CSIProxyMounter); ok {
-		err = proxy.Rescan()
-	} else {
+func scsiHostRescan(io azureutils.IOHandler, m *mount.SafeFormatAndMount) error {
+	proxy, ok := m.Interface.(mounter.CSIProxyMounter)
+	if !ok {
 		klog.Errorf("could not cast to csi proxy class")
+		return fmt.Errorf("could not cast to csi proxy class")
 	}
-
-	if err != nil {
+	if err := proxy.Rescan(); err != nil {
 		klog.Errorf("Rescan failed in scsiHostRescan, error: %v", err)
+		return err
 	}
+	return nil
 }
 
 // search Windows disk number by LUN
@@ -84,6 +86,11 @@ func preparePublishPath(path string, m *mount.SafeFormatAndMount) error {
 	return fmt.Errorf("could not cast to csi proxy class")
 }
 
+// getDiskIdentity is not supported on Windows, so the cached publish contexts are never used
+func getDiskIdentity(devicePath string, _ azureutils.IOHandler) (string, error) {
+	return "", fmt.Errorf("identity of disk %s is not supported on Windows", devicePath)
+}
+
 func CleanupMountPoint(path string, m *mount.SafeFormatAndMount, extensiveCheck bool) error {
 	if proxy, ok := m.Interface.(mounter.CSIProxyMounter); ok {
 		return proxy.Unmount(path)
@@ -164,25 +171,59 @@ func rescanAllVolumes(io azureutils.IOHandler) error {
 	return nil
 }
 
-func (d *DriverCore) GetVolumeStats(ctx context.Context, m *mount.SafeFormatAndMount, volumeID, target string, hostutil hostUtil) ([]*csi.VolumeUsage, error) {
+func freezeFilesystem(mountPath string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("filesystem freeze is not supported on this platform")
+}
+
+func thawFilesystem(mountPath string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("filesystem freeze is not supported on this platform")
+}
+
+func setReservedBlocksPercentage(devicePath, percentage string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("reserved blocks percentage is not supported on this platform")
+}
+
+func setupLUKSDevice(devicePath, mapperName, passphrase, previousPassphrase string, m *mount.SafeFormatAndMount) (string, error) {
+	return "", fmt.Errorf("host encryption is not supported on this platform")
+}
+
+func closeLUKSDevice(mapperName string, m *mount.SafeFormatAndMount) error {
+	return nil
+}
+
+func resizeLUKSDevice(mapperName, passphrase string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("host encryption is not supported on this platform")
+}
+
+func setVolumeOwnership(dir string, fsGroup int64, policy string) error {
+	klog.V(2).Infof("skip changing ownership of %s to fsGroup(%d) since fsGroup is not supported on this platform", dir, fsGroup)
+	return nil
+}
+
+// GetVolumeStats returns the stats of the volume through csi-proxy. Block volumes are not published as devices on
+// Windows, so the target is always the path of a filesystem volume.
+func (d *DriverCore) GetVolumeStats(ctx context.Context, m *mount.SafeFormatAndMount, volumeID, target string, _ hostUtil) ([]*csi.VolumeUsage, error) {
 	// check if the volume stats is cached
 	cache, err := d.volStatsCache.Get(ctx, volumeID, azcache.CacheReadTypeDefault)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, err.Error())
 	}
 	if cache != nil {
-		volUsage := cache.(csi.VolumeUsage)
 		klog.V(6).Infof("NodeGetVolumeStats: volume stats for volume %s path %s is cached", volumeID, target)
-		return []*csi.VolumeUsage{&volUsage}, nil
+		return []*csi.VolumeUsage{cache.(*csi.VolumeUsage)}, nil
 	}
 
 	if proxy, ok := m.Interface.(mounter.CSIProxyMounter); ok {
 		volUsage, err := proxy.GetVolumeStats(ctx, target)
 		if err == nil && volUsage != nil {
 			// cache the volume stats per volume
-			d.volStatsCache.Set(volumeID, *volUsage)
+			d.volStatsCache.Set(volumeID, volUsage)
 		}
 		return []*csi.VolumeUsage{volUsage}, err
 	}
 	return []*csi.VolumeUsage{}, fmt.Errorf("could not cast to csi proxy class")
 }
+
+func getDiskIOStats(devicePath string) (*diskIOStats, error) {
+	return nil, fmt.Errorf("IO statistics of %s are not supported on this platform", devicePath)
+}
@@ -32,8 +32,10 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
 
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	kwait "k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 	volerr "k8s.io/cloud-provider/volume/errors"
 	"k8s.io/klog/v2"
@@ -60,6 +62,13 @@ const (
 	attachDiskMapKeySuffix = "attachdiskmap"
 	detachDiskMapKeySuffix = "detachdiskmap"
 
+	// reason of the node event recorded when a detach is escalated to a force detach
+	forceDetachEscalatedReason = "ForceDetachEscalated"
+
+	// provisioning states of a VM, another update of the VM is rejected with 409 Conflict while it's updating
+	vmProvisioningStateUpdating = "Updating"
+	vmProvisioningStateFailed   = "Failed"
+
 	// default initial delay in milliseconds for batch disk attach/detach
 	defaultAttachDetachInitialDelayInMs = 1000
 
@@ -67,6 +76,10 @@ const (
 	// https://docs.microsoft.com/azure/virtual-machines/windows/how-to-enable-write-accelerator
 	WriteAcceleratorEnabled = "writeacceleratorenabled"
 
+	// CachingModeTag is the disk tag overriding the cachingMode in the volume context of the PV,
+	// it's set by ControllerModifyVolume and applied on the next attach of the disk
+	CachingModeTag = "k8s-azure-caching-mode"
+
 	// see https://docs.microsoft.com/en-us/rest/api/compute/disks/createorupdate#create-a-managed-disk-by-copying-a-snapshot.
 	diskSnapshotPath = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/snapshots/%s"
 
@@ -74,6 +87,9 @@ const (
 	managedDiskPath = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/%s"
 )
 
+// interval to refresh the provisioning state of a VM before the force detach
+var vmUpdatePollInterval = 5 * time.Second
+
 var defaultBackOff = kwait.Backoff{
 	Steps:    20,
 	Duration: 2 * time.Second,
@@ -81,14 +97,25 @@ var defaultBackOff = kwait.Backoff{
 	Jitter:   0.0,
 }
 
+// systemCriticalRetryBackoff is the backoff to retry a failed attach/detach of a system-critical volume
+// within the same CSI call instead of waiting for the next retry of external-attacher
+var systemCriticalRetryBackoff = kwait.Backoff{
+	Steps:    3,
+	Duration: 2 * time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+}
+
 var (
 	managedDiskPathRE  = regexp.MustCompile(`.*/subscriptions/(?:.*)/resourceGroups/(?:.*)/providers/Microsoft.Compute/disks/(.+)`)
 	diskSnapshotPathRE = regexp.MustCompile(`.*/subscriptions/(?:.*)/resourceGroups/(?:.*)/providers/Microsoft.Compute/snapshots/(.+)`)
 )
 
 type controllerCommon struct {
-	diskStateMap  sync.Map // <diskURI, attaching/detaching state>
-	lockMap       *lockMap
+	diskStateMap sync.Map // <diskURI, attaching/detaching state>
+	lockMap      *lockMap
+	// protects cloud and clientFactory, which are replaced when the cloud config is reloaded
+	cloudLock     sync.RWMutex
 	cloud         *provider.Cloud
 	clientFactory azclient.ClientFactory
 	// disk queue that is waiting for attach or detach on specific node
@@ -102,6 +129,95 @@ type controllerCommon struct {
 	// AttachDetachInitialDelayInMs determines initial delay in milliseconds for batch disk attach/detach
 	AttachDetachInitialDelayInMs int
 	ForceDetachBackoff           bool
+	// ForceDetachTimeoutInSeconds escalates a detach blocked on the VM update to a force detach, 0 disables the escalation
+	ForceDetachTimeoutInSeconds int64
+	// eventRecorder records the force detach escalations on the node, could be nil
+	eventRecorder record.EventRecorder
+	// diagnosticSettingsClient is created from the cloud credential if nil
+	diagnosticSettingsClient diagnosticSettingsClient
+}
+
+// getCloud returns the cloud of the disk controller
+func (c *controllerCommon) getCloud() *provider.Cloud {
+	c.cloudLock.RLock()
+	defer c.cloudLock.RUnlock()
+	return c.cloud
+}
+
+// getClientFactory returns the client factory of the cloud of the disk controller
+func (c *controllerCommon) getClientFactory() azclient.ClientFactory {
+	c.cloudLock.RLock()
+	defer c.cloudLock.RUnlock()
+	return c.clientFactory
+}
+
+// setCloud replaces the cloud and its client factory, the VM locks and the attach/detach requests waiting
+// for a batch are kept, the operations in progress keep using the previous cloud
+func (c *controllerCommon) setCloud(cloud *provider.Cloud) {
+	c.cloudLock.Lock()
+	defer c.cloudLock.Unlock()
+	c.cloud = cloud
+	c.clientFactory = cloud.ComputeClientFactory
+}
+
+// systemCriticalOperationKey is the context key marking an attach/detach operation of a system-critical volume
+type systemCriticalOperationKey struct{}
+
+// withSystemCriticalOperation marks the attach/detach operation in ctx as system-critical, which is not delayed to
+// batch with other requests on the same node and whose batch is the next to update the VM of the node
+func withSystemCriticalOperation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemCriticalOperationKey{}, true)
+}
+
+// isSystemCriticalOperation returns true if the attach/detach operation in ctx is system-critical
+func isSystemCriticalOperation(ctx context.Context) bool {
+	v, ok := ctx.Value(systemCriticalOperationKey{}).(bool)
+	return ok && v
+}
+
+// lockNode acquires the lock of the VM updates of node, a system-critical operation in ctx acquires it before the
+// attach/detach batches which are waiting for the VM update in progress
+func (c *controllerCommon) lockNode(ctx context.Context, node string) {
+	if isSystemCriticalOperation(ctx) {
+		c.lockMap.LockEntryWithPriority(node)
+		return
+	}
+	c.lockMap.LockEntry(node)
+}
+
+// attachDetachInitialDelayKey is the context key of the initial delay in milliseconds of an attach/detach operation
+type attachDetachInitialDelayKey struct{}
+
+// withAttachDetachInitialDelay sets the initial delay in milliseconds to batch the attach/detach operation in ctx with
+// other requests on the same node, it overrides AttachDetachInitialDelayInMs of the disk controller for this operation only
+func withAttachDetachInitialDelay(ctx context.Context, delayInMs int) context.Context {
+	return context.WithValue(ctx, attachDetachInitialDelayKey{}, delayInMs)
+}
+
+// getAttachDetachInitialDelayInMs returns the initial delay in milliseconds of the attach/detach operation in ctx,
+// AttachDetachInitialDelayInMs of the disk controller if it's not set in ctx
+func (c *controllerCommon) getAttachDetachInitialDelayInMs(ctx context.Context) int {
+	if v, ok := ctx.Value(attachDetachInitialDelayKey{}).(int); ok {
+		return v
+	}
+	return c.AttachDetachInitialDelayInMs
+}
+
+// retrySystemCriticalOperation retries fn with systemCriticalRetryBackoff until it succeeds,
+// the last error of fn is returned if all the retries failed
+func retrySystemCriticalOperation(ctx context.Context, operation string, fn func() error) error {
+	var lastErr error
+	err := kwait.ExponentialBackoffWithContext(ctx, systemCriticalRetryBackoff, func(_ context.Context) (bool, error) {
+		if lastErr = fn(); lastErr != nil {
+			klog.Warningf("%s of system-critical volume failed with %v, retrying", operation, lastErr)
+			return false, nil
+		}
+		return true, nil
+	})
+	if lastErr != nil {
+		return lastErr
+	}
+	return err
 }
 
 // ExtendedLocation contains additional info about the location of resources.
@@ -116,7 +232,10 @@ type ExtendedLocation struct {
 // occupiedLuns is used to avoid conflict with other disk attach in k8s VolumeAttachments
 // return (lun, error)
 func (c *controllerCommon) AttachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName,
-	cachingMode armcompute.CachingTypes, disk *armcompute.Disk, occupiedLuns []int) (int32, error) {
+	cachingMode armcompute.CachingTypes, disk *armcompute.Disk, occupiedLuns []int) (_ int32, err error) {
+	ctx, span := startARMSpan(ctx, "AttachDisk", diskURIAttribute.String(diskURI), nodeNameAttribute.String(string(nodeName)))
+	defer func() { endSpan(span, err) }()
+
 	diskEncryptionSetID := ""
 	writeAcceleratorEnabled := false
 
@@ -124,7 +243,7 @@ func (c *controllerCommon) AttachDisk(ctx context.Context, diskName, diskURI str
 	// don't check disk state when GetDisk is throttled
 	if disk != nil {
 		if disk.ManagedBy != nil && (disk.Properties == nil || disk.Properties.MaxShares == nil || *disk.Properties.MaxShares <= 1) {
-			vmset, err := c.cloud.GetNodeVMSet(ctx, nodeName, azcache.CacheReadTypeUnsafe)
+			vmset, err := c.getCloud().GetNodeVMSet(ctx, nodeName, azcache.CacheReadTypeUnsafe)
 			if err != nil {
 				return -1, err
 			}
@@ -149,6 +268,15 @@ func (c *controllerCommon) AttachDisk(ctx context.Context, diskName, diskURI str
 			return -1, volerr.NewDanglingError(attachErr, attachedNode, "")
 		}
 
+		if v, ok := disk.Tags[CachingModeTag]; ok && v != nil {
+			if mode, err := azureutils.NormalizeCachingMode(v1.AzureDataDiskCachingMode(*v)); err != nil {
+				klog.Warningf("ignore invalid tag %s(%s) of disk(%s): %v", CachingModeTag, *v, diskURI, err)
+			} else if !strings.EqualFold(string(mode), string(cachingMode)) {
+				klog.V(2).Infof("cachingMode of disk(%s) is modified from %s to %s", diskURI, cachingMode, mode)
+				cachingMode = armcompute.CachingTypes(mode)
+			}
+		}
+
 		if disk.Properties != nil {
 			if disk.Properties.DiskSizeGB != nil && *disk.Properties.DiskSizeGB >= diskCachingLimit && cachingMode != armcompute.CachingTypesNone {
 				// Disk Caching is not supported for disks 4 TiB and larger
@@ -193,7 +321,7 @@ func (c *controllerCommon) AttachDisk(ctx context.Context, diskName, diskURI str
 		return -1, err
 	}
 
-	c.lockMap.LockEntry(node)
+	c.lockNode(ctx, node)
 	unlock := false
 	defer func() {
 		if !unlock {
@@ -201,9 +329,13 @@ func (c *controllerCommon) AttachDisk(ctx context.Context, diskName, diskURI str
 		}
 	}()
 
-	if c.AttachDetachInitialDelayInMs > 0 && requestNum == 1 {
-		klog.V(2).Infof("wait %dms for more requests on node %s, current disk attach: %s", c.AttachDetachInitialDelayInMs, node, diskURI)
-		time.Sleep(time.Duration(c.AttachDetachInitialDelayInMs) * time.Millisecond)
+	if initialDelayInMs := c.getAttachDetachInitialDelayInMs(ctx); initialDelayInMs > 0 && requestNum == 1 {
+		if isSystemCriticalOperation(ctx) {
+			klog.V(2).Infof("skip waiting for more requests on node %s, current disk attach %s is system-critical", node, diskURI)
+		} else {
+			klog.V(2).Infof("wait %dms for more requests on node %s, current disk attach: %s", initialDelayInMs, node, diskURI)
+			time.Sleep(time.Duration(initialDelayInMs) * time.Millisecond)
+		}
 	}
 
 	diskMap, err := c.cleanAttachDiskRequests(node)
@@ -229,7 +361,7 @@ func (c *controllerCommon) AttachDisk(ctx context.Context, diskName, diskURI str
 		return lun, nil
 	}
 
-	vmset, err := c.cloud.GetNodeVMSet(ctx, nodeName, azcache.CacheReadTypeUnsafe)
+	vmset, err := c.getCloud().GetNodeVMSet(ctx, nodeName, azcache.CacheReadTypeUnsafe)
 	if err != nil {
 		return -1, err
 	}
@@ -310,8 +442,11 @@ func (c *controllerCommon) cleanAttachDiskRequests(nodeName string) (map[string]
 }
 
 // DetachDisk detaches a disk from VM
-func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName) error {
-	if _, err := c.cloud.InstanceID(ctx, nodeName); err != nil {
+func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName) (err error) {
+	ctx, span := startARMSpan(ctx, "DetachDisk", diskURIAttribute.String(diskURI), nodeNameAttribute.String(string(nodeName)))
+	defer func() { endSpan(span, err) }()
+
+	if _, err := c.getCloud().InstanceID(ctx, nodeName); err != nil {
 		if errors.Is(err, cloudprovider.InstanceNotFound) {
 			// if host doesn't exist, no need to detach
 			klog.Warningf("azureDisk - failed to get azure instance id(%s), DetachDisk(%s) will assume disk is already detached",
@@ -322,7 +457,7 @@ func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI str
 		return fmt.Errorf("failed to get azure instance id for node %q: %w", nodeName, err)
 	}
 
-	vmset, err := c.cloud.GetNodeVMSet(ctx, nodeName, azcache.CacheReadTypeUnsafe)
+	vmset, err := c.getCloud().GetNodeVMSet(ctx, nodeName, azcache.CacheReadTypeUnsafe)
 	if err != nil {
 		return err
 	}
@@ -334,12 +469,16 @@ func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI str
 		return err
 	}
 
-	c.lockMap.LockEntry(node)
+	c.lockNode(ctx, node)
 	defer c.lockMap.UnlockEntry(node)
 
-	if c.AttachDetachInitialDelayInMs > 0 && requestNum == 1 {
-		klog.V(2).Infof("wait %dms for more requests on node %s, current disk detach: %s", c.AttachDetachInitialDelayInMs, node, diskURI)
-		time.Sleep(time.Duration(c.AttachDetachInitialDelayInMs) * time.Millisecond)
+	if initialDelayInMs := c.getAttachDetachInitialDelayInMs(ctx); initialDelayInMs > 0 && requestNum == 1 {
+		if isSystemCriticalOperation(ctx) {
+			klog.V(2).Infof("skip waiting for more requests on node %s, current disk detach %s is system-critical", node, diskURI)
+		} else {
+			klog.V(2).Infof("wait %dms for more requests on node %s, current disk detach: %s", initialDelayInMs, node, diskURI)
+			time.Sleep(time.Duration(initialDelayInMs) * time.Millisecond)
+		}
 	}
 	diskMap, err := c.cleanDetachDiskRequests(node)
 	if err != nil {
@@ -350,16 +489,35 @@ func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI str
 	if len(diskMap) > 0 {
 		c.diskStateMap.Store(disk, "detaching")
 		defer c.diskStateMap.Delete(disk)
-		if err = vmset.DetachDisk(ctx, nodeName, diskMap, false); err != nil {
+		detachCtx, cancel := withOperationTimeout(ctx, c.ForceDetachTimeoutInSeconds)
+		err = vmset.DetachDisk(detachCtx, nodeName, diskMap, false)
+		// the detach is escalated only if it's bounded by the force detach timeout rather than the caller's deadline
+		detachTimedOut := errors.Is(detachCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+		if err != nil {
 			if isInstanceNotFoundError(err) {
 				// if host doesn't exist, no need to detach
 				klog.Warningf("azureDisk - got InstanceNotFoundError(%v), DetachDisk(%s) will assume disk is already detached",
 					err, diskURI)
 				return nil
 			}
-			if c.ForceDetachBackoff && !azureutils.IsThrottlingError(err) {
+			// the power state is only got once the detach failed, the detach from a running VM usually succeeds
+			powerState := getVMPowerState(ctx, vmset, nodeName)
+			if isVMPowerStateTransitioning(powerState) {
+				// VM updates are rejected until the VM is stopped or deallocated, the retry of the detach succeeds then
+				err = fmt.Errorf("could not detach disk(%s) from node(%s) while the VM is %s, retry after the VM is stopped or deallocated: %w", diskURI, nodeName, powerState, err)
+			} else if reason := c.getForceDetachEscalationReason(ctx, vmset, nodeName, powerState, detachTimedOut); reason != "" {
+				klog.Errorf("azureDisk - DetachDisk(%s) from node %s %s: %v, escalate to force detach", diskURI, nodeName, reason, err)
+				c.recordForceDetachEscalation(nodeName, diskURI, reason, err)
+				// the VM update of the regular detach is still running in ARM after the timeout
+				if err = waitForVMUpdateCompletion(ctx, vmset, nodeName); err == nil {
+					err = vmset.DetachDisk(ctx, nodeName, diskMap, true)
+				}
+			} else if c.ForceDetachBackoff && !azureutils.IsThrottlingError(err) {
 				klog.Errorf("azureDisk - DetachDisk(%s) from node %s failed with error: %v, retry with force detach", diskURI, nodeName, err)
 				err = vmset.DetachDisk(ctx, nodeName, diskMap, true)
+			} else if isVMPowerStateStopped(powerState) {
+				err = fmt.Errorf("detach disk(%s) from node(%s) in %s power state failed: %w", diskURI, nodeName, powerState, err)
 			}
 		}
 	}
@@ -381,9 +539,86 @@ func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI str
 	return nil
 }
 
+// getForceDetachEscalationReason returns why a failed detach is escalated to a force detach, the detach is escalated
+// if it did not complete within the force detach timeout, the VM is stopped or deallocated, or the VM is in Failed
+// provisioning state, an empty reason is returned otherwise or if the escalation is disabled
+func (c *controllerCommon) getForceDetachEscalationReason(ctx context.Context, vmset provider.VMSet, nodeName types.NodeName, powerState string, detachTimedOut bool) string {
+	if c.ForceDetachTimeoutInSeconds <= 0 {
+		return ""
+	}
+	if detachTimedOut {
+		return fmt.Sprintf("did not complete within %ds", c.ForceDetachTimeoutInSeconds)
+	}
+	if isVMPowerStateStopped(powerState) {
+		// there is no guest OS holding the disk on a stopped VM
+		return fmt.Sprintf("failed on the VM in %s power state", powerState)
+	}
+	_, provisioningState, err := vmset.GetDataDisks(ctx, nodeName, azcache.CacheReadTypeForceRefresh)
+	if err != nil {
+		klog.Warningf("azureDisk - failed to get provisioning state of node %s: %v", nodeName, err)
+		return ""
+	}
+	if strings.EqualFold(ptr.Deref(provisioningState, ""), vmProvisioningStateFailed) {
+		return "failed on the VM in Failed provisioning state"
+	}
+	return ""
+}
+
+// waitForVMUpdateCompletion waits until the VM is not in Updating provisioning state, a VM update sent while the
+// previous one is still running is rejected with 409 Conflict
+func waitForVMUpdateCompletion(ctx context.Context, vmset provider.VMSet, nodeName types.NodeName) error {
+	err := kwait.PollUntilContextCancel(ctx, vmUpdatePollInterval, true, func(ctx context.Context) (bool, error) {
+		_, provisioningState, err := vmset.GetDataDisks(ctx, nodeName, azcache.CacheReadTypeForceRefresh)
+		if err != nil {
+			klog.Warningf("azureDisk - failed to get provisioning state of node %s: %v", nodeName, err)
+			return false, nil
+		}
+		if strings.EqualFold(ptr.Deref(provisioningState, ""), vmProvisioningStateUpdating) {
+			klog.V(2).Infof("azureDisk - wait for the VM update of node %s to complete before force detach", nodeName)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("the VM update of node %s did not complete before force detach: %w", nodeName, err)
+	}
+	return nil
+}
+
+// recordForceDetachEscalation records a warning event on the node describing why the detach of diskURI was escalated to a force detach
+func (c *controllerCommon) recordForceDetachEscalation(nodeName types.NodeName, diskURI, reason string, detachErr error) {
+	if c.eventRecorder == nil {
+		return
+	}
+	// the UID of the node is unknown here, the event is still found by the name of the node
+	ref := &v1.ObjectReference{Kind: "Node", Name: string(nodeName)}
+	c.eventRecorder.Eventf(ref, v1.EventTypeWarning, forceDetachEscalatedReason,
+		"detach of disk %s %s (%v), escalated to force detach", diskURI, reason, detachErr)
+}
+
+// getVMPowerState returns the power state of the VM of the node, VMPowerStateUnknown if it could not be got
+func getVMPowerState(ctx context.Context, vmset provider.VMSet, nodeName types.NodeName) string {
+	powerState, err := vmset.GetPowerStatusByNodeName(ctx, string(nodeName))
+	if err != nil {
+		klog.Warningf("azureDisk - failed to get power state of node %s: %v", nodeName, err)
+		return consts.VMPowerStateUnknown
+	}
+	return powerState
+}
+
+// isVMPowerStateTransitioning returns whether the VM is being stopped or deallocated
+func isVMPowerStateTransitioning(powerState string) bool {
+	return strings.EqualFold(powerState, consts.VMPowerStateStopping) || strings.EqualFold(powerState, consts.VMPowerStateDeallocating)
+}
+
+// isVMPowerStateStopped returns whether the VM is stopped(allocated) or deallocated, the guest OS is not running in both states
+func isVMPowerStateStopped(powerState string) bool {
+	return strings.EqualFold(powerState, consts.VMPowerStateStopped) || strings.EqualFold(powerState, consts.VMPowerStateDeallocated)
+}
+
 // UpdateVM updates a vm
 func (c *controllerCommon) UpdateVM(ctx context.Context, nodeName types.NodeName) error {
-	vmset, err := c.cloud.GetNodeVMSet(ctx, nodeName, azcache.CacheReadTypeUnsafe)
+	vmset, err := c.getCloud().GetNodeVMSet(ctx, nodeName, azcache.CacheReadTypeUnsafe)
 	if err != nil {
 		return err
 	}
@@ -446,7 +681,7 @@ func (c *controllerCommon) cleanDetachDiskRequests(nodeName string) (map[string]
 
 // GetNodeDataDisks invokes vmSet interfaces to get data disks for the node.
 func (c *controllerCommon) GetNodeDataDisks(nodeName types.NodeName, crt azcache.AzureCacheReadType) ([]*armcompute.DataDisk, *string, error) {
-	vmset, err := c.cloud.GetNodeVMSet(context.Background(), nodeName, crt)
+	vmset, err := c.getCloud().GetNodeVMSet(context.Background(), nodeName, crt)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -619,7 +854,7 @@ func (c *controllerCommon) checkDiskExists(ctx context.Context, diskURI string)
 		return false, err
 	}
 
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return false, err
 	}
@@ -638,6 +873,27 @@ func IsOperationPreempted(err error) bool {
 }
 
 func getValidCreationData(subscriptionID, resourceGroup string, options *ManagedDiskOptions) (armcompute.CreationData, error) {
+	if options.SourceResourceID == "" && options.SourceURI != "" {
+		creationData := armcompute.CreationData{
+			CreateOption:    to.Ptr(armcompute.DiskCreateOptionImport),
+			SourceURI:       to.Ptr(options.SourceURI),
+			PerformancePlus: options.PerformancePlus,
+		}
+		if options.StorageAccountID != "" {
+			creationData.StorageAccountID = to.Ptr(options.StorageAccountID)
+		}
+		return creationData, nil
+	}
+	if options.SourceResourceID == "" && options.GalleryImageReferenceID != "" {
+		return armcompute.CreationData{
+			CreateOption: to.Ptr(armcompute.DiskCreateOptionFromImage),
+			GalleryImageReference: &armcompute.ImageDiskReference{
+				ID:  to.Ptr(options.GalleryImageReferenceID),
+				Lun: options.GalleryImageLun,
+			},
+			PerformancePlus: options.PerformancePlus,
+		}, nil
+	}
 	if options.SourceResourceID == "" {
 		return armcompute.CreationData{
 			CreateOption:    to.Ptr(armcompute.DiskCreateOptionEmpty),
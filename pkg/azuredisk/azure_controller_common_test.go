@@ -27,6 +27,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -40,6 +41,7 @@ import (
 	"go.uber.org/mock/gomock"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/utils/ptr"
 
@@ -175,6 +177,32 @@ func TestCommonAttachDisk(t *testing.T) {
 			expectErr:   false,
 			statusCode:  200,
 		},
+		{
+			desc:     "cachingMode in disk tags overrides the requested cachingMode",
+			vmList:   map[string]string{"vm1": "PowerState/Running"},
+			nodeName: "vm1",
+			diskName: "disk-name",
+			existedDisk: &armcompute.Disk{Name: ptr.To("disk-name"),
+				Properties: &armcompute.DiskProperties{DiskState: to.Ptr(armcompute.DiskStateUnattached)},
+				Tags:       map[string]*string{CachingModeTag: ptr.To("ReadWrite")}},
+			setup: func(testCloud *provider.Cloud, expectedVMs []compute.VirtualMachine, statusCode int, result *retry.Error) {
+				initVM(testCloud, expectedVMs)
+				mockVMsClient := testCloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+				mockVMsClient.EXPECT().UpdateAsync(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, rg, nodeName string, parameters compute.VirtualMachineUpdate, source string) (*azure.Future, *retry.Error) {
+						for _, dataDisk := range *parameters.StorageProfile.DataDisks {
+							if strings.EqualFold(ptr.Deref(dataDisk.Name, ""), "disk-name") {
+								assert.Equal(t, compute.CachingTypesReadWrite, dataDisk.Caching)
+							}
+						}
+						return fakeUpdateAsync(statusCode)(ctx, rg, nodeName, parameters, source)
+					}).Times(1)
+				mockVMsClient.EXPECT().WaitForUpdateResult(gomock.Any(), gomock.Any(), testCloud.ResourceGroup, gomock.Any()).Return(nil, result).MaxTimes(1)
+			},
+			expectedLun: 3,
+			expectErr:   false,
+			statusCode:  200,
+		},
 		{
 			desc:     "an error shall be returned if disk state is not Unattached",
 			vmList:   map[string]string{"vm1": "PowerState/Running"},
@@ -275,11 +303,12 @@ func TestCommonDetachDisk(t *testing.T) {
 	defer cancel()
 
 	testCases := []struct {
-		desc        string
-		vmList      map[string]string
-		nodeName    types.NodeName
-		diskName    string
-		expectedErr bool
+		desc           string
+		vmList         map[string]string
+		nodeName       types.NodeName
+		diskName       string
+		expectedErr    bool
+		expectedErrMsg string
 	}{
 		{
 			desc:        "error should not be returned if there's no such instance corresponding to given nodeName",
@@ -300,6 +329,21 @@ func TestCommonDetachDisk(t *testing.T) {
 			diskName:    "disk1",
 			expectedErr: true,
 		},
+		{
+			desc:        "no error shall be returned if the VM is stopped",
+			vmList:      map[string]string{"vm1": "PowerState/Stopped"},
+			nodeName:    "vm1",
+			diskName:    "diskx",
+			expectedErr: false,
+		},
+		{
+			desc:           "error shall be returned with the power state if the detach failed on the deallocating VM",
+			vmList:         map[string]string{"vm1": "PowerState/Deallocating"},
+			nodeName:       "vm1",
+			diskName:       "disk1",
+			expectedErr:    true,
+			expectedErrMsg: "while the VM is Deallocating",
+		},
 	}
 
 	for i, test := range testCases {
@@ -318,10 +362,231 @@ func TestCommonDetachDisk(t *testing.T) {
 		if len(expectedVMs) == 0 {
 			mockVMsClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any()).Return(compute.VirtualMachine{}, &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: cloudprovider.InstanceNotFound}).AnyTimes()
 		}
-		mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+		if test.expectedErrMsg == "" {
+			mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+		} else {
+			mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(nil, &retry.Error{HTTPStatusCode: http.StatusConflict, RawError: fmt.Errorf("operation not allowed")}).AnyTimes()
+		}
 
 		err := common.DetachDisk(ctx, test.diskName, diskURI, test.nodeName)
 		assert.Equal(t, test.expectedErr, err != nil, "TestCase[%d]: %s, err: %v", i, test.desc, err)
+		if test.expectedErrMsg != "" {
+			assert.ErrorContains(t, err, test.expectedErrMsg, "TestCase[%d]: %s", i, test.desc)
+		}
+	}
+}
+
+func TestCommonDetachDiskForceDetachEscalation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCloud := provider.GetTestCloud(ctrl)
+	recorder := record.NewFakeRecorder(10)
+	common := &controllerCommon{
+		cloud:                       testCloud,
+		lockMap:                     newLockMap(),
+		DisableDiskLunCheck:         true,
+		ForceDetachTimeoutInSeconds: 1,
+		eventRecorder:               recorder,
+	}
+	diskURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/disk1",
+		testCloud.SubscriptionID, testCloud.ResourceGroup)
+	expectedVMs := setTestVirtualMachines(testCloud, map[string]string{"vm1": "PowerState/Running"}, false)
+	mockVMsClient := testCloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, *expectedVMs[0].Name, gomock.Any()).Return(expectedVMs[0], nil).AnyTimes()
+	gomock.InOrder(
+		// the regular detach is blocked until the force detach timeout
+		mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, _, _ string, _ compute.VirtualMachineUpdate, _ string) (*compute.VirtualMachine, *retry.Error) {
+				<-ctx.Done()
+				return nil, retry.NewError(false, ctx.Err())
+			}),
+		mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ context.Context, _, _ string, parameters compute.VirtualMachineUpdate, _ string) (*compute.VirtualMachine, *retry.Error) {
+				for _, disk := range *parameters.StorageProfile.DataDisks {
+					if ptr.Deref(disk.ToBeDetached, false) {
+						assert.Equal(t, compute.ForceDetach, disk.DetachOption)
+					}
+				}
+				return nil, nil
+			}),
+	)
+
+	err := common.DetachDisk(context.Background(), "disk1", diskURI, "vm1")
+	assert.NoError(t, err)
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, forceDetachEscalatedReason)
+		assert.Contains(t, event, diskURI)
+	default:
+		t.Errorf("expected a %s event", forceDetachEscalatedReason)
+	}
+}
+
+func TestCommonDetachDiskForceDetachAfterVMUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	defer func(interval time.Duration) { vmUpdatePollInterval = interval }(vmUpdatePollInterval)
+	vmUpdatePollInterval = 10 * time.Millisecond
+
+	testCloud := provider.GetTestCloud(ctrl)
+	common := &controllerCommon{
+		cloud:                       testCloud,
+		lockMap:                     newLockMap(),
+		DisableDiskLunCheck:         true,
+		ForceDetachTimeoutInSeconds: 1,
+	}
+	diskURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/disk1",
+		testCloud.SubscriptionID, testCloud.ResourceGroup)
+	expectedVMs := setTestVirtualMachines(testCloud, map[string]string{"vm1": "PowerState/Running"}, false)
+	var mu sync.Mutex
+	provisioningState := string(consts.ProvisioningStateSucceeded)
+	updatingPolls := 0
+	mockVMsClient := testCloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, *expectedVMs[0].Name, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ string, _ compute.InstanceViewTypes) (compute.VirtualMachine, *retry.Error) {
+			mu.Lock()
+			defer mu.Unlock()
+			vm := expectedVMs[0]
+			properties := *vm.VirtualMachineProperties
+			properties.ProvisioningState = ptr.To(provisioningState)
+			vm.VirtualMachineProperties = &properties
+			if provisioningState == vmProvisioningStateUpdating {
+				// the VM update of the regular detach completes after it's polled once
+				updatingPolls++
+				provisioningState = string(consts.ProvisioningStateSucceeded)
+			}
+			return vm, nil
+		}).AnyTimes()
+	gomock.InOrder(
+		mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, _, _ string, _ compute.VirtualMachineUpdate, _ string) (*compute.VirtualMachine, *retry.Error) {
+				mu.Lock()
+				provisioningState = vmProvisioningStateUpdating
+				mu.Unlock()
+				<-ctx.Done()
+				return nil, retry.NewError(false, ctx.Err())
+			}),
+		mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ context.Context, _, _ string, _ compute.VirtualMachineUpdate, _ string) (*compute.VirtualMachine, *retry.Error) {
+				mu.Lock()
+				defer mu.Unlock()
+				assert.Equal(t, 1, updatingPolls, "force detach is sent before the VM update completes")
+				return nil, nil
+			}),
+	)
+
+	err := common.DetachDisk(context.Background(), "disk1", diskURI, "vm1")
+	assert.NoError(t, err)
+}
+
+func TestCommonDetachDiskForceDetachOnFailedVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCloud := provider.GetTestCloud(ctrl)
+	recorder := record.NewFakeRecorder(10)
+	common := &controllerCommon{
+		cloud:                       testCloud,
+		lockMap:                     newLockMap(),
+		DisableDiskLunCheck:         true,
+		ForceDetachTimeoutInSeconds: 120,
+		eventRecorder:               recorder,
+	}
+	diskURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/disk1",
+		testCloud.SubscriptionID, testCloud.ResourceGroup)
+	expectedVMs := setTestVirtualMachines(testCloud, map[string]string{"vm1": "PowerState/Running"}, false)
+	expectedVMs[0].ProvisioningState = ptr.To(vmProvisioningStateFailed)
+	mockVMsClient := testCloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, *expectedVMs[0].Name, gomock.Any()).Return(expectedVMs[0], nil).AnyTimes()
+	gomock.InOrder(
+		// the regular detach fails right away on the VM in Failed provisioning state
+		mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(nil, retry.NewError(false, fmt.Errorf("VM is in failed state"))),
+		mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ context.Context, _, _ string, parameters compute.VirtualMachineUpdate, _ string) (*compute.VirtualMachine, *retry.Error) {
+				for _, disk := range *parameters.StorageProfile.DataDisks {
+					if ptr.Deref(disk.ToBeDetached, false) {
+						assert.Equal(t, compute.ForceDetach, disk.DetachOption)
+					}
+				}
+				return nil, nil
+			}),
+	)
+
+	err := common.DetachDisk(context.Background(), "disk1", diskURI, "vm1")
+	assert.NoError(t, err)
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, forceDetachEscalatedReason)
+		assert.Contains(t, event, "Failed provisioning state")
+	default:
+		t.Errorf("expected a %s event", forceDetachEscalatedReason)
+	}
+}
+
+func TestCommonDetachDiskForceDetachOnStoppedVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCloud := provider.GetTestCloud(ctrl)
+	recorder := record.NewFakeRecorder(10)
+	common := &controllerCommon{
+		cloud:                       testCloud,
+		lockMap:                     newLockMap(),
+		DisableDiskLunCheck:         true,
+		ForceDetachTimeoutInSeconds: 120,
+		eventRecorder:               recorder,
+	}
+	diskURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/disk1",
+		testCloud.SubscriptionID, testCloud.ResourceGroup)
+	expectedVMs := setTestVirtualMachines(testCloud, map[string]string{"vm1": "PowerState/Stopped"}, false)
+	mockVMsClient := testCloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, *expectedVMs[0].Name, gomock.Any()).Return(expectedVMs[0], nil).AnyTimes()
+	gomock.InOrder(
+		// the regular detach fails on the stopped VM
+		mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(nil, retry.NewError(false, fmt.Errorf("VM is stopped"))),
+		mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ context.Context, _, _ string, parameters compute.VirtualMachineUpdate, _ string) (*compute.VirtualMachine, *retry.Error) {
+				for _, disk := range *parameters.StorageProfile.DataDisks {
+					if ptr.Deref(disk.ToBeDetached, false) {
+						assert.Equal(t, compute.ForceDetach, disk.DetachOption)
+					}
+				}
+				return nil, nil
+			}),
+	)
+
+	err := common.DetachDisk(context.Background(), "disk1", diskURI, "vm1")
+	assert.NoError(t, err)
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, forceDetachEscalatedReason)
+		assert.Contains(t, event, "Stopped power state")
+	default:
+		t.Errorf("expected a %s event", forceDetachEscalatedReason)
+	}
+}
+
+func TestVMPowerState(t *testing.T) {
+	tests := []struct {
+		powerState            string
+		expectedTransitioning bool
+		expectedStopped       bool
+	}{
+		{powerState: "Running"},
+		{powerState: "unknown"},
+		{powerState: "Stopping", expectedTransitioning: true},
+		{powerState: "deallocating", expectedTransitioning: true},
+		{powerState: "Stopped", expectedStopped: true},
+		{powerState: "deallocated", expectedStopped: true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expectedTransitioning, isVMPowerStateTransitioning(test.powerState), test.powerState)
+		assert.Equal(t, test.expectedStopped, isVMPowerStateStopped(test.powerState), test.powerState)
 	}
 }
 
@@ -691,6 +956,38 @@ func TestGetValidCreationData(t *testing.T) {
 	}
 }
 
+func TestGetValidCreationDataFromGalleryImage(t *testing.T) {
+	imageID := "/subscriptions/xxx/resourceGroups/xxx/providers/Microsoft.Compute/galleries/xxx/images/xxx/versions/1.0.0"
+	result, err := getValidCreationData("", "", &ManagedDiskOptions{GalleryImageReferenceID: imageID, GalleryImageLun: to.Ptr(int32(1))})
+	assert.NoError(t, err)
+	assert.Equal(t, armcompute.CreationData{
+		CreateOption:          to.Ptr(armcompute.DiskCreateOptionFromImage),
+		GalleryImageReference: &armcompute.ImageDiskReference{ID: to.Ptr(imageID), Lun: to.Ptr(int32(1))},
+	}, result)
+
+	// a volume content source takes precedence over the gallery image
+	sourceResourceVolumeID := "/subscriptions/xxx/resourceGroups/xxx/providers/Microsoft.Compute/disks/xxx"
+	result, err = getValidCreationData("", "", &ManagedDiskOptions{GalleryImageReferenceID: imageID, SourceResourceID: sourceResourceVolumeID, SourceType: sourceVolume})
+	assert.NoError(t, err)
+	assert.Equal(t, armcompute.DiskCreateOptionCopy, *result.CreateOption)
+}
+
+func TestGetValidCreationDataFromSourceURI(t *testing.T) {
+	sourceURI := "https://account.blob.core.windows.net/vhds/disk.vhd"
+	storageAccountID := "/subscriptions/xxx/resourceGroups/xxx/providers/Microsoft.Storage/storageAccounts/account"
+	result, err := getValidCreationData("", "", &ManagedDiskOptions{SourceURI: sourceURI, StorageAccountID: storageAccountID})
+	assert.NoError(t, err)
+	assert.Equal(t, armcompute.CreationData{
+		CreateOption:     to.Ptr(armcompute.DiskCreateOptionImport),
+		SourceURI:        to.Ptr(sourceURI),
+		StorageAccountID: to.Ptr(storageAccountID),
+	}, result)
+
+	result, err = getValidCreationData("", "", &ManagedDiskOptions{SourceURI: sourceURI})
+	assert.NoError(t, err)
+	assert.Nil(t, result.StorageAccountID)
+}
+
 func TestCheckDiskExists(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -1034,3 +1331,60 @@ func setTestVirtualMachines(c *provider.Cloud, vmList map[string]string, isDataD
 
 	return expectedVMs
 }
+
+func TestRetrySystemCriticalOperation(t *testing.T) {
+	originalBackoff := systemCriticalRetryBackoff
+	systemCriticalRetryBackoff = wait.Backoff{Steps: 3, Duration: time.Millisecond, Factor: 1}
+	defer func() { systemCriticalRetryBackoff = originalBackoff }()
+
+	tests := []struct {
+		desc          string
+		failures      int
+		expectedCalls int
+		expectedErr   error
+	}{
+		{
+			desc:          "succeed on first try",
+			failures:      0,
+			expectedCalls: 1,
+		},
+		{
+			desc:          "succeed after retry",
+			failures:      2,
+			expectedCalls: 3,
+		},
+		{
+			desc:          "return last error after all retries failed",
+			failures:      5,
+			expectedCalls: 3,
+			expectedErr:   fmt.Errorf("failure 3"),
+		},
+	}
+	for _, test := range tests {
+		calls := 0
+		err := retrySystemCriticalOperation(context.Background(), "attach volume", func() error {
+			calls++
+			if calls <= test.failures {
+				return fmt.Errorf("failure %d", calls)
+			}
+			return nil
+		})
+		assert.Equal(t, test.expectedErr, err, test.desc)
+		assert.Equal(t, test.expectedCalls, calls, test.desc)
+	}
+}
+
+func TestSystemCriticalOperationContext(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, isSystemCriticalOperation(ctx))
+	assert.True(t, isSystemCriticalOperation(withSystemCriticalOperation(ctx)))
+}
+
+func TestAttachDetachInitialDelayContext(t *testing.T) {
+	c := &controllerCommon{AttachDetachInitialDelayInMs: defaultAttachDetachInitialDelayInMs}
+	ctx := context.Background()
+	assert.Equal(t, defaultAttachDetachInitialDelayInMs, c.getAttachDetachInitialDelayInMs(ctx))
+	assert.Equal(t, 3000, c.getAttachDetachInitialDelayInMs(withAttachDetachInitialDelay(ctx, 3000)))
+	// the delay of the operation does not change the delay of the disk controller
+	assert.Equal(t, defaultAttachDetachInitialDelayInMs, c.AttachDetachInitialDelayInMs)
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/apimachinery/pkg/api/resource"
 	kwait "k8s.io/apimachinery/pkg/util/wait"
 	volumehelpers "k8s.io/cloud-provider/volume/helpers"
@@ -78,6 +79,14 @@ type ManagedDiskOptions struct {
 	SourceResourceID string
 	// The type of source
 	SourceType string
+	// if GalleryImageReferenceID is not empty and SourceResourceID is empty, the disk is created from the gallery
+	// image version, GalleryImageLun is the lun of the data disk image, the OS disk image is used if nil
+	GalleryImageReferenceID string
+	GalleryImageLun         *int32
+	// if SourceURI is not empty and SourceResourceID is empty, the disk is imported from the VHD blob at SourceURI,
+	// StorageAccountID is the storage account of the blob, required if it's in another subscription
+	SourceURI        string
+	StorageAccountID string
 	// ResourceId of the disk encryption set to use for enabling encryption at rest.
 	DiskEncryptionSetID string
 	// DiskEncryption type, available values: EncryptionAtRestWithCustomerKey, EncryptionAtRestWithPlatformAndCustomerKeys
@@ -104,16 +113,31 @@ type ManagedDiskOptions struct {
 	Location string
 	// PerformancePlus - Set this flag to true to get a boost on the performance target of the disk deployed
 	PerformancePlus *bool
+	// SupportsHibernation - Set to true to allow the disk to be attached to VMs with hibernation enabled
+	SupportsHibernation *bool
+	// OptimizedForFrequentAttach - Set to true for disks frequently detached from one VM and attached to another
+	OptimizedForFrequentAttach *bool
+	// CachingMode - host caching mode of the disk applied on the next attach, only used by ModifyDisk
+	CachingMode armcompute.CachingTypes
+	// SecurityType - security type of the trusted launch or confidential VMs the disk is attached to
+	SecurityType string
+	// SecureVMDiskEncryptionSetID - ResourceId of the disk encryption set of ConfidentialVM_DiskEncryptedWithCustomerKey disks
+	SecureVMDiskEncryptionSetID string
+	// ClusterID - UID of the kube-system namespace set as the owner tag of the disk, the owner tag is not set if empty
+	ClusterID string
 }
 
 // CreateManagedDisk: create managed disk
-func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *ManagedDiskOptions) (string, error) {
-	var err error
+func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *ManagedDiskOptions) (_ string, err error) {
+	ctx, span := startARMSpan(ctx, "CreateManagedDisk", attribute.String("azure.disk.name", options.DiskName),
+		attribute.String("azure.disk.sku", string(options.StorageAccountType)), attribute.Int("azure.disk.size_gb", options.SizeGB))
+	defer func() { endSpan(span, err) }()
 	klog.V(4).Infof("azureDisk - creating new managed Name:%s StorageAccountType:%s Size:%v", options.DiskName, options.StorageAccountType, options.SizeGB)
 
+	cloud := c.getCloud()
 	var createZones []string
 	if len(options.AvailabilityZone) > 0 {
-		requestedZone := c.cloud.GetZoneID(options.AvailabilityZone)
+		requestedZone := cloud.GetZoneID(options.AvailabilityZone)
 		if requestedZone != "" {
 			createZones = append(createZones, requestedZone)
 		}
@@ -128,18 +152,21 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 			newTags[k] = &value
 		}
 	}
+	if options.ClusterID != "" {
+		newTags[azureconsts.OwnerTag] = ptr.To(options.ClusterID)
+	}
 
 	diskSizeGB := int32(options.SizeGB)
 	diskSku := options.StorageAccountType
 
-	rg := c.cloud.ResourceGroup
+	rg := cloud.ResourceGroup
 	if options.ResourceGroup != "" {
 		rg = options.ResourceGroup
 	}
-	if options.SubscriptionID != "" && !strings.EqualFold(options.SubscriptionID, c.cloud.SubscriptionID) && options.ResourceGroup == "" {
+	if options.SubscriptionID != "" && !strings.EqualFold(options.SubscriptionID, cloud.SubscriptionID) && options.ResourceGroup == "" {
 		return "", fmt.Errorf("resourceGroup must be specified when subscriptionID(%s) is not empty", options.SubscriptionID)
 	}
-	subsID := c.cloud.SubscriptionID
+	subsID := cloud.SubscriptionID
 	if options.SubscriptionID != "" {
 		subsID = options.SubscriptionID
 	}
@@ -149,9 +176,11 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 		return "", err
 	}
 	diskProperties := armcompute.DiskProperties{
-		DiskSizeGB:      &diskSizeGB,
-		CreationData:    &creationData,
-		BurstingEnabled: options.BurstingEnabled,
+		DiskSizeGB:                 &diskSizeGB,
+		CreationData:               &creationData,
+		BurstingEnabled:            options.BurstingEnabled,
+		SupportsHibernation:        options.SupportsHibernation,
+		OptimizedForFrequentAttach: options.OptimizedForFrequentAttach,
 	}
 
 	if options.PublicNetworkAccess != "" {
@@ -230,9 +259,19 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 			DiskEncryptionSetID: &options.DiskEncryptionSetID,
 			Type:                to.Ptr(encryptionType),
 		}
-	} else {
-		if options.DiskEncryptionType != "" {
-			return "", fmt.Errorf("AzureDisk - DiskEncryptionType(%s) should be empty when DiskEncryptionSetID is not set", options.DiskEncryptionType)
+	} else if options.DiskEncryptionType == string(armcompute.EncryptionTypeEncryptionAtRestWithPlatformKey) {
+		diskProperties.Encryption = &armcompute.Encryption{Type: to.Ptr(armcompute.EncryptionTypeEncryptionAtRestWithPlatformKey)}
+	} else if options.DiskEncryptionType != "" {
+		return "", fmt.Errorf("AzureDisk - DiskEncryptionType(%s) should be empty when DiskEncryptionSetID is not set", options.DiskEncryptionType)
+	}
+
+	if options.SecurityType != "" {
+		klog.V(4).Infof("azureDisk - SecurityType: %s, SecureVMDiskEncryptionSetID: %s", options.SecurityType, options.SecureVMDiskEncryptionSetID)
+		diskProperties.SecurityProfile = &armcompute.DiskSecurityProfile{
+			SecurityType: to.Ptr(armcompute.DiskSecurityTypes(options.SecurityType)),
+		}
+		if options.SecureVMDiskEncryptionSetID != "" {
+			diskProperties.SecurityProfile.SecureVMDiskEncryptionSetID = &options.SecureVMDiskEncryptionSetID
 		}
 	}
 
@@ -240,7 +279,7 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 		diskProperties.MaxShares = &options.MaxShares
 	}
 
-	location := c.cloud.Location
+	location := cloud.Location
 	if options.Location != "" {
 		location = options.Location
 	}
@@ -253,18 +292,18 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 		Properties: &diskProperties,
 	}
 
-	if c.cloud.HasExtendedLocation() {
-		klog.V(2).Infof("extended location Name:%s Type:%s is set on disk(%s)", c.cloud.ExtendedLocationName, c.cloud.ExtendedLocationType, options.DiskName)
+	if cloud.HasExtendedLocation() {
+		klog.V(2).Infof("extended location Name:%s Type:%s is set on disk(%s)", cloud.ExtendedLocationName, cloud.ExtendedLocationType, options.DiskName)
 		model.ExtendedLocation = &armcompute.ExtendedLocation{
-			Name: ptr.To(c.cloud.ExtendedLocationName),
-			Type: to.Ptr(armcompute.ExtendedLocationTypes(c.cloud.ExtendedLocationType)),
+			Name: ptr.To(cloud.ExtendedLocationName),
+			Type: to.Ptr(armcompute.ExtendedLocationTypes(cloud.ExtendedLocationType)),
 		}
 	}
 
 	if len(createZones) > 0 {
 		model.Zones = to.SliceOfPtrs(createZones...)
 	}
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return "", err
 	}
@@ -305,7 +344,10 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 }
 
 // DeleteManagedDisk : delete managed disk
-func (c *ManagedDiskController) DeleteManagedDisk(ctx context.Context, diskURI string) error {
+func (c *ManagedDiskController) DeleteManagedDisk(ctx context.Context, diskURI string) (err error) {
+	ctx, span := startARMSpan(ctx, "DeleteManagedDisk", diskURIAttribute.String(diskURI))
+	defer func() { endSpan(span, err) }()
+
 	resourceGroup, subsID, err := getInfoFromDiskURI(diskURI)
 	if err != nil {
 		return err
@@ -316,7 +358,7 @@ func (c *ManagedDiskController) DeleteManagedDisk(ctx context.Context, diskURI s
 	}
 
 	diskName := path.Base(diskURI)
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return err
 	}
@@ -333,6 +375,10 @@ func (c *ManagedDiskController) DeleteManagedDisk(ctx context.Context, diskURI s
 	if disk.ManagedBy != nil {
 		return fmt.Errorf("disk(%s) already attached to node(%s), could not be deleted", diskURI, *disk.ManagedBy)
 	}
+	// diagnostic settings outlive the disk and would be applied to a new disk of the same name
+	if err = c.deleteDiagnosticSetting(ctx, diskURI, disk.Tags); err != nil {
+		return err
+	}
 
 	if err = diskClient.Delete(ctx, resourceGroup, diskName); err != nil {
 		return err
@@ -347,7 +393,7 @@ func (c *ManagedDiskController) DeleteManagedDisk(ctx context.Context, diskURI s
 
 // GetDisk return: disk provisionState, diskID, error
 func (c *ManagedDiskController) GetDisk(ctx context.Context, subsID, resourceGroup, diskName string) (string, string, error) {
-	diskclient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskclient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return "", "", err
 	}
@@ -364,13 +410,16 @@ func (c *ManagedDiskController) GetDisk(ctx context.Context, subsID, resourceGro
 }
 
 // ResizeDisk Expand the disk to new size
-func (c *ManagedDiskController) ResizeDisk(ctx context.Context, diskURI string, oldSize resource.Quantity, newSize resource.Quantity, supportOnlineResize bool) (resource.Quantity, error) {
+func (c *ManagedDiskController) ResizeDisk(ctx context.Context, diskURI string, oldSize resource.Quantity, newSize resource.Quantity, supportOnlineResize bool) (_ resource.Quantity, err error) {
+	ctx, span := startARMSpan(ctx, "ResizeDisk", diskURIAttribute.String(diskURI), attribute.String("azure.disk.new_size", newSize.String()))
+	defer func() { endSpan(span, err) }()
+
 	diskName := path.Base(diskURI)
 	resourceGroup, subsID, err := getInfoFromDiskURI(diskURI)
 	if err != nil {
 		return oldSize, err
 	}
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return oldSize, err
 	}
@@ -397,6 +446,18 @@ func (c *ManagedDiskController) ResizeDisk(ctx context.Context, diskURI string,
 		return newSizeQuant, nil
 	}
 
+	// Azure only resizes a shared disk detached from all VMs, the attachments are owned by the VolumeAttachments
+	// of each node, so the workloads of all nodes need to be stopped before the expansion is retried
+	if ptr.Deref(result.Properties.MaxShares, 1) > 1 && ptr.Deref(result.Properties.DiskState, armcompute.DiskStateUnattached) != armcompute.DiskStateUnattached {
+		attachedTo := []string{}
+		for _, vm := range result.ManagedByExtended {
+			if vm != nil {
+				attachedTo = append(attachedTo, path.Base(*vm))
+			}
+		}
+		return oldSize, fmt.Errorf("azureDisk - shared disk(%s) could only be resized when it's detached from all VMs, current disk state: %s, still attached to %s", diskName, *result.Properties.DiskState, strings.Join(attachedTo, ","))
+	}
+
 	if !supportOnlineResize && *result.Properties.DiskState != armcompute.DiskStateUnattached {
 		return oldSize, fmt.Errorf("azureDisk - disk resize is only supported on Unattached disk, current disk state: %s, already attached to %s", *result.Properties.DiskState, ptr.Deref(result.ManagedBy, ""))
 	}
@@ -416,15 +477,18 @@ func (c *ManagedDiskController) ResizeDisk(ctx context.Context, diskURI string,
 }
 
 // ModifyDisk: modify disk
-func (c *ManagedDiskController) ModifyDisk(ctx context.Context, options *ManagedDiskOptions) error {
-	klog.V(4).Infof("azureDisk - modifying managed Name:%s, StorageAccountType:%s, DiskIOPSReadWrite:%s, DiskMBpsReadWrite:%s", options.DiskName, options.StorageAccountType, options.DiskIOPSReadWrite, options.DiskMBpsReadWrite)
+func (c *ManagedDiskController) ModifyDisk(ctx context.Context, options *ManagedDiskOptions) (err error) {
+	ctx, span := startARMSpan(ctx, "ModifyDisk", diskURIAttribute.String(options.SourceResourceID))
+	defer func() { endSpan(span, err) }()
+
+	klog.V(4).Infof("azureDisk - modifying managed Name:%s, StorageAccountType:%s, DiskIOPSReadWrite:%s, DiskMBpsReadWrite:%s, CachingMode:%s", options.DiskName, options.StorageAccountType, options.DiskIOPSReadWrite, options.DiskMBpsReadWrite, options.CachingMode)
 
 	rg, subsID, err := getInfoFromDiskURI(options.SourceResourceID)
 	if err != nil {
 		return err
 	}
 
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return err
 	}
@@ -478,7 +542,21 @@ func (c *ManagedDiskController) ModifyDisk(ctx context.Context, options *Managed
 		}
 	}
 
-	if model.SKU != nil || model.Properties != nil {
+	if options.CachingMode != "" {
+		if diskSku == armcompute.DiskStorageAccountTypesPremiumV2LRS && options.CachingMode != armcompute.CachingTypesNone {
+			return fmt.Errorf("AzureDisk - cachingMode %s is not supported for %s", options.CachingMode, armcompute.DiskStorageAccountTypesPremiumV2LRS)
+		}
+		if v, ok := result.Tags[CachingModeTag]; !ok || v == nil || !strings.EqualFold(*v, string(options.CachingMode)) {
+			// tags of DiskUpdate replace all the existing tags of the disk
+			model.Tags = make(map[string]*string, len(result.Tags)+1)
+			for k, v := range result.Tags {
+				model.Tags[k] = v
+			}
+			model.Tags[CachingModeTag] = to.Ptr(string(options.CachingMode))
+		}
+	}
+
+	if model.SKU != nil || model.Properties != nil || model.Tags != nil {
 		if _, err := diskClient.Patch(ctx, rg, options.DiskName, model); err != nil {
 			return err
 		}
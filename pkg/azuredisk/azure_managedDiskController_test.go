@@ -34,6 +34,8 @@ import (
 	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/consts"
 	"sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	azureconsts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 )
 
 const (
@@ -172,6 +174,16 @@ func TestCreateManagedDisk(t *testing.T) {
 			expectedErr:         true,
 			expectedErrMsg:      fmt.Errorf("AzureDisk - DiskEncryptionType(EncryptionAtRestWithCustomerKey) should be empty when DiskEncryptionSetID is not set"),
 		},
+		{
+			desc:               "disk Id and no error shall be returned with EncryptionAtRestWithPlatformKey without DiskEncryptionSetID",
+			diskID:             disk1ID,
+			diskName:           disk1Name,
+			storageAccountType: armcompute.DiskStorageAccountTypesStandardLRS,
+			diskEncryptionType: "EncryptionAtRestWithPlatformKey",
+			expectedDiskID:     disk1ID,
+			existedDisk:        &armcompute.Disk{ID: ptr.To(disk1ID), Name: ptr.To(disk1Name), Properties: &armcompute.DiskProperties{Encryption: &armcompute.Encryption{Type: to.Ptr(armcompute.EncryptionTypeEncryptionAtRestWithPlatformKey)}, ProvisioningState: ptr.To("Succeeded")}, Tags: testTags},
+			expectedErr:        false,
+		},
 		{
 			desc:                "disk Id and no error shall be returned if everything is good with DiskStorageAccountTypesStandardLRS storage account with not empty diskIOPSReadWrite",
 			diskID:              disk1ID,
@@ -360,6 +372,43 @@ func TestCreateManagedDiskWithExtendedLocation(t *testing.T) {
 	assert.Nil(t, err, "There should not be an error.")
 }
 
+func TestCreateManagedDiskWithOwnerTag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	testCloud := provider.GetTestCloud(ctrl)
+	common := &controllerCommon{
+		cloud:                        testCloud,
+		lockMap:                      newLockMap(),
+		AttachDetachInitialDelayInMs: defaultAttachDetachInitialDelayInMs,
+		clientFactory:                testCloud.ComputeClientFactory,
+	}
+	managedDiskController := &ManagedDiskController{common}
+	diskreturned := &armcompute.Disk{ID: ptr.To(disk1ID), Name: ptr.To(disk1Name), Properties: &armcompute.DiskProperties{ProvisioningState: ptr.To("Succeeded")}}
+
+	mockDisksClient := mock_diskclient.NewMockInterface(ctrl)
+	common.clientFactory.(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(testCloud.SubscriptionID).Return(mockDisksClient, nil).AnyTimes()
+	mockDisksClient.EXPECT().CreateOrUpdate(gomock.Any(), testCloud.ResourceGroup, disk1Name, gomock.Any()).
+		Do(func(_ interface{}, _, _ string, disk armcompute.Disk) {
+			assert.Equal(t, map[string]*string{
+				consts.CreatedByTag:   ptr.To(azureconsts.AzureDiskDriverTag),
+				azureconsts.OwnerTag:  ptr.To("cluster-id"),
+				azureconsts.PvNameTag: ptr.To("pv"),
+			}, disk.Tags)
+		}).Return(diskreturned, nil)
+	mockDisksClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, disk1Name).Return(diskreturned, nil).AnyTimes()
+
+	_, err := managedDiskController.CreateManagedDisk(ctx, &ManagedDiskOptions{
+		DiskName:           disk1Name,
+		StorageAccountType: armcompute.DiskStorageAccountTypesPremiumLRS,
+		SizeGB:             1,
+		Tags:               map[string]string{azureconsts.PvNameTag: "pv"},
+		ClusterID:          "cluster-id",
+	})
+	assert.NoError(t, err)
+}
+
 func TestDeleteManagedDisk(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -514,6 +563,7 @@ func TestResizeDisk(t *testing.T) {
 		expectedQuantity resource.Quantity
 		expectedErr      bool
 		expectedErrMsg   error
+		onlineResize     bool
 	}{
 		{
 			desc:             "new quantity and no error shall be returned if everything is good",
@@ -573,6 +623,28 @@ func TestResizeDisk(t *testing.T) {
 			expectedErr:      true,
 			expectedErrMsg:   fmt.Errorf("azureDisk - disk resize is only supported on Unattached disk, current disk state: Attached, already attached to "),
 		},
+		{
+			desc:     "an error shall be returned if shared disk is attached even if online resize is supported",
+			diskName: diskName,
+			oldSize:  *resource.NewQuantity(2*(1024*1024*1024), resource.BinarySI),
+			newSize:  *resource.NewQuantity(3*(1024*1024*1024), resource.BinarySI),
+			existedDisk: &armcompute.Disk{Name: ptr.To(disk1Name),
+				ManagedByExtended: []*string{ptr.To("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"), ptr.To("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2")},
+				Properties:        &armcompute.DiskProperties{DiskSizeGB: &diskSizeGB, MaxShares: ptr.To(int32(2)), DiskState: to.Ptr(armcompute.DiskStateAttached)}},
+			expectedQuantity: *resource.NewQuantity(2*(1024*1024*1024), resource.BinarySI),
+			expectedErr:      true,
+			expectedErrMsg:   fmt.Errorf("azureDisk - shared disk(%s) could only be resized when it's detached from all VMs, current disk state: Attached, still attached to vm1,vm2", disk1Name),
+			onlineResize:     true,
+		},
+		{
+			desc:             "new quantity and no error shall be returned if shared disk is detached",
+			diskName:         diskName,
+			oldSize:          *resource.NewQuantity(2*(1024*1024*1024), resource.BinarySI),
+			newSize:          *resource.NewQuantity(3*(1024*1024*1024), resource.BinarySI),
+			existedDisk:      &armcompute.Disk{Name: ptr.To(disk1Name), Properties: &armcompute.DiskProperties{DiskSizeGB: &diskSizeGB, MaxShares: ptr.To(int32(2)), DiskState: to.Ptr(armcompute.DiskStateUnattached)}},
+			expectedQuantity: *resource.NewQuantity(3*(1024*1024*1024), resource.BinarySI),
+			onlineResize:     true,
+		},
 	}
 
 	for i, test := range testCases {
@@ -601,7 +673,7 @@ func TestResizeDisk(t *testing.T) {
 			mockDisksClient.EXPECT().Patch(gomock.Any(), testCloud.ResourceGroup, test.diskName, gomock.Any()).Return(test.existedDisk, nil).AnyTimes()
 		}
 
-		result, err := managedDiskController.ResizeDisk(ctx, diskURI, test.oldSize, test.newSize, false)
+		result, err := managedDiskController.ResizeDisk(ctx, diskURI, test.oldSize, test.newSize, test.onlineResize)
 		assert.Equal(t, test.expectedErr, err != nil, "TestCase[%d]: %s, return error: %v", i, test.desc, err)
 		if test.expectedErr {
 			assert.EqualError(t, test.expectedErrMsg, err.Error(), "TestCase[%d]: %s, expected: %v, return: %v", i, test.desc, test.expectedErrMsg, err)
@@ -627,7 +699,9 @@ func TestModifyDisk(t *testing.T) {
 		diskIOPSReadWrite  string
 		diskMBpsReadWrite  string
 		storageAccountType armcompute.DiskStorageAccountTypes
+		cachingMode        armcompute.CachingTypes
 		existedDisk        *armcompute.Disk
+		expectedTags       map[string]*string
 		expectedErr        bool
 		expectedErrMsg     error
 	}{
@@ -670,6 +744,27 @@ func TestModifyDisk(t *testing.T) {
 			existedDisk:        &armcompute.Disk{Name: ptr.To(disk1Name), SKU: &armcompute.DiskSKU{Name: &storageAccountTypePremiumLRS}, Properties: &armcompute.DiskProperties{DiskIOPSReadWrite: ptr.To(int64(100))}},
 			expectedErr:        false,
 		},
+		{
+			desc:         "new cachingMode is set in disk tags and existing tags are kept",
+			diskName:     diskName,
+			cachingMode:  armcompute.CachingTypesReadWrite,
+			existedDisk:  &armcompute.Disk{Name: ptr.To(disk1Name), SKU: &armcompute.DiskSKU{Name: &storageAccountTypePremiumLRS}, Properties: &armcompute.DiskProperties{}, Tags: map[string]*string{"key": ptr.To("value")}},
+			expectedTags: map[string]*string{"key": ptr.To("value"), CachingModeTag: ptr.To("ReadWrite")},
+		},
+		{
+			desc:        "cachingMode not changed",
+			diskName:    diskName,
+			cachingMode: armcompute.CachingTypesReadWrite,
+			existedDisk: &armcompute.Disk{Name: ptr.To(disk1Name), SKU: &armcompute.DiskSKU{Name: &storageAccountTypePremiumLRS}, Properties: &armcompute.DiskProperties{}, Tags: map[string]*string{CachingModeTag: ptr.To("readwrite")}},
+		},
+		{
+			desc:           "cachingMode not supported by PremiumV2_LRS",
+			diskName:       diskName,
+			cachingMode:    armcompute.CachingTypesReadOnly,
+			existedDisk:    &armcompute.Disk{Name: ptr.To(disk1Name), SKU: &armcompute.DiskSKU{Name: ptr.To(armcompute.DiskStorageAccountTypesPremiumV2LRS)}, Properties: &armcompute.DiskProperties{}},
+			expectedErr:    true,
+			expectedErrMsg: fmt.Errorf("AzureDisk - cachingMode ReadOnly is not supported for PremiumV2_LRS"),
+		},
 		{
 			desc:               "an error shall be returned when disk SKU is nil",
 			diskName:           diskName,
@@ -756,6 +851,7 @@ func TestModifyDisk(t *testing.T) {
 			ResourceGroup:      testCloud.ResourceGroup,
 			SubscriptionID:     testCloud.SubscriptionID,
 			SourceResourceID:   diskURI,
+			CachingMode:        test.cachingMode,
 		}
 
 		mockDisksClient := mock_diskclient.NewMockInterface(ctrl)
@@ -768,7 +864,11 @@ func TestModifyDisk(t *testing.T) {
 		if test.diskName == fakeCreateDiskFailed {
 			mockDisksClient.EXPECT().Patch(gomock.Any(), testCloud.ResourceGroup, test.diskName, gomock.Any()).Return(test.existedDisk, fmt.Errorf("Patch Disk failed")).AnyTimes()
 		} else {
-			mockDisksClient.EXPECT().Patch(gomock.Any(), testCloud.ResourceGroup, test.diskName, gomock.Any()).Return(test.existedDisk, nil).AnyTimes()
+			mockDisksClient.EXPECT().Patch(gomock.Any(), testCloud.ResourceGroup, test.diskName, gomock.Any()).DoAndReturn(
+				func(_ context.Context, _, _ string, model armcompute.DiskUpdate) (*armcompute.Disk, error) {
+					assert.Equal(t, test.expectedTags, model.Tags, "TestCase[%d]: %s", i, test.desc)
+					return test.existedDisk, nil
+				}).AnyTimes()
 		}
 
 		err := managedDiskController.ModifyDisk(ctx, diskOptions)
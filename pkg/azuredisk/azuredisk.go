@@ -21,9 +21,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
@@ -35,13 +39,22 @@ import (
 	"google.golang.org/grpc/status"
 
 	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume/util/hostutil"
 	"k8s.io/mount-utils"
@@ -123,10 +136,17 @@ type DriverCore struct {
 	enableWindowsHostProcess     bool
 	getNodeIDFromIMDS            bool
 	enableOtelTracing            bool
+	otelTracingOptions           OtelTracingOptions
+	enableAuditLog               bool
+	auditLogPath                 string
+	auditLogMaxSizeMB            int
+	auditLogMaxBackups           int
 	shouldWaitForSnapshotReady   bool
 	checkDiskLUNCollision        bool
 	forceDetachBackoff           bool
 	endpoint                     string
+	tlsEndpoint                  string
+	tlsCertDir                   string
 	disableAVSetNodes            bool
 	removeNotReadyTaint          bool
 	kubeClient                   kubernetes.Interface
@@ -134,6 +154,75 @@ type DriverCore struct {
 	volStatsCache           azcache.Resource
 	maxConcurrentFormat     int64
 	concurrentFormatTimeout int64
+	// namespaces whose volumes are attached/detached with system-critical priority
+	systemCriticalNamespaces map[string]bool
+	// TCP port of the node plugin filesystem freeze server, 0 means disabled
+	fsFreezePort int64
+	// write the realized disk properties into PV annotations
+	enableDiskPropertiesAnnotations bool
+	// read the disk.csi.azure.com/zone and disk.csi.azure.com/volume-priority annotations of the PVCs
+	enablePVCZoneAnnotation        bool
+	enableVolumePriorityAnnotation bool
+	// fence the volumes whose PVs have the disk.csi.azure.com/maintenance-until annotation from new publishes
+	enableVolumeMaintenance bool
+	// per client rate limits of the Azure API clients
+	clientRateLimitOptions *azureutils.ClientRateLimitOptions
+	// per operation timeouts in seconds, 0 means no timeout
+	attachTimeoutInSeconds       int64
+	detachTimeoutInSeconds       int64
+	forceDetachTimeoutInSeconds  int64
+	deadlineBudgetMarginSeconds  int64
+	createVolumeTimeoutInSeconds int64
+	deleteVolumeTimeoutInSeconds int64
+	// apply the fsGroup of the pod passed as volume mount group in NodePublishVolume
+	enableVolumeMountGroup bool
+	// normalize the tags and caching mode of pre-provisioned disks on their first attach
+	normalizeAdoptedDisks         bool
+	adoptedDiskTagCleanupPrefixes []string
+	// records events on the objects of the volumes and snapshots managed by the controller, nil on nodes
+	eventRecorder record.EventRecorder
+	// protects cloud, clientFactory and diskController, cloud and clientFactory are replaced when the cloud config is reloaded
+	cloudLock sync.RWMutex
+	// limits the services served by the driver, all services are served if empty
+	driverRole string
+	// serializes creating and deleting the resource groups created by the driver
+	resourceGroupLocks *lockMap
+	// resourceListClient is created from the cloud credential if nil
+	resourceListClient resourceListClient
+	// resourceTagsClient is created from the cloud credential if nil
+	resourceTagsClient resourceTagsClient
+	// UID of the kube-system namespace set as the owner tag of the disks, loaded on first use
+	clusterID atomic.Value
+	// attaches and detaches which outlived their RPCs, resumed by the retries of the CSI sidecar
+	inflightOperations *inflightOperations
+	// exports the statistics of the volumes staged on the node, nil if disabled or on the controller
+	volumeMetrics *volumeMetricsCollector
+	// annotate nodes with their remaining disk IOPS and bandwidth after each attach and detach
+	enableDiskThroughputHints bool
+	// refreshes of the disk throughput annotations per node <nodeName, *nodeDiskThroughputRefresh>
+	nodeDiskThroughputRefreshes sync.Map
+	// persists the publish contexts of the volumes staged on the node, nil if disabled or on the controller
+	publishContextCache *publishContextCache
+	// limits the concurrent disk and VM mutations per subscription across controllers, nil if disabled or on the node
+	mutationBudget *mutationBudget
+	// upper bound of the in-flight attaches to a node, scaled down by the VM size of the node, 0 if disabled
+	maxAttachConcurrencyPerNode int64
+	// forwards critical events to external alerting, nil if disabled or on the node
+	notifier *notifier
+	// attach duration in seconds over which a warning event is recorded on the PV, 0 if disabled
+	attachSLOSeconds int64
+	// resource groups of the VMs of the node pools <node pool, resource group>, overriding the provider IDs of their nodes
+	nodeResourceGroups map[string]string
+	// disk controllers of the nodes in the resource groups other than the one of the cloud <resource group, disk controller>
+	nodeResourceGroupDiskControllers sync.Map
+	// resource groups of the VMs of the nodes <node name, resource group>
+	nodeResourceGroupCache sync.Map
+	// lister of the PVCs whose annotations are read by CreateVolume, only set on the controller
+	pvcLister       corelisters.PersistentVolumeClaimLister
+	pvcListerSynced cache.InformerSynced
+	// lister of the PVs read by the device settings reconciler on the node and by ControllerPublishVolume on the controller
+	pvLister       corelisters.PersistentVolumeLister
+	pvListerSynced cache.InformerSynced
 }
 
 // Driver is the v1 implementation of the Azure Disk CSI Driver.
@@ -144,13 +233,97 @@ type Driver struct {
 	throttlingCache azcache.Resource
 	// a timed cache for disk lun collision check throttling
 	checkDiskLunThrottlingCache azcache.Resource
+	// system-critical volumes published by this driver <diskURI, bool>
+	systemCriticalVolumes sync.Map
+	// filesystems frozen on this node before taking a snapshot
+	fsFreezer *filesystemFreezer
+	// cross region snapshot copies in progress <snapshotName, localSnapshotName>
+	crossRegionSnapshotCopies sync.Map
+	// SINGLE_NODE_SINGLE_WRITER volumes published on this node <volumeID, targetPath>
+	singleWriterVolumes sync.Map
+	// volumes staged on this node whose device settings are reconciled <volumeID, tunedVolume>
+	tunedVolumes sync.Map
+	// in-flight attach slots of the nodes sized by their VM sizes <lower case node name, *nodeAttachSlots>
+	nodeAttachSlots sync.Map
+	// change the performance of the disks by the class of the node in the nodeClassLabel parameter before they are attached
+	enableNodeClassPerformance bool
+	// nodes read by the PVC validator and the node class performance, only set on the controller
+	nodeLister       corelisters.NodeLister
+	nodeListerSynced cache.InformerSynced
+	// VolumeAttachments indexed by the name of their PV, read by the SINGLE_NODE_SINGLE_WRITER check, only set on the controller
+	// after the first SINGLE_NODE_SINGLE_WRITER publish, the informer runs until volumeAttachmentInformerCtx is done
+	volumeAttachmentIndexer       cache.Indexer
+	volumeAttachmentIndexerSynced cache.InformerSynced
+	volumeAttachmentInformerCtx   context.Context
+	volumeAttachmentInformerOnce  sync.Once
+	// compute usages read by the PVC validator <subscriptionID/location, []*armcompute.Usage>
+	diskUsageCache azcache.Resource
+	// diskUsageClient is created from the cloud credential if nil
+	diskUsageClient diskUsageClient
+	// disks whose properties are written into the annotations of their PVs, only set on the controller if the disk
+	// properties annotations are enabled
+	diskPropertiesQueue workqueue.TypedRateLimitingInterface[diskPropertiesItem]
+	// file of the named tuning profiles accepted by the perfProfile parameter, empty means only builtin profiles
+	perfProfilesConfigFile string
+	// watch the cloud config changes and reload the cloud provider, a failed reload is retried every interval in seconds, 0 means disabled
+	cloudConfigReloadSeconds int64
+	// interval in seconds to re-apply the device settings of staged volumes after their PVs are annotated, 0 if disabled
+	deviceSettingsReconcileSeconds int64
+	// interval in seconds to check the endpoint socket on the node, 0 if disabled
+	socketWatchdogSeconds int64
+
+	// options of the features only run by the controller, the background loops are only run by the leader of the lease
+	pvcMutationWebhookOptions   PVCMutationWebhookOptions
+	leaderElectionOptions       LeaderElectionOptions
+	pvcValidationOptions        PVCValidationOptions
+	pvNodeAffinityOptions       PVNodeAffinityOptions
+	snapshotRetentionOptions    SnapshotRetentionOptions
+	volumePopulatorOptions      VolumePopulatorOptions
+	tagReconcileOptions         TagReconcileOptions
+	snapshotExportOptions       SnapshotExportOptions
+	diskReplicationOptions      DiskReplicationOptions
+	diskPoolOptions             DiskPoolOptions
+	volumeRecommendationOptions VolumeRecommendationOptions
+	orphanDiskGCOptions         OrphanDiskGCOptions
+	// client of the AzDiskReplication, AzDiskPool, AzVolumeRecommendation, AzDiskImport and AzSnapshotExport custom
+	// resources, only set on the controller if any of their controllers is enabled
+	dynamicClient dynamic.Interface
+	// client of the VolumeSnapshot APIs, only set on the controller if snapshot retention or export is enabled
+	volumeSnapshotClient snapshotclientset.Interface
+	// <lower case resource group or subscription ID/resource group, true> of the resource groups the snapshots of
+	// AzDiskReplications could be created in
+	diskReplicationResourceGroups map[string]bool
+	// creates and deletes the snapshots of AzDiskReplications, the driver itself if nil
+	replicaSnapshotter replicaSnapshotter
+	// serializes the claims of the disks of a pool <pool name>
+	diskPoolLocks *lockMap
+	// reads the usage and the performance metrics of the volumes, created from the kube client and the cloud
+	// credential if nil
+	volumeRecommendationMetricsClient volumeRecommendationMetricsClient
+	// limits the disks checked by the tag reconciler, nil if the tag reconciler is disabled
+	tagReconcileRateLimiter flowcontrol.RateLimiter
+	// <lower case storage account name, true> of the storage accounts written by the identity of the driver for the
+	// AzSnapshotExports without destination secret
+	snapshotExportStorageAccounts map[string]bool
+	// grants access to snapshots and copies them to blobs, created from the cloud credential if nil
+	snapshotExportClient snapshotExportClient
+	// age of a disk without PV before it's collected, the PV of a new disk is created after the disk
+	orphanDiskTTL time.Duration
+	// limits the orphaned disks deleted, nil if the orphan disk GC is disabled
+	orphanDiskGCRateLimiter flowcontrol.RateLimiter
 }
 
 // newDriverV1 Creates a NewCSIDriver object. Assumes vendor version is equal to driver version &
 // does not support optional driver plugin info manifest field. Refer to CSI spec for more details.
-func newDriverV1(options *DriverOptions) *Driver {
+func newDriverV1(options *DriverOptions) (*Driver, error) {
 	driver := Driver{}
 	driver.Name = options.DriverName
+	driver.driverRole = options.DriverRole
+	driver.resourceGroupLocks = newLockMap()
+	driver.diskPoolLocks = newLockMap()
+	driver.inflightOperations = newInflightOperations()
+	driver.tlsEndpoint = options.TLSEndpoint
+	driver.tlsCertDir = options.TLSCertDir
 	driver.Version = driverVersion
 	driver.NodeID = options.NodeID
 	driver.VolumeAttachLimit = options.VolumeAttachLimit
@@ -178,6 +351,11 @@ func newDriverV1(options *DriverOptions) *Driver {
 	driver.enableWindowsHostProcess = options.EnableWindowsHostProcess
 	driver.getNodeIDFromIMDS = options.GetNodeIDFromIMDS
 	driver.enableOtelTracing = options.EnableOtelTracing
+	driver.otelTracingOptions = newOtelTracingOptions(options)
+	driver.enableAuditLog = options.EnableAuditLog
+	driver.auditLogPath = options.AuditLogPath
+	driver.auditLogMaxSizeMB = options.AuditLogMaxSizeMB
+	driver.auditLogMaxBackups = options.AuditLogMaxBackups
 	driver.shouldWaitForSnapshotReady = options.WaitForSnapshotReady
 	driver.checkDiskLUNCollision = options.CheckDiskLUNCollision
 	driver.forceDetachBackoff = options.ForceDetachBackoff
@@ -186,29 +364,114 @@ func newDriverV1(options *DriverOptions) *Driver {
 	driver.removeNotReadyTaint = options.RemoveNotReadyTaint
 	driver.maxConcurrentFormat = options.MaxConcurrentFormat
 	driver.concurrentFormatTimeout = options.ConcurrentFormatTimeout
+	driver.systemCriticalNamespaces = parseSystemCriticalNamespaces(options.SystemCriticalNamespaces)
+	driver.fsFreezePort = options.FsFreezePort
+	driver.enableDiskPropertiesAnnotations = options.EnableDiskPropertiesAnnotations
+	driver.enablePVCZoneAnnotation = options.EnablePVCZoneAnnotation
+	driver.enableVolumePriorityAnnotation = options.EnableVolumePriorityAnnotation
+	driver.enableVolumeMaintenance = options.EnableVolumeMaintenance
+	driver.enableNodeClassPerformance = options.EnableNodeClassPerformance
+	driver.clientRateLimitOptions = newClientRateLimitOptions(options)
+	driver.attachTimeoutInSeconds = options.AttachTimeoutInSeconds
+	driver.detachTimeoutInSeconds = options.DetachTimeoutInSeconds
+	driver.forceDetachTimeoutInSeconds = options.ForceDetachTimeoutInSeconds
+	driver.deadlineBudgetMarginSeconds = options.DeadlineBudgetMarginSeconds
+	driver.createVolumeTimeoutInSeconds = options.CreateVolumeTimeoutInSeconds
+	driver.deleteVolumeTimeoutInSeconds = options.DeleteVolumeTimeoutInSeconds
+	driver.pvcMutationWebhookOptions = options.PVCMutationWebhook
+	driver.enableVolumeMountGroup = options.EnableVolumeMountGroup
+	if driver.enableVolumeMountGroup && runtime.GOOS == "windows" {
+		// fsGroup does not apply to NTFS volumes, kubelet ignores the fsGroup of pods on Windows as well
+		klog.Warning("--enable-volume-mount-group is ignored on Windows")
+		driver.enableVolumeMountGroup = false
+	}
+	driver.perfProfilesConfigFile = options.PerfProfilesConfigFile
+	driver.diskReplicationOptions = options.DiskReplication
+	driver.diskReplicationResourceGroups = map[string]bool{}
+	for _, resourceGroup := range strings.Split(options.DiskReplication.ResourceGroups, ",") {
+		if resourceGroup = strings.TrimSpace(resourceGroup); resourceGroup != "" {
+			driver.diskReplicationResourceGroups[strings.ToLower(resourceGroup)] = true
+		}
+	}
+	driver.cloudConfigReloadSeconds = options.CloudConfigReloadSeconds
+	driver.pvcValidationOptions = options.PVCValidation
+	driver.enableDiskThroughputHints = options.EnableDiskThroughputHints
+	driver.diskPoolOptions = options.DiskPool
+	driver.volumeRecommendationOptions = options.VolumeRecommendation
+	driver.pvNodeAffinityOptions = options.PVNodeAffinity
+	driver.deviceSettingsReconcileSeconds = options.DeviceSettingsReconcileSeconds
+	driver.snapshotRetentionOptions = options.SnapshotRetention
+	driver.maxAttachConcurrencyPerNode = options.MaxAttachConcurrencyPerNode
+	driver.attachSLOSeconds = options.AttachSLOSeconds
+	driver.socketWatchdogSeconds = options.SocketWatchdogSeconds
+	driver.volumePopulatorOptions = options.VolumePopulator
+	nodeResourceGroups, err := parseNodeResourceGroupMap(options.NodeResourceGroupMap)
+	if err != nil {
+		return nil, err
+	}
+	driver.nodeResourceGroups = nodeResourceGroups
+	if err := options.TagReconcile.validate(); err != nil {
+		return nil, err
+	}
+	driver.tagReconcileOptions = options.TagReconcile
+	if driver.tagReconcileOptions.IntervalSeconds > 0 {
+		driver.tagReconcileRateLimiter = flowcontrol.NewTokenBucketRateLimiter(float32(options.TagReconcile.QPS), 1)
+	}
+	driver.snapshotExportOptions = options.SnapshotExport
+	driver.snapshotExportStorageAccounts = map[string]bool{}
+	for _, account := range strings.Split(options.SnapshotExport.StorageAccounts, ",") {
+		if account = strings.TrimSpace(account); account != "" {
+			driver.snapshotExportStorageAccounts[strings.ToLower(account)] = true
+		}
+	}
+	if err := options.OrphanDiskGC.validate(); err != nil {
+		return nil, err
+	}
+	driver.orphanDiskGCOptions = options.OrphanDiskGC
+	driver.orphanDiskTTL = time.Duration(options.OrphanDiskGC.TTLSeconds) * time.Second
+	if driver.orphanDiskGCOptions.Enable {
+		driver.orphanDiskGCRateLimiter = flowcontrol.NewTokenBucketRateLimiter(orphanDiskDeleteQPS, 1)
+	}
+	driver.leaderElectionOptions = options.LeaderElection
+	driver.normalizeAdoptedDisks = options.NormalizeAdoptedDisks
+	for _, prefix := range strings.Split(options.AdoptedDiskTagCleanupPrefixes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			driver.adoptedDiskTagCleanupPrefixes = append(driver.adoptedDiskTagCleanupPrefixes, prefix)
+		}
+	}
+	driver.fsFreezer = newFilesystemFreezer(
+		func(mountPath string) error { return freezeFilesystem(mountPath, driver.mounter) },
+		func(mountPath string) error { return thawFilesystem(mountPath, driver.mounter) },
+		fsFreezeLease)
 	driver.volumeLocks = volumehelper.NewVolumeLocks()
 	driver.ioHandler = azureutils.NewOSIOHandler()
 	driver.hostUtil = hostutil.NewHostUtil()
 	if driver.NodeID == "" {
 		// nodeid is not needed in controller component
 		klog.Warning("nodeid is empty")
+	} else {
+		if options.EnableVolumeMetrics {
+			driver.registerVolumeMetrics()
+		}
+		if options.PublishContextCacheDir != "" {
+			driver.publishContextCache = newPublishContextCache(options.PublishContextCacheDir, driver.getDiskIdentityByLun)
+		}
 	}
 	topologyKey = fmt.Sprintf("topology.%s/zone", driver.Name)
 
 	getter := func(_ context.Context, _ string) (interface{}, error) { return nil, nil }
-	var err error
 	if driver.throttlingCache, err = azcache.NewTimedCache(5*time.Minute, getter, false); err != nil {
-		klog.Fatalf("%v", err)
+		return nil, err
 	}
 	if driver.checkDiskLunThrottlingCache, err = azcache.NewTimedCache(30*time.Minute, getter, false); err != nil {
-		klog.Fatalf("%v", err)
+		return nil, err
 	}
 
 	if options.VolStatsCacheExpireInMinutes <= 0 {
 		options.VolStatsCacheExpireInMinutes = 10 // default expire in 10 minutes
 	}
 	if driver.volStatsCache, err = azcache.NewTimedCache(time.Duration(options.VolStatsCacheExpireInMinutes)*time.Minute, getter, false); err != nil {
-		klog.Fatalf("%v", err)
+		return nil, err
 	}
 
 	userAgent := GetUserAgent(driver.Name, driver.customUserAgent, driver.userAgentSuffix)
@@ -219,49 +482,50 @@ func newDriverV1(options *DriverOptions) *Driver {
 		klog.Warningf("get kubeconfig(%s) failed with error: %v", options.Kubeconfig, err)
 	}
 	driver.kubeClient = kubeClient
-
-	cloud, err := azureutils.GetCloudProviderFromClient(context.Background(), kubeClient, driver.cloudConfigSecretName, driver.cloudConfigSecretNamespace,
-		userAgent, driver.allowEmptyCloudConfig, driver.enableTrafficManager, driver.trafficManagerPort)
-	if err != nil {
-		klog.Fatalf("failed to get Azure Cloud Provider, error: %v", err)
+	if kubeClient != nil && driver.NodeID == "" {
+		driver.eventRecorder = newEventRecorder(kubeClient, driver.Name)
 	}
-	driver.cloud = cloud
-
-	if driver.cloud != nil {
-		driver.clientFactory = driver.cloud.ComputeClientFactory
-		if driver.vmType != "" {
-			klog.V(2).Infof("override VMType(%s) in cloud config as %s", driver.cloud.VMType, driver.vmType)
-			driver.cloud.VMType = driver.vmType
+	if driver.NodeID == "" && options.NotificationConfigFile != "" {
+		config, err := loadNotificationConfig(options.NotificationConfigFile)
+		if err != nil {
+			return nil, err
 		}
-
-		if driver.NodeID == "" {
-			// Disable UseInstanceMetadata for controller to mitigate a timeout issue using IMDS
-			// https://github.com/kubernetes-sigs/azuredisk-csi-driver/issues/168
-			klog.V(2).Infof("disable UseInstanceMetadata for controller")
-			driver.cloud.Config.UseInstanceMetadata = false
-
-			if driver.cloud.VMType == azurecloudconsts.VMTypeStandard && driver.cloud.DisableAvailabilitySetNodes {
-				klog.V(2).Infof("set DisableAvailabilitySetNodes as false since VMType is %s", driver.cloud.VMType)
-				driver.cloud.DisableAvailabilitySetNodes = false
-			}
-
-			if driver.cloud.VMType == azurecloudconsts.VMTypeVMSS && !driver.cloud.DisableAvailabilitySetNodes && driver.disableAVSetNodes {
-				klog.V(2).Infof("DisableAvailabilitySetNodes for controller since current VMType is vmss")
-				driver.cloud.DisableAvailabilitySetNodes = true
+		driver.notifier = newNotifier(driver.Name, config)
+		driver.eventRecorder = newNotifyingEventRecorder(driver.eventRecorder, driver.notifier)
+	}
+	if driver.NodeID == "" && options.MutationBudget.PerSubscription > 0 {
+		budgetKubeClient := kubeClient
+		if options.MutationBudget.Kubeconfig != "" {
+			if budgetKubeClient, err = azureutils.GetKubeClient(options.MutationBudget.Kubeconfig); err != nil {
+				return nil, fmt.Errorf("failed to get kubeconfig(%s) of mutation budget: %w", options.MutationBudget.Kubeconfig, err)
 			}
-			klog.V(2).Infof("cloud: %s, location: %s, rg: %s, VMType: %s, PrimaryScaleSetName: %s, PrimaryAvailabilitySetName: %s, DisableAvailabilitySetNodes: %v", driver.cloud.Cloud, driver.cloud.Location, driver.cloud.ResourceGroup, driver.cloud.VMType, driver.cloud.PrimaryScaleSetName, driver.cloud.PrimaryAvailabilitySetName, driver.cloud.DisableAvailabilitySetNodes)
 		}
-
-		if driver.vmssCacheTTLInSeconds > 0 {
-			klog.V(2).Infof("reset vmssCacheTTLInSeconds as %d", driver.vmssCacheTTLInSeconds)
-			driver.cloud.VMCacheTTLInSeconds = int(driver.vmssCacheTTLInSeconds)
-			driver.cloud.VmssCacheTTLInSeconds = int(driver.vmssCacheTTLInSeconds)
+		if budgetKubeClient != nil {
+			hostname, _ := os.Hostname()
+			driver.mutationBudget = newMutationBudget(budgetKubeClient, options.MutationBudget.Namespace, int(options.MutationBudget.PerSubscription), hostname)
+		} else {
+			klog.Warningf("mutation budget is disabled since kube client is not available")
+		}
+	}
+	if driver.NodeID == "" && (driver.snapshotRetentionOptions.IntervalSeconds > 0 || driver.snapshotExportOptions.IntervalSeconds > 0) {
+		if driver.volumeSnapshotClient, err = azureutils.GetSnapshotClient(options.Kubeconfig); err != nil {
+			klog.Warningf("snapshot retention and export are disabled since snapshot client is not available: %v", err)
 		}
+	}
+	if driver.NodeID == "" && (driver.volumePopulatorOptions.Enable || driver.snapshotExportOptions.IntervalSeconds > 0 || driver.diskReplicationOptions.IntervalSeconds > 0 || driver.diskPoolOptions.IntervalSeconds > 0 ||
+		driver.volumeRecommendationOptions.IntervalSeconds > 0) {
+		if driver.dynamicClient, err = azureutils.GetDynamicClient(options.Kubeconfig); err != nil {
+			klog.Warningf("volume populator, snapshot export, disk replication, disk pools and volume recommendation are disabled since dynamic client is not available: %v", err)
+		}
+	}
 
-		driver.diskController = NewManagedDiskController(driver.cloud)
-		driver.diskController.DisableUpdateCache = driver.disableUpdateCache
-		driver.diskController.AttachDetachInitialDelayInMs = int(driver.attachDetachInitialDelayInMs)
-		driver.diskController.ForceDetachBackoff = driver.forceDetachBackoff
+	cloud, err := azureutils.GetCloudProviderFromClient(context.Background(), kubeClient, driver.cloudConfigSecretName, driver.cloudConfigSecretNamespace,
+		userAgent, driver.allowEmptyCloudConfig, driver.enableTrafficManager, driver.trafficManagerPort, driver.clientRateLimitOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Azure Cloud Provider, error: %w", err)
+	}
+	if cloud != nil {
+		driver.swapCloud(cloud)
 	}
 
 	driver.deviceHelper = optimization.NewSafeDeviceHelper()
@@ -275,7 +539,7 @@ func newDriverV1(options *DriverOptions) *Driver {
 
 	driver.mounter, err = mounter.NewSafeMounter(driver.enableWindowsHostProcess, driver.useCSIProxyGAInterface, int(driver.maxConcurrentFormat), time.Duration(driver.concurrentFormatTimeout)*time.Second)
 	if err != nil {
-		klog.Fatalf("Failed to get safe mounter. Error: %v", err)
+		return nil, fmt.Errorf("failed to get safe mounter, error: %w", err)
 	}
 
 	controllerCap := []csi.ControllerServiceCapability_RPC_Type{
@@ -286,6 +550,8 @@ func newDriverV1(options *DriverOptions) *Driver {
 		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
 		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 		csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
 	}
 	if driver.enableListVolumes {
 		controllerCap = append(controllerCap, csi.ControllerServiceCapability_RPC_LIST_VOLUMES, csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES)
@@ -302,12 +568,16 @@ func newDriverV1(options *DriverOptions) *Driver {
 			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
 			csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
 		})
-	driver.AddNodeServiceCapabilities([]csi.NodeServiceCapability_RPC_Type{
+	nodeCapabilities := []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-	})
+	}
+	if driver.enableVolumeMountGroup {
+		nodeCapabilities = append(nodeCapabilities, csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP)
+	}
+	driver.AddNodeServiceCapabilities(nodeCapabilities)
 
 	if kubeClient != nil && driver.removeNotReadyTaint && driver.NodeID != "" {
 		// Remove taint from node to indicate driver startup success
@@ -316,7 +586,51 @@ func newDriverV1(options *DriverOptions) *Driver {
 			removeTaintInBackground(kubeClient, driver.NodeID, driver.Name, taintRemovalBackoff, removeNotReadyTaint)
 		})
 	}
-	return &driver
+	return &driver, nil
+}
+
+// configureCloud applies the driver overrides to the cloud config
+func (d *DriverCore) configureCloud(cloud *azure.Cloud) {
+	if d.vmType != "" {
+		klog.V(2).Infof("override VMType(%s) in cloud config as %s", cloud.VMType, d.vmType)
+		cloud.VMType = d.vmType
+	}
+
+	if d.NodeID == "" {
+		// Disable UseInstanceMetadata for controller to mitigate a timeout issue using IMDS
+		// https://github.com/kubernetes-sigs/azuredisk-csi-driver/issues/168
+		klog.V(2).Infof("disable UseInstanceMetadata for controller")
+		cloud.Config.UseInstanceMetadata = false
+
+		if cloud.VMType == azurecloudconsts.VMTypeStandard && cloud.DisableAvailabilitySetNodes {
+			klog.V(2).Infof("set DisableAvailabilitySetNodes as false since VMType is %s", cloud.VMType)
+			cloud.DisableAvailabilitySetNodes = false
+		}
+
+		if cloud.VMType == azurecloudconsts.VMTypeVMSS && !cloud.DisableAvailabilitySetNodes && d.disableAVSetNodes {
+			klog.V(2).Infof("DisableAvailabilitySetNodes for controller since current VMType is vmss")
+			cloud.DisableAvailabilitySetNodes = true
+		}
+		klog.V(2).Infof("cloud: %s, location: %s, rg: %s, VMType: %s, PrimaryScaleSetName: %s, PrimaryAvailabilitySetName: %s, DisableAvailabilitySetNodes: %v", cloud.Cloud, cloud.Location, cloud.ResourceGroup, cloud.VMType, cloud.PrimaryScaleSetName, cloud.PrimaryAvailabilitySetName, cloud.DisableAvailabilitySetNodes)
+	}
+
+	if d.vmssCacheTTLInSeconds > 0 {
+		klog.V(2).Infof("reset vmssCacheTTLInSeconds as %d", d.vmssCacheTTLInSeconds)
+		cloud.VMCacheTTLInSeconds = int(d.vmssCacheTTLInSeconds)
+		cloud.VmssCacheTTLInSeconds = int(d.vmssCacheTTLInSeconds)
+	}
+}
+
+// newDiskController applies the driver overrides to the cloud config and returns a disk controller using the cloud
+func (d *DriverCore) newDiskController(cloud *azure.Cloud) *ManagedDiskController {
+	d.configureCloud(cloud)
+	diskController := NewManagedDiskController(cloud)
+	diskController.DisableUpdateCache = d.disableUpdateCache
+	diskController.AttachDetachInitialDelayInMs = int(d.attachDetachInitialDelayInMs)
+	diskController.ForceDetachBackoff = d.forceDetachBackoff
+	diskController.ForceDetachTimeoutInSeconds = d.forceDetachTimeoutInSeconds
+	diskController.eventRecorder = d.eventRecorder
+	return diskController
 }
 
 // Run driver initialization
@@ -327,14 +641,33 @@ func (d *Driver) Run(ctx context.Context) error {
 	}
 	klog.Infof("\nDRIVER INFORMATION:\n-------------------\n%s\n\nStreaming logs below:", versionMeta)
 
+	if d.perfProfilesConfigFile != "" {
+		profiles, err := optimization.LoadTuningProfiles(d.perfProfilesConfigFile)
+		if err != nil {
+			return fmt.Errorf("failed to load perf tuning profiles: %w", err)
+		}
+		optimization.SetTuningProfiles(profiles)
+		klog.V(2).Infof("loaded %d perf tuning profiles from %s", len(profiles.Profiles), d.perfProfilesConfigFile)
+	}
+
+	interceptors := []grpc.UnaryServerInterceptor{
+		grpcprom.NewServerMetrics().UnaryServerInterceptor(),
+		csicommon.LogGRPC,
+	}
+	if d.enableAuditLog {
+		auditLogger := newAuditLogger(d.auditLogPath, d.auditLogMaxSizeMB, d.auditLogMaxBackups)
+		defer func() {
+			if err := auditLogger.Close(); err != nil {
+				klog.Errorf("Could not close audit logger: %v", err)
+			}
+		}()
+		interceptors = append(interceptors, auditLogger.UnaryServerInterceptor)
+	}
 	opts := []grpc.ServerOption{
-		grpc.ChainUnaryInterceptor(
-			grpcprom.NewServerMetrics().UnaryServerInterceptor(),
-			csicommon.LogGRPC,
-		),
+		grpc.ChainUnaryInterceptor(interceptors...),
 	}
 	if d.enableOtelTracing {
-		exporter, err := InitOtelTracing()
+		exporter, err := InitOtelTracing(d.otelTracingOptions)
 		if err != nil {
 			klog.Fatalf("Failed to initialize otel tracing: %v", err)
 		}
@@ -347,16 +680,66 @@ func (d *Driver) Run(ctx context.Context) error {
 		opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
 	}
 
-	s := grpc.NewServer(opts...)
-	csi.RegisterIdentityServer(s, d)
-	csi.RegisterControllerServer(s, d)
-	csi.RegisterNodeServer(s, d)
+	s := d.newGRPCServer(d, opts...)
+	if d.tlsEndpoint != "" {
+		go d.runTLSEndpoint(ctx, d, opts)
+	}
 
 	go func() {
 		//graceful shutdown
 		<-ctx.Done()
 		s.GracefulStop()
 	}()
+	if d.NodeID != "" && d.fsFreezePort > 0 {
+		go func() {
+			if err := d.runFsFreezeServer(ctx, d.fsFreezePort); err != nil {
+				klog.Errorf("filesystem freeze server is not served: %v", err)
+			}
+		}()
+	}
+	if d.mutationBudget != nil {
+		d.mutationBudget.Start(ctx)
+	}
+	if d.NodeID == "" && d.kubeClient != nil && (d.enablePVCZoneAnnotation || d.enableVolumePriorityAnnotation) {
+		d.startPVCInformer(ctx)
+	}
+	if d.NodeID == "" && d.kubeClient != nil && (d.enableVolumeMaintenance || d.enableDiskPropertiesAnnotations) {
+		informer := d.startPVInformer(ctx)
+		if d.enableDiskPropertiesAnnotations {
+			d.startDiskPropertiesReconciler(ctx, informer)
+		}
+	}
+	if d.NodeID == "" && d.kubeClient != nil && (d.enableNodeClassPerformance || d.pvcValidationOptions.Enable) {
+		d.startNodeInformer(ctx)
+	}
+	if d.NodeID == "" && d.kubeClient != nil {
+		// the VolumeAttachment informer is started by the first SINGLE_NODE_SINGLE_WRITER publish
+		d.volumeAttachmentInformerCtx = ctx
+	}
+	if d.NodeID == "" && d.maxAttachConcurrencyPerNode > 0 && d.kubeClient != nil {
+		go d.runNodeAttachSlotsInformer(ctx)
+	}
+	if d.NodeID == "" && d.pvcMutationWebhookOptions.Port > 0 {
+		go d.runPVCMutationWebhook(ctx)
+	}
+	if d.cloudConfigReloadSeconds > 0 && d.getCloud() != nil {
+		go d.runCloudConfigReloader(ctx, time.Duration(d.cloudConfigReloadSeconds)*time.Second)
+	}
+	if d.NodeID == "" {
+		go d.runWithLeaderElection(ctx, d.runControllerLoops)
+	}
+	if d.NodeID != "" && d.deviceSettingsReconcileSeconds > 0 && d.getPerfOptimizationEnabled() && d.kubeClient != nil {
+		go d.runDeviceSettingsReconciler(ctx, time.Duration(d.deviceSettingsReconcileSeconds)*time.Second)
+	}
+	if d.NodeID != "" && d.volumeMetrics != nil && d.kubeClient != nil {
+		go d.rebuildStagedVolumeMetrics(ctx, 30*time.Second)
+	}
+	if d.notifier != nil {
+		go d.notifier.Run(ctx)
+	}
+	if d.NodeID != "" && d.socketWatchdogSeconds > 0 {
+		go d.runSocketWatchdog(ctx, s, time.Duration(d.socketWatchdogSeconds)*time.Second)
+	}
 	// Driver d act as IdentityServer, ControllerServer and NodeServer
 	listener, err := csicommon.Listen(ctx, d.endpoint)
 	if err != nil {
@@ -370,6 +753,41 @@ func (d *Driver) Run(ctx context.Context) error {
 	return err
 }
 
+// runControllerLoops starts the background loops of the controller, which are only run by the leader of the
+// controller replicas and stopped once ctx is done
+func (d *Driver) runControllerLoops(ctx context.Context) {
+	if d.pvcValidationOptions.Enable && d.kubeClient != nil && d.getCloud() != nil {
+		go d.runPVCValidator(ctx)
+	}
+	if d.pvNodeAffinityOptions.ReconcileIntervalSeconds > 0 && d.kubeClient != nil && d.getCloud() != nil {
+		go d.runPVNodeAffinityReconciler(ctx, time.Duration(d.pvNodeAffinityOptions.ReconcileIntervalSeconds)*time.Second)
+	}
+	if d.snapshotRetentionOptions.IntervalSeconds > 0 && d.volumeSnapshotClient != nil && d.getCloud() != nil {
+		go d.runSnapshotRetentionController(ctx, time.Duration(d.snapshotRetentionOptions.IntervalSeconds)*time.Second)
+	}
+	if d.volumePopulatorOptions.Enable && d.kubeClient != nil && d.dynamicClient != nil && d.getCloud() != nil {
+		go d.runVolumePopulator(ctx)
+	}
+	if d.tagReconcileOptions.IntervalSeconds > 0 && d.kubeClient != nil && d.getCloud() != nil {
+		go d.runDiskTagReconciler(ctx, time.Duration(d.tagReconcileOptions.IntervalSeconds)*time.Second)
+	}
+	if d.snapshotExportOptions.IntervalSeconds > 0 && d.kubeClient != nil && d.volumeSnapshotClient != nil && d.dynamicClient != nil && d.getCloud() != nil {
+		go d.runSnapshotExporter(ctx, time.Duration(d.snapshotExportOptions.IntervalSeconds)*time.Second)
+	}
+	if d.diskReplicationOptions.IntervalSeconds > 0 && d.kubeClient != nil && d.dynamicClient != nil && d.getCloud() != nil {
+		go d.runDiskReplicator(ctx, time.Duration(d.diskReplicationOptions.IntervalSeconds)*time.Second)
+	}
+	if d.diskPoolOptions.IntervalSeconds > 0 && d.dynamicClient != nil && d.getCloud() != nil {
+		go d.runDiskPoolReplenisher(ctx, time.Duration(d.diskPoolOptions.IntervalSeconds)*time.Second)
+	}
+	if d.volumeRecommendationOptions.IntervalSeconds > 0 && d.kubeClient != nil && d.dynamicClient != nil && d.getCloud() != nil {
+		go d.runVolumeRecommender(ctx, time.Duration(d.volumeRecommendationOptions.IntervalSeconds)*time.Second)
+	}
+	if d.orphanDiskGCOptions.Enable && d.kubeClient != nil && d.getCloud() != nil {
+		go d.runOrphanDiskGC(ctx, time.Duration(d.orphanDiskGCOptions.IntervalSeconds)*time.Second)
+	}
+}
+
 func (d *Driver) isGetDiskThrottled() bool {
 	cache, err := d.throttlingCache.Get(context.Background(), consts.GetDiskThrottlingKey, azcache.CacheReadTypeDefault)
 	if err != nil {
@@ -404,7 +822,7 @@ func (d *Driver) checkDiskExists(ctx context.Context, diskURI string) (*armcompu
 		return nil, nil
 	}
 	subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-	diskClient, err := d.diskController.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := d.getDiskController().getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return nil, err
 	}
@@ -415,12 +833,12 @@ func (d *Driver) checkDiskExists(ctx context.Context, diskURI string) (*armcompu
 	return disk, nil
 }
 
-func (d *Driver) checkDiskCapacity(ctx context.Context, subsID, resourceGroup, diskName string, requestGiB int) (bool, error) {
+func (d *Driver) checkDiskCapacity(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, diskName string, requestGiB int) (bool, error) {
 	if d.isGetDiskThrottled() {
 		klog.Warningf("skip checkDiskCapacity(%s, %s) since it's still in throttling", resourceGroup, diskName)
 		return true, nil
 	}
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := clientFactory.GetDiskClientForSub(subsID)
 	if err != nil {
 		return false, err
 	}
@@ -449,6 +867,16 @@ func (d *DriverCore) setNodeCapabilities(nodeCaps []*csi.NodeServiceCapability)
 	d.NSCap = nodeCaps
 }
 
+// servesController returns whether the driver serves the controller service
+func (d *DriverCore) servesController() bool {
+	return d.driverRole != consts.DriverRoleNode
+}
+
+// servesNode returns whether the driver serves the node service
+func (d *DriverCore) servesNode() bool {
+	return d.driverRole != consts.DriverRoleController
+}
+
 // setName sets the Name field. It is intended for use with unit tests.
 func (d *DriverCore) setName(name string) {
 	d.Name = name
@@ -459,19 +887,74 @@ func (d *DriverCore) setNodeID(nodeID string) {
 	d.NodeID = nodeID
 }
 
+// setDriverRole sets the driverRole field. It is intended for use with unit tests.
+func (d *DriverCore) setDriverRole(role string) {
+	d.driverRole = role
+}
+
 // setName sets the Version field. It is intended for use with unit tests.
 func (d *DriverCore) setVersion(version string) {
 	d.Version = version
 }
 
-// getCloud returns the value of the cloud field. It is intended for use with unit tests.
+// getCloud returns the value of the cloud field.
 func (d *DriverCore) getCloud() *azure.Cloud {
+	d.cloudLock.RLock()
+	defer d.cloudLock.RUnlock()
 	return d.cloud
 }
 
 // setCloud sets the cloud field. It is intended for use with unit tests.
 func (d *DriverCore) setCloud(cloud *azure.Cloud) {
+	d.cloudLock.Lock()
+	defer d.cloudLock.Unlock()
+	d.cloud = cloud
+}
+
+// setKubeClient sets the kubeClient field. It is intended for use with unit tests.
+func (d *DriverCore) setKubeClient(kubeClient kubernetes.Interface) {
+	d.kubeClient = kubeClient
+}
+
+// setPVCLister sets the pvcLister and pvcListerSynced fields. It is intended for use with unit tests.
+func (d *DriverCore) setPVCLister(pvcLister corelisters.PersistentVolumeClaimLister, pvcListerSynced cache.InformerSynced) {
+	d.pvcLister = pvcLister
+	d.pvcListerSynced = pvcListerSynced
+}
+
+// getClientFactory returns the value of the clientFactory field.
+func (d *DriverCore) getClientFactory() azclient.ClientFactory {
+	d.cloudLock.RLock()
+	defer d.cloudLock.RUnlock()
+	return d.clientFactory
+}
+
+// getDiskController returns the value of the diskController field.
+func (d *DriverCore) getDiskController() *ManagedDiskController {
+	d.cloudLock.RLock()
+	defer d.cloudLock.RUnlock()
+	return d.diskController
+}
+
+// swapCloud replaces the cloud and its client factory and drops the disk controllers of the other resource groups.
+// The disk controller of the cloud is kept with its VM locks and the attach/detach requests waiting for a batch,
+// only its cloud is replaced, operations in progress keep using the previous cloud
+func (d *DriverCore) swapCloud(cloud *azure.Cloud) {
+	d.cloudLock.Lock()
+	defer d.cloudLock.Unlock()
+	if d.diskController == nil {
+		d.diskController = d.newDiskController(cloud)
+	} else {
+		d.configureCloud(cloud)
+		d.diskController.setCloud(cloud)
+	}
 	d.cloud = cloud
+	d.clientFactory = cloud.ComputeClientFactory
+	// the disk controllers of the other resource groups are created again from the new cloud config on first use
+	d.nodeResourceGroupDiskControllers.Range(func(key, _ interface{}) bool {
+		d.nodeResourceGroupDiskControllers.Delete(key)
+		return true
+	})
 }
 
 // getMounter returns the value of the mounter field. It is intended for use with unit tests.
@@ -509,8 +992,8 @@ func (d *DriverCore) getHostUtil() hostUtil {
 }
 
 // getSnapshotCompletionPercent returns the completion percent of snapshot
-func (d *DriverCore) getSnapshotCompletionPercent(ctx context.Context, subsID, resourceGroup, snapshotName string) (float32, error) {
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
+func (d *DriverCore) getSnapshotCompletionPercent(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, snapshotName string) (float32, error) {
+	snapshotClient, err := clientFactory.GetSnapshotClientForSub(subsID)
 	if err != nil {
 		return 0.0, err
 	}
@@ -519,6 +1002,10 @@ func (d *DriverCore) getSnapshotCompletionPercent(ctx context.Context, subsID, r
 		return 0.0, err
 	}
 
+	if copySnapshot.Properties != nil && copySnapshot.Properties.CopyCompletionError != nil {
+		return 0.0, fmt.Errorf("copy of snapshot(%s) under rg(%s) failed: %s", snapshotName, resourceGroup, azureutils.GetCopyCompletionErrorMessage(copySnapshot.Properties.CopyCompletionError))
+	}
+
 	if copySnapshot.Properties == nil || copySnapshot.Properties.CompletionPercent == nil {
 		// If CompletionPercent is nil, it means the snapshot is complete
 		klog.V(2).Infof("snapshot(%s) under rg(%s) has no SnapshotProperties or CompletionPercent is nil", snapshotName, resourceGroup)
@@ -529,8 +1016,8 @@ func (d *DriverCore) getSnapshotCompletionPercent(ctx context.Context, subsID, r
 }
 
 // waitForSnapshotReady wait for completionPercent of snapshot is 100.0
-func (d *DriverCore) waitForSnapshotReady(ctx context.Context, subsID, resourceGroup, snapshotName string, intervel, timeout time.Duration) error {
-	completionPercent, err := d.getSnapshotCompletionPercent(ctx, subsID, resourceGroup, snapshotName)
+func (d *DriverCore) waitForSnapshotReady(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, snapshotName string, intervel, timeout time.Duration) error {
+	completionPercent, err := d.getSnapshotCompletionPercent(ctx, clientFactory, subsID, resourceGroup, snapshotName)
 	if err != nil {
 		return err
 	}
@@ -545,7 +1032,7 @@ func (d *DriverCore) waitForSnapshotReady(ctx context.Context, subsID, resourceG
 	for {
 		select {
 		case <-timeTick:
-			completionPercent, err = d.getSnapshotCompletionPercent(ctx, subsID, resourceGroup, snapshotName)
+			completionPercent, err = d.getSnapshotCompletionPercent(ctx, clientFactory, subsID, resourceGroup, snapshotName)
 			if err != nil {
 				return err
 			}
@@ -557,13 +1044,15 @@ func (d *DriverCore) waitForSnapshotReady(ctx context.Context, subsID, resourceG
 			klog.V(2).Infof("snapshot(%s) under rg(%s) completionPercent: %f", snapshotName, resourceGroup, completionPercent)
 		case <-timeAfter:
 			return fmt.Errorf("timeout waiting for snapshot(%s) under rg(%s)", snapshotName, resourceGroup)
+		case <-ctx.Done():
+			return fmt.Errorf("stop waiting for snapshot(%s) under rg(%s): %w", snapshotName, resourceGroup, ctx.Err())
 		}
 	}
 }
 
 // getUsedLunsFromVolumeAttachments returns a list of used luns from VolumeAttachments
 func (d *DriverCore) getUsedLunsFromVolumeAttachments(ctx context.Context, nodeName string) ([]int, error) {
-	kubeClient := d.cloud.KubeClient
+	kubeClient := d.getCloud().KubeClient
 	if kubeClient == nil || kubeClient.StorageV1() == nil || kubeClient.StorageV1().VolumeAttachments() == nil {
 		return nil, fmt.Errorf("kubeClient or kubeClient.StorageV1() or kubeClient.StorageV1().VolumeAttachments() is nil")
 	}
@@ -599,8 +1088,12 @@ func (d *DriverCore) getUsedLunsFromVolumeAttachments(ctx context.Context, nodeN
 }
 
 // getUsedLunsFromNode returns a list of sorted used luns from Node
-func (d *DriverCore) getUsedLunsFromNode(nodeName types.NodeName) ([]int, error) {
-	disks, _, err := d.diskController.GetNodeDataDisks(nodeName, azcache.CacheReadTypeDefault)
+func (d *DriverCore) getUsedLunsFromNode(ctx context.Context, nodeName types.NodeName) ([]int, error) {
+	diskController, err := d.getNodeDiskController(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	disks, _, err := diskController.GetNodeDataDisks(nodeName, azcache.CacheReadTypeDefault)
 	if err != nil {
 		klog.Errorf("error of getting data disks for node %s: %v", nodeName, err)
 		return nil, err
@@ -618,6 +1111,196 @@ func (d *DriverCore) getUsedLunsFromNode(nodeName types.NodeName) ([]int, error)
 	return usedLuns, nil
 }
 
+// isSystemCriticalVolume returns true if the volume belongs to a PVC in one of the system-critical namespaces,
+// or the PVC is annotated with disk.csi.azure.com/volume-priority: system-critical. The PVC is read from the PVC
+// informer cache, which is only started with --enable-volume-priority-annotation or --enable-pvc-zone-annotation,
+// the volume is not system-critical if the cache is not synced yet.
+func (d *DriverCore) isSystemCriticalVolume(volumeContext map[string]string, disk *armcompute.Disk) bool {
+	pvcName := volumeContext[consts.PvcNameKey]
+	pvcNamespace := volumeContext[consts.PvcNamespaceKey]
+	if disk != nil {
+		// fall back to the tags of the disk for static provisioned volumes
+		if pvcName == "" {
+			pvcName = ptr.Deref(disk.Tags[consts.PvcNameTag], "")
+		}
+		if pvcNamespace == "" {
+			pvcNamespace = ptr.Deref(disk.Tags[consts.PvcNamespaceTag], "")
+		}
+	}
+	if pvcNamespace == "" {
+		return false
+	}
+	if d.systemCriticalNamespaces[strings.ToLower(pvcNamespace)] {
+		return true
+	}
+	if !d.enableVolumePriorityAnnotation || pvcName == "" || d.pvcLister == nil {
+		return false
+	}
+	if d.pvcListerSynced != nil && !d.pvcListerSynced() {
+		klog.Warningf("PVC informer is not synced yet, treat the volume of pvc(%s/%s) as not system-critical", pvcNamespace, pvcName)
+		return false
+	}
+	pvc, err := d.pvcLister.PersistentVolumeClaims(pvcNamespace).Get(pvcName)
+	if err != nil {
+		klog.Warningf("get pvc(%s/%s) failed with %v, treat the volume as not system-critical", pvcNamespace, pvcName, err)
+		return false
+	}
+	return strings.EqualFold(pvc.Annotations[consts.VolumePriorityAnnotation], consts.SystemCriticalVolumePriority)
+}
+
+// startPVCInformer starts the informer of the PVCs read by CreateVolume and ControllerPublishVolume, so that they do
+// not get the PVC of every volume from the API server, it is only started if any of the PVC annotations is enabled
+func (d *DriverCore) startPVCInformer(ctx context.Context) {
+	factory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	informer := factory.Core().V1().PersistentVolumeClaims()
+	d.pvcLister = informer.Lister()
+	d.pvcListerSynced = informer.Informer().HasSynced
+	factory.Start(ctx.Done())
+}
+
+// startPVInformer starts the informer of the PVs read by ControllerPublishVolume and the disk properties reconciler,
+// so that they do not get the PV of every volume from the API server
+func (d *DriverCore) startPVInformer(ctx context.Context) cache.SharedIndexInformer {
+	factory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	informer := factory.Core().V1().PersistentVolumes()
+	d.pvLister = informer.Lister()
+	d.pvListerSynced = informer.Informer().HasSynced
+	factory.Start(ctx.Done())
+	return informer.Informer()
+}
+
+// startNodeInformer starts the informer of the nodes read by the PVC validator and the node class performance of
+// ControllerPublishVolume, so that they do not get the nodes from the API server
+func (d *Driver) startNodeInformer(ctx context.Context) {
+	factory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	informer := factory.Core().V1().Nodes()
+	d.nodeLister = informer.Lister()
+	d.nodeListerSynced = informer.Informer().HasSynced
+	factory.Start(ctx.Done())
+}
+
+// getPVCAvailabilityZone returns the zone in the disk.csi.azure.com/zone annotation of the PVC of the volume, which
+// overrides the zone picked from the accessibility requirements, an empty zone is returned if the PVC is unknown or
+// not annotated. The PVC is read from the API server if the informer cache is not synced yet or misses it, since
+// CreateVolume could come right after the PVC is created. With WaitForFirstConsumer binding, the zone must be the
+// zone of the node selected by the scheduler, otherwise the pod could never attach the disk.
+func (d *DriverCore) getPVCAvailabilityZone(ctx context.Context, pvcNamespace, pvcName string, requirement *csi.TopologyRequirement, region string) (string, error) {
+	if !d.enablePVCZoneAnnotation || pvcName == "" || pvcNamespace == "" || d.pvcLister == nil {
+		return "", nil
+	}
+	var pvc *corev1.PersistentVolumeClaim
+	var err error
+	if d.pvcListerSynced == nil || d.pvcListerSynced() {
+		pvc, err = d.pvcLister.PersistentVolumeClaims(pvcNamespace).Get(pvcName)
+	}
+	if pvc == nil && (err == nil || apierrors.IsNotFound(err)) {
+		if d.kubeClient == nil {
+			return "", status.Errorf(codes.Unavailable, "pvc(%s/%s) is not found in the PVC informer cache", pvcNamespace, pvcName)
+		}
+		pvc, err = d.kubeClient.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+	}
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "get pvc(%s/%s) failed with %v", pvcNamespace, pvcName, err)
+	}
+	zone, ok := pvc.Annotations[consts.DiskZoneAnnotation]
+	if !ok {
+		return "", nil
+	}
+	zone, err = azureutils.GetRequestedAvailabilityZone(zone, requirement, region, topologyKey)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s annotation of pvc(%s/%s): %v", consts.DiskZoneAnnotation, pvcNamespace, pvcName, err)
+	}
+	// the first preferred topology is the one of the selected node
+	if selectedNode := pvc.Annotations[selectedNodeAnnotation]; selectedNode != "" && len(requirement.GetPreferred()) > 0 {
+		selectedZone := azureutils.PickAvailabilityZone(&csi.TopologyRequirement{Preferred: requirement.GetPreferred()[:1]}, region, topologyKey)
+		if !strings.EqualFold(selectedZone, zone) {
+			return "", status.Errorf(codes.InvalidArgument, "zone %s in %s annotation of pvc(%s/%s) conflicts with zone %q of the selected node %s",
+				zone, consts.DiskZoneAnnotation, pvcNamespace, pvcName, selectedZone, selectedNode)
+		}
+	}
+	return zone, nil
+}
+
+// getPVNameForDisk returns the name of the PV of the disk from the volume context, falling back to the tags of the disk
+func getPVNameForDisk(volumeContext map[string]string, disk *armcompute.Disk) string {
+	if pvName := volumeContext[consts.PvNameKey]; pvName != "" {
+		return pvName
+	}
+	if disk != nil {
+		return ptr.Deref(disk.Tags[consts.PvNameTag], "")
+	}
+	return ""
+}
+
+// checkVolumeMaintenance returns a FailedPrecondition error if the PV is fenced by the maintenance annotation, the
+// annotation holds an RFC3339 time until which new publishes are blocked. An annotation which is not an RFC3339 time
+// does not fence the volume. The PV is read from the informer cache, failures to get it are only logged.
+func (d *DriverCore) checkVolumeMaintenance(pvName string) error {
+	if !d.enableVolumeMaintenance || pvName == "" || d.pvLister == nil {
+		return nil
+	}
+	if d.pvListerSynced != nil && !d.pvListerSynced() {
+		klog.Warningf("PV informer is not synced yet, skip checking the maintenance annotation of pv(%s)", pvName)
+		return nil
+	}
+	pv, err := d.pvLister.Get(pvName)
+	if err != nil {
+		klog.V(4).Infof("could not get pv(%s) to check maintenance annotation: %v", pvName, err)
+		return nil
+	}
+	until, ok := pv.Annotations[consts.VolumeMaintenanceAnnotation]
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		klog.Warningf("ignore annotation %s(%s) of pv(%s) which is not an RFC3339 time: %v", consts.VolumeMaintenanceAnnotation, until, pvName, err)
+		return nil
+	}
+	if time.Now().After(t) {
+		return nil
+	}
+	return status.Errorf(codes.FailedPrecondition, "volume under maintenance until %s (annotation %s on pv %s)", until, consts.VolumeMaintenanceAnnotation, pvName)
+}
+
+// parseSystemCriticalNamespaces parses a comma separated list of namespaces
+func parseSystemCriticalNamespaces(namespaces string) map[string]bool {
+	result := map[string]bool{}
+	for _, ns := range strings.Split(namespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			result[strings.ToLower(ns)] = true
+		}
+	}
+	return result
+}
+
+// newClientRateLimitOptions returns the Azure API client rate limits and throttling policy from driver options
+func newClientRateLimitOptions(options *DriverOptions) *azureutils.ClientRateLimitOptions {
+	return &azureutils.ClientRateLimitOptions{
+		DiskQPS:       float32(options.DiskClientQPS),
+		DiskBurst:     options.DiskClientBurst,
+		VMQPS:         float32(options.VMClientQPS),
+		VMBurst:       options.VMClientBurst,
+		VMSSQPS:       float32(options.VMSSClientQPS),
+		VMSSBurst:     options.VMSSClientBurst,
+		SnapshotQPS:   float32(options.SnapshotClientQPS),
+		SnapshotBurst: options.SnapshotClientBurst,
+		ThrottlingPolicy: azureutils.NewThrottlingPolicy(options.ARMThrottlingThreshold,
+			time.Duration(options.ARMThrottlingMaxBackoffSeconds)*time.Second),
+	}
+}
+
+// withOperationTimeout returns a context bounded by timeoutInSeconds, ctx is returned as is if timeoutInSeconds is not positive
+func withOperationTimeout(ctx context.Context, timeoutInSeconds int64) (context.Context, context.CancelFunc) {
+	if timeoutInSeconds <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(timeoutInSeconds)*time.Second)
+}
+
 // getNodeInfoFromLabels get zone, instanceType from node labels
 func getNodeInfoFromLabels(ctx context.Context, nodeName string, kubeClient clientset.Interface) (string, string, error) {
 	if kubeClient == nil || kubeClient.CoreV1() == nil {
@@ -0,0 +1,141 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DriverConfigAPIVersion is the only supported version of the driver configuration file
+	DriverConfigAPIVersion = "disk.csi.azure.com/v1alpha1"
+	// DriverConfigKind is the kind of the driver configuration file
+	DriverConfigKind = "DriverConfiguration"
+	// ConfigFileFlag is the flag of the driver configuration file, which could not be set in the file itself
+	ConfigFileFlag = "config"
+)
+
+// DriverConfig is the driver configuration file, options are keyed by the names of the equivalent flags, e.g.
+//
+//	apiVersion: disk.csi.azure.com/v1alpha1
+//	kind: DriverConfiguration
+//	options:
+//	  drivername: disk.csi.azure.com
+//	  enable-perf-optimization: true
+type DriverConfig struct {
+	APIVersion string                     `json:"apiVersion"`
+	Kind       string                     `json:"kind"`
+	Options    map[string]json.RawMessage `json:"options"`
+}
+
+// LoadConfigFile sets the flags of fs which are not set on the command line by the options in the driver configuration
+// file of path, so the flags keep working and override the file. Options not in the file keep the default values of
+// the flags. An unknown option or an invalid value is an error.
+func LoadConfigFile(path string, fs *flag.FlagSet) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read driver configuration file %s: %w", path, err)
+	}
+	values, err := parseDriverConfig(data)
+	if err != nil {
+		return fmt.Errorf("invalid driver configuration file %s: %w", path, err)
+	}
+
+	setOnCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		if name == ConfigFileFlag || fs.Lookup(name) == nil {
+			return fmt.Errorf("invalid driver configuration file %s: unknown option %q", path, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if setOnCommandLine[name] {
+			klog.Warningf("option %s in driver configuration file %s is overridden by the command line flag", name, path)
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid driver configuration file %s: invalid value %q of option %s: %w", path, values[name], name, err)
+		}
+	}
+	return nil
+}
+
+// parseDriverConfig returns the option values of the driver configuration file data in the format of the flags
+func parseDriverConfig(data []byte) (map[string]string, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	config := DriverConfig{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	if config.APIVersion != DriverConfigAPIVersion {
+		return nil, fmt.Errorf("unsupported apiVersion %q, supported: %s", config.APIVersion, DriverConfigAPIVersion)
+	}
+	if config.Kind != DriverConfigKind {
+		return nil, fmt.Errorf("unsupported kind %q, supported: %s", config.Kind, DriverConfigKind)
+	}
+
+	values := make(map[string]string, len(config.Options))
+	for name, raw := range config.Options {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		// keep large integers as they are instead of converting them to float64
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		switch v := value.(type) {
+		case string:
+			values[name] = v
+		case bool, json.Number:
+			values[name] = fmt.Sprint(v)
+		case []interface{}:
+			// list values are accepted for the comma separated flags
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				switch item.(type) {
+				case map[string]interface{}, []interface{}, nil:
+					return nil, fmt.Errorf("value of option %s must be a scalar or a list of scalars", name)
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("value of option %s must be a scalar or a list of scalars", name)
+		}
+	}
+	return values, nil
+}
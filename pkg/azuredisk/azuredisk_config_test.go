@@ -0,0 +1,108 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeTestConfigFile(t, `
+apiVersion: disk.csi.azure.com/v1alpha1
+kind: DriverConfiguration
+options:
+  drivername: test.csi.azure.com
+  enable-perf-optimization: true
+  volume-attach-limit: 16
+  max-concurrent-format: 4
+  disk-client-qps: 2.5
+  system-critical-namespaces: [kube-system, monitoring]
+`)
+	o := &DriverOptions{}
+	fs := o.AddFlags()
+	require.NoError(t, fs.Parse([]string{"--max-concurrent-format=8"}))
+	require.NoError(t, LoadConfigFile(path, fs))
+
+	assert.Equal(t, "test.csi.azure.com", o.DriverName)
+	assert.True(t, o.EnablePerfOptimization)
+	assert.Equal(t, int64(16), o.VolumeAttachLimit)
+	assert.Equal(t, 2.5, o.DiskClientQPS)
+	assert.Equal(t, "kube-system,monitoring", o.SystemCriticalNamespaces)
+	assert.Equal(t, int64(8), o.MaxConcurrentFormat, "flags override the config file")
+	assert.Equal(t, "unix://tmp/csi.sock", o.Endpoint, "default of the flag is kept")
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		content string
+	}{
+		{
+			desc:    "unsupported apiVersion",
+			content: "apiVersion: disk.csi.azure.com/v2\nkind: DriverConfiguration\n",
+		},
+		{
+			desc:    "unsupported kind",
+			content: "apiVersion: disk.csi.azure.com/v1alpha1\nkind: Config\n",
+		},
+		{
+			desc:    "unknown field",
+			content: "apiVersion: disk.csi.azure.com/v1alpha1\nkind: DriverConfiguration\nflags: {}\n",
+		},
+		{
+			desc:    "unknown option",
+			content: "apiVersion: disk.csi.azure.com/v1alpha1\nkind: DriverConfiguration\noptions:\n  unknown-option: true\n",
+		},
+		{
+			desc:    "config file in the config file",
+			content: "apiVersion: disk.csi.azure.com/v1alpha1\nkind: DriverConfiguration\noptions:\n  config: /etc/config.yaml\n",
+		},
+		{
+			desc:    "invalid value",
+			content: "apiVersion: disk.csi.azure.com/v1alpha1\nkind: DriverConfiguration\noptions:\n  volume-attach-limit: many\n",
+		},
+		{
+			desc:    "nested value",
+			content: "apiVersion: disk.csi.azure.com/v1alpha1\nkind: DriverConfiguration\noptions:\n  drivername:\n    name: test\n",
+		},
+		{
+			desc:    "invalid YAML",
+			content: "apiVersion: [",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			o := &DriverOptions{}
+			fs := o.AddFlags()
+			fs.String(ConfigFileFlag, "", "")
+			assert.Error(t, LoadConfigFile(writeTestConfigFile(t, test.content), fs))
+		})
+	}
+
+	assert.Error(t, LoadConfigFile(filepath.Join(t.TempDir(), "not-found.yaml"), (&DriverOptions{}).AddFlags()))
+}
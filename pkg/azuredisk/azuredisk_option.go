@@ -18,6 +18,7 @@ package azuredisk
 
 import (
 	"flag"
+	"fmt"
 
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 )
@@ -27,6 +28,7 @@ type DriverOptions struct {
 	// Common options
 	NodeID                     string
 	DriverName                 string
+	DriverRole                 string
 	VolumeAttachLimit          int64
 	ReservedDataDiskSlotNum    int64
 	EnablePerfOptimization     bool
@@ -36,33 +38,94 @@ type DriverOptions struct {
 	UserAgentSuffix            string
 	UseCSIProxyGAInterface     bool
 	EnableOtelTracing          bool
+	OtelExporterEndpoint       string
+	OtelExporterInsecure       bool
+	OtelTraceSampleRatio       float64
+	EnableAuditLog             bool
+	AuditLogPath               string
+	AuditLogMaxSizeMB          int
+	AuditLogMaxBackups         int
+	DiskClientQPS              float64
+	DiskClientBurst            int
+	VMClientQPS                float64
+	VMClientBurst              int
+	VMSSClientQPS              float64
+	VMSSClientBurst            int
+	SnapshotClientQPS          float64
+	SnapshotClientBurst        int
 
 	//only used in v1
-	EnableDiskOnlineResize       bool
-	AllowEmptyCloudConfig        bool
-	EnableListVolumes            bool
-	EnableListSnapshots          bool
-	SupportZone                  bool
-	GetNodeInfoFromLabels        bool
-	EnableDiskCapacityCheck      bool
-	DisableUpdateCache           bool
-	EnableTrafficManager         bool
-	TrafficManagerPort           int64
-	AttachDetachInitialDelayInMs int64
-	VMSSCacheTTLInSeconds        int64
-	VolStatsCacheExpireInMinutes int64
-	VMType                       string
-	EnableWindowsHostProcess     bool
-	GetNodeIDFromIMDS            bool
-	WaitForSnapshotReady         bool
-	CheckDiskLUNCollision        bool
-	ForceDetachBackoff           bool
-	Kubeconfig                   string
-	Endpoint                     string
-	DisableAVSetNodes            bool
-	RemoveNotReadyTaint          bool
-	MaxConcurrentFormat          int64
-	ConcurrentFormatTimeout      int64
+	EnableDiskOnlineResize          bool
+	AllowEmptyCloudConfig           bool
+	EnableListVolumes               bool
+	EnableListSnapshots             bool
+	SupportZone                     bool
+	GetNodeInfoFromLabels           bool
+	EnableDiskCapacityCheck         bool
+	DisableUpdateCache              bool
+	EnableTrafficManager            bool
+	TrafficManagerPort              int64
+	AttachDetachInitialDelayInMs    int64
+	VMSSCacheTTLInSeconds           int64
+	VolStatsCacheExpireInMinutes    int64
+	VMType                          string
+	EnableWindowsHostProcess        bool
+	GetNodeIDFromIMDS               bool
+	WaitForSnapshotReady            bool
+	CheckDiskLUNCollision           bool
+	ForceDetachBackoff              bool
+	Kubeconfig                      string
+	Endpoint                        string
+	TLSEndpoint                     string
+	TLSCertDir                      string
+	DisableAVSetNodes               bool
+	RemoveNotReadyTaint             bool
+	MaxConcurrentFormat             int64
+	ConcurrentFormatTimeout         int64
+	SystemCriticalNamespaces        string
+	FsFreezePort                    int64
+	EnableDiskPropertiesAnnotations bool
+	EnablePVCZoneAnnotation         bool
+	EnableVolumePriorityAnnotation  bool
+	EnableVolumeMaintenance         bool
+	EnableNodeClassPerformance      bool
+	AttachTimeoutInSeconds          int64
+	DetachTimeoutInSeconds          int64
+	ForceDetachTimeoutInSeconds     int64
+	DeadlineBudgetMarginSeconds     int64
+	CreateVolumeTimeoutInSeconds    int64
+	DeleteVolumeTimeoutInSeconds    int64
+	EnableVolumeMountGroup          bool
+	PerfProfilesConfigFile          string
+	CloudConfigReloadSeconds        int64
+	NormalizeAdoptedDisks           bool
+	AdoptedDiskTagCleanupPrefixes   string
+	EnableVolumeMetrics             bool
+	EnableDiskThroughputHints       bool
+	PublishContextCacheDir          string
+	DeviceSettingsReconcileSeconds  int64
+	MaxAttachConcurrencyPerNode     int64
+	NotificationConfigFile          string
+	AttachSLOSeconds                int64
+	SocketWatchdogSeconds           int64
+	ARMThrottlingThreshold          int
+	ARMThrottlingMaxBackoffSeconds  int64
+	NodeResourceGroupMap            string
+
+	// options of the features only run by the controller
+	PVCMutationWebhook   PVCMutationWebhookOptions
+	MutationBudget       MutationBudgetOptions
+	LeaderElection       LeaderElectionOptions
+	PVCValidation        PVCValidationOptions
+	PVNodeAffinity       PVNodeAffinityOptions
+	SnapshotRetention    SnapshotRetentionOptions
+	VolumePopulator      VolumePopulatorOptions
+	TagReconcile         TagReconcileOptions
+	SnapshotExport       SnapshotExportOptions
+	DiskReplication      DiskReplicationOptions
+	DiskPool             DiskPoolOptions
+	VolumeRecommendation VolumeRecommendationOptions
+	OrphanDiskGC         OrphanDiskGCOptions
 }
 
 func (o *DriverOptions) AddFlags() *flag.FlagSet {
@@ -72,6 +135,7 @@ func (o *DriverOptions) AddFlags() *flag.FlagSet {
 	fs := flag.NewFlagSet("", flag.ExitOnError)
 	fs.StringVar(&o.NodeID, "nodeid", "", "node id")
 	fs.StringVar(&o.DriverName, "drivername", consts.DefaultDriverName, "name of the driver")
+	fs.StringVar(&o.DriverRole, "driver-role", "", "role of the driver, controller serves the identity and controller services, node serves the identity and node services, all services are served if empty")
 	fs.Int64Var(&o.VolumeAttachLimit, "volume-attach-limit", -1, "maximum number of attachable volumes per node")
 	fs.Int64Var(&o.ReservedDataDiskSlotNum, "reserved-data-disk-slot-num", 0, "reserved data disk slot number per node")
 	fs.BoolVar(&o.EnablePerfOptimization, "enable-perf-optimization", false, "boolean flag to enable disk perf optimization")
@@ -81,6 +145,21 @@ func (o *DriverOptions) AddFlags() *flag.FlagSet {
 	fs.StringVar(&o.UserAgentSuffix, "user-agent-suffix", "", "userAgent suffix")
 	fs.BoolVar(&o.UseCSIProxyGAInterface, "use-csiproxy-ga-interface", true, "boolean flag to enable csi-proxy GA interface on Windows")
 	fs.BoolVar(&o.EnableOtelTracing, "enable-otel-tracing", false, "If set, enable opentelemetry tracing for the driver. The tracing is disabled by default. Configure the exporter endpoint with OTEL_EXPORTER_OTLP_ENDPOINT and other env variables, see https://opentelemetry.io/docs/specs/otel/configuration/sdk-environment-variables/#general-sdk-configuration.")
+	fs.StringVar(&o.OtelExporterEndpoint, "otel-exporter-endpoint", "", "host:port of the OTLP gRPC collector the traces are exported to, OTEL_EXPORTER_OTLP_ENDPOINT is used if empty")
+	fs.BoolVar(&o.OtelExporterInsecure, "otel-exporter-insecure", false, "boolean flag to disable TLS on the connection to the OTLP collector")
+	fs.Float64Var(&o.OtelTraceSampleRatio, "otel-trace-sample-ratio", 1, "ratio of the sampled traces in (0, 1), the sampler is configured by OTEL_TRACES_SAMPLER if not in this range")
+	fs.BoolVar(&o.EnableAuditLog, "enable-audit-log", false, "boolean flag to write an audit record (sanitized of secrets) with duration and result for every CSI RPC")
+	fs.StringVar(&o.AuditLogPath, "audit-log-path", "", "path of the audit log file, audit records are written to stdout as JSON if empty")
+	fs.IntVar(&o.AuditLogMaxSizeMB, "audit-log-max-size-mb", 100, "maximum size in megabytes of the audit log file before it gets rotated")
+	fs.IntVar(&o.AuditLogMaxBackups, "audit-log-max-backups", 5, "maximum number of rotated audit log files to retain")
+	fs.Float64Var(&o.DiskClientQPS, "disk-client-qps", 0, "QPS of the Azure disk client rate limiter, 0 keeps disk client rate limit disabled")
+	fs.IntVar(&o.DiskClientBurst, "disk-client-burst", 0, "burst of the Azure disk client rate limiter, 0 uses the default bucket size")
+	fs.Float64Var(&o.VMClientQPS, "vm-client-qps", 0, "QPS of the Azure virtual machine client rate limiter, 0 keeps the rate limit in cloud config")
+	fs.IntVar(&o.VMClientBurst, "vm-client-burst", 0, "burst of the Azure virtual machine client rate limiter, 0 uses the default bucket size")
+	fs.Float64Var(&o.VMSSClientQPS, "vmss-client-qps", 0, "QPS of the Azure virtual machine scale set client rate limiter, 0 keeps the rate limit in cloud config")
+	fs.IntVar(&o.VMSSClientBurst, "vmss-client-burst", 0, "burst of the Azure virtual machine scale set client rate limiter, 0 uses the default bucket size")
+	fs.Float64Var(&o.SnapshotClientQPS, "snapshot-client-qps", 0, "QPS of the Azure snapshot client rate limiter, 0 keeps snapshot client rate limit disabled")
+	fs.IntVar(&o.SnapshotClientBurst, "snapshot-client-burst", 0, "burst of the Azure snapshot client rate limiter, 0 uses the default bucket size")
 	//only used in v1
 	fs.BoolVar(&o.EnableDiskOnlineResize, "enable-disk-online-resize", true, "boolean flag to enable disk online resize")
 	fs.BoolVar(&o.AllowEmptyCloudConfig, "allow-empty-cloud-config", true, "Whether allow running driver without cloud config")
@@ -105,8 +184,186 @@ func (o *DriverOptions) AddFlags() *flag.FlagSet {
 	fs.BoolVar(&o.DisableAVSetNodes, "disable-avset-nodes", false, "disable DisableAvailabilitySetNodes in cloud config for controller")
 	fs.BoolVar(&o.RemoveNotReadyTaint, "remove-not-ready-taint", true, "remove NotReady taint from node when node is ready")
 	fs.StringVar(&o.Endpoint, "endpoint", "unix://tmp/csi.sock", "CSI endpoint")
+	fs.StringVar(&o.TLSEndpoint, "tls-endpoint", "", "additional tcp:// endpoint serving the CSI services with mTLS for testing and debugging tools running outside of the pod, disabled if empty")
+	fs.StringVar(&o.TLSCertDir, "tls-cert-dir", "/etc/csi-tls", "directory containing tls.crt and tls.key served by tls-endpoint and ca.crt verifying the client certificates")
 	fs.Int64Var(&o.MaxConcurrentFormat, "max-concurrent-format", 2, "maximum number of concurrent format exec calls")
 	fs.Int64Var(&o.ConcurrentFormatTimeout, "concurrent-format-timeout", 300, "maximum time in seconds duration of a format operation before its concurrency token is released")
+	fs.StringVar(&o.SystemCriticalNamespaces, "system-critical-namespaces", "kube-system", "comma separated list of namespaces whose volumes are attached/detached with system-critical priority")
+	fs.Int64Var(&o.FsFreezePort, "fs-freeze-port", 0, "TCP port of the node plugin filesystem freeze server used by snapshots with fsFreeze enabled, served with mTLS using the certificates in tls-cert-dir, 0 disables it")
+	fs.BoolVar(&o.EnableDiskPropertiesAnnotations, "enable-disk-properties-annotations", false, "boolean flag to write the realized disk properties (sku, tier, zones, encryption, sector size, bursting) into PV annotations")
+	fs.BoolVar(&o.EnablePVCZoneAnnotation, "enable-pvc-zone-annotation", false, "boolean flag to create the disk in the zone set by the disk.csi.azure.com/zone annotation of the PVC")
+	fs.BoolVar(&o.EnableVolumePriorityAnnotation, "enable-volume-priority-annotation", false, "boolean flag to attach/detach the volumes of PVCs annotated with disk.csi.azure.com/volume-priority: system-critical with system-critical priority")
+	fs.BoolVar(&o.EnableVolumeMaintenance, "enable-volume-maintenance", false, "boolean flag to fail ControllerPublishVolume of the volumes whose PVs have the disk.csi.azure.com/maintenance-until annotation set to a later time")
+	fs.BoolVar(&o.EnableNodeClassPerformance, "enable-node-class-performance", false, "boolean flag to change the IOPS and throughput of Ultra and PremiumV2 disks by the class of the node before they are attached, according to the nodeClassLabel parameter of the volume")
+	fs.Int64Var(&o.AttachTimeoutInSeconds, "attach-timeout-seconds", 0, "maximum time in seconds of a disk attach operation in ControllerPublishVolume, 0 means no timeout")
+	fs.Int64Var(&o.DetachTimeoutInSeconds, "detach-timeout-seconds", 0, "maximum time in seconds of a disk detach operation in ControllerUnpublishVolume, 0 means no timeout")
+	fs.Int64Var(&o.ForceDetachTimeoutInSeconds, "force-detach-timeout-seconds", 0, "maximum time in seconds to wait for a disk detach blocked on the VM update before escalating to a force detach, 0 disables the escalation")
+	fs.Int64Var(&o.DeadlineBudgetMarginSeconds, "deadline-budget-margin-seconds", 0, "margin in seconds kept before the deadline set by the CSI sidecar timeout, ARM operations of the controller keep running in the background once the margin is reached and are resumed by the retry of the sidecar, 0 disables it")
+	fs.Int64Var(&o.CreateVolumeTimeoutInSeconds, "create-volume-timeout-seconds", 0, "maximum time in seconds of a disk creation in CreateVolume, 0 means no timeout")
+	fs.Int64Var(&o.DeleteVolumeTimeoutInSeconds, "delete-volume-timeout-seconds", 0, "maximum time in seconds of a disk deletion in DeleteVolume, 0 means no timeout")
+	fs.Int64Var(&o.PVCMutationWebhook.Port, "pvc-mutation-webhook-port", 0, "HTTPS port of the controller webhook setting StorageClass and annotations of new PVCs by namespace labels, 0 disables it")
+	fs.StringVar(&o.PVCMutationWebhook.CertDir, "pvc-mutation-webhook-cert-dir", "/etc/webhook/certs", "directory containing tls.crt and tls.key served by the PVC mutation webhook")
+	fs.StringVar(&o.PVCMutationWebhook.PolicyFile, "pvc-mutation-policy-file", "/etc/webhook/policy/policy.yaml", "path of the policy file of the PVC mutation webhook")
+	fs.BoolVar(&o.EnableVolumeMountGroup, "enable-volume-mount-group", false, "boolean flag to report the VOLUME_MOUNT_GROUP node capability and apply the fsGroup of the pod in NodePublishVolume instead of kubelet, ignored on Windows")
+	fs.StringVar(&o.PerfProfilesConfigFile, "perf-profiles-config-file", "", "path of the YAML file of the named device tuning profiles accepted by the perfProfile parameter, usually mounted from a configmap")
+	fs.Int64Var(&o.DiskReplication.IntervalSeconds, "disk-replication-interval-seconds", 0, "interval in seconds to take the due snapshots of the PVCs selected by AzDiskReplications and check the copies of the snapshots to their destinations, the AzDiskReplication CRD must be installed, 0 disables it")
+	fs.StringVar(&o.DiskReplication.ResourceGroups, "disk-replication-resource-groups", "", "comma separated resource groups the snapshots of AzDiskReplications could be created in, <resource group> in the subscription of the cluster or <subscription ID>/<resource group>, the replications to the other resource groups fail")
+	fs.Int64Var(&o.CloudConfigReloadSeconds, "cloud-config-reload-interval-seconds", 0, "watch the cloud config secret, cloud config file and AZURE_ENVIRONMENT_FILEPATH file for changes and reload the cloud provider without restarting the driver, a failed reload is retried every interval in seconds, 0 disables it")
+	fs.BoolVar(&o.NormalizeAdoptedDisks, "normalize-adopted-disks", false, "boolean flag to apply the driver tags, repair missing kubernetes-created-for tags and fix the caching mode of pre-provisioned disks on their first attach")
+	fs.StringVar(&o.AdoptedDiskTagCleanupPrefixes, "adopted-disk-tag-cleanup-prefixes", "", "comma separated prefixes of the tag keys removed from pre-provisioned disks when normalize-adopted-disks is enabled, e.g. test-,debug-")
+	fs.BoolVar(&o.PVCValidation.Enable, "enable-pvc-validation", false, "boolean flag to validate new pending PVCs against the parameters of their StorageClass, the node zones and the disk quota of the subscription in the controller, failures are reported as PVC events")
+	fs.BoolVar(&o.EnableVolumeMetrics, "enable-volume-metrics", false, "boolean flag to export the usage and IO statistics of the volumes staged on the node keyed by PV name on the metrics address of the node plugin")
+	fs.BoolVar(&o.EnableDiskThroughputHints, "enable-disk-throughput-hints", false, "boolean flag to annotate nodes with their remaining disk IOPS and bandwidth, i.e. the VM size limits minus the provisioned performance of the attached disks, after each attach and detach in the controller")
+	fs.Int64Var(&o.DiskPool.IntervalSeconds, "disk-pool-interval-seconds", 0, "interval in seconds to replenish the available disks of AzDiskPools, StorageClasses with diskPool claim the disks of the pools in CreateVolume, the AzDiskPool CRD must be installed, 0 disables it")
+	fs.Int64Var(&o.VolumeRecommendation.IntervalSeconds, "volume-recommendation-interval-seconds", 0, "interval in seconds to analyze the peak IOPS and throughput of the disks in the last 7 days and the usage of the PVCs of the driver and write the SKU, size and performance recommendations to the AzVolumeRecommendation of each PVC, the AzVolumeRecommendation CRD must be installed, 0 disables it")
+	fs.StringVar(&o.PublishContextCacheDir, "publish-context-cache-dir", "", "node-local directory to persist the publish contexts of the staged volumes, used when kubelet retries NodeStageVolume or NodePublishVolume without the lun, disabled if empty")
+	fs.Int64Var(&o.PVNodeAffinity.ReconcileIntervalSeconds, "pv-node-affinity-reconcile-interval-seconds", 0, "interval in seconds to replace the PVs not in use whose node affinity does not match the zones of their disks any more, e.g. after the disks are converted to ZRS, with PVs of the updated node affinity bound to the same PVCs in the controller, 0 disables it")
+	fs.Int64Var(&o.DeviceSettingsReconcileSeconds, "device-settings-reconcile-interval-seconds", 0, "interval in seconds to re-apply the perf optimization of the devices staged on the node after the perf profile or device settings annotations of their PVs are changed, 0 disables it")
+	fs.Int64Var(&o.MutationBudget.PerSubscription, "mutation-budget-per-subscription", 0, "maximum number of concurrent disk create, delete, attach, detach and resize operations per subscription shared by all controllers using the same leases, 0 disables it")
+	fs.StringVar(&o.MutationBudget.Kubeconfig, "mutation-budget-kubeconfig", "", "absolute path to the kubeconfig file of the cluster holding the mutation budget leases, e.g. a hub cluster shared by multiple clusters, the leases are in the local cluster if empty")
+	fs.StringVar(&o.MutationBudget.Namespace, "mutation-budget-namespace", "kube-system", "namespace of the mutation budget leases")
+	fs.Int64Var(&o.SnapshotRetention.IntervalSeconds, "snapshot-retention-interval-seconds", 0, "interval in seconds to prune the snapshots created by the driver according to the retentionDays and maxSnapshotsPerVolume parameters of their VolumeSnapshotClasses in the controller, 0 disables it")
+	fs.Int64Var(&o.MaxAttachConcurrencyPerNode, "max-attach-concurrency-per-node", 0, "maximum number of in-flight attaches to a node in the controller, the limit of a node is the data disk count of its VM size between 1 and this value, 0 disables it")
+	fs.StringVar(&o.NotificationConfigFile, "notification-config-file", "", "path of the YAML file of the webhook, Slack and Event Grid sinks which critical events are forwarded to in the controller, usually mounted from a configmap, empty disables it")
+	fs.Int64Var(&o.AttachSLOSeconds, "attach-slo-seconds", 0, "record an AttachSLOExceeded warning event on the PV if attaching it takes longer than this in the controller, 0 disables it")
+	fs.Int64Var(&o.SocketWatchdogSeconds, "socket-watchdog-interval-seconds", 30, "interval in seconds to check the endpoint socket of the node and listen on a new socket if it's deleted or stale, 0 disables it")
+	fs.IntVar(&o.ARMThrottlingThreshold, "arm-throttling-threshold", 5, "number of consecutive throttled responses of an ARM API, i.e. the reads or writes of a resource type in a subscription, opening its circuit breaker shared by all Azure clients, the requests of the API fail without being sent while it's open, 0 disables it")
+	fs.Int64Var(&o.ARMThrottlingMaxBackoffSeconds, "arm-throttling-max-backoff-seconds", 300, "maximum duration in seconds the circuit breaker of an ARM API is opened for, the duration starts at 10 seconds and doubles each time it's opened again, a longer Retry-After returned by ARM is honored")
+	fs.BoolVar(&o.VolumePopulator.Enable, "enable-volume-populator", false, "import the disks of the PVCs whose dataSourceRef is an AzDiskImport from the VHD blobs in their sourceURI and create their PVs in the controller, the AzDiskImport CRD must be installed")
+	fs.StringVar(&o.NodeResourceGroupMap, "node-resource-group-map", "", "comma separated list of <node pool>=<resource group> pairs of the node pools whose VMs are in another resource group than the one of the cloud config, e.g. BYO VMSS, the resource group in the provider ID of the node is used for the other node pools")
+	fs.Int64Var(&o.TagReconcile.IntervalSeconds, "tag-reconcile-interval-seconds", 0, "interval in seconds to repair the tags set on the disks of the PVs provisioned by the driver, including the owner tag of the cluster, if they were removed or changed outside of the driver, PVs annotated with disk.csi.azure.com/skip-tag-reconcile=true are skipped, 0 disables it")
+	fs.Float64Var(&o.TagReconcile.QPS, "tag-reconcile-qps", 1, "maximum number of disks checked per second by the tag reconciler")
+	fs.Int64Var(&o.SnapshotExport.IntervalSeconds, "snapshot-export-interval-seconds", 0, "interval in seconds to copy the snapshots of the VolumeSnapshots referenced by AzSnapshotExports to their destination blobs and update the progress of the copies, the AzSnapshotExport CRD must be installed, 0 disables it")
+	fs.StringVar(&o.SnapshotExport.StorageAccounts, "snapshot-export-storage-accounts", "", "comma separated names of the storage accounts the snapshots of AzSnapshotExports without destinationSecretName are copied to with the identity of the driver, the exports to the other storage accounts without destinationSecretName fail")
+	fs.BoolVar(&o.OrphanDiskGC.Enable, "enable-orphan-disk-gc", false, "delete the unattached disks provisioned by the driver with the k8s-azure-dd-owner tag of the cluster which are not referenced by any PV in the controller, the disks of the PVs with Retain policy are tagged with k8s-azure-dd-retain and never deleted")
+	fs.BoolVar(&o.OrphanDiskGC.DryRun, "orphan-disk-gc-dry-run", true, "only log the orphaned disks instead of deleting them")
+	fs.Int64Var(&o.OrphanDiskGC.IntervalSeconds, "orphan-disk-gc-interval-seconds", 3600, "interval in seconds to collect the orphaned disks")
+	fs.Int64Var(&o.OrphanDiskGC.TTLSeconds, "orphan-disk-ttl-seconds", 86400, "minimum age in seconds of an orphaned disk before it's deleted")
+	fs.BoolVar(&o.LeaderElection.Enable, "leader-election", true, "only run the background loops of the controller, e.g. the reconcilers, the snapshot exporter and the orphan disk GC, in the replica holding the lease of the driver, e.g. disk-csi-azure-com-controller")
+	fs.StringVar(&o.LeaderElection.Namespace, "leader-election-namespace", "kube-system", "namespace of the lease of the background loops of the controller")
 
 	return fs
 }
+
+// ValidateDriverRole returns an error if the driver role is unknown or does not match the node ID
+func (o *DriverOptions) ValidateDriverRole() error {
+	switch o.DriverRole {
+	case "":
+	case consts.DriverRoleController:
+		if o.NodeID != "" {
+			return fmt.Errorf("nodeid(%s) must not be set with driver role %s", o.NodeID, o.DriverRole)
+		}
+	case consts.DriverRoleNode:
+		if o.NodeID == "" {
+			return fmt.Errorf("nodeid must be set with driver role %s", o.DriverRole)
+		}
+	default:
+		return fmt.Errorf("unknown driver role %q, supported roles: %s, %s", o.DriverRole, consts.DriverRoleController, consts.DriverRoleNode)
+	}
+	return nil
+}
+
+// PVCMutationWebhookOptions are the options of the webhook setting the StorageClass and annotations of new PVCs
+type PVCMutationWebhookOptions struct {
+	// HTTPS port of the webhook, 0 disables it
+	Port       int64
+	CertDir    string
+	PolicyFile string
+}
+
+// MutationBudgetOptions are the options of the leases limiting the concurrent disk and VM mutations per subscription
+type MutationBudgetOptions struct {
+	// maximum number of concurrent mutations per subscription, 0 disables it
+	PerSubscription int64
+	// kubeconfig of the cluster holding the leases, the local cluster if empty
+	Kubeconfig string
+	Namespace  string
+}
+
+// LeaderElectionOptions are the options of the lease of the background loops of the controller
+type LeaderElectionOptions struct {
+	Enable    bool
+	Namespace string
+}
+
+// PVCValidationOptions are the options of the validator of new pending PVCs
+type PVCValidationOptions struct {
+	Enable bool
+}
+
+// PVNodeAffinityOptions are the options of the reconciler of the node affinity of PVs with the zones of their disks
+type PVNodeAffinityOptions struct {
+	// 0 disables it
+	ReconcileIntervalSeconds int64
+}
+
+// SnapshotRetentionOptions are the options of the pruning of snapshots by their VolumeSnapshotClasses
+type SnapshotRetentionOptions struct {
+	// 0 disables it
+	IntervalSeconds int64
+}
+
+// VolumePopulatorOptions are the options of the populator of the PVCs whose data source is an AzDiskImport
+type VolumePopulatorOptions struct {
+	Enable bool
+}
+
+// TagReconcileOptions are the options of the reconciler of the tags of the disks provisioned by the driver
+type TagReconcileOptions struct {
+	// 0 disables it
+	IntervalSeconds int64
+	// maximum number of disks checked per second
+	QPS float64
+}
+
+func (o *TagReconcileOptions) validate() error {
+	if o.IntervalSeconds > 0 && o.QPS <= 0 {
+		return fmt.Errorf("tag-reconcile-qps(%v) must be positive", o.QPS)
+	}
+	return nil
+}
+
+// SnapshotExportOptions are the options of the copies of the snapshots of AzSnapshotExports to blobs
+type SnapshotExportOptions struct {
+	// 0 disables it
+	IntervalSeconds int64
+	// comma separated storage accounts written by the identity of the driver
+	StorageAccounts string
+}
+
+// DiskReplicationOptions are the options of the snapshots of the PVCs selected by AzDiskReplications
+type DiskReplicationOptions struct {
+	// 0 disables it
+	IntervalSeconds int64
+	// comma separated resource groups the snapshots could be created in
+	ResourceGroups string
+}
+
+// DiskPoolOptions are the options of the replenishment of the available disks of AzDiskPools
+type DiskPoolOptions struct {
+	// 0 disables it
+	IntervalSeconds int64
+}
+
+// VolumeRecommendationOptions are the options of the analyzer of the volumes writing AzVolumeRecommendations
+type VolumeRecommendationOptions struct {
+	// 0 disables it
+	IntervalSeconds int64
+}
+
+// OrphanDiskGCOptions are the options of the collection of the disks not referenced by any PV
+type OrphanDiskGCOptions struct {
+	Enable bool
+	// only report the orphaned disks instead of deleting them
+	DryRun          bool
+	IntervalSeconds int64
+	// age of an orphaned disk before it's deleted
+	TTLSeconds int64
+}
+
+func (o *OrphanDiskGCOptions) validate() error {
+	if o.Enable && (o.IntervalSeconds <= 0 || o.TTLSeconds <= 0) {
+		return fmt.Errorf("orphan-disk-gc-interval-seconds(%d) and orphan-disk-ttl-seconds(%d) must be positive", o.IntervalSeconds, o.TTLSeconds)
+	}
+	return nil
+}
@@ -20,18 +20,55 @@ import (
 	"flag"
 	"reflect"
 	"testing"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 )
 
 func TestDriverOptions_AddFlags(t *testing.T) {
 	o := &DriverOptions{}
-	typeInfo := reflect.TypeOf(*o)
+	// the fields of the options structs of the features are flags as well
+	var numFields func(typeInfo reflect.Type) int
+	numFields = func(typeInfo reflect.Type) int {
+		n := 0
+		for i := 0; i < typeInfo.NumField(); i++ {
+			if field := typeInfo.Field(i).Type; field.Kind() == reflect.Struct {
+				n += numFields(field)
+			} else {
+				n++
+			}
+		}
+		return n
+	}
+	want := numFields(reflect.TypeOf(*o))
 
 	got := o.AddFlags()
 	count := 0
 	got.VisitAll(func(_ *flag.Flag) {
 		count++
 	})
-	if count != typeInfo.NumField() {
-		t.Errorf("DriverOptions.AddFlags() = %v, want %v", count, typeInfo.NumField())
+	if count != want {
+		t.Errorf("DriverOptions.AddFlags() = %v, want %v", count, want)
+	}
+}
+
+func TestDriverOptions_ValidateDriverRole(t *testing.T) {
+	tests := []struct {
+		role      string
+		nodeID    string
+		expectErr bool
+	}{
+		{role: "", nodeID: ""},
+		{role: "", nodeID: "node"},
+		{role: consts.DriverRoleController, nodeID: ""},
+		{role: consts.DriverRoleController, nodeID: "node", expectErr: true},
+		{role: consts.DriverRoleNode, nodeID: "node"},
+		{role: consts.DriverRoleNode, nodeID: "", expectErr: true},
+		{role: "manager", nodeID: "", expectErr: true},
+	}
+	for _, test := range tests {
+		o := &DriverOptions{DriverRole: test.role, NodeID: test.nodeID}
+		if err := o.ValidateDriverRole(); (err != nil) != test.expectErr {
+			t.Errorf("ValidateDriverRole(role: %q, nodeID: %q) = %v, expectErr: %v", test.role, test.nodeID, err, test.expectErr)
+		}
 	}
 }
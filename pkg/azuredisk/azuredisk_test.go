@@ -27,14 +27,22 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/utils/ptr"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/snapshotclient/mock_snapshotclient"
@@ -43,7 +51,7 @@ import (
 )
 
 func TestNewDriverV1(t *testing.T) {
-	d := newDriverV1(&DriverOptions{
+	d, err := newDriverV1(&DriverOptions{
 		NodeID:                 os.Getenv("nodeid"),
 		DriverName:             consts.DefaultDriverName,
 		VolumeAttachLimit:      16,
@@ -51,7 +59,22 @@ func TestNewDriverV1(t *testing.T) {
 		Kubeconfig:             "",
 		AllowEmptyCloudConfig:  true,
 	})
+	assert.NoError(t, err)
 	assert.NotNil(t, d)
+
+	// invalid options fail the construction
+	_, err = newDriverV1(&DriverOptions{
+		DriverName:            consts.DefaultDriverName,
+		AllowEmptyCloudConfig: true,
+		OrphanDiskGC:          OrphanDiskGCOptions{Enable: true},
+	})
+	assert.Error(t, err)
+	_, err = newDriverV1(&DriverOptions{
+		DriverName:            consts.DefaultDriverName,
+		AllowEmptyCloudConfig: true,
+		TagReconcile:          TagReconcileOptions{IntervalSeconds: 60},
+	})
+	assert.Error(t, err)
 }
 
 func TestCheckDiskCapacity(t *testing.T) {
@@ -69,11 +92,11 @@ func TestCheckDiskCapacity(t *testing.T) {
 	diskClient := mock_diskclient.NewMockInterface(cntl)
 	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub("").Return(diskClient, nil).AnyTimes()
 	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
-	flag, err := d.checkDiskCapacity(context.TODO(), "", resourceGroup, diskName, 10)
+	flag, err := d.checkDiskCapacity(context.TODO(), d.getClientFactory(), "", resourceGroup, diskName, 10)
 	assert.Equal(t, flag, true)
 	assert.Nil(t, err)
 
-	flag, err = d.checkDiskCapacity(context.TODO(), "", resourceGroup, diskName, 11)
+	flag, err = d.checkDiskCapacity(context.TODO(), d.getClientFactory(), "", resourceGroup, diskName, 11)
 	assert.Equal(t, flag, false)
 	expectedErr := status.Errorf(6, "the request volume already exists, but its capacity(10) is different from (11)")
 	assert.Equal(t, err, expectedErr)
@@ -183,7 +206,7 @@ func TestRun(t *testing.T) {
 
 				t.Setenv(consts.DefaultAzureCredentialFileEnv, fakeCredFile)
 
-				d := newDriverV1(&DriverOptions{
+				d, err := newDriverV1(&DriverOptions{
 					NodeID:                 "",
 					DriverName:             consts.DefaultDriverName,
 					EnableListVolumes:      true,
@@ -193,13 +216,14 @@ func TestRun(t *testing.T) {
 					VMType:                 "vmss",
 					Endpoint:               "tcp://127.0.0.1:0",
 				})
+				assert.NoError(t, err)
 				ctx, cancelFn := context.WithCancel(context.Background())
 				var routines errgroup.Group
 				routines.Go(func() error { return d.Run(ctx) })
 				time.Sleep(time.Millisecond * 500)
 				cancelFn()
 				time.Sleep(time.Millisecond * 500)
-				err := routines.Wait()
+				err = routines.Wait()
 				assert.Nil(t, err)
 			},
 		},
@@ -221,7 +245,7 @@ func TestRun(t *testing.T) {
 				t.Setenv("AZURE_CLIENT_ID", "123456")
 				t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "fake-token-file")
 
-				d := newDriverV1(&DriverOptions{
+				d, err := newDriverV1(&DriverOptions{
 					NodeID:                 "",
 					DriverName:             consts.DefaultDriverName,
 					EnableListVolumes:      true,
@@ -231,6 +255,7 @@ func TestRun(t *testing.T) {
 					VMType:                 "vmss",
 					Endpoint:               "tcp://127.0.0.1:0",
 				})
+				assert.NoError(t, err)
 
 				ctx, cancel := context.WithCancel(context.Background())
 				ch := make(chan error)
@@ -373,7 +398,7 @@ func TestWaitForSnapshot(t *testing.T) {
 				d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub(subID).Return(mockSnapshotClient, nil).AnyTimes()
 
 				mockSnapshotClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(snapshot, fmt.Errorf("invalid snapshotID")).AnyTimes()
-				err := d.waitForSnapshotReady(context.Background(), subID, resourceGroup, snapshotID, intervel, timeout)
+				err := d.waitForSnapshotReady(context.Background(), d.getClientFactory(), subID, resourceGroup, snapshotID, intervel, timeout)
 
 				wantErr := true
 				subErrMsg := "invalid snapshotID"
@@ -417,7 +442,7 @@ func TestWaitForSnapshot(t *testing.T) {
 				d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub(subID).Return(mockSnapshotClient, nil).AnyTimes()
 
 				mockSnapshotClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(snapshot, nil).AnyTimes()
-				err := d.waitForSnapshotReady(context.Background(), subID, resourceGroup, snapshotID, intervel, timeout)
+				err := d.waitForSnapshotReady(context.Background(), d.getClientFactory(), subID, resourceGroup, snapshotID, intervel, timeout)
 
 				wantErr := true
 				subErrMsg := "timeout"
@@ -460,7 +485,7 @@ func TestWaitForSnapshot(t *testing.T) {
 				mockSnapshotClient := mock_snapshotclient.NewMockInterface(ctrl)
 				d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub(subID).Return(mockSnapshotClient, nil).AnyTimes()
 				mockSnapshotClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(snapshot, nil).AnyTimes()
-				err := d.waitForSnapshotReady(context.Background(), subID, resourceGroup, snapshotID, intervel, timeout)
+				err := d.waitForSnapshotReady(context.Background(), d.getClientFactory(), subID, resourceGroup, snapshotID, intervel, timeout)
 
 				wantErr := false
 				subErrMsg := ""
@@ -565,6 +590,7 @@ func TestGetUsedLunsFromNode(t *testing.T) {
 	}
 	mockVMsClient := d.getCloud().VirtualMachinesClient.(*mockvmclient.MockInterface)
 	mockVMsClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(vm, nil).AnyTimes()
+	d.setKubeClient(fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}))
 
 	tests := []struct {
 		name                string
@@ -580,7 +606,7 @@ func TestGetUsedLunsFromNode(t *testing.T) {
 		},
 	}
 	for _, test := range tests {
-		result, err := d.getUsedLunsFromNode(types.NodeName(test.nodeName))
+		result, err := d.getUsedLunsFromNode(context.Background(), types.NodeName(test.nodeName))
 		if !reflect.DeepEqual(err, test.expectedErr) {
 			t.Errorf("test(%s): err(%v) != expected err(%v)", test.name, err, test.expectedErr)
 		}
@@ -589,3 +615,216 @@ func TestGetUsedLunsFromNode(t *testing.T) {
 		}
 	}
 }
+
+func TestIsSystemCriticalVolume(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, _ := NewFakeDriver(cntl)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, pvc := range []*v1.PersistentVolumeClaim{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "prometheus-data",
+				Namespace:   "monitoring",
+				Annotations: map[string]string{consts.VolumePriorityAnnotation: consts.SystemCriticalVolumePriority},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "app-data",
+				Namespace: "default",
+			},
+		},
+	} {
+		assert.NoError(t, indexer.Add(pvc))
+	}
+	synced := true
+	d.setPVCLister(corelisters.NewPersistentVolumeClaimLister(indexer), func() bool { return synced })
+
+	tests := []struct {
+		desc          string
+		volumeContext map[string]string
+		disk          *armcompute.Disk
+		expected      bool
+	}{
+		{
+			desc:          "no pvc info",
+			volumeContext: map[string]string{},
+			expected:      false,
+		},
+		{
+			desc:          "pvc in system-critical namespace",
+			volumeContext: map[string]string{consts.PvcNameKey: "pvc", consts.PvcNamespaceKey: "kube-system"},
+			expected:      true,
+		},
+		{
+			desc:          "pvc annotated as system-critical",
+			volumeContext: map[string]string{consts.PvcNameKey: "prometheus-data", consts.PvcNamespaceKey: "monitoring"},
+			expected:      true,
+		},
+		{
+			desc:          "pvc without annotation",
+			volumeContext: map[string]string{consts.PvcNameKey: "app-data", consts.PvcNamespaceKey: "default"},
+			expected:      false,
+		},
+		{
+			desc:          "pvc not found",
+			volumeContext: map[string]string{consts.PvcNameKey: "not-exist", consts.PvcNamespaceKey: "default"},
+			expected:      false,
+		},
+		{
+			desc:          "pvc info from disk tags",
+			volumeContext: map[string]string{},
+			disk: &armcompute.Disk{
+				Tags: map[string]*string{
+					consts.PvcNameTag:      ptr.To("prometheus-data"),
+					consts.PvcNamespaceTag: ptr.To("monitoring"),
+				},
+			},
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		result := d.isSystemCriticalVolume(test.volumeContext, test.disk)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+
+	// the annotation is not known until the cache is synced
+	synced = false
+	assert.False(t, d.isSystemCriticalVolume(map[string]string{consts.PvcNameKey: "prometheus-data", consts.PvcNamespaceKey: "monitoring"}, nil))
+	assert.True(t, d.isSystemCriticalVolume(map[string]string{consts.PvcNameKey: "pvc", consts.PvcNamespaceKey: "kube-system"}, nil))
+}
+
+func TestGetPVCAvailabilityZone(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, _ := NewFakeDriver(cntl)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, pvc := range []*v1.PersistentVolumeClaim{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pinned",
+				Namespace:   "default",
+				Annotations: map[string]string{consts.DiskZoneAnnotation: "2"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pinned-wffc",
+				Namespace:   "default",
+				Annotations: map[string]string{consts.DiskZoneAnnotation: "2", selectedNodeAnnotation: "node-1"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "app-data",
+				Namespace: "default",
+			},
+		},
+	} {
+		assert.NoError(t, indexer.Add(pvc))
+	}
+	// the PVC created right before CreateVolume is not in the informer cache yet
+	d.setKubeClient(fake.NewSimpleClientset(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pinned-new",
+			Namespace:   "default",
+			Annotations: map[string]string{consts.DiskZoneAnnotation: "2"},
+		},
+	}))
+	requirement := &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{
+			{Segments: map[string]string{consts.WellKnownTopologyKey: "eastus-1"}},
+			{Segments: map[string]string{consts.WellKnownTopologyKey: "eastus-2"}},
+		},
+	}
+	newWFFCRequirement := func(selectedZone string) *csi.TopologyRequirement {
+		return &csi.TopologyRequirement{
+			Requisite: requirement.Requisite,
+			Preferred: []*csi.Topology{
+				{Segments: map[string]string{consts.WellKnownTopologyKey: selectedZone}},
+				{Segments: map[string]string{consts.WellKnownTopologyKey: "eastus-1"}},
+				{Segments: map[string]string{consts.WellKnownTopologyKey: "eastus-2"}},
+			},
+		}
+	}
+
+	tests := []struct {
+		desc         string
+		pvcName      string
+		requirement  *csi.TopologyRequirement
+		notSynced    bool
+		expectedZone string
+		expectedCode codes.Code
+	}{
+		{desc: "no pvc info"},
+		{desc: "pvc without annotation", pvcName: "app-data", requirement: requirement},
+		{desc: "pvc not found", pvcName: "not-exist", requirement: requirement},
+		{desc: "pvc annotated with allowed zone", pvcName: "pinned", requirement: requirement, expectedZone: "eastus-2"},
+		{
+			desc:    "pvc annotated with zone not allowed",
+			pvcName: "pinned",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: map[string]string{consts.WellKnownTopologyKey: "eastus-1"}}},
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{desc: "pvc annotated with zone of selected node", pvcName: "pinned-wffc", requirement: newWFFCRequirement("eastus-2"), expectedZone: "eastus-2"},
+		{desc: "pvc annotated with zone other than selected node", pvcName: "pinned-wffc", requirement: newWFFCRequirement("eastus-1"), expectedCode: codes.InvalidArgument},
+		{desc: "pvc not in informer cache", pvcName: "pinned-new", requirement: requirement, expectedZone: "eastus-2"},
+		{desc: "pvc informer not synced", pvcName: "pinned-new", requirement: requirement, notSynced: true, expectedZone: "eastus-2"},
+	}
+	for _, test := range tests {
+		namespace := ""
+		if test.pvcName != "" {
+			namespace = "default"
+		}
+		d.setPVCLister(corelisters.NewPersistentVolumeClaimLister(indexer), func() bool { return !test.notSynced })
+		zone, err := d.getPVCAvailabilityZone(context.Background(), namespace, test.pvcName, test.requirement, "eastus")
+		assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+		assert.Equal(t, test.expectedZone, zone, test.desc)
+	}
+
+	// the annotation is ignored without the PVC informer
+	d.setPVCLister(nil, nil)
+	zone, err := d.getPVCAvailabilityZone(context.Background(), "default", "pinned", requirement, "eastus")
+	assert.NoError(t, err)
+	assert.Empty(t, zone)
+}
+
+func TestParseSystemCriticalNamespaces(t *testing.T) {
+	assert.Equal(t, map[string]bool{}, parseSystemCriticalNamespaces(""))
+	assert.Equal(t, map[string]bool{"kube-system": true, "monitoring": true}, parseSystemCriticalNamespaces("kube-system, Monitoring,"))
+}
+
+func TestNewClientRateLimitOptions(t *testing.T) {
+	options := &DriverOptions{
+		DiskClientQPS:       10,
+		DiskClientBurst:     20,
+		VMSSClientQPS:       1.5,
+		SnapshotClientBurst: 5,
+	}
+	expected := &azureutils.ClientRateLimitOptions{
+		DiskQPS:       10,
+		DiskBurst:     20,
+		VMSSQPS:       1.5,
+		SnapshotBurst: 5,
+	}
+	assert.Equal(t, expected, newClientRateLimitOptions(options))
+
+	options.ARMThrottlingThreshold = 5
+	assert.NotNil(t, newClientRateLimitOptions(options).ThrottlingPolicy)
+}
+
+func TestWithOperationTimeout(t *testing.T) {
+	ctx, cancel := withOperationTimeout(context.Background(), 0)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+
+	ctx, cancel = withOperationTimeout(context.Background(), 60)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+}
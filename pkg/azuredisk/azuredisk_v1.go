@@ -21,6 +21,7 @@ package azuredisk
 
 // NewDriver Creates a NewCSIDriver object. Assumes vendor version is equal to driver version &
 // does not support optional driver plugin info manifest field. Refer to CSI spec for more details.
-func NewDriver(options *DriverOptions) CSIDriver {
+// An error is returned if the options are invalid or the driver could not be initialized.
+func NewDriver(options *DriverOptions) (CSIDriver, error) {
 	return newDriverV1(options)
 }
@@ -21,14 +21,34 @@ package azuredisk
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	mount "k8s.io/mount-utils"
+	"k8s.io/utils/ptr"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/vmclient/mockvmclient"
 )
 
 func TestCheckDiskCapacity_V1(t *testing.T) {
@@ -48,7 +68,7 @@ func TestCheckDiskCapacity_V1(t *testing.T) {
 	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
 
 	d.setThrottlingCache(consts.GetDiskThrottlingKey, "")
-	flag, _ := d.checkDiskCapacity(context.TODO(), "", resourceGroup, diskName, 11)
+	flag, _ := d.checkDiskCapacity(context.TODO(), d.getClientFactory(), "", resourceGroup, diskName, 11)
 	assert.Equal(t, flag, true)
 }
 
@@ -60,3 +80,528 @@ func TestDriver_checkDiskExists_V1(t *testing.T) {
 	_, err := d.checkDiskExists(context.TODO(), "testurl/subscriptions/12/resourceGroups/23/providers/Microsoft.Compute/disks/name")
 	assert.Equal(t, err, nil)
 }
+
+func TestCreateVolumeWithSkuFallback_V1(t *testing.T) {
+	skuNotAvailableErr := fmt.Errorf("Code=\"SkuNotAvailable\" Message=\"The requested size for resource is currently not available in location 'eastus' zones '3'\"")
+	tests := []struct {
+		name              string
+		parameters        map[string]string
+		createErrs        []error
+		expectedSkus      []armcompute.DiskStorageAccountTypes
+		expectedSkuName   string
+		expectedErrorCode codes.Code
+	}{
+		{
+			name:            "preferred sku is available",
+			parameters:      map[string]string{"skuName": "UltraSSD_LRS", "skuFallback": "Premium_LRS"},
+			createErrs:      []error{nil},
+			expectedSkus:    []armcompute.DiskStorageAccountTypes{armcompute.DiskStorageAccountTypesUltraSSDLRS},
+			expectedSkuName: "UltraSSD_LRS",
+		},
+		{
+			name:            "fall back to the next sku",
+			parameters:      map[string]string{"skuName": "UltraSSD_LRS", "skuFallback": "PremiumV2_LRS, Premium_LRS", consts.DiskIOPSReadWriteField: "6000"},
+			createErrs:      []error{skuNotAvailableErr, skuNotAvailableErr, nil},
+			expectedSkus:    []armcompute.DiskStorageAccountTypes{armcompute.DiskStorageAccountTypesUltraSSDLRS, armcompute.DiskStorageAccountTypesPremiumV2LRS, armcompute.DiskStorageAccountTypesPremiumLRS},
+			expectedSkuName: "Premium_LRS",
+		},
+		{
+			name:              "all skus are not available",
+			parameters:        map[string]string{consts.SkuNameField: "UltraSSD_LRS", consts.SkuFallbackField: "Premium_LRS"},
+			createErrs:        []error{skuNotAvailableErr, skuNotAvailableErr},
+			expectedSkus:      []armcompute.DiskStorageAccountTypes{armcompute.DiskStorageAccountTypesUltraSSDLRS, armcompute.DiskStorageAccountTypesPremiumLRS},
+			expectedErrorCode: codes.Internal,
+		},
+		{
+			name:              "no fallback on other errors",
+			parameters:        map[string]string{consts.SkuNameField: "UltraSSD_LRS", consts.SkuFallbackField: "Premium_LRS"},
+			createErrs:        []error{fmt.Errorf("test")},
+			expectedSkus:      []armcompute.DiskStorageAccountTypes{armcompute.DiskStorageAccountTypesUltraSSDLRS},
+			expectedErrorCode: codes.Internal,
+		},
+		{
+			name:              "invalid fallback sku",
+			parameters:        map[string]string{consts.SkuNameField: "UltraSSD_LRS", consts.SkuFallbackField: "Invalid_LRS"},
+			expectedErrorCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cntl := gomock.NewController(t)
+			defer cntl.Finish()
+			d, _ := NewFakeDriver(cntl)
+
+			req := &csi.CreateVolumeRequest{
+				Name:               testVolumeName,
+				VolumeCapabilities: stdVolumeCapabilities,
+				CapacityRange:      stdCapacityRange,
+				Parameters:         test.parameters,
+			}
+			id := fmt.Sprintf(consts.ManagedDiskPath, "subs", "rg", testVolumeName)
+			disk := &armcompute.Disk{
+				ID:   &id,
+				Name: &testVolumeName,
+				Properties: &armcompute.DiskProperties{
+					DiskSizeGB:        ptr.To(int32(10)),
+					ProvisioningState: ptr.To("Succeeded"),
+				},
+			}
+			diskClient := mock_diskclient.NewMockInterface(cntl)
+			d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+			diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
+			skus := []armcompute.DiskStorageAccountTypes{}
+			for _, createErr := range test.createErrs {
+				createErr := createErr
+				diskClient.EXPECT().CreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, _, _ string, parameters armcompute.Disk) (*armcompute.Disk, error) {
+						skus = append(skus, *parameters.SKU.Name)
+						if *parameters.SKU.Name == armcompute.DiskStorageAccountTypesPremiumLRS {
+							assert.Nil(t, parameters.Properties.DiskIOPSReadWrite)
+						}
+						if createErr != nil {
+							return nil, createErr
+						}
+						return disk, nil
+					})
+			}
+
+			resp, err := d.CreateVolume(context.Background(), req)
+			if test.expectedErrorCode != codes.OK {
+				assert.Equal(t, test.expectedErrorCode, status.Code(err))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedSkuName, resp.Volume.VolumeContext["skuName"])
+			}
+			assert.Equal(t, len(test.expectedSkus), len(skus))
+			for i := range test.expectedSkus {
+				assert.Equal(t, test.expectedSkus[i], skus[i])
+			}
+		})
+	}
+}
+
+func TestUpdateDiskPropertiesAnnotations_V1(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+	kubeClient := fake.NewSimpleClientset(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-1", Annotations: map[string]string{"foo": "bar"}},
+	})
+	d.kubeClient = kubeClient
+	// the PVs are read from the informer cache, which is synced with the patched PV by syncPV
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	syncPV := func() {
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), "pv-1", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.NoError(t, indexer.Update(pv))
+	}
+	syncPV()
+	kubeClient.ClearActions()
+	d.pvLister = corelisters.NewPersistentVolumeLister(indexer)
+	disk := &armcompute.Disk{
+		SKU:   &armcompute.DiskSKU{Name: ptr.To(armcompute.DiskStorageAccountTypesPremiumLRS)},
+		Zones: []*string{ptr.To("1")},
+		Tags:  map[string]*string{consts.PvNameTag: ptr.To("pv-1")},
+		Properties: &armcompute.DiskProperties{
+			Tier: ptr.To("P10"),
+		},
+	}
+
+	// disabled by default
+	assert.NoError(t, d.updateDiskPropertiesAnnotations(context.Background(), "pv-1", disk))
+	assert.Empty(t, kubeClient.Actions())
+
+	d.enableDiskPropertiesAnnotations = true
+	assert.Equal(t, "pv-1", getPVNameForDisk(nil, disk))
+	assert.Equal(t, "pv-2", getPVNameForDisk(map[string]string{consts.PvNameKey: "pv-2"}, disk))
+	assert.NoError(t, d.updateDiskPropertiesAnnotations(context.Background(), getPVNameForDisk(nil, disk), disk))
+	pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), "pv-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"foo":                      "bar",
+		consts.DiskSkuAnnotation:   string(armcompute.DiskStorageAccountTypesPremiumLRS),
+		consts.DiskZonesAnnotation: "1",
+		consts.DiskTierAnnotation:  "P10",
+	}, pv.Annotations)
+
+	// no patch if nothing changed
+	syncPV()
+	kubeClient.ClearActions()
+	assert.NoError(t, d.updateDiskPropertiesAnnotations(context.Background(), "pv-1", disk))
+	assert.Empty(t, kubeClient.Actions())
+
+	// tier changed after resize
+	disk.Properties.Tier = ptr.To("P15")
+	assert.NoError(t, d.updateDiskPropertiesAnnotations(context.Background(), "pv-1", disk))
+	pv, err = kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), "pv-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "P15", pv.Annotations[consts.DiskTierAnnotation])
+
+	assert.Error(t, d.updateDiskPropertiesAnnotations(context.Background(), "pv-not-exist", disk))
+}
+
+func TestCheckVolumeMaintenance_V1(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, pv := range []*v1.PersistentVolume{
+		{ObjectMeta: metav1.ObjectMeta{Name: "pv-free"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pv-expired", Annotations: map[string]string{consts.VolumeMaintenanceAnnotation: "2020-01-01T00:00:00Z"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pv-fenced", Annotations: map[string]string{consts.VolumeMaintenanceAnnotation: "2999-01-01T00:00:00Z"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pv-invalid", Annotations: map[string]string{consts.VolumeMaintenanceAnnotation: "sku migration"}}},
+	} {
+		assert.NoError(t, indexer.Add(pv))
+	}
+	d.pvLister = corelisters.NewPersistentVolumeLister(indexer)
+
+	// the maintenance annotation is ignored unless enabled
+	assert.NoError(t, d.checkVolumeMaintenance("pv-fenced"))
+
+	d.enableVolumeMaintenance = true
+	tests := []struct {
+		pvName   string
+		expected codes.Code
+	}{
+		{pvName: "", expected: codes.OK},
+		{pvName: "pv-missing", expected: codes.OK},
+		{pvName: "pv-free", expected: codes.OK},
+		{pvName: "pv-expired", expected: codes.OK},
+		{pvName: "pv-fenced", expected: codes.FailedPrecondition},
+		{pvName: "pv-invalid", expected: codes.OK},
+	}
+	for _, test := range tests {
+		err := d.checkVolumeMaintenance(test.pvName)
+		assert.Equal(t, test.expected, status.Code(err), "pv %q", test.pvName)
+	}
+	err = d.checkVolumeMaintenance("pv-fenced")
+	assert.Contains(t, err.Error(), "volume under maintenance until 2999-01-01T00:00:00Z")
+
+	// the volume is not fenced until the informer is synced
+	d.pvListerSynced = func() bool { return false }
+	assert.NoError(t, d.checkVolumeMaintenance("pv-fenced"))
+}
+
+func TestControllerPublishVolumeAlreadyAttached_V1(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+
+	nodeName := "unit-test-node"
+	instanceID := fmt.Sprintf("/subscriptions/subscription/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/%s", nodeName)
+	vm := compute.VirtualMachine{
+		Name:     &nodeName,
+		ID:       &instanceID,
+		Location: &d.cloud.Location,
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			ProvisioningState: ptr.To("Succeeded"),
+			StorageProfile: &compute.StorageProfile{
+				DataDisks: &[]compute.DataDisk{
+					{Lun: ptr.To(int32(2)), Name: &testVolumeName},
+				},
+			},
+		},
+	}
+	mockVMsClient := d.cloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(vm, nil).AnyTimes()
+	// no VM update is expected since the disk is already attached
+	mockVMsClient.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	disk := &armcompute.Disk{
+		ID:         &testVolumeID,
+		Name:       &testVolumeName,
+		Tags:       map[string]*string{consts.PvNameTag: ptr.To("pv-rwop")},
+		Properties: &armcompute.DiskProperties{CreationData: &armcompute.CreationData{LogicalSectorSize: ptr.To(int32(4096))}},
+	}
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
+	d.kubeClient = fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}})
+
+	newRequest := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.ControllerPublishVolumeRequest {
+		return &csi.ControllerPublishVolumeRequest{
+			VolumeId: testVolumeID,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+			},
+			NodeId:        nodeName,
+			VolumeContext: map[string]string{consts.RequestedSizeGib: "10"},
+		}
+	}
+
+	// the publish context of a volume already attached to the node matches the one of the original attach
+	resp, err := d.ControllerPublishVolume(context.Background(), newRequest(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{consts.LUN: "2", consts.LogicalSectorSizeField: "4096"}, resp.PublishContext)
+
+	// the single writer check still applies to a volume already attached to the node
+	_, err = d.kubeClient.StorageV1().VolumeAttachments().Create(context.Background(), &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "va-other-node"},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: d.Name,
+			NodeName: "other-node",
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: ptr.To("pv-rwop")},
+		},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	_, err = d.ControllerPublishVolume(context.Background(), newRequest(csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestNodePublishVolumeWithVolumeMountGroup_V1(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+	d.enableVolumeMountGroup = true
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId: testVolumeID,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: "invalid"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		StagingTargetPath: "/tmp/staging",
+		TargetPath:        "/tmp/target",
+	}
+	_, err = d.NodePublishVolume(context.Background(), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	req.VolumeCapability.GetMount().VolumeMountGroup = "1000"
+	req.VolumeContext = map[string]string{consts.FsGroupChangePolicyField: "invalid"}
+	_, err = d.NodePublishVolume(context.Background(), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestApplyNodeClassPerformance_V1(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+	ctx := context.Background()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, indexer.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "standby", Labels: map[string]string{"example.com/node-class": "standby"}}}))
+	assert.NoError(t, indexer.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "primary"}}))
+
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	newDisk := func(iops, mbps int64) *armcompute.Disk {
+		return &armcompute.Disk{
+			Name: ptr.To(testVolumeName),
+			SKU:  &armcompute.DiskSKU{Name: ptr.To(armcompute.DiskStorageAccountTypesUltraSSDLRS)},
+			Properties: &armcompute.DiskProperties{
+				DiskIOPSReadWrite: ptr.To(iops),
+				DiskMBpsReadWrite: ptr.To(mbps),
+			},
+		}
+	}
+	volumeContext := map[string]string{
+		"nodeClassLabel":             "example.com/node-class",
+		"nodeClassDiskIOPSReadWrite": "standby=100",
+		"nodeClassDiskMBpsReadWrite": "standby=10",
+		"diskIOPSReadWrite":          "2000",
+		"diskMBpsReadWrite":          "200",
+	}
+
+	// no update if node class performance is disabled
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "standby", volumeContext, newDisk(2000, 200)))
+	d.enableNodeClassPerformance = true
+
+	// no update while the node informer is not synced
+	d.nodeLister = corelisters.NewNodeLister(indexer)
+	d.nodeListerSynced = func() bool { return false }
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "standby", volumeContext, newDisk(2000, 200)))
+	d.nodeListerSynced = func() bool { return true }
+
+	// reduced performance on standby node
+	disk := newDisk(2000, 200)
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), testVolumeName).Return(disk, nil)
+	diskClient.EXPECT().Patch(gomock.Any(), gomock.Any(), testVolumeName, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ string, update armcompute.DiskUpdate) (*armcompute.Disk, error) {
+			assert.Equal(t, int64(100), *update.Properties.DiskIOPSReadWrite)
+			assert.Equal(t, int64(10), *update.Properties.DiskMBpsReadWrite)
+			return nil, nil
+		})
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "standby", volumeContext, disk))
+
+	// full performance is restored on promotion to a node without class
+	disk = newDisk(100, 10)
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), testVolumeName).Return(disk, nil)
+	diskClient.EXPECT().Patch(gomock.Any(), gomock.Any(), testVolumeName, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ string, update armcompute.DiskUpdate) (*armcompute.Disk, error) {
+			assert.Equal(t, int64(2000), *update.Properties.DiskIOPSReadWrite)
+			assert.Equal(t, int64(200), *update.Properties.DiskMBpsReadWrite)
+			return nil, nil
+		})
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "primary", volumeContext, disk))
+
+	// no update if the performance already matches or the sku does not support it
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "primary", volumeContext, newDisk(2000, 200)))
+	premiumDisk := newDisk(100, 10)
+	premiumDisk.SKU.Name = ptr.To(armcompute.DiskStorageAccountTypesPremiumLRS)
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "primary", volumeContext, premiumDisk))
+	// the node is not read if every class has the performance of the disk
+	sameContext := map[string]string{
+		"nodeClassLabel":             "example.com/node-class",
+		"nodeClassDiskIOPSReadWrite": "standby=2000",
+		"diskIOPSReadWrite":          "2000",
+	}
+	d.nodeListerSynced = func() bool {
+		t.Errorf("node is read although every class has the performance of the disk")
+		return true
+	}
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "standby", sameContext, newDisk(2000, 200)))
+	d.nodeListerSynced = func() bool { return true }
+
+	// a failed update does not fail the attach
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	volumeContext[consts.PvNameKey] = "pv-node-class"
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "notexist", volumeContext, newDisk(100, 10)))
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), testVolumeName).Return(newDisk(2000, 200), nil)
+	diskClient.EXPECT().Patch(gomock.Any(), gomock.Any(), testVolumeName, gomock.Any()).Return(nil, fmt.Errorf("OperationNotAllowed"))
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "standby", volumeContext, newDisk(2000, 200)))
+	assert.Contains(t, <-recorder.Events, nodeClassPerformanceFailedReason)
+
+	// no update without the performance of the volume, it could not be restored on promotion
+	noDefaultContext := map[string]string{
+		"nodeClassLabel":             "example.com/node-class",
+		"nodeClassDiskIOPSReadWrite": "standby=100",
+	}
+	assert.NoError(t, d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "standby", noDefaultContext, newDisk(2000, 200)))
+
+	volumeContext["nodeClassDiskIOPSReadWrite"] = "standby"
+	err = d.applyNodeClassPerformance(ctx, testVolumeID, testVolumeName, "standby", volumeContext, newDisk(100, 10))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCheckSingleWriterAttachment_V1(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+	ctx := context.Background()
+	newVolumeAttachment := func(name, attacher, pvName, nodeName string, deleting bool) *storagev1.VolumeAttachment {
+		va := &storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: storagev1.VolumeAttachmentSpec{
+				Attacher: attacher,
+				NodeName: nodeName,
+				Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: ptr.To(pvName)},
+			},
+		}
+		if deleting {
+			va.DeletionTimestamp = ptr.To(metav1.Now())
+			va.Finalizers = []string{"external-attacher/disk-csi-azure-com"}
+		}
+		return va
+	}
+	d.kubeClient = fake.NewSimpleClientset(
+		newVolumeAttachment("va-pv1-node1", d.Name, "pv1", "node1", false),
+		newVolumeAttachment("va-pv2-node2", d.Name, "pv2", "node2", false),
+		newVolumeAttachment("va-pv3-node2", d.Name, "pv3", "node2", true),
+		newVolumeAttachment("va-pv4-node2", "other.csi.azure.com", "pv4", "node2", false),
+	)
+
+	assert.NoError(t, d.checkSingleWriterAttachment(ctx, testVolumeID, "", "node1"))
+	assert.NoError(t, d.checkSingleWriterAttachment(ctx, testVolumeID, "pv1", "node1"))
+	// VolumeAttachments being deleted and the ones of other drivers are ignored, so that the dangling disk is detached
+	assert.NoError(t, d.checkSingleWriterAttachment(ctx, testVolumeID, "pv3", "node1"))
+	assert.NoError(t, d.checkSingleWriterAttachment(ctx, testVolumeID, "pv4", "node1"))
+	assert.NoError(t, d.checkSingleWriterAttachment(ctx, testVolumeID, "pv5", "node1"))
+
+	err = d.checkSingleWriterAttachment(ctx, testVolumeID, "pv2", "node1")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// the informer is not started without the context of the controller
+	assert.Nil(t, d.volumeAttachmentIndexer)
+
+	// VolumeAttachments are read from the informer cache once it's synced, it's started by the first check
+	d.volumeAttachmentInformerOnce = sync.Once{}
+	informerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.volumeAttachmentInformerCtx = informerCtx
+	assert.NoError(t, d.checkSingleWriterAttachment(ctx, testVolumeID, "pv1", "node1"))
+	require.NotNil(t, d.volumeAttachmentIndexer)
+	require.True(t, cache.WaitForCacheSync(informerCtx.Done(), d.volumeAttachmentIndexerSynced))
+	kubeClient := d.kubeClient.(*fake.Clientset)
+	kubeClient.ClearActions()
+	assert.NoError(t, d.checkSingleWriterAttachment(ctx, testVolumeID, "pv1", "node1"))
+	assert.NoError(t, d.checkSingleWriterAttachment(ctx, testVolumeID, "pv3", "node1"))
+	assert.NoError(t, d.checkSingleWriterAttachment(ctx, testVolumeID, "pv4", "node1"))
+	err = d.checkSingleWriterAttachment(ctx, testVolumeID, "pv2", "node1")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Empty(t, kubeClient.Actions())
+}
+
+func TestGetOtherPublishedTarget(t *testing.T) {
+	d := &DriverCore{}
+	d.setMounter(&mount.SafeFormatAndMount{Interface: mount.NewFakeMounter([]mount.MountPoint{
+		{Device: "/dev/sdc", Path: "/staging/vol1"},
+		{Device: "/dev/sdc", Path: "/pods/pod1/vol1"},
+		{Device: "/dev/sdd", Path: "/staging/vol2"},
+	})})
+	fsCapability := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+	blockCapability := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+
+	assert.Equal(t, "/pods/pod1/vol1", d.getOtherPublishedTarget(fsCapability, "/staging/vol1", "/pods/pod2/vol1"))
+	assert.Equal(t, "", d.getOtherPublishedTarget(fsCapability, "/staging/vol1", "/pods/pod1/vol1"))
+	assert.Equal(t, "", d.getOtherPublishedTarget(fsCapability, "/staging/vol2", "/pods/pod2/vol2"))
+	assert.Equal(t, "", d.getOtherPublishedTarget(blockCapability, "/staging/vol1", "/pods/pod2/vol1"))
+}
+
+func TestNodePublishVolumeSingleWriter_V1(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	assert.NoError(t, err)
+	d.setMounter(fakeMounter)
+	ctx := context.Background()
+	dir := t.TempDir()
+	staging := filepath.Join(dir, "staging")
+	assert.NoError(t, os.MkdirAll(staging, 0750))
+
+	newRequest := func(target string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId: testVolumeID,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER},
+			},
+			StagingTargetPath: staging,
+			TargetPath:        target,
+		}
+	}
+	target1 := filepath.Join(dir, "pod1")
+	target2 := filepath.Join(dir, "pod2")
+
+	_, err = d.NodePublishVolume(ctx, newRequest(target1))
+	assert.NoError(t, err)
+	// publish to the same target path is idempotent
+	_, err = d.NodePublishVolume(ctx, newRequest(target1))
+	assert.NoError(t, err)
+	_, err = d.NodePublishVolume(ctx, newRequest(target2))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// the volume could be published to another pod after it's unpublished
+	_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: testVolumeID, TargetPath: target1})
+	assert.NoError(t, err)
+	_, err = d.NodePublishVolume(ctx, newRequest(target2))
+	assert.NoError(t, err)
+
+	// failed publish does not hold the volume
+	_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: testVolumeID, TargetPath: target2})
+	assert.NoError(t, err)
+	req := newRequest(target1)
+	req.VolumeContext = map[string]string{consts.FsGroupChangePolicyField: "invalid"}
+	_, err = d.NodePublishVolume(ctx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = d.NodePublishVolume(ctx, newRequest(target2))
+	assert.NoError(t, err)
+}
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
@@ -44,6 +45,7 @@ import (
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
 	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
 	consts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
 )
 
@@ -53,10 +55,12 @@ var useDriverV2 = flag.Bool("temp-use-driver-v2", false, "A temporary flag to en
 type DriverV2 struct {
 	DriverCore
 	volumeLocks *volumehelper.VolumeLocks
+	// SINGLE_NODE_SINGLE_WRITER volumes published on this node <volumeID, targetPath>
+	singleWriterVolumes sync.Map
 }
 
 // NewDriver creates a Driver or DriverV2 object depending on the --temp-use-driver-v2 flag.
-func NewDriver(options *DriverOptions) CSIDriver {
+func NewDriver(options *DriverOptions) (CSIDriver, error) {
 	if !*useDriverV2 {
 		return newDriverV1(options)
 	} else {
@@ -66,10 +70,16 @@ func NewDriver(options *DriverOptions) CSIDriver {
 
 // newDriverV2 Creates a NewCSIDriver object. Assumes vendor version is equal to driver version &
 // does not support optional driver plugin info manifest field. Refer to CSI spec for more details.
-func newDriverV2(options *DriverOptions) *DriverV2 {
+func newDriverV2(options *DriverOptions) (*DriverV2, error) {
 	klog.Warning("Using DriverV2")
 	driver := DriverV2{}
 	driver.Name = options.DriverName
+	driver.driverRole = options.DriverRole
+	driver.resourceGroupLocks = newLockMap()
+	driver.inflightOperations = newInflightOperations()
+	driver.deadlineBudgetMarginSeconds = options.DeadlineBudgetMarginSeconds
+	driver.tlsEndpoint = options.TLSEndpoint
+	driver.tlsCertDir = options.TLSCertDir
 	driver.Version = driverVersion
 	driver.NodeID = options.NodeID
 	driver.VolumeAttachLimit = options.VolumeAttachLimit
@@ -81,10 +91,23 @@ func newDriverV2(options *DriverOptions) *DriverV2 {
 	driver.userAgentSuffix = options.UserAgentSuffix
 	driver.useCSIProxyGAInterface = options.UseCSIProxyGAInterface
 	driver.enableOtelTracing = options.EnableOtelTracing
+	driver.otelTracingOptions = newOtelTracingOptions(options)
+	driver.enableAuditLog = options.EnableAuditLog
+	driver.auditLogPath = options.AuditLogPath
+	driver.auditLogMaxSizeMB = options.AuditLogMaxSizeMB
+	driver.auditLogMaxBackups = options.AuditLogMaxBackups
+	driver.clientRateLimitOptions = newClientRateLimitOptions(options)
 	driver.ioHandler = azureutils.NewOSIOHandler()
 	driver.hostUtil = hostutil.NewHostUtil()
 	driver.disableAVSetNodes = options.DisableAVSetNodes
 	driver.endpoint = options.Endpoint
+	driver.enableDiskThroughputHints = options.EnableDiskThroughputHints
+	if driver.NodeID != "" && options.EnableVolumeMetrics {
+		driver.registerVolumeMetrics()
+	}
+	if driver.NodeID != "" && options.PublishContextCacheDir != "" {
+		driver.publishContextCache = newPublishContextCache(options.PublishContextCacheDir, driver.getDiskIdentityByLun)
+	}
 
 	topologyKey = fmt.Sprintf("topology.%s/zone", driver.Name)
 	userAgent := GetUserAgent(driver.Name, driver.customUserAgent, driver.userAgentSuffix)
@@ -94,15 +117,15 @@ func newDriverV2(options *DriverOptions) *DriverV2 {
 	if err != nil {
 		klog.Warningf("get kubeconfig(%s) failed with error: %v", options.Kubeconfig, err)
 		if !os.IsNotExist(err) && !errors.Is(err, rest.ErrNotInCluster) {
-			klog.Fatalf("failed to get KubeClient: %v", err)
+			return nil, fmt.Errorf("failed to get KubeClient: %w", err)
 		}
 	}
 	driver.kubeClient = kubeClient
 
 	cloud, err := azureutils.GetCloudProviderFromClient(context.Background(), kubeClient, driver.cloudConfigSecretName, driver.cloudConfigSecretNamespace,
-		userAgent, driver.allowEmptyCloudConfig, driver.enableTrafficManager, driver.trafficManagerPort)
+		userAgent, driver.allowEmptyCloudConfig, driver.enableTrafficManager, driver.trafficManagerPort, driver.clientRateLimitOptions)
 	if err != nil {
-		klog.Fatalf("failed to get Azure Cloud Provider, error: %v", err)
+		return nil, fmt.Errorf("failed to get Azure Cloud Provider, error: %w", err)
 	}
 	driver.cloud = cloud
 
@@ -146,7 +169,7 @@ func newDriverV2(options *DriverOptions) *DriverV2 {
 
 	driver.mounter, err = mounter.NewSafeMounter(driver.enableWindowsHostProcess, driver.useCSIProxyGAInterface, int(driver.maxConcurrentFormat), time.Duration(driver.concurrentFormatTimeout)*time.Second)
 	if err != nil {
-		klog.Fatalf("Failed to get safe mounter. Error: %v", err)
+		return nil, fmt.Errorf("failed to get safe mounter, error: %w", err)
 	}
 
 	driver.AddControllerServiceCapabilities(
@@ -175,7 +198,7 @@ func newDriverV2(options *DriverOptions) *DriverV2 {
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 	})
-	return &driver
+	return &driver, nil
 }
 
 // Run driver initialization
@@ -186,19 +209,39 @@ func (d *DriverV2) Run(ctx context.Context) error {
 	}
 	klog.Infof("\nDRIVER INFORMATION:\n-------------------\n%s\n\nStreaming logs below:", versionMeta)
 
+	interceptors := []grpc.UnaryServerInterceptor{
+		grpcprom.NewServerMetrics().UnaryServerInterceptor(),
+		csicommon.LogGRPC,
+	}
+	if d.enableAuditLog {
+		auditLogger := newAuditLogger(d.auditLogPath, d.auditLogMaxSizeMB, d.auditLogMaxBackups)
+		defer func() {
+			if err := auditLogger.Close(); err != nil {
+				klog.Errorf("Could not close audit logger: %v", err)
+			}
+		}()
+		interceptors = append(interceptors, auditLogger.UnaryServerInterceptor)
+	}
 	opts := []grpc.ServerOption{
-		grpc.ChainUnaryInterceptor(
-			grpcprom.NewServerMetrics().UnaryServerInterceptor(),
-			csicommon.LogGRPC,
-		),
+		grpc.ChainUnaryInterceptor(interceptors...),
 	}
 	if d.enableOtelTracing {
+		exporter, err := InitOtelTracing(d.otelTracingOptions)
+		if err != nil {
+			klog.Fatalf("Failed to initialize otel tracing: %v", err)
+		}
+		// Exporter will flush traces on shutdown
+		defer func() {
+			if err := exporter.Shutdown(context.Background()); err != nil {
+				klog.Errorf("Could not shutdown otel exporter: %v", err)
+			}
+		}()
 		opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
 	}
-	s := grpc.NewServer(opts...)
-	csi.RegisterIdentityServer(s, d)
-	csi.RegisterControllerServer(s, d)
-	csi.RegisterNodeServer(s, d)
+	s := d.newGRPCServer(d, opts...)
+	if d.tlsEndpoint != "" {
+		go d.runTLSEndpoint(ctx, d, opts)
+	}
 
 	go func() {
 		//graceful shutdown
@@ -230,7 +273,7 @@ func (d *DriverV2) checkDiskExists(ctx context.Context, diskURI string) (*armcom
 	}
 
 	subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := d.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return nil, err
 	}
@@ -243,8 +286,8 @@ func (d *DriverV2) checkDiskExists(ctx context.Context, diskURI string) (*armcom
 	return disk, nil
 }
 
-func (d *DriverV2) checkDiskCapacity(ctx context.Context, subsID, resourceGroup, diskName string, requestGiB int) (bool, error) {
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
+func (d *DriverV2) checkDiskCapacity(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, diskName string, requestGiB int) (bool, error) {
+	diskClient, err := clientFactory.GetDiskClientForSub(subsID)
 	if err != nil {
 		return false, err
 	}
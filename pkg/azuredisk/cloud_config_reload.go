@@ -0,0 +1,150 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/filewatcher"
+	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+)
+
+// getCloudConfigFiles returns the cloud config file and the cloud environment file (AZURE_ENVIRONMENT_FILEPATH)
+func getCloudConfigFiles() []string {
+	credFile := os.Getenv(consts.DefaultAzureCredentialFileEnv)
+	if strings.TrimSpace(credFile) == "" {
+		credFile = consts.DefaultCredFilePathLinux
+		if volumehelper.IsWindowsOS() {
+			credFile = consts.DefaultCredFilePathWindows
+		}
+	}
+	files := []string{credFile}
+	if envFile := os.Getenv(azclient.EnvironmentFilepathName); envFile != "" {
+		files = append(files, envFile)
+	}
+	return files
+}
+
+// watchCloudConfigSecret calls onChange when the cloud config in the cloud config secret is created, updated or deleted
+func (d *Driver) watchCloudConfigSecret(ctx context.Context, onChange func()) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(d.kubeClient, 0, informers.WithNamespace(d.cloudConfigSecretNamespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", d.cloudConfigSecretName).String()
+		}))
+	informer := factory.Core().V1().Secrets().Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(_ interface{}, isInInitialList bool) {
+			// the secret in the initial list is the one the current cloud is created from
+			if !isInInitialList {
+				onChange()
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSecret, ok := oldObj.(*v1.Secret)
+			newSecret, ok2 := newObj.(*v1.Secret)
+			if ok && ok2 && string(oldSecret.Data["cloud-config"]) != string(newSecret.Data["cloud-config"]) {
+				onChange()
+			}
+		},
+		DeleteFunc: func(_ interface{}) {
+			onChange()
+		},
+	}); err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	return nil
+}
+
+// reloadCloud creates the cloud provider from the current cloud config and replaces the one used by the driver
+func (d *Driver) reloadCloud(ctx context.Context) error {
+	userAgent := GetUserAgent(d.Name, d.customUserAgent, d.userAgentSuffix)
+	cloud, err := azureutils.GetCloudProviderFromClient(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace,
+		userAgent, d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort, d.clientRateLimitOptions)
+	if err != nil {
+		return err
+	}
+	// keep the current cloud if the new cloud config could not be initialized
+	if cloud == nil || cloud.ComputeClientFactory == nil {
+		return fmt.Errorf("failed to initialize cloud from the new cloud config")
+	}
+	d.swapCloud(cloud)
+	return nil
+}
+
+// runCloudConfigReloader watches the cloud config secret, the cloud config file and the cloud environment file and
+// reloads the cloud provider when one of them is changed, so that cloud environment settings like
+// resourceManagerEndpoint are updated without restarting the driver. A failed reload is retried every retryInterval.
+func (d *Driver) runCloudConfigReloader(ctx context.Context, retryInterval time.Duration) {
+	// changes arriving while a reload is pending are coalesced into that reload
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	for _, file := range getCloudConfigFiles() {
+		if err := filewatcher.WatchFilesForChanges(ctx, []string{file}, func(name string) {
+			klog.V(2).Infof("cloud config file %s is changed", name)
+			notify()
+		}); err != nil {
+			klog.Warningf("failed to watch cloud config file %s: %v", file, err)
+		}
+	}
+	if d.kubeClient != nil {
+		if err := d.watchCloudConfigSecret(ctx, func() {
+			klog.V(2).Infof("cloud config secret %s/%s is changed", d.cloudConfigSecretNamespace, d.cloudConfigSecretName)
+			notify()
+		}); err != nil {
+			klog.Warningf("failed to watch cloud config secret %s/%s: %v", d.cloudConfigSecretNamespace, d.cloudConfigSecretName, err)
+		}
+	}
+
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-retry:
+		}
+		retry = nil
+		klog.V(2).Infof("cloud config is changed, reloading cloud provider")
+		if err := d.reloadCloud(ctx); err != nil {
+			klog.Errorf("failed to reload cloud provider, retrying in %v: %v", retryInterval, err)
+			retry = time.After(retryInterval)
+			continue
+		}
+		klog.V(2).Infof("cloud provider is reloaded, cloud: %s, location: %s", d.getCloud().Cloud, d.getCloud().Location)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func TestGetCloudConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	credFile := filepath.Join(dir, "azure.json")
+	envFile := filepath.Join(dir, "environment.json")
+	t.Setenv(consts.DefaultAzureCredentialFileEnv, credFile)
+	t.Setenv(azclient.EnvironmentFilepathName, "")
+	assert.Equal(t, []string{credFile}, getCloudConfigFiles())
+
+	t.Setenv(azclient.EnvironmentFilepathName, envFile)
+	assert.Equal(t, []string{credFile, envFile}, getCloudConfigFiles())
+}
+
+func TestWatchCloudConfigSecret(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.cloudConfigSecretName = "azure-cloud-provider"
+	d.cloudConfigSecretNamespace = "kube-system"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: d.cloudConfigSecretName, Namespace: d.cloudConfigSecretNamespace},
+		Data:       map[string][]byte{"cloud-config": []byte(`{"cloud":"AzurePublicCloud"}`)},
+	}
+	_, err = d.kubeClient.CoreV1().Secrets(d.cloudConfigSecretNamespace).Create(ctx, secret, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	changed := make(chan struct{}, 10)
+	require.NoError(t, d.watchCloudConfigSecret(ctx, func() { changed <- struct{}{} }))
+
+	// the secret in the initial list does not trigger a reload
+	assert.Never(t, func() bool { return len(changed) > 0 }, time.Second, 100*time.Millisecond)
+
+	clouds := []string{`{"cloud":"AzureStackCloud"}`, `{"cloud":"AzurePublicCloud"}`}
+	i := 0
+	require.Eventually(t, func() bool {
+		i++
+		secret.Data["cloud-config"] = []byte(clouds[i%2])
+		_, err := d.kubeClient.CoreV1().Secrets(d.cloudConfigSecretNamespace).Update(ctx, secret, metav1.UpdateOptions{})
+		return err == nil && len(changed) > 0
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestReloadCloud(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	t.Setenv(consts.DefaultAzureCredentialFileEnv, filepath.Join(t.TempDir(), "azure.json"))
+
+	// the current cloud is kept if the new cloud config is empty
+	cloud := d.getCloud()
+	assert.Error(t, d.reloadCloud(context.Background()))
+	assert.Same(t, cloud, d.getCloud())
+
+	// the disk controller and its VM locks are kept, only its cloud is replaced
+	diskController := d.getDiskController()
+	lockMap := diskController.lockMap
+	newCloud := azure.GetTestCloud(cntl)
+	d.swapCloud(newCloud)
+	assert.Same(t, newCloud, d.getCloud())
+	assert.Equal(t, newCloud.ComputeClientFactory, d.getClientFactory())
+	assert.Same(t, diskController, d.getDiskController())
+	assert.Same(t, lockMap, d.getDiskController().lockMap)
+	assert.Same(t, newCloud, d.getDiskController().getCloud())
+	assert.Equal(t, newCloud.ComputeClientFactory, d.getDiskController().getClientFactory())
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,9 +33,12 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
 	cloudprovider "k8s.io/cloud-provider"
 	volerr "k8s.io/cloud-provider/volume/errors"
 	"k8s.io/klog/v2"
@@ -44,6 +48,7 @@ import (
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
 	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
 	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
 	"sigs.k8s.io/cloud-provider-azure/pkg/metrics"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
@@ -54,6 +59,8 @@ const (
 	waitForSnapshotReadyTimeout  = 10 * time.Minute
 	maxErrMsgLength              = 990
 	checkDiskLunThrottleLatency  = 1 * time.Second
+	// index of the VolumeAttachments by the name of their PV
+	volumeAttachmentPVIndex = "pvName"
 )
 
 // listVolumeStatus explains the return status of `listVolumesByResourceGroup`
@@ -118,27 +125,26 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Error(codes.InvalidArgument, "After round-up, volume size exceeds the limit specified")
 	}
 
-	localCloud := d.cloud
-	localDiskController := d.diskController
+	localCloud := d.getCloud()
+	localDiskController := d.getDiskController()
+	// the clients of the disk, its source and the snapshots are in the tenant of the secrets if they are set
+	localClientFactory := d.getClientFactory()
 
-	if diskParams.UserAgent != "" {
+	secretsCloud, err := d.getCloudFromSecrets(ctx, req.GetSecrets(), diskParams.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+	if secretsCloud != nil {
+		localCloud = secretsCloud
+		localDiskController = d.newLocalDiskController(localCloud)
+		localClientFactory = secretsCloud.ComputeClientFactory
+	} else if diskParams.UserAgent != "" {
 		localCloud, err = azureutils.GetCloudProviderFromClient(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, diskParams.UserAgent,
-			d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort)
+			d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort, d.clientRateLimitOptions)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "create cloud with UserAgent(%s) failed with: (%s)", diskParams.UserAgent, err)
 		}
-		localDiskController = &ManagedDiskController{
-			controllerCommon: &controllerCommon{
-				cloud:               localCloud,
-				lockMap:             newLockMap(),
-				DisableDiskLunCheck: true,
-				clientFactory:       localCloud.ComputeClientFactory,
-				ForceDetachBackoff:  d.forceDetachBackoff,
-			},
-		}
-		localDiskController.DisableUpdateCache = d.disableUpdateCache
-		localDiskController.AttachDetachInitialDelayInMs = int(d.attachDetachInitialDelayInMs)
-
+		localDiskController = d.newLocalDiskController(localCloud)
 	}
 	if azureutils.IsAzureStackCloud(localCloud.Config.Cloud, localCloud.Config.DisableAzureStackCloud) {
 		if diskParams.MaxShares > 1 {
@@ -152,26 +158,25 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	diskParams.DiskName = azureutils.CreateValidDiskName(diskParams.DiskName)
 
 	if diskParams.ResourceGroup == "" {
-		diskParams.ResourceGroup = d.cloud.ResourceGroup
+		diskParams.ResourceGroup = localCloud.ResourceGroup
 	}
 
-	// normalize values
-	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, localCloud.Config.Cloud, localCloud.Config.DisableAzureStackCloud)
+	// validate and normalize values
+	skuName, err := azureutils.ValidateDiskParameters(&diskParams, localCloud.Config.Cloud, localCloud.Config.DisableAzureStackCloud)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-
-	if _, err := azureutils.NormalizeCachingMode(diskParams.CachingMode); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
-	}
-	if skuName == armcompute.DiskStorageAccountTypesPremiumV2LRS {
-		// PremiumV2LRS only supports None caching mode
-		azureutils.SetKeyValueInMap(diskParams.VolumeContext, consts.CachingModeField, string(v1.AzureDataDiskCachingNone))
-	}
-
-	if err := azureutils.ValidateDiskEncryptionType(diskParams.DiskEncryptionType); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+	skuNames := []armcompute.DiskStorageAccountTypes{skuName}
+	for _, fallback := range diskParams.SkuFallback {
+		fallbackSkuName, err := azureutils.NormalizeStorageAccountType(fallback, localCloud.Config.Cloud, localCloud.Config.DisableAzureStackCloud)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if !slices.Contains(skuNames, fallbackSkuName) {
+			skuNames = append(skuNames, fallbackSkuName)
+		}
 	}
+	var chosenSkuName armcompute.DiskStorageAccountTypes
 
 	networkAccessPolicy, err := azureutils.NormalizeNetworkAccessPolicy(diskParams.NetworkAccessPolicy)
 	if err != nil {
@@ -184,18 +189,40 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}
 
 	diskZone := azureutils.PickAvailabilityZone(req.GetAccessibilityRequirements(), diskParams.Location, topologyKey)
+	zoneRegion := diskParams.Location
+	if zoneRegion == "" {
+		zoneRegion = d.getCloud().Location
+	}
+	pvcZone, err := d.getPVCAvailabilityZone(ctx, diskParams.Tags[consts.PvcNamespaceTag], diskParams.Tags[consts.PvcNameTag], req.GetAccessibilityRequirements(), zoneRegion)
+	if err != nil {
+		return nil, err
+	}
+	if pvcZone != "" {
+		klog.V(2).Infof("zone of disk(%s) is set to %s by pvc annotation, topology-selected zone was %q", diskParams.DiskName, pvcZone, diskZone)
+		diskZone = pvcZone
+	}
 	if diskParams.Location == "" {
-		diskParams.Location = d.cloud.Location
+		diskParams.Location = d.getCloud().Location
 		region := azureutils.GetRegionFromAvailabilityZone(diskZone)
-		if region != "" && region != d.cloud.Location {
+		if region != "" && region != d.getCloud().Location {
 			klog.V(2).Infof("got a different region from zone %s for disk %s", diskZone, diskParams.DiskName)
 			diskParams.Location = region
 		}
 	}
-	accessibleTopology := []*csi.Topology{}
+	var volumeZone string
+	var accessibleTopology []*csi.Topology
+
+	if diskParams.CreateResourceGroupIfNotExist {
+		if !d.isClusterSubscription(diskParams.SubscriptionID) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is only supported in subscription %s", consts.CreateResourceGroupIfNotExist, d.getCloud().SubscriptionID)
+		}
+		if err := d.ensureResourceGroup(ctx, &diskParams); err != nil {
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+	}
 
 	if d.enableDiskCapacityCheck {
-		if ok, err := d.checkDiskCapacity(ctx, diskParams.SubscriptionID, diskParams.ResourceGroup, diskParams.DiskName, requestGiB); !ok {
+		if ok, err := d.checkDiskCapacity(ctx, localClientFactory, diskParams.SubscriptionID, diskParams.ResourceGroup, diskParams.DiskName, requestGiB); !ok {
 			return nil, err
 		}
 	}
@@ -209,6 +236,9 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	metricsRequest := "controller_create_volume"
 	content := req.GetVolumeContentSource()
 	if content != nil {
+		if diskParams.GalleryImageReferenceID != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s could not be used with a volume content source", consts.GalleryImageReferenceIDField)
+		}
 		if content.GetSnapshot() != nil {
 			sourceID = content.GetSnapshot().GetSnapshotId()
 			sourceType = consts.SourceSnapshot
@@ -219,6 +249,17 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 					},
 				},
 			}
+			if diskZone != "" {
+				if err := d.prepareSnapshotForZonalRestore(ctx, localClientFactory, sourceID, diskParams.Location, diskZone); err != nil {
+					return nil, err
+				}
+			}
+			if sourceGiB, err := d.getSnapshotSizeGiB(ctx, localClientFactory, sourceID); err != nil {
+				klog.Warningf("failed to get snapshot(%s) size, err: %v", sourceID, err)
+			} else if sourceGiB != nil && *sourceGiB < int32(requestGiB) {
+				diskParams.VolumeContext[consts.ResizeRequired] = strconv.FormatBool(true)
+				klog.V(2).Infof("snapshot(%s) size(%d) is less than requested size(%d), set resizeRequired as true", sourceID, *sourceGiB, requestGiB)
+			}
 			metricsRequest = "controller_create_volume_from_snapshot"
 		} else {
 			sourceID = content.GetVolume().GetVolumeId()
@@ -231,7 +272,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 				},
 			}
 			subsID := azureutils.GetSubscriptionIDFromURI(sourceID)
-			sourceGiB, disk, err := d.GetSourceDiskSize(ctx, subsID, diskParams.ResourceGroup, path.Base(sourceID), 0, consts.SourceDiskSearchMaxDepth)
+			sourceGiB, disk, err := d.GetSourceDiskSize(ctx, localClientFactory, subsID, diskParams.ResourceGroup, path.Base(sourceID), 0, consts.SourceDiskSearchMaxDepth)
 			if err == nil {
 				if sourceGiB != nil && *sourceGiB < int32(requestGiB) {
 					diskParams.VolumeContext[consts.ResizeRequired] = strconv.FormatBool(true)
@@ -250,90 +291,144 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		}
 	}
 
-	if strings.HasSuffix(strings.ToLower(string(skuName)), "zrs") {
-		klog.V(2).Infof("diskZone(%s) is reset as empty since disk(%s) is ZRS(%s)", diskZone, diskParams.DiskName, skuName)
-		diskZone = ""
-		// make volume scheduled on all 3 availability zones
-		for i := 1; i <= 3; i++ {
-			topology := &csi.Topology{
-				Segments: map[string]string{topologyKey: fmt.Sprintf("%s-%d", diskParams.Location, i)},
-			}
-			accessibleTopology = append(accessibleTopology, topology)
-		}
-		// make volume scheduled on all non-zone nodes
-		topology := &csi.Topology{
-			Segments: map[string]string{topologyKey: ""},
-		}
-		accessibleTopology = append(accessibleTopology, topology)
-	} else {
-		accessibleTopology = []*csi.Topology{
-			{
-				Segments: map[string]string{topologyKey: diskZone},
-			},
-		}
-	}
-
-	klog.V(2).Infof("begin to create azure disk(%s) account type(%s) rg(%s) location(%s) size(%d) diskZone(%v) maxShares(%d)",
-		diskParams.DiskName, skuName, diskParams.ResourceGroup, diskParams.Location, requestGiB, diskZone, diskParams.MaxShares)
-
-	if skuName == armcompute.DiskStorageAccountTypesUltraSSDLRS {
-		if diskParams.DiskIOPSReadWrite == "" && diskParams.DiskMBPSReadWrite == "" {
-			// set default DiskIOPSReadWrite, DiskMBPSReadWrite per request size
-			diskParams.DiskIOPSReadWrite = strconv.Itoa(getDefaultDiskIOPSReadWrite(requestGiB))
-			diskParams.DiskMBPSReadWrite = strconv.Itoa(getDefaultDiskMBPSReadWrite(requestGiB))
-			klog.V(2).Infof("set default DiskIOPSReadWrite as %s, DiskMBPSReadWrite as %s on disk(%s)", diskParams.DiskIOPSReadWrite, diskParams.DiskMBPSReadWrite, diskParams.DiskName)
+	importSource := getVolumeImportSource(ctx)
+	if importSource != nil {
+		if content != nil || diskParams.GalleryImageReferenceID != "" {
+			return nil, status.Error(codes.InvalidArgument, "a VHD import could not be used with a volume content source or gallery image")
 		}
+		metricsRequest = "controller_create_volume_from_import"
 	}
 
 	diskParams.VolumeContext[consts.RequestedSizeGib] = strconv.Itoa(requestGiB)
-	volumeOptions := &ManagedDiskOptions{
-		AvailabilityZone:    diskZone,
-		BurstingEnabled:     diskParams.EnableBursting,
-		DiskEncryptionSetID: diskParams.DiskEncryptionSetID,
-		DiskEncryptionType:  diskParams.DiskEncryptionType,
-		DiskIOPSReadWrite:   diskParams.DiskIOPSReadWrite,
-		DiskMBpsReadWrite:   diskParams.DiskMBPSReadWrite,
-		DiskName:            diskParams.DiskName,
-		LogicalSectorSize:   int32(diskParams.LogicalSectorSize),
-		MaxShares:           int32(diskParams.MaxShares),
-		ResourceGroup:       diskParams.ResourceGroup,
-		SubscriptionID:      diskParams.SubscriptionID,
-		SizeGB:              requestGiB,
-		StorageAccountType:  skuName,
-		SourceResourceID:    sourceID,
-		SourceType:          sourceType,
-		Tags:                diskParams.Tags,
-		Location:            diskParams.Location,
-		PerformancePlus:     diskParams.PerformancePlus,
-	}
-
-	volumeOptions.SkipGetDiskOperation = d.isGetDiskThrottled()
-	// Azure Stack Cloud does not support NetworkAccessPolicy, PublicNetworkAccess
-	if !azureutils.IsAzureStackCloud(localCloud.Config.Cloud, localCloud.Config.DisableAzureStackCloud) {
-		volumeOptions.NetworkAccessPolicy = networkAccessPolicy
-		volumeOptions.PublicNetworkAccess = publicNetworkAccess
-		if diskParams.DiskAccessID != "" {
-			volumeOptions.DiskAccessID = &diskParams.DiskAccessID
-		}
-	}
 
 	var diskURI string
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, metricsRequest, d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, metricsRequest, d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
-	diskURI, err = localDiskController.CreateManagedDisk(ctx, volumeOptions)
-	if err != nil {
-		if strings.Contains(err.Error(), consts.NotFound) {
-			return nil, status.Error(codes.NotFound, err.Error())
+	if diskParams.EnableAzureMonitor {
+		// the diagnostic setting is deleted with the disk in DeleteVolume
+		diskParams.Tags[consts.DiagnosticSettingTag] = consts.DiagnosticSettingName
+	}
+
+	createCtx, cancel := withOperationTimeout(ctx, d.createVolumeTimeoutInSeconds)
+	defer cancel()
+	if diskParams.DiskPool != "" && content == nil && importSource == nil && diskParams.GalleryImageReferenceID == "" {
+		volumeZone, accessibleTopology = getAccessibleTopology(skuName, diskZone, diskParams.Location)
+		if diskURI, err = d.claimPoolDisk(createCtx, name, skuName, requestGiB, volumeZone, &diskParams); err != nil {
+			return nil, err
+		}
+		if diskURI != "" {
+			// the pool disk is used instead of creating a disk with any of the skus
+			diskParams.DiskName = path.Base(diskURI)
+			chosenSkuName = skuName
+			skuNames = nil
+		}
+	}
+	for i, skuName := range skuNames {
+		volumeZone, accessibleTopology = getAccessibleTopology(skuName, diskZone, diskParams.Location)
+		if volumeZone != diskZone {
+			klog.V(2).Infof("diskZone(%s) is reset as empty since disk(%s) is ZRS(%s)", diskZone, diskParams.DiskName, skuName)
+		}
+
+		klog.V(2).Infof("begin to create azure disk(%s) account type(%s) rg(%s) location(%s) size(%d) diskZone(%v) maxShares(%d)",
+			diskParams.DiskName, skuName, diskParams.ResourceGroup, diskParams.Location, requestGiB, volumeZone, diskParams.MaxShares)
+
+		diskIOPSReadWrite, diskMBPSReadWrite, logicalSectorSize := diskParams.DiskIOPSReadWrite, diskParams.DiskMBPSReadWrite, diskParams.LogicalSectorSize
+		if i > 0 && skuName != armcompute.DiskStorageAccountTypesUltraSSDLRS && skuName != armcompute.DiskStorageAccountTypesPremiumV2LRS {
+			// performance settings are only applicable to the preferred UltraSSD_LRS or PremiumV2_LRS sku
+			diskIOPSReadWrite, diskMBPSReadWrite, logicalSectorSize = "", "", 0
+		}
+		if skuName == armcompute.DiskStorageAccountTypesUltraSSDLRS {
+			if diskIOPSReadWrite == "" && diskMBPSReadWrite == "" {
+				// set default DiskIOPSReadWrite, DiskMBPSReadWrite per request size
+				diskIOPSReadWrite = strconv.Itoa(getDefaultDiskIOPSReadWrite(requestGiB))
+				diskMBPSReadWrite = strconv.Itoa(getDefaultDiskMBPSReadWrite(requestGiB))
+				klog.V(2).Infof("set default DiskIOPSReadWrite as %s, DiskMBPSReadWrite as %s on disk(%s)", diskIOPSReadWrite, diskMBPSReadWrite, diskParams.DiskName)
+			}
+		}
+
+		volumeOptions := &ManagedDiskOptions{
+			AvailabilityZone:    volumeZone,
+			BurstingEnabled:     diskParams.EnableBursting,
+			DiskEncryptionSetID: diskParams.DiskEncryptionSetID,
+			DiskEncryptionType:  diskParams.DiskEncryptionType,
+			DiskIOPSReadWrite:   diskIOPSReadWrite,
+			DiskMBpsReadWrite:   diskMBPSReadWrite,
+			DiskName:            diskParams.DiskName,
+			LogicalSectorSize:   int32(logicalSectorSize),
+			MaxShares:           int32(diskParams.MaxShares),
+			ResourceGroup:       diskParams.ResourceGroup,
+			SubscriptionID:      diskParams.SubscriptionID,
+			SizeGB:              requestGiB,
+			StorageAccountType:  skuName,
+			SourceResourceID:    sourceID,
+			SourceType:          sourceType,
+			Tags:                diskParams.Tags,
+			Location:            diskParams.Location,
+			PerformancePlus:     diskParams.PerformancePlus,
+		}
+
+		volumeOptions.SkipGetDiskOperation = d.isGetDiskThrottled()
+		volumeOptions.SupportsHibernation = diskParams.SupportsHibernation
+		volumeOptions.OptimizedForFrequentAttach = diskParams.OptimizedForFrequentAttach
+		volumeOptions.GalleryImageReferenceID = diskParams.GalleryImageReferenceID
+		volumeOptions.GalleryImageLun = diskParams.GalleryImageLun
+		volumeOptions.SecurityType = diskParams.SecurityType
+		volumeOptions.SecureVMDiskEncryptionSetID = diskParams.SecureVMDiskEncryptionSetID
+		volumeOptions.ClusterID = d.getDiskOwnerClusterID(ctx, diskParams.DiskName)
+		if importSource != nil {
+			volumeOptions.SourceURI = importSource.SourceURI
+			volumeOptions.StorageAccountID = importSource.StorageAccountID
+		}
+		// Azure Stack Cloud does not support NetworkAccessPolicy, PublicNetworkAccess
+		if !azureutils.IsAzureStackCloud(localCloud.Config.Cloud, localCloud.Config.DisableAzureStackCloud) {
+			volumeOptions.NetworkAccessPolicy = networkAccessPolicy
+			volumeOptions.PublicNetworkAccess = publicNetworkAccess
+			if diskParams.DiskAccessID != "" {
+				volumeOptions.DiskAccessID = &diskParams.DiskAccessID
+			}
+		}
+
+		diskURI, err = d.createManagedDiskWithDeadlineBudget(createCtx, localDiskController, volumeOptions)
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
+		if err != nil && i < len(skuNames)-1 && azureutils.IsSkuNotAvailableError(err) {
+			klog.Warningf("create azure disk(%s) with account type(%s) failed with %v, fall back to account type(%s)", diskParams.DiskName, skuName, err, skuNames[i+1])
+			continue
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), consts.NotFound) {
+				return nil, status.Error(codes.NotFound, err.Error())
+			}
+			if azureutils.IsQuotaExceededError(err) {
+				d.recordEvent(getPersistentVolumeClaimReference(diskParams.Tags[consts.PvcNamespaceTag], diskParams.Tags[consts.PvcNameTag]),
+					v1.EventTypeWarning, diskQuotaExceededReason, "failed to create disk %s with account type %s: %v", diskParams.DiskName, skuName, err)
+			}
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+		chosenSkuName = skuName
+		break
+	}
+	if diskParams.EnableAzureMonitor {
+		if err := localDiskController.CreateDiagnosticSetting(createCtx, diskURI, diskParams.WorkspaceID); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create diagnostic setting of disk(%s): %v", diskURI, err)
 		}
-		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 
+	if chosenSkuName == armcompute.DiskStorageAccountTypesPremiumV2LRS {
+		// PremiumV2LRS only supports None caching mode
+		azureutils.SetKeyValueInMap(diskParams.VolumeContext, consts.CachingModeField, string(v1.AzureDataDiskCachingNone))
+	}
+	if len(diskParams.SkuFallback) > 0 {
+		// record the sku the disk is actually created with
+		azureutils.SetKeyValueInMap(diskParams.VolumeContext, consts.SkuNameField, string(chosenSkuName))
+	}
 	isOperationSucceeded = true
-	klog.V(2).Infof("create azure disk(%s) account type(%s) rg(%s) location(%s) size(%d) tags(%s) successfully", diskParams.DiskName, skuName, diskParams.ResourceGroup, diskParams.Location, requestGiB, diskParams.Tags)
+	klog.V(2).Infof("create azure disk(%s) account type(%s) rg(%s) location(%s) size(%d) tags(%s) successfully", diskParams.DiskName, chosenSkuName, diskParams.ResourceGroup, diskParams.Location, requestGiB, diskParams.Tags)
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
@@ -346,6 +441,32 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}, nil
 }
 
+// getAccessibleTopology returns the zone the disk should be created in and the accessible topology of the volume,
+// ZRS disk is not created in any zone and could be scheduled on all zones and non-zone nodes
+func getAccessibleTopology(skuName armcompute.DiskStorageAccountTypes, diskZone, location string) (string, []*csi.Topology) {
+	if !strings.HasSuffix(strings.ToLower(string(skuName)), "zrs") {
+		return diskZone, []*csi.Topology{
+			{
+				Segments: map[string]string{topologyKey: diskZone},
+			},
+		}
+	}
+	accessibleTopology := []*csi.Topology{}
+	// make volume scheduled on all 3 availability zones
+	for i := 1; i <= 3; i++ {
+		topology := &csi.Topology{
+			Segments: map[string]string{topologyKey: fmt.Sprintf("%s-%d", location, i)},
+		}
+		accessibleTopology = append(accessibleTopology, topology)
+	}
+	// make volume scheduled on all non-zone nodes
+	topology := &csi.Topology{
+		Segments: map[string]string{topologyKey: ""},
+	}
+	accessibleTopology = append(accessibleTopology, topology)
+	return "", accessibleTopology
+}
+
 // DeleteVolume delete an azure disk
 func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
@@ -368,22 +489,114 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	}
 	defer d.volumeLocks.Release(volumeID)
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
 	klog.V(2).Infof("deleting azure disk(%s)", diskURI)
-	err := d.diskController.DeleteManagedDisk(ctx, diskURI)
+	ctx, cancel := withOperationTimeout(ctx, d.deleteVolumeTimeoutInSeconds)
+	defer cancel()
+	diskController := d.getDiskController()
+	if secretsCloud, err := d.getCloudFromSecrets(ctx, req.GetSecrets(), ""); err != nil {
+		return nil, err
+	} else if secretsCloud != nil {
+		diskController = d.newLocalDiskController(secretsCloud)
+	}
+	err := d.deleteManagedDiskWithDeadlineBudget(ctx, diskController, diskURI)
 	klog.V(2).Infof("delete azure disk(%s) returned with %v", diskURI, err)
 	isOperationSucceeded = (err == nil)
+	if err == nil {
+		d.cleanupResourceGroup(ctx, diskURI)
+		// the volume may be deleted without a successful ControllerUnpublishVolume, e.g. after its node is deleted
+		d.systemCriticalVolumes.Delete(strings.ToLower(diskURI))
+	}
 	return &csi.DeleteVolumeResponse{}, err
 }
 
-// ControllerGetVolume get volume
-func (d *Driver) ControllerGetVolume(context.Context, *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// ControllerGetVolume returns the published nodes and the condition of a volume, the volume is abnormal if the disk
+// is deleted out of band, or the disk is not found in the data disks of a VM it's attached to
+func (d *Driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	diskURI := req.GetVolumeId()
+	if len(diskURI) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in the request")
+	}
+	diskName, err := azureutils.GetDiskName(diskURI)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_get_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
+	isOperationSucceeded := false
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
+	}()
+
+	disk, err := d.checkDiskExists(ctx, diskURI)
+	if err != nil {
+		if strings.Contains(err.Error(), consts.ResourceNotFound) {
+			return nil, status.Errorf(codes.NotFound, "disk %s is not found: %v", diskURI, err)
+		}
+		return nil, status.Errorf(codes.Internal, "could not get disk %s: %v", diskURI, err)
+	}
+	if disk == nil {
+		return nil, status.Errorf(codes.Unavailable, "could not get disk %s since GetDisk is throttled", diskURI)
+	}
+
+	vmIDs := append([]*string{disk.ManagedBy}, disk.ManagedByExtended...)
+	publishedNodes := []string{}
+	abnormalMessages := []string{}
+	for _, vmID := range vmIDs {
+		if vmID == nil || *vmID == "" {
+			continue
+		}
+		nodeName, err := d.getCloud().VMSet.GetNodeNameByProviderID(ctx, *vmID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not get node name of %s: %v", *vmID, err)
+		}
+		if slices.Contains(publishedNodes, string(nodeName)) {
+			continue
+		}
+		publishedNodes = append(publishedNodes, string(nodeName))
+
+		diskController, err := d.getNodeDiskController(ctx, nodeName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+		if _, _, err := diskController.GetDiskLun(diskName, diskURI, nodeName); err != nil {
+			switch {
+			case err == cloudprovider.InstanceNotFound:
+				abnormalMessages = append(abnormalMessages, fmt.Sprintf("VM of node %s is not found", nodeName))
+			case strings.Contains(err.Error(), azureconsts.CannotFindDiskLUN):
+				abnormalMessages = append(abnormalMessages, fmt.Sprintf("disk is not found in the data disks of node %s", nodeName))
+			default:
+				return nil, status.Errorf(codes.Internal, "could not get lun of disk %s on node %s: %v", diskURI, nodeName, err)
+			}
+		}
+	}
+
+	volume := &csi.Volume{VolumeId: diskURI}
+	condition := &csi.VolumeCondition{}
+	if disk.Properties != nil {
+		if disk.Properties.DiskSizeGB != nil {
+			volume.CapacityBytes = volumehelper.GiBToBytes(int64(*disk.Properties.DiskSizeGB))
+		}
+		if disk.Properties.DiskState != nil {
+			condition.Message = fmt.Sprintf("disk state: %s", *disk.Properties.DiskState)
+		}
+	}
+	if len(abnormalMessages) > 0 {
+		condition = &csi.VolumeCondition{Abnormal: true, Message: strings.Join(abnormalMessages, ", ")}
+	}
+	isOperationSucceeded = true
+	return &csi.ControllerGetVolumeResponse{
+		Volume: volume,
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: publishedNodes,
+			VolumeCondition:  condition,
+		},
+	}, nil
 }
 
 // ControllerModifyVolume modify volume
@@ -413,16 +626,25 @@ func (d *Driver) ControllerModifyVolume(ctx context.Context, req *csi.Controller
 	}
 
 	// normalize values
-	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud)
+	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 	if diskParams.AccountType == "" {
 		skuName = ""
 	}
+	var cachingMode armcompute.CachingTypes
+	if diskParams.CachingMode != "" {
+		// host caching is set on the data disk entry of the VM, the new value is applied on the next attach
+		mode, err := azureutils.NormalizeCachingMode(diskParams.CachingMode)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		cachingMode = armcompute.CachingTypes(mode)
+	}
 
-	klog.V(2).Infof("begin to modify azure disk(%s) account type(%s) rg(%s) location(%s)",
-		diskParams.DiskName, skuName, diskParams.ResourceGroup, diskParams.Location)
+	klog.V(2).Infof("begin to modify azure disk(%s) account type(%s) caching mode(%s) rg(%s) location(%s)",
+		diskParams.DiskName, skuName, cachingMode, diskParams.ResourceGroup, diskParams.Location)
 
 	volumeOptions := &ManagedDiskOptions{
 		DiskIOPSReadWrite:  diskParams.DiskIOPSReadWrite,
@@ -433,15 +655,19 @@ func (d *Driver) ControllerModifyVolume(ctx context.Context, req *csi.Controller
 		StorageAccountType: skuName,
 		SourceResourceID:   diskURI,
 		SourceType:         consts.SourceVolume,
+		CachingMode:        cachingMode,
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_modify_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_modify_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
-	if err = d.diskController.ModifyDisk(ctx, volumeOptions); err != nil {
+	if err = d.modifyDiskWithDeadlineBudget(ctx, d.getDiskController(), volumeOptions); err != nil {
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
 		if strings.Contains(err.Error(), consts.NotFound) {
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
@@ -450,6 +676,7 @@ func (d *Driver) ControllerModifyVolume(ctx context.Context, req *csi.Controller
 
 	isOperationSucceeded = true
 	klog.V(2).Infof("modify azure disk(%s) account type(%s) rg(%s) location(%s) successfully", diskParams.DiskName, skuName, diskParams.ResourceGroup, diskParams.Location)
+	d.queueDiskPropertiesAnnotations(diskURI, "")
 
 	return &csi.ControllerModifyVolumeResponse{}, err
 }
@@ -492,13 +719,23 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_publish_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	if azureutils.IsSingleNodeSingleWriter(volCap) {
+		if err := d.checkSingleWriterAttachment(ctx, diskURI, getPVNameForDisk(req.GetVolumeContext(), disk), nodeName); err != nil {
+			return nil, err
+		}
+	}
+
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_publish_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI, consts.Node, string(nodeName))
 	}()
 
-	lun, vmState, err := d.diskController.GetDiskLun(diskName, diskURI, nodeName)
+	diskController, err := d.getNodeDiskController(ctx, nodeName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	lun, vmState, err := diskController.GetDiskLun(diskName, diskURI, nodeName)
 	if err == cloudprovider.InstanceNotFound {
 		return nil, status.Error(codes.NotFound, fmt.Sprintf("failed to get azure instance id for node %q (%v)", nodeName, err))
 	}
@@ -515,19 +752,36 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		volumeContext = map[string]string{}
 	}
 
+	if _, ok := volumeContext[consts.RequestedSizeGib]; !ok && d.normalizeAdoptedDisks && disk != nil {
+		// pre-provisioned disk, errors are only logged since normalization should not block the attach
+		var normalizeErr error
+		if disk, normalizeErr = d.normalizeAdoptedDisk(ctx, diskURI, disk, volumeContext); normalizeErr != nil {
+			klog.Warningf("failed to normalize adopted disk(%s): %v", diskURI, normalizeErr)
+		}
+	}
+
 	if err == nil {
 		if vmState != nil && strings.ToLower(*vmState) == "failed" {
 			klog.Warningf("VM(%s) is in failed state, update VM first", nodeName)
-			if err := d.diskController.UpdateVM(ctx, nodeName); err != nil {
+			if err := diskController.UpdateVM(ctx, nodeName); err != nil {
 				return nil, status.Errorf(codes.Internal, "update instance %q failed with %v", nodeName, err)
 			}
 		}
-		// Volume is already attached to node.
+		// Volume is already attached to node, e.g. a pod restarted on the same node within the detach grace window,
+		// the publish context is still built from the disk so that it matches the one of the original attach.
+		// the node class performance is applied again in case it failed at the original attach
+		if err := d.applyNodeClassPerformance(ctx, diskURI, diskName, nodeName, volumeContext, disk); err != nil {
+			return nil, err
+		}
 		klog.V(2).Infof("Attach operation is successful. volume %s is already attached to node %s at lun %d.", diskURI, nodeName, lun)
 	} else {
 		if !strings.Contains(err.Error(), azureconsts.CannotFindDiskLUN) {
 			return nil, status.Errorf(codes.Internal, "could not get disk lun for volume %s: %v", diskURI, err)
 		}
+		// existing attachments are kept, only new publishes of a volume under maintenance are blocked
+		if err := d.checkVolumeMaintenance(getPVNameForDisk(volumeContext, disk)); err != nil {
+			return nil, err
+		}
 		var cachingMode armcompute.CachingTypes
 		if cachingMode, err = azureutils.GetCachingMode(volumeContext); err != nil {
 			return nil, status.Errorf(codes.Internal, "%v", err)
@@ -538,10 +792,29 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 
 		attachDiskInitialDelay := azureutils.GetAttachDiskInitialDelay(volumeContext)
 		if attachDiskInitialDelay > 0 {
+			// the delay only applies to this attach, the disk controller is shared by all the volumes
 			klog.V(2).Infof("attachDiskInitialDelayInMs is set to %d", attachDiskInitialDelay)
-			d.diskController.AttachDetachInitialDelayInMs = attachDiskInitialDelay
+			ctx = withAttachDetachInitialDelay(ctx, attachDiskInitialDelay)
+		}
+		systemCritical := d.isSystemCriticalVolume(volumeContext, disk)
+		if systemCritical {
+			klog.V(2).Infof("volume %s is system-critical, attach with priority", diskURI)
+			ctx = withSystemCriticalOperation(ctx)
+			d.systemCriticalVolumes.Store(strings.ToLower(diskURI), true)
+		}
+		ctx, cancel := withOperationTimeout(ctx, d.attachTimeoutInSeconds)
+		defer cancel()
+		if err := d.applyNodeClassPerformance(ctx, diskURI, diskName, nodeName, volumeContext, disk); err != nil {
+			return nil, err
+		}
+		attachStart := time.Now()
+		defer func() {
+			d.checkAttachSLO(getPVNameForDisk(volumeContext, disk), diskURI, nodeName, time.Since(attachStart), isOperationSucceeded)
+		}()
+		lun, err = d.attachDiskWithDeadlineBudget(ctx, diskController, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns, d.acquireNodeAttachSlot)
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
 		}
-		lun, err = d.diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
 		if err == nil {
 			klog.V(2).Infof("Attach operation successful: volume %s attached to node %s.", diskURI, nodeName)
 		} else {
@@ -552,11 +825,23 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 					return nil, err
 				}
 				klog.Warningf("volume %s is already attached to node %s, try detach first", diskURI, derr.CurrentNode)
-				if err = d.diskController.DetachDisk(ctx, diskName, diskURI, derr.CurrentNode); err != nil {
+				d.recordEvent(getPersistentVolumeReference(getPVNameForDisk(volumeContext, disk)), v1.EventTypeWarning, danglingAttachmentReason,
+					"disk %s is still attached to node %s, detaching it before attaching to node %s", diskURI, derr.CurrentNode, nodeName)
+				currentNodeDiskController, controllerErr := d.getNodeDiskController(ctx, derr.CurrentNode)
+				if controllerErr != nil {
+					return nil, status.Errorf(codes.Internal, "%v", controllerErr)
+				}
+				if err = d.detachDiskWithDeadlineBudget(ctx, currentNodeDiskController, diskName, diskURI, derr.CurrentNode); err != nil {
+					if status.Code(err) == codes.DeadlineExceeded {
+						return nil, err
+					}
 					return nil, status.Errorf(codes.Internal, "Could not detach volume %s from node %s: %v", diskURI, derr.CurrentNode, err)
 				}
 				klog.V(2).Infof("Trying to attach volume %s to node %s again", diskURI, nodeName)
-				lun, err = d.diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
+				lun, err = d.attachDiskWithDeadlineBudget(ctx, diskController, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns, d.acquireNodeAttachSlot)
+				if status.Code(err) == codes.DeadlineExceeded {
+					return nil, err
+				}
 			}
 			if err != nil {
 				klog.Errorf("Attach volume %s to instance %s failed with %v", diskURI, nodeName, err)
@@ -568,6 +853,7 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 			}
 		}
 		klog.V(2).Infof("attach volume %s to node %s successfully", diskURI, nodeName)
+		d.refreshNodeDiskThroughputAnnotations(nodeName)
 	}
 
 	publishContext := map[string]string{consts.LUN: strconv.Itoa(int(lun))}
@@ -575,12 +861,220 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		if _, ok := volumeContext[consts.RequestedSizeGib]; !ok {
 			klog.V(6).Infof("found static PV(%s), insert disk properties to volumeattachments", diskURI)
 			azureutils.InsertDiskProperties(disk, publishContext)
+		} else if disk.Properties != nil && disk.Properties.CreationData != nil && disk.Properties.CreationData.LogicalSectorSize != nil {
+			// the node formats the disk according to its logical sector size
+			publishContext[consts.LogicalSectorSizeField] = strconv.Itoa(int(*disk.Properties.CreationData.LogicalSectorSize))
+		}
+	}
+	if pvName := getPVNameForDisk(volumeContext, disk); pvName != "" {
+		if err := d.updateDiskPropertiesAnnotations(ctx, pvName, disk); err != nil {
+			klog.V(4).Infof("update disk properties annotations of disk(%s) failed with %v, will retry in the background", diskURI, err)
+			d.queueDiskPropertiesAnnotations(diskURI, pvName)
 		}
 	}
 	isOperationSucceeded = true
 	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
 }
 
+// checkSingleWriterAttachment returns FailedPrecondition if the PV of a SINGLE_NODE_SINGLE_WRITER volume has a
+// VolumeAttachment on another node which is not being deleted, i.e. the volume is still used by a pod on that node.
+// A disk still attached to another node without VolumeAttachment is detached from it like other access modes.
+func (d *Driver) checkSingleWriterAttachment(ctx context.Context, diskURI, pvName string, nodeName types.NodeName) error {
+	if pvName == "" || d.kubeClient == nil {
+		klog.V(4).Infof("skip checking VolumeAttachments of volume %s with access mode %s", diskURI, csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER)
+		return nil
+	}
+	d.volumeAttachmentInformerOnce.Do(func() {
+		if d.volumeAttachmentInformerCtx != nil {
+			d.startVolumeAttachmentInformer(d.volumeAttachmentInformerCtx)
+		}
+	})
+	volumeAttachments, err := d.getVolumeAttachmentsOfPV(ctx, pvName)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to list VolumeAttachments: %v", err)
+	}
+	for _, va := range volumeAttachments {
+		if va.Spec.Attacher != d.Name || ptr.Deref(va.Spec.Source.PersistentVolumeName, "") != pvName || va.DeletionTimestamp != nil {
+			continue
+		}
+		if !strings.EqualFold(va.Spec.NodeName, string(nodeName)) {
+			return status.Errorf(codes.FailedPrecondition, "volume %s with access mode %s is already attached to node %s by VolumeAttachment %s",
+				diskURI, csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, va.Spec.NodeName, va.Name)
+		}
+	}
+	return nil
+}
+
+// startVolumeAttachmentInformer starts the informer of the VolumeAttachments indexed by the name of their PV, so that
+// the SINGLE_NODE_SINGLE_WRITER check does not list all VolumeAttachments on every publish. It's started by the first
+// SINGLE_NODE_SINGLE_WRITER publish, so that the clusters without such volumes do not cache all VolumeAttachments.
+func (d *Driver) startVolumeAttachmentInformer(ctx context.Context) {
+	factory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	informer := factory.Storage().V1().VolumeAttachments().Informer()
+	if err := informer.AddIndexers(cache.Indexers{volumeAttachmentPVIndex: volumeAttachmentPVIndexFunc}); err != nil {
+		klog.Errorf("failed to add VolumeAttachment indexer: %v", err)
+		return
+	}
+	d.volumeAttachmentIndexer = informer.GetIndexer()
+	d.volumeAttachmentIndexerSynced = informer.HasSynced
+	factory.Start(ctx.Done())
+}
+
+// volumeAttachmentPVIndexFunc indexes a VolumeAttachment by the name of its PV
+func volumeAttachmentPVIndexFunc(obj interface{}) ([]string, error) {
+	va, ok := obj.(*storagev1.VolumeAttachment)
+	if !ok || va.Spec.Source.PersistentVolumeName == nil {
+		return nil, nil
+	}
+	return []string{*va.Spec.Source.PersistentVolumeName}, nil
+}
+
+// getVolumeAttachmentsOfPV returns the VolumeAttachments of pvName from the informer cache, or from the API server
+// if the informer is not started or not synced yet
+func (d *Driver) getVolumeAttachmentsOfPV(ctx context.Context, pvName string) ([]*storagev1.VolumeAttachment, error) {
+	var volumeAttachments []*storagev1.VolumeAttachment
+	if d.volumeAttachmentIndexer != nil && d.volumeAttachmentIndexerSynced() {
+		objs, err := d.volumeAttachmentIndexer.ByIndex(volumeAttachmentPVIndex, pvName)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if va, ok := obj.(*storagev1.VolumeAttachment); ok {
+				volumeAttachments = append(volumeAttachments, va)
+			}
+		}
+		return volumeAttachments, nil
+	}
+	list, err := d.kubeClient.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		if ptr.Deref(list.Items[i].Spec.Source.PersistentVolumeName, "") == pvName {
+			volumeAttachments = append(volumeAttachments, &list.Items[i])
+		}
+	}
+	return volumeAttachments, nil
+}
+
+// applyNodeClassPerformance updates the IOPS and throughput of an UltraSSD_LRS or PremiumV2_LRS disk before it's attached,
+// according to the class of the node read from the nodeClassLabel label. Nodes without a matching class get the
+// diskIOPSReadWrite and diskMBpsReadWrite of the volume, so that the full performance is restored on promotion.
+// The node is read from the node informer cache and the disk is only updated if its performance differs from the
+// class of the node. Only invalid parameters fail the attach, a failed update is reported by an event and retried on
+// the next publish.
+func (d *Driver) applyNodeClassPerformance(ctx context.Context, diskURI, diskName string, nodeName types.NodeName, volumeContext map[string]string, disk *armcompute.Disk) error {
+	if !d.enableNodeClassPerformance {
+		return nil
+	}
+	var labelKey, defaultIOPS, defaultMBps string
+	var iopsByClass, mbpsByClass map[string]int64
+	var err error
+	for k, v := range volumeContext {
+		switch strings.ToLower(k) {
+		case consts.NodeClassLabelField:
+			labelKey = v
+		case consts.NodeClassDiskIOPSField:
+			if iopsByClass, err = azureutils.ParseNodeClassValues(v); err != nil {
+				return status.Errorf(codes.InvalidArgument, "parse %s failed with error: %v", k, err)
+			}
+		case consts.NodeClassDiskMBPSField:
+			if mbpsByClass, err = azureutils.ParseNodeClassValues(v); err != nil {
+				return status.Errorf(codes.InvalidArgument, "parse %s failed with error: %v", k, err)
+			}
+		case consts.DiskIOPSReadWriteField:
+			defaultIOPS = v
+		case consts.DiskMBPSReadWriteField:
+			defaultMBps = v
+		}
+	}
+	if labelKey == "" || (len(iopsByClass) == 0 && len(mbpsByClass) == 0) || disk == nil || disk.SKU == nil || disk.Properties == nil {
+		return nil
+	}
+	// a class value could not be restored on promotion without the performance of the volume
+	if defaultIOPS == "" {
+		iopsByClass = nil
+	}
+	if defaultMBps == "" {
+		mbpsByClass = nil
+	}
+	if len(iopsByClass) == 0 && len(mbpsByClass) == 0 {
+		klog.Warningf("skip applying node class performance on disk(%s) since %s and %s are not set", diskURI, consts.DiskIOPSReadWriteField, consts.DiskMBPSReadWriteField)
+		return nil
+	}
+	if sku := ptr.Deref(disk.SKU.Name, ""); sku != armcompute.DiskStorageAccountTypesUltraSSDLRS && sku != armcompute.DiskStorageAccountTypesPremiumV2LRS {
+		klog.V(2).Infof("skip applying node class performance on disk(%s) with sku %s", diskURI, sku)
+		return nil
+	}
+	currentIOPS := strconv.FormatInt(ptr.Deref(disk.Properties.DiskIOPSReadWrite, 0), 10)
+	currentMBps := strconv.FormatInt(ptr.Deref(disk.Properties.DiskMBpsReadWrite, 0), 10)
+	// the class of the node is not needed if the disk already has the performance of every class
+	if nodeClassPerformanceMatches(currentIOPS, defaultIOPS, iopsByClass) && nodeClassPerformanceMatches(currentMBps, defaultMBps, mbpsByClass) {
+		return nil
+	}
+	if d.nodeLister == nil {
+		klog.Warningf("skip applying node class performance on disk(%s) since the node informer is not running", diskURI)
+		return nil
+	}
+	if d.nodeListerSynced != nil && !d.nodeListerSynced() {
+		klog.Warningf("skip applying node class performance on disk(%s) since the node informer is not synced yet", diskURI)
+		return nil
+	}
+	node, err := d.nodeLister.Get(string(nodeName))
+	if err != nil {
+		klog.Warningf("skip applying node class performance on disk(%s) since getting node %s failed with %v", diskURI, nodeName, err)
+		return nil
+	}
+	nodeClass := node.Labels[labelKey]
+
+	diskIOPS, diskMBps := defaultIOPS, defaultMBps
+	if v, ok := iopsByClass[nodeClass]; ok {
+		diskIOPS = strconv.FormatInt(v, 10)
+	}
+	if v, ok := mbpsByClass[nodeClass]; ok {
+		diskMBps = strconv.FormatInt(v, 10)
+	}
+	if diskIOPS == currentIOPS {
+		diskIOPS = ""
+	}
+	if diskMBps == currentMBps {
+		diskMBps = ""
+	}
+	if diskIOPS == "" && diskMBps == "" {
+		return nil
+	}
+
+	klog.V(2).Infof("updating disk(%s) for node %s of class %q: DiskIOPSReadWrite: %s, DiskMBpsReadWrite: %s", diskURI, nodeName, nodeClass, diskIOPS, diskMBps)
+	if err := d.getDiskController().ModifyDisk(ctx, &ManagedDiskOptions{
+		DiskName:          diskName,
+		SourceResourceID:  diskURI,
+		DiskIOPSReadWrite: diskIOPS,
+		DiskMBpsReadWrite: diskMBps,
+	}); err != nil {
+		klog.Warningf("failed to update performance of disk(%s) for node %s: %v", diskURI, nodeName, err)
+		d.recordEvent(getPersistentVolumeReference(getPVNameForDisk(volumeContext, disk)), v1.EventTypeWarning, nodeClassPerformanceFailedReason,
+			"failed to update performance of disk %s for node %s of class %q: %v", diskURI, nodeName, nodeClass, err)
+	}
+	return nil
+}
+
+// nodeClassPerformanceMatches returns whether the current value of a disk equals the default value and the values of
+// all the classes, i.e. no class of node would change it
+func nodeClassPerformanceMatches(current, defaultValue string, valueByClass map[string]int64) bool {
+	if len(valueByClass) == 0 {
+		return true
+	}
+	if current != defaultValue {
+		return false
+	}
+	for _, v := range valueByClass {
+		if strconv.FormatInt(v, 10) != current {
+			return false
+		}
+	}
+	return true
+}
+
 // ControllerUnpublishVolume detach an azure disk from a required node
 func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 	diskURI := req.GetVolumeId()
@@ -599,15 +1093,31 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_unpublish_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_unpublish_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI, consts.Node, string(nodeName))
 	}()
 
+	diskController, err := d.getNodeDiskController(ctx, nodeName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
 	klog.V(2).Infof("Trying to detach volume %s from node %s", diskURI, nodeID)
 
-	if err := d.diskController.DetachDisk(ctx, diskName, diskURI, nodeName); err != nil {
+	_, systemCritical := d.systemCriticalVolumes.Load(strings.ToLower(diskURI))
+	if systemCritical {
+		klog.V(2).Infof("volume %s is system-critical, detach with priority", diskURI)
+		ctx = withSystemCriticalOperation(ctx)
+	}
+	ctx, cancel := withOperationTimeout(ctx, d.detachTimeoutInSeconds)
+	defer cancel()
+	err = d.detachDiskWithDeadlineBudget(ctx, diskController, diskName, diskURI, nodeName)
+	if status.Code(err) == codes.DeadlineExceeded {
+		return nil, err
+	}
+	if err != nil {
 		if strings.Contains(err.Error(), consts.ErrDiskNotFound) {
 			klog.Warningf("volume %s already detached from node %s", diskURI, nodeID)
 		} else {
@@ -620,6 +1130,8 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 		}
 	}
 	klog.V(2).Infof("detach volume %s from node %s successfully", diskURI, nodeID)
+	d.refreshNodeDiskThroughputAnnotations(nodeName)
+	d.systemCriticalVolumes.Delete(strings.ToLower(diskURI))
 	isOperationSucceeded = true
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
@@ -668,7 +1180,7 @@ func (d *Driver) getOccupiedLunsFromNode(ctx context.Context, nodeName types.Nod
 		now := time.Now()
 		if usedLunsFromVA, err := d.getUsedLunsFromVolumeAttachments(ctx, string(nodeName)); err == nil {
 			if len(usedLunsFromVA) > 0 {
-				if usedLunsFromNode, err := d.getUsedLunsFromNode(nodeName); err == nil {
+				if usedLunsFromNode, err := d.getUsedLunsFromNode(ctx, nodeName); err == nil {
 					occupiedLuns = volumehelper.GetElementsInArray1NotInArray2(usedLunsFromVA, usedLunsFromNode)
 					if len(occupiedLuns) > 0 {
 						klog.Warningf("node: %s, usedLuns from VolumeAttachments: %v, usedLuns from Node: %v, occupiedLuns: %v, disk: %s", nodeName, usedLunsFromVA, usedLunsFromNode, occupiedLuns, diskURI)
@@ -718,17 +1230,17 @@ func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (
 			return nil, status.Errorf(codes.Aborted, "ListVolumes starting token(%d) can not be negative", start)
 		}
 	}
-	if d.cloud.KubeClient != nil && d.cloud.KubeClient.CoreV1() != nil && d.cloud.KubeClient.CoreV1().PersistentVolumes() != nil {
+	if d.getCloud().KubeClient != nil && d.getCloud().KubeClient.CoreV1() != nil && d.getCloud().KubeClient.CoreV1().PersistentVolumes() != nil {
 		klog.V(6).Infof("List Volumes in Cluster:")
 		return d.listVolumesInCluster(ctx, start, int(req.MaxEntries))
 	}
-	klog.V(6).Infof("List Volumes in Node Resource Group: %s", d.cloud.ResourceGroup)
+	klog.V(6).Infof("List Volumes in Node Resource Group: %s", d.getCloud().ResourceGroup)
 	return d.listVolumesInNodeResourceGroup(ctx, start, int(req.MaxEntries))
 }
 
 // listVolumesInCluster is a helper function for ListVolumes used for when there is an available kubeclient
 func (d *Driver) listVolumesInCluster(ctx context.Context, start, maxEntries int) (*csi.ListVolumesResponse, error) {
-	pvList, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	pvList, err := d.getCloud().KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "ListVolumes failed while fetching PersistentVolumes List with error: %v", err)
 	}
@@ -749,8 +1261,8 @@ func (d *Driver) listVolumesInCluster(ctx context.Context, start, maxEntries int
 				continue
 			}
 			subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-			if !strings.EqualFold(subsID, d.cloud.SubscriptionID) {
-				klog.V(6).Infof("disk(%s) not in current subscription(%s), skip", diskURI, d.cloud.SubscriptionID)
+			if !strings.EqualFold(subsID, d.getCloud().SubscriptionID) {
+				klog.V(6).Infof("disk(%s) not in current subscription(%s), skip", diskURI, d.getCloud().SubscriptionID)
 				continue
 			}
 			rg, diskURI = strings.ToLower(rg), strings.ToLower(diskURI)
@@ -816,7 +1328,7 @@ func (d *Driver) listVolumesInCluster(ctx context.Context, start, maxEntries int
 // listVolumesInNodeResourceGroup is a helper function for ListVolumes used for when there is no available kubeclient
 func (d *Driver) listVolumesInNodeResourceGroup(ctx context.Context, start, maxEntries int) (*csi.ListVolumesResponse, error) {
 	entries := []*csi.ListVolumesResponse_Entry{}
-	listStatus := d.listVolumesByResourceGroup(ctx, d.cloud.ResourceGroup, entries, start, maxEntries, nil)
+	listStatus := d.listVolumesByResourceGroup(ctx, d.getCloud().ResourceGroup, entries, start, maxEntries, nil)
 	if listStatus.err != nil {
 		return nil, listStatus.err
 	}
@@ -836,7 +1348,7 @@ func (d *Driver) listVolumesInNodeResourceGroup(ctx context.Context, start, maxE
 
 // listVolumesByResourceGroup is a helper function that updates the ListVolumeResponse_Entry slice and returns number of total visited volumes, number of volumes that needs to be visited and an error if found
 func (d *Driver) listVolumesByResourceGroup(ctx context.Context, resourceGroup string, entries []*csi.ListVolumesResponse_Entry, start, maxEntries int, volSet map[string]bool) listVolumeStatus {
-	diskClient := d.clientFactory.GetDiskClient()
+	diskClient := d.getClientFactory().GetDiskClient()
 	disks, derr := diskClient.List(ctx, resourceGroup)
 	if derr != nil {
 		return listVolumeStatus{err: status.Errorf(codes.Internal, "ListVolumes on rg(%s) failed with error: %v", resourceGroup, derr)}
@@ -852,7 +1364,7 @@ func (d *Driver) listVolumesByResourceGroup(ctx context.Context, resourceGroup s
 	if start > 0 && start >= len(disks) {
 		return listVolumeStatus{
 			numVisited: len(disks),
-			err:        status.Errorf(codes.FailedPrecondition, "ListVolumes starting token(%d) on rg(%s) is greater than total number of volumes", start, d.cloud.ResourceGroup),
+			err:        status.Errorf(codes.FailedPrecondition, "ListVolumes starting token(%d) on rg(%s) is greater than total number of volumes", start, d.getCloud().ResourceGroup),
 		}
 	}
 	if start < 0 {
@@ -877,7 +1389,7 @@ func (d *Driver) listVolumesByResourceGroup(ctx context.Context, resourceGroup s
 			nodeList := []string{}
 
 			if disk.ManagedBy != nil {
-				attachedNode, err := d.cloud.VMSet.GetNodeNameByProviderID(ctx, *disk.ManagedBy)
+				attachedNode, err := d.getCloud().VMSet.GetNodeNameByProviderID(ctx, *disk.ManagedBy)
 				if err != nil {
 					return listVolumeStatus{err: err}
 				}
@@ -931,7 +1443,7 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	}
 
 	subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := d.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get disk client for subscription(%s) with error(%v)", subsID, err)
 	}
@@ -944,14 +1456,17 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	}
 	oldSize := *resource.NewQuantity(int64(*result.Properties.DiskSizeGB), resource.BinarySI)
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_expand_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_expand_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
 	klog.V(2).Infof("begin to expand azure disk(%s) with new size(%d)", diskURI, requestSize.Value())
-	newSize, err := d.diskController.ResizeDisk(ctx, diskURI, oldSize, requestSize, d.enableDiskOnlineResize)
+	newSize, err := d.resizeDiskWithDeadlineBudget(ctx, d.getDiskController(), diskURI, oldSize, requestSize)
+	if status.Code(err) == codes.DeadlineExceeded {
+		return nil, err
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to resize disk(%s) with error(%v)", diskURI, err)
 	}
@@ -963,6 +1478,7 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 
 	isOperationSucceeded = true
 	klog.V(2).Infof("expand azure disk(%s) successfully, currentSize(%d)", diskURI, currentSize)
+	d.queueDiskPropertiesAnnotations(diskURI, "")
 
 	return &csi.ControllerExpandVolumeResponse{
 		CapacityBytes:         currentSize,
@@ -986,10 +1502,12 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	var customTags string
 	// set incremental snapshot as true by default
 	incremental := true
-	var subsID, resourceGroup, dataAccessAuthMode, tagValueDelimiter string
+	var subsID, resourceGroup, dataAccessAuthMode, tagValueDelimiter, snapshotContentName, userAgent string
+	var fsFreeze bool
 	var err error
-	localCloud := d.cloud
-	location := d.cloud.Location
+	localCloud := d.getCloud()
+	clientFactory := d.getClientFactory()
+	location := d.getCloud().Location
 
 	tags := make(map[string]*string)
 
@@ -1007,29 +1525,53 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		case consts.LocationField:
 			location = v
 		case consts.UserAgentField:
-			newUserAgent := v
-			localCloud, err = azureutils.GetCloudProviderFromClient(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, newUserAgent,
-				d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort)
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "create cloud with UserAgent(%s) failed with: (%s)", newUserAgent, err)
-			}
+			userAgent = v
 		case consts.SubscriptionIDField:
 			subsID = v
 		case consts.DataAccessAuthModeField:
 			dataAccessAuthMode = v
 		case consts.TagValueDelimiterField:
 			tagValueDelimiter = v
+		case consts.FsFreezeField:
+			if fsFreeze, err = strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in VolumeSnapshotClass", k, v)
+			}
 		case consts.VolumeSnapshotNameKey:
 			tags[consts.SnapshotNameTag] = ptr.To(v)
 		case consts.VolumeSnapshotNamespaceKey:
 			tags[consts.SnapshotNamespaceTag] = ptr.To(v)
 		case consts.VolumeSnapshotContentNameKey:
-			// ignore the key
+			snapshotContentName = v
+		case consts.SnapshotRetentionDaysField:
+			if days, err := strconv.Atoi(v); err != nil || days <= 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in VolumeSnapshotClass, it must be a positive integer", k, v)
+			}
+			tags[consts.SnapshotRetentionDaysTag] = ptr.To(v)
+		case consts.MaxSnapshotsPerVolumeField:
+			if count, err := strconv.Atoi(v); err != nil || count <= 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in VolumeSnapshotClass, it must be a positive integer", k, v)
+			}
+			tags[consts.MaxSnapshotsPerVolumeTag] = ptr.To(v)
 		default:
 			return nil, status.Errorf(codes.Internal, "AzureDisk - invalid option %s in VolumeSnapshotClass", k)
 		}
 	}
 
+	secretsCloud, err := d.getCloudFromSecrets(ctx, req.GetSecrets(), userAgent)
+	if err != nil {
+		return nil, err
+	}
+	if secretsCloud != nil {
+		localCloud = secretsCloud
+		clientFactory = secretsCloud.ComputeClientFactory
+	} else if userAgent != "" {
+		localCloud, err = azureutils.GetCloudProviderFromClient(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, userAgent,
+			d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort, d.clientRateLimitOptions)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "create cloud with UserAgent(%s) failed with: (%s)", userAgent, err)
+		}
+	}
+
 	if azureutils.IsAzureStackCloud(localCloud.Config.Cloud, localCloud.Config.DisableAzureStackCloud) {
 		klog.V(2).Info("Use full snapshot instead as Azure Stack does not support incremental snapshot.")
 		incremental = false
@@ -1047,7 +1589,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
 	}
 	tags[azureconsts.CreatedByTag] = ptr.To(consts.AzureDiskDriverTag)
-	tags["source_volume_id"] = ptr.To(sourceVolumeID)
+	tags[consts.SourceVolumeIDTag] = ptr.To(sourceVolumeID)
 	for k, v := range customTagsMap {
 		value := v
 		tags[k] = &value
@@ -1061,15 +1603,15 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 			},
 			Incremental: &incremental,
 		},
-		Location: &d.cloud.Location,
+		Location: &d.getCloud().Location,
 		Tags:     tags,
 	}
 
-	if d.cloud.HasExtendedLocation() {
-		klog.V(2).Infof("extended location Name:%s Type:%s is set on snapshot %s, source volume %s", d.cloud.ExtendedLocationName, d.cloud.ExtendedLocationType, snapshotName, sourceVolumeID)
+	if d.getCloud().HasExtendedLocation() {
+		klog.V(2).Infof("extended location Name:%s Type:%s is set on snapshot %s, source volume %s", d.getCloud().ExtendedLocationName, d.getCloud().ExtendedLocationType, snapshotName, sourceVolumeID)
 		snapshot.ExtendedLocation = &armcompute.ExtendedLocation{
-			Name: to.Ptr(d.cloud.ExtendedLocationName),
-			Type: to.Ptr(armcompute.ExtendedLocationTypes(d.cloud.ExtendedLocationType)),
+			Name: to.Ptr(d.getCloud().ExtendedLocationName),
+			Type: to.Ptr(armcompute.ExtendedLocationTypes(d.getCloud().ExtendedLocationType)),
 		}
 	}
 
@@ -1086,7 +1628,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	defer d.volumeLocks.Release(snapshotName)
 
 	var crossRegionSnapshotName string
-	if location != "" && location != d.cloud.Location {
+	if location != "" && location != d.getCloud().Location {
 		if incremental {
 			crossRegionSnapshotName = snapshotName
 			snapshotName = azureutils.CreateValidDiskName("local_" + snapshotName)
@@ -1099,18 +1641,43 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	if crossRegionSnapshotName != "" {
 		metricsRequest = "controller_create_snapshot_cross_region"
 	}
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, metricsRequest, d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, metricsRequest, d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.SourceResourceID, sourceVolumeID, consts.SnapshotName, snapshotName)
 	}()
 
-	klog.V(2).Infof("begin to create snapshot(%s, incremental: %v) under rg(%s) region(%s)", snapshotName, incremental, resourceGroup, d.cloud.Location)
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
+	klog.V(2).Infof("begin to create snapshot(%s, incremental: %v) under rg(%s) region(%s)", snapshotName, incremental, resourceGroup, d.getCloud().Location)
+	snapshotClient, err := clientFactory.GetSnapshotClientForSub(subsID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
 	}
-	if _, err := snapshotClient.CreateOrUpdate(ctx, resourceGroup, snapshotName, snapshot); err != nil {
+	if crossRegionSnapshotName != "" {
+		// the background copy of a cross region snapshot may have been started by a previous call
+		csiSnapshot, err := d.checkCrossRegionSnapshotCopy(ctx, snapshotClient, resourceGroup, crossRegionSnapshotName, snapshotName, sourceVolumeID, getVolumeSnapshotContentReference(snapshotContentName))
+		if err != nil {
+			return nil, err
+		}
+		if csiSnapshot != nil {
+			isOperationSucceeded = true
+			return &csi.CreateSnapshotResponse{Snapshot: csiSnapshot}, nil
+		}
+	}
+
+	thaw := func() {}
+	if fsFreeze {
+		if thaw, err = d.freezeSourceVolume(ctx, sourceVolumeID); err != nil {
+			return nil, err
+		}
+	}
+	err = d.createSnapshotWithDeadlineBudget(ctx, snapshotClient, subsID, resourceGroup, snapshotName, snapshot)
+	// the point in time of the snapshot is fixed once the creation is accepted, before the deadline budget runs out,
+	// so the filesystem is thawed even if the creation is left running in the background
+	thaw()
+	if status.Code(err) == codes.DeadlineExceeded {
+		return nil, err
+	}
+	if err != nil {
 		if strings.Contains(err.Error(), "existing disk") {
 			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("request snapshot(%s) under rg(%s) already exists, but the SourceVolumeId is different, error details: %v", snapshotName, resourceGroup, err))
 		}
@@ -1120,13 +1687,13 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	}
 
 	if d.shouldWaitForSnapshotReady {
-		if err := d.waitForSnapshotReady(ctx, subsID, resourceGroup, snapshotName, waitForSnapshotReadyInterval, waitForSnapshotReadyTimeout); err != nil {
+		if err := d.waitForSnapshotReady(ctx, clientFactory, subsID, resourceGroup, snapshotName, waitForSnapshotReadyInterval, waitForSnapshotReadyTimeout); err != nil {
 			return nil, status.Error(codes.Internal, fmt.Sprintf("waitForSnapshotReady(%s, %s, %s) failed with %v", subsID, resourceGroup, snapshotName, err))
 		}
 	}
-	klog.V(2).Infof("create snapshot(%s) under rg(%s) region(%s) successfully", snapshotName, resourceGroup, d.cloud.Location)
+	klog.V(2).Infof("create snapshot(%s) under rg(%s) region(%s) successfully", snapshotName, resourceGroup, d.getCloud().Location)
 
-	csiSnapshot, err := d.getSnapshotByID(ctx, subsID, resourceGroup, snapshotName, sourceVolumeID)
+	csiSnapshot, err := d.getSnapshotByID(ctx, clientFactory, subsID, resourceGroup, snapshotName, sourceVolumeID)
 	if err != nil {
 		return nil, err
 	}
@@ -1154,21 +1721,13 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		}
 		klog.V(2).Infof("create snapshot(%s) under rg(%s) region(%s) successfully", crossRegionSnapshotName, resourceGroup, location)
 
-		if err := d.waitForSnapshotReady(ctx, subsID, resourceGroup, crossRegionSnapshotName, waitForSnapshotReadyInterval, waitForSnapshotReadyTimeout); err != nil {
-			return nil, status.Error(codes.Internal, fmt.Sprintf("waitForSnapshotReady(%s, %s, %s) failed with %v", subsID, resourceGroup, crossRegionSnapshotName, err))
-		}
-
-		klog.V(2).Infof("begin to delete snapshot(%s) under rg(%s) region(%s)", snapshotName, resourceGroup, d.cloud.Location)
-		if err = snapshotClient.Delete(ctx, resourceGroup, snapshotName); err != nil {
-			klog.Errorf("delete snapshot error: %v", err)
-			azureutils.SleepIfThrottled(err, consts.SnapshotOpThrottlingSleepSec)
-		} else {
-			klog.V(2).Infof("delete snapshot(%s) under rg(%s) region(%s) successfully", snapshotName, resourceGroup, d.cloud.Location)
-		}
-
-		csiSnapshot, err = d.getSnapshotByID(ctx, subsID, resourceGroup, crossRegionSnapshotName, sourceVolumeID)
+		// data is copied in background, the snapshot is not ready to use until the copy is completed
+		csiSnapshot, err = d.checkCrossRegionSnapshotCopy(ctx, snapshotClient, resourceGroup, crossRegionSnapshotName, snapshotName, sourceVolumeID, getVolumeSnapshotContentReference(snapshotContentName))
 		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, err
+		}
+		if csiSnapshot == nil {
+			return nil, status.Errorf(codes.Internal, "snapshot(%s) under rg(%s) not found after creation", crossRegionSnapshotName, resourceGroup)
 		}
 	}
 
@@ -1189,7 +1748,7 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 	var err error
 	var subsID string
 	snapshotName := snapshotID
-	resourceGroup := d.cloud.ResourceGroup
+	resourceGroup := d.getCloud().ResourceGroup
 
 	if azureutils.IsARMResourceID(snapshotID) {
 		snapshotName, resourceGroup, subsID, err = d.getSnapshotInfo(snapshotID)
@@ -1198,22 +1757,32 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 		}
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_snapshot", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_snapshot", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.SnapshotID, snapshotID)
 	}()
 
+	clientFactory := d.getClientFactory()
+	if secretsCloud, err := d.getCloudFromSecrets(ctx, req.GetSecrets(), ""); err != nil {
+		return nil, err
+	} else if secretsCloud != nil {
+		clientFactory = secretsCloud.ComputeClientFactory
+	}
 	klog.V(2).Infof("begin to delete snapshot(%s) under rg(%s)", snapshotName, resourceGroup)
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
+	snapshotClient, err := clientFactory.GetSnapshotClientForSub(subsID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
 	}
-	if err := snapshotClient.Delete(ctx, resourceGroup, snapshotName); err != nil {
+	if err := d.deleteSnapshotWithDeadlineBudget(ctx, snapshotClient, subsID, resourceGroup, snapshotName); err != nil {
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
 		azureutils.SleepIfThrottled(err, consts.SnapshotOpThrottlingSleepSec)
 		return nil, status.Error(codes.Internal, fmt.Sprintf("delete snapshot error: %v", err))
 	}
 	klog.V(2).Infof("delete snapshot(%s) under rg(%s) successfully", snapshotName, resourceGroup)
+	d.cancelCrossRegionSnapshotCopy(ctx, snapshotClient, resourceGroup, snapshotName)
 	isOperationSucceeded = true
 	return &csi.DeleteSnapshotResponse{}, nil
 }
@@ -1222,7 +1791,7 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 	// SnapshotId is not empty, return snapshot that match the snapshot id.
 	if len(req.GetSnapshotId()) != 0 {
-		snapshot, err := d.getSnapshotByID(ctx, "", d.cloud.ResourceGroup, req.GetSnapshotId(), req.SourceVolumeId)
+		snapshot, err := d.getSnapshotByID(ctx, d.getClientFactory(), "", d.getCloud().ResourceGroup, req.GetSnapshotId(), req.SourceVolumeId)
 		if err != nil {
 			if strings.Contains(err.Error(), consts.ResourceNotFound) {
 				return &csi.ListSnapshotsResponse{}, nil
@@ -1239,9 +1808,9 @@ func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReques
 		}
 		return listSnapshotResp, nil
 	}
-	snapshotClient := d.clientFactory.GetSnapshotClient()
+	snapshotClient := d.getClientFactory().GetSnapshotClient()
 	// no SnapshotId is set, return all snapshots that satisfy the request.
-	snapshots, err := snapshotClient.List(ctx, d.cloud.ResourceGroup)
+	snapshots, err := snapshotClient.List(ctx, d.getCloud().ResourceGroup)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list snapshot error: %v", err.Error()))
 	}
@@ -1249,7 +1818,7 @@ func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReques
 	return azureutils.GetEntriesAndNextToken(req, snapshots)
 }
 
-func (d *Driver) getSnapshotByID(ctx context.Context, subsID, resourceGroup, snapshotID, sourceVolumeID string) (*csi.Snapshot, error) {
+func (d *Driver) getSnapshotByID(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, snapshotID, sourceVolumeID string) (*csi.Snapshot, error) {
 	var err error
 	snapshotName := snapshotID
 	if azureutils.IsARMResourceID(snapshotID) {
@@ -1258,7 +1827,7 @@ func (d *Driver) getSnapshotByID(ctx context.Context, subsID, resourceGroup, sna
 			return nil, status.Errorf(codes.Internal, "%v", err)
 		}
 	}
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
+	snapshotClient, err := clientFactory.GetSnapshotClientForSub(subsID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
 	}
@@ -1271,11 +1840,11 @@ func (d *Driver) getSnapshotByID(ctx context.Context, subsID, resourceGroup, sna
 }
 
 // GetSourceDiskSize recursively searches for the sourceDisk and returns: sourceDisk disk size, error
-func (d *Driver) GetSourceDiskSize(ctx context.Context, subsID, resourceGroup, diskName string, curDepth, maxDepth int) (*int32, *armcompute.Disk, error) {
+func (d *Driver) GetSourceDiskSize(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, diskName string, curDepth, maxDepth int) (*int32, *armcompute.Disk, error) {
 	if curDepth > maxDepth {
 		return nil, nil, status.Error(codes.Internal, fmt.Sprintf("current depth (%d) surpassed the max depth (%d) while searching for the source disk size", curDepth, maxDepth))
 	}
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := clientFactory.GetDiskClientForSub(subsID)
 	if err != nil {
 		return nil, nil, status.Error(codes.Internal, err.Error())
 	}
@@ -1292,7 +1861,7 @@ func (d *Driver) GetSourceDiskSize(ctx context.Context, subsID, resourceGroup, d
 		sourceResourceID := *result.Properties.CreationData.SourceResourceID
 		parentResourceGroup, _ := azureutils.GetResourceGroupFromURI(sourceResourceID)
 		parentDiskName := path.Base(sourceResourceID)
-		return d.GetSourceDiskSize(ctx, subsID, parentResourceGroup, parentDiskName, curDepth+1, maxDepth)
+		return d.GetSourceDiskSize(ctx, clientFactory, subsID, parentResourceGroup, parentDiskName, curDepth+1, maxDepth)
 	}
 
 	if (*result.Properties).DiskSizeGB == nil {
@@ -1301,6 +1870,105 @@ func (d *Driver) GetSourceDiskSize(ctx context.Context, subsID, resourceGroup, d
 	return (*result.Properties).DiskSizeGB, result, nil
 }
 
+// prepareSnapshotForZonalRestore makes sure the snapshot could be restored into diskZone,
+// the disk is always created in the requested zone even if the snapshot was taken from a disk in another zone.
+// Unavailable is returned while the data of the snapshot is still being copied, so that csi-provisioner retries
+// CreateVolume instead of CreateVolume waiting for the copy.
+func (d *DriverCore) prepareSnapshotForZonalRestore(ctx context.Context, clientFactory azclient.ClientFactory, snapshotID, location, diskZone string) error {
+	snapshotName, err := azureutils.GetSnapshotNameFromURI(snapshotID)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to parse snapshot(%s): %v", snapshotID, err)
+	}
+	resourceGroup, err := azureutils.GetResourceGroupFromURI(snapshotID)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to parse snapshot(%s): %v", snapshotID, err)
+	}
+	subsID := azureutils.GetSubscriptionIDFromURI(snapshotID)
+	sourceZone, err := d.getSnapshotSourceDiskZone(ctx, clientFactory, subsID, resourceGroup, snapshotName, location)
+	if err != nil {
+		if !strings.Contains(err.Error(), consts.ResourceNotFound) {
+			return status.Errorf(codes.Internal, "failed to get source disk zone of snapshot(%s): %v", snapshotID, err)
+		}
+		// the zone of a deleted source disk is unknown, the snapshot must be ready in any case
+		klog.V(2).Infof("source disk of snapshot(%s) is not found, restoring it into zone(%s)", snapshotID, diskZone)
+	} else if sourceZone == "" || strings.EqualFold(sourceZone, diskZone) {
+		return nil
+	} else {
+		klog.V(2).Infof("snapshot(%s) was taken from a disk in zone(%s), restoring it into zone(%s)", snapshotID, sourceZone, diskZone)
+	}
+
+	// data of an incremental snapshot must be fully copied before it could be restored into another zone
+	completionPercent, err := d.getSnapshotCompletionPercent(ctx, clientFactory, subsID, resourceGroup, snapshotName)
+	if err != nil {
+		return status.Errorf(codes.Internal, "getSnapshotCompletionPercent(%s, %s, %s) failed with %v", subsID, resourceGroup, snapshotName, err)
+	}
+	if completionPercent < float32(100.0) {
+		return status.Errorf(codes.Unavailable, "snapshot(%s) is %.1f%% copied, it could be restored into zone(%s) once the copy is completed", snapshotID, completionPercent, diskZone)
+	}
+	return nil
+}
+
+// getSnapshotSizeGiB returns the size of the disk the snapshot was taken from, nil is returned if the size is unknown
+func (d *DriverCore) getSnapshotSizeGiB(ctx context.Context, clientFactory azclient.ClientFactory, snapshotID string) (*int32, error) {
+	snapshotName, err := azureutils.GetSnapshotNameFromURI(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	resourceGroup, err := azureutils.GetResourceGroupFromURI(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	snapshotClient, err := clientFactory.GetSnapshotClientForSub(azureutils.GetSubscriptionIDFromURI(snapshotID))
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := snapshotClient.Get(ctx, resourceGroup, snapshotName)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil || snapshot.Properties == nil {
+		return nil, nil
+	}
+	return snapshot.Properties.DiskSizeGB, nil
+}
+
+// getSnapshotSourceDiskZone returns the zone(e.g. eastus-1) of the disk the snapshot was taken from, empty string is returned if the source disk is not zonal
+func (d *DriverCore) getSnapshotSourceDiskZone(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, snapshotName, location string) (string, error) {
+	snapshotClient, err := clientFactory.GetSnapshotClientForSub(subsID)
+	if err != nil {
+		return "", err
+	}
+	snapshot, err := snapshotClient.Get(ctx, resourceGroup, snapshotName)
+	if err != nil {
+		return "", err
+	}
+	if snapshot.Properties == nil || snapshot.Properties.CreationData == nil || snapshot.Properties.CreationData.SourceResourceID == nil {
+		return "", nil
+	}
+
+	sourceDiskURI := *snapshot.Properties.CreationData.SourceResourceID
+	sourceDiskName, err := azureutils.GetDiskName(sourceDiskURI)
+	if err != nil {
+		return "", err
+	}
+	sourceResourceGroup, err := azureutils.GetResourceGroupFromURI(sourceDiskURI)
+	if err != nil {
+		return "", err
+	}
+	diskClient, err := clientFactory.GetDiskClientForSub(azureutils.GetSubscriptionIDFromURI(sourceDiskURI))
+	if err != nil {
+		return "", err
+	}
+	disk, err := diskClient.Get(ctx, sourceResourceGroup, sourceDiskName)
+	if err != nil {
+		return "", err
+	}
+	if len(disk.Zones) != 1 || disk.Zones[0] == nil {
+		return "", nil
+	}
+	return fmt.Sprintf("%s-%s", location, *disk.Zones[0]), nil
+}
+
 // The format of snapshot id is /subscriptions/xxx/resourceGroups/xxx/providers/Microsoft.Compute/snapshots/snapshot-xxx-xxx.
 func (d *Driver) getSnapshotInfo(snapshotID string) (snapshotName, resourceGroup, subsID string, err error) {
 	if snapshotName, err = azureutils.GetSnapshotNameFromURI(snapshotID); err != nil {
@@ -34,6 +34,8 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/utils/ptr"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azuredisk/mockcorev1"
@@ -379,6 +381,87 @@ func TestCreateVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "gallery image with volume content source",
+			testFunc: func(t *testing.T) {
+				cntl := gomock.NewController(t)
+				defer cntl.Finish()
+				d, _ := NewFakeDriver(cntl)
+				mp := map[string]string{
+					consts.GalleryImageReferenceIDField: "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/image/versions/1.0.0",
+				}
+				volumecontensource := csi.VolumeContentSource{
+					Type: &csi.VolumeContentSource_Snapshot{},
+				}
+				req := &csi.CreateVolumeRequest{
+					Name:                "unit-test",
+					VolumeCapabilities:  createVolumeCapabilities(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+					Parameters:          mp,
+					VolumeContentSource: &volumecontensource,
+				}
+				_, err := d.CreateVolume(context.Background(), req)
+				expectedErr := status.Error(codes.InvalidArgument, "galleryimagereferenceid could not be used with a volume content source")
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "confidential VM security profile",
+			testFunc: func(t *testing.T) {
+				cntl := gomock.NewController(t)
+				defer cntl.Finish()
+				d, _ := NewFakeDriver(cntl)
+				desID := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/diskEncryptionSets/des"
+				mp := map[string]string{
+					consts.SecurityTypeField:                string(armcompute.DiskSecurityTypesConfidentialVMDiskEncryptedWithCustomerKey),
+					consts.SecureVMDiskEncryptionSetIDField: desID,
+					consts.GalleryImageReferenceIDField:     "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/image/versions/1.0.0",
+				}
+				req := &csi.CreateVolumeRequest{
+					Name:               testVolumeName,
+					VolumeCapabilities: stdVolumeCapabilities,
+					Parameters:         mp,
+				}
+				id := fmt.Sprintf(consts.ManagedDiskPath, "subs", "rg", testVolumeName)
+				disk := &armcompute.Disk{
+					ID:         &id,
+					Name:       &testVolumeName,
+					Properties: &armcompute.DiskProperties{ProvisioningState: ptr.To("Succeeded")},
+				}
+				diskClient := mock_diskclient.NewMockInterface(cntl)
+				d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+				diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
+				diskClient.EXPECT().CreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, _, _ string, model armcompute.Disk) (*armcompute.Disk, error) {
+						securityProfile := model.Properties.SecurityProfile
+						if securityProfile == nil || *securityProfile.SecurityType != armcompute.DiskSecurityTypesConfidentialVMDiskEncryptedWithCustomerKey ||
+							ptr.Deref(securityProfile.SecureVMDiskEncryptionSetID, "") != desID {
+							t.Errorf("unexpected security profile: %+v", securityProfile)
+						}
+						return disk, nil
+					}).Times(1)
+				_, err := d.CreateVolume(context.Background(), req)
+				if err != nil {
+					t.Errorf("actualErr: (%v), expectedErr: (nil)", err)
+				}
+
+				// the disk encryption set is only supported by ConfidentialVM_DiskEncryptedWithCustomerKey disks
+				mp[consts.SecurityTypeField] = string(armcompute.DiskSecurityTypesTrustedLaunch)
+				_, err = d.CreateVolume(context.Background(), req)
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("actualErr: (%v), expectedErr: InvalidArgument", err)
+				}
+
+				// the security type only applies to OS disks
+				delete(mp, consts.SecureVMDiskEncryptionSetIDField)
+				delete(mp, consts.GalleryImageReferenceIDField)
+				_, err = d.CreateVolume(context.Background(), req)
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("actualErr: (%v), expectedErr: InvalidArgument", err)
+				}
+			},
+		},
 		{
 			name: "valid request ZRS",
 			testFunc: func(t *testing.T) {
@@ -595,8 +678,8 @@ func TestDeleteVolume(t *testing.T) {
 		}
 		diskClient := mock_diskclient.NewMockInterface(cntl)
 		d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
-		diskClient.EXPECT().Get(gomock.Eq(ctx), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
-		diskClient.EXPECT().Delete(gomock.Eq(ctx), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+		diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
+		diskClient.EXPECT().Delete(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
 
 		result, err := d.DeleteVolume(ctx, test.req)
 		if err != nil {
@@ -611,16 +694,70 @@ func TestDeleteVolume(t *testing.T) {
 func TestControllerGetVolume(t *testing.T) {
 	cntl := gomock.NewController(t)
 	defer cntl.Finish()
-	d, err := NewFakeDriver(cntl)
+	d, err := newFakeDriverV1(cntl)
 	if err != nil {
 		t.Fatalf("Error getting driver: %v", err)
 	}
-	req := csi.ControllerGetVolumeRequest{}
-	resp, err := d.ControllerGetVolume(context.Background(), &req)
-	assert.Nil(t, resp)
-	if !reflect.DeepEqual(err, status.Error(codes.Unimplemented, "")) {
-		t.Errorf("Unexpected error: %v", err)
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	newVM := func(nodeName, dataDiskName string) compute.VirtualMachine {
+		return compute.VirtualMachine{
+			Name:     ptr.To(nodeName),
+			ID:       ptr.To(fmt.Sprintf("/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/%s", nodeName)),
+			Location: &d.cloud.Location,
+			VirtualMachineProperties: &compute.VirtualMachineProperties{
+				ProvisioningState: ptr.To("Succeeded"),
+				StorageProfile: &compute.StorageProfile{
+					DataDisks: &[]compute.DataDisk{{Lun: ptr.To(int32(1)), Name: ptr.To(dataDiskName)}},
+				},
+			},
+		}
 	}
+	attachedVM := newVM("attached-node", testVolumeName)
+	otherVM := newVM("other-node", "other-disk")
+	mockVMsClient := d.cloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), gomock.Any(), "attached-node", gomock.Any()).Return(attachedVM, nil).AnyTimes()
+	mockVMsClient.EXPECT().Get(gomock.Any(), gomock.Any(), "other-node", gomock.Any()).Return(otherVM, nil).AnyTimes()
+	d.kubeClient = fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "attached-node"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "other-node"}},
+	)
+
+	_, err = d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{})
+	checkTestError(t, codes.InvalidArgument, err)
+
+	req := &csi.ControllerGetVolumeRequest{VolumeId: testVolumeID}
+	diskClient.EXPECT().Get(gomock.Any(), "rg", testVolumeName).Return(nil, fmt.Errorf("internal error"))
+	_, err = d.ControllerGetVolume(context.Background(), req)
+	checkTestError(t, codes.Internal, err)
+
+	// disk deleted out of band
+	diskClient.EXPECT().Get(gomock.Any(), "rg", testVolumeName).Return(nil, fmt.Errorf(consts.ResourceNotFound))
+	_, err = d.ControllerGetVolume(context.Background(), req)
+	checkTestError(t, codes.NotFound, err)
+
+	disk := &armcompute.Disk{
+		Name:      ptr.To(testVolumeName),
+		ManagedBy: otherVM.ID,
+		Properties: &armcompute.DiskProperties{
+			DiskSizeGB: ptr.To(int32(10)),
+			DiskState:  ptr.To(armcompute.DiskStateAttached),
+		},
+	}
+	// disk is attached according to the disk but not found in the data disks of the VM
+	diskClient.EXPECT().Get(gomock.Any(), "rg", testVolumeName).Return(disk, nil)
+	resp, err := d.ControllerGetVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other-node"}, resp.Status.PublishedNodeIds)
+	assert.Equal(t, &csi.VolumeCondition{Abnormal: true, Message: "disk is not found in the data disks of node other-node"}, resp.Status.VolumeCondition)
+
+	disk.ManagedBy = attachedVM.ID
+	diskClient.EXPECT().Get(gomock.Any(), "rg", testVolumeName).Return(disk, nil)
+	resp, err = d.ControllerGetVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, volumehelper.GiBToBytes(10), resp.Volume.CapacityBytes)
+	assert.Equal(t, []string{"attached-node"}, resp.Status.PublishedNodeIds)
+	assert.Equal(t, &csi.VolumeCondition{Message: "disk state: Attached"}, resp.Status.VolumeCondition)
 }
 
 func TestControllerModifyVolume(t *testing.T) {
@@ -698,6 +835,28 @@ func TestControllerModifyVolume(t *testing.T) {
 			expectedResp:    nil,
 			expectedErrCode: codes.InvalidArgument,
 		},
+		{
+			desc: "success with caching mode",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId: testVolumeID,
+				MutableParameters: map[string]string{
+					consts.CachingModeField: "ReadWrite",
+				},
+			},
+			oldSKU:       &storageAccountTypeUltraSSDLRS,
+			expectedResp: &csi.ControllerModifyVolumeResponse{},
+		},
+		{
+			desc: "fail with invalid caching mode",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId: testVolumeID,
+				MutableParameters: map[string]string{
+					consts.CachingModeField: "WriteOnly",
+				},
+			},
+			expectedResp:    nil,
+			expectedErrCode: codes.InvalidArgument,
+		},
 		{
 			desc: "fail with unsupported sku",
 			req: &csi.ControllerModifyVolumeRequest{
@@ -804,6 +963,7 @@ func TestControllerPublishVolume(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error getting driver: %v", err)
 	}
+	d.setKubeClient(fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}))
 	testCases := []struct {
 		name     string
 		testFunc func(t *testing.T)
@@ -959,6 +1119,7 @@ func TestControllerPublishVolume(t *testing.T) {
 				if err != nil {
 					t.Fatalf("Error getting driver: %v", err)
 				}
+				d.setKubeClient(fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}))
 				req := &csi.ControllerPublishVolumeRequest{
 					VolumeId:         testVolumeID,
 					VolumeCapability: volumeCap,
@@ -1019,6 +1180,7 @@ func TestControllerPublishVolume(t *testing.T) {
 				if err != nil {
 					t.Fatalf("Error getting driver: %v", err)
 				}
+				d.setKubeClient(fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}))
 				volumeContext := make(map[string]string)
 				volumeContext[consts.CachingModeField] = "badmode"
 				req := &csi.ControllerPublishVolumeRequest{
@@ -1613,7 +1775,7 @@ func TestGetSnapshotByID(t *testing.T) {
 				d.setCloud(&azure.Cloud{})
 				snapshotID := "testurl/subscriptions/23/providers/Microsoft.Compute/snapshots/snapshot-name"
 				expectedErr := status.Errorf(codes.Internal, "could not get snapshot name from testurl/subscriptions/23/providers/Microsoft.Compute/snapshots/snapshot-name, correct format: (?i).*/subscriptions/(?:.*)/resourceGroups/(?:.*)/providers/Microsoft.Compute/snapshots/(.+)")
-				_, err := d.getSnapshotByID(ctx, d.getCloud().SubscriptionID, d.getCloud().ResourceGroup, snapshotID, sourceVolumeID)
+				_, err := d.getSnapshotByID(ctx, d.getClientFactory(), d.getCloud().SubscriptionID, d.getCloud().ResourceGroup, snapshotID, sourceVolumeID)
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
 				}
@@ -1638,7 +1800,7 @@ func TestGetSnapshotByID(t *testing.T) {
 				snapshotVolumeID := "unit-test"
 				mockSnapshotClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(snapshot, fmt.Errorf("test")).AnyTimes()
 				expectedErr := status.Errorf(codes.Internal, "could not get snapshot name from testurl/subscriptions/23/providers/Microsoft.Compute/snapshots/snapshot-name, correct format: (?i).*/subscriptions/(?:.*)/resourceGroups/(?:.*)/providers/Microsoft.Compute/snapshots/(.+)")
-				_, err := d.getSnapshotByID(context.Background(), d.getCloud().SubscriptionID, d.getCloud().ResourceGroup, snapshotID, snapshotVolumeID)
+				_, err := d.getSnapshotByID(context.Background(), d.getClientFactory(), d.getCloud().SubscriptionID, d.getCloud().ResourceGroup, snapshotID, snapshotVolumeID)
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
 				}
@@ -2246,7 +2408,7 @@ func TestGetSourceDiskSize(t *testing.T) {
 				cntl := gomock.NewController(t)
 				defer cntl.Finish()
 				d, _ := NewFakeDriver(cntl)
-				_, _, err := d.GetSourceDiskSize(context.Background(), "", "test-rg", "test-disk", 2, 1)
+				_, _, err := d.GetSourceDiskSize(context.Background(), d.getClientFactory(), "", "test-rg", "test-disk", 2, 1)
 				expectedErr := status.Errorf(codes.Internal, "current depth (2) surpassed the max depth (1) while searching for the source disk size")
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
@@ -2264,7 +2426,7 @@ func TestGetSourceDiskSize(t *testing.T) {
 				d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
 				diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
 
-				_, _, err := d.GetSourceDiskSize(context.Background(), "", "test-rg", "test-disk", 0, 1)
+				_, _, err := d.GetSourceDiskSize(context.Background(), d.getClientFactory(), "", "test-rg", "test-disk", 0, 1)
 				expectedErr := status.Error(codes.Internal, "DiskProperty not found for disk (test-disk) in resource group (test-rg)")
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
@@ -2284,7 +2446,7 @@ func TestGetSourceDiskSize(t *testing.T) {
 				diskClient := mock_diskclient.NewMockInterface(cntl)
 				d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
 				diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
-				_, _, err := d.GetSourceDiskSize(context.Background(), "", "test-rg", "test-disk", 0, 1)
+				_, _, err := d.GetSourceDiskSize(context.Background(), d.getClientFactory(), "", "test-rg", "test-disk", 0, 1)
 				expectedErr := status.Error(codes.Internal, "DiskSizeGB for disk (test-disk) in resourcegroup (test-rg) is nil")
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
@@ -2307,7 +2469,7 @@ func TestGetSourceDiskSize(t *testing.T) {
 				diskClient := mock_diskclient.NewMockInterface(cntl)
 				d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
 				diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
-				size, _, _ := d.GetSourceDiskSize(context.Background(), "", "test-rg", "test-disk", 0, 1)
+				size, _, _ := d.GetSourceDiskSize(context.Background(), d.getClientFactory(), "", "test-rg", "test-disk", 0, 1)
 				expectedOutput := diskSizeGB
 				if *size != expectedOutput {
 					t.Errorf("actualOutput: (%v), expectedOutput: (%v)", *size, expectedOutput)
@@ -2343,7 +2505,7 @@ func TestGetSourceDiskSize(t *testing.T) {
 				diskClient := mock_diskclient.NewMockInterface(cntl)
 				d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
 				diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk1, nil).Return(disk2, nil).AnyTimes()
-				size, _, _ := d.GetSourceDiskSize(context.Background(), "", "test-rg", "test-disk-1", 0, 2)
+				size, _, _ := d.GetSourceDiskSize(context.Background(), d.getClientFactory(), "", "test-rg", "test-disk-1", 0, 2)
 				expectedOutput := diskSizeGB2
 				if *size != expectedOutput {
 					t.Errorf("actualOutput: (%v), expectedOutput: (%v)", *size, expectedOutput)
@@ -2356,6 +2518,177 @@ func TestGetSourceDiskSize(t *testing.T) {
 	}
 }
 
+func TestPrepareSnapshotForZonalRestore(t *testing.T) {
+	snapshotID := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/snapshots/snapshot-name"
+	sourceDiskID := fmt.Sprintf(consts.ManagedDiskPath, "subs", "rg", "source-disk")
+	snapshot := &armcompute.Snapshot{
+		Properties: &armcompute.SnapshotProperties{
+			CreationData: &armcompute.CreationData{
+				SourceResourceID: &sourceDiskID,
+			},
+			CompletionPercent: to.Ptr(float32(50.0)),
+		},
+	}
+	completedSnapshot := &armcompute.Snapshot{
+		Properties: &armcompute.SnapshotProperties{
+			CreationData: &armcompute.CreationData{
+				SourceResourceID: &sourceDiskID,
+			},
+			CompletionPercent: to.Ptr(float32(100.0)),
+		},
+	}
+	sourceDisk := &armcompute.Disk{
+		Zones:      []*string{to.Ptr("1")},
+		Properties: &armcompute.DiskProperties{},
+	}
+
+	tests := []struct {
+		name        string
+		snapshotID  string
+		diskZone    string
+		snapshots   []*armcompute.Snapshot
+		snapshotErr error
+		diskErr     error
+		expectedErr error
+	}{
+		{
+			name:      "snapshot from a disk in the same zone",
+			diskZone:  "eastus-1",
+			snapshots: []*armcompute.Snapshot{snapshot},
+		},
+		{
+			name:      "snapshot from a disk in another zone",
+			diskZone:  "eastus-2",
+			snapshots: []*armcompute.Snapshot{completedSnapshot, completedSnapshot},
+		},
+		{
+			name:        "snapshot from a disk in another zone is being copied",
+			diskZone:    "eastus-2",
+			snapshots:   []*armcompute.Snapshot{snapshot, snapshot},
+			expectedErr: status.Errorf(codes.Unavailable, "snapshot(%s) is 50.0%% copied, it could be restored into zone(eastus-2) once the copy is completed", snapshotID),
+		},
+		{
+			name:        "snapshot from a deleted disk is being copied",
+			diskZone:    "eastus-2",
+			snapshots:   []*armcompute.Snapshot{snapshot, snapshot},
+			diskErr:     fmt.Errorf("%s", consts.ResourceNotFound),
+			expectedErr: status.Errorf(codes.Unavailable, "snapshot(%s) is 50.0%% copied, it could be restored into zone(eastus-2) once the copy is completed", snapshotID),
+		},
+		{
+			name:        "invalid snapshot id",
+			snapshotID:  "invalid",
+			diskZone:    "eastus-2",
+			expectedErr: status.Errorf(codes.InvalidArgument, "failed to parse snapshot(invalid): could not get snapshot name from invalid, correct format: (?i).*/subscriptions/(?:.*)/resourceGroups/(?:.*)/providers/Microsoft.Compute/snapshots/(.+)"),
+		},
+		{
+			name:        "get snapshot error",
+			diskZone:    "eastus-2",
+			snapshots:   []*armcompute.Snapshot{nil},
+			snapshotErr: fmt.Errorf("get snapshot error"),
+			expectedErr: status.Errorf(codes.Internal, "failed to get source disk zone of snapshot(%s): get snapshot error", snapshotID),
+		},
+		{
+			name:        "get snapshot completion percent error",
+			diskZone:    "eastus-2",
+			snapshots:   []*armcompute.Snapshot{snapshot, nil},
+			snapshotErr: fmt.Errorf("get snapshot error"),
+			expectedErr: status.Errorf(codes.Internal, "getSnapshotCompletionPercent(subs, rg, snapshot-name) failed with get snapshot error"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cntl := gomock.NewController(t)
+			defer cntl.Finish()
+			d, _ := NewFakeDriver(cntl)
+
+			mockSnapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+			d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub(gomock.Any()).Return(mockSnapshotClient, nil).AnyTimes()
+			var calls []any
+			for _, snapshot := range test.snapshots {
+				if snapshot == nil {
+					calls = append(calls, mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapshot-name").Return(nil, test.snapshotErr))
+				} else {
+					calls = append(calls, mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapshot-name").Return(snapshot, nil))
+				}
+			}
+			if len(calls) > 0 {
+				gomock.InOrder(calls...)
+			}
+
+			diskClient := mock_diskclient.NewMockInterface(cntl)
+			d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+			if test.diskErr != nil {
+				diskClient.EXPECT().Get(gomock.Any(), "rg", "source-disk").Return(nil, test.diskErr).AnyTimes()
+			} else {
+				diskClient.EXPECT().Get(gomock.Any(), "rg", "source-disk").Return(sourceDisk, nil).AnyTimes()
+			}
+
+			id := snapshotID
+			if test.snapshotID != "" {
+				id = test.snapshotID
+			}
+			err := d.prepareSnapshotForZonalRestore(context.Background(), d.getClientFactory(), id, "eastus", test.diskZone)
+			if !reflect.DeepEqual(err, test.expectedErr) {
+				t.Errorf("actualErr: (%v), expectedErr: (%v)", err, test.expectedErr)
+			}
+		})
+	}
+}
+
+func TestGetSnapshotSizeGiB(t *testing.T) {
+	snapshotID := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/snapshots/snapshot-name"
+	tests := []struct {
+		name         string
+		snapshotID   string
+		snapshot     *armcompute.Snapshot
+		snapshotErr  error
+		expectedSize *int32
+		expectedErr  bool
+	}{
+		{
+			name:        "invalid snapshot ID",
+			snapshotID:  "unit-test",
+			expectedErr: true,
+		},
+		{
+			name:         "snapshot with size",
+			snapshotID:   snapshotID,
+			snapshot:     &armcompute.Snapshot{Properties: &armcompute.SnapshotProperties{DiskSizeGB: to.Ptr(int32(10))}},
+			expectedSize: to.Ptr(int32(10)),
+		},
+		{
+			name:       "snapshot without properties",
+			snapshotID: snapshotID,
+			snapshot:   &armcompute.Snapshot{},
+		},
+		{
+			name:        "get snapshot error",
+			snapshotID:  snapshotID,
+			snapshotErr: fmt.Errorf("get snapshot error"),
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cntl := gomock.NewController(t)
+			defer cntl.Finish()
+			d, _ := NewFakeDriver(cntl)
+
+			mockSnapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+			d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub("subs").Return(mockSnapshotClient, nil).AnyTimes()
+			if test.snapshot != nil || test.snapshotErr != nil {
+				mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapshot-name").Return(test.snapshot, test.snapshotErr).Times(1)
+			}
+
+			size, err := d.getSnapshotSizeGiB(context.Background(), d.getClientFactory(), test.snapshotID)
+			assert.Equal(t, test.expectedErr, err != nil, "err: %v", err)
+			assert.Equal(t, test.expectedSize, size)
+		})
+	}
+}
+
 func getFakeDriverWithKubeClient(ctrl *gomock.Controller) FakeDriver {
 
 	d, _ := NewFakeDriver(ctrl)
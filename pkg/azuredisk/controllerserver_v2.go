@@ -45,6 +45,7 @@ import (
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
 	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
 	"sigs.k8s.io/cloud-provider-azure/pkg/metrics"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 )
@@ -105,7 +106,7 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 		return nil, status.Error(codes.InvalidArgument, "After round-up, volume size exceeds the limit specified")
 	}
 
-	if azureutils.IsAzureStackCloud(d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud) {
+	if azureutils.IsAzureStackCloud(d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud) {
 		if diskParams.MaxShares > 1 {
 			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid maxShares value: %d as Azure Stack does not support shared disk.", diskParams.MaxShares))
 		}
@@ -117,27 +118,19 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 	diskParams.DiskName = azureutils.CreateValidDiskName(diskParams.DiskName)
 
 	if diskParams.ResourceGroup == "" {
-		diskParams.ResourceGroup = d.cloud.ResourceGroup
+		diskParams.ResourceGroup = d.getCloud().ResourceGroup
 	}
 
-	// normalize values
-	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud)
+	// validate and normalize values
+	skuName, err := azureutils.ValidateDiskParameters(&diskParams, d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-
-	if _, err := azureutils.NormalizeCachingMode(diskParams.CachingMode); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
-	}
 	if skuName == armcompute.DiskStorageAccountTypesPremiumV2LRS {
 		// PremiumV2LRS only supports None caching mode
 		azureutils.SetKeyValueInMap(diskParams.VolumeContext, consts.CachingModeField, string(v1.AzureDataDiskCachingNone))
 	}
 
-	if err := azureutils.ValidateDiskEncryptionType(diskParams.DiskEncryptionType); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
-	}
-
 	networkAccessPolicy, err := azureutils.NormalizeNetworkAccessPolicy(diskParams.NetworkAccessPolicy)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
@@ -148,10 +141,27 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	selectedAvailabilityZone := azureutils.PickAvailabilityZone(req.GetAccessibilityRequirements(), d.cloud.Location, topologyKey)
+	selectedAvailabilityZone := azureutils.PickAvailabilityZone(req.GetAccessibilityRequirements(), d.getCloud().Location, topologyKey)
+	pvcZone, err := d.getPVCAvailabilityZone(ctx, diskParams.Tags[consts.PvcNamespaceTag], diskParams.Tags[consts.PvcNameTag], req.GetAccessibilityRequirements(), d.getCloud().Location)
+	if err != nil {
+		return nil, err
+	}
+	if pvcZone != "" {
+		klog.V(2).Infof("zone of disk(%s) is set to %s by pvc annotation, topology-selected zone was %q", diskParams.DiskName, pvcZone, selectedAvailabilityZone)
+		selectedAvailabilityZone = pvcZone
+	}
+
+	if diskParams.CreateResourceGroupIfNotExist {
+		if !d.isClusterSubscription(diskParams.SubscriptionID) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is only supported in subscription %s", consts.CreateResourceGroupIfNotExist, d.getCloud().SubscriptionID)
+		}
+		if err := d.ensureResourceGroup(ctx, &diskParams); err != nil {
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+	}
 
 	if d.enableDiskCapacityCheck {
-		if ok, err := d.checkDiskCapacity(ctx, diskParams.SubscriptionID, diskParams.ResourceGroup, diskParams.DiskName, requestGiB); !ok {
+		if ok, err := d.checkDiskCapacity(ctx, d.getClientFactory(), diskParams.SubscriptionID, diskParams.ResourceGroup, diskParams.DiskName, requestGiB); !ok {
 			return nil, err
 		}
 	}
@@ -168,6 +178,9 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 	sourceType := ""
 	content := req.GetVolumeContentSource()
 	if content != nil {
+		if diskParams.GalleryImageReferenceID != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s could not be used with a volume content source", consts.GalleryImageReferenceIDField)
+		}
 		if content.GetSnapshot() != nil {
 			sourceID = content.GetSnapshot().GetSnapshotId()
 			sourceType = consts.SourceSnapshot
@@ -178,6 +191,9 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 					},
 				},
 			}
+			if sourceGiB, _ := d.getSnapshotSizeGiB(ctx, d.getClientFactory(), sourceID); sourceGiB != nil && *sourceGiB < int32(requestGiB) {
+				diskParams.VolumeContext[consts.ResizeRequired] = strconv.FormatBool(true)
+			}
 		} else {
 			sourceID = content.GetVolume().GetVolumeId()
 			sourceType = consts.SourceVolume
@@ -190,7 +206,7 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 			}
 
 			subsID := azureutils.GetSubscriptionIDFromURI(sourceID)
-			if sourceGiB, _, _ := d.GetSourceDiskSize(ctx, subsID, diskParams.ResourceGroup, path.Base(sourceID), 0, consts.SourceDiskSearchMaxDepth); sourceGiB != nil && *sourceGiB < int32(requestGiB) {
+			if sourceGiB, _, _ := d.GetSourceDiskSize(ctx, d.getClientFactory(), subsID, diskParams.ResourceGroup, path.Base(sourceID), 0, consts.SourceDiskSearchMaxDepth); sourceGiB != nil && *sourceGiB < int32(requestGiB) {
 				diskParams.VolumeContext[consts.ResizeRequired] = strconv.FormatBool(true)
 			}
 		}
@@ -216,8 +232,15 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 		Location:            diskParams.Location,
 		PerformancePlus:     diskParams.PerformancePlus,
 	}
+	volumeOptions.SupportsHibernation = diskParams.SupportsHibernation
+	volumeOptions.OptimizedForFrequentAttach = diskParams.OptimizedForFrequentAttach
+	volumeOptions.GalleryImageReferenceID = diskParams.GalleryImageReferenceID
+	volumeOptions.GalleryImageLun = diskParams.GalleryImageLun
+	volumeOptions.SecurityType = diskParams.SecurityType
+	volumeOptions.SecureVMDiskEncryptionSetID = diskParams.SecureVMDiskEncryptionSetID
+	volumeOptions.ClusterID = d.getDiskOwnerClusterID(ctx, diskParams.DiskName)
 	// Azure Stack Cloud does not support NetworkAccessPolicy, PublicNetworkAccess
-	if !azureutils.IsAzureStackCloud(d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud) {
+	if !azureutils.IsAzureStackCloud(d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud) {
 		volumeOptions.NetworkAccessPolicy = networkAccessPolicy
 		volumeOptions.PublicNetworkAccess = publicNetworkAccess
 		if diskParams.DiskAccessID != "" {
@@ -226,14 +249,17 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 	}
 
 	var diskURI string
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_create_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_create_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
-	diskURI, err = d.diskController.CreateManagedDisk(ctx, volumeOptions)
+	diskURI, err = d.createManagedDiskWithDeadlineBudget(ctx, d.getDiskController(), volumeOptions)
 	if err != nil {
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
 		if strings.Contains(err.Error(), consts.NotFound) {
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
@@ -280,16 +306,19 @@ func (d *DriverV2) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeReques
 	}
 	defer d.volumeLocks.Release(volumeID)
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
 	klog.V(2).Infof("deleting azure disk(%s)", diskURI)
-	err := d.diskController.DeleteManagedDisk(ctx, diskURI)
+	err := d.deleteManagedDiskWithDeadlineBudget(ctx, d.getDiskController(), diskURI)
 	klog.V(2).Infof("delete azure disk(%s) returned with %v", diskURI, err)
 	isOperationSucceeded = (err == nil)
+	if err == nil {
+		d.cleanupResourceGroup(ctx, diskURI)
+	}
 	return &csi.DeleteVolumeResponse{}, err
 }
 
@@ -325,16 +354,25 @@ func (d *DriverV2) ControllerModifyVolume(ctx context.Context, req *csi.Controll
 	}
 
 	// normalize values
-	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud)
+	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 	if diskParams.AccountType == "" {
 		skuName = ""
 	}
+	var cachingMode armcompute.CachingTypes
+	if diskParams.CachingMode != "" {
+		// host caching is set on the data disk entry of the VM, the new value is applied on the next attach
+		mode, err := azureutils.NormalizeCachingMode(diskParams.CachingMode)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		cachingMode = armcompute.CachingTypes(mode)
+	}
 
-	klog.V(2).Infof("begin to modify azure disk(%s) account type(%s) rg(%s) location(%s)",
-		diskParams.DiskName, skuName, diskParams.ResourceGroup, diskParams.Location)
+	klog.V(2).Infof("begin to modify azure disk(%s) account type(%s) caching mode(%s) rg(%s) location(%s)",
+		diskParams.DiskName, skuName, cachingMode, diskParams.ResourceGroup, diskParams.Location)
 
 	volumeOptions := &ManagedDiskOptions{
 		DiskIOPSReadWrite:  diskParams.DiskIOPSReadWrite,
@@ -345,15 +383,19 @@ func (d *DriverV2) ControllerModifyVolume(ctx context.Context, req *csi.Controll
 		StorageAccountType: skuName,
 		SourceResourceID:   diskURI,
 		SourceType:         consts.SourceVolume,
+		CachingMode:        cachingMode,
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_modify_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_modify_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
-	if err = d.diskController.ModifyDisk(ctx, volumeOptions); err != nil {
+	if err = d.modifyDiskWithDeadlineBudget(ctx, d.getDiskController(), volumeOptions); err != nil {
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
 		if strings.Contains(err.Error(), consts.NotFound) {
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
@@ -404,13 +446,17 @@ func (d *DriverV2) ControllerPublishVolume(ctx context.Context, req *csi.Control
 		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_publish_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_publish_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI, consts.Node, string(nodeName))
 	}()
 
-	lun, vmState, err := d.diskController.GetDiskLun(diskName, diskURI, nodeName)
+	diskController, err := d.getNodeDiskController(ctx, nodeName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	lun, vmState, err := diskController.GetDiskLun(diskName, diskURI, nodeName)
 	if err == cloudprovider.InstanceNotFound {
 		return nil, status.Error(codes.NotFound, fmt.Sprintf("failed to get azure instance id for node %q (%v)", nodeName, err))
 	}
@@ -425,7 +471,7 @@ func (d *DriverV2) ControllerPublishVolume(ctx context.Context, req *csi.Control
 	if err == nil {
 		if vmState != nil && strings.ToLower(*vmState) == "failed" {
 			klog.Warningf("VM(%s) is in failed state, update VM first", nodeName)
-			if err := d.diskController.UpdateVM(ctx, nodeName); err != nil {
+			if err := diskController.UpdateVM(ctx, nodeName); err != nil {
 				return nil, status.Errorf(codes.Internal, "update instance %q failed with %v", nodeName, err)
 			}
 		}
@@ -435,23 +481,40 @@ func (d *DriverV2) ControllerPublishVolume(ctx context.Context, req *csi.Control
 		if azureutils.IsThrottlingError(err) {
 			return nil, status.Errorf(codes.Internal, "%v", err)
 		}
+		// existing attachments are kept, only new publishes of a volume under maintenance are blocked
+		if err := d.checkVolumeMaintenance(getPVNameForDisk(volumeContext, disk)); err != nil {
+			return nil, err
+		}
 		var cachingMode armcompute.CachingTypes
 		if cachingMode, err = azureutils.GetCachingMode(volumeContext); err != nil {
 			return nil, status.Errorf(codes.Internal, "%v", err)
 		}
 		klog.V(2).Infof("Trying to attach volume %s to node %s", diskURI, nodeName)
 
-		lun, err = d.diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, nil)
+		lun, err = d.attachDiskWithDeadlineBudget(ctx, diskController, diskName, diskURI, nodeName, cachingMode, disk, nil, nil)
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
 		if err == nil {
 			klog.V(2).Infof("Attach operation successful: volume %s attached to node %s.", diskURI, nodeName)
 		} else {
 			if derr, ok := err.(*volerr.DanglingAttachError); ok {
 				klog.Warningf("volume %s is already attached to node %s, try detach first", diskURI, derr.CurrentNode)
-				if err = d.diskController.DetachDisk(ctx, diskName, diskURI, derr.CurrentNode); err != nil {
+				currentNodeDiskController, controllerErr := d.getNodeDiskController(ctx, derr.CurrentNode)
+				if controllerErr != nil {
+					return nil, status.Errorf(codes.Internal, "%v", controllerErr)
+				}
+				if err = d.detachDiskWithDeadlineBudget(ctx, currentNodeDiskController, diskName, diskURI, derr.CurrentNode); err != nil {
+					if status.Code(err) == codes.DeadlineExceeded {
+						return nil, err
+					}
 					return nil, status.Errorf(codes.Internal, "Could not detach volume %s from node %s: %v", diskURI, derr.CurrentNode, err)
 				}
 				klog.V(2).Infof("Trying to attach volume %s to node %s again", diskURI, nodeName)
-				lun, err = d.diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, nil)
+				lun, err = d.attachDiskWithDeadlineBudget(ctx, diskController, diskName, diskURI, nodeName, cachingMode, disk, nil, nil)
+				if status.Code(err) == codes.DeadlineExceeded {
+					return nil, err
+				}
 			}
 			if err != nil {
 				klog.Errorf("Attach volume %s to instance %s failed with %v", diskURI, nodeName, err)
@@ -459,6 +522,7 @@ func (d *DriverV2) ControllerPublishVolume(ctx context.Context, req *csi.Control
 			}
 		}
 		klog.V(2).Infof("attach volume %s to node %s successfully", diskURI, nodeName)
+		d.refreshNodeDiskThroughputAnnotations(nodeName)
 	}
 
 	publishContext := map[string]string{consts.LUN: strconv.Itoa(int(lun))}
@@ -466,6 +530,9 @@ func (d *DriverV2) ControllerPublishVolume(ctx context.Context, req *csi.Control
 		if _, ok := volumeContext[consts.RequestedSizeGib]; !ok {
 			klog.V(2).Infof("found static PV(%s), insert disk properties to volumeattachments", diskURI)
 			azureutils.InsertDiskProperties(disk, publishContext)
+		} else if disk.Properties != nil && disk.Properties.CreationData != nil && disk.Properties.CreationData.LogicalSectorSize != nil {
+			// the node formats the disk according to its logical sector size
+			publishContext[consts.LogicalSectorSizeField] = strconv.Itoa(int(*disk.Properties.CreationData.LogicalSectorSize))
 		}
 	}
 	isOperationSucceeded = true
@@ -490,7 +557,7 @@ func (d *DriverV2) ControllerUnpublishVolume(ctx context.Context, req *csi.Contr
 		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_unpublish_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_unpublish_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI, consts.Node, string(nodeName))
@@ -498,7 +565,14 @@ func (d *DriverV2) ControllerUnpublishVolume(ctx context.Context, req *csi.Contr
 
 	klog.V(2).Infof("Trying to detach volume %s from node %s", diskURI, nodeID)
 
-	if err := d.diskController.DetachDisk(ctx, diskName, diskURI, nodeName); err != nil {
+	diskController, err := d.getNodeDiskController(ctx, nodeName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	if err := d.detachDiskWithDeadlineBudget(ctx, diskController, diskName, diskURI, nodeName); err != nil {
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
 		if strings.Contains(err.Error(), consts.ErrDiskNotFound) {
 			klog.Warningf("volume %s already detached from node %s", diskURI, nodeID)
 		} else {
@@ -506,6 +580,7 @@ func (d *DriverV2) ControllerUnpublishVolume(ctx context.Context, req *csi.Contr
 		}
 	}
 	klog.V(2).Infof("detach volume %s from node %s successfully", diskURI, nodeID)
+	d.refreshNodeDiskThroughputAnnotations(nodeName)
 	isOperationSucceeded = true
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
@@ -567,17 +642,17 @@ func (d *DriverV2) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest)
 			return nil, status.Errorf(codes.Aborted, "ListVolumes starting token(%d) can not be negative", start)
 		}
 	}
-	if d.cloud.KubeClient != nil && d.cloud.KubeClient.CoreV1() != nil && d.cloud.KubeClient.CoreV1().PersistentVolumes() != nil {
+	if d.getCloud().KubeClient != nil && d.getCloud().KubeClient.CoreV1() != nil && d.getCloud().KubeClient.CoreV1().PersistentVolumes() != nil {
 		klog.V(6).Infof("List Volumes in Cluster:")
 		return d.listVolumesInCluster(ctx, start, int(req.MaxEntries))
 	}
-	klog.V(6).Infof("List Volumes in Node Resource Group: %s", d.cloud.ResourceGroup)
+	klog.V(6).Infof("List Volumes in Node Resource Group: %s", d.getCloud().ResourceGroup)
 	return d.listVolumesInNodeResourceGroup(ctx, start, int(req.MaxEntries))
 }
 
 // listVolumesInCluster is a helper function for ListVolumes used for when there is an available kubeclient
 func (d *DriverV2) listVolumesInCluster(ctx context.Context, start, maxEntries int) (*csi.ListVolumesResponse, error) {
-	pvList, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	pvList, err := d.getCloud().KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "ListVolumes failed while fetching PersistentVolumes List with error: %v", err)
 	}
@@ -598,8 +673,8 @@ func (d *DriverV2) listVolumesInCluster(ctx context.Context, start, maxEntries i
 				continue
 			}
 			subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-			if !strings.EqualFold(subsID, d.cloud.SubscriptionID) {
-				klog.V(6).Infof("disk(%s) not in current subscription(%s), skip", diskURI, d.cloud.SubscriptionID)
+			if !strings.EqualFold(subsID, d.getCloud().SubscriptionID) {
+				klog.V(6).Infof("disk(%s) not in current subscription(%s), skip", diskURI, d.getCloud().SubscriptionID)
 				continue
 			}
 			rg, diskURI = strings.ToLower(rg), strings.ToLower(diskURI)
@@ -665,7 +740,7 @@ func (d *DriverV2) listVolumesInCluster(ctx context.Context, start, maxEntries i
 // listVolumesInNodeResourceGroup is a helper function for ListVolumes used for when there is no available kubeclient
 func (d *DriverV2) listVolumesInNodeResourceGroup(ctx context.Context, start, maxEntries int) (*csi.ListVolumesResponse, error) {
 	entries := []*csi.ListVolumesResponse_Entry{}
-	listStatus := d.listVolumesByResourceGroup(ctx, d.cloud.ResourceGroup, entries, start, maxEntries, nil)
+	listStatus := d.listVolumesByResourceGroup(ctx, d.getCloud().ResourceGroup, entries, start, maxEntries, nil)
 	if listStatus.err != nil {
 		return nil, listStatus.err
 	}
@@ -685,7 +760,7 @@ func (d *DriverV2) listVolumesInNodeResourceGroup(ctx context.Context, start, ma
 
 // listVolumesByResourceGroup is a helper function that updates the ListVolumeResponse_Entry slice and returns number of total visited volumes, number of volumes that needs to be visited and an error if found
 func (d *DriverV2) listVolumesByResourceGroup(ctx context.Context, resourceGroup string, entries []*csi.ListVolumesResponse_Entry, start, maxEntries int, volSet map[string]bool) listVolumeStatus {
-	diskClient := d.clientFactory.GetDiskClient()
+	diskClient := d.getClientFactory().GetDiskClient()
 	disks, derr := diskClient.List(ctx, resourceGroup)
 	if derr != nil {
 		return listVolumeStatus{err: status.Errorf(codes.Internal, "ListVolumes on rg(%s) failed with error: %v", resourceGroup, derr)}
@@ -701,7 +776,7 @@ func (d *DriverV2) listVolumesByResourceGroup(ctx context.Context, resourceGroup
 	if start > 0 && start >= len(disks) {
 		return listVolumeStatus{
 			numVisited: len(disks),
-			err:        status.Errorf(codes.FailedPrecondition, "ListVolumes starting token(%d) on rg(%s) is greater than total number of volumes", start, d.cloud.ResourceGroup),
+			err:        status.Errorf(codes.FailedPrecondition, "ListVolumes starting token(%d) on rg(%s) is greater than total number of volumes", start, d.getCloud().ResourceGroup),
 		}
 	}
 	if start < 0 {
@@ -726,7 +801,7 @@ func (d *DriverV2) listVolumesByResourceGroup(ctx context.Context, resourceGroup
 			nodeList := []string{}
 
 			if disk.ManagedBy != nil {
-				attachedNode, err := d.cloud.VMSet.GetNodeNameByProviderID(ctx, *disk.ManagedBy)
+				attachedNode, err := d.getCloud().VMSet.GetNodeNameByProviderID(ctx, *disk.ManagedBy)
 				if err != nil {
 					return listVolumeStatus{err: err}
 				}
@@ -779,14 +854,14 @@ func (d *DriverV2) ControllerExpandVolume(ctx context.Context, req *csi.Controll
 		return nil, status.Errorf(codes.Internal, "could not get resource group from diskURI(%s) with error(%v)", diskURI, err)
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_expand_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_expand_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
 	subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := d.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get disk client for subscription(%s) with error(%v)", subsID, err)
 	}
@@ -800,7 +875,10 @@ func (d *DriverV2) ControllerExpandVolume(ctx context.Context, req *csi.Controll
 	oldSize := *resource.NewQuantity(int64(*result.Properties.DiskSizeGB), resource.BinarySI)
 
 	klog.V(2).Infof("begin to expand azure disk(%s) with new size(%d)", diskURI, requestSize.Value())
-	newSize, err := d.diskController.ResizeDisk(ctx, diskURI, oldSize, requestSize, d.enableDiskOnlineResize)
+	newSize, err := d.resizeDiskWithDeadlineBudget(ctx, d.getDiskController(), diskURI, oldSize, requestSize)
+	if status.Code(err) == codes.DeadlineExceeded {
+		return nil, err
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to resize disk(%s) with error(%v)", diskURI, err)
 	}
@@ -860,7 +938,7 @@ func (d *DriverV2) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRe
 		}
 	}
 
-	if azureutils.IsAzureStackCloud(d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud) {
+	if azureutils.IsAzureStackCloud(d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud) {
 		klog.V(2).Info("Use full snapshot instead as Azure Stack does not support incremental snapshot.")
 		incremental = false
 	}
@@ -890,7 +968,7 @@ func (d *DriverV2) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRe
 			},
 			Incremental: &incremental,
 		},
-		Location: &d.cloud.Location,
+		Location: &d.getCloud().Location,
 		Tags:     tags,
 	}
 	if dataAccessAuthMode != "" {
@@ -900,30 +978,33 @@ func (d *DriverV2) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRe
 		snapshot.Properties.DataAccessAuthMode = to.Ptr(armcompute.DataAccessAuthMode(dataAccessAuthMode))
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_create_snapshot", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_create_snapshot", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.SourceResourceID, sourceVolumeID, consts.SnapshotName, snapshotName)
 	}()
 
 	klog.V(2).Infof("begin to create snapshot(%s, incremental: %v) under rg(%s)", snapshotName, incremental, resourceGroup)
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
+	snapshotClient, err := d.getClientFactory().GetSnapshotClientForSub(subsID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
 	}
-	if _, err := snapshotClient.CreateOrUpdate(ctx, resourceGroup, snapshotName, snapshot); err != nil {
+	if err := d.createSnapshotWithDeadlineBudget(ctx, snapshotClient, subsID, resourceGroup, snapshotName, snapshot); err != nil {
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
 		if strings.Contains(err.Error(), "existing disk") {
 			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("request snapshot(%s) under rg(%s) already exists, but the SourceVolumeId is different, error details: %v", snapshotName, resourceGroup, err))
 		}
 		azureutils.SleepIfThrottled(err, consts.SnapshotOpThrottlingSleepSec)
 		return nil, status.Error(codes.Internal, fmt.Sprintf("create snapshot error: %v", err))
 	}
-	if err := d.waitForSnapshotReady(ctx, subsID, resourceGroup, snapshotName, waitForSnapshotReadyInterval, waitForSnapshotReadyTimeout); err != nil {
+	if err := d.waitForSnapshotReady(ctx, d.getClientFactory(), subsID, resourceGroup, snapshotName, waitForSnapshotReadyInterval, waitForSnapshotReadyTimeout); err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("waitForSnapshotReady(%s, %s, %s) failed with %v", subsID, resourceGroup, snapshotName, err))
 	}
 	klog.V(2).Infof("create snapshot(%s) under rg(%s) successfully", snapshotName, resourceGroup)
 
-	csiSnapshot, err := d.getSnapshotByID(ctx, subsID, resourceGroup, snapshotName, sourceVolumeID)
+	csiSnapshot, err := d.getSnapshotByID(ctx, d.getClientFactory(), subsID, resourceGroup, snapshotName, sourceVolumeID)
 	if err != nil {
 		return nil, err
 	}
@@ -945,7 +1026,7 @@ func (d *DriverV2) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRe
 	var err error
 	var subsID string
 	snapshotName := snapshotID
-	resourceGroup := d.cloud.ResourceGroup
+	resourceGroup := d.getCloud().ResourceGroup
 
 	if azureutils.IsARMResourceID(snapshotID) {
 		snapshotName, resourceGroup, subsID, err = d.getSnapshotInfo(snapshotID)
@@ -954,19 +1035,22 @@ func (d *DriverV2) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRe
 		}
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_snapshot", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_snapshot", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.SnapshotID, snapshotName)
 	}()
 
 	klog.V(2).Infof("begin to delete snapshot(%s) under rg(%s)", snapshotName, resourceGroup)
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
+	snapshotClient, err := d.getClientFactory().GetSnapshotClientForSub(subsID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
 	}
-	err = snapshotClient.Delete(ctx, resourceGroup, snapshotName)
+	err = d.deleteSnapshotWithDeadlineBudget(ctx, snapshotClient, subsID, resourceGroup, snapshotName)
 	if err != nil {
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
 		azureutils.SleepIfThrottled(err, consts.SnapshotOpThrottlingSleepSec)
 		return nil, status.Error(codes.Internal, fmt.Sprintf("delete snapshot error: %v", err))
 	}
@@ -979,7 +1063,7 @@ func (d *DriverV2) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRe
 func (d *DriverV2) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 	// SnapshotId is not empty, return snapshot that match the snapshot id.
 	if len(req.GetSnapshotId()) != 0 {
-		snapshot, err := d.getSnapshotByID(ctx, "", d.cloud.ResourceGroup, req.GetSnapshotId(), req.SourceVolumeId)
+		snapshot, err := d.getSnapshotByID(ctx, d.getClientFactory(), "", d.getCloud().ResourceGroup, req.GetSnapshotId(), req.SourceVolumeId)
 		if err != nil {
 			if strings.Contains(err.Error(), consts.ResourceNotFound) {
 				return &csi.ListSnapshotsResponse{}, nil
@@ -996,9 +1080,9 @@ func (d *DriverV2) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequ
 		}
 		return listSnapshotResp, nil
 	}
-	snapshotClient := d.clientFactory.GetSnapshotClient()
+	snapshotClient := d.getClientFactory().GetSnapshotClient()
 	// no SnapshotId is set, return all snapshots that satisfy the request.
-	snapshots, err := snapshotClient.List(ctx, d.cloud.ResourceGroup)
+	snapshots, err := snapshotClient.List(ctx, d.getCloud().ResourceGroup)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list snapshot error: %v", err))
 	}
@@ -1006,7 +1090,7 @@ func (d *DriverV2) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequ
 	return azureutils.GetEntriesAndNextToken(req, snapshots)
 }
 
-func (d *DriverV2) getSnapshotByID(ctx context.Context, subsID, resourceGroup, snapshotID, sourceVolumeID string) (*csi.Snapshot, error) {
+func (d *DriverV2) getSnapshotByID(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, snapshotID, sourceVolumeID string) (*csi.Snapshot, error) {
 	var err error
 	snapshotName := snapshotID
 	if azureutils.IsARMResourceID(snapshotID) {
@@ -1015,7 +1099,7 @@ func (d *DriverV2) getSnapshotByID(ctx context.Context, subsID, resourceGroup, s
 			return nil, status.Errorf(codes.Internal, "%v", err)
 		}
 	}
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
+	snapshotClient, err := clientFactory.GetSnapshotClientForSub(subsID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
 	}
@@ -1028,11 +1112,11 @@ func (d *DriverV2) getSnapshotByID(ctx context.Context, subsID, resourceGroup, s
 }
 
 // GetSourceDiskSize recursively searches for the sourceDisk and returns: sourceDisk disk size, error
-func (d *DriverV2) GetSourceDiskSize(ctx context.Context, subsID, resourceGroup, diskName string, curDepth, maxDepth int) (*int32, *armcompute.Disk, error) {
+func (d *DriverV2) GetSourceDiskSize(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, diskName string, curDepth, maxDepth int) (*int32, *armcompute.Disk, error) {
 	if curDepth > maxDepth {
 		return nil, nil, status.Error(codes.Internal, fmt.Sprintf("current depth (%d) surpassed the max depth (%d) while searching for the source disk size", curDepth, maxDepth))
 	}
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := clientFactory.GetDiskClientForSub(subsID)
 	if err != nil {
 		return nil, nil, status.Error(codes.Internal, err.Error())
 	}
@@ -1049,7 +1133,7 @@ func (d *DriverV2) GetSourceDiskSize(ctx context.Context, subsID, resourceGroup,
 		sourceResourceID := *result.Properties.CreationData.SourceResourceID
 		parentResourceGroup, _ := azureutils.GetResourceGroupFromURI(sourceResourceID)
 		parentDiskName := path.Base(sourceResourceID)
-		return d.GetSourceDiskSize(ctx, subsID, parentResourceGroup, parentDiskName, curDepth+1, maxDepth)
+		return d.GetSourceDiskSize(ctx, clientFactory, subsID, parentResourceGroup, parentDiskName, curDepth+1, maxDepth)
 	}
 
 	if (*result.Properties).DiskSizeGB == nil {
@@ -0,0 +1,71 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+// getCloudProviderWithCredentials creates the cloud of another tenant, it's replaced by a test cloud in the unit tests
+var getCloudProviderWithCredentials = azureutils.GetCloudProviderWithCredentials
+
+// getCloudFromSecrets returns the cloud using the credentials of another tenant in the provisioner or snapshotter
+// secrets of a request, nil is returned if secrets has no credentials
+func (d *Driver) getCloudFromSecrets(ctx context.Context, secrets map[string]string, userAgent string) (*azure.Cloud, error) {
+	creds, err := azureutils.ParseCloudCredentials(secrets)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid credentials: %v", err)
+	}
+	if creds == nil {
+		return nil, nil
+	}
+	klog.V(2).Infof("using the credentials of client %s in tenant %s from the secrets", creds.ClientID, creds.TenantID)
+	cloud, err := getCloudProviderWithCredentials(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, userAgent,
+		d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort, d.clientRateLimitOptions, creds)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "create cloud of tenant %s failed with: %v", creds.TenantID, err)
+	}
+	if cloud.ComputeClientFactory == nil {
+		return nil, status.Errorf(codes.Internal, "create cloud of tenant %s failed: no cloud config provided", creds.TenantID)
+	}
+	return cloud, nil
+}
+
+// newLocalDiskController returns a disk controller of cloud with the settings of the driver
+func (d *DriverCore) newLocalDiskController(cloud *azure.Cloud) *ManagedDiskController {
+	diskController := &ManagedDiskController{
+		controllerCommon: &controllerCommon{
+			cloud:               cloud,
+			lockMap:             newLockMap(),
+			DisableDiskLunCheck: true,
+			clientFactory:       cloud.ComputeClientFactory,
+			ForceDetachBackoff:  d.forceDetachBackoff,
+			eventRecorder:       d.eventRecorder,
+		},
+	}
+	diskController.DisableUpdateCache = d.disableUpdateCache
+	diskController.AttachDetachInitialDelayInMs = int(d.attachDetachInitialDelayInMs)
+	diskController.ForceDetachTimeoutInSeconds = d.forceDetachTimeoutInSeconds
+	return diskController
+}
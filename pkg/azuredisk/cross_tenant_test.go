@@ -0,0 +1,169 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/snapshotclient/mock_snapshotclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+var testSecrets = map[string]string{"tenantID": "tenant", "clientID": "client", "clientSecret": "secret"}
+
+// useTestTenantCloud makes the secrets of the requests use a test cloud whose clients are returned
+func useTestTenantCloud(t *testing.T, cntl *gomock.Controller) (*mock_diskclient.MockInterface, *mock_snapshotclient.MockInterface) {
+	tenantCloud := azure.GetTestCloud(cntl)
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	snapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+	tenantFactory := tenantCloud.ComputeClientFactory.(*mock_azclient.MockClientFactory)
+	tenantFactory.EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	tenantFactory.EXPECT().GetSnapshotClientForSub(gomock.Any()).Return(snapshotClient, nil).AnyTimes()
+
+	original := getCloudProviderWithCredentials
+	t.Cleanup(func() { getCloudProviderWithCredentials = original })
+	getCloudProviderWithCredentials = func(_ context.Context, _ clientset.Interface, _, _, _ string, _, _ bool, _ int64,
+		_ *azureutils.ClientRateLimitOptions, creds *azureutils.CloudCredentials) (*azure.Cloud, error) {
+		assert.Equal(t, "tenant", creds.TenantID)
+		return tenantCloud, nil
+	}
+	return diskClient, snapshotClient
+}
+
+func TestGetCloudFromSecrets(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	cloud, err := d.getCloudFromSecrets(ctx, nil, "")
+	assert.NoError(t, err)
+	assert.Nil(t, cloud)
+
+	invalidSecrets := map[string]string{"tenantID": "tenant", "clientSecret": "secret"}
+	_, err = d.getCloudFromSecrets(ctx, invalidSecrets, "")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "unit-test",
+		VolumeCapabilities: createVolumeCapabilities(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+		Secrets:            invalidSecrets,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "CreateVolume: %v", err)
+
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: testVolumeID, Secrets: invalidSecrets})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "DeleteVolume: %v", err)
+
+	_, err = d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: testVolumeID, Name: "snapshot", Secrets: invalidSecrets})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "CreateSnapshot: %v", err)
+
+	_, err = d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "snapshot", Secrets: invalidSecrets})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "DeleteSnapshot: %v", err)
+}
+
+func TestCreateVolumeFromSnapshotWithSecrets(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	// the clients of the driver must not be used, the mock fails on any call
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	diskClient, snapshotClient := useTestTenantCloud(t, cntl)
+
+	sourceDiskID := "/subscriptions/tenant-subs/resourceGroups/rg/providers/Microsoft.Compute/disks/source-disk"
+	snapshotID := "/subscriptions/tenant-subs/resourceGroups/rg/providers/Microsoft.Compute/snapshots/snapshot"
+	snapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapshot").Return(&armcompute.Snapshot{
+		ID: ptr.To(snapshotID),
+		Properties: &armcompute.SnapshotProperties{
+			CreationData:      &armcompute.CreationData{SourceResourceID: ptr.To(sourceDiskID)},
+			DiskSizeGB:        ptr.To(int32(5)),
+			CompletionPercent: ptr.To(float32(100)),
+		},
+	}, nil).MinTimes(1)
+	diskClient.EXPECT().Get(gomock.Any(), "rg", "source-disk").Return(&armcompute.Disk{Zones: []*string{ptr.To("2")}}, nil).Times(1)
+	diskClient.EXPECT().CreateOrUpdate(gomock.Any(), gomock.Any(), "unit-test", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ string, disk armcompute.Disk) (*armcompute.Disk, error) {
+			assert.Equal(t, snapshotID, *disk.Properties.CreationData.SourceResourceID)
+			return &disk, nil
+		}).Times(1)
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), "unit-test").Return(&armcompute.Disk{
+		ID:         ptr.To("/subscriptions/tenant-subs/resourceGroups/rg/providers/Microsoft.Compute/disks/unit-test"),
+		Properties: &armcompute.DiskProperties{ProvisioningState: ptr.To("Succeeded")},
+	}, nil).AnyTimes()
+
+	resp, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:                "unit-test",
+		CapacityRange:       &csi.CapacityRange{RequiredBytes: 10 << 30},
+		VolumeCapabilities:  createVolumeCapabilities(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+		Parameters:          map[string]string{"skuName": "Premium_LRS"},
+		VolumeContentSource: &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID}}},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{{Segments: map[string]string{topologyKey: d.getCloud().Location + "-1"}}},
+		},
+		Secrets: testSecrets,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "true", resp.Volume.VolumeContext["resizeRequired"])
+}
+
+func TestCreateSnapshotWithSecrets(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	// the clients of the driver must not be used, the mock fails on any call
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	_, snapshotClient := useTestTenantCloud(t, cntl)
+
+	sourceVolumeID := "/subscriptions/tenant-subs/resourceGroups/rg/providers/Microsoft.Compute/disks/disk"
+	snapshotID := "/subscriptions/tenant-subs/resourceGroups/rg/providers/Microsoft.Compute/snapshots/snapshot"
+	snapshotClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "snapshot", gomock.Any()).Return(nil, nil).Times(1)
+	snapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapshot").Return(&armcompute.Snapshot{
+		ID: ptr.To(snapshotID),
+		Properties: &armcompute.SnapshotProperties{
+			DiskSizeGB:        ptr.To(int32(10)),
+			TimeCreated:       ptr.To(time.Now()),
+			ProvisioningState: ptr.To("Succeeded"),
+		},
+	}, nil).MinTimes(2)
+
+	resp, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		SourceVolumeId: sourceVolumeID,
+		Name:           "snapshot",
+		Secrets:        testSecrets,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, snapshotID, resp.Snapshot.SnapshotId)
+	assert.True(t, resp.Snapshot.ReadyToUse)
+}
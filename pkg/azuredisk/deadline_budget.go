@@ -0,0 +1,412 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	volerr "k8s.io/cloud-provider/volume/errors"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/snapshotclient"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+var (
+	// inflightOperationTimeout is the maximum time of an ARM operation which outlives the RPC that started it if the
+	// operation has no timeout configured
+	inflightOperationTimeout = 10 * time.Minute
+	// inflightOperationResultTTL is the time the result of a succeeded operation is kept for the retry of its RPC
+	inflightOperationResultTTL = time.Minute
+
+	// dedupOperations are the operations which always run as in-flight operations, even if the deadline budget is
+	// disabled, so that the retry of CreateVolume or DeleteVolume from a restarted csi-provisioner joins the disk
+	// creation or deletion in progress or gets its result instead of sending a second PUT or DELETE, mapped to the
+	// operation label of volumeOperationDedupHits
+	dedupOperations = map[string]string{
+		"create": "create_volume",
+		"delete": "delete_volume",
+	}
+
+	// volumeOperationDedupHits records the retries which joined an operation in progress or got the result of a
+	// succeeded one instead of calling ARM again
+	volumeOperationDedupHits = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      consts.AzureDiskCSIDriverName,
+			Name:           "volume_operation_dedup_hits_total",
+			Help:           "Number of volume operations which got the result of the same operation in progress or succeeded recently",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation", "source"},
+	)
+)
+
+const (
+	dedupSourceInflight = "inflight"
+	dedupSourceCached   = "cached"
+)
+
+func init() {
+	legacyregistry.MustRegister(volumeOperationDedupHits)
+}
+
+// inflightOperation is an ARM operation which keeps running after the RPC that started it ran out of its deadline budget
+type inflightOperation struct {
+	operation string
+	// fingerprint of the parameters of the operation, the retry only joins or gets the result of the same request
+	fingerprint string
+	done        chan struct{}
+	result      interface{}
+	err         error
+	completedAt time.Time
+}
+
+// inflightOperations tracks the operations by resource and node, so that the retry of an RPC abandoned by the
+// CSI sidecar waits for the operation started by the previous call instead of starting a concurrent one. It's the
+// only deduplication of ARM operations of the controller, at most one operation runs on a resource and node.
+type inflightOperations struct {
+	sync.Mutex
+	ops map[string]*inflightOperation
+}
+
+func newInflightOperations() *inflightOperations {
+	return &inflightOperations{ops: map[string]*inflightOperation{}}
+}
+
+// getOrStart returns the operation of key, a new operation running op with timeout is started if there is none or the
+// result of the succeeded one has expired or is the result of another operation or of the same operation with another
+// fingerprint. The source of an existing operation (dedupSourceInflight or dedupSourceCached) is also returned, it's
+// empty for a new one. An Aborted error is returned if another operation, or the same operation with another
+// fingerprint, is still running on key.
+func (o *inflightOperations) getOrStart(ctx context.Context, operation, fingerprint, key string, timeout time.Duration,
+	op func(context.Context) (interface{}, error)) (*inflightOperation, string, error) {
+	o.Lock()
+	defer o.Unlock()
+	for k, existing := range o.ops {
+		select {
+		case <-existing.done:
+			if time.Since(existing.completedAt) >= inflightOperationResultTTL {
+				delete(o.ops, k)
+			}
+		default:
+		}
+	}
+	if existing, ok := o.ops[key]; ok {
+		sameRequest := existing.operation == operation && existing.fingerprint == fingerprint
+		select {
+		case <-existing.done:
+			if sameRequest {
+				klog.V(2).Infof("return the result of the completed %s of %s", operation, key)
+				return existing, dedupSourceCached, nil
+			}
+		default:
+			if existing.operation != operation {
+				return nil, "", status.Errorf(codes.Aborted, "%s of %s is still in progress", existing.operation, key)
+			}
+			if !sameRequest {
+				return nil, "", status.Errorf(codes.Aborted, "%s of %s with other parameters is still in progress", operation, key)
+			}
+			klog.V(2).Infof("wait for the in-flight %s of %s", operation, key)
+			return existing, dedupSourceInflight, nil
+		}
+	}
+
+	inflight := &inflightOperation{operation: operation, fingerprint: fingerprint, done: make(chan struct{})}
+	o.ops[key] = inflight
+	// the operation is detached from the cancellation of the RPC but keeps its values, e.g. trace span, system-critical marker
+	opCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	go func() {
+		defer cancel()
+		var result interface{}
+		// the operation is completed with an error if op exits without returning
+		err := fmt.Errorf("%s of %s did not complete", operation, key)
+		defer func() {
+			o.Lock()
+			inflight.result, inflight.err, inflight.completedAt = result, err, time.Now()
+			// failures are not kept so that the retry runs the operation again
+			if err != nil && o.ops[key] == inflight {
+				delete(o.ops, key)
+			}
+			o.Unlock()
+			close(inflight.done)
+		}()
+		result, err = op(opCtx)
+	}()
+	return inflight, "", nil
+}
+
+// remove removes the completed operation of key once its result is returned
+func (o *inflightOperations) remove(key string, inflight *inflightOperation) {
+	o.Lock()
+	defer o.Unlock()
+	if o.ops[key] == inflight {
+		delete(o.ops, key)
+	}
+}
+
+// isRunning returns whether any operation whose key starts with prefix is still running
+func (o *inflightOperations) isRunning(prefix string) bool {
+	o.Lock()
+	defer o.Unlock()
+	prefix = strings.ToLower(prefix)
+	for key, inflight := range o.ops {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		select {
+		case <-inflight.done:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// inflightOperationKey returns the key of the operations on the resource and node, nodeName is empty for the operations
+// which are not on a node
+func inflightOperationKey(resourceID string, nodeName types.NodeName) string {
+	key := strings.ToLower(resourceID)
+	if nodeName != "" {
+		key += "/" + strings.ToLower(string(nodeName))
+	}
+	return key
+}
+
+// withDeadlineBudget returns a context ending deadlineBudgetMarginSeconds before the deadline of ctx set by the CSI sidecar
+// timeout, half of the remaining time is used if it's shorter than the margin. ctx is returned as is if it has no deadline
+// or the deadline budget is disabled.
+func (d *DriverCore) withDeadlineBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || d.deadlineBudgetMarginSeconds <= 0 {
+		return ctx, func() {}
+	}
+	margin := time.Duration(d.deadlineBudgetMarginSeconds) * time.Second
+	if remaining := time.Until(deadline); remaining < 2*margin {
+		margin = remaining / 2
+	}
+	return context.WithDeadline(ctx, deadline.Add(-margin))
+}
+
+// runWithDeadlineBudget runs the ARM operation op on the resource and node within the deadline budget of ctx, the
+// operation keeps running until it completes or timeoutInSeconds (inflightOperationTimeout if 0) passes, and a
+// DeadlineExceeded error is returned if the budget runs out, the retry of the RPC then waits for the same operation.
+// op must acquire the locks and budgets it needs itself so that they are held until the operation completes.
+// op is called with ctx as is if the deadline budget is disabled, except for dedupOperations which run until ctx ends
+// and keep the succeeded result for inflightOperationResultTTL. fingerprint identifies the parameters of the request,
+// a retry only joins or gets the result of the operation with the same fingerprint.
+func (d *DriverCore) runWithDeadlineBudget(ctx context.Context, operation, fingerprint, resourceID string, nodeName types.NodeName, timeoutInSeconds int64,
+	op func(context.Context) (interface{}, error)) (interface{}, error) {
+	dedupOperation, isDedup := dedupOperations[operation]
+	if _, ok := ctx.Deadline(); d.inflightOperations == nil || (!isDedup && (!ok || d.deadlineBudgetMarginSeconds <= 0)) {
+		return op(ctx)
+	}
+	budgetCtx, cancel := d.withDeadlineBudget(ctx)
+	defer cancel()
+
+	timeout := inflightOperationTimeout
+	if timeoutInSeconds > 0 {
+		timeout = time.Duration(timeoutInSeconds) * time.Second
+	}
+	key := inflightOperationKey(resourceID, nodeName)
+	inflight, source, err := d.inflightOperations.getOrStart(ctx, operation, fingerprint, key, timeout, op)
+	if err != nil {
+		return nil, err
+	}
+	if isDedup && source != "" {
+		volumeOperationDedupHits.WithLabelValues(dedupOperation, source).Inc()
+	}
+	select {
+	case <-inflight.done:
+		// the succeeded result of a dedup operation is kept for the retry of a csi-provisioner which missed it
+		if !isDedup {
+			d.inflightOperations.remove(key, inflight)
+		}
+		return inflight.result, inflight.err
+	case <-budgetCtx.Done():
+		klog.Warningf("deadline budget of %s of %s ran out, it keeps running and will be resumed by the retry", operation, key)
+		return nil, status.Errorf(codes.DeadlineExceeded, "%s of %s is still in progress, it will be resumed by the retry", operation, key)
+	}
+}
+
+// attachDiskWithDeadlineBudget attaches the disk to the node with diskController within the deadline budget of ctx, the
+// attach slot of the node from acquireAttachSlot (if not nil) and the mutation budget are held until the attach completes,
+// the attach of a system-critical volume is retried in the same operation
+func (d *DriverCore) attachDiskWithDeadlineBudget(ctx context.Context, diskController *ManagedDiskController, diskName, diskURI string, nodeName types.NodeName,
+	cachingMode armcompute.CachingTypes, disk *armcompute.Disk, occupiedLuns []int, acquireAttachSlot func(context.Context, types.NodeName) (func(), error)) (int32, error) {
+	result, err := d.runWithDeadlineBudget(ctx, "attach", operationFingerprint(diskName, cachingMode), diskURI, nodeName, d.attachTimeoutInSeconds, func(ctx context.Context) (interface{}, error) {
+		if acquireAttachSlot != nil {
+			releaseSlot, err := acquireAttachSlot(ctx, nodeName)
+			if err != nil {
+				return nil, err
+			}
+			defer releaseSlot()
+		}
+		release, err := d.acquireMutationBudget(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		lun, err := diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
+		if _, isDangling := err.(*volerr.DanglingAttachError); err != nil && !isDangling && isSystemCriticalOperation(ctx) {
+			err = retrySystemCriticalOperation(ctx, fmt.Sprintf("attach volume %s to node %s", diskURI, nodeName), func() error {
+				var attachErr error
+				lun, attachErr = diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
+				return attachErr
+			})
+		}
+		return lun, err
+	})
+	if lun, ok := result.(int32); ok {
+		return lun, err
+	}
+	return -1, err
+}
+
+// detachDiskWithDeadlineBudget detaches the disk from the node with diskController within the deadline budget of ctx, the
+// mutation budget is held until the detach completes, the detach of a system-critical volume is retried in the same operation
+func (d *DriverCore) detachDiskWithDeadlineBudget(ctx context.Context, diskController *ManagedDiskController, diskName, diskURI string, nodeName types.NodeName) error {
+	_, err := d.runWithDeadlineBudget(ctx, "detach", operationFingerprint(diskName), diskURI, nodeName, d.detachTimeoutInSeconds, func(ctx context.Context) (interface{}, error) {
+		release, err := d.acquireMutationBudget(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		err = diskController.DetachDisk(ctx, diskName, diskURI, nodeName)
+		if err != nil && isSystemCriticalOperation(ctx) && !strings.Contains(err.Error(), consts.ErrDiskNotFound) {
+			err = retrySystemCriticalOperation(ctx, fmt.Sprintf("detach volume %s from node %s", diskURI, nodeName), func() error {
+				return diskController.DetachDisk(ctx, diskName, diskURI, nodeName)
+			})
+		}
+		return nil, err
+	})
+	return err
+}
+
+// createManagedDiskWithDeadlineBudget creates the disk with diskController within the deadline budget of ctx, the
+// operation is keyed by the URI of the disk CreateManagedDisk creates, i.e. in the subscription and resource group of
+// the cloud if options has none, so that cleanupResourceGroup sees the creation in progress in the resource group
+func (d *DriverCore) createManagedDiskWithDeadlineBudget(ctx context.Context, diskController *ManagedDiskController, options *ManagedDiskOptions) (string, error) {
+	subsID, resourceGroup := options.SubscriptionID, options.ResourceGroup
+	if cloud := diskController.getCloud(); cloud != nil {
+		if subsID == "" {
+			subsID = cloud.SubscriptionID
+		}
+		if resourceGroup == "" {
+			resourceGroup = cloud.ResourceGroup
+		}
+	}
+	diskURI := fmt.Sprintf(consts.ManagedDiskPath, subsID, resourceGroup, options.DiskName)
+	result, err := d.runWithDeadlineBudget(ctx, "create", createManagedDiskFingerprint(options), diskURI, "", d.createVolumeTimeoutInSeconds, func(ctx context.Context) (interface{}, error) {
+		release, err := d.acquireMutationBudget(ctx, options.SubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return diskController.CreateManagedDisk(ctx, options)
+	})
+	diskURI, _ = result.(string)
+	return diskURI, err
+}
+
+// createManagedDiskFingerprint returns the fingerprint of the parameters of the disk creation, so that the retry of a
+// CreateVolume with other parameters does not get the disk created with the previous ones
+func createManagedDiskFingerprint(options *ManagedDiskOptions) string {
+	o := *options
+	// skipping the GET of the disk does not change the created disk
+	o.SkipGetDiskOperation = false
+	return operationFingerprint(o)
+}
+
+// operationFingerprint returns the fingerprint of the parameters of an operation, the parameters must only hold
+// strings, numbers, maps of strings and pointers to them, which are always marshaled
+func operationFingerprint(params ...interface{}) string {
+	data, _ := json.Marshal(params)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// deleteManagedDiskWithDeadlineBudget deletes the disk with diskController within the deadline budget of ctx, the
+// operation is fingerprinted by the identity of diskController since DeleteVolume may use the credential of its secrets
+func (d *DriverCore) deleteManagedDiskWithDeadlineBudget(ctx context.Context, diskController *ManagedDiskController, diskURI string) error {
+	var fingerprint string
+	if cloud := diskController.getCloud(); cloud != nil {
+		fingerprint = operationFingerprint(cloud.TenantID, cloud.AADClientID, cloud.UserAssignedIdentityID)
+	}
+	_, err := d.runWithDeadlineBudget(ctx, "delete", fingerprint, diskURI, "", d.deleteVolumeTimeoutInSeconds, func(ctx context.Context) (interface{}, error) {
+		release, err := d.acquireMutationBudget(ctx, d.getDiskSubscriptionID(diskURI))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return nil, diskController.DeleteManagedDisk(ctx, diskURI)
+	})
+	return err
+}
+
+// modifyDiskWithDeadlineBudget modifies the disk with diskController within the deadline budget of ctx
+func (d *DriverCore) modifyDiskWithDeadlineBudget(ctx context.Context, diskController *ManagedDiskController, options *ManagedDiskOptions) error {
+	_, err := d.runWithDeadlineBudget(ctx, "modify", "", options.SourceResourceID, "", 0, func(ctx context.Context) (interface{}, error) {
+		return nil, diskController.ModifyDisk(ctx, options)
+	})
+	return err
+}
+
+// resizeDiskWithDeadlineBudget resizes the disk with diskController within the deadline budget of ctx
+func (d *DriverCore) resizeDiskWithDeadlineBudget(ctx context.Context, diskController *ManagedDiskController, diskURI string, oldSize, newSize resource.Quantity) (resource.Quantity, error) {
+	result, err := d.runWithDeadlineBudget(ctx, "resize", "", diskURI, "", 0, func(ctx context.Context) (interface{}, error) {
+		release, err := d.acquireMutationBudget(ctx, d.getDiskSubscriptionID(diskURI))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return diskController.ResizeDisk(ctx, diskURI, oldSize, newSize, d.enableDiskOnlineResize)
+	})
+	size, _ := result.(resource.Quantity)
+	return size, err
+}
+
+// createSnapshotWithDeadlineBudget creates the snapshot with snapshotClient within the deadline budget of ctx
+func (d *DriverCore) createSnapshotWithDeadlineBudget(ctx context.Context, snapshotClient snapshotclient.Interface, subsID, resourceGroup, snapshotName string, snapshot armcompute.Snapshot) error {
+	snapshotID := fmt.Sprintf(diskSnapshotPath, subsID, resourceGroup, snapshotName)
+	_, err := d.runWithDeadlineBudget(ctx, "snapshot", "", snapshotID, "", 0, func(ctx context.Context) (interface{}, error) {
+		return snapshotClient.CreateOrUpdate(ctx, resourceGroup, snapshotName, snapshot)
+	})
+	return err
+}
+
+// deleteSnapshotWithDeadlineBudget deletes the snapshot with snapshotClient within the deadline budget of ctx
+func (d *DriverCore) deleteSnapshotWithDeadlineBudget(ctx context.Context, snapshotClient snapshotclient.Interface, subsID, resourceGroup, snapshotName string) error {
+	snapshotID := fmt.Sprintf(diskSnapshotPath, subsID, resourceGroup, snapshotName)
+	_, err := d.runWithDeadlineBudget(ctx, "deletesnapshot", "", snapshotID, "", 0, func(ctx context.Context) (interface{}, error) {
+		return nil, snapshotClient.Delete(ctx, resourceGroup, snapshotName)
+	})
+	return err
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/snapshotclient/mock_snapshotclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestWithDeadlineBudget(t *testing.T) {
+	d := &DriverCore{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	// disabled
+	budgetCtx, budgetCancel := d.withDeadlineBudget(ctx)
+	budgetDeadline, _ := budgetCtx.Deadline()
+	assert.Equal(t, deadline, budgetDeadline)
+	budgetCancel()
+
+	d.deadlineBudgetMarginSeconds = 10
+	budgetCtx, budgetCancel = d.withDeadlineBudget(ctx)
+	budgetDeadline, _ = budgetCtx.Deadline()
+	assert.Equal(t, deadline.Add(-10*time.Second), budgetDeadline)
+	budgetCancel()
+
+	// half of the remaining time is used if it's shorter than the margin
+	d.deadlineBudgetMarginSeconds = 60
+	budgetCtx, budgetCancel = d.withDeadlineBudget(ctx)
+	budgetDeadline, _ = budgetCtx.Deadline()
+	assert.InDelta(t, 30*time.Second, time.Until(budgetDeadline), float64(time.Second))
+	budgetCancel()
+
+	// no deadline
+	budgetCtx, budgetCancel = d.withDeadlineBudget(context.Background())
+	_, ok := budgetCtx.Deadline()
+	assert.False(t, ok)
+	budgetCancel()
+}
+
+func TestRunWithDeadlineBudget(t *testing.T) {
+	d := &DriverCore{deadlineBudgetMarginSeconds: 10, inflightOperations: newInflightOperations()}
+	diskURI := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/Disk"
+	key := inflightOperationKey(diskURI, "Node1")
+	assert.Equal(t, "/subscriptions/subs/resourcegroups/rg/providers/microsoft.compute/disks/disk/node1", key)
+	assert.Equal(t, "/subscriptions/subs/resourcegroups/rg/providers/microsoft.compute/disks/disk", inflightOperationKey(diskURI, ""))
+
+	var started int32
+	release := make(chan struct{})
+	op := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&started, 1)
+		select {
+		case <-release:
+			return int32(3), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// the operation keeps running after the deadline budget of the first call runs out
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, err := d.runWithDeadlineBudget(ctx, "attach", "", diskURI, "Node1", 0, op)
+	cancel()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.True(t, d.inflightOperations.isRunning("/subscriptions/subs/resourceGroups/rg/"))
+	assert.False(t, d.inflightOperations.isRunning("/subscriptions/subs/resourceGroups/rg2/"))
+
+	// another operation on the same disk and node is not started while the attach is running
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err = d.runWithDeadlineBudget(ctx, "detach", "", diskURI, "Node1", 0, op)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// the retry waits for the same operation
+	close(release)
+	result, err := d.runWithDeadlineBudget(ctx, "attach", "", diskURI, "Node1", 0, op)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&started))
+	assert.Empty(t, d.inflightOperations.ops)
+	assert.False(t, d.inflightOperations.isRunning("/subscriptions/subs/resourceGroups/rg/"))
+
+	// op is called directly without deadline
+	result, err = d.runWithDeadlineBudget(context.Background(), "attach", "", diskURI, "Node1", 0, func(_ context.Context) (interface{}, error) { return int32(5), nil })
+	assert.NoError(t, err)
+	assert.Equal(t, int32(5), result)
+	assert.Empty(t, d.inflightOperations.ops)
+}
+
+func TestRunWithDeadlineBudgetFailure(t *testing.T) {
+	d := &DriverCore{deadlineBudgetMarginSeconds: 10, inflightOperations: newInflightOperations()}
+	diskURI := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/disk"
+	fail := make(chan struct{})
+	op := func(ctx context.Context) (interface{}, error) {
+		select {
+		case <-fail:
+			return nil, fmt.Errorf("test error")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// the failure of an operation which outlived its call is not returned to the retry
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, err := d.runWithDeadlineBudget(ctx, "delete", "", diskURI, "", 0, op)
+	cancel()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	close(fail)
+	assert.Eventually(t, func() bool {
+		d.inflightOperations.Lock()
+		defer d.inflightOperations.Unlock()
+		return len(d.inflightOperations.ops) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err = d.runWithDeadlineBudget(ctx, "delete", "", diskURI, "", 0, func(_ context.Context) (interface{}, error) { return nil, nil })
+	assert.NoError(t, err)
+
+	// the operation is stopped after its timeout
+	_, err = d.runWithDeadlineBudget(ctx, "delete", "", diskURI+"-timeout", "", 1, func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestRunWithDeadlineBudgetDedup(t *testing.T) {
+	// the deadline budget is disabled
+	d := &DriverCore{inflightOperations: newInflightOperations()}
+	diskURI := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/dedup"
+	getDedupHits := func(source string) float64 {
+		hits, err := testutil.GetCounterMetricValue(volumeOperationDedupHits.WithLabelValues("create_volume", source))
+		assert.NoError(t, err)
+		return hits
+	}
+	inflightHits, cachedHits := getDedupHits(dedupSourceInflight), getDedupHits(dedupSourceCached)
+
+	var started int32
+	release := make(chan struct{})
+	op := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&started, 1)
+		select {
+		case <-release:
+			return diskURI, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// the creation keeps running after the call of a restarted csi-provisioner is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	_, err := d.runWithDeadlineBudget(ctx, "create", "", diskURI, "", 0, op)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	// the retry joins the creation in progress
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+	result, err := d.runWithDeadlineBudget(context.Background(), "create", "", diskURI, "", 0, op)
+	assert.NoError(t, err)
+	assert.Equal(t, diskURI, result)
+
+	// the next retry gets the result of the succeeded creation
+	result, err = d.runWithDeadlineBudget(context.Background(), "create", "", diskURI, "", 0, op)
+	assert.NoError(t, err)
+	assert.Equal(t, diskURI, result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&started))
+	assert.Equal(t, inflightHits+1, getDedupHits(dedupSourceInflight))
+	assert.Equal(t, cachedHits+1, getDedupHits(dedupSourceCached))
+
+	// the retry with other parameters does not get the result of the previous creation
+	result, err = d.runWithDeadlineBudget(context.Background(), "create", "other", diskURI, "", 0, func(_ context.Context) (interface{}, error) { return diskURI + "-other", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, diskURI+"-other", result)
+	assert.Equal(t, cachedHits+1, getDedupHits(dedupSourceCached))
+
+	// the deletion of the disk drops the result of the creation
+	_, err = d.runWithDeadlineBudget(context.Background(), "delete", "", diskURI, "", 0, func(_ context.Context) (interface{}, error) { return nil, nil })
+	assert.NoError(t, err)
+	_, err = d.runWithDeadlineBudget(context.Background(), "create", "", diskURI, "", 0, func(_ context.Context) (interface{}, error) { return diskURI, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, cachedHits+1, getDedupHits(dedupSourceCached))
+
+	// other operations are not tracked without deadline budget
+	_, err = d.runWithDeadlineBudget(context.Background(), "attach", "", diskURI, "Node1", 0, func(_ context.Context) (interface{}, error) { return int32(1), nil })
+	assert.NoError(t, err)
+	assert.False(t, d.inflightOperations.isRunning(diskURI+"/node1"))
+	_, ok := d.inflightOperations.ops[inflightOperationKey(diskURI, "Node1")]
+	assert.False(t, ok)
+}
+
+func TestCreateSnapshotWithDeadlineBudget(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d := &DriverCore{deadlineBudgetMarginSeconds: 10, inflightOperations: newInflightOperations()}
+	snapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+	release := make(chan struct{})
+	snapshotClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "snapshot", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _, _ string, snapshot armcompute.Snapshot) (*armcompute.Snapshot, error) {
+			<-release
+			return &snapshot, nil
+		}).Times(1)
+
+	// the snapshot keeps being created after the deadline budget of the first call runs out
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	err := d.createSnapshotWithDeadlineBudget(ctx, snapshotClient, "subs", "rg", "snapshot", armcompute.Snapshot{})
+	cancel()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	// the retry joins the creation instead of sending another request
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	close(release)
+	assert.NoError(t, d.createSnapshotWithDeadlineBudget(ctx, snapshotClient, "subs", "rg", "snapshot", armcompute.Snapshot{}))
+	assert.Empty(t, d.inflightOperations.ops)
+}
+
+func TestCreateManagedDiskFingerprint(t *testing.T) {
+	options := &ManagedDiskOptions{DiskName: "disk", SizeGB: 10, StorageAccountType: armcompute.DiskStorageAccountTypesPremiumLRS}
+	fingerprint := createManagedDiskFingerprint(options)
+
+	skipGet := *options
+	skipGet.SkipGetDiskOperation = true
+	assert.Equal(t, fingerprint, createManagedDiskFingerprint(&skipGet))
+
+	resized := *options
+	resized.SizeGB = 20
+	assert.NotEqual(t, fingerprint, createManagedDiskFingerprint(&resized))
+}
+
+func TestCreateManagedDiskWithDeadlineBudget(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	testCloud := provider.GetTestCloud(cntl)
+	diskController := &ManagedDiskController{&controllerCommon{cloud: testCloud, lockMap: newLockMap(), clientFactory: testCloud.ComputeClientFactory}}
+	d := &DriverCore{deadlineBudgetMarginSeconds: 10, inflightOperations: newInflightOperations()}
+
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	testCloud.ComputeClientFactory.(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(testCloud.SubscriptionID).Return(diskClient, nil).AnyTimes()
+	release := make(chan struct{})
+	diskClient.EXPECT().CreateOrUpdate(gomock.Any(), testCloud.ResourceGroup, "disk", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ string, disk armcompute.Disk) (*armcompute.Disk, error) {
+			<-release
+			return &disk, nil
+		}).Times(1)
+	expectedDiskURI := fmt.Sprintf(managedDiskPath, testCloud.SubscriptionID, testCloud.ResourceGroup, "disk")
+	diskClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, "disk").Return(&armcompute.Disk{
+		ID:         ptr.To(expectedDiskURI),
+		Properties: &armcompute.DiskProperties{ProvisioningState: ptr.To("Succeeded")},
+	}, nil).AnyTimes()
+
+	// the options have no subscription nor resource group, the creation is still tracked in the ones of the cloud
+	options := &ManagedDiskOptions{DiskName: "disk", SizeGB: 10, StorageAccountType: armcompute.DiskStorageAccountTypesPremiumLRS}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, err := d.createManagedDiskWithDeadlineBudget(ctx, diskController, options)
+	cancel()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.True(t, d.inflightOperations.isRunning(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/", testCloud.SubscriptionID, testCloud.ResourceGroup)))
+
+	// the retry joins the creation instead of sending another request
+	close(release)
+	diskURI, err := d.createManagedDiskWithDeadlineBudget(context.Background(), diskController, options)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedDiskURI, diskURI)
+}
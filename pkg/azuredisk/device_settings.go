@@ -0,0 +1,288 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
+)
+
+// tunedVolume is a volume staged on the node whose device settings are reconciled with the annotations of its PV
+type tunedVolume struct {
+	pvName        string
+	devicePath    string
+	volumeContext map[string]string
+	// applied is the perf profile and device settings last applied to the device
+	applied string
+}
+
+// trackTunedVolume adds the volume staged on devicePath to the device settings reconciliation, the PV name falls back
+// to the disk name if it's not in the volume context. It's a no-op if the reconciliation is disabled.
+func (d *Driver) trackTunedVolume(volumeID string, volumeContext map[string]string, devicePath string) {
+	if d.deviceSettingsReconcileSeconds <= 0 || !d.getPerfOptimizationEnabled() || devicePath == "" {
+		return
+	}
+	pvName := volumeContext[consts.PvNameKey]
+	if pvName == "" {
+		pvName, _ = azureutils.GetDiskName(volumeID)
+	}
+	d.tunedVolumes.Store(volumeID, tunedVolume{
+		pvName:        pvName,
+		devicePath:    devicePath,
+		volumeContext: volumeContext,
+		applied:       getPerfSettingsKey(volumeContext),
+	})
+}
+
+// trackTunedMount adds the volume staged on the existing mount of stagingPath to the device settings reconciliation,
+// the volume is not reconciled if the device of the mount is not found
+func (d *Driver) trackTunedMount(volumeID string, volumeContext map[string]string, stagingPath string) {
+	if d.deviceSettingsReconcileSeconds <= 0 || !d.getPerfOptimizationEnabled() {
+		return
+	}
+	devicePath, err := getDevicePathWithMountPath(stagingPath, d.mounter)
+	if err != nil {
+		klog.V(4).Infof("failed to get device of staging path %s: %v", stagingPath, err)
+	}
+	d.trackTunedVolume(volumeID, volumeContext, devicePath)
+}
+
+// runDeviceSettingsReconciler re-applies the perf optimization of the devices of the volumes staged on the node when
+// the perf profile or device settings annotations of their PVs are changed, without remounting the volumes. The PVs
+// are read from an informer, the volumes staged before a restart of the node plugin are tracked again from the mount
+// table, and the failed volumes are retried every interval.
+func (d *Driver) runDeviceSettingsReconciler(ctx context.Context, interval time.Duration) {
+	factory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	informer := factory.Core().V1().PersistentVolumes().Informer()
+	// PVs can't be selected by node, so only the fields read by the reconciler are cached
+	if err := informer.SetTransform(trimTunedPV); err != nil {
+		klog.Errorf("failed to set the transform of the PV informer: %v", err)
+		return
+	}
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    d.onTunedPVUpdate,
+		UpdateFunc: func(_, obj interface{}) { d.onTunedPVUpdate(obj) },
+	}); err != nil {
+		klog.Errorf("failed to add the event handler of the PV informer: %v", err)
+		return
+	}
+	d.pvLister = factory.Core().V1().PersistentVolumes().Lister()
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		klog.Errorf("PV informer of the device settings reconciler is not synced")
+		return
+	}
+	d.rebuildTunedVolumes()
+
+	klog.V(2).Infof("reconciling device settings of staged volumes on PV changes, retried every %v", interval)
+	wait.UntilWithContext(ctx, func(_ context.Context) {
+		d.tunedVolumes.Range(func(key, _ interface{}) bool {
+			volumeID := key.(string)
+			if err := d.reconcileDeviceSettings(volumeID); err != nil {
+				klog.Errorf("failed to reconcile device settings of volume %s: %v", volumeID, err)
+			}
+			return true
+		})
+	}, interval)
+}
+
+// trimTunedPV drops the fields of a PV which are not read by the device settings reconciler
+func trimTunedPV(obj interface{}) (interface{}, error) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		return obj, nil
+	}
+	trimmed := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pv.Name,
+			UID:             pv.UID,
+			ResourceVersion: pv.ResourceVersion,
+			Annotations:     pv.Annotations,
+		},
+	}
+	if pv.Spec.CSI != nil {
+		trimmed.Spec.CSI = &v1.CSIPersistentVolumeSource{
+			Driver:           pv.Spec.CSI.Driver,
+			VolumeHandle:     pv.Spec.CSI.VolumeHandle,
+			VolumeAttributes: pv.Spec.CSI.VolumeAttributes,
+		}
+	}
+	return trimmed, nil
+}
+
+// onTunedPVUpdate reconciles the device settings of the volumes of the PV staged on the node
+func (d *Driver) onTunedPVUpdate(obj interface{}) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		return
+	}
+	d.tunedVolumes.Range(func(key, value interface{}) bool {
+		if value.(tunedVolume).pvName != pv.Name {
+			return true
+		}
+		volumeID := key.(string)
+		if err := d.reconcileDeviceSettings(volumeID); err != nil {
+			klog.Errorf("failed to reconcile device settings of volume %s: %v", volumeID, err)
+		}
+		return true
+	})
+}
+
+// rebuildTunedVolumes tracks the volumes staged on the node before the node plugin was restarted, the settings of the
+// annotations of their PVs are applied again since the settings last applied are not known
+func (d *Driver) rebuildTunedVolumes() {
+	pvs, err := d.pvLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list PVs: %v", err)
+		return
+	}
+	mounts, err := d.getStagedMounts(pvs)
+	if err != nil {
+		klog.Errorf("failed to find the staged volumes of the device settings reconciler: %v", err)
+		return
+	}
+	for _, mount := range mounts {
+		d.trackStagedTunedMount(mount)
+	}
+}
+
+// trackStagedTunedMount tracks the volume of a staging path found in the mount table unless it's being staged or
+// unstaged, NodeStageVolume tracks the volume in that case
+func (d *Driver) trackStagedTunedMount(mount stagedMount) {
+	if acquired := d.volumeLocks.TryAcquire(mount.volumeID); !acquired {
+		return
+	}
+	defer d.volumeLocks.Release(mount.volumeID)
+	if _, ok := d.tunedVolumes.Load(mount.volumeID); ok {
+		return
+	}
+	if notMnt, err := d.mounter.IsLikelyNotMountPoint(mount.stagingPath); err != nil || notMnt {
+		return
+	}
+	klog.V(2).Infof("tracking device settings of volume %s staged on %s", mount.volumeID, mount.stagingPath)
+	d.trackTunedMount(mount.volumeID, mount.volumeContext, mount.stagingPath)
+}
+
+// reconcileDeviceSettings applies the perf profile and device settings of the volume context overridden by the
+// annotations of the PV to the device of the volume, if they are different from the ones last applied
+func (d *Driver) reconcileDeviceSettings(volumeID string) error {
+	value, ok := d.tunedVolumes.Load(volumeID)
+	if !ok || d.pvLister == nil {
+		return nil
+	}
+	volume := value.(tunedVolume)
+	pv, err := d.pvLister.Get(volume.pvName)
+	if err != nil {
+		return fmt.Errorf("failed to get pv(%s): %w", volume.pvName, err)
+	}
+	attributes, err := getAnnotatedPerfAttributes(volume.volumeContext, pv.Annotations)
+	if err != nil {
+		return err
+	}
+	key := getPerfSettingsKey(attributes)
+	if key == volume.applied {
+		return nil
+	}
+
+	// the volume is reconciled in the next interval if it's being staged or unstaged
+	if acquired := d.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil
+	}
+	defer d.volumeLocks.Release(volumeID)
+	if _, ok := d.tunedVolumes.Load(volumeID); !ok {
+		return nil
+	}
+
+	profile, accountType, diskSizeGibStr, diskIopsStr, diskBwMbpsStr, deviceSettings, err := optimization.GetDiskPerfAttributes(attributes)
+	if err != nil {
+		return err
+	}
+	if d.getDeviceHelper().DiskSupportsPerfOptimization(profile, accountType) {
+		if err := d.getDeviceHelper().OptimizeDiskPerformance(d.getNodeInfo(), volume.devicePath, profile, accountType,
+			diskSizeGibStr, diskIopsStr, diskBwMbpsStr, deviceSettings); err != nil {
+			return err
+		}
+		klog.V(2).Infof("applied perfProfile %s and device settings %v of pv(%s) to %s", profile, deviceSettings, volume.pvName, volume.devicePath)
+	} else {
+		klog.V(2).Infof("perf optimization is disabled for %s of pv(%s). perfProfile %s accountType %s", volume.devicePath, volume.pvName, profile, accountType)
+	}
+	volume.applied = key
+	d.tunedVolumes.Store(volumeID, volume)
+	return nil
+}
+
+// getAnnotatedPerfAttributes returns a copy of volumeContext with the perf profile and device settings overridden by
+// the perf profile and device settings annotations, the device settings annotation is a comma separated list of
+// setting=value, e.g. queue/scheduler=none,queue/read_ahead_kb=256
+func getAnnotatedPerfAttributes(volumeContext, annotations map[string]string) (map[string]string, error) {
+	attributes := make(map[string]string, len(volumeContext))
+	for k, v := range volumeContext {
+		attributes[k] = v
+	}
+	if profile, ok := annotations[consts.PerfProfileAnnotation]; ok {
+		for k := range attributes {
+			if strings.EqualFold(k, consts.PerfProfileField) {
+				delete(attributes, k)
+			}
+		}
+		attributes[consts.PerfProfileField] = profile
+	}
+	if settings := strings.TrimSpace(annotations[consts.DeviceSettingsAnnotation]); settings != "" {
+		for _, setting := range strings.Split(settings, ",") {
+			kv := strings.SplitN(setting, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return nil, fmt.Errorf("invalid device setting %q in annotation %s, expected setting=value", setting, consts.DeviceSettingsAnnotation)
+			}
+			key := consts.DeviceSettingsKeyPrefix + strings.TrimSpace(kv[0])
+			for k := range attributes {
+				if strings.EqualFold(k, key) {
+					delete(attributes, k)
+				}
+			}
+			attributes[key] = strings.TrimSpace(kv[1])
+		}
+	}
+	return attributes, nil
+}
+
+// getPerfSettingsKey returns the sorted perf profile and device settings in attributes, which identify the tuning of a device
+func getPerfSettingsKey(attributes map[string]string) string {
+	settings := []string{}
+	for k, v := range attributes {
+		key := strings.ToLower(k)
+		if key == consts.PerfProfileField || strings.HasPrefix(key, consts.DeviceSettingsKeyPrefix) {
+			settings = append(settings, key+"="+v)
+		}
+	}
+	sort.Strings(settings)
+	return strings.Join(settings, ",")
+}
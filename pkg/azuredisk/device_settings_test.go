@@ -0,0 +1,160 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/mount-utils"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization/mockoptimization"
+)
+
+func TestGetAnnotatedPerfAttributes(t *testing.T) {
+	volumeContext := map[string]string{
+		"skuName":                        "Premium_LRS",
+		"perfProfile":                    "Advanced",
+		"device-setting/queue/nomerges":  "0",
+		"Device-Setting/queue/scheduler": "mq-deadline",
+	}
+
+	attributes, err := getAnnotatedPerfAttributes(volumeContext, nil)
+	require.NoError(t, err)
+	assert.Equal(t, volumeContext, attributes)
+	assert.Equal(t, getPerfSettingsKey(volumeContext), getPerfSettingsKey(attributes))
+
+	attributes, err = getAnnotatedPerfAttributes(volumeContext, map[string]string{
+		consts.PerfProfileAnnotation:    "database",
+		consts.DeviceSettingsAnnotation: "queue/scheduler=none, queue/read_ahead_kb=256",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"skuName":                            "Premium_LRS",
+		"perfprofile":                        "database",
+		"device-setting/queue/nomerges":      "0",
+		"device-setting/queue/scheduler":     "none",
+		"device-setting/queue/read_ahead_kb": "256",
+	}, attributes)
+	assert.Equal(t, "device-setting/queue/nomerges=0,device-setting/queue/read_ahead_kb=256,device-setting/queue/scheduler=none,perfprofile=database", getPerfSettingsKey(attributes))
+	assert.Equal(t, "Advanced", volumeContext["perfProfile"], "volume context is not changed")
+
+	_, err = getAnnotatedPerfAttributes(volumeContext, map[string]string{consts.DeviceSettingsAnnotation: "queue/scheduler"})
+	assert.Error(t, err)
+}
+
+func TestReconcileDeviceSettings(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.setPerfOptimizationEnabled(true)
+	d.deviceSettingsReconcileSeconds = 60
+
+	pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv1"}}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, indexer.Add(pv))
+	d.pvLister = corelisters.NewPersistentVolumeLister(indexer)
+	volumeContext := map[string]string{consts.PvNameKey: "pv1", "skuName": "Premium_LRS", "perfProfile": "Basic"}
+	d.trackTunedVolume(testVolumeID, volumeContext, "/dev/sdc")
+
+	// nothing is applied until the PV is annotated
+	assert.NoError(t, d.reconcileDeviceSettings(testVolumeID))
+
+	deviceHelper := d.getDeviceHelper().(*mockoptimization.MockInterface)
+	deviceHelper.EXPECT().DiskSupportsPerfOptimization("Advanced", "Premium_LRS").Return(true).Times(1)
+	deviceHelper.EXPECT().OptimizeDiskPerformance(gomock.Any(), "/dev/sdc", "Advanced", "Premium_LRS", "", "", "",
+		map[string]string{"queue/scheduler": "none"}).Return(nil).Times(1)
+	pv.Annotations = map[string]string{consts.PerfProfileAnnotation: "Advanced", consts.DeviceSettingsAnnotation: "queue/scheduler=none"}
+	require.NoError(t, indexer.Update(pv))
+	d.onTunedPVUpdate(pv)
+	// the applied settings are not applied again
+	assert.NoError(t, d.reconcileDeviceSettings(testVolumeID))
+
+	// the volume is not reconciled after it's unstaged
+	d.tunedVolumes.Delete(testVolumeID)
+	pv.Annotations[consts.DeviceSettingsAnnotation] = "queue/scheduler=mq-deadline"
+	require.NoError(t, indexer.Update(pv))
+	d.onTunedPVUpdate(pv)
+
+	// volumes are not tracked if the reconciliation is disabled
+	d.deviceSettingsReconcileSeconds = 0
+	d.trackTunedVolume(testVolumeID, volumeContext, "/dev/sdc")
+	_, ok := d.tunedVolumes.Load(testVolumeID)
+	assert.False(t, ok)
+}
+
+func TestRebuildTunedVolumes(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.setPerfOptimizationEnabled(true)
+	d.deviceSettingsReconcileSeconds = 60
+
+	stagedPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv1"},
+		Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{
+			Driver: d.Name, VolumeHandle: testVolumeID, VolumeAttributes: map[string]string{"perfProfile": "Basic"},
+		}}},
+	}
+	unstagedPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv2"},
+		Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{
+			Driver: d.Name, VolumeHandle: testVolumeID + "-2",
+		}}},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, indexer.Add(stagedPV))
+	require.NoError(t, indexer.Add(unstagedPV))
+	d.pvLister = corelisters.NewPersistentVolumeLister(indexer)
+
+	// the fake mounter only reports the paths with false_is_likely as mount points
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{
+		{Device: "/dev/sdc", Path: fmt.Sprintf("/false_is_likely/plugins/kubernetes.io/csi/%s/%x/globalmount", d.Name, sha256.Sum256([]byte(testVolumeID)))},
+		{Device: "/dev/sdc", Path: "/false_is_likely/pods/uid/volumes/kubernetes.io~csi/pv1/mount"},
+		// unmounted after the mount table was listed
+		{Device: "/dev/sdd", Path: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv2/globalmount"},
+	}
+	d.setNextCommandOutputScripts(func() ([]byte, []byte, error) { return []byte("/dev/sdc\n"), []byte{}, nil })
+	d.rebuildTunedVolumes()
+
+	value, ok := d.tunedVolumes.Load(testVolumeID)
+	require.True(t, ok)
+	volume := value.(tunedVolume)
+	assert.Equal(t, "pv1", volume.pvName)
+	assert.Equal(t, "/dev/sdc", volume.devicePath)
+	assert.Equal(t, "perfprofile=Basic", volume.applied)
+	_, ok = d.tunedVolumes.Load(testVolumeID + "-2")
+	assert.False(t, ok)
+}
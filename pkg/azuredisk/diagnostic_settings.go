@@ -0,0 +1,158 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	provider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+const diagnosticSettingsAPIVersion = "2021-05-01-preview"
+
+// diagnosticSettingsClient manages the Azure Monitor diagnostic settings of resources
+type diagnosticSettingsClient interface {
+	// CreateOrUpdate exports all metrics of the resource to the Log Analytics workspace by the diagnostic setting of name
+	CreateOrUpdate(ctx context.Context, resourceID, name, workspaceID string) error
+	// Delete deletes the diagnostic setting of name of the resource, a diagnostic setting not found is not an error
+	Delete(ctx context.Context, resourceID, name string) error
+}
+
+// armDiagnosticSettingsClient calls the Microsoft.Insights/diagnosticSettings REST API,
+// the monitor SDK is not a dependency of the driver
+type armDiagnosticSettingsClient struct {
+	client *arm.Client
+}
+
+func newARMDiagnosticSettingsClient(cloud *provider.Cloud) (diagnosticSettingsClient, error) {
+	if cloud == nil || cloud.AuthProvider == nil {
+		return nil, fmt.Errorf("azure credential is not initialized")
+	}
+	clientOption, err := azclient.GetAzCoreClientOption(&cloud.ARMClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	cred := cloud.AuthProvider.GetAzIdentity()
+	if cloud.AuthProvider.IsMultiTenantModeEnabled() {
+		cred = cloud.AuthProvider.GetMultiTenantIdentity()
+	}
+	client, err := arm.NewClient("azuredisk-csi-driver.diagnosticsettings", "v1.0.0", cred, &arm.ClientOptions{ClientOptions: *clientOption})
+	if err != nil {
+		return nil, err
+	}
+	return &armDiagnosticSettingsClient{client: client}, nil
+}
+
+func (c *armDiagnosticSettingsClient) newRequest(ctx context.Context, method, resourceID, name string) (*policy.Request, error) {
+	req, err := runtime.NewRequest(ctx, method, runtime.JoinPaths(c.client.Endpoint(), resourceID, "providers/Microsoft.Insights/diagnosticSettings", url.PathEscape(name)))
+	if err != nil {
+		return nil, err
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", diagnosticSettingsAPIVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+	return req, nil
+}
+
+func (c *armDiagnosticSettingsClient) CreateOrUpdate(ctx context.Context, resourceID, name, workspaceID string) error {
+	req, err := c.newRequest(ctx, http.MethodPut, resourceID, name)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"workspaceId": workspaceID,
+			// disks have no log categories
+			"metrics": []map[string]interface{}{{"category": "AllMetrics", "enabled": true}},
+		},
+	}
+	if err := runtime.MarshalAsJSON(req, body); err != nil {
+		return err
+	}
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusCreated) {
+		return runtime.NewResponseError(resp)
+	}
+	return nil
+}
+
+func (c *armDiagnosticSettingsClient) Delete(ctx context.Context, resourceID, name string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, resourceID, name)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusNoContent, http.StatusNotFound) {
+		return runtime.NewResponseError(resp)
+	}
+	return nil
+}
+
+// getDiagnosticSettingsClient returns the diagnostic settings client using the credential of the cloud
+func (c *controllerCommon) getDiagnosticSettingsClient() (diagnosticSettingsClient, error) {
+	if c.diagnosticSettingsClient != nil {
+		return c.diagnosticSettingsClient, nil
+	}
+	return newARMDiagnosticSettingsClient(c.getCloud())
+}
+
+// CreateDiagnosticSetting exports the metrics of the disk to the Log Analytics workspace of workspaceID
+func (c *ManagedDiskController) CreateDiagnosticSetting(ctx context.Context, diskURI, workspaceID string) error {
+	client, err := c.getDiagnosticSettingsClient()
+	if err != nil {
+		return err
+	}
+	if err := client.CreateOrUpdate(ctx, diskURI, consts.DiagnosticSettingName, workspaceID); err != nil {
+		return err
+	}
+	klog.V(2).Infof("azureDisk - created diagnostic setting %s of disk(%s) exporting metrics to %s", consts.DiagnosticSettingName, diskURI, workspaceID)
+	return nil
+}
+
+// deleteDiagnosticSetting deletes the diagnostic setting created with the disk, recorded by the diagnostic setting tag
+func (c *ManagedDiskController) deleteDiagnosticSetting(ctx context.Context, diskURI string, tags map[string]*string) error {
+	name, ok := tags[consts.DiagnosticSettingTag]
+	if !ok || name == nil || *name == "" {
+		return nil
+	}
+	client, err := c.getDiagnosticSettingsClient()
+	if err != nil {
+		return err
+	}
+	if err := client.Delete(ctx, diskURI, *name); err != nil {
+		return fmt.Errorf("failed to delete diagnostic setting %s of disk(%s): %w", *name, diskURI, err)
+	}
+	klog.V(2).Infof("azureDisk - deleted diagnostic setting %s of disk(%s)", *name, diskURI)
+	return nil
+}
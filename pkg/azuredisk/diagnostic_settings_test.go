@@ -0,0 +1,140 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+type fakeTokenCredential struct{}
+
+func (fakeTokenCredential) GetToken(_ context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+type fakeDiagnosticSettingsClient struct {
+	settings  map[string]string
+	deleteErr error
+}
+
+func (c *fakeDiagnosticSettingsClient) CreateOrUpdate(_ context.Context, resourceID, name, workspaceID string) error {
+	c.settings[resourceID+"/"+name] = workspaceID
+	return nil
+}
+
+func (c *fakeDiagnosticSettingsClient) Delete(_ context.Context, resourceID, name string) error {
+	if c.deleteErr != nil {
+		return c.deleteErr
+	}
+	delete(c.settings, resourceID+"/"+name)
+	return nil
+}
+
+func TestARMDiagnosticSettingsClient(t *testing.T) {
+	diskURI := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk1"
+	workspaceID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws"
+	deleteStatus := http.StatusNotFound
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, diskURI+"/providers/Microsoft.Insights/diagnosticSettings/"+consts.DiagnosticSettingName, r.URL.Path)
+		assert.Equal(t, diagnosticSettingsAPIVersion, r.URL.Query().Get("api-version"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		switch r.Method {
+		case http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			setting := map[string]map[string]interface{}{}
+			assert.NoError(t, json.Unmarshal(body, &setting))
+			assert.Equal(t, workspaceID, setting["properties"]["workspaceId"])
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			w.WriteHeader(deleteStatus)
+		}
+	}))
+	defer server.Close()
+
+	client, err := arm.NewClient("test", "v1.0.0", fakeTokenCredential{}, &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+		Cloud: cloud.Configuration{Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {Endpoint: server.URL, Audience: "https://management.azure.com"},
+		}},
+		Transport: server.Client(),
+		Retry:     policy.RetryOptions{MaxRetries: -1},
+	}})
+	require.NoError(t, err)
+	c := &armDiagnosticSettingsClient{client: client}
+	ctx := context.Background()
+
+	assert.NoError(t, c.CreateOrUpdate(ctx, diskURI, consts.DiagnosticSettingName, workspaceID))
+	assert.NoError(t, c.Delete(ctx, diskURI, consts.DiagnosticSettingName), "setting not found is deleted")
+	deleteStatus = http.StatusForbidden
+	assert.Error(t, c.Delete(ctx, diskURI, consts.DiagnosticSettingName))
+}
+
+func TestDeleteManagedDiskWithDiagnosticSetting(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	testCloud := provider.GetTestCloud(ctrl)
+	diagnosticSettings := &fakeDiagnosticSettingsClient{settings: map[string]string{}}
+	managedDiskController := &ManagedDiskController{&controllerCommon{
+		cloud:                    testCloud,
+		lockMap:                  newLockMap(),
+		clientFactory:            testCloud.ComputeClientFactory,
+		diagnosticSettingsClient: diagnosticSettings,
+	}}
+	diskURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/%s", testCloud.SubscriptionID, testCloud.ResourceGroup, disk1Name)
+	assert.NoError(t, managedDiskController.CreateDiagnosticSetting(ctx, diskURI, "workspace"))
+	assert.Equal(t, map[string]string{diskURI + "/" + consts.DiagnosticSettingName: "workspace"}, diagnosticSettings.settings)
+
+	mockDisksClient := mock_diskclient.NewMockInterface(ctrl)
+	testCloud.ComputeClientFactory.(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(testCloud.SubscriptionID).Return(mockDisksClient, nil).AnyTimes()
+	mockDisksClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, disk1Name).Return(&armcompute.Disk{
+		Name: ptr.To(disk1Name),
+		Tags: map[string]*string{consts.DiagnosticSettingTag: ptr.To(consts.DiagnosticSettingName)},
+	}, nil).Times(2)
+
+	// the disk is kept if its diagnostic setting could not be deleted
+	diagnosticSettings.deleteErr = fmt.Errorf("forbidden")
+	assert.Error(t, managedDiskController.DeleteManagedDisk(ctx, diskURI))
+
+	diagnosticSettings.deleteErr = nil
+	mockDisksClient.EXPECT().Delete(gomock.Any(), testCloud.ResourceGroup, disk1Name).Return(nil).Times(1)
+	assert.NoError(t, managedDiskController.DeleteManagedDisk(ctx, diskURI))
+	assert.Empty(t, diagnosticSettings.settings)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+// getAdoptedDiskTags returns the normalized tags of a pre-provisioned disk and the list of changes, pv is nil if
+// the PV of the disk is unknown. The driver tag and the missing kubernetes-created-for tags are set, the tags matching
+// the cleanup prefixes are removed and the caching mode of shared disks is set to None since host caching is not
+// supported on disks with maxShares > 1.
+func (d *Driver) getAdoptedDiskTags(disk *armcompute.Disk, pv *v1.PersistentVolume, volumeContext map[string]string) (map[string]*string, []string) {
+	tags := make(map[string]*string, len(disk.Tags)+4)
+	changes := []string{}
+	for k, v := range disk.Tags {
+		removed := false
+		for _, prefix := range d.adoptedDiskTagCleanupPrefixes {
+			if strings.HasPrefix(strings.ToLower(k), strings.ToLower(prefix)) {
+				removed = true
+				break
+			}
+		}
+		if removed {
+			changes = append(changes, fmt.Sprintf("removed tag %s", k))
+			continue
+		}
+		tags[k] = v
+	}
+
+	setMissingTag := func(key, value string) {
+		if value == "" || ptr.Deref(tags[key], "") != "" {
+			return
+		}
+		tags[key] = ptr.To(value)
+		changes = append(changes, fmt.Sprintf("set tag %s=%s", key, value))
+	}
+	setMissingTag(azureconsts.CreatedByTag, consts.AzureDiskDriverTag)
+	if pv != nil {
+		setMissingTag(consts.PvNameTag, pv.Name)
+		if pv.Spec.ClaimRef != nil {
+			setMissingTag(consts.PvcNameTag, pv.Spec.ClaimRef.Name)
+			setMissingTag(consts.PvcNamespaceTag, pv.Spec.ClaimRef.Namespace)
+		}
+	}
+
+	if disk.Properties != nil && ptr.Deref(disk.Properties.MaxShares, 0) > 1 {
+		cachingMode, err := azureutils.GetCachingMode(volumeContext)
+		if tag, ok := tags[CachingModeTag]; ok && tag != nil {
+			cachingMode, err = armcompute.CachingTypes(*tag), nil
+		}
+		if err != nil || !strings.EqualFold(string(cachingMode), string(armcompute.CachingTypesNone)) {
+			tags[CachingModeTag] = ptr.To(string(armcompute.CachingTypesNone))
+			changes = append(changes, fmt.Sprintf("set tag %s=%s on shared disk", CachingModeTag, armcompute.CachingTypesNone))
+		}
+	}
+	sort.Strings(changes)
+	return tags, changes
+}
+
+// normalizeAdoptedDisk normalizes the tags of a pre-provisioned disk on its first attach and records the adoption
+// in the annotations of its PV, the disk with the updated tags is returned. Disks whose PV is already annotated
+// are skipped.
+func (d *Driver) normalizeAdoptedDisk(ctx context.Context, diskURI string, disk *armcompute.Disk, volumeContext map[string]string) (*armcompute.Disk, error) {
+	var pv *v1.PersistentVolume
+	if pvName := getPVNameForDisk(volumeContext, disk); pvName != "" && d.kubeClient != nil {
+		var err error
+		if pv, err = d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{}); err != nil {
+			return disk, fmt.Errorf("get pv(%s) failed with %w", pvName, err)
+		}
+		if _, ok := pv.Annotations[consts.DiskAdoptedAtAnnotation]; ok {
+			return disk, nil
+		}
+	}
+
+	tags, changes := d.getAdoptedDiskTags(disk, pv, volumeContext)
+	if len(changes) > 0 {
+		diskName, err := azureutils.GetDiskName(diskURI)
+		if err != nil {
+			return disk, err
+		}
+		resourceGroup, subsID, err := getInfoFromDiskURI(diskURI)
+		if err != nil {
+			return disk, err
+		}
+		diskClient, err := d.getClientFactory().GetDiskClientForSub(subsID)
+		if err != nil {
+			return disk, err
+		}
+		// tags of DiskUpdate replace all the existing tags of the disk
+		updated, err := diskClient.Patch(ctx, resourceGroup, diskName, armcompute.DiskUpdate{Tags: tags})
+		if err != nil {
+			return disk, fmt.Errorf("update tags of disk(%s) failed with %w", diskURI, err)
+		}
+		klog.V(2).Infof("normalized adopted disk(%s): %s", diskURI, strings.Join(changes, "; "))
+		if updated != nil {
+			disk = updated
+		} else {
+			disk.Tags = tags
+		}
+	}
+
+	if pv == nil {
+		return disk, nil
+	}
+	adoptionChanges := strings.Join(changes, "; ")
+	if adoptionChanges == "" {
+		adoptionChanges = "none"
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]string{
+			consts.DiskAdoptedAtAnnotation:       time.Now().UTC().Format(time.RFC3339),
+			consts.DiskAdoptionChangesAnnotation: adoptionChanges,
+		}},
+	})
+	if err != nil {
+		return disk, err
+	}
+	if _, err := d.kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, k8stypes.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return disk, fmt.Errorf("patch pv(%s) failed with %w", pv.Name, err)
+	}
+	return disk, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func TestGetAdoptedDiskTags(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.adoptedDiskTagCleanupPrefixes = []string{"test-", "debug-"}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+		Spec:       v1.PersistentVolumeSpec{ClaimRef: &v1.ObjectReference{Name: "pvc", Namespace: "default"}},
+	}
+
+	disk := &armcompute.Disk{
+		Tags: map[string]*string{
+			"Test-Owner":     ptr.To("alice"),
+			"debug-run":      ptr.To("1"),
+			"team":           ptr.To("storage"),
+			consts.PvNameTag: ptr.To("original-pv"),
+		},
+		Properties: &armcompute.DiskProperties{MaxShares: ptr.To(int32(2))},
+	}
+	tags, changes := d.getAdoptedDiskTags(disk, pv, map[string]string{consts.CachingModeField: "ReadOnly"})
+	assert.Equal(t, map[string]*string{
+		"team":                   ptr.To("storage"),
+		consts.PvNameTag:         ptr.To("original-pv"),
+		consts.PvcNameTag:        ptr.To("pvc"),
+		consts.PvcNamespaceTag:   ptr.To("default"),
+		azureconsts.CreatedByTag: ptr.To(consts.AzureDiskDriverTag),
+		CachingModeTag:           ptr.To("None"),
+	}, tags)
+	assert.Len(t, changes, 6)
+	assert.Contains(t, changes, "removed tag Test-Owner")
+
+	// a normalized disk is not changed
+	disk.Tags = tags
+	_, changes = d.getAdoptedDiskTags(disk, pv, map[string]string{consts.CachingModeField: "ReadOnly"})
+	assert.Empty(t, changes)
+}
+
+func TestNormalizeAdoptedDisk(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+
+	pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv"}}
+	_, err = d.kubeClient.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{})
+	require.NoError(t, err)
+	volumeContext := map[string]string{consts.PvNameKey: "pv"}
+
+	diskClient.EXPECT().Patch(gomock.Any(), "rg", testVolumeName, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ string, update armcompute.DiskUpdate) (*armcompute.Disk, error) {
+			assert.Equal(t, consts.AzureDiskDriverTag, ptr.Deref(update.Tags[azureconsts.CreatedByTag], ""))
+			assert.Equal(t, "pv", ptr.Deref(update.Tags[consts.PvNameTag], ""))
+			return &armcompute.Disk{Name: ptr.To(testVolumeName), Tags: update.Tags}, nil
+		})
+	disk, err := d.normalizeAdoptedDisk(ctx, testVolumeID, &armcompute.Disk{Name: ptr.To(testVolumeName)}, volumeContext)
+	require.NoError(t, err)
+	assert.Equal(t, consts.AzureDiskDriverTag, ptr.Deref(disk.Tags[azureconsts.CreatedByTag], ""))
+
+	pv, err = d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, pv.Annotations[consts.DiskAdoptedAtAnnotation])
+	assert.Contains(t, pv.Annotations[consts.DiskAdoptionChangesAnnotation], "set tag "+consts.PvNameTag+"=pv")
+
+	// the disk is not normalized again once the adoption is recorded
+	_, err = d.normalizeAdoptedDisk(ctx, testVolumeID, &armcompute.Disk{Name: ptr.To(testVolumeName)}, volumeContext)
+	assert.NoError(t, err)
+}
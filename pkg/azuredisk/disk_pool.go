@@ -0,0 +1,371 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+const (
+	diskPoolReplenishedReason     = "DiskPoolReplenished"
+	diskPoolReplenishFailedReason = "DiskPoolReplenishFailed"
+
+	azDiskPoolKind = "AzDiskPool"
+
+	// diskPoolTag is the tag of the disks created for a pool with the name of the AzDiskPool
+	diskPoolTag = "k8s-azure-disk-pool"
+	// diskPoolClaimTag is the tag of a claimed pool disk with the name of the CreateVolume request, so that a retried
+	// request gets the disk it already claimed
+	diskPoolClaimTag = "k8s-azure-disk-pool-claim"
+	// maxDiskPoolCreatesPerRound limits the disks created for a pool in one round of the replenisher
+	maxDiskPoolCreatesPerRound = 10
+)
+
+// azDiskPoolResource is the resource of the cluster scoped AzDiskPool custom resource, which keeps a number of
+// detached disks of a SKU, size and zone, so that CreateVolume could claim a disk instead of creating one
+var azDiskPoolResource = schema.GroupVersionResource{Group: "disk.csi.azure.com", Version: "v1alpha1", Resource: "azdiskpools"}
+
+// diskPoolSpec is the disks kept in the pool
+type diskPoolSpec struct {
+	SkuName string `json:"skuName"`
+	SizeGiB int    `json:"sizeGiB"`
+	// Zone is the availability zone of the disks, e.g. eastus-1, the disks are not zonal if not set
+	Zone string `json:"zone,omitempty"`
+	// ResourceGroup is the resource group of the disks, the resource group of the cluster if not set
+	ResourceGroup string `json:"resourceGroup,omitempty"`
+	// Size is the number of available disks kept in the pool
+	Size int `json:"size"`
+}
+
+// diskPoolStatus is the number of the disks of the pool
+type diskPoolStatus struct {
+	Available int    `json:"available"`
+	Claimed   int    `json:"claimed"`
+	Message   string `json:"message,omitempty"`
+}
+
+// azDiskPool is an AzDiskPool custom resource
+type azDiskPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   diskPoolSpec   `json:"spec"`
+	Status diskPoolStatus `json:"status,omitempty"`
+}
+
+// runDiskPoolReplenisher creates the missing available disks of the AzDiskPools every interval
+func (d *Driver) runDiskPoolReplenisher(ctx context.Context, interval time.Duration) {
+	klog.V(2).Infof("replenishing disks of %s every %v", azDiskPoolResource.Resource, interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := d.replenishDiskPools(ctx); err != nil {
+			klog.Errorf("failed to replenish disk pools: %v", err)
+		}
+	}, interval)
+}
+
+// replenishDiskPools replenishes all AzDiskPools, the status is only updated if changed
+func (d *Driver) replenishDiskPools(ctx context.Context) error {
+	list, err := d.dynamicClient.Resource(azDiskPoolResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", azDiskPoolResource.Resource, err)
+	}
+	for i := range list.Items {
+		pool := &azDiskPool{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, pool); err != nil {
+			klog.Errorf("failed to convert %s %s: %v", azDiskPoolKind, list.Items[i].GetName(), err)
+			continue
+		}
+		original := pool.Status
+		d.replenishDiskPool(ctx, pool)
+		if !reflect.DeepEqual(pool.Status, original) {
+			if err := d.updateDiskPoolStatus(ctx, pool); err != nil {
+				klog.Errorf("failed to update status of %s %s: %v", azDiskPoolKind, pool.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// replenishDiskPool creates the disks missing from the available disks of pool, at most maxDiskPoolCreatesPerRound
+// disks are created in a round. The available disks over the size of the pool are kept for later claims.
+func (d *Driver) replenishDiskPool(ctx context.Context, pool *azDiskPool) {
+	pool.Status.Message = ""
+	skuName, err := d.validateDiskPool(pool)
+	if err != nil {
+		pool.Status.Message = err.Error()
+		return
+	}
+	available, claimed, err := d.listDiskPoolDisks(ctx, pool)
+	if err != nil {
+		pool.Status.Message = fmt.Sprintf("failed to list disks: %v", err)
+		return
+	}
+	pool.Status.Available, pool.Status.Claimed = len(available), len(claimed)
+
+	missing := min(pool.Spec.Size-len(available), maxDiskPoolCreatesPerRound)
+	if missing <= 0 {
+		return
+	}
+	ref := getDiskPoolReference(pool)
+	clusterID := d.getDiskOwnerClusterID(ctx, pool.Name)
+	created := 0
+	for ; created < missing; created++ {
+		options := &ManagedDiskOptions{
+			AvailabilityZone:   pool.Spec.Zone,
+			DiskName:           fmt.Sprintf("azdp-%s-%d", pool.UID, time.Now().UnixNano()),
+			Location:           d.getDiskPoolLocation(pool),
+			ResourceGroup:      d.getDiskPoolResourceGroup(pool),
+			SizeGB:             pool.Spec.SizeGiB,
+			StorageAccountType: skuName,
+			Tags:               map[string]string{diskPoolTag: pool.Name},
+			ClusterID:          clusterID,
+		}
+		if _, err := d.getDiskController().CreateManagedDisk(ctx, options); err != nil {
+			pool.Status.Message = fmt.Sprintf("failed to create disk %s: %v", options.DiskName, err)
+			d.recordEvent(ref, v1.EventTypeWarning, diskPoolReplenishFailedReason, "%s", pool.Status.Message)
+			break
+		}
+		pool.Status.Available++
+	}
+	if created > 0 {
+		klog.V(2).Infof("created %d disks of %s %s", created, azDiskPoolKind, pool.Name)
+		d.recordEvent(ref, v1.EventTypeNormal, diskPoolReplenishedReason, "created %d disks, %d disks are available", created, pool.Status.Available)
+	}
+}
+
+// validateDiskPool checks the spec of pool and returns the normalized SKU of its disks
+func (d *Driver) validateDiskPool(pool *azDiskPool) (armcompute.DiskStorageAccountTypes, error) {
+	skuName, err := azureutils.NormalizeStorageAccountType(pool.Spec.SkuName, d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud)
+	if err != nil {
+		return "", err
+	}
+	if pool.Spec.SizeGiB < consts.MinimumDiskSizeGiB {
+		return "", fmt.Errorf("sizeGiB must be at least %d", consts.MinimumDiskSizeGiB)
+	}
+	if pool.Spec.Size < 0 {
+		return "", fmt.Errorf("size must not be negative")
+	}
+	if pool.Spec.Zone != "" && strings.HasSuffix(strings.ToLower(string(skuName)), "zrs") {
+		return "", fmt.Errorf("zone must not be set for zone redundant sku %s", skuName)
+	}
+	return skuName, nil
+}
+
+// listDiskPoolDisks returns the available disks of pool sorted by name and the claimed disks keyed by the name of
+// the request which claimed them. The disks being created or attached are neither available nor claimed.
+func (d *DriverCore) listDiskPoolDisks(ctx context.Context, pool *azDiskPool) ([]*armcompute.Disk, map[string]*armcompute.Disk, error) {
+	diskClient, err := d.getClientFactory().GetDiskClientForSub(d.getCloud().SubscriptionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	disks, err := diskClient.List(ctx, d.getDiskPoolResourceGroup(pool))
+	if err != nil {
+		return nil, nil, err
+	}
+	var available []*armcompute.Disk
+	claimed := map[string]*armcompute.Disk{}
+	for _, disk := range disks {
+		if disk == nil || disk.ID == nil || ptr.Deref(disk.Tags[diskPoolTag], "") != pool.Name {
+			continue
+		}
+		if claim := ptr.Deref(disk.Tags[diskPoolClaimTag], ""); claim != "" {
+			claimed[claim] = disk
+			continue
+		}
+		if disk.ManagedBy != nil || disk.Properties == nil || !strings.EqualFold(ptr.Deref(disk.Properties.ProvisioningState, ""), "Succeeded") {
+			continue
+		}
+		available = append(available, disk)
+	}
+	sort.Slice(available, func(i, j int) bool { return *available[i].ID < *available[j].ID })
+	return available, claimed, nil
+}
+
+// claimPoolDisk claims an available disk of the first AzDiskPool in the comma separated diskPool parameter which
+// matches the SKU, size, zone, location and resource group of the volume named name, e.g. one pool per zone. An empty
+// disk URI is returned if no pool matches the volume or the matching pool has no available disk, then the disk is
+// created as usual.
+func (d *Driver) claimPoolDisk(ctx context.Context, name string, skuName armcompute.DiskStorageAccountTypes, requestGiB int, volumeZone string, diskParams *azureutils.ManagedDiskParameters) (string, error) {
+	if d.dynamicClient == nil {
+		return "", status.Errorf(codes.InvalidArgument, "%s is set but disk pools are disabled in the driver", consts.DiskPoolField)
+	}
+	if param := getUnsupportedDiskPoolParameter(diskParams, d.isClusterSubscription(diskParams.SubscriptionID)); param != "" {
+		return "", status.Errorf(codes.InvalidArgument, "%s could not be used with %s", param, consts.DiskPoolField)
+	}
+	for _, poolName := range strings.Split(diskParams.DiskPool, ",") {
+		if poolName = strings.TrimSpace(poolName); poolName == "" {
+			continue
+		}
+		obj, err := d.dynamicClient.Resource(azDiskPoolResource).Get(ctx, poolName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return "", status.Errorf(codes.InvalidArgument, "%s %s is not found", azDiskPoolKind, poolName)
+		}
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "failed to get %s %s: %v", azDiskPoolKind, poolName, err)
+		}
+		pool := &azDiskPool{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, pool); err != nil {
+			return "", status.Errorf(codes.Internal, "failed to convert %s %s: %v", azDiskPoolKind, poolName, err)
+		}
+		poolSkuName, err := d.validateDiskPool(pool)
+		if err != nil {
+			return "", status.Errorf(codes.InvalidArgument, "invalid %s %s: %v", azDiskPoolKind, pool.Name, err)
+		}
+		if poolSkuName == skuName && pool.Spec.SizeGiB == requestGiB && strings.EqualFold(pool.Spec.Zone, volumeZone) &&
+			strings.EqualFold(d.getDiskPoolLocation(pool), diskParams.Location) && strings.EqualFold(d.getDiskPoolResourceGroup(pool), diskParams.ResourceGroup) {
+			return d.claimDiskOfPool(ctx, pool, name, diskParams.Tags)
+		}
+	}
+	klog.V(2).Infof("no disk pool in %q matches volume %s(%s, %dGiB, zone %q), creating the disk", diskParams.DiskPool, name, skuName, requestGiB, volumeZone)
+	return "", nil
+}
+
+// claimDiskOfPool claims an available disk of pool for the volume named name, tags of the volume are merged into the
+// tags of the disk. The disk keeps the name it's created with since a managed disk could not be renamed. Claims are
+// serialized per pool in the driver, CreateVolume is only called on the leader of csi-provisioner.
+func (d *Driver) claimDiskOfPool(ctx context.Context, pool *azDiskPool, name string, volumeTags map[string]string) (string, error) {
+	d.diskPoolLocks.LockEntry(pool.Name)
+	defer d.diskPoolLocks.UnlockEntry(pool.Name)
+	available, claimed, err := d.listDiskPoolDisks(ctx, pool)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to list disks of %s %s: %v", azDiskPoolKind, pool.Name, err)
+	}
+	if disk, ok := claimed[name]; ok {
+		klog.V(2).Infof("disk(%s) of %s %s is already claimed by volume %s", *disk.ID, azDiskPoolKind, pool.Name, name)
+		return *disk.ID, nil
+	}
+	if len(available) == 0 {
+		klog.Warningf("no disk of %s %s is available for volume %s, creating the disk", azDiskPoolKind, pool.Name, name)
+		return "", nil
+	}
+	diskURI := *available[0].ID
+	tags := map[string]string{diskPoolClaimTag: name}
+	for k, v := range volumeTags {
+		tags[k] = v
+	}
+	tagsClient, err := d.getResourceTagsClient()
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "%v", err)
+	}
+	if err := tagsClient.MergeTags(ctx, diskURI, tags); err != nil {
+		return "", status.Errorf(codes.Internal, "failed to claim disk(%s) of %s %s: %v", diskURI, azDiskPoolKind, pool.Name, err)
+	}
+	klog.V(2).Infof("volume %s claimed disk(%s) of %s %s", name, diskURI, azDiskPoolKind, pool.Name)
+	return diskURI, nil
+}
+
+// getUnsupportedDiskPoolParameter returns the first parameter set on a volume which the disks of a pool are not
+// created with, the disks of the pools only have the SKU, size and zone of the pools and are in the cluster subscription
+func getUnsupportedDiskPoolParameter(diskParams *azureutils.ManagedDiskParameters, inClusterSubscription bool) string {
+	switch {
+	case !inClusterSubscription:
+		return consts.SubscriptionIDField
+	case diskParams.DiskEncryptionSetID != "":
+		return consts.DesIDField
+	case diskParams.DiskEncryptionType != "":
+		return consts.DiskEncryptionTypeField
+	case diskParams.DiskIOPSReadWrite != "":
+		return consts.DiskIOPSReadWriteField
+	case diskParams.DiskMBPSReadWrite != "":
+		return consts.DiskMBPSReadWriteField
+	case diskParams.LogicalSectorSize != 0:
+		return consts.LogicalSectorSizeField
+	case diskParams.MaxShares > 1:
+		return consts.MaxSharesField
+	case diskParams.NetworkAccessPolicy != "":
+		return consts.NetworkAccessPolicyField
+	case diskParams.PublicNetworkAccess != "":
+		return consts.PublicNetworkAccessField
+	case diskParams.DiskAccessID != "":
+		return consts.DiskAccessIDField
+	case diskParams.EnableBursting != nil:
+		return consts.EnableBurstingField
+	case diskParams.PerformancePlus != nil:
+		return consts.PerformancePlusField
+	case diskParams.SupportsHibernation != nil:
+		return consts.SupportsHibernationField
+	case diskParams.OptimizedForFrequentAttach != nil:
+		return consts.OptimizedForFrequentAttachField
+	case diskParams.SecurityType != "":
+		return consts.SecurityTypeField
+	}
+	return ""
+}
+
+// getDiskPoolLocation returns the region of the zone of pool, the region of the cluster if the pool is not zonal
+func (d *DriverCore) getDiskPoolLocation(pool *azDiskPool) string {
+	if region := azureutils.GetRegionFromAvailabilityZone(pool.Spec.Zone); region != "" {
+		return region
+	}
+	return d.getCloud().Location
+}
+
+func (d *DriverCore) getDiskPoolResourceGroup(pool *azDiskPool) string {
+	if pool.Spec.ResourceGroup != "" {
+		return pool.Spec.ResourceGroup
+	}
+	return d.getCloud().ResourceGroup
+}
+
+func (d *Driver) updateDiskPoolStatus(ctx context.Context, pool *azDiskPool) error {
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&pool.Status)
+	if err != nil {
+		return err
+	}
+	obj, err := d.dynamicClient.Resource(azDiskPoolResource).Get(ctx, pool.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if obj.GetUID() != pool.UID {
+		return nil
+	}
+	if err := unstructured.SetNestedMap(obj.Object, status, "status"); err != nil {
+		return err
+	}
+	_, err = d.dynamicClient.Resource(azDiskPoolResource).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+func getDiskPoolReference(pool *azDiskPool) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: azDiskPoolResource.GroupVersion().String(),
+		Kind:       azDiskPoolKind,
+		Name:       pool.Name,
+		UID:        pool.UID,
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+func newTestPoolDisk(d *fakeDriverV1, name string, tags map[string]string) *armcompute.Disk {
+	disk := &armcompute.Disk{
+		ID:         ptr.To(fmt.Sprintf(consts.ManagedDiskPath, d.getCloud().SubscriptionID, d.getCloud().ResourceGroup, name)),
+		Name:       ptr.To(name),
+		Tags:       map[string]*string{},
+		Properties: &armcompute.DiskProperties{ProvisioningState: ptr.To("Succeeded")},
+	}
+	for k, v := range tags {
+		disk.Tags[k] = ptr.To(v)
+	}
+	return disk
+}
+
+func newTestDiskPool(name, zone string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "disk.csi.azure.com/v1alpha1",
+		"kind":       azDiskPoolKind,
+		"metadata":   map[string]interface{}{"name": name, "uid": name + "-uid"},
+		"spec":       map[string]interface{}{"skuName": "Premium_LRS", "sizeGiB": int64(10), "zone": zone, "size": int64(2)},
+	}}
+}
+
+func newTestDiskPoolDriver(t *testing.T, cntl *gomock.Controller) (*fakeDriverV1, *mock_diskclient.MockInterface) {
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{azDiskPoolResource: "AzDiskPoolList"},
+		newTestDiskPool("pool", ""))
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	return d, diskClient
+}
+
+func TestClaimPoolDisk(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, diskClient := newTestDiskPoolDriver(t, cntl)
+	ctx := context.Background()
+	tagsClient := &fakeResourceTagsClient{}
+	d.resourceTagsClient = tagsClient
+
+	available := newTestPoolDisk(d, "available", map[string]string{diskPoolTag: "pool"})
+	claimed := newTestPoolDisk(d, "claimed", map[string]string{diskPoolTag: "pool", diskPoolClaimTag: "pvc-claimed"})
+	attached := newTestPoolDisk(d, "attached", map[string]string{diskPoolTag: "pool"})
+	attached.ManagedBy = ptr.To("vm")
+	other := newTestPoolDisk(d, "other", map[string]string{diskPoolTag: "other-pool"})
+	diskClient.EXPECT().List(gomock.Any(), d.getCloud().ResourceGroup).Return([]*armcompute.Disk{attached, claimed, other, available}, nil).AnyTimes()
+
+	newDiskParams := func() *azureutils.ManagedDiskParameters {
+		return &azureutils.ManagedDiskParameters{
+			DiskPool:      "pool",
+			Location:      d.getCloud().Location,
+			ResourceGroup: d.getCloud().ResourceGroup,
+			Tags:          map[string]string{consts.PvNameTag: "pv"},
+		}
+	}
+
+	// the available disk is claimed with the tags of the volume
+	diskURI, err := d.claimPoolDisk(ctx, "pvc-new", armcompute.DiskStorageAccountTypesPremiumLRS, 10, "", newDiskParams())
+	require.NoError(t, err)
+	assert.Equal(t, *available.ID, diskURI)
+	assert.Equal(t, map[string]map[string]string{*available.ID: {diskPoolClaimTag: "pvc-new", consts.PvNameTag: "pv"}}, tagsClient.merged)
+
+	// a retried request gets the disk it already claimed
+	tagsClient.merged = nil
+	diskURI, err = d.claimPoolDisk(ctx, "pvc-claimed", armcompute.DiskStorageAccountTypesPremiumLRS, 10, "", newDiskParams())
+	require.NoError(t, err)
+	assert.Equal(t, *claimed.ID, diskURI)
+	assert.Empty(t, tagsClient.merged)
+
+	// the first matching pool is used
+	diskParams := newDiskParams()
+	diskParams.DiskPool = "pool-eastus-1, pool"
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{azDiskPoolResource: "AzDiskPoolList"},
+		newTestDiskPool("pool", ""), newTestDiskPool("pool-eastus-1", "eastus-1"))
+	diskURI, err = d.claimPoolDisk(ctx, "pvc-claimed", armcompute.DiskStorageAccountTypesPremiumLRS, 10, "", diskParams)
+	require.NoError(t, err)
+	assert.Equal(t, *claimed.ID, diskURI)
+
+	// the disk is created if the pool does not match the volume
+	diskURI, err = d.claimPoolDisk(ctx, "pvc-new", armcompute.DiskStorageAccountTypesPremiumLRS, 20, "", newDiskParams())
+	require.NoError(t, err)
+	assert.Empty(t, diskURI)
+	diskURI, err = d.claimPoolDisk(ctx, "pvc-new", armcompute.DiskStorageAccountTypesPremiumLRS, 10, d.getCloud().Location+"-1", newDiskParams())
+	require.NoError(t, err)
+	assert.Empty(t, diskURI)
+	assert.Empty(t, tagsClient.merged)
+
+	diskParams = newDiskParams()
+	diskParams.DiskEncryptionSetID = "des"
+	_, err = d.claimPoolDisk(ctx, "pvc-new", armcompute.DiskStorageAccountTypesPremiumLRS, 10, "", diskParams)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	diskParams = newDiskParams()
+	diskParams.DiskPool = "missing"
+	_, err = d.claimPoolDisk(ctx, "pvc-new", armcompute.DiskStorageAccountTypesPremiumLRS, 10, "", diskParams)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestReplenishDiskPools(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, diskClient := newTestDiskPoolDriver(t, cntl)
+	ctx := context.Background()
+
+	available := newTestPoolDisk(d, "available", map[string]string{diskPoolTag: "pool"})
+	claimed := newTestPoolDisk(d, "claimed", map[string]string{diskPoolTag: "pool", diskPoolClaimTag: "pvc-claimed"})
+	diskClient.EXPECT().List(gomock.Any(), d.getCloud().ResourceGroup).Return([]*armcompute.Disk{available, claimed}, nil).Times(1)
+	diskClient.EXPECT().CreateOrUpdate(gomock.Any(), d.getCloud().ResourceGroup, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, name string, model armcompute.Disk) (*armcompute.Disk, error) {
+			assert.Regexp(t, "^azdp-pool-uid-[0-9]+$", name)
+			assert.Equal(t, "pool", ptr.Deref(model.Tags[diskPoolTag], ""))
+			assert.Equal(t, armcompute.DiskStorageAccountTypesPremiumLRS, *model.SKU.Name)
+			assert.Equal(t, int32(10), *model.Properties.DiskSizeGB)
+			return newTestPoolDisk(d, name, nil), nil
+		}).Times(1)
+	diskClient.EXPECT().Get(gomock.Any(), d.getCloud().ResourceGroup, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, name string) (*armcompute.Disk, error) {
+			return newTestPoolDisk(d, name, nil), nil
+		}).AnyTimes()
+
+	require.NoError(t, d.replenishDiskPools(ctx))
+	obj, err := d.dynamicClient.Resource(azDiskPoolResource).Get(ctx, "pool", metav1.GetOptions{})
+	require.NoError(t, err)
+	poolStatus, _, _ := unstructured.NestedMap(obj.Object, "status")
+	assert.Equal(t, map[string]interface{}{"available": int64(2), "claimed": int64(1)}, poolStatus)
+}
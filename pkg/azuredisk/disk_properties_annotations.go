@@ -0,0 +1,175 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+var (
+	// diskPropertiesAnnotationsTimeout is the maximum time of refreshing the disk properties annotations of a PV
+	diskPropertiesAnnotationsTimeout = time.Minute
+	// diskPropertiesAnnotationsWorkers is the number of disks whose PV annotations are refreshed concurrently
+	diskPropertiesAnnotationsWorkers = 2
+	// diskPropertiesAnnotationsMaxRetries is the number of retries of a failed disk before it's dropped until it's queued again
+	diskPropertiesAnnotationsMaxRetries = 10
+)
+
+// diskPropertiesItem is a disk whose properties are written into the annotations of its PV, pvName is empty if the
+// PV is not known yet, the PV in the tag of the disk is annotated then
+type diskPropertiesItem struct {
+	diskURI string
+	pvName  string
+}
+
+// startDiskPropertiesReconciler writes the realized properties of the disks into the annotations of their PVs. The PVs
+// of the driver without the annotations are queued by the PV informer, e.g. once they are created by the provisioner,
+// the disks resized or modified by the controller are queued again. diskPropertiesAnnotationsWorkers workers get the
+// disks of the queue, the failed ones are retried with backoff. The workers stop once ctx is done.
+func (d *Driver) startDiskPropertiesReconciler(ctx context.Context, informer cache.SharedIndexInformer) {
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[diskPropertiesItem]())
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { d.onDiskPropertiesPVUpdate(queue, obj) },
+		UpdateFunc: func(_, obj interface{}) { d.onDiskPropertiesPVUpdate(queue, obj) },
+	}); err != nil {
+		klog.Errorf("failed to add the event handler of the disk properties reconciler: %v", err)
+		queue.ShutDown()
+		return
+	}
+	d.diskPropertiesQueue = queue
+	for i := 0; i < diskPropertiesAnnotationsWorkers; i++ {
+		go func() {
+			for d.processNextDiskPropertiesItem(queue) {
+			}
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
+}
+
+// onDiskPropertiesPVUpdate queues the disk of a PV of the driver which has no disk properties annotations yet
+func (d *Driver) onDiskPropertiesPVUpdate(queue workqueue.TypedRateLimitingInterface[diskPropertiesItem], obj interface{}) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.Spec.CSI.VolumeHandle == "" {
+		return
+	}
+	if _, ok := pv.Annotations[consts.DiskSkuAnnotation]; ok {
+		return
+	}
+	queue.Add(diskPropertiesItem{diskURI: pv.Spec.CSI.VolumeHandle, pvName: pv.Name})
+}
+
+// queueDiskPropertiesAnnotations queues the disk to refresh the properties annotations of its PV, e.g. after the disk
+// is resized or modified, it's a no-op if the disk properties reconciler is not running
+func (d *Driver) queueDiskPropertiesAnnotations(diskURI, pvName string) {
+	if d.diskPropertiesQueue != nil {
+		d.diskPropertiesQueue.Add(diskPropertiesItem{diskURI: diskURI, pvName: pvName})
+	}
+}
+
+// processNextDiskPropertiesItem refreshes the annotations of the next disk of the queue, false is returned once the
+// queue is shut down
+func (d *Driver) processNextDiskPropertiesItem(queue workqueue.TypedRateLimitingInterface[diskPropertiesItem]) bool {
+	item, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(item)
+
+	ctx, cancel := context.WithTimeout(context.Background(), diskPropertiesAnnotationsTimeout)
+	defer cancel()
+	err := d.syncDiskPropertiesAnnotations(ctx, item)
+	switch {
+	case err == nil:
+		queue.Forget(item)
+	case queue.NumRequeues(item) < diskPropertiesAnnotationsMaxRetries:
+		klog.V(4).Infof("update disk properties annotations of disk(%s) failed with %v, will retry", item.diskURI, err)
+		queue.AddRateLimited(item)
+	default:
+		klog.Warningf("could not update disk properties annotations of disk(%s): %v", item.diskURI, err)
+		queue.Forget(item)
+	}
+	return true
+}
+
+// syncDiskPropertiesAnnotations gets the disk of item and writes its properties into the annotations of its PV,
+// deleted disks are skipped
+func (d *Driver) syncDiskPropertiesAnnotations(ctx context.Context, item diskPropertiesItem) error {
+	disk, err := d.checkDiskExists(ctx, item.diskURI)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("get disk(%s) failed with %w", item.diskURI, err)
+	}
+	if disk == nil {
+		return fmt.Errorf("get disk(%s) is throttled", item.diskURI)
+	}
+	pvName := item.pvName
+	if pvName == "" {
+		pvName = getPVNameForDisk(nil, disk)
+	}
+	return d.updateDiskPropertiesAnnotations(ctx, pvName, disk)
+}
+
+// updateDiskPropertiesAnnotations writes the realized properties of the disk into the annotations of the PV, the PV
+// is read from the informer cache and only patched when any of the properties changed
+func (d *DriverCore) updateDiskPropertiesAnnotations(ctx context.Context, pvName string, disk *armcompute.Disk) error {
+	if !d.enableDiskPropertiesAnnotations || pvName == "" || disk == nil || d.kubeClient == nil || d.pvLister == nil {
+		return nil
+	}
+	pv, err := d.pvLister.Get(pvName)
+	if err != nil {
+		return fmt.Errorf("get pv(%s) failed with %w", pvName, err)
+	}
+	changed := map[string]string{}
+	for k, v := range azureutils.GetDiskPropertiesAnnotations(disk) {
+		if pv.Annotations[k] != v {
+			changed[k] = v
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": changed},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := d.kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pvName, k8stypes.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("patch pv(%s) failed with %w", pvName, err)
+	}
+	klog.V(2).Infof("updated disk properties annotations %v on pv(%s)", changed, pvName)
+	return nil
+}
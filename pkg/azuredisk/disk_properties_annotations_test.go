@@ -0,0 +1,87 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func TestDiskPropertiesReconciler(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+	d.enableDiskPropertiesAnnotations = true
+
+	diskURI := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/disk-1"
+	disk := &armcompute.Disk{
+		SKU:        &armcompute.DiskSKU{Name: ptr.To(armcompute.DiskStorageAccountTypesPremiumLRS)},
+		Tags:       map[string]*string{consts.PvNameTag: ptr.To("pv-1")},
+		Properties: &armcompute.DiskProperties{Tier: ptr.To("P10")},
+	}
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	diskClient.EXPECT().Get(gomock.Any(), "rg", "disk-1").Return(disk, nil).AnyTimes()
+
+	newPV := func(name, driver string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: diskURI}},
+			},
+		}
+	}
+	kubeClient := fake.NewSimpleClientset(newPV("pv-1", d.Name), newPV("pv-other-driver", "other.csi.azure.com"))
+	d.kubeClient = kubeClient
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.startDiskPropertiesReconciler(ctx, d.startPVInformer(ctx))
+	getTier := func(pvName string) string {
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return pv.Annotations[consts.DiskTierAnnotation]
+	}
+
+	// the PV of the driver without annotations is annotated once it's in the informer cache
+	assert.NoError(t, wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		return getTier("pv-1") == "P10", nil
+	}))
+	assert.Empty(t, getTier("pv-other-driver"))
+
+	// the disk queued after a resize refreshes the PV in its tag
+	disk.Properties.Tier = ptr.To("P15")
+	d.queueDiskPropertiesAnnotations(diskURI, "")
+	assert.NoError(t, wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		return getTier("pv-1") == "P15", nil
+	}))
+}
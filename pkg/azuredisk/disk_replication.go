@@ -0,0 +1,465 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+const (
+	diskReplicationStartedReason   = "DiskReplicationStarted"
+	diskReplicationSucceededReason = "DiskReplicationSucceeded"
+	diskReplicationFailedReason    = "DiskReplicationFailed"
+
+	azDiskReplicationKind = "AzDiskReplication"
+
+	defaultDiskReplicationIntervalMinutes = 60
+	defaultDiskReplicationRetainedCount   = 3
+	// diskReplicationCopyTimeout is the time after which a replica which is not copied is deleted and taken again
+	diskReplicationCopyTimeout = 24 * time.Hour
+	// diskReplicationTag is the tag of the replicas with the namespace and name of their AzDiskReplication
+	diskReplicationTag = "k8s-azure-disk-replication"
+	// diskReplicationManifestsSuffix is appended to the name of an AzDiskReplication to name its ConfigMap of the
+	// manifests restoring the replicated PVCs in the DR cluster
+	diskReplicationManifestsSuffix = "-dr-manifests"
+)
+
+// azDiskReplicationResource is the resource of the namespaced AzDiskReplication custom resource, which copies
+// incremental snapshots of the disks of the selected PVCs in its namespace to another region, resource group or
+// subscription
+var azDiskReplicationResource = schema.GroupVersionResource{Group: "disk.csi.azure.com", Version: "v1alpha1", Resource: "azdiskreplications"}
+
+// replicaSnapshotter creates and deletes the snapshots copied to the destination of the replications
+type replicaSnapshotter interface {
+	CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error)
+	DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error)
+}
+
+// diskReplicationSpec is the PVCs to replicate and the destination of their snapshots
+type diskReplicationSpec struct {
+	// Selector selects the PVCs of the driver in the namespace of the replication
+	Selector *metav1.LabelSelector `json:"selector"`
+	// DestinationLocation is the region the snapshots are copied to, the region of the cluster if not set
+	DestinationLocation string `json:"destinationLocation,omitempty"`
+	// DestinationResourceGroup is the resource group of the snapshots, which must be allowed by the admin
+	DestinationResourceGroup string `json:"destinationResourceGroup"`
+	// DestinationSubscriptionID is the subscription of the snapshots, the subscription of the cluster if not set
+	DestinationSubscriptionID string `json:"destinationSubscriptionID,omitempty"`
+	// IntervalMinutes is the interval between the snapshots of a PVC
+	IntervalMinutes int64 `json:"intervalMinutes,omitempty"`
+	// RetainedCount is the number of the copied snapshots kept per PVC
+	RetainedCount int `json:"retainedCount,omitempty"`
+}
+
+// replicatedVolume is the replication state of a selected PVC
+type replicatedVolume struct {
+	PVCName  string `json:"pvcName"`
+	VolumeID string `json:"volumeID,omitempty"`
+	// PendingSnapshotName is the name of the snapshot being copied to the destination
+	PendingSnapshotName string `json:"pendingSnapshotName,omitempty"`
+	// LastSnapshotTime is the time the last snapshot was taken
+	LastSnapshotTime *metav1.Time `json:"lastSnapshotTime,omitempty"`
+	// Replicas are the IDs of the snapshots copied to the destination, the latest is the last one
+	Replicas []string `json:"replicas,omitempty"`
+	Message  string   `json:"message,omitempty"`
+}
+
+// diskReplicationStatus is the replication state of the selected PVCs
+type diskReplicationStatus struct {
+	Volumes []replicatedVolume `json:"volumes,omitempty"`
+	Message string             `json:"message,omitempty"`
+}
+
+// azDiskReplication is an AzDiskReplication custom resource
+type azDiskReplication struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   diskReplicationSpec   `json:"spec"`
+	Status diskReplicationStatus `json:"status,omitempty"`
+}
+
+// runDiskReplicator takes the snapshots of the PVCs selected by the AzDiskReplications which are due, and checks the
+// copies of the snapshots to their destinations every interval
+func (d *Driver) runDiskReplicator(ctx context.Context, interval time.Duration) {
+	klog.V(2).Infof("replicating disks of %s every %v", azDiskReplicationResource.Resource, interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := d.replicateDisks(ctx); err != nil {
+			klog.Errorf("failed to replicate disks: %v", err)
+		}
+	}, interval)
+}
+
+// replicateDisks reconciles all AzDiskReplications, the status and the DR manifests are only updated if changed
+func (d *Driver) replicateDisks(ctx context.Context) error {
+	list, err := d.dynamicClient.Resource(azDiskReplicationResource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", azDiskReplicationResource.Resource, err)
+	}
+	for i := range list.Items {
+		replication := &azDiskReplication{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, replication); err != nil {
+			klog.Errorf("failed to convert %s %s/%s: %v", azDiskReplicationKind, list.Items[i].GetNamespace(), list.Items[i].GetName(), err)
+			continue
+		}
+		original := &azDiskReplication{}
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, original)
+		manifests, err := d.reconcileDiskReplication(ctx, replication)
+		if err != nil {
+			klog.Errorf("failed to replicate disks of %s %s/%s: %v", azDiskReplicationKind, replication.Namespace, replication.Name, err)
+		} else if err := d.updateDiskReplicationManifests(ctx, replication, manifests); err != nil {
+			replication.Status.Message = fmt.Sprintf("failed to update ConfigMap %s: %v", replication.Name+diskReplicationManifestsSuffix, err)
+			klog.Errorf("failed to update DR manifests of %s %s/%s: %v", azDiskReplicationKind, replication.Namespace, replication.Name, err)
+		}
+		if !reflect.DeepEqual(replication.Status, original.Status) {
+			if err := d.updateDiskReplicationStatus(ctx, replication); err != nil {
+				klog.Errorf("failed to update status of %s %s/%s: %v", azDiskReplicationKind, replication.Namespace, replication.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// reconcileDiskReplication replicates the disks of the PVCs selected by replication and returns the manifests
+// restoring the latest replicas of the PVCs keyed by <PVC name>.yaml. The replicas of the PVCs which are no longer
+// selected are kept in the destination but removed from the status.
+func (d *Driver) reconcileDiskReplication(ctx context.Context, replication *azDiskReplication) (map[string]string, error) {
+	replication.Status.Message = ""
+	if err := d.validateDiskReplicationDestination(replication); err != nil {
+		replication.Status.Message = err.Error()
+		return nil, err
+	}
+	if replication.Spec.Selector == nil {
+		replication.Status.Message = "selector must be set"
+		return nil, fmt.Errorf("%s", replication.Status.Message)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(replication.Spec.Selector)
+	if err != nil {
+		replication.Status.Message = fmt.Sprintf("invalid selector: %v", err)
+		return nil, err
+	}
+	pvcs, err := d.kubeClient.CoreV1().PersistentVolumeClaims(replication.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		replication.Status.Message = fmt.Sprintf("failed to list PVCs: %v", err)
+		return nil, err
+	}
+	sort.Slice(pvcs.Items, func(i, j int) bool { return pvcs.Items[i].Name < pvcs.Items[j].Name })
+
+	// <PVC name, replicatedVolume>
+	volumes := map[string]replicatedVolume{}
+	for _, volume := range replication.Status.Volumes {
+		volumes[volume.PVCName] = volume
+	}
+	replication.Status.Volumes = nil
+	manifests := map[string]string{}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		volume, ok := volumes[pvc.Name]
+		if !ok {
+			volume = replicatedVolume{PVCName: pvc.Name}
+		}
+		d.replicateVolume(ctx, replication, pvc, &volume)
+		replication.Status.Volumes = append(replication.Status.Volumes, volume)
+		if len(volume.Replicas) == 0 {
+			continue
+		}
+		manifest, err := d.getDiskReplicationManifest(pvc, volume.Replicas[len(volume.Replicas)-1])
+		if err != nil {
+			return nil, err
+		}
+		manifests[pvc.Name+".yaml"] = manifest
+	}
+	return manifests, nil
+}
+
+// validateDiskReplicationDestination checks the destination resource group is allowed by the admin, so that users
+// creating an AzDiskReplication could not create snapshots in the other resource groups writable by the driver
+func (d *Driver) validateDiskReplicationDestination(replication *azDiskReplication) error {
+	if replication.Spec.DestinationResourceGroup == "" {
+		return fmt.Errorf("destinationResourceGroup must be set")
+	}
+	subsID := replication.Spec.DestinationSubscriptionID
+	if subsID == "" {
+		subsID = d.getCloud().SubscriptionID
+	}
+	resourceGroup := strings.ToLower(replication.Spec.DestinationResourceGroup)
+	if !d.diskReplicationResourceGroups[strings.ToLower(subsID)+"/"+resourceGroup] &&
+		!(strings.EqualFold(subsID, d.getCloud().SubscriptionID) && d.diskReplicationResourceGroups[resourceGroup]) {
+		return fmt.Errorf("resource group %s of subscription %s is not in --disk-replication-resource-groups of the driver", replication.Spec.DestinationResourceGroup, subsID)
+	}
+	return nil
+}
+
+// replicateVolume checks the copy of the pending snapshot of the PVC, or takes a new snapshot copied to the
+// destination if the last one is older than the interval of replication
+func (d *Driver) replicateVolume(ctx context.Context, replication *azDiskReplication, pvc *v1.PersistentVolumeClaim, volume *replicatedVolume) {
+	volume.Message = ""
+	if pvc.Spec.VolumeName == "" {
+		volume.Message = "waiting for the PVC to be bound"
+		return
+	}
+	pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		volume.Message = fmt.Sprintf("failed to get PV %s: %v", pvc.Spec.VolumeName, err)
+		return
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name {
+		volume.Message = fmt.Sprintf("PV %s is not provisioned by %s", pv.Name, d.Name)
+		return
+	}
+	volume.VolumeID = pv.Spec.CSI.VolumeHandle
+	ref := getDiskReplicationReference(replication)
+
+	if volume.PendingSnapshotName != "" {
+		resp, err := d.getReplicaSnapshotter().CreateSnapshot(ctx, d.newReplicaSnapshotRequest(replication, volume.VolumeID, volume.PendingSnapshotName))
+		if err == nil && resp.GetSnapshot().GetReadyToUse() {
+			d.completeReplica(ctx, replication, volume, resp.GetSnapshot().GetSnapshotId())
+			return
+		}
+		if volume.LastSnapshotTime != nil && time.Since(volume.LastSnapshotTime.Time) > diskReplicationCopyTimeout {
+			d.abandonReplica(ctx, replication, volume, err)
+			return
+		}
+		if err != nil {
+			volume.Message = fmt.Sprintf("failed to check copy of snapshot %s: %v", volume.PendingSnapshotName, err)
+		}
+		return
+	}
+
+	interval := time.Duration(replication.Spec.IntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultDiskReplicationIntervalMinutes * time.Minute
+	}
+	if volume.LastSnapshotTime != nil && time.Since(volume.LastSnapshotTime.Time) < interval {
+		return
+	}
+	now := metav1.Now()
+	snapshotName := fmt.Sprintf("azdr-%s-%d", pvc.UID, now.Unix())
+	resp, err := d.getReplicaSnapshotter().CreateSnapshot(ctx, d.newReplicaSnapshotRequest(replication, volume.VolumeID, snapshotName))
+	if err != nil {
+		volume.Message = fmt.Sprintf("failed to create snapshot %s: %v", snapshotName, err)
+		d.recordEvent(ref, v1.EventTypeWarning, diskReplicationFailedReason, "failed to create snapshot %s of PVC %s: %v", snapshotName, pvc.Name, err)
+		return
+	}
+	volume.PendingSnapshotName = snapshotName
+	volume.LastSnapshotTime = &now
+	klog.V(2).Infof("replicating disk %s of PVC %s/%s to snapshot %s", volume.VolumeID, pvc.Namespace, pvc.Name, snapshotName)
+	d.recordEvent(ref, v1.EventTypeNormal, diskReplicationStartedReason, "copying snapshot %s of PVC %s", snapshotName, pvc.Name)
+	if resp.GetSnapshot().GetReadyToUse() {
+		d.completeReplica(ctx, replication, volume, resp.GetSnapshot().GetSnapshotId())
+	}
+}
+
+// completeReplica adds the copied snapshot to the replicas of the volume and deletes the oldest replicas over the
+// retained count
+func (d *Driver) completeReplica(ctx context.Context, replication *azDiskReplication, volume *replicatedVolume, snapshotID string) {
+	volume.PendingSnapshotName = ""
+	volume.Replicas = append(volume.Replicas, snapshotID)
+	klog.V(2).Infof("copied snapshot %s of disk %s", snapshotID, volume.VolumeID)
+	d.recordEvent(getDiskReplicationReference(replication), v1.EventTypeNormal, diskReplicationSucceededReason, "copied snapshot %s of PVC %s", snapshotID, volume.PVCName)
+
+	retainedCount := replication.Spec.RetainedCount
+	if retainedCount <= 0 {
+		retainedCount = defaultDiskReplicationRetainedCount
+	}
+	for len(volume.Replicas) > retainedCount {
+		// retried in the next round if the replica is not deleted
+		if _, err := d.getReplicaSnapshotter().DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: volume.Replicas[0]}); err != nil {
+			volume.Message = fmt.Sprintf("failed to delete snapshot %s: %v", volume.Replicas[0], err)
+			return
+		}
+		volume.Replicas = volume.Replicas[1:]
+	}
+}
+
+// abandonReplica deletes the pending snapshot of the volume which is not copied within diskReplicationCopyTimeout,
+// e.g. the copy failed, so that a new snapshot is taken in the next round
+func (d *Driver) abandonReplica(ctx context.Context, replication *azDiskReplication, volume *replicatedVolume, copyErr error) {
+	subsID := replication.Spec.DestinationSubscriptionID
+	if subsID == "" {
+		subsID = d.getCloud().SubscriptionID
+	}
+	snapshotID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/snapshots/%s", subsID, replication.Spec.DestinationResourceGroup, volume.PendingSnapshotName)
+	if _, err := d.getReplicaSnapshotter().DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snapshotID}); err != nil {
+		volume.Message = fmt.Sprintf("failed to delete snapshot %s which is not copied in %v: %v", snapshotID, diskReplicationCopyTimeout, err)
+		return
+	}
+	volume.Message = fmt.Sprintf("snapshot %s is not copied in %v, error: %v", volume.PendingSnapshotName, diskReplicationCopyTimeout, copyErr)
+	d.recordEvent(getDiskReplicationReference(replication), v1.EventTypeWarning, diskReplicationFailedReason, "%s", volume.Message)
+	volume.PendingSnapshotName = ""
+	volume.LastSnapshotTime = nil
+}
+
+// newReplicaSnapshotRequest returns the request creating the incremental snapshot of volumeID in the destination of
+// replication, the snapshot is created in the region of the cluster and copied to the destination region in background
+func (d *Driver) newReplicaSnapshotRequest(replication *azDiskReplication, volumeID, snapshotName string) *csi.CreateSnapshotRequest {
+	parameters := map[string]string{
+		consts.ResourceGroupField: replication.Spec.DestinationResourceGroup,
+		consts.TagsField:          fmt.Sprintf("%s=%s/%s", diskReplicationTag, replication.Namespace, replication.Name),
+	}
+	if replication.Spec.DestinationLocation != "" {
+		parameters[consts.LocationField] = replication.Spec.DestinationLocation
+	}
+	if replication.Spec.DestinationSubscriptionID != "" {
+		parameters[consts.SubscriptionIDField] = replication.Spec.DestinationSubscriptionID
+	}
+	return &csi.CreateSnapshotRequest{Name: snapshotName, SourceVolumeId: volumeID, Parameters: parameters}
+}
+
+// getDiskReplicationManifest returns the manifests restoring pvc from the replica of snapshotID in the DR cluster, a
+// pre-provisioned VolumeSnapshotContent and VolumeSnapshot of the replica and the PVC restored from the VolumeSnapshot.
+// A PV could not refer to a snapshot, the disk and the PV are created from the snapshot when the PVC is provisioned.
+func (d *Driver) getDiskReplicationManifest(pvc *v1.PersistentVolumeClaim, snapshotID string) (string, error) {
+	snapshotName := pvc.Name + "-dr"
+	contentName := fmt.Sprintf("%s-%s-dr", pvc.Namespace, pvc.Name)
+	content := &snapshotv1.VolumeSnapshotContent{
+		TypeMeta:   metav1.TypeMeta{APIVersion: snapshotv1.SchemeGroupVersion.String(), Kind: "VolumeSnapshotContent"},
+		ObjectMeta: metav1.ObjectMeta{Name: contentName},
+		Spec: snapshotv1.VolumeSnapshotContentSpec{
+			DeletionPolicy:    snapshotv1.VolumeSnapshotContentRetain,
+			Driver:            d.Name,
+			Source:            snapshotv1.VolumeSnapshotContentSource{SnapshotHandle: ptr.To(snapshotID)},
+			VolumeSnapshotRef: v1.ObjectReference{Namespace: pvc.Namespace, Name: snapshotName},
+		},
+	}
+	snapshot := &snapshotv1.VolumeSnapshot{
+		TypeMeta:   metav1.TypeMeta{APIVersion: snapshotv1.SchemeGroupVersion.String(), Kind: "VolumeSnapshot"},
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotName, Namespace: pvc.Namespace},
+		Spec:       snapshotv1.VolumeSnapshotSpec{Source: snapshotv1.VolumeSnapshotSource{VolumeSnapshotContentName: ptr.To(contentName)}},
+	}
+	requests := pvc.Spec.Resources.Requests
+	if capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]; ok {
+		requests = v1.ResourceList{v1.ResourceStorage: capacity}
+	}
+	restoredPVC := &v1.PersistentVolumeClaim{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{Name: pvc.Name, Namespace: pvc.Namespace, Labels: pvc.Labels},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      pvc.Spec.AccessModes,
+			StorageClassName: pvc.Spec.StorageClassName,
+			VolumeMode:       pvc.Spec.VolumeMode,
+			Resources:        v1.VolumeResourceRequirements{Requests: requests},
+			DataSource:       &v1.TypedLocalObjectReference{APIGroup: ptr.To(snapshotv1.GroupName), Kind: "VolumeSnapshot", Name: snapshotName},
+		},
+	}
+	documents := []string{}
+	for _, obj := range []interface{}{content, snapshot, restoredPVC} {
+		document, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+		documents = append(documents, string(document))
+	}
+	return strings.Join(documents, "---\n"), nil
+}
+
+// updateDiskReplicationManifests writes manifests to the ConfigMap of the DR manifests of replication, which is owned
+// by the replication and deleted with it
+func (d *Driver) updateDiskReplicationManifests(ctx context.Context, replication *azDiskReplication, manifests map[string]string) error {
+	name := replication.Name + diskReplicationManifestsSuffix
+	configMaps := d.kubeClient.CoreV1().ConfigMaps(replication.Namespace)
+	configMap, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if len(manifests) == 0 {
+			return nil
+		}
+		_, err = configMaps.Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: replication.Namespace,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: azDiskReplicationResource.GroupVersion().String(),
+					Kind:       azDiskReplicationKind,
+					Name:       replication.Name,
+					UID:        replication.UID,
+					Controller: ptr.To(true),
+				}},
+			},
+			Data: manifests,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(configMap, replication) {
+		return fmt.Errorf("ConfigMap %s is not owned by %s %s", name, azDiskReplicationKind, replication.Name)
+	}
+	if len(configMap.Data) == len(manifests) && reflect.DeepEqual(configMap.Data, manifests) {
+		return nil
+	}
+	configMap.Data = manifests
+	_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+func (d *Driver) updateDiskReplicationStatus(ctx context.Context, replication *azDiskReplication) error {
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&replication.Status)
+	if err != nil {
+		return err
+	}
+	obj, err := d.dynamicClient.Resource(azDiskReplicationResource).Namespace(replication.Namespace).Get(ctx, replication.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if obj.GetUID() != replication.UID {
+		return nil
+	}
+	if err := unstructured.SetNestedMap(obj.Object, status, "status"); err != nil {
+		return err
+	}
+	_, err = d.dynamicClient.Resource(azDiskReplicationResource).Namespace(replication.Namespace).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+// getReplicaSnapshotter returns replicaSnapshotter, the driver itself if it's not set
+func (d *Driver) getReplicaSnapshotter() replicaSnapshotter {
+	if d.replicaSnapshotter != nil {
+		return d.replicaSnapshotter
+	}
+	return d
+}
+
+func getDiskReplicationReference(replication *azDiskReplication) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: azDiskReplicationResource.GroupVersion().String(),
+		Kind:       azDiskReplicationKind,
+		Namespace:  replication.Namespace,
+		Name:       replication.Name,
+		UID:        replication.UID,
+	}
+}
@@ -0,0 +1,239 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+type fakeReplicaSnapshotter struct {
+	// <snapshot name, ready to use>
+	snapshots map[string]bool
+	requests  []*csi.CreateSnapshotRequest
+	deleted   []string
+}
+
+func (s *fakeReplicaSnapshotter) CreateSnapshot(_ context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	s.requests = append(s.requests, req)
+	if _, ok := s.snapshots[req.Name]; !ok {
+		s.snapshots[req.Name] = false
+	}
+	return &csi.CreateSnapshotResponse{Snapshot: &csi.Snapshot{SnapshotId: "/snapshots/" + req.Name, ReadyToUse: s.snapshots[req.Name]}}, nil
+}
+
+func (s *fakeReplicaSnapshotter) DeleteSnapshot(_ context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	s.deleted = append(s.deleted, req.SnapshotId)
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func newTestDiskReplicationDriver(t *testing.T, cntl *gomock.Controller) (*fakeDriverV1, *fakeReplicaSnapshotter) {
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.diskReplicationResourceGroups = map[string]bool{"dr-rg": true}
+	snapshotter := &fakeReplicaSnapshotter{snapshots: map[string]bool{}}
+	d.replicaSnapshotter = snapshotter
+	d.kubeClient = fake.NewSimpleClientset(
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default", UID: "pvc-uid", Labels: map[string]string{"dr": "true"}},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				StorageClassName: ptr.To("managed-csi"),
+				VolumeName:       "pv-data",
+				Resources:        v1.VolumeResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")}},
+			},
+		},
+		&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default", Labels: map[string]string{"dr": "true"}}},
+		&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}, Spec: v1.PersistentVolumeClaimSpec{VolumeName: "pv-other"}},
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-data"},
+			Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{
+				Driver: d.Name, VolumeHandle: testVolumeID,
+			}}},
+		},
+	)
+	return d, snapshotter
+}
+
+func newTestDiskReplication() *azDiskReplication {
+	return &azDiskReplication{
+		ObjectMeta: metav1.ObjectMeta{Name: "replication", Namespace: "default", UID: "replication-uid"},
+		Spec: diskReplicationSpec{
+			Selector:                 &metav1.LabelSelector{MatchLabels: map[string]string{"dr": "true"}},
+			DestinationLocation:      "westus2",
+			DestinationResourceGroup: "DR-RG",
+			RetainedCount:            2,
+		},
+	}
+}
+
+func TestReconcileDiskReplication(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, snapshotter := newTestDiskReplicationDriver(t, cntl)
+	ctx := context.Background()
+	replication := newTestDiskReplication()
+
+	manifests, err := d.reconcileDiskReplication(ctx, replication)
+	require.NoError(t, err)
+	assert.Empty(t, manifests)
+	require.Len(t, replication.Status.Volumes, 2)
+	assert.Equal(t, "waiting for the PVC to be bound", replication.Status.Volumes[1].Message)
+	volume := replication.Status.Volumes[0]
+	assert.Equal(t, "data", volume.PVCName)
+	assert.Equal(t, testVolumeID, volume.VolumeID)
+	pendingSnapshotName := volume.PendingSnapshotName
+	assert.Regexp(t, "^azdr-pvc-uid-[0-9]+$", pendingSnapshotName)
+	require.Len(t, snapshotter.requests, 1)
+	assert.Equal(t, testVolumeID, snapshotter.requests[0].SourceVolumeId)
+	assert.Equal(t, map[string]string{
+		consts.ResourceGroupField: "DR-RG",
+		consts.LocationField:      "westus2",
+		consts.TagsField:          diskReplicationTag + "=default/replication",
+	}, snapshotter.requests[0].Parameters)
+
+	// the copy is checked until it's completed
+	_, err = d.reconcileDiskReplication(ctx, replication)
+	require.NoError(t, err)
+	assert.Equal(t, pendingSnapshotName, replication.Status.Volumes[0].PendingSnapshotName)
+	assert.Empty(t, replication.Status.Volumes[0].Replicas)
+
+	snapshotter.snapshots[pendingSnapshotName] = true
+	manifests, err = d.reconcileDiskReplication(ctx, replication)
+	require.NoError(t, err)
+	volume = replication.Status.Volumes[0]
+	assert.Empty(t, volume.PendingSnapshotName)
+	assert.Equal(t, []string{"/snapshots/" + pendingSnapshotName}, volume.Replicas)
+	require.Contains(t, manifests, "data.yaml")
+	assert.Contains(t, manifests["data.yaml"], "snapshotHandle: /snapshots/"+pendingSnapshotName)
+	assert.Contains(t, manifests["data.yaml"], "kind: VolumeSnapshot\n")
+	assert.Contains(t, manifests["data.yaml"], "name: data-dr\n")
+	assert.Contains(t, manifests["data.yaml"], "storageClassName: managed-csi")
+
+	// no snapshot is taken before the interval
+	_, err = d.reconcileDiskReplication(ctx, replication)
+	require.NoError(t, err)
+	assert.Len(t, snapshotter.requests, 3)
+
+	// the oldest replicas over the retained count are deleted
+	replication.Status.Volumes[0].Replicas = []string{"/snapshots/1", "/snapshots/2"}
+	replication.Status.Volumes[0].LastSnapshotTime = &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}
+	// the snapshot names are unique per second
+	snapshotter.snapshots = map[string]bool{}
+	_, err = d.reconcileDiskReplication(ctx, replication)
+	require.NoError(t, err)
+	pendingSnapshotName = replication.Status.Volumes[0].PendingSnapshotName
+	require.NotEmpty(t, pendingSnapshotName)
+	snapshotter.snapshots[pendingSnapshotName] = true
+	_, err = d.reconcileDiskReplication(ctx, replication)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/snapshots/2", "/snapshots/" + pendingSnapshotName}, replication.Status.Volumes[0].Replicas)
+	assert.Equal(t, []string{"/snapshots/1"}, snapshotter.deleted)
+
+	// a replica which is not copied in time is deleted and taken again
+	replication.Status.Volumes[0].PendingSnapshotName = "stuck"
+	replication.Status.Volumes[0].LastSnapshotTime = &metav1.Time{Time: time.Now().Add(-diskReplicationCopyTimeout - time.Minute)}
+	_, err = d.reconcileDiskReplication(ctx, replication)
+	require.NoError(t, err)
+	assert.Empty(t, replication.Status.Volumes[0].PendingSnapshotName)
+	assert.Nil(t, replication.Status.Volumes[0].LastSnapshotTime)
+	assert.Equal(t, fmt.Sprintf("/subscriptions/%s/resourceGroups/DR-RG/providers/Microsoft.Compute/snapshots/stuck", d.getCloud().SubscriptionID), snapshotter.deleted[1])
+}
+
+func TestValidateDiskReplicationDestination(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, snapshotter := newTestDiskReplicationDriver(t, cntl)
+	subsID := d.getCloud().SubscriptionID
+
+	replication := newTestDiskReplication()
+	assert.NoError(t, d.validateDiskReplicationDestination(replication))
+	replication.Spec.DestinationSubscriptionID = "other-subscription"
+	assert.Error(t, d.validateDiskReplicationDestination(replication))
+	d.diskReplicationResourceGroups["other-subscription/dr-rg"] = true
+	assert.NoError(t, d.validateDiskReplicationDestination(replication))
+
+	replication = newTestDiskReplication()
+	replication.Spec.DestinationResourceGroup = "other-rg"
+	_, err := d.reconcileDiskReplication(context.Background(), replication)
+	assert.Error(t, err)
+	assert.Equal(t, fmt.Sprintf("resource group other-rg of subscription %s is not in --disk-replication-resource-groups of the driver", subsID), replication.Status.Message)
+	assert.Empty(t, snapshotter.requests)
+}
+
+func TestReplicateDisks(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, snapshotter := newTestDiskReplicationDriver(t, cntl)
+	ctx := context.Background()
+
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{azDiskReplicationResource: "AzDiskReplicationList"},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "disk.csi.azure.com/v1alpha1",
+			"kind":       azDiskReplicationKind,
+			"metadata":   map[string]interface{}{"name": "replication", "namespace": "default", "uid": "replication-uid"},
+			"spec": map[string]interface{}{
+				"selector":                 map[string]interface{}{"matchLabels": map[string]interface{}{"dr": "true"}},
+				"destinationResourceGroup": "dr-rg",
+			},
+		}})
+
+	require.NoError(t, d.replicateDisks(ctx))
+	obj, err := d.dynamicClient.Resource(azDiskReplicationResource).Namespace("default").Get(ctx, "replication", metav1.GetOptions{})
+	require.NoError(t, err)
+	volumes, _, _ := unstructured.NestedSlice(obj.Object, "status", "volumes")
+	require.Len(t, volumes, 2)
+	pendingSnapshotName, _, _ := unstructured.NestedString(volumes[0].(map[string]interface{}), "pendingSnapshotName")
+	require.NotEmpty(t, pendingSnapshotName)
+
+	snapshotter.snapshots[pendingSnapshotName] = true
+	require.NoError(t, d.replicateDisks(ctx))
+	configMap, err := d.kubeClient.CoreV1().ConfigMaps("default").Get(ctx, "replication"+diskReplicationManifestsSuffix, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, configMap.Data, "data.yaml")
+	require.Len(t, configMap.OwnerReferences, 1)
+	assert.Equal(t, azDiskReplicationKind, configMap.OwnerReferences[0].Kind)
+
+	// a ConfigMap not owned by the replication is not overwritten
+	configMap.OwnerReferences = nil
+	_, err = d.kubeClient.CoreV1().ConfigMaps("default").Update(ctx, configMap, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	err = d.updateDiskReplicationManifests(ctx, &azDiskReplication{ObjectMeta: metav1.ObjectMeta{Name: "replication", Namespace: "default", UID: "replication-uid"}}, map[string]string{})
+	assert.Error(t, err)
+}
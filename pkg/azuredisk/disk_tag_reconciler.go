@@ -0,0 +1,199 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+)
+
+const diskTagsRepairedReason = "DiskTagsRepaired"
+
+// runDiskTagReconciler checks the disks of the PVs provisioned by the driver every interval and repairs the tags set
+// on creation and the owner tag of the cluster if they were removed or changed outside of the driver, the disks are
+// checked at the rate of the tag reconcile rate limiter
+func (d *Driver) runDiskTagReconciler(ctx context.Context, interval time.Duration) {
+	klog.V(2).Infof("reconciling tags of disks every %v", interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		clusterID, err := d.getClusterID(ctx)
+		if err != nil {
+			klog.Errorf("failed to get cluster ID: %v", err)
+			return
+		}
+		pvs, err := d.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			klog.Errorf("failed to list PVs: %v", err)
+			return
+		}
+		for i := range pvs.Items {
+			if err := d.reconcileDiskTags(ctx, &pvs.Items[i], clusterID); err != nil {
+				klog.Errorf("failed to reconcile tags of the disk of PV %s: %v", pvs.Items[i].Name, err)
+			}
+		}
+	}, interval)
+}
+
+// getClusterID returns the UID of the kube-system namespace, which is the value of the owner tag of the disks, the
+// UID is cached since it never changes
+func (d *DriverCore) getClusterID(ctx context.Context) (string, error) {
+	if clusterID, ok := d.clusterID.Load().(string); ok {
+		return clusterID, nil
+	}
+	if d.kubeClient == nil {
+		return "", fmt.Errorf("kube client is not initialized")
+	}
+	namespace, err := d.kubeClient.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if namespace.UID == "" {
+		return "", fmt.Errorf("UID of namespace %s is empty", metav1.NamespaceSystem)
+	}
+	d.clusterID.Store(string(namespace.UID))
+	return string(namespace.UID), nil
+}
+
+// getDiskOwnerClusterID returns the cluster ID set as the owner tag of a new disk, the disk is created without the
+// owner tag if the cluster ID could not be got, e.g. without kube client
+func (d *DriverCore) getDiskOwnerClusterID(ctx context.Context, diskName string) string {
+	clusterID, err := d.getClusterID(ctx)
+	if err != nil {
+		klog.Warningf("owner tag %s is not set on disk(%s): failed to get cluster ID: %v", consts.OwnerTag, diskName, err)
+	}
+	return clusterID
+}
+
+// reconcileDiskTags sets the missing or changed tags of the disk of pv, the tags added outside of the driver are kept.
+// PVs of other drivers, pre-provisioned PVs and PVs with the skip annotation are skipped.
+func (d *Driver) reconcileDiskTags(ctx context.Context, pv *v1.PersistentVolume, clusterID string) error {
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.DeletionTimestamp != nil {
+		return nil
+	}
+	if _, ok := pv.Spec.CSI.VolumeAttributes[consts.RequestedSizeGib]; !ok {
+		return nil
+	}
+	if strings.EqualFold(pv.Annotations[consts.SkipTagReconcileAnnotation], consts.TrueValue) {
+		return nil
+	}
+	desiredTags, err := getDesiredDiskTags(pv.Spec.CSI.VolumeAttributes, clusterID)
+	if err != nil {
+		return err
+	}
+
+	diskURI := pv.Spec.CSI.VolumeHandle
+	diskName, err := azureutils.GetDiskName(diskURI)
+	if err != nil {
+		return err
+	}
+	resourceGroup, subsID, err := getInfoFromDiskURI(diskURI)
+	if err != nil {
+		return err
+	}
+	if err := d.tagReconcileRateLimiter.Wait(ctx); err != nil {
+		return err
+	}
+	diskClient, err := d.getClientFactory().GetDiskClientForSub(subsID)
+	if err != nil {
+		return err
+	}
+	disk, err := diskClient.Get(ctx, resourceGroup, diskName)
+	if err != nil {
+		return fmt.Errorf("failed to get disk %s: %w", diskURI, err)
+	}
+	tags, changes := getRepairedDiskTags(disk.Tags, desiredTags)
+	if len(changes) == 0 {
+		return nil
+	}
+	tagsClient, err := d.getResourceTagsClient()
+	if err != nil {
+		return err
+	}
+	// only the repaired tags are merged, the tags changed meanwhile by someone else are kept
+	if err := tagsClient.MergeTags(ctx, diskURI, tags); err != nil {
+		return fmt.Errorf("update tags of disk(%s) failed with %w", diskURI, err)
+	}
+	klog.V(2).Infof("repaired tags of disk(%s): %s", diskURI, strings.Join(changes, "; "))
+	d.recordEvent(&v1.ObjectReference{Kind: "PersistentVolume", APIVersion: "v1", Name: pv.Name, UID: pv.UID}, v1.EventTypeNormal,
+		diskTagsRepairedReason, "repaired tags of disk %s: %s", diskURI, strings.Join(changes, "; "))
+	return nil
+}
+
+// getDesiredDiskTags returns the tags set by CreateVolume on the disk of a volume provisioned with volumeContext
+// and the owner tag of the cluster
+func getDesiredDiskTags(volumeContext map[string]string, clusterID string) (map[string]string, error) {
+	tags := map[string]string{azureconsts.CreatedByTag: consts.AzureDiskDriverTag, consts.OwnerTag: clusterID}
+	var customTags, tagValueDelimiter string
+	for k, v := range volumeContext {
+		switch strings.ToLower(k) {
+		case consts.TagsField:
+			customTags = v
+		case consts.TagValueDelimiterField:
+			tagValueDelimiter = v
+		case consts.PvcNameKey:
+			tags[consts.PvcNameTag] = v
+		case consts.PvcNamespaceKey:
+			tags[consts.PvcNamespaceTag] = v
+		case consts.PvNameKey:
+			tags[consts.PvNameTag] = v
+		}
+	}
+	customTagsMap, err := util.ConvertTagsToMap(customTags, tagValueDelimiter)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range customTagsMap {
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+// getRepairedDiskTags returns the desired tags which are missing or changed in the current tags of a disk and the
+// list of changes, tag keys are case-insensitive in Azure
+func getRepairedDiskTags(currentTags map[string]*string, desiredTags map[string]string) (map[string]string, []string) {
+	tags := map[string]string{}
+	changes := []string{}
+	for key, value := range desiredTags {
+		found := false
+		for k, v := range currentTags {
+			if strings.EqualFold(k, key) && ptr.Deref(v, "") == value {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		tags[key] = value
+		changes = append(changes, fmt.Sprintf("set tag %s=%s", key, value))
+	}
+	sort.Strings(changes)
+	return tags, changes
+}
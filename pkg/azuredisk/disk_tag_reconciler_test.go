@@ -0,0 +1,164 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func TestGetDesiredDiskTags(t *testing.T) {
+	tags, err := getDesiredDiskTags(map[string]string{
+		"Tags":              "team=storage;env=a,b",
+		"tagValueDelimiter": ";",
+		consts.PvNameKey:    "pv",
+		consts.SkuNameField: "Premium_LRS",
+	}, "cluster-id")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		azureconsts.CreatedByTag: consts.AzureDiskDriverTag,
+		consts.OwnerTag:          "cluster-id",
+		consts.PvNameTag:         "pv",
+		"team":                   "storage",
+		"env":                    "a,b",
+	}, tags)
+
+	_, err = getDesiredDiskTags(map[string]string{consts.TagsField: "invalid"}, "cluster-id")
+	assert.Error(t, err)
+}
+
+func TestGetRepairedDiskTags(t *testing.T) {
+	desired := map[string]string{"team": "storage", "env": "prod", consts.OwnerTag: "cluster-id"}
+	tags, changes := getRepairedDiskTags(map[string]*string{
+		"Team":     ptr.To("storage"),
+		"env":      ptr.To("dev"),
+		"external": ptr.To("kept"),
+	}, desired)
+	// only the missing and changed tags are returned
+	assert.Equal(t, map[string]string{
+		"env":           "prod",
+		consts.OwnerTag: "cluster-id",
+	}, tags)
+	assert.Equal(t, []string{"set tag env=prod", "set tag k8s-azure-dd-owner=cluster-id"}, changes)
+
+	_, changes = getRepairedDiskTags(map[string]*string{
+		"team":          ptr.To("storage"),
+		"env":           ptr.To("prod"),
+		consts.OwnerTag: ptr.To("cluster-id"),
+	}, desired)
+	assert.Empty(t, changes)
+}
+
+// fakeResourceTagsClient keeps the tags merged into the resources
+type fakeResourceTagsClient struct {
+	merged map[string]map[string]string
+}
+
+func (c *fakeResourceTagsClient) MergeTags(_ context.Context, resourceID string, tags map[string]string) error {
+	if c.merged == nil {
+		c.merged = map[string]map[string]string{}
+	}
+	if c.merged[resourceID] == nil {
+		c.merged[resourceID] = map[string]string{}
+	}
+	for k, v := range tags {
+		c.merged[resourceID][k] = v
+	}
+	return nil
+}
+
+func TestGetClusterID(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = d.getClusterID(ctx)
+	assert.Error(t, err)
+	assert.Empty(t, d.getDiskOwnerClusterID(ctx, "disk"))
+
+	_, err = d.kubeClient.CoreV1().Namespaces().Create(ctx, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceSystem, UID: "cluster-id"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-id", d.getDiskOwnerClusterID(ctx, "disk"))
+
+	// the cluster ID is cached
+	require.NoError(t, d.kubeClient.CoreV1().Namespaces().Delete(ctx, metav1.NamespaceSystem, metav1.DeleteOptions{}))
+	clusterID, err := d.getClusterID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-id", clusterID)
+}
+
+func TestReconcileDiskTags(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+	d.tagReconcileRateLimiter = flowcontrol.NewFakeAlwaysRateLimiter()
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+
+	_, err = d.kubeClient.CoreV1().Namespaces().Create(ctx, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceSystem, UID: "cluster-id"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	clusterID, err := d.getClusterID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-id", clusterID)
+
+	newPV := func(volumeAttributes map[string]string, annotations map[string]string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv", Annotations: annotations},
+			Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{
+				Driver:           d.Name,
+				VolumeHandle:     testVolumeID,
+				VolumeAttributes: volumeAttributes,
+			}}},
+		}
+	}
+	provisioned := map[string]string{consts.RequestedSizeGib: "10", consts.TagsField: "team=storage"}
+
+	// pre-provisioned PVs and PVs with the skip annotation are not checked
+	require.NoError(t, d.reconcileDiskTags(ctx, newPV(map[string]string{consts.TagsField: "team=storage"}, nil), clusterID))
+	require.NoError(t, d.reconcileDiskTags(ctx, newPV(provisioned, map[string]string{consts.SkipTagReconcileAnnotation: "true"}), clusterID))
+
+	diskClient.EXPECT().Get(gomock.Any(), "rg", testVolumeName).Return(&armcompute.Disk{
+		Name: ptr.To(testVolumeName),
+		Tags: map[string]*string{azureconsts.CreatedByTag: ptr.To(consts.AzureDiskDriverTag), "external": ptr.To("kept")},
+	}, nil).Times(1)
+	tagsClient := &fakeResourceTagsClient{}
+	d.resourceTagsClient = tagsClient
+	require.NoError(t, d.reconcileDiskTags(ctx, newPV(provisioned, nil), clusterID))
+	// only the missing tags are merged, the other tags of the disk are not replaced
+	assert.Equal(t, map[string]map[string]string{testVolumeID: {
+		consts.OwnerTag: "cluster-id",
+		"team":          "storage",
+	}}, tagsClient.merged)
+}
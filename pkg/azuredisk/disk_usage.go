@@ -0,0 +1,118 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	provider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// diskCountUsageNames are the names of the compute usages limiting the number of managed disks of a sku prefix
+var diskCountUsageNames = map[string]string{
+	"premiumv2_":   "PremiumV2DiskCount",
+	"premium_":     "PremiumDiskCount",
+	"standardssd_": "StandardSSDDiskCount",
+	"standard_":    "StandardDiskCount",
+	"ultrassd_":    "UltraSSDDiskCount",
+}
+
+// diskUsageClient lists the compute resource usages of a subscription in a location
+type diskUsageClient interface {
+	List(ctx context.Context, subscriptionID, location string) ([]*armcompute.Usage, error)
+}
+
+// armDiskUsageClient lists the usages by the compute usage API, which is not in the client factory of the cloud
+type armDiskUsageClient struct {
+	cloud *provider.Cloud
+}
+
+func (c *armDiskUsageClient) List(ctx context.Context, subscriptionID, location string) ([]*armcompute.Usage, error) {
+	if c.cloud == nil || c.cloud.AuthProvider == nil {
+		return nil, fmt.Errorf("azure credential is not initialized")
+	}
+	clientOption, err := azclient.GetAzCoreClientOption(&c.cloud.ARMClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	cred := c.cloud.AuthProvider.GetAzIdentity()
+	if c.cloud.AuthProvider.IsMultiTenantModeEnabled() {
+		cred = c.cloud.AuthProvider.GetMultiTenantIdentity()
+	}
+	client, err := armcompute.NewUsageClient(subscriptionID, cred, &arm.ClientOptions{ClientOptions: *clientOption})
+	if err != nil {
+		return nil, err
+	}
+	var usages []*armcompute.Usage
+	pager := client.NewListPager(location, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, page.Value...)
+	}
+	return usages, nil
+}
+
+// getDiskUsageClient returns the usage client using the credential of the cloud
+func (d *Driver) getDiskUsageClient() diskUsageClient {
+	if d.diskUsageClient != nil {
+		return d.diskUsageClient
+	}
+	return &armDiskUsageClient{cloud: d.getCloud()}
+}
+
+// getDiskUsages returns the compute usages of the subscription in location, read from diskUsageCache if it's set
+func (d *Driver) getDiskUsages(ctx context.Context, subscriptionID, location string) ([]*armcompute.Usage, error) {
+	if d.diskUsageCache == nil {
+		return d.getDiskUsageClient().List(ctx, subscriptionID, location)
+	}
+	usages, err := d.diskUsageCache.Get(ctx, subscriptionID+"/"+location, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return nil, err
+	}
+	return usages.([]*armcompute.Usage), nil
+}
+
+// getDiskCountUsage returns the usage limiting the number of managed disks of skuName, nil is returned if the usages
+// do not limit the sku
+func getDiskCountUsage(usages []*armcompute.Usage, skuName armcompute.DiskStorageAccountTypes) *armcompute.Usage {
+	var usageName string
+	sku := strings.ToLower(string(skuName))
+	for prefix, name := range diskCountUsageNames {
+		if strings.HasPrefix(sku, prefix) {
+			usageName = name
+		}
+	}
+	if usageName == "" {
+		return nil
+	}
+	for _, usage := range usages {
+		if usage != nil && usage.Name != nil && strings.EqualFold(ptr.Deref(usage.Name.Value, ""), usageName) {
+			return usage
+		}
+	}
+	return nil
+}
@@ -24,7 +24,10 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"go.uber.org/mock/gomock"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/mount-utils"
 	testingexec "k8s.io/utils/exec/testing"
 
@@ -67,7 +70,7 @@ var (
 type FakeDriver interface {
 	CSIDriver
 
-	GetSourceDiskSize(ctx context.Context, subsID, resourceGroup, diskName string, curDepth, maxDepth int) (*int32, *armcompute.Disk, error)
+	GetSourceDiskSize(ctx context.Context, clientFactory azclient.ClientFactory, subsID, resourceGroup, diskName string, curDepth, maxDepth int) (*int32, *armcompute.Disk, error)
 
 	setNextCommandOutputScripts(scripts ...testingexec.FakeAction)
 
@@ -76,9 +79,12 @@ type FakeDriver interface {
 	setNodeCapabilities([]*csi.NodeServiceCapability)
 	setName(string)
 	setNodeID(string)
+	setDriverRole(string)
 	setVersion(version string)
 	getCloud() *azure.Cloud
 	setCloud(*azure.Cloud)
+	setKubeClient(kubernetes.Interface)
+	setPVCLister(corelisters.PersistentVolumeClaimLister, cache.InformerSynced)
 	getClientFactory() azclient.ClientFactory
 	getMounter() *mount.SafeFormatAndMount
 	setMounter(*mount.SafeFormatAndMount)
@@ -86,17 +92,22 @@ type FakeDriver interface {
 	getDeviceHelper() optimization.Interface
 	getHostUtil() hostUtil
 
-	checkDiskCapacity(context.Context, string, string, string, int) (bool, error)
+	checkDiskCapacity(context.Context, azclient.ClientFactory, string, string, string, int) (bool, error)
 	checkDiskExists(ctx context.Context, diskURI string) (*armcompute.Disk, error)
 	getSnapshotInfo(string) (string, string, string, error)
-	waitForSnapshotReady(context.Context, string, string, string, time.Duration, time.Duration) error
-	getSnapshotByID(context.Context, string, string, string, string) (*csi.Snapshot, error)
+	waitForSnapshotReady(context.Context, azclient.ClientFactory, string, string, string, time.Duration, time.Duration) error
+	prepareSnapshotForZonalRestore(ctx context.Context, clientFactory azclient.ClientFactory, snapshotID, location, diskZone string) error
+	getSnapshotSizeGiB(ctx context.Context, clientFactory azclient.ClientFactory, snapshotID string) (*int32, error)
+	getSnapshotByID(context.Context, azclient.ClientFactory, string, string, string, string) (*csi.Snapshot, error)
 	ensureMountPoint(string) (bool, error)
 	ensureBlockTargetFile(string) error
-	getDevicePathWithLUN(lunStr string) (string, error)
+	getDevicePathWithLUN(volumeID, lunStr string) (string, error)
+	reuseStagingMount(lunStr, target string) (bool, error)
 	setThrottlingCache(key string, value string)
 	getUsedLunsFromVolumeAttachments(context.Context, string) ([]int, error)
-	getUsedLunsFromNode(nodeName types.NodeName) ([]int, error)
+	getUsedLunsFromNode(ctx context.Context, nodeName types.NodeName) ([]int, error)
+	isSystemCriticalVolume(volumeContext map[string]string, disk *armcompute.Disk) bool
+	getPVCAvailabilityZone(ctx context.Context, pvcNamespace, pvcName string, requirement *csi.TopologyRequirement, region string) (string, error)
 }
 
 type fakeDriverV1 struct {
@@ -110,6 +121,9 @@ func newFakeDriverV1(ctrl *gomock.Controller) (*fakeDriverV1, error) {
 	driver.NodeID = fakeNodeID
 	driver.CSIDriver = *csicommon.NewFakeCSIDriver()
 	driver.volumeLocks = volumehelper.NewVolumeLocks()
+	driver.resourceGroupLocks = newLockMap()
+	driver.diskPoolLocks = newLockMap()
+	driver.inflightOperations = newInflightOperations()
 	driver.VolumeAttachLimit = -1
 	driver.supportZone = true
 	driver.ioHandler = azureutils.NewFakeIOHandler()
@@ -119,7 +133,14 @@ func newFakeDriverV1(ctrl *gomock.Controller) (*fakeDriverV1, error) {
 	driver.shouldWaitForSnapshotReady = true
 	driver.endpoint = "tcp://127.0.0.1:0"
 	driver.disableAVSetNodes = true
+	driver.systemCriticalNamespaces = map[string]bool{"kube-system": true}
+	driver.enablePVCZoneAnnotation = true
+	driver.enableVolumePriorityAnnotation = true
 	driver.kubeClient = fake.NewSimpleClientset()
+	driver.fsFreezer = newFilesystemFreezer(
+		func(mountPath string) error { return freezeFilesystem(mountPath, driver.mounter) },
+		func(mountPath string) error { return thawFilesystem(mountPath, driver.mounter) },
+		fsFreezeLease)
 
 	driver.cloud = azure.GetTestCloud(ctrl)
 	driver.diskController = NewManagedDiskController(driver.cloud)
@@ -153,6 +174,8 @@ func newFakeDriverV1(ctrl *gomock.Controller) (*fakeDriverV1, error) {
 			csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
 			csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
 			csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+			csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
 		})
 	driver.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER})
 	driver.AddNodeServiceCapabilities([]csi.NodeServiceCapability_RPC_Type{
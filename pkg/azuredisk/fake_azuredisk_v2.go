@@ -62,6 +62,8 @@ func newFakeDriverV2(ctrl *gomock.Controller) (*fakeDriverV2, error) {
 	driver.NodeID = fakeNodeID
 	driver.CSIDriver = *csicommon.NewFakeCSIDriver()
 	driver.volumeLocks = volumehelper.NewVolumeLocks()
+	driver.resourceGroupLocks = newLockMap()
+	driver.inflightOperations = newInflightOperations()
 	driver.VolumeAttachLimit = -1
 	driver.supportZone = true
 	driver.ioHandler = azureutils.NewFakeIOHandler()
@@ -70,6 +72,9 @@ func newFakeDriverV2(ctrl *gomock.Controller) (*fakeDriverV2, error) {
 	driver.allowEmptyCloudConfig = true
 	driver.endpoint = "tcp://127.0.0.1:0"
 	driver.disableAVSetNodes = true
+	driver.systemCriticalNamespaces = map[string]bool{"kube-system": true}
+	driver.enablePVCZoneAnnotation = true
+	driver.enableVolumePriorityAnnotation = true
 	driver.kubeClient = fake.NewSimpleClientset()
 
 	driver.cloud = azure.GetTestCloud(ctrl)
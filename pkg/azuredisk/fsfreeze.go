@@ -0,0 +1,350 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// fsFreezeServiceName is the name of the internal gRPC service served by the node plugin
+	fsFreezeServiceName = "disk.csi.azure.com.v1.FilesystemFreeze"
+	// fsFreezeLease is the time after which a frozen filesystem is thawed automatically unless the freeze is renewed,
+	// the controller renews the freeze until the snapshot is created, so a controller crash between freeze and thaw
+	// never leaves the filesystem frozen for long
+	fsFreezeLease = time.Minute
+	// fsFreezeRenewInterval is the interval at which the controller renews the freeze while the snapshot is created
+	fsFreezeRenewInterval = fsFreezeLease / 3
+	fsFreezeRPCTimeout    = 15 * time.Second
+	// fsFreezeTLSServerName is the name the controller verifies in the certificate of the filesystem freeze server,
+	// the certificate in the TLS cert dir must have it as a DNS name
+	fsFreezeTLSServerName = "fsfreeze.disk.csi.azure.com"
+)
+
+// fsFreezeServer is the internal gRPC service the controller uses to freeze and thaw
+// the filesystem of a disk attached to a node, requests carry the LUN of the disk
+type fsFreezeServer interface {
+	FreezeFilesystem(context.Context, *wrapperspb.StringValue) (*emptypb.Empty, error)
+	ThawFilesystem(context.Context, *wrapperspb.StringValue) (*emptypb.Empty, error)
+}
+
+var fsFreezeServiceDesc = grpc.ServiceDesc{
+	ServiceName: fsFreezeServiceName,
+	HandlerType: (*fsFreezeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "FreezeFilesystem",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				return handleFsFreezeRPC(srv, ctx, dec, interceptor, "FreezeFilesystem", fsFreezeServer.FreezeFilesystem)
+			},
+		},
+		{
+			MethodName: "ThawFilesystem",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				return handleFsFreezeRPC(srv, ctx, dec, interceptor, "ThawFilesystem", fsFreezeServer.ThawFilesystem)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func handleFsFreezeRPC(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor,
+	method string, call func(fsFreezeServer, context.Context, *wrapperspb.StringValue) (*emptypb.Empty, error)) (interface{}, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return call(srv.(fsFreezeServer), ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: fmt.Sprintf("/%s/%s", fsFreezeServiceName, method),
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return call(srv.(fsFreezeServer), ctx, req.(*wrapperspb.StringValue))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// filesystemFreezer tracks the filesystems frozen on this node and thaws them automatically if the freeze is not
+// renewed within timeout
+type filesystemFreezer struct {
+	mu      sync.Mutex
+	frozen  map[string]*time.Timer
+	freeze  func(mountPath string) error
+	thaw    func(mountPath string) error
+	timeout time.Duration
+}
+
+func newFilesystemFreezer(freeze, thaw func(mountPath string) error, timeout time.Duration) *filesystemFreezer {
+	return &filesystemFreezer{
+		frozen:  map[string]*time.Timer{},
+		freeze:  freeze,
+		thaw:    thaw,
+		timeout: timeout,
+	}
+}
+
+// Freeze freezes the filesystem mounted at mountPath, the freeze of a frozen filesystem is renewed
+func (f *filesystemFreezer) Freeze(mountPath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if timer, ok := f.frozen[mountPath]; ok {
+		timer.Reset(f.timeout)
+		return nil
+	}
+	if err := f.freeze(mountPath); err != nil {
+		return err
+	}
+	f.frozen[mountPath] = time.AfterFunc(f.timeout, func() {
+		klog.Warningf("freeze of filesystem at %s was not renewed within %v, thawing it", mountPath, f.timeout)
+		if err := f.Thaw(mountPath); err != nil {
+			klog.Errorf("failed to thaw filesystem at %s: %v", mountPath, err)
+		}
+	})
+	return nil
+}
+
+// Thaw thaws the filesystem mounted at mountPath, it's a no-op if the filesystem is not frozen
+func (f *filesystemFreezer) Thaw(mountPath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	timer, ok := f.frozen[mountPath]
+	if !ok {
+		return nil
+	}
+	if err := f.thaw(mountPath); err != nil {
+		return err
+	}
+	timer.Stop()
+	delete(f.frozen, mountPath)
+	return nil
+}
+
+// FreezeFilesystem freezes the filesystem of the disk attached at the LUN in the request
+func (d *Driver) FreezeFilesystem(_ context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	mountPath, err := d.getMountPathWithLUN(req.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("FreezeFilesystem: freezing filesystem at %s (lun %s)", mountPath, req.GetValue())
+	if err := d.fsFreezer.Freeze(mountPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to freeze filesystem at %s: %v", mountPath, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// ThawFilesystem thaws the filesystem of the disk attached at the LUN in the request
+func (d *Driver) ThawFilesystem(_ context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	mountPath, err := d.getMountPathWithLUN(req.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("ThawFilesystem: thawing filesystem at %s (lun %s)", mountPath, req.GetValue())
+	if err := d.fsFreezer.Thaw(mountPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to thaw filesystem at %s: %v", mountPath, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// getMountPathWithLUN returns a mount point of the filesystem on the disk attached at lun
+func (d *Driver) getMountPathWithLUN(lun string) (string, error) {
+	if lun == "" {
+		return "", status.Error(codes.InvalidArgument, "lun must be provided")
+	}
+	devicePath, err := d.getDevicePathWithLUN("", lun)
+	if err != nil {
+		return "", status.Errorf(codes.NotFound, "could not find disk with lun %s: %v", lun, err)
+	}
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		devicePath = resolved
+	}
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to list mount points: %v", err)
+	}
+	for _, mp := range mountPoints {
+		if mp.Device == devicePath {
+			return mp.Path, nil
+		}
+	}
+	return "", status.Errorf(codes.FailedPrecondition, "device %s (lun %s) is not mounted", devicePath, lun)
+}
+
+// runFsFreezeServer serves the filesystem freeze service on port with mTLS until ctx is done, only the clients with
+// a certificate signed by ca.crt in the TLS cert dir are served since the service freezes any filesystem on the node
+func (d *Driver) runFsFreezeServer(ctx context.Context, port int64) error {
+	tlsConfig, err := newMutualTLSConfig(d.tlsCertDir)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on filesystem freeze port %d: %w", port, err)
+	}
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	s.RegisterService(&fsFreezeServiceDesc, d)
+	go func() {
+		<-ctx.Done()
+		s.GracefulStop()
+	}()
+	klog.V(2).Infof("filesystem freeze server listening on %s", listener.Addr())
+	if err := s.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return fmt.Errorf("filesystem freeze server stopped: %w", err)
+	}
+	return nil
+}
+
+// newFsFreezeClientTLSConfig returns the TLS config of the controller presenting tls.crt and tls.key in certDir to the
+// filesystem freeze server and verifying the certificate of the server issued for fsFreezeTLSServerName with ca.crt
+func newFsFreezeClientTLSConfig(certDir string) (*tls.Config, error) {
+	cert, rootCAs, err := loadCertificateAndCA(certDir)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      rootCAs,
+		ServerName:   fsFreezeTLSServerName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// freezeSourceVolume freezes the filesystem of the disk on the node it's attached to through the
+// filesystem freeze server of the node plugin, and returns a function thawing the filesystem. The freeze is
+// renewed until the function is called. Nothing is frozen if the disk is not attached.
+func (d *Driver) freezeSourceVolume(ctx context.Context, diskURI string) (func(), error) {
+	if d.fsFreezePort <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "fsFreeze requires the driver to run with --fs-freeze-port")
+	}
+	disk, err := d.checkDiskExists(ctx, diskURI)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "could not get disk %s: %v", diskURI, err)
+	}
+	if disk == nil || disk.ManagedBy == nil || *disk.ManagedBy == "" {
+		klog.V(2).Infof("disk %s is not attached, skip filesystem freeze", diskURI)
+		return func() {}, nil
+	}
+	nodeName, err := d.getCloud().VMSet.GetNodeNameByProviderID(ctx, *disk.ManagedBy)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get node name of %s: %v", *disk.ManagedBy, err)
+	}
+	diskName := ""
+	if disk.Name != nil {
+		diskName = *disk.Name
+	}
+	diskController, err := d.getNodeDiskController(ctx, nodeName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	lun, _, err := diskController.GetDiskLun(diskName, diskURI, nodeName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get lun of disk %s on node %s: %v", diskURI, nodeName, err)
+	}
+	address, err := d.getNodeInternalIP(ctx, string(nodeName))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get address of node %s: %v", nodeName, err)
+	}
+
+	tlsConfig, err := newFsFreezeClientTLSConfig(d.tlsCertDir)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not load certificates of filesystem freeze client: %v", err)
+	}
+	target := net.JoinHostPort(address, strconv.FormatInt(d.fsFreezePort, 10))
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not connect to filesystem freeze server %s: %v", target, err)
+	}
+	req := wrapperspb.String(strconv.Itoa(int(lun)))
+	freezeCtx, cancel := context.WithTimeout(ctx, fsFreezeRPCTimeout)
+	defer cancel()
+	if err := conn.Invoke(freezeCtx, fmt.Sprintf("/%s/FreezeFilesystem", fsFreezeServiceName), req, &emptypb.Empty{}); err != nil {
+		conn.Close()
+		return nil, status.Errorf(codes.Internal, "could not freeze filesystem of disk %s on node %s: %v", diskURI, nodeName, err)
+	}
+	klog.V(2).Infof("froze filesystem of disk %s (lun %d) on node %s", diskURI, lun, nodeName)
+
+	// the renewal in progress is completed before the thaw, so the filesystem is never frozen again after it
+	stopRenew := make(chan struct{})
+	renewStopped := make(chan struct{})
+	go func() {
+		defer close(renewStopped)
+		ticker := time.NewTicker(fsFreezeRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopRenew:
+				return
+			case <-ticker.C:
+				renewCtx, cancel := context.WithTimeout(context.Background(), fsFreezeRPCTimeout)
+				if err := conn.Invoke(renewCtx, fmt.Sprintf("/%s/FreezeFilesystem", fsFreezeServiceName), req, &emptypb.Empty{}); err != nil {
+					klog.Warningf("could not renew the freeze of filesystem of disk %s on node %s: %v", diskURI, nodeName, err)
+				}
+				cancel()
+			}
+		}
+	}()
+
+	return func() {
+		close(stopRenew)
+		<-renewStopped
+		defer conn.Close()
+		// thaw even if the snapshot request has been cancelled
+		thawCtx, cancel := context.WithTimeout(context.Background(), fsFreezeRPCTimeout)
+		defer cancel()
+		if err := conn.Invoke(thawCtx, fmt.Sprintf("/%s/ThawFilesystem", fsFreezeServiceName), req, &emptypb.Empty{}); err != nil {
+			klog.Errorf("could not thaw filesystem of disk %s on node %s, it will be thawed automatically within %v: %v", diskURI, nodeName, fsFreezeLease, err)
+			return
+		}
+		klog.V(2).Infof("thawed filesystem of disk %s (lun %d) on node %s", diskURI, lun, nodeName)
+	}, nil
+}
+
+func (d *Driver) getNodeInternalIP(ctx context.Context, nodeName string) (string, error) {
+	if d.kubeClient == nil {
+		return "", fmt.Errorf("kubeClient is nil")
+	}
+	node, err := d.kubeClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == v1.NodeInternalIP {
+			return addr.Address, nil
+		}
+	}
+	return "", fmt.Errorf("node %s has no internal IP", nodeName)
+}
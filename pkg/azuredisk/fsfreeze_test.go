@@ -0,0 +1,223 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+)
+
+func TestFilesystemFreezer(t *testing.T) {
+	var mu sync.Mutex
+	calls := []string{}
+	record := func(action string, err error) func(string) error {
+		return func(mountPath string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, action+" "+mountPath)
+			return err
+		}
+	}
+
+	f := newFilesystemFreezer(record("freeze", nil), record("thaw", nil), time.Hour)
+	assert.NoError(t, f.Thaw("/mnt/a"))
+	assert.NoError(t, f.Freeze("/mnt/a"))
+	assert.NoError(t, f.Freeze("/mnt/a"))
+	assert.NoError(t, f.Thaw("/mnt/a"))
+	assert.NoError(t, f.Thaw("/mnt/a"))
+	assert.Equal(t, []string{"freeze /mnt/a", "thaw /mnt/a"}, calls)
+	assert.Empty(t, f.frozen)
+
+	calls = []string{}
+	f = newFilesystemFreezer(record("freeze", fmt.Errorf("test")), record("thaw", nil), time.Hour)
+	assert.Error(t, f.Freeze("/mnt/a"))
+	assert.Empty(t, f.frozen)
+
+	calls = []string{}
+	f = newFilesystemFreezer(record("freeze", nil), record("thaw", nil), 10*time.Millisecond)
+	assert.NoError(t, f.Freeze("/mnt/b"))
+	assert.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.frozen) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"freeze /mnt/b", "thaw /mnt/b"}, calls)
+	mu.Unlock()
+
+	// the freeze is renewed by freezing the frozen filesystem again
+	f = newFilesystemFreezer(record("freeze", nil), record("thaw", nil), 300*time.Millisecond)
+	assert.NoError(t, f.Freeze("/mnt/c"))
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, f.Freeze("/mnt/c"))
+	time.Sleep(200 * time.Millisecond)
+	f.mu.Lock()
+	assert.Len(t, f.frozen, 1)
+	f.mu.Unlock()
+	assert.NoError(t, f.Thaw("/mnt/c"))
+}
+
+// newTestFsFreezeCertDir returns a directory with a CA and a certificate for both the filesystem freeze server and client
+func newTestFsFreezeCertDir(t *testing.T) string {
+	notAfter := time.Now().Add(time.Hour)
+	caPEM, _, ca, caKey := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test-ca"}, NotAfter: notAfter,
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}, nil, nil)
+	certPEM, keyPEM, _, _ := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "azuredisk"}, NotAfter: notAfter,
+		DNSNames:    []string{fsFreezeTLSServerName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	certDir := t.TempDir()
+	for name, data := range map[string][]byte{"ca.crt": caPEM, "tls.crt": certPEM, "tls.key": keyPEM} {
+		require.NoError(t, os.WriteFile(filepath.Join(certDir, name), data, 0600))
+	}
+	return certDir
+}
+
+type fakeFsFreezeServer struct {
+	requests []string
+}
+
+func (s *fakeFsFreezeServer) FreezeFilesystem(_ context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	s.requests = append(s.requests, "freeze "+req.GetValue())
+	return &emptypb.Empty{}, nil
+}
+
+func (s *fakeFsFreezeServer) ThawFilesystem(_ context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	s.requests = append(s.requests, "thaw "+req.GetValue())
+	if req.GetValue() == "" {
+		return nil, status.Error(codes.InvalidArgument, "lun must be provided")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func TestFsFreezeService(t *testing.T) {
+	certDir := newTestFsFreezeCertDir(t)
+	serverTLSConfig, err := newMutualTLSConfig(certDir)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fakeServer := &fakeFsFreezeServer{}
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	s.RegisterService(&fsFreezeServiceDesc, fakeServer)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	defer s.Stop()
+
+	// clients without a certificate signed by the CA are rejected
+	clientTLSConfig, err := newFsFreezeClientTLSConfig(certDir)
+	require.NoError(t, err)
+	noCertConn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		RootCAs:    clientTLSConfig.RootCAs,
+		ServerName: fsFreezeTLSServerName,
+		MinVersion: tls.VersionTLS12,
+	})))
+	require.NoError(t, err)
+	defer noCertConn.Close()
+	assert.Error(t, noCertConn.Invoke(context.Background(), fmt.Sprintf("/%s/FreezeFilesystem", fsFreezeServiceName), wrapperspb.String("1"), &emptypb.Empty{}))
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	assert.NoError(t, conn.Invoke(ctx, fmt.Sprintf("/%s/FreezeFilesystem", fsFreezeServiceName), wrapperspb.String("1"), &emptypb.Empty{}))
+	assert.NoError(t, conn.Invoke(ctx, fmt.Sprintf("/%s/ThawFilesystem", fsFreezeServiceName), wrapperspb.String("1"), &emptypb.Empty{}))
+	err = conn.Invoke(ctx, fmt.Sprintf("/%s/ThawFilesystem", fsFreezeServiceName), wrapperspb.String(""), &emptypb.Empty{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, []string{"freeze 1", "thaw 1", "thaw "}, fakeServer.requests)
+}
+
+func TestRunFsFreezeServer(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	// the service is never served without the certificates
+	d.tlsCertDir = t.TempDir()
+	assert.Error(t, d.runFsFreezeServer(context.Background(), 0))
+
+	// a listen error is returned instead of exiting
+	d.tlsCertDir = newTestFsFreezeCertDir(t)
+	assert.Error(t, d.runFsFreezeServer(context.Background(), -1))
+}
+
+func TestFreezeSourceVolume(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	_, err = d.freezeSourceVolume(context.Background(), testVolumeID)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	d.fsFreezePort = 9090
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(&armcompute.Disk{Name: ptr.To("disk")}, nil).Times(1)
+	thaw, err := d.freezeSourceVolume(context.Background(), testVolumeID)
+	assert.NoError(t, err)
+	assert.NotNil(t, thaw)
+
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("test")).Times(1)
+	_, err = d.freezeSourceVolume(context.Background(), testVolumeID)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestCreateSnapshotWithInvalidFsFreeze(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	req := &csi.CreateSnapshotRequest{
+		SourceVolumeId: testVolumeID,
+		Name:           "snapname",
+		Parameters:     map[string]string{"fsFreeze": "invalid"},
+	}
+	_, err = d.CreateSnapshot(context.Background(), req)
+	assert.Equal(t, status.Error(codes.InvalidArgument, "invalid fsFreeze: invalid in VolumeSnapshotClass"), err)
+}
@@ -23,6 +23,8 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 )
 
 const (
@@ -82,4 +84,20 @@ func TestGetPluginCapabilities(t *testing.T) {
 	resp, err := d.GetPluginCapabilities(context.Background(), &req)
 	assert.NoError(t, err)
 	assert.NotNil(t, resp)
+	assert.True(t, hasControllerServiceCapability(resp))
+
+	// the node plugin does not serve the controller service
+	d.setDriverRole(consts.DriverRoleNode)
+	resp, err = d.GetPluginCapabilities(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.False(t, hasControllerServiceCapability(resp))
+}
+
+func hasControllerServiceCapability(resp *csi.GetPluginCapabilitiesResponse) bool {
+	for _, capability := range resp.GetCapabilities() {
+		if capability.GetService().GetType() == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+			return true
+		}
+	}
+	return false
 }
@@ -57,13 +57,6 @@ func (f *DriverV2) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.Probe
 // GetPluginCapabilities returns the capabilities of the plugin
 func (f *DriverV2) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
 	capabilities := []*csi.PluginCapability{
-		{
-			Type: &csi.PluginCapability_Service_{
-				Service: &csi.PluginCapability_Service{
-					Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-				},
-			},
-		},
 		{
 			Type: &csi.PluginCapability_Service_{
 				Service: &csi.PluginCapability_Service{
@@ -80,6 +73,16 @@ func (f *DriverV2) GetPluginCapabilities(ctx context.Context, req *csi.GetPlugin
 		},
 	}
 
+	if f.servesController() {
+		capabilities = append(capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
+				},
+			},
+		})
+	}
+
 	if f.enableDiskOnlineResize {
 		pluginCapability := &csi.PluginCapability{
 			Type: &csi.PluginCapability_VolumeExpansion_{
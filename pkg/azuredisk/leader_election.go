@@ -0,0 +1,83 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	leaderElectionLeaseDuration = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 2 * time.Second
+)
+
+// getLeaderElectionLeaseName returns the name of the lease of the background loops of the controller, e.g.
+// disk-csi-azure-com-controller, which differs from the leases of the CSI sidecars
+func getLeaderElectionLeaseName(driverName string) string {
+	return strings.ReplaceAll(driverName, ".", "-") + "-controller"
+}
+
+// runWithLeaderElection runs run with a context canceled once the leadership is lost, the replica campaigns again
+// until ctx is done. run is called directly if leader election is disabled.
+func (d *Driver) runWithLeaderElection(ctx context.Context, run func(ctx context.Context)) {
+	if !d.leaderElectionOptions.Enable || d.kubeClient == nil {
+		run(ctx)
+		return
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		klog.Errorf("failed to get the identity of the leader election: %v", err)
+		return
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      getLeaderElectionLeaseName(d.Name),
+			Namespace: d.leaderElectionOptions.Namespace,
+		},
+		Client:     d.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaderElectionLeaseDuration,
+			RenewDeadline:   leaderElectionRenewDeadline,
+			RetryPeriod:     leaderElectionRetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            lock.LeaseMeta.Name,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					klog.V(2).Infof("%s became the leader of lease %s/%s, starting the background loops of the controller", identity, lock.LeaseMeta.Namespace, lock.LeaseMeta.Name)
+					run(ctx)
+				},
+				OnStoppedLeading: func() {
+					klog.V(2).Infof("%s is not the leader of lease %s/%s any more", identity, lock.LeaseMeta.Namespace, lock.LeaseMeta.Name)
+				},
+			},
+		})
+	}, leaderElectionRetryPeriod)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestGetLeaderElectionLeaseName(t *testing.T) {
+	assert.Equal(t, "disk-csi-azure-com-controller", getLeaderElectionLeaseName("disk.csi.azure.com"))
+}
+
+func TestRunWithLeaderElection(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	// the loops are run directly without leader election
+	ran := false
+	d.runWithLeaderElection(context.Background(), func(context.Context) { ran = true })
+	assert.True(t, ran)
+
+	d.kubeClient = fake.NewSimpleClientset()
+	d.leaderElectionOptions.Enable = true
+	d.leaderElectionOptions.Namespace = "kube-system"
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	leading := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.runWithLeaderElection(ctx, func(ctx context.Context) {
+			close(leading)
+			<-ctx.Done()
+		})
+	}()
+	select {
+	case <-leading:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the loops are not run by the leader")
+	}
+	lease, err := d.kubeClient.CoordinationV1().Leases("kube-system").Get(ctx, getLeaderElectionLeaseName(d.Name), metav1.GetOptions{})
+	require.NoError(t, err)
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	assert.Equal(t, hostname, ptr.Deref(lease.Spec.HolderIdentity, ""))
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("leader election is not stopped")
+	}
+}
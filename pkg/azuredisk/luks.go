@@ -0,0 +1,53 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+// luksMapperPrefix is the prefix of the device mapper names of the volumes encrypted on the node
+const luksMapperPrefix = "azuredisk-luks-"
+
+// luksMapperDir is the directory of the device mapper devices, a var for unit tests
+var luksMapperDir = "/dev/mapper"
+
+// getLUKSMapperName returns the device mapper name of the encrypted volume of diskURI, the hash of the disk URI
+// tells apart the disks of the same name in different resource groups
+func getLUKSMapperName(diskURI string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(diskURI)))
+	diskName, err := azureutils.GetDiskName(diskURI)
+	if err != nil {
+		return fmt.Sprintf("%s%x", luksMapperPrefix, sum[:8])
+	}
+	return fmt.Sprintf("%s%s-%x", luksMapperPrefix, diskName, sum[:4])
+}
+
+// getLUKSMapperPath returns the path of the device mapper device of name
+func getLUKSMapperPath(name string) string {
+	return filepath.Join(luksMapperDir, name)
+}
+
+// isLUKSMapperPath returns whether devicePath is the device mapper device of a volume encrypted on the node
+func isLUKSMapperPath(devicePath string) bool {
+	return filepath.Dir(devicePath) == luksMapperDir && strings.HasPrefix(filepath.Base(devicePath), luksMapperPrefix)
+}
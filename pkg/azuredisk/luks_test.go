@@ -0,0 +1,106 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func TestGetLUKSMapperName(t *testing.T) {
+	diskURI := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk"
+	name := getLUKSMapperName(diskURI)
+	assert.True(t, strings.HasPrefix(name, luksMapperPrefix+"disk-"), name)
+	assert.Equal(t, name, getLUKSMapperName(strings.ToUpper(diskURI[:1])+diskURI[1:]))
+	assert.NotEqual(t, name, getLUKSMapperName(strings.Replace(diskURI, "/rg/", "/rg2/", 1)))
+	assert.True(t, strings.HasPrefix(getLUKSMapperName("invalid"), luksMapperPrefix))
+
+	assert.True(t, isLUKSMapperPath(getLUKSMapperPath(name)))
+	assert.False(t, isLUKSMapperPath("/dev/mapper/other"))
+	assert.False(t, isLUKSMapperPath("/dev/sdc"))
+}
+
+func TestNodeStageVolumeHostEncryption(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+	secrets := map[string]string{consts.LUKSPassphraseSecretKey: "key"}
+	tests := []struct {
+		desc          string
+		capability    *csi.VolumeCapability
+		volumeContext map[string]string
+		secrets       map[string]string
+	}{
+		{
+			desc:          "invalid hostEncryption",
+			capability:    mountCapability,
+			volumeContext: map[string]string{"hostEncryption": "yes"},
+			secrets:       secrets,
+		},
+		{
+			desc:          "block volume",
+			capability:    blockCapability,
+			volumeContext: map[string]string{"hostEncryption": "true"},
+			secrets:       secrets,
+		},
+		{
+			desc:          "partition",
+			capability:    mountCapability,
+			volumeContext: map[string]string{"hostEncryption": "true", consts.VolumeAttributePartition: "1"},
+			secrets:       secrets,
+		},
+		{
+			desc:          "no passphrase",
+			capability:    mountCapability,
+			volumeContext: map[string]string{"hostEncryption": "true"},
+		},
+	}
+	for _, test := range tests {
+		_, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          "vol_1",
+			StagingTargetPath: "/tmp/staging",
+			VolumeCapability:  test.capability,
+			VolumeContext:     test.volumeContext,
+			PublishContext:    map[string]string{consts.LUN: "1"},
+			Secrets:           test.secrets,
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%s: %v", test.desc, err)
+	}
+}
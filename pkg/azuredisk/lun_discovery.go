@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+const (
+	lunDiscoveryPhaseRescan     = "rescan"
+	lunDiscoveryPhaseWaitForLun = "wait_for_lun"
+)
+
+var (
+	// lunDiscoveryDuration records how long it takes to find the device of a disk attached at a lun on the node,
+	// slow lun appearance is the dominant cause of long pod start time
+	lunDiscoveryDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      consts.AzureDiskCSIDriverName,
+			Name:           "lun_discovery_duration_seconds",
+			Help:           "Latency of discovering the device of a disk attached at a lun on the node",
+			Buckets:        []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"phase", "result"},
+	)
+	// lunDiscoveryRetries records the number of retries of finding the device of a disk attached at a lun
+	lunDiscoveryRetries = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace:      consts.AzureDiskCSIDriverName,
+			Name:           "lun_discovery_retries_total",
+			Help:           "Number of retries of finding the device of a disk attached at a lun on the node",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(lunDiscoveryDuration)
+	legacyregistry.MustRegister(lunDiscoveryRetries)
+}
+
+// waitForDevicePathWithLUN rescans the scsi hosts and waits until the device of the disk attached at lun appears,
+// the duration of each phase and the number of retries are recorded without per volume labels to bound the cardinality
+func waitForDevicePathWithLUN(volumeID, lunStr string, io azureutils.IOHandler, m *mount.SafeFormatAndMount) (string, error) {
+	lun, err := azureutils.GetDiskLUN(lunStr)
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	// a failed rescan is only recorded, the device may still appear if the disk was attached before the rescan
+	rescanResult := "succeeded"
+	if err := scsiHostRescan(io, m); err != nil {
+		klog.Warningf("rescan for volume %s failed with %v", volumeID, err)
+		rescanResult = "failed"
+	}
+	lunDiscoveryDuration.WithLabelValues(lunDiscoveryPhaseRescan, rescanResult).Observe(time.Since(start).Seconds())
+
+	start = time.Now()
+	attempts := 0
+	newDevicePath := ""
+	err = wait.PollImmediate(1*time.Second, 2*time.Minute, func() (bool, error) {
+		attempts++
+		var err error
+		if newDevicePath, err = findDiskByLun(int(lun), io, m); err != nil {
+			return false, fmt.Errorf("azureDisk - findDiskByLun(%v) failed with error(%s)", lun, err)
+		}
+
+		// did we find it?
+		if newDevicePath != "" {
+			return true, nil
+		}
+		// wait until timeout
+		return false, nil
+	})
+	if err == nil && newDevicePath == "" {
+		err = fmt.Errorf("azureDisk - findDiskByLun(%v) failed within timeout", lun)
+	}
+
+	latency := time.Since(start)
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+	}
+	lunDiscoveryDuration.WithLabelValues(lunDiscoveryPhaseWaitForLun, result).Observe(latency.Seconds())
+	if attempts > 1 {
+		lunDiscoveryRetries.Add(float64(attempts - 1))
+	}
+	klog.V(4).Infof("found device %s of volume %s on lun %d in %v after %d attempts, error: %v", newDevicePath, volumeID, lun, latency, attempts, err)
+	return newDevicePath, err
+}
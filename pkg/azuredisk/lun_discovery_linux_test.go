@@ -0,0 +1,78 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/component-base/metrics/testutil"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+)
+
+// failingRescanIOHandler fails to read the scsi hosts so that the rescan fails
+type failingRescanIOHandler struct {
+	azureutils.IOHandler
+}
+
+func (h *failingRescanIOHandler) ReadDir(dirname string) ([]os.DirEntry, error) {
+	if dirname == "/sys/class/scsi_host/" {
+		return nil, fmt.Errorf("permission denied")
+	}
+	return h.IOHandler.ReadDir(dirname)
+}
+
+func TestWaitForDevicePathWithLUNMetrics(t *testing.T) {
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	volumeID := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/lun-discovery"
+	getCount := func(phase, result string) uint64 {
+		count, err := testutil.GetHistogramMetricCount(lunDiscoveryDuration.WithLabelValues(phase, result))
+		require.NoError(t, err)
+		return count
+	}
+	rescanSucceeded := getCount(lunDiscoveryPhaseRescan, "succeeded")
+	rescanFailed := getCount(lunDiscoveryPhaseRescan, "failed")
+	waitSucceeded := getCount(lunDiscoveryPhaseWaitForLun, "succeeded")
+	retries, err := testutil.GetCounterMetricValue(lunDiscoveryRetries)
+	require.NoError(t, err)
+
+	_, err = waitForDevicePathWithLUN(volumeID, "invalid", azureutils.NewFakeIOHandler(), fakeMounter)
+	assert.Error(t, err)
+	assert.Equal(t, rescanSucceeded, getCount(lunDiscoveryPhaseRescan, "succeeded"))
+
+	devicePath, err := waitForDevicePathWithLUN(volumeID, "1", azureutils.NewFakeIOHandler(), fakeMounter)
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/sdd", devicePath)
+	assert.Equal(t, rescanSucceeded+1, getCount(lunDiscoveryPhaseRescan, "succeeded"))
+	assert.Equal(t, waitSucceeded+1, getCount(lunDiscoveryPhaseWaitForLun, "succeeded"))
+	// the device is found in the first attempt
+	newRetries, err := testutil.GetCounterMetricValue(lunDiscoveryRetries)
+	require.NoError(t, err)
+	assert.Equal(t, retries, newRetries)
+
+	// a failed rescan is recorded, the device is still found
+	devicePath, err = waitForDevicePathWithLUN(volumeID, "1", &failingRescanIOHandler{IOHandler: azureutils.NewFakeIOHandler()}, fakeMounter)
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/sdd", devicePath)
+	assert.Equal(t, rescanFailed+1, getCount(lunDiscoveryPhaseRescan, "failed"))
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+const mutationBudgetLeasePrefix = "azuredisk-mutation-"
+
+var (
+	// mutationBudgetLeaseDuration is the duration of a lease not renewed before it could be taken by another holder,
+	// so that a slot held by a crashed controller is released
+	mutationBudgetLeaseDuration = 60 * time.Second
+	// mutationBudgetPollInterval is the initial interval of retrying to acquire a slot while all slots are held,
+	// it's doubled with jitter on every retry up to mutationBudgetMaxPollInterval
+	mutationBudgetPollInterval    = time.Second
+	mutationBudgetMaxPollInterval = 10 * time.Second
+	// mutationBudgetReleaseTimeout bounds the release of a lease, so that a slow API server does not hold up the
+	// operation which completed, an unreleased lease is taken by another holder after it expires
+	mutationBudgetReleaseTimeout = 10 * time.Second
+)
+
+// mutationBudget limits the concurrent ARM disk and VM mutations per subscription across all driver installations
+// sharing the leases, e.g. controllers of multiple clusters pointing to the same hub cluster. Each subscription has
+// a fixed number of Lease objects, an operation holds one of them while it's in progress. The leases are read from
+// an informer cache of the namespace, only the attempts to take a free lease go to the API server.
+type mutationBudget struct {
+	kubeClient clientset.Interface
+	namespace  string
+	size       int
+	// identity is the prefix of the holder identities of this controller
+	identity string
+
+	informerFactory   informers.SharedInformerFactory
+	leaseLister       coordinationlisters.LeaseLister
+	leaseListerSynced cache.InformerSynced
+}
+
+func newMutationBudget(kubeClient clientset.Interface, namespace string, size int, identity string) *mutationBudget {
+	factory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 0, informers.WithNamespace(namespace))
+	informer := factory.Coordination().V1().Leases()
+	return &mutationBudget{
+		kubeClient:        kubeClient,
+		namespace:         namespace,
+		size:              size,
+		identity:          identity,
+		informerFactory:   factory,
+		leaseLister:       informer.Lister(),
+		leaseListerSynced: informer.Informer().HasSynced,
+	}
+}
+
+// Start starts the lease informer, the leases are read from the API server until it's synced
+func (b *mutationBudget) Start(ctx context.Context) {
+	b.informerFactory.Start(ctx.Done())
+}
+
+// Acquire waits until a slot of subscriptionID is acquired, the returned function must be called to release the slot.
+// The lease of the slot is renewed until it's released. Waiters start from a random slot and retry with jittered
+// exponential backoff, so that they do not all compete for the first slots at the same time.
+func (b *mutationBudget) Acquire(ctx context.Context, subscriptionID string) (func(), error) {
+	holder := fmt.Sprintf("%s-%s", b.identity, uuid.NewUUID())
+	backoff := wait.Backoff{
+		Duration: mutationBudgetPollInterval,
+		Factor:   2,
+		Jitter:   1,
+		Steps:    math.MaxInt32,
+		Cap:      mutationBudgetMaxPollInterval,
+	}
+	start := rand.Intn(b.size)
+	for {
+		for i := 0; i < b.size; i++ {
+			name := fmt.Sprintf("%s%s-%d", mutationBudgetLeasePrefix, strings.ToLower(subscriptionID), (start+i)%b.size)
+			acquired, err := b.tryAcquire(ctx, name, holder)
+			if err != nil {
+				klog.Warningf("failed to acquire mutation budget lease %s/%s: %v", b.namespace, name, err)
+				continue
+			}
+			if acquired {
+				klog.V(4).Infof("acquired mutation budget lease %s/%s as %s", b.namespace, name, holder)
+				return b.keepAlive(name, holder), nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("all %d mutation slots of subscription %s are in use: %w", b.size, subscriptionID, ctx.Err())
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+// tryAcquire takes the lease of name if it's free or expired, conflicts with other holders are not errors
+func (b *mutationBudget) tryAcquire(ctx context.Context, name, holder string) (bool, error) {
+	now := metav1.NewMicroTime(time.Now())
+	leases := b.kubeClient.CoordinationV1().Leases(b.namespace)
+	lease, err := b.getLease(ctx, name)
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: b.namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(holder),
+				LeaseDurationSeconds: ptr.To(int32(mutationBudgetLeaseDuration.Seconds())),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if !isLeaseFree(lease, now.Time) {
+		return false, nil
+	}
+	// the lease from the informer cache is shared, a stale one fails the update with a conflict
+	lease = lease.DeepCopy()
+	lease.Spec.HolderIdentity = ptr.To(holder)
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(mutationBudgetLeaseDuration.Seconds()))
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		if apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// getLease returns the lease of name from the informer cache, or from the API server if the cache is not synced yet
+func (b *mutationBudget) getLease(ctx context.Context, name string) (*coordinationv1.Lease, error) {
+	if b.leaseListerSynced() {
+		return b.leaseLister.Leases(b.namespace).Get(name)
+	}
+	return b.kubeClient.CoordinationV1().Leases(b.namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// keepAlive renews the lease of name held by holder until the returned function is called, which releases the lease
+func (b *mutationBudget) keepAlive(name, holder string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(mutationBudgetLeaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := b.update(ctx, name, holder, func(lease *coordinationv1.Lease) {
+					lease.Spec.RenewTime = ptr.To(metav1.NewMicroTime(time.Now()))
+				}); err != nil {
+					// the slot could be taken by another holder once the lease expires, so that more mutations than
+					// the budget run concurrently
+					klog.Errorf("failed to renew mutation budget lease %s/%s: %v", b.namespace, name, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+		ctx, cancel := context.WithTimeout(context.Background(), mutationBudgetReleaseTimeout)
+		defer cancel()
+		if err := b.update(ctx, name, holder, func(lease *coordinationv1.Lease) {
+			lease.Spec.HolderIdentity = nil
+		}); err != nil {
+			// the lease is taken by others after it expires
+			klog.Warningf("failed to release mutation budget lease %s/%s: %v", b.namespace, name, err)
+		}
+	}
+}
+
+// update updates the lease of name with mutate if it's still held by holder
+func (b *mutationBudget) update(ctx context.Context, name, holder string, mutate func(*coordinationv1.Lease)) error {
+	leases := b.kubeClient.CoordinationV1().Leases(b.namespace)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != holder {
+		return fmt.Errorf("lease is held by %q", ptr.Deref(lease.Spec.HolderIdentity, ""))
+	}
+	mutate(lease)
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// isLeaseFree returns true if the lease has no holder or it's not renewed within its duration
+func isLeaseFree(lease *coordinationv1.Lease, now time.Time) bool {
+	if ptr.Deref(lease.Spec.HolderIdentity, "") == "" || lease.Spec.RenewTime == nil {
+		return true
+	}
+	duration := time.Duration(ptr.Deref(lease.Spec.LeaseDurationSeconds, 0)) * time.Second
+	return lease.Spec.RenewTime.Add(duration).Before(now)
+}
+
+// acquireMutationBudget waits for a slot of the subscription of the disk or VM mutation, it returns a no-op release
+// function if the budget is disabled, and ResourceExhausted if no slot is free before ctx is done
+func (d *DriverCore) acquireMutationBudget(ctx context.Context, subscriptionID string) (func(), error) {
+	if d.mutationBudget == nil {
+		return func() {}, nil
+	}
+	if subscriptionID == "" {
+		subscriptionID = d.getCloud().SubscriptionID
+	}
+	release, err := d.mutationBudget.Acquire(ctx, subscriptionID)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return release, nil
+}
+
+// getDiskSubscriptionID returns the subscription of diskURI, or the subscription of the cluster if diskURI has none
+func (d *DriverCore) getDiskSubscriptionID(diskURI string) string {
+	if subscriptionID := azureutils.GetSubscriptionIDFromURI(diskURI); subscriptionID != "" {
+		return subscriptionID
+	}
+	return d.getCloud().SubscriptionID
+}
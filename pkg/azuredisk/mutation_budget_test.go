@@ -0,0 +1,150 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestMutationBudget(t *testing.T) {
+	pollInterval := mutationBudgetPollInterval
+	mutationBudgetPollInterval = 10 * time.Millisecond
+	defer func() { mutationBudgetPollInterval = pollInterval }()
+
+	kubeClient := fake.NewSimpleClientset()
+	budget := newMutationBudget(kubeClient, "kube-system", 2, "controller")
+	ctx := context.Background()
+
+	release1, err := budget.Acquire(ctx, "SUB")
+	require.NoError(t, err)
+	release2, err := budget.Acquire(ctx, "sub")
+	require.NoError(t, err)
+	leases, err := kubeClient.CoordinationV1().Leases("kube-system").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, leases.Items, 2)
+
+	// all slots of the subscription are held
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = budget.Acquire(timeoutCtx, "sub")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// slots of other subscriptions are not shared
+	release3, err := budget.Acquire(ctx, "other")
+	require.NoError(t, err)
+	release3()
+
+	// a released slot could be acquired
+	release1()
+	leases, err = kubeClient.CoordinationV1().Leases("kube-system").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	held := 0
+	for _, lease := range leases.Items {
+		if lease.Spec.HolderIdentity != nil {
+			held++
+		}
+	}
+	assert.Equal(t, 1, held, "the released slots are free")
+	release4, err := budget.Acquire(ctx, "sub")
+	require.NoError(t, err)
+	release4()
+	release2()
+}
+
+func TestMutationBudgetLeaseInformer(t *testing.T) {
+	pollInterval := mutationBudgetPollInterval
+	mutationBudgetPollInterval = 10 * time.Millisecond
+	defer func() { mutationBudgetPollInterval = pollInterval }()
+
+	kubeClient := fake.NewSimpleClientset()
+	budget := newMutationBudget(kubeClient, "kube-system", 2, "controller")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	budget.Start(ctx)
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), budget.leaseListerSynced))
+
+	release1, err := budget.Acquire(ctx, "sub")
+	require.NoError(t, err)
+	release2, err := budget.Acquire(ctx, "sub")
+	require.NoError(t, err)
+	// the held leases are read from the informer cache while waiting for a slot
+	require.Eventually(t, func() bool {
+		leases, err := budget.leaseLister.Leases("kube-system").List(labels.Everything())
+		return err == nil && len(leases) == 2
+	}, time.Second, 10*time.Millisecond)
+	kubeClient.ClearActions()
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer timeoutCancel()
+	_, err = budget.Acquire(timeoutCtx, "sub")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	for _, action := range kubeClient.Actions() {
+		assert.NotEqual(t, "get", action.GetVerb(), "lease is read from the API server while waiting for a slot")
+	}
+
+	release1()
+	release3, err := budget.Acquire(ctx, "sub")
+	require.NoError(t, err)
+	release3()
+	release2()
+}
+
+func TestIsLeaseFree(t *testing.T) {
+	now := time.Now()
+	assert.True(t, isLeaseFree(&coordinationv1.Lease{}, now))
+	assert.True(t, isLeaseFree(&coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{HolderIdentity: ptr.To("")}}, now))
+	held := &coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{
+		HolderIdentity:       ptr.To("controller"),
+		LeaseDurationSeconds: ptr.To(int32(60)),
+		RenewTime:            ptr.To(metav1.NewMicroTime(now.Add(-30 * time.Second))),
+	}}
+	assert.False(t, isLeaseFree(held, now))
+	// the lease of a crashed controller expires
+	assert.True(t, isLeaseFree(held, now.Add(time.Minute)))
+}
+
+func TestAcquireMutationBudget(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	core := &DriverCore{cloud: provider.GetTestCloud(cntl)}
+
+	release, err := core.acquireMutationBudget(context.Background(), "")
+	require.NoError(t, err)
+	release()
+
+	core.mutationBudget = newMutationBudget(fake.NewSimpleClientset(), "kube-system", 1, "controller")
+	release, err = core.acquireMutationBudget(context.Background(), "")
+	require.NoError(t, err)
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = core.acquireMutationBudget(ctx, core.getDiskSubscriptionID("/subscriptions/"+core.getCloud().SubscriptionID+"/resourceGroups/rg/providers/Microsoft.Compute/disks/disk"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+var (
+	// nodeDiskThroughputTimeout is the maximum time of refreshing the disk throughput annotations of a node
+	nodeDiskThroughputTimeout = 2 * time.Minute
+	// nodeDiskThroughputRefreshDelay is the time the refreshes requested by the attaches and detaches on a node are
+	// coalesced into one refresh
+	nodeDiskThroughputRefreshDelay = 5 * time.Second
+)
+
+// nodeDiskThroughputRefresh is the state of the refreshes of a node, at most one refresh runs per node
+type nodeDiskThroughputRefresh struct {
+	sync.Mutex
+	running bool
+	pending bool
+}
+
+// refreshNodeDiskThroughputAnnotations refreshes the disk throughput annotations of the node in the background after a
+// disk is attached or detached. The requests within nodeDiskThroughputRefreshDelay and while a refresh is running are
+// coalesced into one more refresh of the node. Failures are only logged since the annotations are scheduling hints.
+func (d *DriverCore) refreshNodeDiskThroughputAnnotations(nodeName types.NodeName) {
+	if !d.enableDiskThroughputHints || d.kubeClient == nil {
+		return
+	}
+	v, _ := d.nodeDiskThroughputRefreshes.LoadOrStore(strings.ToLower(string(nodeName)), &nodeDiskThroughputRefresh{})
+	refresh := v.(*nodeDiskThroughputRefresh)
+	refresh.Lock()
+	defer refresh.Unlock()
+	refresh.pending = true
+	if refresh.running {
+		return
+	}
+	refresh.running = true
+	go func() {
+		for {
+			time.Sleep(nodeDiskThroughputRefreshDelay)
+			refresh.Lock()
+			if !refresh.pending {
+				refresh.running = false
+				refresh.Unlock()
+				return
+			}
+			refresh.pending = false
+			refresh.Unlock()
+
+			ctx, cancel := context.WithTimeout(context.Background(), nodeDiskThroughputTimeout)
+			if err := d.updateNodeDiskThroughputAnnotations(ctx, nodeName); err != nil {
+				klog.Warningf("could not update disk throughput annotations of node(%s): %v", nodeName, err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// updateNodeDiskThroughputAnnotations writes the remaining disk IOPS and bandwidth of the node into its annotations,
+// which are the uncached disk limits of the VM size minus the provisioned performance of the attached data disks.
+// Nodes of VM sizes not in the sku map are not annotated.
+func (d *DriverCore) updateNodeDiskThroughputAnnotations(ctx context.Context, nodeName types.NodeName) error {
+	node, err := d.kubeClient.CoreV1().Nodes().Get(ctx, string(nodeName), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get node(%s) failed with %w", nodeName, err)
+	}
+	instanceType := node.Labels[v1.LabelInstanceTypeStable]
+	vmSku, ok := optimization.NodeInfoMap[strings.ToLower(instanceType)]
+	if !ok {
+		klog.V(4).Infof("VM size %q of node(%s) is not in the sku map, skip disk throughput annotations", instanceType, nodeName)
+		return nil
+	}
+
+	diskController, err := d.getNodeDiskController(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+	dataDisks, _, err := diskController.GetNodeDataDisks(nodeName, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return fmt.Errorf("get data disks of node(%s) failed with %w", nodeName, err)
+	}
+	remainingIops, remainingBwMbps := vmSku.MaxIops, vmSku.MaxBwMbps
+	for _, dataDisk := range dataDisks {
+		if dataDisk == nil || dataDisk.ManagedDisk == nil || dataDisk.ManagedDisk.ID == nil {
+			continue
+		}
+		iops, bwMbps, err := d.getProvisionedDiskPerformance(ctx, *dataDisk.ManagedDisk.ID)
+		if err != nil {
+			return err
+		}
+		remainingIops -= iops
+		remainingBwMbps -= bwMbps
+	}
+
+	annotations := map[string]string{
+		consts.NodeRemainingDiskIOPSAnnotation: strconv.Itoa(max(remainingIops, 0)),
+		consts.NodeRemainingDiskMBpsAnnotation: strconv.Itoa(max(remainingBwMbps, 0)),
+	}
+	changed := map[string]string{}
+	for k, v := range annotations {
+		if node.Annotations[k] != v {
+			changed[k] = v
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": changed},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := d.kubeClient.CoreV1().Nodes().Patch(ctx, string(nodeName), types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("patch node(%s) failed with %w", nodeName, err)
+	}
+	klog.V(2).Infof("updated disk throughput annotations %v on node(%s)", changed, nodeName)
+	return nil
+}
+
+// getProvisionedDiskPerformance returns the provisioned IOPS and bandwidth of the disk
+func (d *DriverCore) getProvisionedDiskPerformance(ctx context.Context, diskURI string) (int, int, error) {
+	diskName, err := azureutils.GetDiskName(diskURI)
+	if err != nil {
+		return 0, 0, err
+	}
+	resourceGroup, err := azureutils.GetResourceGroupFromURI(diskURI)
+	if err != nil {
+		return 0, 0, err
+	}
+	diskClient, err := d.getDiskController().getClientFactory().GetDiskClientForSub(azureutils.GetSubscriptionIDFromURI(diskURI))
+	if err != nil {
+		return 0, 0, err
+	}
+	disk, err := diskClient.Get(ctx, resourceGroup, diskName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("get disk(%s) failed with %w", diskURI, err)
+	}
+	if disk.SKU == nil || disk.SKU.Name == nil || disk.Properties == nil {
+		return 0, 0, fmt.Errorf("sku or properties of disk(%s) is empty", diskURI)
+	}
+	return optimization.GetDiskPerformance(string(*disk.SKU.Name), int(ptr.Deref(disk.Properties.DiskSizeGB, 0)),
+		int(ptr.Deref(disk.Properties.DiskIOPSReadWrite, 0)), int(ptr.Deref(disk.Properties.DiskMBpsReadWrite, 0)))
+}
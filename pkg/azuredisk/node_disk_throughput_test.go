@@ -0,0 +1,155 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/vmclient/mockvmclient"
+)
+
+func TestUpdateNodeDiskThroughputAnnotations(t *testing.T) {
+	tests := []struct {
+		desc                string
+		instanceType        string
+		expectedAnnotations map[string]string
+	}{
+		{
+			desc:         "remaining disk throughput of the VM size minus the attached disks",
+			instanceType: "Standard_D4s_v3",
+			expectedAnnotations: map[string]string{
+				consts.NodeRemainingDiskIOPSAnnotation: "5400",
+				consts.NodeRemainingDiskMBpsAnnotation: "0",
+			},
+		},
+		{
+			desc:         "VM size not in the sku map is not annotated",
+			instanceType: "Standard_Unknown",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cntl := gomock.NewController(t)
+			defer cntl.Finish()
+			d, err := newFakeDriverV1(cntl)
+			assert.NoError(t, err)
+			nodeName := "vm1"
+			kubeClient := fake.NewSimpleClientset(&v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: nodeName, Labels: map[string]string{v1.LabelInstanceTypeStable: test.instanceType}},
+			})
+			d.kubeClient = kubeClient
+
+			diskURIs := []string{
+				"/subscriptions/subscription/resourceGroups/rg/providers/Microsoft.Compute/disks/disk1",
+				"/subscriptions/subscription/resourceGroups/rg/providers/Microsoft.Compute/disks/disk2",
+			}
+			vm := compute.VirtualMachine{
+				Name: &nodeName,
+				VirtualMachineProperties: &compute.VirtualMachineProperties{
+					StorageProfile: &compute.StorageProfile{
+						DataDisks: &[]compute.DataDisk{
+							{Lun: ptr.To(int32(0)), Name: ptr.To("disk1"), ManagedDisk: &compute.ManagedDiskParameters{ID: &diskURIs[0]}},
+							{Lun: ptr.To(int32(1)), Name: ptr.To("disk2"), ManagedDisk: &compute.ManagedDiskParameters{ID: &diskURIs[1]}},
+						},
+					},
+				},
+			}
+			mockVMsClient := d.getCloud().VirtualMachinesClient.(*mockvmclient.MockInterface)
+			mockVMsClient.EXPECT().Get(gomock.Any(), gomock.Any(), nodeName, gomock.Any()).Return(vm, nil).AnyTimes()
+
+			diskClient := mock_diskclient.NewMockInterface(cntl)
+			d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+			// a P10 disk and a PremiumV2 disk with provisioned performance
+			diskClient.EXPECT().Get(gomock.Any(), "rg", "disk1").Return(&armcompute.Disk{
+				SKU:        &armcompute.DiskSKU{Name: ptr.To(armcompute.DiskStorageAccountTypesPremiumLRS)},
+				Properties: &armcompute.DiskProperties{DiskSizeGB: ptr.To(int32(100))},
+			}, nil).AnyTimes()
+			diskClient.EXPECT().Get(gomock.Any(), "rg", "disk2").Return(&armcompute.Disk{
+				SKU: &armcompute.DiskSKU{Name: ptr.To(armcompute.DiskStorageAccountTypesPremiumV2LRS)},
+				Properties: &armcompute.DiskProperties{
+					DiskSizeGB:        ptr.To(int32(100)),
+					DiskIOPSReadWrite: ptr.To(int64(500)),
+					DiskMBpsReadWrite: ptr.To(int64(125)),
+				},
+			}, nil).AnyTimes()
+
+			assert.NoError(t, d.updateNodeDiskThroughputAnnotations(context.Background(), types.NodeName(nodeName)))
+			node, err := kubeClient.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedAnnotations, node.Annotations)
+		})
+	}
+}
+
+func TestRefreshNodeDiskThroughputAnnotationsDisabled(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+	kubeClient := fake.NewSimpleClientset()
+	d.kubeClient = kubeClient
+
+	d.refreshNodeDiskThroughputAnnotations(types.NodeName("vm1"))
+	assert.Empty(t, kubeClient.Actions())
+}
+
+func TestRefreshNodeDiskThroughputAnnotationsCoalesced(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	assert.NoError(t, err)
+	d.enableDiskThroughputHints = true
+	// nodes of unknown VM sizes are only read, so every refresh is one node GET
+	kubeClient := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "vm1", Labels: map[string]string{v1.LabelInstanceTypeStable: "Standard_Unknown"}},
+	})
+	d.kubeClient = kubeClient
+	defer func(delay time.Duration) { nodeDiskThroughputRefreshDelay = delay }(nodeDiskThroughputRefreshDelay)
+	nodeDiskThroughputRefreshDelay = 100 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		d.refreshNodeDiskThroughputAnnotations(types.NodeName("vm1"))
+	}
+	d.refreshNodeDiskThroughputAnnotations(types.NodeName("VM1"))
+	assert.Eventually(t, func() bool {
+		v, _ := d.nodeDiskThroughputRefreshes.Load("vm1")
+		refresh := v.(*nodeDiskThroughputRefresh)
+		refresh.Lock()
+		defer refresh.Unlock()
+		return !refresh.running
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, kubeClient.Actions(), 1)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+// nodePoolLabels are the labels of the node pool of a node, in order of precedence
+var nodePoolLabels = []string{"kubernetes.azure.com/agentpool", "agentpool"}
+
+// providerIDResourceGroupRegex matches the resource group of the VM in the provider ID of a node, e.g.
+// azure:///subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachineScaleSets/<vmss>/virtualMachines/<id>
+var providerIDResourceGroupRegex = regexp.MustCompile(`(?i)^azure:///subscriptions/[^/]+/resourceGroups/([^/]+)/providers/`)
+
+// parseNodeResourceGroupMap parses a comma separated list of <node pool>=<resource group> pairs
+func parseNodeResourceGroupMap(value string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		pool, resourceGroup, found := strings.Cut(pair, "=")
+		pool, resourceGroup = strings.TrimSpace(pool), strings.TrimSpace(resourceGroup)
+		if !found || pool == "" || resourceGroup == "" {
+			return nil, fmt.Errorf("invalid node resource group %q, expected <node pool>=<resource group>", pair)
+		}
+		result[strings.ToLower(pool)] = resourceGroup
+	}
+	return result, nil
+}
+
+// getNodeResourceGroup returns the resource group of the VM of the node, it's the resource group of the node pool
+// in the node resource group map, the resource group in the provider ID of the node or the resource group of the cloud.
+// The resource group of a node is cached since the VM of a node never moves to another resource group.
+func (d *DriverCore) getNodeResourceGroup(ctx context.Context, nodeName types.NodeName) (string, error) {
+	defaultResourceGroup := d.getCloud().ResourceGroup
+	if d.kubeClient == nil {
+		return defaultResourceGroup, nil
+	}
+	key := strings.ToLower(string(nodeName))
+	if resourceGroup, ok := d.nodeResourceGroupCache.Load(key); ok {
+		return resourceGroup.(string), nil
+	}
+	node, err := d.kubeClient.CoreV1().Nodes().Get(ctx, string(nodeName), metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get node %s failed with %w", nodeName, err)
+	}
+	resourceGroup := defaultResourceGroup
+	if matches := providerIDResourceGroupRegex.FindStringSubmatch(node.Spec.ProviderID); len(matches) == 2 {
+		resourceGroup = matches[1]
+	}
+	for _, label := range nodePoolLabels {
+		if pool, ok := node.Labels[label]; ok {
+			if poolResourceGroup, ok := d.nodeResourceGroups[strings.ToLower(pool)]; ok {
+				resourceGroup = poolResourceGroup
+			}
+			break
+		}
+	}
+	d.nodeResourceGroupCache.Store(key, resourceGroup)
+	return resourceGroup, nil
+}
+
+// getNodeDiskController returns the disk controller managing the VM of the node, the disk controllers of the resource
+// groups other than the one of the cloud are created on first use with the same settings as the disk controller of the
+// cloud, and dropped when the cloud config is reloaded. All the operations on the VM of a node must use this controller
+// so that they are serialized by the same lock of the VM.
+func (d *DriverCore) getNodeDiskController(ctx context.Context, nodeName types.NodeName) (*ManagedDiskController, error) {
+	resourceGroup, err := d.getNodeResourceGroup(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(resourceGroup, d.getCloud().ResourceGroup) {
+		return d.getDiskController(), nil
+	}
+	key := strings.ToLower(resourceGroup)
+	if diskController, ok := d.nodeResourceGroupDiskControllers.Load(key); ok {
+		return diskController.(*ManagedDiskController), nil
+	}
+	klog.V(2).Infof("node %s is in resource group %s, create its disk controller", nodeName, resourceGroup)
+	userAgent := GetUserAgent(d.Name, d.customUserAgent, d.userAgentSuffix)
+	cloud, err := azureutils.GetCloudProviderForResourceGroup(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, userAgent,
+		d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort, d.clientRateLimitOptions, resourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("create cloud of resource group %s failed with: %v", resourceGroup, err)
+	}
+	if cloud.ComputeClientFactory == nil {
+		return nil, fmt.Errorf("create cloud of resource group %s failed: no cloud config provided", resourceGroup)
+	}
+	diskController := d.newDiskController(cloud)
+	// the VM locks are shared with the disk controller of the cloud, so that the operations on a node still running on
+	// a controller dropped by a cloud config reload are serialized with the ones on the controller replacing it
+	diskController.lockMap = d.getDiskController().lockMap
+	actual, _ := d.nodeResourceGroupDiskControllers.LoadOrStore(key, diskController)
+	return actual.(*ManagedDiskController), nil
+}
@@ -0,0 +1,116 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseNodeResourceGroupMap(t *testing.T) {
+	result, err := parseNodeResourceGroupMap(" Pool1=rg1, pool2 = rg2,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"pool1": "rg1", "pool2": "rg2"}, result)
+
+	result, err = parseNodeResourceGroupMap("")
+	require.NoError(t, err)
+	assert.Empty(t, result)
+
+	for _, value := range []string{"pool1", "pool1=", "=rg1"} {
+		_, err = parseNodeResourceGroupMap(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestGetNodeResourceGroup(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+	defaultResourceGroup := d.getCloud().ResourceGroup
+
+	d.nodeResourceGroups = map[string]string{"byo": "byo-rg"}
+	d.kubeClient = fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "byo-node", Labels: map[string]string{"kubernetes.azure.com/agentpool": "BYO"}},
+			Spec:       v1.NodeSpec{ProviderID: "azure:///subscriptions/sub/resourceGroups/other-rg/providers/Microsoft.Compute/virtualMachines/byo-node"},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "vmss-node", Labels: map[string]string{"agentpool": "pool1"}},
+			Spec:       v1.NodeSpec{ProviderID: "azure:///subscriptions/sub/resourceGroups/vmss-rg/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines/0"},
+		},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "no-provider-id"}},
+	)
+
+	for nodeName, expected := range map[string]string{
+		"byo-node":       "byo-rg",
+		"vmss-node":      "vmss-rg",
+		"no-provider-id": defaultResourceGroup,
+	} {
+		resourceGroup, err := d.getNodeResourceGroup(ctx, types.NodeName(nodeName))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, resourceGroup, nodeName)
+	}
+	_, err = d.getNodeResourceGroup(ctx, "missing-node")
+	assert.Error(t, err)
+
+	// the resource group of a node is cached
+	require.NoError(t, d.kubeClient.CoreV1().Nodes().Delete(ctx, "vmss-node", metav1.DeleteOptions{}))
+	resourceGroup, err := d.getNodeResourceGroup(ctx, "VMSS-node")
+	assert.NoError(t, err)
+	assert.Equal(t, "vmss-rg", resourceGroup)
+}
+
+func TestGetNodeDiskController(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	d.kubeClient = fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "vmss-node"},
+		Spec:       v1.NodeSpec{ProviderID: "azure:///subscriptions/sub/resourceGroups/VMSS-RG/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines/0"},
+	})
+	vmssDiskController := &ManagedDiskController{}
+	d.nodeResourceGroupDiskControllers.Store("vmss-rg", vmssDiskController)
+
+	diskController, err := d.getNodeDiskController(ctx, "vmss-node")
+	require.NoError(t, err)
+	assert.Same(t, vmssDiskController, diskController)
+
+	// the disk controller of the cloud is not used for a node whose resource group is unknown
+	_, err = d.getNodeDiskController(ctx, "missing-node")
+	assert.Error(t, err)
+
+	// the disk controllers of the other resource groups are dropped when the cloud is reloaded
+	d.swapCloud(d.getCloud())
+	_, ok := d.nodeResourceGroupDiskControllers.Load("vmss-rg")
+	assert.False(t, ok)
+}
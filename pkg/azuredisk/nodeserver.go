@@ -22,8 +22,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
-	"time"
 
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
 	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
@@ -35,7 +35,6 @@ import (
 	"google.golang.org/grpc/status"
 
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/wait"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
@@ -84,20 +83,55 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	hostEncryption, err := azureutils.GetHostEncryption(params)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if hostEncryption {
+		if volumeCapability.GetBlock() != nil {
+			return nil, status.Error(codes.InvalidArgument, "hostEncryption is not supported for block volumes")
+		}
+		if _, ok := params[consts.VolumeAttributePartition]; ok {
+			return nil, status.Error(codes.InvalidArgument, "hostEncryption is not supported for a partition of the disk")
+		}
+		if req.GetSecrets()[consts.LUKSPassphraseSecretKey] == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s not provided in the node stage secret of hostEncryption", consts.LUKSPassphraseSecretKey)
+		}
+	}
+
 	if acquired := d.volumeLocks.TryAcquire(diskURI); !acquired {
 		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, diskURI)
 	}
 	defer d.volumeLocks.Release(diskURI)
 
-	lun, ok := req.PublishContext[consts.LUN]
+	publishContext := d.resolvePublishContext(diskURI, req.GetPublishContext())
+	lun, ok := publishContext[consts.LUN]
 	if !ok {
 		return nil, status.Error(codes.InvalidArgument, "lun not provided")
 	}
 
-	source, err := d.getDevicePathWithLUN(lun)
+	// the staging mount of an encrypted volume is from the mapper device, the key is rotated on the lun device below
+	if _, isBlock := volumeCapability.GetAccessType().(*csi.VolumeCapability_Block); !isBlock && !hostEncryption {
+		if _, ok := req.GetVolumeContext()[consts.VolumeAttributePartition]; !ok {
+			reused, err := d.reuseStagingMount(lun, target)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "%v", err)
+			}
+			if reused {
+				klog.V(2).Infof("NodeStageVolume: reuse existing staging mount of lun %s on target %s", lun, target)
+				d.cachePublishContext(diskURI, publishContext)
+				d.trackStagedMount(diskURI, params, target)
+				d.trackTunedMount(diskURI, params, target)
+				return &csi.NodeStageVolumeResponse{}, nil
+			}
+		}
+	}
+
+	source, err := d.getDevicePathWithLUN(diskURI, lun)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to find disk on lun %s. %v", lun, err)
 	}
+	d.cachePublishContext(diskURI, publishContext)
 
 	// If perf optimizations are enabled
 	// tweak device settings to enhance performance
@@ -117,9 +151,20 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		}
 	}
 
+	if hostEncryption {
+		mapperName := getLUKSMapperName(diskURI)
+		klog.V(2).Infof("NodeStageVolume: opening %s as encrypted device %s", source, mapperName)
+		if source, err = setupLUKSDevice(source, mapperName, req.GetSecrets()[consts.LUKSPassphraseSecretKey],
+			req.GetSecrets()[consts.LUKSPreviousPassphraseSecretKey], d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not set up encryption of %s(lun: %s): %v", diskURI, lun, err)
+		}
+	}
+
 	// If the access type is block, do nothing for stage
 	switch req.GetVolumeCapability().GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
+		d.trackStagedVolume(diskURI, params, "", source)
+		d.trackTunedVolume(diskURI, params, source)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -129,6 +174,8 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 	}
 	if mnt {
 		klog.V(2).Infof("NodeStageVolume: already mounted on target %s", target)
+		d.trackStagedVolume(diskURI, params, target, source)
+		d.trackTunedVolume(diskURI, params, source)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -147,6 +194,30 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		// respect "fstype" setting in storage class parameters
 		fstype = volContextFSType
 	}
+	reservedBlocksPercentage, err := azureutils.GetReservedBlocksPercentage(req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// logical sector size is in volume context of dynamically provisioned volumes or in publish context
+	logicalSectorSize, err := azureutils.GetLogicalSectorSize(req.GetVolumeContext())
+	if err == nil && logicalSectorSize == 0 {
+		logicalSectorSize, err = azureutils.GetLogicalSectorSize(publishContext)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// mkfs options and allocation unit size only apply when the volume is formatted at first stage
+	mkfsOptions, err := azureutils.GetMkfsOptions(req.GetVolumeContext(), fstype)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	allocationUnitSize, err := azureutils.GetAllocationUnitSize(req.GetVolumeContext(), fstype)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if allocationUnitSize > 0 {
+		options = append(options, fmt.Sprintf("%s=%d", consts.AllocationUnitSizeMountOption, allocationUnitSize))
+	}
 
 	// If partition is specified, should mount it only instead of the entire disk.
 	if partition, ok := req.GetVolumeContext()[consts.VolumeAttributePartition]; ok {
@@ -155,11 +226,22 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 
 	// FormatAndMount will format only if needed
 	klog.V(2).Infof("NodeStageVolume: formatting %s and mounting at %s with mount options(%s)", source, target, options)
-	if err := d.formatAndMount(source, target, fstype, options); err != nil {
+	if err := d.formatAndMount(source, target, fstype, options, logicalSectorSize, mkfsOptions); err != nil {
+		// the format options not supported by the mounter
+		if status.Code(err) == codes.InvalidArgument {
+			return nil, err
+		}
 		return nil, status.Errorf(codes.Internal, "could not format %s(lun: %s), and mount it at %s, failed with %v", source, lun, target, err)
 	}
 	klog.V(2).Infof("NodeStageVolume: format %s and mounting at %s successfully.", source, target)
 
+	if reservedBlocksPercentage != "" && strings.HasPrefix(fstype, "ext") {
+		klog.V(2).Infof("NodeStageVolume: setting reserved blocks percentage of %s to %s", source, reservedBlocksPercentage)
+		if err := setReservedBlocksPercentage(source, reservedBlocksPercentage, d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not set reserved blocks percentage of %s: %v", source, err)
+		}
+	}
+
 	var needResize bool
 	if required, ok := req.GetVolumeContext()[consts.ResizeRequired]; ok && strings.EqualFold(required, consts.TrueValue) {
 		needResize = true
@@ -178,6 +260,8 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		}
 		klog.V(2).Infof("NodeStageVolume: fs resize successful on target(%s) volumeid(%s).", target, diskURI)
 	}
+	d.trackStagedVolume(diskURI, params, target, source)
+	d.trackTunedVolume(diskURI, params, source)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -204,12 +288,18 @@ func (d *Driver) NodeUnstageVolume(_ context.Context, req *csi.NodeUnstageVolume
 		return nil, status.Errorf(codes.Internal, "failed to unmount staging target %q: %v", stagingTargetPath, err)
 	}
 	klog.V(2).Infof("NodeUnstageVolume: unmount %s successfully", stagingTargetPath)
+	if err := closeLUKSDevice(getLUKSMapperName(volumeID), d.mounter); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to close encrypted device of %s: %v", volumeID, err)
+	}
+	d.untrackStagedVolume(volumeID)
+	d.tunedVolumes.Delete(volumeID)
+	d.forgetPublishContext(volumeID)
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 // NodePublishVolume mount the volume from staging to target path
-func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolumeRequest) (_ *csi.NodePublishVolumeResponse, err error) {
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in the request")
@@ -240,6 +330,40 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 		return nil, status.Error(codes.InvalidArgument, "Target path not provided")
 	}
 
+	if azureutils.IsSingleNodeSingleWriter(volumeCapability) {
+		published, loaded := d.singleWriterVolumes.LoadOrStore(volumeID, target)
+		if loaded && published.(string) != target {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s with access mode %s is already published at %s",
+				volumeID, csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, published)
+		}
+		// the published volumes are lost on a restart of the node plugin, so they are also derived from the mounts
+		if other := d.getOtherPublishedTarget(volumeCapability, source, target); !loaded && other != "" {
+			d.singleWriterVolumes.CompareAndDelete(volumeID, target)
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s with access mode %s is already published at %s",
+				volumeID, csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, other)
+		}
+		defer func() {
+			// release the volume so that it could be published to another pod if this publish failed
+			if err != nil && !loaded {
+				d.singleWriterVolumes.CompareAndDelete(volumeID, target)
+			}
+		}()
+	}
+
+	// the fsGroup of the pod is only passed if VOLUME_MOUNT_GROUP capability is reported
+	var fsGroup *int64
+	if volumeMountGroup := volumeCapability.GetMount().GetVolumeMountGroup(); d.enableVolumeMountGroup && volumeMountGroup != "" && !req.GetReadonly() {
+		gid, err := strconv.ParseInt(volumeMountGroup, 10, 64)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid volume mount group %q: %v", volumeMountGroup, err)
+		}
+		fsGroup = &gid
+	}
+	fsGroupChangePolicy, err := azureutils.GetFsGroupChangePolicy(params)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	err = preparePublishPath(target, d.mounter)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Target path could not be prepared: %v", err))
@@ -252,19 +376,24 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 
 	switch req.GetVolumeCapability().GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
-		lun, ok := req.PublishContext[consts.LUN]
+		publishContext := d.resolvePublishContext(volumeID, req.GetPublishContext())
+		lun, ok := publishContext[consts.LUN]
 		if !ok {
 			return nil, status.Error(codes.InvalidArgument, "lun not provided")
 		}
 		var err error
-		source, err = d.getDevicePathWithLUN(lun)
+		source, err = d.getDevicePathWithLUN(volumeID, lun)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to find device path with lun %s. %v", lun, err)
 		}
+		d.cachePublishContext(volumeID, publishContext)
 		klog.V(2).Infof("NodePublishVolume [block]: found device path %s with lun %s", source, lun)
 		if err = d.ensureBlockTargetFile(target); err != nil {
 			return nil, status.Errorf(codes.Internal, "%v", err)
 		}
+		if err = d.applyIOThrottle(params, source); err != nil {
+			return nil, err
+		}
 	case *csi.VolumeCapability_Mount:
 		mnt, err := d.ensureMountPoint(target)
 		if err != nil {
@@ -274,6 +403,9 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 			klog.V(2).Infof("NodePublishVolume: already mounted on target %s", target)
 			return &csi.NodePublishVolumeResponse{}, nil
 		}
+		if err = d.applyIOThrottle(params, source); err != nil {
+			return nil, err
+		}
 	}
 
 	klog.V(2).Infof("NodePublishVolume: mounting %s at %s", source, target)
@@ -283,9 +415,53 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 
 	klog.V(2).Infof("NodePublishVolume: mount %s at %s successfully", source, target)
 
+	if fsGroup != nil && volumeCapability.GetMount() != nil {
+		if err := setVolumeOwnership(target, *fsGroup, fsGroupChangePolicy); err != nil {
+			// unmount the target so that the ownership change is retried in next NodePublishVolume call
+			if unmountErr := d.mounter.Unmount(target); unmountErr != nil {
+				klog.Errorf("failed to unmount target %s: %v", target, unmountErr)
+			}
+			return nil, status.Errorf(codes.Internal, "could not set ownership of %s to fsGroup(%d): %v", target, *fsGroup, err)
+		}
+	}
+
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// applyIOThrottle caps the IO of the consuming pod on the volume device if iopsLimit or bandwidthLimit is set,
+// source is either the device path or the staging path of the volume
+func (d *DriverCore) applyIOThrottle(volumeContext map[string]string, source string) error {
+	throttle, err := optimization.GetIOThrottleFromAttributes(volumeContext)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !throttle.IsEnabled() {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		klog.Warningf("NodePublishVolume: %s and %s are not supported on Windows, skip io throttle", consts.IopsLimitField, consts.BandwidthLimitField)
+		return nil
+	}
+
+	podUID := volumeContext[consts.PodUIDKey]
+	if podUID == "" {
+		return status.Errorf(codes.FailedPrecondition, "pod UID is not provided in volume context, podInfoOnMount must be enabled in CSIDriver (feature.enableIOThrottle of the helm chart) to apply %s and %s", consts.IopsLimitField, consts.BandwidthLimitField)
+	}
+
+	devicePath := source
+	if !strings.HasPrefix(source, "/dev/") {
+		if devicePath, err = getDevicePathWithMountPath(source, d.mounter); err != nil {
+			return status.Errorf(codes.Internal, "could not get device path of %s: %v", source, err)
+		}
+	}
+
+	if err := optimization.ApplyIOThrottle(consts.CgroupRootPathLinux, podUID, devicePath, throttle); err != nil {
+		return status.Errorf(codes.Internal, "failed to apply io throttle on %s for pod %s: %v", devicePath, podUID, err)
+	}
+	klog.V(2).Infof("NodePublishVolume: applied io throttle(%+v) on %s for pod %s", throttle, devicePath, podUID)
+	return nil
+}
+
 // NodeUnpublishVolume unmount the volume from the target path
 func (d *Driver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	targetPath := req.GetTargetPath()
@@ -305,6 +481,7 @@ func (d *Driver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVo
 	}
 
 	klog.V(2).Infof("NodeUnpublishVolume: unmount volume %s on %s successfully", volumeID, targetPath)
+	d.singleWriterVolumes.CompareAndDelete(volumeID, targetPath)
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
@@ -328,16 +505,16 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 	if d.supportZone {
 		var zone cloudprovider.Zone
 		if d.getNodeInfoFromLabels {
-			failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+			failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 		} else {
-			if runtime.GOOS == "windows" && (!d.cloud.UseInstanceMetadata || d.cloud.Metadata == nil) {
-				zone, err = d.cloud.VMSet.GetZoneByNodeName(ctx, d.NodeID)
+			if runtime.GOOS == "windows" && (!d.getCloud().UseInstanceMetadata || d.getCloud().Metadata == nil) {
+				zone, err = d.getCloud().VMSet.GetZoneByNodeName(ctx, d.NodeID)
 			} else {
-				zone, err = d.cloud.GetZone(ctx)
+				zone, err = d.getCloud().GetZone(ctx)
 			}
 			if err != nil {
 				klog.Warningf("get zone(%s) failed with: %v, fall back to get zone from node labels", d.NodeID, err)
-				failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		}
 		if err != nil {
@@ -348,7 +525,7 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 		}
 
 		klog.V(2).Infof("NodeGetInfo, nodeName: %s, failureDomain: %s", d.NodeID, zone.FailureDomain)
-		if azureutils.IsValidAvailabilityZone(zone.FailureDomain, d.cloud.Location) {
+		if azureutils.IsValidAvailabilityZone(zone.FailureDomain, d.getCloud().Location) {
 			topology.Segments[topologyKey] = zone.FailureDomain
 			topology.Segments[consts.WellKnownTopologyKey] = zone.FailureDomain
 		}
@@ -360,18 +537,18 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 		var err error
 		if d.getNodeInfoFromLabels {
 			if instanceTypeFromLabels == "" {
-				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		} else {
-			if runtime.GOOS == "windows" && d.cloud.UseInstanceMetadata && d.cloud.Metadata != nil {
+			if runtime.GOOS == "windows" && d.getCloud().UseInstanceMetadata && d.getCloud().Metadata != nil {
 				var metadata *azure.InstanceMetadata
-				metadata, err = d.cloud.Metadata.GetMetadata(ctx, azcache.CacheReadTypeDefault)
+				metadata, err = d.getCloud().Metadata.GetMetadata(ctx, azcache.CacheReadTypeDefault)
 				if err == nil && metadata != nil && metadata.Compute != nil {
 					instanceType = metadata.Compute.VMSize
 					klog.V(2).Infof("NodeGetInfo: nodeName(%s), VM Size(%s)", d.NodeID, instanceType)
 				}
 			} else {
-				instances, ok := d.cloud.Instances()
+				instances, ok := d.getCloud().Instances()
 				if !ok {
 					klog.Warningf("failed to get instances from cloud provider")
 				} else {
@@ -383,7 +560,7 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 			}
 			if instanceType == "" && instanceTypeFromLabels == "" {
 				klog.Warningf("fall back to get instance type from node labels")
-				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		}
 		if err != nil {
@@ -396,8 +573,8 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 	}
 
 	nodeID := d.NodeID
-	if d.getNodeIDFromIMDS && d.cloud.UseInstanceMetadata && d.cloud.Metadata != nil {
-		metadata, err := d.cloud.Metadata.GetMetadata(ctx, azcache.CacheReadTypeDefault)
+	if d.getNodeIDFromIMDS && d.getCloud().UseInstanceMetadata && d.getCloud().Metadata != nil {
+		metadata, err := d.getCloud().Metadata.GetMetadata(ctx, azcache.CacheReadTypeDefault)
 		if err == nil && metadata != nil && metadata.Compute != nil {
 			klog.V(2).Infof("NodeGetInfo: NodeID(%s), metadata.Compute.Name(%s)", d.NodeID, metadata.Compute.Name)
 			if metadata.Compute.Name != "" {
@@ -500,7 +677,18 @@ func (d *Driver) NodeExpandVolume(_ context.Context, req *csi.NodeExpandVolumeRe
 		return nil, status.Errorf(codes.NotFound, "%v", err)
 	}
 
-	if d.enableDiskOnlineResize {
+	if isLUKSMapperPath(devicePath) {
+		// the underlying device of an encrypted volume is not known from the mount, so rescan all devices
+		if d.enableDiskOnlineResize {
+			klog.V(2).Infof("NodeExpandVolume begin to rescan all devices on encrypted volume(%s)", volumeID)
+			if err := rescanAllVolumes(d.ioHandler); err != nil {
+				klog.Errorf("NodeExpandVolume rescanAllVolumes failed with error: %v", err)
+			}
+		}
+		if err := resizeLUKSDevice(filepath.Base(devicePath), req.GetSecrets()[consts.LUKSPassphraseSecretKey], d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not resize encrypted device %s of volume %q: %v", devicePath, volumeID, err)
+		}
+	} else if d.enableDiskOnlineResize {
 		klog.V(2).Infof("NodeExpandVolume begin to rescan device %s on volume(%s)", devicePath, volumeID)
 		if err := rescanVolume(d.ioHandler, devicePath); err != nil {
 			klog.Errorf("NodeExpandVolume rescanVolume failed with error: %v", err)
@@ -599,36 +787,66 @@ func (d *Driver) ensureMountPoint(target string) (bool, error) {
 	return !notMnt, nil
 }
 
-func (d *Driver) formatAndMount(source, target, fstype string, options []string) error {
-	return formatAndMount(source, target, fstype, options, d.mounter)
+func (d *Driver) formatAndMount(source, target, fstype string, options []string, logicalSectorSize int, mkfsOptions []string) error {
+	return formatAndMount(source, target, fstype, options, logicalSectorSize, mkfsOptions, d.mounter)
 }
 
-func (d *Driver) getDevicePathWithLUN(lunStr string) (string, error) {
-	lun, err := azureutils.GetDiskLUN(lunStr)
+func (d *Driver) getDevicePathWithLUN(volumeID, lunStr string) (string, error) {
+	return waitForDevicePathWithLUN(volumeID, lunStr, d.ioHandler, d.mounter)
+}
+
+// getOtherPublishedTarget returns a target path other than target which the staging mount of a filesystem volume is
+// bind mounted at, empty if there is none or the mounts could not be read, e.g. on Windows. The targets of block
+// volumes are not derived since they are bind mounts of the device file.
+func (d *DriverCore) getOtherPublishedTarget(volumeCapability *csi.VolumeCapability, stagingPath, target string) string {
+	if volumeCapability.GetBlock() != nil {
+		return ""
+	}
+	refs, err := d.mounter.GetMountRefs(stagingPath)
 	if err != nil {
-		return "", err
+		klog.V(4).Infof("could not get mount references of %s: %v", stagingPath, err)
+		return ""
 	}
-
-	scsiHostRescan(d.ioHandler, d.mounter)
-
-	newDevicePath := ""
-	err = wait.PollImmediate(1*time.Second, 2*time.Minute, func() (bool, error) {
-		var err error
-		if newDevicePath, err = findDiskByLun(int(lun), d.ioHandler, d.mounter); err != nil {
-			return false, fmt.Errorf("azureDisk - findDiskByLun(%v) failed with error(%s)", lun, err)
+	for _, ref := range refs {
+		if ref = filepath.Clean(ref); ref != filepath.Clean(stagingPath) && ref != filepath.Clean(target) {
+			return ref
 		}
+	}
+	return ""
+}
 
-		// did we find it?
-		if newDevicePath != "" {
-			return true, nil
-		}
-		// wait until timeout
+// reuseStagingMount returns true if the staging target is already mounted from the disk attached at lun,
+// the disk is looked up without rescanning the SCSI hosts so restaging a volume on the same node is fast.
+// An error is returned if the staging target is mounted from a different disk.
+func (d *DriverCore) reuseStagingMount(lunStr, target string) (bool, error) {
+	notMnt, err := d.mounter.IsLikelyNotMountPoint(target)
+	if err != nil || notMnt {
 		return false, nil
-	})
-	if err == nil && newDevicePath == "" {
-		err = fmt.Errorf("azureDisk - findDiskByLun(%v) failed within timeout", lun)
 	}
-	return newDevicePath, err
+	lun, err := azureutils.GetDiskLUN(lunStr)
+	if err != nil {
+		return false, nil
+	}
+	expectedDevice, err := findDiskByLun(int(lun), d.ioHandler, d.mounter)
+	if err != nil || expectedDevice == "" {
+		klog.V(4).Infof("could not find disk on lun %s without rescan: %v", lunStr, err)
+		return false, nil
+	}
+	mountedDevice, err := getDevicePathWithMountPath(target, d.mounter)
+	if err != nil {
+		klog.V(4).Infof("could not get device mounted at %s: %v", target, err)
+		return false, nil
+	}
+	if resolved, err := filepath.EvalSymlinks(expectedDevice); err == nil {
+		expectedDevice = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(mountedDevice); err == nil {
+		mountedDevice = resolved
+	}
+	if expectedDevice != mountedDevice {
+		return false, fmt.Errorf("staging target %s is mounted from device %s, while the disk on lun %s is %s", target, mountedDevice, lunStr, expectedDevice)
+	}
+	return true, nil
 }
 
 func (d *Driver) ensureBlockTargetFile(target string) error {
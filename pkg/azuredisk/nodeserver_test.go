@@ -418,6 +418,17 @@ func TestNodeStageVolume(t *testing.T) {
 	volumeContextWithPerfProfileField := map[string]string{
 		consts.PerfProfileField: "wrong",
 	}
+	volumeContextWithReservedBlocks := map[string]string{
+		consts.FsTypeField:                   defaultLinuxFsType,
+		consts.ReservedBlocksPercentageField: "1",
+	}
+	volumeContextWithInvalidReservedBlocks := map[string]string{
+		consts.ReservedBlocksPercentageField: "60",
+	}
+	volumeContextWithInvalidMkfsOptions := map[string]string{
+		consts.FsTypeField:      defaultLinuxFsType,
+		consts.MkfsOptionsField: "-n",
+	}
 
 	stdVolCapBlock := &csi.VolumeCapability_Block{
 		Block: &csi.VolumeCapability_BlockVolume{},
@@ -444,6 +455,15 @@ func TestNodeStageVolume(t *testing.T) {
 	resize2fsAction := func() ([]byte, []byte, error) {
 		return []byte{}, []byte{}, nil
 	}
+	tune2fsListAction := func() ([]byte, []byte, error) {
+		return []byte("Block count:              262144\nReserved block count:     0\n"), []byte{}, nil
+	}
+	tune2fsListReservedAction := func() ([]byte, []byte, error) {
+		return []byte("Block count:              262144\nReserved block count:     2621\n"), []byte{}, nil
+	}
+	tune2fsAction := func() ([]byte, []byte, error) {
+		return []byte{}, []byte{}, nil
+	}
 
 	tests := []struct {
 		desc          string
@@ -638,6 +658,60 @@ func TestNodeStageVolume(t *testing.T) {
 			},
 			expectedErr: nil,
 		},
+		{
+			desc:          "Invalid reserved blocks percentage",
+			skipOnDarwin:  true,
+			skipOnWindows: true,
+			req: &csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap,
+					AccessType: stdVolCap},
+				PublishContext: publishContext,
+				VolumeContext:  volumeContextWithInvalidReservedBlocks,
+			},
+			expectedErr: status.Error(codes.InvalidArgument, "invalid reservedblockspercentage: 60, should be a number between 0 and 50"),
+		},
+		{
+			desc:          "Invalid mkfs options",
+			skipOnDarwin:  true,
+			skipOnWindows: true,
+			req: &csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap,
+					AccessType: stdVolCap},
+				PublishContext: publishContext,
+				VolumeContext:  volumeContextWithInvalidMkfsOptions,
+			},
+			expectedErr: status.Error(codes.InvalidArgument, "option -n in mkfsoptions is not supported, supported options are [-C -E -G -I -J -L -M -N -O -T -U -b -g -i -j -m -o -q -r]"),
+		},
+		{
+			desc:          "Successfully staged with reserved blocks percentage",
+			skipOnDarwin:  true,
+			skipOnWindows: true,
+			setupFunc: func(_ *testing.T, d FakeDriver) {
+				d.setNextCommandOutputScripts(blkidAction, fsckAction, tune2fsListAction, tune2fsAction, blockSizeAction, blockSizeAction)
+			},
+			req: &csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap,
+					AccessType: stdVolCap},
+				PublishContext: publishContext,
+				VolumeContext:  volumeContextWithReservedBlocks,
+			},
+			expectedErr: nil,
+		},
+		{
+			desc:          "Successfully staged with the reserved blocks percentage already set",
+			skipOnDarwin:  true,
+			skipOnWindows: true,
+			setupFunc: func(_ *testing.T, d FakeDriver) {
+				d.setNextCommandOutputScripts(blkidAction, fsckAction, tune2fsListReservedAction, blockSizeAction, blockSizeAction)
+			},
+			req: &csi.NodeStageVolumeRequest{VolumeId: "vol_1", StagingTargetPath: sourceTest,
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap,
+					AccessType: stdVolCap},
+				PublishContext: publishContext,
+				VolumeContext:  volumeContextWithReservedBlocks,
+			},
+			expectedErr: nil,
+		},
 	}
 
 	for _, test := range tests {
@@ -670,6 +744,58 @@ func TestNodeStageVolume(t *testing.T) {
 
 }
 
+func TestReuseStagingMount(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test on ", runtime.GOOS)
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, _ := NewFakeDriver(cntl)
+	lun := "/dev/disk/azure/scsi1/lun1"
+
+	findmntAction := func(device string) testingexec.FakeAction {
+		return func() ([]byte, []byte, error) {
+			return []byte(device + "\n"), []byte{}, nil
+		}
+	}
+
+	tests := []struct {
+		desc          string
+		mountedDevice string
+		expected      bool
+		expectedErr   bool
+	}{
+		{
+			desc: "staging target not mounted",
+		},
+		{
+			desc:          "staging target mounted from the disk on lun",
+			mountedDevice: "/dev/sdd",
+			expected:      true,
+		},
+		{
+			desc:          "staging target mounted from another disk",
+			mountedDevice: "/dev/sde",
+			expectedErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		target := sourceTest
+		fakeMounter, err := mounter.NewFakeSafeMounter()
+		assert.NoError(t, err)
+		d.setMounter(fakeMounter)
+		if test.mountedDevice != "" {
+			// FakeSafeMounter treats paths containing false_is_likely as mount points
+			target = filepath.Join(t.TempDir(), "false_is_likely")
+			d.setNextCommandOutputScripts(findmntAction(test.mountedDevice))
+		}
+		reused, err := d.reuseStagingMount(lun, target)
+		assert.Equal(t, test.expected, reused, test.desc)
+		assert.Equal(t, test.expectedErr, err != nil, test.desc)
+	}
+}
+
 func TestNodeUnstageVolume(t *testing.T) {
 	cntl := gomock.NewController(t)
 	defer cntl.Finish()
@@ -903,13 +1029,40 @@ func TestNodePublishVolume(t *testing.T) {
 		{
 			desc: "[Success] Valid request already mounted",
 			req: &csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap, AccessType: stdVolCap},
-				VolumeId:          "vol_1",
+				// a different volume, vol_1 with SINGLE_NODE_SINGLE_WRITER could only be published at one target path
+				VolumeId:          "vol_2",
 				TargetPath:        alreadyMountedTarget,
 				StagingTargetPath: sourceTest,
 				Readonly:          true},
 			skipOnWindows: true, // permission issues
 			expectedErr:   testutil.TestError{},
 		},
+		{
+			desc: "[Error] Invalid iopsLimit",
+			req: &csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap, AccessType: stdVolCap},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest,
+				VolumeContext:     map[string]string{consts.IopsLimitField: "-1"},
+				Readonly:          true},
+			skipOnWindows: true, // permission issues
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.InvalidArgument, "iopslimit:-1 must be a positive integer"),
+			},
+		},
+		{
+			desc: "[Error] Pod UID not provided with iopsLimit",
+			req: &csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap, AccessType: stdVolCap},
+				VolumeId:          "vol_1",
+				TargetPath:        targetTest,
+				StagingTargetPath: sourceTest,
+				VolumeContext:     map[string]string{consts.IopsLimitField: "100"},
+				Readonly:          true},
+			skipOnWindows: true, // permission issues
+			expectedErr: testutil.TestError{
+				DefaultError: status.Error(codes.FailedPrecondition, "pod UID is not provided in volume context, podInfoOnMount must be enabled in CSIDriver (feature.enableIOThrottle of the helm chart) to apply iopslimit and bandwidthlimit"),
+			},
+		},
 		{
 			desc: "[Success] Valid request",
 			req: &csi.NodePublishVolumeRequest{VolumeCapability: &csi.VolumeCapability{AccessMode: &volumeCap, AccessType: stdVolCap},
@@ -1026,6 +1179,44 @@ func TestNodeUnpublishVolume(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestNodePublishVolumeSingleNodeSingleWriter(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := NewFakeDriver(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	ctx := context.Background()
+	dir := t.TempDir()
+	staging := filepath.Join(dir, "staging")
+	require.NoError(t, os.MkdirAll(staging, 0750))
+
+	newRequest := func(target string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId: "vol_1",
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER},
+			},
+			StagingTargetPath: staging,
+			TargetPath:        target,
+		}
+	}
+	target1 := filepath.Join(dir, "pod1")
+	target2 := filepath.Join(dir, "pod2")
+
+	_, err = d.NodePublishVolume(ctx, newRequest(target1))
+	assert.NoError(t, err)
+	_, err = d.NodePublishVolume(ctx, newRequest(target2))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol_1", TargetPath: target1})
+	assert.NoError(t, err)
+	_, err = d.NodePublishVolume(ctx, newRequest(target2))
+	assert.NoError(t, err)
+}
+
 func TestNodeExpandVolume(t *testing.T) {
 	cntl := gomock.NewController(t)
 	defer cntl.Finish()
@@ -1371,7 +1562,7 @@ func TestGetDevicePathWithLUN(t *testing.T) {
 		},
 	}
 	for _, test := range tests {
-		_, err := d.getDevicePathWithLUN(test.req)
+		_, err := d.getDevicePathWithLUN("", test.req)
 		if !reflect.DeepEqual(err, test.expectedErr) {
 			t.Errorf("desc: %s\n actualErr: (%v), expectedErr: (%v)", test.desc, err, test.expectedErr)
 		}
@@ -26,7 +26,6 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
-	"time"
 
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
 	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
@@ -37,7 +36,6 @@ import (
 	"google.golang.org/grpc/status"
 
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/wait"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
@@ -76,15 +74,17 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 	}
 	defer d.volumeLocks.Release(diskURI)
 
-	lun, ok := req.PublishContext[consts.LUN]
+	publishContext := d.resolvePublishContext(diskURI, req.GetPublishContext())
+	lun, ok := publishContext[consts.LUN]
 	if !ok {
 		return nil, status.Error(codes.InvalidArgument, "lun not provided")
 	}
 
-	source, err := d.getDevicePathWithLUN(lun)
+	source, err := d.getDevicePathWithLUN(diskURI, lun)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to find disk on lun %s. %v", lun, err)
 	}
+	d.cachePublishContext(diskURI, publishContext)
 
 	// If perf optimizations are enabled
 	// tweak device settings to enhance performance
@@ -107,6 +107,7 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 	// If the access type is block, do nothing for stage
 	switch req.GetVolumeCapability().GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
+		d.trackStagedVolume(diskURI, params, "", source)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -116,6 +117,7 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 	}
 	if mnt {
 		klog.V(2).Infof("NodeStageVolume: already mounted on target %s", target)
+		d.trackStagedVolume(diskURI, params, target, source)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -134,6 +136,30 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 		// respect "fstype" setting in storage class parameters
 		fstype = volContextFSType
 	}
+	reservedBlocksPercentage, err := azureutils.GetReservedBlocksPercentage(req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// logical sector size is in volume context of dynamically provisioned volumes or in publish context
+	logicalSectorSize, err := azureutils.GetLogicalSectorSize(req.GetVolumeContext())
+	if err == nil && logicalSectorSize == 0 {
+		logicalSectorSize, err = azureutils.GetLogicalSectorSize(publishContext)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// mkfs options and allocation unit size only apply when the volume is formatted at first stage
+	mkfsOptions, err := azureutils.GetMkfsOptions(req.GetVolumeContext(), fstype)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	allocationUnitSize, err := azureutils.GetAllocationUnitSize(req.GetVolumeContext(), fstype)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if allocationUnitSize > 0 {
+		options = append(options, fmt.Sprintf("%s=%d", consts.AllocationUnitSizeMountOption, allocationUnitSize))
+	}
 
 	// If partition is specified, should mount it only instead of the entire disk.
 	if partition, ok := req.GetVolumeContext()[consts.VolumeAttributePartition]; ok {
@@ -142,11 +168,22 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 
 	// FormatAndMount will format only if needed
 	klog.V(2).Infof("NodeStageVolume: formatting %s and mounting at %s with mount options(%s)", source, target, options)
-	if err := d.formatAndMount(source, target, fstype, options); err != nil {
+	if err := d.formatAndMount(source, target, fstype, options, logicalSectorSize, mkfsOptions); err != nil {
+		// the format options not supported by the mounter
+		if status.Code(err) == codes.InvalidArgument {
+			return nil, err
+		}
 		return nil, status.Errorf(codes.Internal, "could not format %s(lun: %s), and mount it at %s, failed with %v", source, lun, target, err)
 	}
 	klog.V(2).Infof("NodeStageVolume: format %s and mounting at %s successfully.", source, target)
 
+	if reservedBlocksPercentage != "" && strings.HasPrefix(fstype, "ext") {
+		klog.V(2).Infof("NodeStageVolume: setting reserved blocks percentage of %s to %s", source, reservedBlocksPercentage)
+		if err := setReservedBlocksPercentage(source, reservedBlocksPercentage, d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not set reserved blocks percentage of %s: %v", source, err)
+		}
+	}
+
 	var needResize bool
 	if required, ok := req.GetVolumeContext()[consts.ResizeRequired]; ok && strings.EqualFold(required, consts.TrueValue) {
 		needResize = true
@@ -165,6 +202,7 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 		}
 		klog.V(2).Infof("NodeStageVolume: fs resize successful on target(%s) volumeid(%s).", target, diskURI)
 	}
+	d.trackStagedVolume(diskURI, params, target, source)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -191,12 +229,14 @@ func (d *DriverV2) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVo
 		return nil, status.Errorf(codes.Internal, "failed to unmount staging target %q: %v", stagingTargetPath, err)
 	}
 	klog.V(2).Infof("NodeUnstageVolume: unmount %s successfully", stagingTargetPath)
+	d.untrackStagedVolume(volumeID)
+	d.forgetPublishContext(volumeID)
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 // NodePublishVolume mount the volume from staging to target path
-func (d *DriverV2) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+func (d *DriverV2) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (_ *csi.NodePublishVolumeResponse, err error) {
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in the request")
@@ -227,6 +267,26 @@ func (d *DriverV2) NodePublishVolume(ctx context.Context, req *csi.NodePublishVo
 		return nil, status.Error(codes.InvalidArgument, "Target path not provided")
 	}
 
+	if azureutils.IsSingleNodeSingleWriter(volumeCapability) {
+		published, loaded := d.singleWriterVolumes.LoadOrStore(volumeID, target)
+		if loaded && published.(string) != target {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s with access mode %s is already published at %s",
+				volumeID, csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, published)
+		}
+		// the published volumes are lost on a restart of the node plugin, so they are also derived from the mounts
+		if other := d.getOtherPublishedTarget(volumeCapability, source, target); !loaded && other != "" {
+			d.singleWriterVolumes.CompareAndDelete(volumeID, target)
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s with access mode %s is already published at %s",
+				volumeID, csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, other)
+		}
+		defer func() {
+			// release the volume so that it could be published to another pod if this publish failed
+			if err != nil && !loaded {
+				d.singleWriterVolumes.CompareAndDelete(volumeID, target)
+			}
+		}()
+	}
+
 	err = preparePublishPath(target, d.mounter)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Target path could not be prepared: %v", err))
@@ -244,19 +304,24 @@ func (d *DriverV2) NodePublishVolume(ctx context.Context, req *csi.NodePublishVo
 
 	switch req.GetVolumeCapability().GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
-		lun, ok := req.PublishContext[consts.LUN]
+		publishContext := d.resolvePublishContext(volumeID, req.GetPublishContext())
+		lun, ok := publishContext[consts.LUN]
 		if !ok {
 			return nil, status.Error(codes.InvalidArgument, "lun not provided")
 		}
 		var err error
-		source, err = d.getDevicePathWithLUN(lun)
+		source, err = d.getDevicePathWithLUN(volumeID, lun)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to find device path with lun %s. %v", lun, err)
 		}
+		d.cachePublishContext(volumeID, publishContext)
 		klog.V(2).Infof("NodePublishVolume [block]: found device path %s with lun %s", source, lun)
 		if err = d.ensureBlockTargetFile(target); err != nil {
 			return nil, status.Errorf(codes.Internal, "%v", err)
 		}
+		if err = d.applyIOThrottle(params, source); err != nil {
+			return nil, err
+		}
 	case *csi.VolumeCapability_Mount:
 		mnt, err := d.ensureMountPoint(target)
 		if err != nil {
@@ -266,6 +331,9 @@ func (d *DriverV2) NodePublishVolume(ctx context.Context, req *csi.NodePublishVo
 			klog.V(2).Infof("NodePublishVolume: already mounted on target %s", target)
 			return &csi.NodePublishVolumeResponse{}, nil
 		}
+		if err = d.applyIOThrottle(params, source); err != nil {
+			return nil, err
+		}
 	}
 
 	klog.V(2).Infof("NodePublishVolume: mounting %s at %s", source, target)
@@ -301,6 +369,7 @@ func (d *DriverV2) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpubli
 	defer d.volumeLocks.Release(volumeID)
 
 	klog.V(2).Infof("NodeUnpublishVolume: unmount volume %s on %s successfully", volumeID, targetPath)
+	d.singleWriterVolumes.CompareAndDelete(volumeID, targetPath)
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
@@ -324,16 +393,16 @@ func (d *DriverV2) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest)
 	if d.supportZone {
 		var zone cloudprovider.Zone
 		if d.getNodeInfoFromLabels {
-			failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+			failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 		} else {
-			if runtime.GOOS == "windows" && (!d.cloud.UseInstanceMetadata || d.cloud.Metadata == nil) {
-				zone, err = d.cloud.VMSet.GetZoneByNodeName(ctx, d.NodeID)
+			if runtime.GOOS == "windows" && (!d.getCloud().UseInstanceMetadata || d.getCloud().Metadata == nil) {
+				zone, err = d.getCloud().VMSet.GetZoneByNodeName(ctx, d.NodeID)
 			} else {
-				zone, err = d.cloud.GetZone(ctx)
+				zone, err = d.getCloud().GetZone(ctx)
 			}
 			if err != nil {
 				klog.Warningf("get zone(%s) failed with: %v, fall back to get zone from node labels", d.NodeID, err)
-				failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		}
 		if err != nil {
@@ -344,7 +413,7 @@ func (d *DriverV2) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest)
 		}
 
 		klog.V(2).Infof("NodeGetInfo, nodeName: %s, failureDomain: %s", d.NodeID, zone.FailureDomain)
-		if azureutils.IsValidAvailabilityZone(zone.FailureDomain, d.cloud.Location) {
+		if azureutils.IsValidAvailabilityZone(zone.FailureDomain, d.getCloud().Location) {
 			topology.Segments[topologyKey] = zone.FailureDomain
 			topology.Segments[consts.WellKnownTopologyKey] = zone.FailureDomain
 		}
@@ -356,17 +425,17 @@ func (d *DriverV2) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest)
 		var err error
 		if d.getNodeInfoFromLabels {
 			if instanceTypeFromLabels == "" {
-				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		} else {
-			if runtime.GOOS == "windows" && d.cloud.UseInstanceMetadata && d.cloud.Metadata != nil {
-				metadata, err := d.cloud.Metadata.GetMetadata(ctx, azcache.CacheReadTypeDefault)
+			if runtime.GOOS == "windows" && d.getCloud().UseInstanceMetadata && d.getCloud().Metadata != nil {
+				metadata, err := d.getCloud().Metadata.GetMetadata(ctx, azcache.CacheReadTypeDefault)
 				if err == nil && metadata.Compute != nil {
 					instanceType = metadata.Compute.VMSize
 					klog.V(5).Infof("NodeGetInfo: nodeName(%s), VM Size(%s)", d.NodeID, instanceType)
 				}
 			} else {
-				instances, ok := d.cloud.Instances()
+				instances, ok := d.getCloud().Instances()
 				if !ok {
 					klog.Warningf("failed to get instances from cloud provider")
 				} else {
@@ -378,7 +447,7 @@ func (d *DriverV2) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest)
 			}
 			if instanceType == "" && instanceTypeFromLabels == "" {
 				klog.Warningf("fall back to get instance type from node labels")
-				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		}
 		if err != nil {
@@ -552,36 +621,12 @@ func (d *DriverV2) ensureMountPoint(target string) (bool, error) {
 	return !notMnt, nil
 }
 
-func (d *DriverV2) formatAndMount(source, target, fstype string, options []string) error {
-	return formatAndMount(source, target, fstype, options, d.mounter)
+func (d *DriverV2) formatAndMount(source, target, fstype string, options []string, logicalSectorSize int, mkfsOptions []string) error {
+	return formatAndMount(source, target, fstype, options, logicalSectorSize, mkfsOptions, d.mounter)
 }
 
-func (d *DriverV2) getDevicePathWithLUN(lunStr string) (string, error) {
-	lun, err := azureutils.GetDiskLUN(lunStr)
-	if err != nil {
-		return "", err
-	}
-
-	scsiHostRescan(d.ioHandler, d.mounter)
-
-	newDevicePath := ""
-	err = wait.PollImmediate(1*time.Second, 2*time.Minute, func() (bool, error) {
-		var err error
-		if newDevicePath, err = findDiskByLun(int(lun), d.ioHandler, d.mounter); err != nil {
-			return false, fmt.Errorf("azureDisk - findDiskByLun(%v) failed with error(%s)", lun, err)
-		}
-
-		// did we find it?
-		if newDevicePath != "" {
-			return true, nil
-		}
-		// wait until timeout
-		return false, nil
-	})
-	if err == nil && newDevicePath == "" {
-		err = fmt.Errorf("azureDisk - findDiskByLun(%v) failed within timeout", lun)
-	}
-	return newDevicePath, err
+func (d *DriverV2) getDevicePathWithLUN(volumeID, lunStr string) (string, error) {
+	return waitForDevicePathWithLUN(volumeID, lunStr, d.ioHandler, d.mounter)
 }
 
 func (d *DriverV2) ensureBlockTargetFile(target string) error {
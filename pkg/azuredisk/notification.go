@@ -0,0 +1,315 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	danglingAttachmentReason         = "DanglingAttachment"
+	attachSLOExceededReason          = "AttachSLOExceeded"
+	diskQuotaExceededReason          = "DiskQuotaExceeded"
+	nodeClassPerformanceFailedReason = "NodeClassPerformanceFailed"
+
+	webhookSinkType   = "webhook"
+	slackSinkType     = "slack"
+	eventGridSinkType = "eventgrid"
+)
+
+var (
+	// criticalEventReasons are the event reasons forwarded to the notification sinks if no reason is configured
+	criticalEventReasons = []string{forceDetachEscalatedReason, danglingAttachmentReason, attachSLOExceededReason, diskQuotaExceededReason, snapshotCopyFailedReason}
+	// notificationQueueSize is the number of notifications waiting to be sent, newer notifications are dropped if it's full
+	notificationQueueSize = 100
+	// notificationTimeout is the timeout of sending a notification to a sink
+	notificationTimeout = 10 * time.Second
+)
+
+// notificationConfig is the configuration of the notification sinks, usually mounted from a configmap
+type notificationConfig struct {
+	// Reasons are the event reasons forwarded to the sinks, the critical event reasons of the driver if empty
+	Reasons []string                 `json:"reasons,omitempty"`
+	Sinks   []notificationSinkConfig `json:"sinks"`
+}
+
+// notificationSinkConfig is an external alerting endpoint receiving the notifications
+type notificationSinkConfig struct {
+	Name string `json:"name"`
+	// Type is webhook, slack or eventgrid
+	Type string `json:"type"`
+	// URL is the webhook URL, the Slack incoming webhook URL or the Event Grid topic endpoint
+	URL string `json:"url"`
+	// Headers are the additional HTTP headers of the requests
+	Headers map[string]string `json:"headers,omitempty"`
+	// KeyFile is the file of the access key of the Event Grid topic, usually mounted from a secret
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// notification is a critical event forwarded to the sinks, it's the payload of webhook sinks
+type notification struct {
+	Time    string `json:"time"`
+	Driver  string `json:"driver"`
+	Type    string `json:"type"`
+	Reason  string `json:"reason"`
+	Object  string `json:"object,omitempty"`
+	Message string `json:"message"`
+}
+
+// notifier forwards the events of the configured reasons to the notification sinks
+type notifier struct {
+	driverName string
+	reasons    map[string]bool
+	sinks      []notificationSinkConfig
+	client     *http.Client
+	queue      chan notification
+}
+
+// loadNotificationConfig reads the notification config of path
+func loadNotificationConfig(path string) (*notificationConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &notificationConfig{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse notification config %s: %w", path, err)
+	}
+	for _, sink := range config.Sinks {
+		switch strings.ToLower(sink.Type) {
+		case webhookSinkType, slackSinkType:
+		case eventGridSinkType:
+			if sink.KeyFile == "" {
+				return nil, fmt.Errorf("keyFile of eventgrid sink %s is not set", sink.Name)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported type %q of sink %s, supported types: %s, %s, %s", sink.Type, sink.Name, webhookSinkType, slackSinkType, eventGridSinkType)
+		}
+		if sink.URL == "" {
+			return nil, fmt.Errorf("url of sink %s is not set", sink.Name)
+		}
+	}
+	return config, nil
+}
+
+func newNotifier(driverName string, config *notificationConfig) *notifier {
+	reasons := config.Reasons
+	if len(reasons) == 0 {
+		reasons = criticalEventReasons
+	}
+	n := &notifier{
+		driverName: driverName,
+		reasons:    map[string]bool{},
+		sinks:      config.Sinks,
+		client:     &http.Client{Timeout: notificationTimeout},
+		queue:      make(chan notification, notificationQueueSize),
+	}
+	for _, reason := range reasons {
+		n.reasons[reason] = true
+	}
+	return n
+}
+
+// Run sends the queued notifications to the sinks until ctx is done
+func (n *notifier) Run(ctx context.Context) {
+	klog.V(2).Infof("forwarding events of %d reasons to %d notification sinks", len(n.reasons), len(n.sinks))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-n.queue:
+			for _, sink := range n.sinks {
+				if err := n.send(ctx, sink, notification); err != nil {
+					klog.Errorf("failed to send %s notification of %s to sink %s: %v", notification.Reason, notification.Object, sink.Name, err)
+				}
+			}
+		}
+	}
+}
+
+// notify queues the event of object if its reason is forwarded to the sinks
+func (n *notifier) notify(object runtime.Object, eventType, reason, message string) {
+	if !n.reasons[reason] {
+		return
+	}
+	notification := notification{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Driver:  n.driverName,
+		Type:    eventType,
+		Reason:  reason,
+		Object:  getObjectDescription(object),
+		Message: message,
+	}
+	select {
+	case n.queue <- notification:
+	default:
+		klog.Warningf("notification queue is full, dropped %s notification of %s: %s", reason, notification.Object, message)
+	}
+}
+
+// send posts notification to sink in the payload format of the sink type
+func (n *notifier) send(ctx context.Context, sink notificationSinkConfig, notification notification) error {
+	var payload interface{} = notification
+	headers := map[string]string{"Content-Type": "application/json"}
+	switch strings.ToLower(sink.Type) {
+	case slackSinkType:
+		text := fmt.Sprintf("[%s] %s %s", notification.Driver, notification.Type, notification.Reason)
+		if notification.Object != "" {
+			text += " " + notification.Object
+		}
+		payload = map[string]string{"text": text + ": " + notification.Message}
+	case eventGridSinkType:
+		key, err := os.ReadFile(sink.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read key of sink %s: %w", sink.Name, err)
+		}
+		headers["aeg-sas-key"] = strings.TrimSpace(string(key))
+		subject := notification.Object
+		if subject == "" {
+			// the subject of an Event Grid event is required
+			subject = notification.Driver
+		}
+		payload = []map[string]interface{}{{
+			"id":          string(uuid.NewUUID()),
+			"eventType":   "AzureDiskCSIDriver." + notification.Reason,
+			"subject":     subject,
+			"eventTime":   notification.Time,
+			"data":        notification,
+			"dataVersion": "1.0",
+		}}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notificationTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range sink.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// getObjectDescription returns Kind/namespace/name of the object of an event, empty if the event has no object
+func getObjectDescription(object runtime.Object) string {
+	if object == nil {
+		return ""
+	}
+	ref, ok := object.(*v1.ObjectReference)
+	if !ok {
+		var err error
+		if ref, err = reference.GetReference(scheme.Scheme, object); err != nil {
+			return fmt.Sprintf("%T", object)
+		}
+	}
+	if ref.Namespace == "" {
+		return ref.Kind + "/" + ref.Name
+	}
+	return ref.Kind + "/" + ref.Namespace + "/" + ref.Name
+}
+
+// notifyingEventRecorder records events with the wrapped recorder and forwards them to the notifier
+type notifyingEventRecorder struct {
+	recorder record.EventRecorder
+	notifier *notifier
+}
+
+// newNotifyingEventRecorder returns a recorder forwarding the events of recorder to n, recorder could be nil if the
+// events are only forwarded
+func newNotifyingEventRecorder(recorder record.EventRecorder, n *notifier) record.EventRecorder {
+	return &notifyingEventRecorder{recorder: recorder, notifier: n}
+}
+
+func (r *notifyingEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.recorder != nil {
+		r.recorder.Event(object, eventtype, reason, message)
+	}
+	r.notifier.notify(object, eventtype, reason, message)
+}
+
+func (r *notifyingEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *notifyingEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.recorder != nil {
+		r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	}
+	r.notifier.notify(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// checkAttachSLO records a warning event on the PV if attaching diskURI to nodeName took longer than the attach SLO
+func (d *DriverCore) checkAttachSLO(pvName, diskURI string, nodeName types.NodeName, duration time.Duration, succeeded bool) {
+	if d.attachSLOSeconds <= 0 || duration <= time.Duration(d.attachSLOSeconds)*time.Second {
+		return
+	}
+	result := "succeeded"
+	if !succeeded {
+		result = "failed"
+	}
+	d.recordEvent(getPersistentVolumeReference(pvName), v1.EventTypeWarning, attachSLOExceededReason,
+		"attaching disk %s to node %s %s after %v, exceeding the attach SLO of %ds", diskURI, nodeName, result, duration.Round(time.Second), d.attachSLOSeconds)
+}
+
+// getPersistentVolumeReference returns the object reference of a PV, nil is returned if name is empty
+func getPersistentVolumeReference(name string) *v1.ObjectReference {
+	if name == "" {
+		return nil
+	}
+	return &v1.ObjectReference{APIVersion: "v1", Kind: "PersistentVolume", Name: name}
+}
+
+// getPersistentVolumeClaimReference returns the object reference of a PVC, nil is returned if name is empty
+func getPersistentVolumeClaimReference(namespace, name string) *v1.ObjectReference {
+	if name == "" {
+		return nil
+	}
+	return &v1.ObjectReference{APIVersion: "v1", Kind: "PersistentVolumeClaim", Namespace: namespace, Name: name}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestLoadNotificationConfig(t *testing.T) {
+	tests := []struct {
+		desc          string
+		content       string
+		expectedError string
+	}{
+		{
+			desc: "valid config",
+			content: `
+reasons: [ForceDetachEscalated]
+sinks:
+- name: ops
+  type: webhook
+  url: https://example.com/hook
+  headers:
+    Authorization: Bearer token
+- name: alerts
+  type: slack
+  url: https://hooks.slack.com/services/x
+- name: grid
+  type: eventgrid
+  url: https://topic.eastus-1.eventgrid.azure.net/api/events
+  keyFile: /etc/notification/key
+`,
+		},
+		{
+			desc:          "unsupported sink type",
+			content:       "sinks:\n- name: pager\n  type: pagerduty\n  url: https://example.com\n",
+			expectedError: `unsupported type "pagerduty" of sink pager`,
+		},
+		{
+			desc:          "eventgrid sink without key",
+			content:       "sinks:\n- name: grid\n  type: eventgrid\n  url: https://example.com\n",
+			expectedError: "keyFile of eventgrid sink grid is not set",
+		},
+		{
+			desc:          "sink without url",
+			content:       "sinks:\n- name: ops\n  type: webhook\n",
+			expectedError: "url of sink ops is not set",
+		},
+		{
+			desc:          "unknown field",
+			content:       "sink: []\n",
+			expectedError: "failed to parse notification config",
+		},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(test.content), 0600))
+		config, err := loadNotificationConfig(path)
+		if test.expectedError == "" {
+			require.NoError(t, err, test.desc)
+			assert.Len(t, config.Sinks, 3, test.desc)
+		} else {
+			require.Error(t, err, test.desc)
+			assert.Contains(t, err.Error(), test.expectedError, test.desc)
+		}
+	}
+}
+
+func TestNotifyingEventRecorder(t *testing.T) {
+	type request struct {
+		path    string
+		headers http.Header
+		body    string
+	}
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{path: r.URL.Path, headers: r.Header, body: string(body)}
+	}))
+	defer server.Close()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("secret\n"), 0600))
+
+	n := newNotifier("disk.csi.azure.com", &notificationConfig{Sinks: []notificationSinkConfig{
+		{Name: "ops", Type: "webhook", URL: server.URL + "/webhook", Headers: map[string]string{"Authorization": "Bearer token"}},
+		{Name: "alerts", Type: "Slack", URL: server.URL + "/slack"},
+		{Name: "grid", Type: "eventgrid", URL: server.URL + "/eventgrid", KeyFile: keyFile},
+	}})
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder := newNotifyingEventRecorder(fakeRecorder, n)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	recorder.Eventf(getPersistentVolumeReference("pv-1"), v1.EventTypeNormal, snapshotCopyCompletedReason, "copy completed")
+	recorder.Eventf(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}, v1.EventTypeWarning, forceDetachEscalatedReason, "detach of disk %s escalated", "disk-1")
+	assert.Equal(t, "Normal SnapshotCopyCompleted copy completed", <-fakeRecorder.Events)
+	assert.Equal(t, "Warning ForceDetachEscalated detach of disk disk-1 escalated", <-fakeRecorder.Events)
+
+	received := map[string]request{}
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-requests:
+			received[r.path] = r
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for notifications, received %v", received)
+		}
+	}
+
+	expected := notification{}
+	require.NoError(t, json.Unmarshal([]byte(received["/webhook"].body), &expected))
+	assert.Equal(t, "disk.csi.azure.com", expected.Driver)
+	assert.Equal(t, forceDetachEscalatedReason, expected.Reason)
+	assert.Equal(t, "Node/node-1", expected.Object)
+	assert.Equal(t, "detach of disk disk-1 escalated", expected.Message)
+	assert.Equal(t, "Bearer token", received["/webhook"].headers.Get("Authorization"))
+
+	assert.JSONEq(t, `{"text":"[disk.csi.azure.com] Warning ForceDetachEscalated Node/node-1: detach of disk disk-1 escalated"}`, received["/slack"].body)
+
+	var events []map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(received["/eventgrid"].body), &events))
+	require.Len(t, events, 1)
+	assert.Equal(t, "AzureDiskCSIDriver.ForceDetachEscalated", events[0]["eventType"])
+	assert.Equal(t, "Node/node-1", events[0]["subject"])
+	assert.Equal(t, "secret", received["/eventgrid"].headers.Get("aeg-sas-key"))
+
+	select {
+	case r := <-requests:
+		t.Errorf("unexpected notification %v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCheckAttachSLO(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	d := &DriverCore{eventRecorder: recorder}
+
+	d.checkAttachSLO("pv-1", "disk-1", "node-1", time.Hour, false)
+	d.attachSLOSeconds = 60
+	d.checkAttachSLO("pv-1", "disk-1", "node-1", 30*time.Second, true)
+	d.checkAttachSLO("pv-1", "disk-1", "node-1", 90*time.Second, false)
+
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning AttachSLOExceeded attaching disk disk-1 to node node-1 failed after 1m30s, exceeding the attach SLO of 60s", <-recorder.Events)
+}
+
+func TestRecordEventWithoutObject(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	n := newNotifier("disk.csi.azure.com", &notificationConfig{})
+	d := &DriverCore{eventRecorder: newNotifyingEventRecorder(recorder, n), notifier: n}
+
+	// a static disk without PV name has no event, the notification is still sent
+	d.recordEvent(getPersistentVolumeReference(""), v1.EventTypeWarning, diskQuotaExceededReason, "quota of disk %s exceeded", "disk-1")
+	assert.Empty(t, recorder.Events)
+	require.Len(t, n.queue, 1)
+	notification := <-n.queue
+	assert.Equal(t, diskQuotaExceededReason, notification.Reason)
+	assert.Empty(t, notification.Object)
+	assert.Equal(t, "quota of disk disk-1 exceeded", notification.Message)
+
+	// events with object are forwarded once by the recorder
+	d.recordEvent(getPersistentVolumeReference("pv-1"), v1.EventTypeWarning, diskQuotaExceededReason, "quota of disk %s exceeded", "disk-1")
+	assert.Len(t, recorder.Events, 1)
+	require.Len(t, n.queue, 1)
+	assert.Equal(t, "PersistentVolume/pv-1", (<-n.queue).Object)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// orphanDiskDeleteQPS is the maximum number of orphaned disks deleted per second, deleting a disk is not urgent
+const orphanDiskDeleteQPS = 0.2
+
+// runOrphanDiskGC deletes the disks created by the driver for the cluster which are not referenced by any PV and
+// older than the orphan disk TTL every interval, the disks are only reported in dry run mode
+func (d *Driver) runOrphanDiskGC(ctx context.Context, interval time.Duration) {
+	klog.V(2).Infof("collecting orphaned disks older than %v every %v, dry run: %t", d.orphanDiskTTL, interval, d.orphanDiskGCOptions.DryRun)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := d.collectOrphanDisks(ctx, time.Now()); err != nil {
+			klog.Errorf("failed to collect orphaned disks: %v", err)
+		}
+	}, interval)
+}
+
+// collectOrphanDisks lists the disks in the resource group of the cluster and the resource groups of the PVs, and
+// deletes the orphaned ones
+func (d *Driver) collectOrphanDisks(ctx context.Context, now time.Time) error {
+	clusterID, err := d.getClusterID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster ID: %w", err)
+	}
+	if clusterID == "" {
+		return fmt.Errorf("cluster ID is empty")
+	}
+	pvs, err := d.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list PVs: %w", err)
+	}
+	// disk URIs of all PVs are lower case, including the PVs of other drivers and in-tree PVs referencing the disks
+	volumeHandles := map[string]bool{}
+	// <lower case disk URI, PV name> of the PVs with Retain policy
+	retainedVolumes := map[string]string{}
+	// <lower case subscription/resource group, [subscription, resource group]>
+	resourceGroups := map[string][2]string{
+		strings.ToLower(d.getCloud().SubscriptionID + "/" + d.getCloud().ResourceGroup): {d.getCloud().SubscriptionID, d.getCloud().ResourceGroup},
+	}
+	for i := range pvs.Items {
+		var diskURI string
+		switch pv := &pvs.Items[i]; {
+		case pv.Spec.CSI != nil:
+			diskURI = pv.Spec.CSI.VolumeHandle
+		case pv.Spec.AzureDisk != nil:
+			diskURI = pv.Spec.AzureDisk.DataDiskURI
+		default:
+			continue
+		}
+		volumeHandles[strings.ToLower(diskURI)] = true
+		if pvs.Items[i].Spec.PersistentVolumeReclaimPolicy == v1.PersistentVolumeReclaimRetain {
+			retainedVolumes[strings.ToLower(diskURI)] = pvs.Items[i].Name
+		}
+		if resourceGroup, subsID, err := getInfoFromDiskURI(diskURI); err == nil {
+			resourceGroups[strings.ToLower(subsID+"/"+resourceGroup)] = [2]string{subsID, resourceGroup}
+		}
+	}
+
+	var disks []*armcompute.Disk
+	for _, rg := range resourceGroups {
+		diskClient, err := d.getClientFactory().GetDiskClientForSub(rg[0])
+		if err != nil {
+			klog.Errorf("could not get disk client for subscription(%s) with error(%v)", rg[0], err)
+			continue
+		}
+		list, err := diskClient.List(ctx, rg[1])
+		if err != nil {
+			klog.Errorf("failed to list disks under rg(%s) of subscription(%s): %v", rg[1], rg[0], err)
+			continue
+		}
+		disks = append(disks, list...)
+	}
+	d.tagRetainedDisks(ctx, disks, retainedVolumes)
+
+	for _, disk := range getOrphanDisks(disks, clusterID, volumeHandles, now.Add(-d.orphanDiskTTL)) {
+		diskURI := *disk.ID
+		if d.orphanDiskGCOptions.DryRun {
+			klog.Infof("orphaned disk %s created at %v is not referenced by any PV, it would be deleted without dry run", diskURI, *disk.Properties.TimeCreated)
+			continue
+		}
+		if err := d.orphanDiskGCRateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+		// the disk is checked again before it's deleted, an attached disk is not deleted
+		if err := d.getDiskController().DeleteManagedDisk(ctx, diskURI); err != nil {
+			klog.Errorf("failed to delete orphaned disk %s: %v", diskURI, err)
+			continue
+		}
+		klog.V(2).Infof("deleted orphaned disk %s created at %v, it's not referenced by any PV", diskURI, *disk.Properties.TimeCreated)
+	}
+	return nil
+}
+
+// tagRetainedDisks sets the retain tag on the disks of the PVs with Retain policy, so the disks are not collected
+// once the PVs are deleted. Failures are only logged, the disks are referenced by the PVs in this pass.
+func (d *Driver) tagRetainedDisks(ctx context.Context, disks []*armcompute.Disk, retainedVolumes map[string]string) {
+	for _, disk := range disks {
+		if disk == nil || disk.ID == nil {
+			continue
+		}
+		pvName, ok := retainedVolumes[strings.ToLower(*disk.ID)]
+		if !ok {
+			continue
+		}
+		if _, ok := disk.Tags[consts.RetainDiskTag]; ok {
+			continue
+		}
+		tagsClient, err := d.getResourceTagsClient()
+		if err != nil {
+			klog.Errorf("failed to get resource tags client: %v", err)
+			return
+		}
+		if err := tagsClient.MergeTags(ctx, *disk.ID, map[string]string{consts.RetainDiskTag: pvName}); err != nil {
+			klog.Errorf("failed to set tag %s on disk %s of PV %s with Retain policy: %v", consts.RetainDiskTag, *disk.ID, pvName, err)
+			continue
+		}
+		klog.V(2).Infof("set tag %s on disk %s of PV %s with Retain policy", consts.RetainDiskTag, *disk.ID, pvName)
+	}
+}
+
+// getOrphanDisks returns the unattached disks provisioned by the driver with the owner tag of clusterID before
+// createdBefore, which are not in volumeHandles. Disks without the owner tag are skipped since they could be
+// created by another cluster sharing the resource group, so are the disks without the PV name tag set by the
+// provisioner, e.g. disks created from the API with the tags of the driver, and the disks with the retain tag.
+func getOrphanDisks(disks []*armcompute.Disk, clusterID string, volumeHandles map[string]bool, createdBefore time.Time) []*armcompute.Disk {
+	var orphans []*armcompute.Disk
+	for _, disk := range disks {
+		if disk == nil || disk.ID == nil || disk.Properties == nil || disk.Properties.TimeCreated == nil {
+			continue
+		}
+		if ptr.Deref(disk.Tags[azureconsts.CreatedByTag], "") != consts.AzureDiskDriverTag || ptr.Deref(disk.Tags[consts.OwnerTag], "") != clusterID {
+			continue
+		}
+		if _, ok := disk.Tags[consts.PvNameTag]; !ok {
+			continue
+		}
+		if _, ok := disk.Tags[consts.RetainDiskTag]; ok {
+			continue
+		}
+		if disk.ManagedBy != nil || len(disk.ManagedByExtended) > 0 || !disk.Properties.TimeCreated.Before(createdBefore) {
+			continue
+		}
+		if volumeHandles[strings.ToLower(*disk.ID)] {
+			continue
+		}
+		orphans = append(orphans, disk)
+	}
+	return orphans
+}
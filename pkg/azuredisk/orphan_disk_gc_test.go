@@ -0,0 +1,135 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func newTestOrphanDisk(subsID, resourceGroup, name string, created time.Time, tags map[string]string) *armcompute.Disk {
+	disk := &armcompute.Disk{
+		ID:         ptr.To(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/%s", subsID, resourceGroup, name)),
+		Name:       ptr.To(name),
+		Tags:       map[string]*string{},
+		Properties: &armcompute.DiskProperties{TimeCreated: ptr.To(created)},
+	}
+	for k, v := range tags {
+		disk.Tags[k] = ptr.To(v)
+	}
+	return disk
+}
+
+func TestGetOrphanDisks(t *testing.T) {
+	now := time.Now()
+	owned := map[string]string{azureconsts.CreatedByTag: consts.AzureDiskDriverTag, consts.OwnerTag: "cluster-id", consts.PvNameTag: "pv"}
+	orphan := newTestOrphanDisk("sub", "rg", "orphan", now.Add(-2*time.Hour), owned)
+	bound := newTestOrphanDisk("sub", "rg", "Bound", now.Add(-2*time.Hour), owned)
+	attached := newTestOrphanDisk("sub", "rg", "attached", now.Add(-2*time.Hour), owned)
+	attached.ManagedBy = ptr.To("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm")
+	disks := []*armcompute.Disk{
+		orphan,
+		bound,
+		attached,
+		nil,
+		newTestOrphanDisk("sub", "rg", "new", now.Add(-time.Minute), owned),
+		newTestOrphanDisk("sub", "rg", "other-cluster", now.Add(-2*time.Hour), map[string]string{azureconsts.CreatedByTag: consts.AzureDiskDriverTag, consts.OwnerTag: "other"}),
+		newTestOrphanDisk("sub", "rg", "no-owner", now.Add(-2*time.Hour), map[string]string{azureconsts.CreatedByTag: consts.AzureDiskDriverTag}),
+		newTestOrphanDisk("sub", "rg", "not-created-by-driver", now.Add(-2*time.Hour), map[string]string{consts.OwnerTag: "cluster-id", consts.PvNameTag: "pv"}),
+		newTestOrphanDisk("sub", "rg", "not-provisioned", now.Add(-2*time.Hour), map[string]string{azureconsts.CreatedByTag: consts.AzureDiskDriverTag, consts.OwnerTag: "cluster-id"}),
+		newTestOrphanDisk("sub", "rg", "retained", now.Add(-2*time.Hour), map[string]string{
+			azureconsts.CreatedByTag: consts.AzureDiskDriverTag, consts.OwnerTag: "cluster-id", consts.PvNameTag: "pv", consts.RetainDiskTag: "",
+		}),
+	}
+	volumeHandles := map[string]bool{strings.ToLower(*bound.ID): true}
+
+	assert.Equal(t, []*armcompute.Disk{orphan}, getOrphanDisks(disks, "cluster-id", volumeHandles, now.Add(-time.Hour)))
+}
+
+func TestCollectOrphanDisks(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now()
+	subsID, resourceGroup := d.getCloud().SubscriptionID, d.getCloud().ResourceGroup
+	d.orphanDiskTTL = time.Hour
+	d.orphanDiskGCRateLimiter = flowcontrol.NewFakeAlwaysRateLimiter()
+
+	owned := map[string]string{azureconsts.CreatedByTag: consts.AzureDiskDriverTag, consts.OwnerTag: "cluster-id", consts.PvNameTag: "pv"}
+	orphan := newTestOrphanDisk(subsID, resourceGroup, "orphan", now.Add(-2*time.Hour), owned)
+	bound := newTestOrphanDisk("other-sub", "other-rg", "bound", now.Add(-2*time.Hour), owned)
+	retained := newTestOrphanDisk("other-sub", "other-rg", "retained", now.Add(-2*time.Hour), owned)
+	d.kubeClient = fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceSystem, UID: "cluster-id"}},
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+			Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: d.Name, VolumeHandle: *bound.ID},
+			}},
+		},
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-retained"},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimRetain,
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{Driver: d.Name, VolumeHandle: *retained.ID},
+				},
+			},
+		},
+	)
+	tagsClient := &fakeResourceTagsClient{}
+	d.resourceTagsClient = tagsClient
+
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	otherDiskClient := mock_diskclient.NewMockInterface(cntl)
+	clientFactory := d.getClientFactory().(*mock_azclient.MockClientFactory)
+	clientFactory.EXPECT().GetDiskClientForSub(subsID).Return(diskClient, nil).AnyTimes()
+	clientFactory.EXPECT().GetDiskClientForSub("other-sub").Return(otherDiskClient, nil).AnyTimes()
+	diskClient.EXPECT().List(gomock.Any(), resourceGroup).Return([]*armcompute.Disk{orphan}, nil).Times(2)
+	otherDiskClient.EXPECT().List(gomock.Any(), "other-rg").Return([]*armcompute.Disk{bound, retained}, nil).Times(2)
+
+	// orphaned disks are only reported in dry run mode
+	d.orphanDiskGCOptions.DryRun = true
+	require.NoError(t, d.collectOrphanDisks(ctx, now))
+	// the disk of the PV with Retain policy is tagged, so it's kept after the PV is deleted
+	assert.Equal(t, map[string]map[string]string{*retained.ID: {consts.RetainDiskTag: "pv-retained"}}, tagsClient.merged)
+
+	d.orphanDiskGCOptions.DryRun = false
+	diskClient.EXPECT().Get(gomock.Any(), resourceGroup, "orphan").Return(orphan, nil).Times(1)
+	diskClient.EXPECT().Delete(gomock.Any(), resourceGroup, "orphan").Return(nil).Times(1)
+	require.NoError(t, d.collectOrphanDisks(ctx, now))
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+// publishContextCache persists the publish contexts of the volumes staged on the node in a node-local directory,
+// one file per volume, so that a stage or publish request without the lun, e.g. retried by kubelet while the
+// VolumeAttachment is not readable after a restart of the node plugin, can still find the device of the volume
+type publishContextCache struct {
+	dir string
+	// identify returns the identity of the disk attached at lun, the cached lun of a volume is only used if the
+	// disk at the lun is still the disk staged for the volume, since the lun is reused after the disk is detached
+	identify func(lun string) (string, error)
+}
+
+// cachedPublishContext is the file of the publish context of a staged volume
+type cachedPublishContext struct {
+	PublishContext map[string]string `json:"publishContext"`
+	// DiskIdentity is the identity of the disk at the lun when the volume was staged
+	DiskIdentity string `json:"diskIdentity"`
+}
+
+func newPublishContextCache(dir string, identify func(lun string) (string, error)) *publishContextCache {
+	return &publishContextCache{dir: dir, identify: identify}
+}
+
+// path returns the file of the publish context of volumeID, volume IDs are case insensitive disk URIs
+func (c *publishContextCache) path(volumeID string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(volumeID)))
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:])+".json")
+}
+
+// load returns the cached publish context of volumeID, nil if it's not cached
+func (c *publishContextCache) load(volumeID string) (*cachedPublishContext, error) {
+	content, err := os.ReadFile(c.path(volumeID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cached := &cachedPublishContext{}
+	if err := json.Unmarshal(content, cached); err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+// save writes the publish context of volumeID through a temporary file so that a crash never leaves a partial file
+func (c *publishContextCache) save(volumeID string, cached *cachedPublishContext) error {
+	if err := os.MkdirAll(c.dir, 0750); err != nil {
+		return err
+	}
+	content, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	path := c.path(volumeID)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// remove deletes the cached publish context of volumeID
+func (c *publishContextCache) remove(volumeID string) error {
+	if err := os.Remove(c.path(volumeID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// resolvePublishContext returns the publish context of the request if it has the lun. Otherwise the cached publish
+// context of the volume is returned if the disk attached at the cached lun is still the disk staged for the volume,
+// so that the lun of a detached disk is never used for another disk. Cache failures are only logged since the cache
+// is a fallback. It's a no-op if the cache is disabled.
+func (d *DriverCore) resolvePublishContext(volumeID string, publishContext map[string]string) map[string]string {
+	if d.publishContextCache == nil {
+		return publishContext
+	}
+	if _, ok := publishContext[consts.LUN]; ok {
+		return publishContext
+	}
+	cached, err := d.publishContextCache.load(volumeID)
+	if err != nil {
+		klog.Warningf("failed to load cached publish context of volume %s: %v", volumeID, err)
+		return publishContext
+	}
+	if cached == nil || cached.DiskIdentity == "" {
+		return publishContext
+	}
+	lun := cached.PublishContext[consts.LUN]
+	identity, err := d.publishContextCache.identify(lun)
+	if err != nil || identity != cached.DiskIdentity {
+		klog.Warningf("cached publish context of volume %s is not used since the disk at lun %s is not the staged disk(%s), identity: %s, error: %v",
+			volumeID, lun, cached.DiskIdentity, identity, err)
+		return publishContext
+	}
+	klog.V(2).Infof("lun of volume %s is not in the request, use the cached publish context %v", volumeID, cached.PublishContext)
+	return cached.PublishContext
+}
+
+// cachePublishContext caches the publish context of the request with the lun after the device of the volume is found,
+// with the identity of the disk at the lun. It's not cached if the identity of the disk is not available.
+func (d *DriverCore) cachePublishContext(volumeID string, publishContext map[string]string) {
+	if d.publishContextCache == nil {
+		return
+	}
+	lun, ok := publishContext[consts.LUN]
+	if !ok {
+		return
+	}
+	identity, err := d.publishContextCache.identify(lun)
+	if err != nil {
+		klog.Warningf("publish context of volume %s is not cached since the identity of the disk at lun %s is not available: %v", volumeID, lun, err)
+		return
+	}
+	cached, err := d.publishContextCache.load(volumeID)
+	if err == nil && cached != nil && cached.DiskIdentity == identity && reflect.DeepEqual(cached.PublishContext, publishContext) {
+		return
+	}
+	if err := d.publishContextCache.save(volumeID, &cachedPublishContext{PublishContext: publishContext, DiskIdentity: identity}); err != nil {
+		klog.Warningf("failed to cache publish context of volume %s: %v", volumeID, err)
+	}
+}
+
+// getDiskIdentityByLun returns the identity of the disk attached at lun without rescanning the devices
+func (d *DriverCore) getDiskIdentityByLun(lunStr string) (string, error) {
+	lun, err := azureutils.GetDiskLUN(lunStr)
+	if err != nil {
+		return "", err
+	}
+	device, err := findDiskByLun(int(lun), d.ioHandler, d.mounter)
+	if err != nil {
+		return "", err
+	}
+	if device == "" {
+		return "", fmt.Errorf("no disk is found at lun %s", lunStr)
+	}
+	return getDiskIdentity(device, d.ioHandler)
+}
+
+// forgetPublishContext removes the cached publish context of the unstaged volume
+func (d *DriverCore) forgetPublishContext(volumeID string) {
+	if d.publishContextCache == nil {
+		return
+	}
+	if err := d.publishContextCache.remove(volumeID); err != nil {
+		klog.Warningf("failed to remove cached publish context of volume %s: %v", volumeID, err)
+	}
+}
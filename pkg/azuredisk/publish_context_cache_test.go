@@ -0,0 +1,110 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// fakeDiskIdentities returns the identity of the disk at lun from a map, so that a lun could be reused by another disk
+type fakeDiskIdentities map[string]string
+
+func (f fakeDiskIdentities) identify(lun string) (string, error) {
+	identity, ok := f[lun]
+	if !ok {
+		return "", fmt.Errorf("no disk is found at lun %s", lun)
+	}
+	return identity, nil
+}
+
+func TestResolvePublishContext(t *testing.T) {
+	volumeID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk1"
+	publishContext := map[string]string{consts.LUN: "1", consts.LogicalSectorSizeField: "4096"}
+	disks := fakeDiskIdentities{"1": "wwid-disk1"}
+
+	// disabled by default
+	d := &DriverCore{}
+	assert.Equal(t, map[string]string{}, d.resolvePublishContext(volumeID, map[string]string{}))
+	d.cachePublishContext(volumeID, publishContext)
+
+	dir := filepath.Join(t.TempDir(), "publish-contexts")
+	d.publishContextCache = newPublishContextCache(dir, disks.identify)
+	assert.Equal(t, map[string]string{}, d.resolvePublishContext(volumeID, map[string]string{}), "nothing cached yet")
+	assert.Equal(t, publishContext, d.resolvePublishContext(volumeID, publishContext))
+	d.cachePublishContext(volumeID, publishContext)
+
+	// a request without the lun gets the cached publish context, volume IDs are case insensitive
+	assert.Equal(t, publishContext, d.resolvePublishContext(strings.ToUpper(volumeID), nil))
+	assert.Equal(t, &cachedPublishContext{PublishContext: publishContext, DiskIdentity: "wwid-disk1"},
+		newPublishContextCache(dir, disks.identify).mustLoad(t, volumeID), "cache survives restarts")
+
+	// the cached lun is not used after it's reused by another disk, or the disk is detached
+	disks["1"] = "wwid-disk2"
+	assert.Nil(t, d.resolvePublishContext(volumeID, nil))
+	delete(disks, "1")
+	assert.Nil(t, d.resolvePublishContext(volumeID, nil))
+
+	// a new lun replaces the cached one
+	disks["2"] = "wwid-disk1"
+	newPublishContext := map[string]string{consts.LUN: "2"}
+	assert.Equal(t, newPublishContext, d.resolvePublishContext(volumeID, newPublishContext))
+	d.cachePublishContext(volumeID, newPublishContext)
+	assert.Equal(t, newPublishContext, d.resolvePublishContext(volumeID, nil))
+
+	// nothing is cached without the identity of the disk
+	otherVolumeID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk3"
+	d.cachePublishContext(otherVolumeID, map[string]string{consts.LUN: "3"})
+	assert.Nil(t, d.publishContextCache.mustLoad(t, otherVolumeID))
+
+	d.forgetPublishContext(volumeID)
+	assert.Nil(t, d.resolvePublishContext(volumeID, nil))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	// forgetting a volume not cached is a no-op
+	d.forgetPublishContext(volumeID)
+}
+
+func TestPublishContextCacheCorruptedFile(t *testing.T) {
+	volumeID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk1"
+	disks := fakeDiskIdentities{"0": "wwid-disk1"}
+	d := &DriverCore{publishContextCache: newPublishContextCache(t.TempDir(), disks.identify)}
+	assert.NoError(t, os.WriteFile(d.publishContextCache.path(volumeID), []byte("{"), 0600))
+
+	_, err := d.publishContextCache.load(volumeID)
+	assert.Error(t, err)
+	assert.Nil(t, d.resolvePublishContext(volumeID, nil))
+
+	// a corrupted file is overwritten by the next request with the lun
+	publishContext := map[string]string{consts.LUN: "0"}
+	d.cachePublishContext(volumeID, publishContext)
+	assert.Equal(t, &cachedPublishContext{PublishContext: publishContext, DiskIdentity: "wwid-disk1"}, d.publishContextCache.mustLoad(t, volumeID))
+}
+
+func (c *publishContextCache) mustLoad(t *testing.T, volumeID string) *cachedPublishContext {
+	cached, err := c.load(volumeID)
+	assert.NoError(t, err)
+	return cached
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/diskmigration"
+)
+
+const (
+	pvNodeAffinityOutdatedReason = "NodeAffinityOutdated"
+	pvNodeAffinityUpdatedReason  = "NodeAffinityUpdated"
+)
+
+var (
+	// pvReplaceTimeout is the maximum time of waiting for the deletion of a PV replaced with the updated node affinity
+	pvReplaceTimeout      = 2 * time.Minute
+	pvReplacePollInterval = time.Second
+)
+
+// runPVNodeAffinityReconciler checks the PVs of the driver every interval and reports or updates the node affinity of
+// the PVs whose disks were converted to ZRS or moved to another zone, so that their pods are not pinned to the original
+// zone. The PVs are read from an informer cache and the disks are listed once per resource group.
+func (d *Driver) runPVNodeAffinityReconciler(ctx context.Context, interval time.Duration) {
+	factory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	pvLister := factory.Core().V1().PersistentVolumes().Lister()
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	for _, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return
+		}
+	}
+	klog.V(2).Infof("reconciling node affinity of PVs every %v", interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		pvs, err := pvLister.List(labels.Everything())
+		if err != nil {
+			klog.Errorf("failed to list PVs: %v", err)
+			return
+		}
+		d.reconcilePVNodeAffinities(ctx, pvs)
+	}, interval)
+}
+
+// reconcilePVNodeAffinities reconciles the node affinity of the zonal PVs of the driver against the zones of their
+// disks, which are listed once per resource group instead of one GET per PV
+func (d *Driver) reconcilePVNodeAffinities(ctx context.Context, pvs []*v1.PersistentVolume) {
+	// <subscription/resource group, PVs> of the PVs to check
+	groups := map[string][]*v1.PersistentVolume{}
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.DeletionTimestamp != nil {
+			continue
+		}
+		if _, ok := getPVNodeAffinityZones(pv); !ok {
+			continue
+		}
+		diskURI := pv.Spec.CSI.VolumeHandle
+		resourceGroup, err := azureutils.GetResourceGroupFromURI(diskURI)
+		if err != nil {
+			klog.V(4).Infof("skip reconciling node affinity of PV %s: %v", pv.Name, err)
+			continue
+		}
+		key := strings.ToLower(azureutils.GetSubscriptionIDFromURI(diskURI) + "/" + resourceGroup)
+		groups[key] = append(groups[key], pv)
+	}
+
+	for key, groupPVs := range groups {
+		subsID, resourceGroup, _ := strings.Cut(key, "/")
+		diskClient, err := d.getClientFactory().GetDiskClientForSub(subsID)
+		if err != nil {
+			klog.Errorf("failed to get disk client of subscription %s: %v", subsID, err)
+			continue
+		}
+		disks, err := diskClient.List(ctx, resourceGroup)
+		if err != nil {
+			klog.Errorf("failed to list disks in resource group %s: %v", resourceGroup, err)
+			continue
+		}
+		disksByName := make(map[string]*armcompute.Disk, len(disks))
+		for _, disk := range disks {
+			if disk != nil && disk.Name != nil {
+				disksByName[strings.ToLower(*disk.Name)] = disk
+			}
+		}
+		for _, pv := range groupPVs {
+			diskName, err := azureutils.GetDiskName(pv.Spec.CSI.VolumeHandle)
+			if err != nil {
+				continue
+			}
+			disk, ok := disksByName[strings.ToLower(diskName)]
+			if !ok {
+				klog.V(4).Infof("skip reconciling node affinity of PV %s: disk %s is not found", pv.Name, pv.Spec.CSI.VolumeHandle)
+				continue
+			}
+			if err := d.reconcilePVNodeAffinity(ctx, pv, disk); err != nil {
+				klog.Errorf("failed to reconcile node affinity of PV %s: %v", pv.Name, err)
+			}
+		}
+	}
+}
+
+// reconcilePVNodeAffinity reports a node affinity of pv which does not match the current zones of its disk with a
+// warning event. The node affinity of a PV is immutable, so a PV annotated with disk.csi.azure.com/update-node-affinity=true
+// is replaced with a PV of the updated node affinity: it's retained, deleted and recreated with the same claimRef once
+// it's not in use. PVs whose node affinity has other requirements than the zone are skipped.
+func (d *Driver) reconcilePVNodeAffinity(ctx context.Context, pv *v1.PersistentVolume, disk *armcompute.Disk) error {
+	currentZones, ok := getPVNodeAffinityZones(pv)
+	if !ok {
+		return nil
+	}
+	diskURI := pv.Spec.CSI.VolumeHandle
+	desiredZones, err := getDiskAccessibleZones(disk)
+	if err != nil {
+		return fmt.Errorf("failed to get zones of disk %s: %w", diskURI, err)
+	}
+	if currentZones.Equal(desiredZones) {
+		return nil
+	}
+
+	ref := &v1.ObjectReference{Kind: "PersistentVolume", APIVersion: "v1", Name: pv.Name, UID: pv.UID}
+	if !strings.EqualFold(pv.Annotations[consts.PVNodeAffinityUpdateAnnotation], "true") {
+		d.recordEvent(ref, v1.EventTypeWarning, pvNodeAffinityOutdatedReason,
+			"node affinity allows zones %v while the disk is accessible from zones %v, annotate the PV with %s=true to replace it with the updated node affinity once it's not in use",
+			sets.List(currentZones), sets.List(desiredZones), consts.PVNodeAffinityUpdateAnnotation)
+		return nil
+	}
+	reason, err := diskmigration.InUse(ctx, d.kubeClient, pv)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		klog.V(2).Infof("node affinity of PV %s allows zones %v while disk %s is accessible from zones %v, %s", pv.Name, sets.List(currentZones), diskURI, sets.List(desiredZones), reason)
+		d.recordEvent(ref, v1.EventTypeWarning, pvNodeAffinityOutdatedReason,
+			"node affinity allows zones %v while the disk is accessible from zones %v, it's updated once the PV is not in use: %s", sets.List(currentZones), sets.List(desiredZones), reason)
+		return nil
+	}
+
+	klog.V(2).Infof("replacing PV %s to update its node affinity from zones %v to %v", pv.Name, sets.List(currentZones), sets.List(desiredZones))
+	if err := diskmigration.ReplacePV(ctx, d.kubeClient, pv, newPVWithZones(pv, desiredZones), pvReplacePollInterval, pvReplaceTimeout); err != nil {
+		return err
+	}
+	d.recordEvent(ref, v1.EventTypeNormal, pvNodeAffinityUpdatedReason, "node affinity is updated from zones %v to %v", sets.List(currentZones), sets.List(desiredZones))
+	return nil
+}
+
+// getDiskAccessibleZones returns the zones the disk could be attached from, which are the same as the accessible
+// topology of a volume created by CreateVolume, "" stands for the nodes out of availability zones
+func getDiskAccessibleZones(disk *armcompute.Disk) (sets.Set[string], error) {
+	if disk.SKU == nil || disk.SKU.Name == nil {
+		return nil, fmt.Errorf("sku of the disk is empty")
+	}
+	location := strings.ToLower(ptr.Deref(disk.Location, ""))
+	var diskZone string
+	if len(disk.Zones) > 0 && disk.Zones[0] != nil {
+		diskZone = fmt.Sprintf("%s-%s", location, *disk.Zones[0])
+	}
+	_, topology := getAccessibleTopology(armcompute.DiskStorageAccountTypes(*disk.SKU.Name), diskZone, location)
+	zones := sets.New[string]()
+	for _, t := range topology {
+		zones.Insert(t.Segments[topologyKey])
+	}
+	return zones, nil
+}
+
+// getPVNodeAffinityZones returns the zones allowed by the node affinity of pv, false is returned if the node affinity
+// is empty or has requirements other than the zone
+func getPVNodeAffinityZones(pv *v1.PersistentVolume) (sets.Set[string], bool) {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil || len(pv.Spec.NodeAffinity.Required.NodeSelectorTerms) == 0 {
+		return nil, false
+	}
+	zoneKeys := sets.New(topologyKey, consts.WellKnownTopologyKey, v1.LabelFailureDomainBetaZone)
+	zones := sets.New[string]()
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		if len(term.MatchExpressions) != 1 || len(term.MatchFields) > 0 {
+			return nil, false
+		}
+		expression := term.MatchExpressions[0]
+		if !zoneKeys.Has(expression.Key) || expression.Operator != v1.NodeSelectorOpIn {
+			return nil, false
+		}
+		for _, zone := range expression.Values {
+			zones.Insert(strings.ToLower(zone))
+		}
+	}
+	return zones, true
+}
+
+// newPVWithZones returns a copy of pv to be created after pv is deleted, with the node affinity of zones in the same
+// form as the node affinity set by the external-provisioner, one term per zone
+func newPVWithZones(pv *v1.PersistentVolume, zones sets.Set[string]) *v1.PersistentVolume {
+	newPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pv.Name,
+			Labels:      map[string]string{},
+			Annotations: pv.Annotations,
+		},
+		Spec: *pv.Spec.DeepCopy(),
+	}
+	for k, v := range pv.Labels {
+		// zone labels of the PV are not updated by anyone else
+		if k != v1.LabelTopologyZone && k != v1.LabelFailureDomainBetaZone {
+			newPV.Labels[k] = v
+		}
+	}
+	if newPV.Spec.ClaimRef != nil {
+		newPV.Spec.ClaimRef.ResourceVersion = ""
+	}
+	terms := make([]v1.NodeSelectorTerm, 0, zones.Len())
+	for _, zone := range sets.List(zones) {
+		terms = append(terms, v1.NodeSelectorTerm{
+			MatchExpressions: []v1.NodeSelectorRequirement{{Key: topologyKey, Operator: v1.NodeSelectorOpIn, Values: []string{zone}}},
+		})
+	}
+	newPV.Spec.NodeAffinity = &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: terms}}
+	return newPV
+}
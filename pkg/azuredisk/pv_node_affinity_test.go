@@ -0,0 +1,172 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func newTestZonalPV(name, driver, diskName string, zones ...string) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Labels:     map[string]string{v1.LabelTopologyZone: zones[0], "app": "test"},
+			Finalizers: []string{"kubernetes.io/pv-protection"},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{
+				Driver:       driver,
+				VolumeHandle: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/" + diskName,
+			}},
+			ClaimRef: &v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: "default", Name: "pvc-" + name, UID: "uid", ResourceVersion: "1"},
+			NodeAffinity: &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchExpressions: []v1.NodeSelectorRequirement{{Key: topologyKey, Operator: v1.NodeSelectorOpIn, Values: zones}},
+			}}}},
+		},
+	}
+	return pv
+}
+
+func TestReconcilePVNodeAffinity(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	pvReplacePollInterval = time.Millisecond
+	ctx := context.Background()
+
+	hostnamePV := newTestZonalPV("pv-hostname", d.Name, "zrs-disk", "eastus-1")
+	hostnamePV.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions = append(hostnamePV.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions,
+		v1.NodeSelectorRequirement{Key: v1.LabelHostname, Operator: v1.NodeSelectorOpIn, Values: []string{"node-1"}})
+	convertedPV := newTestZonalPV("pv-converted", d.Name, "zrs-disk", "eastus-1")
+	convertedPV.Annotations = map[string]string{consts.PVNodeAffinityUpdateAnnotation: "true"}
+	attachedPV := newTestZonalPV("pv-attached", d.Name, "zrs-disk", "eastus-1")
+	attachedPV.Annotations = map[string]string{consts.PVNodeAffinityUpdateAnnotation: "true"}
+	d.kubeClient = fake.NewSimpleClientset(
+		convertedPV,
+		attachedPV,
+		newTestZonalPV("pv-not-opted-in", d.Name, "zrs-disk", "eastus-1"),
+		newTestZonalPV("pv-disk-not-found", d.Name, "deleted-disk", "eastus-1"),
+		newTestZonalPV("pv-unchanged", d.Name, "lrs-disk", "eastus-2"),
+		newTestZonalPV("pv-other-driver", "file.csi.azure.com", "zrs-disk", "eastus-1"),
+		hostnamePV,
+		&storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: "va-1"},
+			Spec: storagev1.VolumeAttachmentSpec{
+				NodeName: "node-1",
+				Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: ptr.To("pv-attached")},
+			},
+		},
+	)
+
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub("sub").Return(diskClient, nil).AnyTimes()
+	// the disks of a resource group are listed once
+	diskClient.EXPECT().List(gomock.Any(), "rg").Return([]*armcompute.Disk{
+		{
+			Name:     ptr.To("zrs-disk"),
+			Location: ptr.To("eastus"),
+			SKU:      &armcompute.DiskSKU{Name: ptr.To(armcompute.DiskStorageAccountTypesPremiumZRS)},
+		},
+		{
+			Name:     ptr.To("lrs-disk"),
+			Location: ptr.To("eastus"),
+			Zones:    []*string{ptr.To("2")},
+			SKU:      &armcompute.DiskSKU{Name: ptr.To(armcompute.DiskStorageAccountTypesPremiumLRS)},
+		},
+	}, nil).Times(1)
+
+	pvList, err := d.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	pvs := []*v1.PersistentVolume{}
+	for i := range pvList.Items {
+		pvs = append(pvs, &pvList.Items[i])
+	}
+	d.reconcilePVNodeAffinities(ctx, pvs)
+
+	// the PV of the disk converted to ZRS is recreated with the node affinity of all zones, bound to the same PVC
+	pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-converted", metav1.GetOptions{})
+	require.NoError(t, err)
+	zones, ok := getPVNodeAffinityZones(pv)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"", "eastus-1", "eastus-2", "eastus-3"}, sets.List(zones))
+	assert.Equal(t, "pvc-pv-converted", pv.Spec.ClaimRef.Name)
+	assert.Empty(t, pv.Spec.ClaimRef.ResourceVersion)
+	assert.Equal(t, v1.PersistentVolumeReclaimDelete, pv.Spec.PersistentVolumeReclaimPolicy)
+	assert.Equal(t, map[string]string{"app": "test"}, pv.Labels)
+
+	// the attached PV is kept until it's not in use
+	pv, err = d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-attached", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eastus-1"}, pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values)
+
+	// PVs without the annotation are only reported
+	pv, err = d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv-not-opted-in", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eastus-1"}, pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values)
+
+	require.Len(t, recorder.Events, 3)
+	events := <-recorder.Events + <-recorder.Events + <-recorder.Events
+	assert.Equal(t, 2, strings.Count(events, pvNodeAffinityOutdatedReason))
+	assert.Contains(t, events, pvNodeAffinityUpdatedReason)
+	assert.Contains(t, events, consts.PVNodeAffinityUpdateAnnotation)
+}
+
+func TestGetPVNodeAffinityZones(t *testing.T) {
+	pv := newTestZonalPV("pv", fakeDriverName, "disk", "EastUS-1", "eastus-2")
+	zones, ok := getPVNodeAffinityZones(pv)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"eastus-1", "eastus-2"}, sets.List(zones))
+
+	pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Key = v1.LabelFailureDomainBetaZone
+	_, ok = getPVNodeAffinityZones(pv)
+	assert.True(t, ok)
+
+	pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Operator = v1.NodeSelectorOpNotIn
+	_, ok = getPVNodeAffinityZones(pv)
+	assert.False(t, ok)
+
+	pv.Spec.NodeAffinity = nil
+	_, ok = getPVNodeAffinityZones(pv)
+	assert.False(t, ok)
+}
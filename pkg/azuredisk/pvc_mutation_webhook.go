@@ -0,0 +1,213 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/admission"
+)
+
+const (
+	pvcMutationWebhookPath = "/mutate-pvc"
+	// isDefaultStorageClassAnnotation marks the default StorageClass of the cluster
+	isDefaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+)
+
+// pvcMutationRule sets the StorageClass and annotations of the PVCs created in the namespaces matching NamespaceSelector
+type pvcMutationRule struct {
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+	// StorageClassName is set on PVCs without a StorageClass or with the default StorageClass of the cluster
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// Annotations are added to the PVC, annotations already set on the PVC are kept
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	selector labels.Selector
+}
+
+// pvcMutationPolicy is the list of rules of the PVC mutation webhook, the first matching rule is applied
+type pvcMutationPolicy struct {
+	Rules []pvcMutationRule `json:"rules"`
+}
+
+// loadPVCMutationPolicy reads the PVC mutation policy from a YAML or JSON file
+func loadPVCMutationPolicy(path string) (*pvcMutationPolicy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &pvcMutationPolicy{}
+	if err := yaml.UnmarshalStrict(content, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse PVC mutation policy %s: %w", path, err)
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.NamespaceSelector == nil {
+			return nil, fmt.Errorf("namespaceSelector of rule %d must be provided", i)
+		}
+		if rule.selector, err = metav1.LabelSelectorAsSelector(rule.NamespaceSelector); err != nil {
+			return nil, fmt.Errorf("invalid namespaceSelector of rule %d: %w", i, err)
+		}
+		if rule.StorageClassName == "" && len(rule.Annotations) == 0 {
+			return nil, fmt.Errorf("rule %d must set storageClassName or annotations", i)
+		}
+	}
+	return policy, nil
+}
+
+// jsonPatchOperation is a single RFC 6902 JSON patch operation
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// pvcMutationWebhook is a mutating admission webhook applying the PVC mutation policy on PVC creation
+type pvcMutationWebhook struct {
+	policy     *pvcMutationPolicy
+	kubeClient kubernetes.Interface
+}
+
+// mutate returns the JSON patch applying the first rule matching the namespace of the PVC
+func (w *pvcMutationWebhook) mutate(ctx context.Context, pvc *v1.PersistentVolumeClaim) ([]jsonPatchOperation, error) {
+	ns, err := w.kubeClient.CoreV1().Namespaces().Get(ctx, pvc.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %w", pvc.Namespace, err)
+	}
+	var rule *pvcMutationRule
+	for i := range w.policy.Rules {
+		if w.policy.Rules[i].selector.Matches(labels.Set(ns.Labels)) {
+			rule = &w.policy.Rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return nil, nil
+	}
+
+	patch := []jsonPatchOperation{}
+	if rule.StorageClassName != "" {
+		setStorageClass := pvc.Spec.StorageClassName == nil
+		// an empty StorageClass explicitly requests a PV without class, it's kept as is
+		if !setStorageClass && *pvc.Spec.StorageClassName != "" && *pvc.Spec.StorageClassName != rule.StorageClassName {
+			// the default StorageClass may already be set by the DefaultStorageClass admission plugin
+			if setStorageClass, err = w.isDefaultStorageClass(ctx, *pvc.Spec.StorageClassName); err != nil {
+				return nil, err
+			}
+		}
+		if setStorageClass {
+			patch = append(patch, jsonPatchOperation{Op: "add", Path: "/spec/storageClassName", Value: rule.StorageClassName})
+		}
+	}
+	if len(rule.Annotations) > 0 {
+		if pvc.Annotations == nil {
+			patch = append(patch, jsonPatchOperation{Op: "add", Path: "/metadata/annotations", Value: rule.Annotations})
+		} else {
+			keys := make([]string, 0, len(rule.Annotations))
+			for k := range rule.Annotations {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if _, ok := pvc.Annotations[k]; !ok {
+					patch = append(patch, jsonPatchOperation{Op: "add", Path: "/metadata/annotations/" + escapeJSONPointer(k), Value: rule.Annotations[k]})
+				}
+			}
+		}
+	}
+	return patch, nil
+}
+
+func (w *pvcMutationWebhook) isDefaultStorageClass(ctx context.Context, name string) (bool, error) {
+	sc, err := w.kubeClient.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get StorageClass %s: %w", name, err)
+	}
+	return strings.EqualFold(sc.Annotations[isDefaultStorageClassAnnotation], "true"), nil
+}
+
+// review returns the admission response of a PVC admission request, the PVC is always allowed
+func (w *pvcMutationWebhook) review(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Operation != admissionv1.Create || req.Kind.Kind != "PersistentVolumeClaim" {
+		return resp
+	}
+	pvc := &v1.PersistentVolumeClaim{}
+	if err := json.Unmarshal(req.Object.Raw, pvc); err != nil {
+		klog.Errorf("failed to decode PVC in admission request %s: %v", req.UID, err)
+		return resp
+	}
+	if pvc.Namespace == "" {
+		pvc.Namespace = req.Namespace
+	}
+	patch, err := w.mutate(ctx, pvc)
+	if err != nil {
+		klog.Errorf("failed to mutate PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		return resp
+	}
+	if len(patch) == 0 {
+		return resp
+	}
+	if resp.Patch, err = json.Marshal(patch); err != nil {
+		klog.Errorf("failed to marshal patch of PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		return resp
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	resp.PatchType = &patchType
+	klog.V(2).Infof("mutating PVC %s/%s with patch %s", pvc.Namespace, pvc.Name, string(resp.Patch))
+	return resp
+}
+
+// ServeHTTP handles the AdmissionReview requests sent by the API server
+func (w *pvcMutationWebhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	admission.ReviewFunc(w.review).ServeHTTP(rw, r)
+}
+
+// runPVCMutationWebhook serves the PVC mutation webhook over HTTPS until ctx is done
+func (d *Driver) runPVCMutationWebhook(ctx context.Context) {
+	policy, err := loadPVCMutationPolicy(d.pvcMutationWebhookOptions.PolicyFile)
+	if err != nil {
+		klog.Fatalf("failed to load PVC mutation policy: %v", err)
+	}
+	if d.kubeClient == nil {
+		klog.Fatalf("PVC mutation webhook requires a kubernetes client")
+	}
+	webhook := &pvcMutationWebhook{policy: policy, kubeClient: d.kubeClient}
+	name := fmt.Sprintf("PVC mutation webhook with %d rules", len(policy.Rules))
+	if err := admission.Serve(ctx, name, int(d.pvcMutationWebhookOptions.Port), d.pvcMutationWebhookOptions.CertDir, pvcMutationWebhookPath, webhook); err != nil {
+		klog.Errorf("PVC mutation webhook stopped with error: %v", err)
+	}
+}
+
+// escapeJSONPointer escapes a key as a JSON pointer reference token, see RFC 6901
+func escapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+const testPVCMutationPolicy = `
+rules:
+- namespaceSelector:
+    matchLabels:
+      env: prod
+  storageClassName: managed-csi-zrs
+  annotations:
+    example.com/backup: daily
+    example.com/tier: gold
+- namespaceSelector:
+    matchExpressions:
+    - key: env
+      operator: Exists
+  annotations:
+    example.com/tier: silver
+`
+
+func TestLoadPVCMutationPolicy(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		desc        string
+		content     string
+		expectedErr bool
+	}{
+		{
+			desc:    "valid policy",
+			content: testPVCMutationPolicy,
+		},
+		{
+			desc:        "unknown field",
+			content:     "rules:\n- namespaceSelector: {}\n  storageClass: managed-csi\n",
+			expectedErr: true,
+		},
+		{
+			desc:        "missing namespaceSelector",
+			content:     "rules:\n- storageClassName: managed-csi\n",
+			expectedErr: true,
+		},
+		{
+			desc:        "invalid namespaceSelector",
+			content:     "rules:\n- namespaceSelector:\n    matchExpressions:\n    - key: env\n      operator: Invalid\n  storageClassName: managed-csi\n",
+			expectedErr: true,
+		},
+		{
+			desc:        "rule without mutation",
+			content:     "rules:\n- namespaceSelector: {}\n",
+			expectedErr: true,
+		},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, string(rune('a'+i))+".yaml")
+		require.NoError(t, os.WriteFile(path, []byte(test.content), 0600))
+		policy, err := loadPVCMutationPolicy(path)
+		assert.Equal(t, test.expectedErr, err != nil, test.desc)
+		if !test.expectedErr {
+			assert.Len(t, policy.Rules, 2, test.desc)
+		}
+	}
+
+	_, err := loadPVCMutationPolicy(filepath.Join(dir, "notexist.yaml"))
+	assert.Error(t, err)
+}
+
+func TestPVCMutationWebhookMutate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testPVCMutationPolicy), 0600))
+	policy, err := loadPVCMutationPolicy(path)
+	require.NoError(t, err)
+
+	kubeClient := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"env": "dev"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: map[string]string{isDefaultStorageClassAnnotation: "true"}}},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "managed-csi"}},
+	)
+	w := &pvcMutationWebhook{policy: policy, kubeClient: kubeClient}
+
+	tests := []struct {
+		desc          string
+		pvc           *v1.PersistentVolumeClaim
+		expectedPatch []jsonPatchOperation
+		expectedErr   bool
+	}{
+		{
+			desc: "no storage class and no annotations",
+			pvc:  &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "prod"}},
+			expectedPatch: []jsonPatchOperation{
+				{Op: "add", Path: "/spec/storageClassName", Value: "managed-csi-zrs"},
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]string{"example.com/backup": "daily", "example.com/tier": "gold"}},
+			},
+		},
+		{
+			desc: "default storage class and existing annotation",
+			pvc: &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Annotations: map[string]string{"example.com/tier": "bronze"}},
+				Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("default")},
+			},
+			expectedPatch: []jsonPatchOperation{
+				{Op: "add", Path: "/spec/storageClassName", Value: "managed-csi-zrs"},
+				{Op: "add", Path: "/metadata/annotations/example.com~1backup", Value: "daily"},
+			},
+		},
+		{
+			desc: "explicit storage class is kept",
+			pvc: &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Annotations: map[string]string{}},
+				Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("managed-csi")},
+			},
+			expectedPatch: []jsonPatchOperation{
+				{Op: "add", Path: "/metadata/annotations/example.com~1backup", Value: "daily"},
+				{Op: "add", Path: "/metadata/annotations/example.com~1tier", Value: "gold"},
+			},
+		},
+		{
+			desc: "empty storage class is kept",
+			pvc: &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "dev"},
+				Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("")},
+			},
+			expectedPatch: []jsonPatchOperation{
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]string{"example.com/tier": "silver"}},
+			},
+		},
+		{
+			desc: "no matching rule",
+			pvc:  &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "other"}},
+		},
+		{
+			desc:        "namespace not found",
+			pvc:         &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "notexist"}},
+			expectedErr: true,
+		},
+		{
+			desc: "storage class not found",
+			pvc: &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "prod"},
+				Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("notexist")},
+			},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		patch, err := w.mutate(context.Background(), test.pvc)
+		assert.Equal(t, test.expectedErr, err != nil, test.desc)
+		if len(test.expectedPatch) == 0 {
+			assert.Empty(t, patch, test.desc)
+		} else {
+			assert.Equal(t, test.expectedPatch, patch, test.desc)
+		}
+	}
+}
+
+func TestPVCMutationWebhookServeHTTP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testPVCMutationPolicy), 0600))
+	policy, err := loadPVCMutationPolicy(path)
+	require.NoError(t, err)
+	kubeClient := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"env": "dev"}}})
+	w := &pvcMutationWebhook{policy: policy, kubeClient: kubeClient}
+
+	pvc, err := json.Marshal(&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc"}})
+	require.NoError(t, err)
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "uid",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"},
+			Namespace: "dev",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: pvc},
+		},
+	}
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, pvcMutationWebhookPath, bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	result := &admissionv1.AdmissionReview{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), result))
+	require.NotNil(t, result.Response)
+	assert.Nil(t, result.Request)
+	assert.Equal(t, "uid", string(result.Response.UID))
+	assert.True(t, result.Response.Allowed)
+	assert.Equal(t, admissionv1.PatchTypeJSONPatch, *result.Response.PatchType)
+	assert.JSONEq(t, `[{"op":"add","path":"/metadata/annotations","value":{"example.com/tier":"silver"}}]`, string(result.Response.Patch))
+
+	review.Request.Operation = admissionv1.Update
+	body, err = json.Marshal(review)
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, pvcMutationWebhookPath, bytes.NewReader(body)))
+	result = &admissionv1.AdmissionReview{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), result))
+	assert.True(t, result.Response.Allowed)
+	assert.Empty(t, result.Response.Patch)
+
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, pvcMutationWebhookPath, bytes.NewReader([]byte("invalid"))))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+const (
+	invalidStorageClassParametersReason = "InvalidStorageClassParameters"
+	unsupportedAccessModeReason         = "UnsupportedAccessMode"
+	unsatisfiableTopologyReason         = "UnsatisfiableTopology"
+)
+
+// pvcValidationFailure is a misconfiguration of a PVC which would fail the provisioning or attach of its volume
+type pvcValidationFailure struct {
+	reason  string
+	message string
+}
+
+// runPVCValidator validates new pending PVCs of the driver against their StorageClass, the cluster topology and the
+// disk quota of the subscription, failures are reported as warning events of the PVC before the first pod using it
+// is scheduled. The nodes are read from the node informer cache and the usages of a subscription are read once a minute.
+func (d *Driver) runPVCValidator(ctx context.Context) {
+	factory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	usageCache, err := azcache.NewTimedCache(time.Minute, func(ctx context.Context, key string) (interface{}, error) {
+		subscriptionID, location, _ := strings.Cut(key, "/")
+		return d.getDiskUsageClient().List(ctx, subscriptionID, location)
+	}, false)
+	if err != nil {
+		klog.Errorf("failed to create disk usage cache: %v", err)
+		return
+	}
+	d.diskUsageCache = usageCache
+	informer := factory.Core().V1().PersistentVolumeClaims().Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pvc, ok := obj.(*v1.PersistentVolumeClaim); ok {
+				d.validatePVC(ctx, pvc)
+			}
+		},
+	}); err != nil {
+		klog.Errorf("failed to add PVC event handler: %v", err)
+		return
+	}
+	klog.V(2).Infof("validating StorageClass parameters of new PVCs")
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
+
+// validatePVC records a warning event on the PVC for each validation failure, PVCs which are bound
+// or provisioned by other drivers are skipped
+func (d *Driver) validatePVC(ctx context.Context, pvc *v1.PersistentVolumeClaim) {
+	if pvc.Status.Phase != v1.ClaimPending || pvc.Spec.VolumeName != "" || pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return
+	}
+	sc, err := d.kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		klog.V(4).Infof("skip validating PVC %s/%s: failed to get StorageClass %s: %v", pvc.Namespace, pvc.Name, *pvc.Spec.StorageClassName, err)
+		return
+	}
+	if sc.Provisioner != d.Name {
+		return
+	}
+
+	ref := &v1.ObjectReference{
+		APIVersion:      "v1",
+		Kind:            "PersistentVolumeClaim",
+		Namespace:       pvc.Namespace,
+		Name:            pvc.Name,
+		UID:             pvc.UID,
+		ResourceVersion: pvc.ResourceVersion,
+	}
+	for _, failure := range d.getPVCValidationFailures(ctx, pvc, sc) {
+		klog.Warningf("PVC %s/%s: %s", pvc.Namespace, pvc.Name, failure.message)
+		d.recordEvent(ref, v1.EventTypeWarning, failure.reason, "%s", failure.message)
+	}
+}
+
+// getPVCValidationFailures checks the StorageClass parameters the same way as CreateVolume, the access modes of the PVC
+// against maxShares, the disk count quota of skuName in the subscription, and the allowed topologies and skuName of
+// the StorageClass against the zones of the nodes
+func (d *Driver) getPVCValidationFailures(ctx context.Context, pvc *v1.PersistentVolumeClaim, sc *storagev1.StorageClass) []pvcValidationFailure {
+	invalidParameters := func(format string, args ...interface{}) []pvcValidationFailure {
+		return []pvcValidationFailure{{
+			reason:  invalidStorageClassParametersReason,
+			message: fmt.Sprintf("StorageClass %s: %s", sc.Name, fmt.Sprintf(format, args...)),
+		}}
+	}
+
+	diskParams, err := azureutils.ParseDiskParameters(sc.Parameters)
+	if err != nil {
+		return invalidParameters("%v", err)
+	}
+	cloud := d.getCloud()
+	skuName, err := azureutils.ValidateDiskParameters(&diskParams, cloud.Config.Cloud, cloud.Config.DisableAzureStackCloud)
+	if err != nil {
+		return invalidParameters("%v", err)
+	}
+
+	var failures []pvcValidationFailure
+	for _, accessMode := range pvc.Spec.AccessModes {
+		if accessMode != v1.ReadWriteMany && accessMode != v1.ReadOnlyMany {
+			continue
+		}
+		if diskParams.MaxShares < 2 {
+			failures = append(failures, pvcValidationFailure{
+				reason:  unsupportedAccessModeReason,
+				message: fmt.Sprintf("access mode %s requires maxShares of StorageClass %s to be greater than 1", accessMode, sc.Name),
+			})
+		} else if pvc.Spec.VolumeMode == nil || *pvc.Spec.VolumeMode != v1.PersistentVolumeBlock {
+			failures = append(failures, pvcValidationFailure{
+				reason:  unsupportedAccessModeReason,
+				message: fmt.Sprintf("access mode %s is only supported with volumeMode Block", accessMode),
+			})
+		}
+	}
+
+	location := diskParams.Location
+	if location == "" {
+		location = cloud.Location
+	}
+	subscriptionID := diskParams.SubscriptionID
+	if subscriptionID == "" {
+		subscriptionID = cloud.SubscriptionID
+	}
+	if usages, err := d.getDiskUsages(ctx, subscriptionID, location); err != nil {
+		klog.Warningf("skip validating disk quota of StorageClass %s: failed to list usages of subscription %s in %s: %v", sc.Name, subscriptionID, location, err)
+	} else if usage := getDiskCountUsage(usages, skuName); usage != nil && int64(ptr.Deref(usage.CurrentValue, 0)) >= ptr.Deref(usage.Limit, 0) {
+		failures = append(failures, pvcValidationFailure{
+			reason: diskQuotaExceededReason,
+			message: fmt.Sprintf("%d of %d disks of quota %s are used in %s of subscription %s, no disk of skuName %s could be created",
+				ptr.Deref(usage.CurrentValue, 0), ptr.Deref(usage.Limit, 0), ptr.Deref(usage.Name.Value, ""), location, subscriptionID, skuName),
+		})
+	}
+
+	if d.nodeLister == nil {
+		return failures
+	}
+	nodes, err := d.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("skip validating topology of StorageClass %s: failed to list nodes: %v", sc.Name, err)
+		return failures
+	}
+	nodeZones := map[string]bool{}
+	hasZonalNode := false
+	for _, node := range nodes {
+		zone := node.Labels[topologyKey]
+		if zone == "" {
+			zone = node.Labels[consts.WellKnownTopologyKey]
+		}
+		nodeZones[zone] = true
+		if azureutils.IsValidAvailabilityZone(zone, location) {
+			hasZonalNode = true
+		}
+	}
+
+	if strings.HasSuffix(strings.ToLower(string(skuName)), "zrs") && !hasZonalNode && len(nodes) > 0 {
+		failures = append(failures, pvcValidationFailure{
+			reason:  unsatisfiableTopologyReason,
+			message: fmt.Sprintf("skuName %s of StorageClass %s requires availability zones, but no node is in an availability zone of %s", skuName, sc.Name, location),
+		})
+	}
+
+	allowedZones := getAllowedZones(sc)
+	if len(allowedZones) == 0 {
+		return failures
+	}
+	found := false
+	for _, zone := range allowedZones {
+		if zone != "" && !azureutils.IsValidAvailabilityZone(zone, location) {
+			failures = append(failures, pvcValidationFailure{
+				reason:  unsatisfiableTopologyReason,
+				message: fmt.Sprintf("zone %s in allowedTopologies of StorageClass %s is not in location %s", zone, sc.Name, location),
+			})
+		}
+		if nodeZones[zone] {
+			found = true
+		}
+	}
+	if !found && len(nodes) > 0 {
+		failures = append(failures, pvcValidationFailure{
+			reason:  unsatisfiableTopologyReason,
+			message: fmt.Sprintf("no node is in zones %v in allowedTopologies of StorageClass %s", allowedZones, sc.Name),
+		})
+	}
+	return failures
+}
+
+// getAllowedZones returns the sorted zones in allowedTopologies of the StorageClass
+func getAllowedZones(sc *storagev1.StorageClass) []string {
+	zones := map[string]bool{}
+	for _, term := range sc.AllowedTopologies {
+		for _, expr := range term.MatchLabelExpressions {
+			if expr.Key != topologyKey && expr.Key != consts.WellKnownTopologyKey {
+				continue
+			}
+			for _, zone := range expr.Values {
+				zones[zone] = true
+			}
+		}
+	}
+	allowedZones := make([]string, 0, len(zones))
+	for zone := range zones {
+		allowedZones = append(allowedZones, zone)
+	}
+	sort.Strings(allowedZones)
+	return allowedZones
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func newTestPVC(storageClassName string, accessMode v1.PersistentVolumeAccessMode) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "default"},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: ptr.To(storageClassName),
+			AccessModes:      []v1.PersistentVolumeAccessMode{accessMode},
+		},
+		Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending},
+	}
+}
+
+// fakeDiskUsageClient returns the same usages for all subscriptions and locations
+type fakeDiskUsageClient struct {
+	usages []*armcompute.Usage
+	err    error
+}
+
+func (c *fakeDiskUsageClient) List(_ context.Context, _, _ string) ([]*armcompute.Usage, error) {
+	return c.usages, c.err
+}
+
+func newDiskCountUsage(name string, current int32, limit int64) *armcompute.Usage {
+	return &armcompute.Usage{Name: &armcompute.UsageName{Value: ptr.To(name)}, CurrentValue: ptr.To(current), Limit: ptr.To(limit)}
+}
+
+func newTestNodeLister(t *testing.T, nodes ...*v1.Node) corelisters.NodeLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		require.NoError(t, indexer.Add(node))
+	}
+	return corelisters.NewNodeLister(indexer)
+}
+
+func TestGetPVCValidationFailures(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+	d.nodeLister = newTestNodeLister(t,
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{consts.WellKnownTopologyKey: "westus-1"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Labels: map[string]string{consts.WellKnownTopologyKey: "westus-2"}}},
+	)
+	d.diskUsageClient = &fakeDiskUsageClient{usages: []*armcompute.Usage{
+		newDiskCountUsage("PremiumDiskCount", 10, 50000),
+		newDiskCountUsage("StandardSSDDiskCount", 50000, 50000),
+	}}
+	zoneTopology := func(zones ...string) []v1.TopologySelectorTerm {
+		return []v1.TopologySelectorTerm{{
+			MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{{Key: consts.WellKnownTopologyKey, Values: zones}},
+		}}
+	}
+
+	tests := []struct {
+		desc              string
+		parameters        map[string]string
+		allowedTopologies []v1.TopologySelectorTerm
+		accessMode        v1.PersistentVolumeAccessMode
+		expectedReasons   []string
+	}{
+		{
+			desc:       "valid parameters",
+			parameters: map[string]string{"skuName": "Premium_LRS"},
+			accessMode: v1.ReadWriteOnce,
+		},
+		{
+			desc:            "invalid skuName",
+			parameters:      map[string]string{"skuName": "Premium"},
+			accessMode:      v1.ReadWriteOnce,
+			expectedReasons: []string{invalidStorageClassParametersReason},
+		},
+		{
+			desc:            "logicalSectorSize with unsupported skuName",
+			parameters:      map[string]string{"skuName": "Premium_LRS", "logicalSectorSize": "4096"},
+			accessMode:      v1.ReadWriteOnce,
+			expectedReasons: []string{invalidStorageClassParametersReason},
+		},
+		{
+			desc:            "ReadWriteMany without maxShares",
+			parameters:      map[string]string{"skuName": "Premium_LRS"},
+			accessMode:      v1.ReadWriteMany,
+			expectedReasons: []string{unsupportedAccessModeReason},
+		},
+		{
+			desc:              "allowed zones with nodes",
+			parameters:        map[string]string{"skuName": "Premium_LRS"},
+			allowedTopologies: zoneTopology("westus-1", "westus-3"),
+			accessMode:        v1.ReadWriteOnce,
+		},
+		{
+			desc:              "allowed zones without nodes",
+			parameters:        map[string]string{"skuName": "Premium_LRS"},
+			allowedTopologies: zoneTopology("westus-3"),
+			accessMode:        v1.ReadWriteOnce,
+			expectedReasons:   []string{unsatisfiableTopologyReason},
+		},
+		{
+			desc:              "allowed zone in another location",
+			parameters:        map[string]string{"skuName": "Premium_LRS"},
+			allowedTopologies: zoneTopology("westus-1", "eastus-1"),
+			accessMode:        v1.ReadWriteOnce,
+			expectedReasons:   []string{unsatisfiableTopologyReason},
+		},
+		{
+			desc:            "disk count quota of skuName is used up",
+			parameters:      map[string]string{"skuName": "StandardSSD_LRS"},
+			accessMode:      v1.ReadWriteOnce,
+			expectedReasons: []string{diskQuotaExceededReason},
+		},
+		{
+			desc:       "skuName without disk count quota",
+			parameters: map[string]string{"skuName": "Standard_LRS"},
+			accessMode: v1.ReadWriteOnce,
+		},
+	}
+	for _, test := range tests {
+		sc := &storagev1.StorageClass{
+			ObjectMeta:        metav1.ObjectMeta{Name: "sc"},
+			Provisioner:       d.Name,
+			Parameters:        test.parameters,
+			AllowedTopologies: test.allowedTopologies,
+		}
+		failures := d.getPVCValidationFailures(ctx, newTestPVC(sc.Name, test.accessMode), sc)
+		reasons := []string{}
+		for _, failure := range failures {
+			reasons = append(reasons, failure.reason)
+		}
+		assert.ElementsMatch(t, test.expectedReasons, reasons, test.desc)
+	}
+}
+
+func TestGetPVCValidationFailuresZRSWithoutZonalNodes(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+	d.nodeLister = newTestNodeLister(t, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{consts.WellKnownTopologyKey: "0"}}})
+	// a failed usage read does not fail the validation
+	d.diskUsageClient = &fakeDiskUsageClient{err: fmt.Errorf("list usages error")}
+
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc"}, Provisioner: d.Name, Parameters: map[string]string{"skuName": "Premium_ZRS"}}
+	failures := d.getPVCValidationFailures(ctx, newTestPVC(sc.Name, v1.ReadWriteOnce), sc)
+	require.Len(t, failures, 1)
+	assert.Equal(t, unsatisfiableTopologyReason, failures[0].reason)
+}
+
+func TestValidatePVC(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	d.diskUsageClient = &fakeDiskUsageClient{}
+	ctx := context.Background()
+
+	for name, provisioner := range map[string]string{"invalid-sc": d.Name, "other-sc": "file.csi.azure.com"} {
+		sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: name}, Provisioner: provisioner, Parameters: map[string]string{"skuName": "invalid"}}
+		_, err := d.kubeClient.StorageV1().StorageClasses().Create(ctx, sc, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	// PVCs of other drivers, bound PVCs and PVCs with not found StorageClass are skipped
+	d.validatePVC(ctx, newTestPVC("other-sc", v1.ReadWriteOnce))
+	d.validatePVC(ctx, newTestPVC("notfound-sc", v1.ReadWriteOnce))
+	boundPVC := newTestPVC("invalid-sc", v1.ReadWriteOnce)
+	boundPVC.Status.Phase = v1.ClaimBound
+	d.validatePVC(ctx, boundPVC)
+	assert.Empty(t, recorder.Events)
+
+	d.validatePVC(ctx, newTestPVC("invalid-sc", v1.ReadWriteOnce))
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, invalidStorageClassParametersReason)
+}
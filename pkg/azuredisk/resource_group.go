@@ -0,0 +1,185 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+	provider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+// resourceListClient lists the resources of any type in a resource group, the client factory only provides typed
+// clients
+type resourceListClient interface {
+	// ListByResourceGroup returns the IDs of all resources in the resource group
+	ListByResourceGroup(ctx context.Context, resourceGroup string) ([]string, error)
+}
+
+type armResourceListClient struct {
+	client *armresources.Client
+}
+
+func newARMResourceListClient(cloud *provider.Cloud) (resourceListClient, error) {
+	if cloud == nil || cloud.AuthProvider == nil {
+		return nil, fmt.Errorf("azure credential is not initialized")
+	}
+	clientOption, err := azclient.GetAzCoreClientOption(&cloud.ARMClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	cred := cloud.AuthProvider.GetAzIdentity()
+	if cloud.AuthProvider.IsMultiTenantModeEnabled() {
+		cred = cloud.AuthProvider.GetMultiTenantIdentity()
+	}
+	client, err := armresources.NewClient(cloud.SubscriptionID, cred, &arm.ClientOptions{ClientOptions: *clientOption})
+	if err != nil {
+		return nil, err
+	}
+	return &armResourceListClient{client: client}, nil
+}
+
+func (c *armResourceListClient) ListByResourceGroup(ctx context.Context, resourceGroup string) ([]string, error) {
+	ids := []string{}
+	pager := c.client.NewListByResourceGroupPager(resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range page.Value {
+			if resource != nil {
+				ids = append(ids, ptr.Deref(resource.ID, ""))
+			}
+		}
+	}
+	return ids, nil
+}
+
+// getResourceListClient returns the resource list client using the credential of the cloud
+func (d *DriverCore) getResourceListClient() (resourceListClient, error) {
+	if d.resourceListClient != nil {
+		return d.resourceListClient, nil
+	}
+	return newARMResourceListClient(d.getCloud())
+}
+
+func isNotFoundError(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// isClusterSubscription returns whether subsID is empty or the subscription of the cluster, resource groups are
+// only created and deleted in the subscription of the cluster
+func (d *DriverCore) isClusterSubscription(subsID string) bool {
+	return subsID == "" || strings.EqualFold(subsID, d.getCloud().SubscriptionID)
+}
+
+// ensureResourceGroup creates the resource group of the disk with resourceGroupTags if it does not exist, the
+// resource group is tagged as created by the driver so that it is deleted once it is empty. The lock of the resource
+// group is only held while it is created, a disk creation racing with the cleanup of the group fails and is retried.
+func (d *DriverCore) ensureResourceGroup(ctx context.Context, diskParams *azureutils.ManagedDiskParameters) error {
+	resourceGroup := diskParams.ResourceGroup
+	d.resourceGroupLocks.LockEntry(strings.ToLower(resourceGroup))
+	defer d.resourceGroupLocks.UnlockEntry(strings.ToLower(resourceGroup))
+
+	rgClient := d.getClientFactory().GetResourceGroupClient()
+	_, err := rgClient.Get(ctx, resourceGroup)
+	if err == nil {
+		return nil
+	}
+	if !isNotFoundError(err) {
+		return fmt.Errorf("get resource group(%s) failed with %w", resourceGroup, err)
+	}
+
+	location := diskParams.Location
+	if location == "" {
+		location = d.getCloud().Location
+	}
+	tags := map[string]*string{azureconsts.CreatedByTag: ptr.To(consts.AzureDiskDriverTag)}
+	for k, v := range diskParams.ResourceGroupTags {
+		tags[k] = ptr.To(v)
+	}
+	if _, err := rgClient.CreateOrUpdate(ctx, resourceGroup, armresources.ResourceGroup{
+		Location: ptr.To(location),
+		Tags:     tags,
+	}); err != nil {
+		return fmt.Errorf("create resource group(%s) failed with %w", resourceGroup, err)
+	}
+	klog.V(2).Infof("created resource group(%s) in location(%s) with tags(%v)", resourceGroup, location, diskParams.ResourceGroupTags)
+	return nil
+}
+
+// cleanupResourceGroup deletes the resource group of a deleted disk if it was created by the driver and there is no
+// resource of any type left in it, failures are only logged since the disk is already deleted
+func (d *DriverCore) cleanupResourceGroup(ctx context.Context, diskURI string) {
+	resourceGroup, subsID, err := getInfoFromDiskURI(diskURI)
+	if err != nil || strings.EqualFold(resourceGroup, d.getCloud().ResourceGroup) || !d.isClusterSubscription(subsID) {
+		return
+	}
+	d.resourceGroupLocks.LockEntry(strings.ToLower(resourceGroup))
+	defer d.resourceGroupLocks.UnlockEntry(strings.ToLower(resourceGroup))
+	// a disk creation which outlived its CreateVolume call may not be listed in the resource group yet
+	if d.inflightOperations != nil && d.inflightOperations.isRunning(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/", subsID, resourceGroup)) {
+		klog.V(4).Infof("keep resource group(%s) with in-flight operations", resourceGroup)
+		return
+	}
+
+	rgClient := d.getClientFactory().GetResourceGroupClient()
+	rg, err := rgClient.Get(ctx, resourceGroup)
+	if err != nil {
+		if !isNotFoundError(err) {
+			klog.Warningf("get resource group(%s) of deleted disk(%s) failed with %v", resourceGroup, diskURI, err)
+		}
+		return
+	}
+	if !strings.EqualFold(ptr.Deref(rg.Tags[azureconsts.CreatedByTag], ""), consts.AzureDiskDriverTag) {
+		return
+	}
+
+	// deleting a resource group deletes everything in it, so it is kept as long as it has any resource, not only
+	// disks and snapshots of the driver
+	listClient, err := d.getResourceListClient()
+	if err != nil {
+		klog.Warningf("get resource list client failed with %v", err)
+		return
+	}
+	resources, err := listClient.ListByResourceGroup(ctx, resourceGroup)
+	if err != nil || len(resources) > 0 {
+		klog.V(4).Infof("keep resource group(%s) with %d resources, list error: %v", resourceGroup, len(resources), err)
+		return
+	}
+
+	if err := rgClient.Delete(ctx, resourceGroup); err != nil {
+		klog.Warningf("delete empty resource group(%s) failed with %v", resourceGroup, err)
+		return
+	}
+	klog.V(2).Infof("deleted empty resource group(%s) created by the driver after its last disk(%s) is deleted", resourceGroup, diskURI)
+}
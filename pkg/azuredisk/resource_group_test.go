@@ -0,0 +1,132 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+// fakeResourceGroupClient keeps the resource groups in memory
+type fakeResourceGroupClient struct {
+	groups  map[string]*armresources.ResourceGroup
+	deleted []string
+}
+
+func (c *fakeResourceGroupClient) Get(_ context.Context, resourceGroupName string) (*armresources.ResourceGroup, error) {
+	if rg, ok := c.groups[strings.ToLower(resourceGroupName)]; ok {
+		return rg, nil
+	}
+	return nil, &azcore.ResponseError{StatusCode: http.StatusNotFound}
+}
+
+func (c *fakeResourceGroupClient) CreateOrUpdate(_ context.Context, resourceGroupName string, resourceParam armresources.ResourceGroup) (*armresources.ResourceGroup, error) {
+	resourceParam.Name = ptr.To(resourceGroupName)
+	c.groups[strings.ToLower(resourceGroupName)] = &resourceParam
+	return &resourceParam, nil
+}
+
+func (c *fakeResourceGroupClient) Delete(_ context.Context, resourceGroupName string) error {
+	delete(c.groups, strings.ToLower(resourceGroupName))
+	c.deleted = append(c.deleted, resourceGroupName)
+	return nil
+}
+
+func (c *fakeResourceGroupClient) List(_ context.Context) ([]*armresources.ResourceGroup, error) {
+	result := []*armresources.ResourceGroup{}
+	for _, rg := range c.groups {
+		result = append(result, rg)
+	}
+	return result, nil
+}
+
+// fakeResourceListClient returns the resources of the resource groups
+type fakeResourceListClient struct {
+	resources map[string][]string
+}
+
+func (c *fakeResourceListClient) ListByResourceGroup(_ context.Context, resourceGroup string) ([]string, error) {
+	return c.resources[resourceGroup], nil
+}
+
+func TestEnsureResourceGroup(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	rgClient := &fakeResourceGroupClient{groups: map[string]*armresources.ResourceGroup{
+		"existing-rg": {Name: ptr.To("existing-rg"), Tags: map[string]*string{}},
+	}}
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetResourceGroupClient().Return(rgClient).AnyTimes()
+	ctx := context.Background()
+
+	diskParams := &azureutils.ManagedDiskParameters{ResourceGroup: "team-rg", ResourceGroupTags: map[string]string{"team": "storage"}}
+	require.NoError(t, d.ensureResourceGroup(ctx, diskParams))
+	rg := rgClient.groups["team-rg"]
+	require.NotNil(t, rg)
+	assert.Equal(t, d.getCloud().Location, ptr.Deref(rg.Location, ""))
+	assert.Equal(t, "storage", ptr.Deref(rg.Tags["team"], ""))
+	assert.Equal(t, consts.AzureDiskDriverTag, ptr.Deref(rg.Tags[azureconsts.CreatedByTag], ""))
+
+	// existing resource groups are not updated
+	require.NoError(t, d.ensureResourceGroup(ctx, &azureutils.ManagedDiskParameters{ResourceGroup: "existing-rg", Location: "eastus"}))
+	assert.Nil(t, rgClient.groups["existing-rg"].Location)
+}
+
+func TestCleanupResourceGroup(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	subsID := d.getCloud().SubscriptionID
+	rgClient := &fakeResourceGroupClient{groups: map[string]*armresources.ResourceGroup{
+		"team-rg":     {Tags: map[string]*string{azureconsts.CreatedByTag: ptr.To(consts.AzureDiskDriverTag)}},
+		"busy-rg":     {Tags: map[string]*string{azureconsts.CreatedByTag: ptr.To(consts.AzureDiskDriverTag)}},
+		"snapshot-rg": {Tags: map[string]*string{azureconsts.CreatedByTag: ptr.To(consts.AzureDiskDriverTag)}},
+		"vm-rg":       {Tags: map[string]*string{azureconsts.CreatedByTag: ptr.To(consts.AzureDiskDriverTag)}},
+		"user-rg":     {Tags: map[string]*string{}},
+	}}
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetResourceGroupClient().Return(rgClient).AnyTimes()
+	d.resourceListClient = &fakeResourceListClient{resources: map[string][]string{
+		"busy-rg":     {"/subscriptions/" + subsID + "/resourceGroups/busy-rg/providers/Microsoft.Compute/disks/other"},
+		"snapshot-rg": {"/subscriptions/" + subsID + "/resourceGroups/snapshot-rg/providers/Microsoft.Compute/snapshots/snapshot"},
+		// resources of any type keep the resource group
+		"vm-rg": {"/subscriptions/" + subsID + "/resourceGroups/vm-rg/providers/Microsoft.Compute/virtualMachines/vm"},
+	}}
+
+	ctx := context.Background()
+	for _, rg := range []string{"team-rg", "busy-rg", "snapshot-rg", "vm-rg", "user-rg", d.getCloud().ResourceGroup} {
+		d.cleanupResourceGroup(ctx, "/subscriptions/"+subsID+"/resourceGroups/"+rg+"/providers/Microsoft.Compute/disks/disk")
+	}
+	// resource groups in other subscriptions are skipped
+	d.cleanupResourceGroup(ctx, "/subscriptions/other/resourceGroups/team-rg/providers/Microsoft.Compute/disks/disk")
+	assert.Equal(t, []string{"team-rg"}, rgClient.deleted)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	provider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// resourceTagsClient updates some tags of a resource without replacing the others, unlike the tags of DiskUpdate
+// which replace all the tags of the disk and could drop a tag set concurrently by someone else
+type resourceTagsClient interface {
+	// MergeTags sets tags on the resource, the other tags of the resource are kept
+	MergeTags(ctx context.Context, resourceID string, tags map[string]string) error
+}
+
+type armResourceTagsClient struct {
+	client *armresources.TagsClient
+}
+
+func newARMResourceTagsClient(cloud *provider.Cloud) (resourceTagsClient, error) {
+	if cloud == nil || cloud.AuthProvider == nil {
+		return nil, fmt.Errorf("azure credential is not initialized")
+	}
+	clientOption, err := azclient.GetAzCoreClientOption(&cloud.ARMClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	cred := cloud.AuthProvider.GetAzIdentity()
+	if cloud.AuthProvider.IsMultiTenantModeEnabled() {
+		cred = cloud.AuthProvider.GetMultiTenantIdentity()
+	}
+	client, err := armresources.NewTagsClient(cloud.SubscriptionID, cred, &arm.ClientOptions{ClientOptions: *clientOption})
+	if err != nil {
+		return nil, err
+	}
+	return &armResourceTagsClient{client: client}, nil
+}
+
+func (c *armResourceTagsClient) MergeTags(ctx context.Context, resourceID string, tags map[string]string) error {
+	properties := &armresources.Tags{Tags: make(map[string]*string, len(tags))}
+	for k, v := range tags {
+		properties.Tags[k] = ptr.To(v)
+	}
+	// the scope is joined to the path with a slash
+	_, err := c.client.UpdateAtScope(ctx, strings.TrimPrefix(resourceID, "/"), armresources.TagsPatchResource{
+		Operation:  ptr.To(armresources.TagsPatchOperationMerge),
+		Properties: properties,
+	}, nil)
+	return err
+}
+
+// getResourceTagsClient returns the resource tags client using the credential of the cloud
+func (d *DriverCore) getResourceTagsClient() (resourceTagsClient, error) {
+	if d.resourceTagsClient != nil {
+		return d.resourceTagsClient, nil
+	}
+	return newARMResourceTagsClient(d.getCloud())
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/snapshotclient"
+)
+
+const (
+	snapshotCopyInProgressReason = "SnapshotCopyInProgress"
+	snapshotCopyCompletedReason  = "SnapshotCopyCompleted"
+	snapshotCopyFailedReason     = "SnapshotCopyFailed"
+)
+
+// newEventRecorder returns an event recorder writing events through kubeClient
+func newEventRecorder(kubeClient kubernetes.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+}
+
+// recordEvent records an event on ref, it's a no-op if there is no event recorder. If ref is nil, e.g. a static disk
+// without PV name, no event is recorded but it's still forwarded to the notification sinks.
+func (d *DriverCore) recordEvent(ref *v1.ObjectReference, eventType, reason, messageFmt string, args ...interface{}) {
+	if ref == nil {
+		if d.notifier != nil {
+			d.notifier.notify(nil, eventType, reason, fmt.Sprintf(messageFmt, args...))
+		}
+		return
+	}
+	if d.eventRecorder == nil {
+		return
+	}
+	d.eventRecorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
+
+// getVolumeSnapshotContentReference returns the object reference of a VolumeSnapshotContent, nil is returned if name is empty
+func getVolumeSnapshotContentReference(name string) *v1.ObjectReference {
+	if name == "" {
+		return nil
+	}
+	return &v1.ObjectReference{APIVersion: "snapshot.storage.k8s.io/v1", Kind: "VolumeSnapshotContent", Name: name}
+}
+
+// checkCrossRegionSnapshotCopy returns the cross region snapshot copied by CopyStart from localSnapshotName,
+// nil is returned if the copy has not been started. ReadyToUse of the snapshot is false until the background
+// copy is completed, the local snapshot is deleted once the copy is completed.
+func (d *Driver) checkCrossRegionSnapshotCopy(ctx context.Context, snapshotClient snapshotclient.Interface, resourceGroup, snapshotName, localSnapshotName, sourceVolumeID string, ref *v1.ObjectReference) (*csi.Snapshot, error) {
+	snapshot, err := snapshotClient.Get(ctx, resourceGroup, snapshotName)
+	if err != nil {
+		if strings.Contains(err.Error(), consts.ResourceNotFound) {
+			return nil, nil
+		}
+		return nil, status.Errorf(codes.Internal, "get snapshot %s from rg(%s) error: %v", snapshotName, resourceGroup, err)
+	}
+	if snapshot.Properties != nil && snapshot.Properties.CopyCompletionError != nil {
+		msg := azureutils.GetCopyCompletionErrorMessage(snapshot.Properties.CopyCompletionError)
+		d.recordEvent(ref, v1.EventTypeWarning, snapshotCopyFailedReason, "copy of snapshot %s to region %s failed: %s", localSnapshotName, ptr.Deref(snapshot.Location, ""), msg)
+		return nil, status.Errorf(codes.Internal, "copy of snapshot(%s) under rg(%s) failed: %s", snapshotName, resourceGroup, msg)
+	}
+	csiSnapshot, err := azureutils.GenerateCSISnapshot(sourceVolumeID, snapshot)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if !azureutils.IsSnapshotCopyCompleted(snapshot) {
+		d.crossRegionSnapshotCopies.Store(snapshotName, localSnapshotName)
+		percent := ptr.Deref(snapshot.Properties.CompletionPercent, 0)
+		klog.V(2).Infof("snapshot(%s) under rg(%s) region(%s) completionPercent: %f", snapshotName, resourceGroup, ptr.Deref(snapshot.Location, ""), percent)
+		d.recordEvent(ref, v1.EventTypeNormal, snapshotCopyInProgressReason, "copying snapshot %s to region %s: %.1f%% completed", localSnapshotName, ptr.Deref(snapshot.Location, ""), percent)
+		return csiSnapshot, nil
+	}
+
+	klog.V(2).Infof("begin to delete snapshot(%s) under rg(%s) region(%s)", localSnapshotName, resourceGroup, d.getCloud().Location)
+	if err := snapshotClient.Delete(ctx, resourceGroup, localSnapshotName); err != nil {
+		klog.Errorf("delete snapshot error: %v", err)
+		azureutils.SleepIfThrottled(err, consts.SnapshotOpThrottlingSleepSec)
+	} else {
+		klog.V(2).Infof("delete snapshot(%s) under rg(%s) region(%s) successfully", localSnapshotName, resourceGroup, d.getCloud().Location)
+	}
+	d.crossRegionSnapshotCopies.Delete(snapshotName)
+	d.recordEvent(ref, v1.EventTypeNormal, snapshotCopyCompletedReason, "copy of snapshot %s to region %s completed", localSnapshotName, ptr.Deref(snapshot.Location, ""))
+	return csiSnapshot, nil
+}
+
+// cancelCrossRegionSnapshotCopy deletes the local snapshot of a cross region snapshot copy in progress,
+// the background copy is cancelled by deleting the cross region snapshot before
+func (d *Driver) cancelCrossRegionSnapshotCopy(ctx context.Context, snapshotClient snapshotclient.Interface, resourceGroup, snapshotName string) {
+	localSnapshotName, ok := d.crossRegionSnapshotCopies.LoadAndDelete(snapshotName)
+	if !ok {
+		return
+	}
+	klog.V(2).Infof("copy of snapshot(%s) under rg(%s) is cancelled, begin to delete snapshot(%s)", snapshotName, resourceGroup, localSnapshotName)
+	if err := snapshotClient.Delete(ctx, resourceGroup, localSnapshotName.(string)); err != nil {
+		klog.Errorf("delete snapshot(%s) under rg(%s) error: %v", localSnapshotName, resourceGroup, err)
+	}
+}
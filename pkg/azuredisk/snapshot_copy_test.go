@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/snapshotclient/mock_snapshotclient"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func newTestCopySnapshot(name, location string, completionPercent float32) *armcompute.Snapshot {
+	return &armcompute.Snapshot{
+		ID:       to.Ptr(fmt.Sprintf("/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/snapshots/%s", name)),
+		Name:     to.Ptr(name),
+		Location: to.Ptr(location),
+		Properties: &armcompute.SnapshotProperties{
+			TimeCreated:       to.Ptr(time.Now()),
+			ProvisioningState: to.Ptr("Succeeded"),
+			DiskSizeGB:        to.Ptr(int32(10)),
+			CreationData: &armcompute.CreationData{
+				CreateOption: to.Ptr(armcompute.DiskCreateOptionCopyStart),
+			},
+			CompletionPercent: to.Ptr(completionPercent),
+		},
+	}
+}
+
+func TestCreateSnapshotCrossRegion(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+
+	mockSnapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub(gomock.Any()).Return(mockSnapshotClient, nil).AnyTimes()
+	localSnapshot := newTestCopySnapshot("local_snapname", "westus", 100)
+	localSnapshot.Properties.CreationData.CreateOption = to.Ptr(armcompute.DiskCreateOptionCopy)
+	gomock.InOrder(
+		mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapname").Return(nil, fmt.Errorf(consts.ResourceNotFound)),
+		mockSnapshotClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "local_snapname", gomock.Any()).Return(nil, nil),
+		mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "local_snapname").Return(localSnapshot, nil).Times(2),
+		mockSnapshotClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "snapname", gomock.Any()).DoAndReturn(
+			func(_ context.Context, _, _ string, snapshot armcompute.Snapshot) (*armcompute.Snapshot, error) {
+				assert.Equal(t, armcompute.DiskCreateOptionCopyStart, *snapshot.Properties.CreationData.CreateOption)
+				assert.Equal(t, "eastus", *snapshot.Location)
+				return nil, nil
+			}),
+		mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapname").Return(newTestCopySnapshot("snapname", "eastus", 40), nil),
+		mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapname").Return(newTestCopySnapshot("snapname", "eastus", 100), nil),
+		mockSnapshotClient.EXPECT().Delete(gomock.Any(), "rg", "local_snapname").Return(nil),
+	)
+
+	req := &csi.CreateSnapshotRequest{
+		SourceVolumeId: testVolumeID,
+		Name:           "snapname",
+		Parameters: map[string]string{
+			consts.LocationField:                "eastus",
+			consts.VolumeSnapshotContentNameKey: "snapcontent",
+		},
+	}
+	resp, err := d.CreateSnapshot(context.Background(), req)
+	require.NoError(t, err)
+	assert.False(t, resp.Snapshot.ReadyToUse)
+	assert.Equal(t, "Normal SnapshotCopyInProgress copying snapshot local_snapname to region eastus: 40.0% completed", <-recorder.Events)
+
+	resp, err = d.CreateSnapshot(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, resp.Snapshot.ReadyToUse)
+	assert.Equal(t, "Normal SnapshotCopyCompleted copy of snapshot local_snapname to region eastus completed", <-recorder.Events)
+	_, ok := d.crossRegionSnapshotCopies.Load("snapname")
+	assert.False(t, ok)
+}
+
+func TestCheckCrossRegionSnapshotCopy(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	mockSnapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+	ref := getVolumeSnapshotContentReference("snapcontent")
+
+	mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapname").Return(nil, fmt.Errorf("get snapshot error"))
+	_, err = d.checkCrossRegionSnapshotCopy(context.Background(), mockSnapshotClient, "rg", "snapname", "local_snapname", testVolumeID, ref)
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	failedSnapshot := newTestCopySnapshot("snapname", "eastus", 60)
+	failedSnapshot.Properties.CopyCompletionError = &armcompute.CopyCompletionError{
+		ErrorCode:    to.Ptr(armcompute.CopyCompletionErrorReasonCopySourceNotFound),
+		ErrorMessage: to.Ptr("source not found"),
+	}
+	mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapname").Return(failedSnapshot, nil)
+	_, err = d.checkCrossRegionSnapshotCopy(context.Background(), mockSnapshotClient, "rg", "snapname", "local_snapname", testVolumeID, ref)
+	assert.Equal(t, status.Error(codes.Internal, "copy of snapshot(snapname) under rg(rg) failed: CopySourceNotFound: source not found"), err)
+	assert.Equal(t, "Warning SnapshotCopyFailed copy of snapshot local_snapname to region eastus failed: CopySourceNotFound: source not found", <-recorder.Events)
+
+	// no event is recorded without an object reference
+	mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapname").Return(newTestCopySnapshot("snapname", "eastus", 10), nil)
+	snapshot, err := d.checkCrossRegionSnapshotCopy(context.Background(), mockSnapshotClient, "rg", "snapname", "local_snapname", testVolumeID, nil)
+	assert.NoError(t, err)
+	assert.False(t, snapshot.ReadyToUse)
+	assert.Empty(t, recorder.Events)
+}
+
+func TestDeleteSnapshotCancelsCrossRegionCopy(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	mockSnapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub(gomock.Any()).Return(mockSnapshotClient, nil).AnyTimes()
+
+	d.crossRegionSnapshotCopies.Store("snapname", "local_snapname")
+	gomock.InOrder(
+		mockSnapshotClient.EXPECT().Delete(gomock.Any(), gomock.Any(), "snapname").Return(nil),
+		mockSnapshotClient.EXPECT().Delete(gomock.Any(), gomock.Any(), "local_snapname").Return(nil),
+		mockSnapshotClient.EXPECT().Delete(gomock.Any(), gomock.Any(), "snapname").Return(nil),
+	)
+	_, err = d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "snapname"})
+	assert.NoError(t, err)
+	// the local snapshot is only deleted once
+	_, err = d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "snapname"})
+	assert.NoError(t, err)
+}
+
+func TestWaitForSnapshotReadyCancelled(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	mockSnapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub(gomock.Any()).Return(mockSnapshotClient, nil).AnyTimes()
+	mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "snapname").Return(newTestCopySnapshot("snapname", "eastus", 10), nil).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = d.waitForSnapshotReady(ctx, d.getClientFactory(), "subs", "rg", "snapname", time.Hour, time.Hour)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	failedSnapshot := newTestCopySnapshot("snapname", "eastus", 10)
+	failedSnapshot.Properties.CopyCompletionError = &armcompute.CopyCompletionError{
+		ErrorCode:    to.Ptr(armcompute.CopyCompletionErrorReasonCopySourceNotFound),
+		ErrorMessage: to.Ptr("source not found"),
+	}
+	mockSnapshotClient.EXPECT().Get(gomock.Any(), "rg", "failed").Return(failedSnapshot, nil)
+	err = d.waitForSnapshotReady(context.Background(), d.getClientFactory(), "subs", "rg", "failed", time.Hour, time.Hour)
+	assert.EqualError(t, err, "copy of snapshot(failed) under rg(rg) failed: CopySourceNotFound: source not found")
+}
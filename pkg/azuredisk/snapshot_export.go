@@ -0,0 +1,518 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+	provider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+const (
+	snapshotExportStartedReason   = "SnapshotExportStarted"
+	snapshotExportSucceededReason = "SnapshotExportSucceeded"
+	snapshotExportFailedReason    = "SnapshotExportFailed"
+
+	azSnapshotExportKind = "AzSnapshotExport"
+
+	snapshotExportPhasePending   = "Pending"
+	snapshotExportPhaseCopying   = "Copying"
+	snapshotExportPhaseSucceeded = "Succeeded"
+	snapshotExportPhaseFailed    = "Failed"
+
+	// snapshotExportSASTokenKey is the key of the SAS token of the destination blob in the destination secret
+	snapshotExportSASTokenKey = "sasToken"
+	// snapshotExportAccessDuration is the lifetime of the SAS URL reading the snapshot, the copy fails if it takes longer
+	snapshotExportAccessDuration = 24 * time.Hour
+
+	blobAPIVersion = "2021-08-06"
+	storageScope   = "https://storage.azure.com/.default"
+)
+
+// azSnapshotExportResource is the resource of the namespaced AzSnapshotExport custom resource, which copies the
+// snapshot of a VolumeSnapshot in its namespace to a page blob
+var azSnapshotExportResource = schema.GroupVersionResource{Group: "disk.csi.azure.com", Version: "v1alpha1", Resource: "azsnapshotexports"}
+
+// snapshotExportSpec is the VolumeSnapshot to export and the destination blob
+type snapshotExportSpec struct {
+	// VolumeSnapshotName is the name of a ready VolumeSnapshot of the driver in the namespace of the export
+	VolumeSnapshotName string `json:"volumeSnapshotName"`
+	// DestinationURI is the URI of the page blob the snapshot is copied to as a VHD, without the SAS token
+	DestinationURI string `json:"destinationURI"`
+	// DestinationSecretName is the secret in the namespace of the export whose sasToken writes the destination blob,
+	// the identity of the driver is used if not set, which only writes the storage accounts allowed by the admin
+	DestinationSecretName string `json:"destinationSecretName,omitempty"`
+}
+
+// snapshotExportStatus is the progress of the copy of an AzSnapshotExport
+type snapshotExportStatus struct {
+	Phase      string `json:"phase,omitempty"`
+	SnapshotID string `json:"snapshotID,omitempty"`
+	CopyID     string `json:"copyID,omitempty"`
+	// Progress is the copied and the total bytes of the copy, e.g. 1048576/10737418240
+	Progress       string       `json:"progress,omitempty"`
+	Message        string       `json:"message,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// azSnapshotExport is an AzSnapshotExport custom resource
+type azSnapshotExport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   snapshotExportSpec   `json:"spec"`
+	Status snapshotExportStatus `json:"status,omitempty"`
+}
+
+// blobCopyStatus is the status of the last copy to a blob
+type blobCopyStatus struct {
+	CopyID string
+	// Status is pending, success, aborted or failed
+	Status      string
+	Progress    string
+	Description string
+}
+
+// snapshotExportClient grants read access to snapshots and copies them to blobs
+type snapshotExportClient interface {
+	// GrantAccess returns a SAS URL reading the snapshot of snapshotID for duration
+	GrantAccess(ctx context.Context, snapshotID string, duration time.Duration) (string, error)
+	// RevokeAccess revokes the SAS URLs of the snapshot of snapshotID
+	RevokeAccess(ctx context.Context, snapshotID string) error
+	// StartCopy starts copying sourceURL to the blob of destinationURI and returns the copy ID, the blob is written
+	// with sasToken, or the identity of the driver if sasToken is empty
+	StartCopy(ctx context.Context, destinationURI, sasToken, sourceURL string) (string, error)
+	// GetCopyStatus returns the status of the last copy to the blob of destinationURI
+	GetCopyStatus(ctx context.Context, destinationURI, sasToken string) (*blobCopyStatus, error)
+}
+
+// azureSnapshotExportClient calls the compute API to grant access to snapshots and the Blob REST API to copy them,
+// the storage SDK is not a dependency of the driver
+type azureSnapshotExportClient struct {
+	cred          azcore.TokenCredential
+	clientOptions *policy.ClientOptions
+	// blobPipeline authenticates with the identity of the driver, sasBlobPipeline with the SAS token in the URL
+	blobPipeline    azruntime.Pipeline
+	sasBlobPipeline azruntime.Pipeline
+}
+
+func newAzureSnapshotExportClient(cloud *provider.Cloud) (snapshotExportClient, error) {
+	if cloud == nil || cloud.AuthProvider == nil {
+		return nil, fmt.Errorf("azure credential is not initialized")
+	}
+	clientOptions, err := azclient.GetAzCoreClientOption(&cloud.ARMClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	cred := cloud.AuthProvider.GetAzIdentity()
+	if cloud.AuthProvider.IsMultiTenantModeEnabled() {
+		cred = cloud.AuthProvider.GetMultiTenantIdentity()
+	}
+	return newSnapshotExportClientWithOptions(cred, clientOptions), nil
+}
+
+func newSnapshotExportClientWithOptions(cred azcore.TokenCredential, clientOptions *policy.ClientOptions) *azureSnapshotExportClient {
+	const module, version = "azuredisk-csi-driver.snapshotexport", "v1.0.0"
+	return &azureSnapshotExportClient{
+		cred:          cred,
+		clientOptions: clientOptions,
+		blobPipeline: azruntime.NewPipeline(module, version, azruntime.PipelineOptions{
+			PerRetry: []policy.Policy{azruntime.NewBearerTokenPolicy(cred, []string{storageScope}, nil)},
+		}, clientOptions),
+		sasBlobPipeline: azruntime.NewPipeline(module, version, azruntime.PipelineOptions{}, clientOptions),
+	}
+}
+
+func (c *azureSnapshotExportClient) getSnapshotsClient(snapshotID string) (*armcompute.SnapshotsClient, string, string, error) {
+	resource, err := arm.ParseResourceID(snapshotID)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid snapshot ID %s: %w", snapshotID, err)
+	}
+	client, err := armcompute.NewSnapshotsClient(resource.SubscriptionID, c.cred, &arm.ClientOptions{ClientOptions: *c.clientOptions})
+	if err != nil {
+		return nil, "", "", err
+	}
+	return client, resource.ResourceGroupName, resource.Name, nil
+}
+
+func (c *azureSnapshotExportClient) GrantAccess(ctx context.Context, snapshotID string, duration time.Duration) (string, error) {
+	client, resourceGroup, name, err := c.getSnapshotsClient(snapshotID)
+	if err != nil {
+		return "", err
+	}
+	poller, err := client.BeginGrantAccess(ctx, resourceGroup, name, armcompute.GrantAccessData{
+		Access:            ptr.To(armcompute.AccessLevelRead),
+		DurationInSeconds: ptr.To(int32(duration.Seconds())),
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	if ptr.Deref(resp.AccessSAS, "") == "" {
+		return "", fmt.Errorf("no SAS URL is returned for snapshot %s", snapshotID)
+	}
+	return *resp.AccessSAS, nil
+}
+
+func (c *azureSnapshotExportClient) RevokeAccess(ctx context.Context, snapshotID string) error {
+	client, resourceGroup, name, err := c.getSnapshotsClient(snapshotID)
+	if err != nil {
+		return err
+	}
+	poller, err := client.BeginRevokeAccess(ctx, resourceGroup, name, nil)
+	if err != nil {
+		return err
+	}
+	_, err = poller.PollUntilDone(ctx, nil)
+	return err
+}
+
+func (c *azureSnapshotExportClient) newBlobRequest(ctx context.Context, method, destinationURI, sasToken string) (*policy.Request, azruntime.Pipeline, error) {
+	pipeline := c.blobPipeline
+	if sasToken != "" {
+		destinationURI = destinationURI + "?" + strings.TrimPrefix(sasToken, "?")
+		pipeline = c.sasBlobPipeline
+	}
+	req, err := azruntime.NewRequest(ctx, method, destinationURI)
+	if err != nil {
+		return nil, pipeline, err
+	}
+	req.Raw().Header.Set("x-ms-version", blobAPIVersion)
+	return req, pipeline, nil
+}
+
+func (c *azureSnapshotExportClient) StartCopy(ctx context.Context, destinationURI, sasToken, sourceURL string) (string, error) {
+	req, pipeline, err := c.newBlobRequest(ctx, http.MethodPut, destinationURI, sasToken)
+	if err != nil {
+		return "", err
+	}
+	req.Raw().Header.Set("x-ms-copy-source", sourceURL)
+	resp, err := pipeline.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if !azruntime.HasStatusCode(resp, http.StatusAccepted) {
+		return "", azruntime.NewResponseError(resp)
+	}
+	return resp.Header.Get("x-ms-copy-id"), nil
+}
+
+func (c *azureSnapshotExportClient) GetCopyStatus(ctx context.Context, destinationURI, sasToken string) (*blobCopyStatus, error) {
+	req, pipeline, err := c.newBlobRequest(ctx, http.MethodHead, destinationURI, sasToken)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pipeline.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !azruntime.HasStatusCode(resp, http.StatusOK) {
+		return nil, azruntime.NewResponseError(resp)
+	}
+	return &blobCopyStatus{
+		CopyID:      resp.Header.Get("x-ms-copy-id"),
+		Status:      resp.Header.Get("x-ms-copy-status"),
+		Progress:    resp.Header.Get("x-ms-copy-progress"),
+		Description: resp.Header.Get("x-ms-copy-status-description"),
+	}, nil
+}
+
+// runSnapshotExporter copies the snapshots of the AzSnapshotExports to their destination blobs and updates the
+// progress of the copies every interval
+func (d *Driver) runSnapshotExporter(ctx context.Context, interval time.Duration) {
+	if d.snapshotExportClient == nil {
+		client, err := newAzureSnapshotExportClient(d.getCloud())
+		if err != nil {
+			klog.Errorf("snapshot export is disabled since snapshot export client is not available: %v", err)
+			return
+		}
+		d.snapshotExportClient = client
+	}
+	klog.V(2).Infof("exporting snapshots of %s every %v", azSnapshotExportResource.Resource, interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := d.exportSnapshots(ctx); err != nil {
+			klog.Errorf("failed to export snapshots: %v", err)
+		}
+	}, interval)
+}
+
+// exportSnapshots reconciles the AzSnapshotExports which are not completed, the access to a snapshot is revoked after
+// the last copy of it is completed
+func (d *Driver) exportSnapshots(ctx context.Context) error {
+	list, err := d.dynamicClient.Resource(azSnapshotExportResource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", azSnapshotExportResource.Resource, err)
+	}
+	exports := make([]*azSnapshotExport, 0, len(list.Items))
+	// <lower case snapshot ID, number of the copies in progress>
+	copying := map[string]int{}
+	for i := range list.Items {
+		export := &azSnapshotExport{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, export); err != nil {
+			klog.Errorf("failed to convert %s %s/%s: %v", azSnapshotExportKind, list.Items[i].GetNamespace(), list.Items[i].GetName(), err)
+			continue
+		}
+		if export.Status.Phase == snapshotExportPhaseCopying {
+			copying[strings.ToLower(export.Status.SnapshotID)]++
+		}
+		exports = append(exports, export)
+	}
+	for _, export := range exports {
+		if export.Status.Phase == snapshotExportPhaseSucceeded || export.Status.Phase == snapshotExportPhaseFailed {
+			continue
+		}
+		status := export.Status
+		if err := d.reconcileSnapshotExport(ctx, export, copying); err != nil {
+			klog.Errorf("failed to export snapshot of %s %s/%s: %v", azSnapshotExportKind, export.Namespace, export.Name, err)
+		}
+		if export.Status != status {
+			if err := d.updateSnapshotExportStatus(ctx, export); err != nil {
+				klog.Errorf("failed to update status of %s %s/%s: %v", azSnapshotExportKind, export.Namespace, export.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// reconcileSnapshotExport starts the copy of the snapshot of export, or updates the status of the copy in progress.
+// copying is the number of the copies in progress per snapshot, the access to the snapshot is revoked when it's 0.
+func (d *Driver) reconcileSnapshotExport(ctx context.Context, export *azSnapshotExport, copying map[string]int) error {
+	if export.Status.Phase == "" {
+		export.Status.Phase = snapshotExportPhasePending
+	}
+	sasToken, err := d.getSnapshotExportSASToken(ctx, export)
+	if err != nil {
+		export.Status.Message = err.Error()
+		return err
+	}
+	if export.Status.Phase == snapshotExportPhaseCopying {
+		return d.updateSnapshotExportProgress(ctx, export, sasToken, copying)
+	}
+
+	destination, err := url.Parse(export.Spec.DestinationURI)
+	if err != nil || destination.Scheme != "https" || destination.Host == "" || destination.RawQuery != "" {
+		d.failSnapshotExport(export, "destinationURI must be an https blob URI without a SAS token")
+		return nil
+	}
+	// without a SAS token of the user, anyone creating an AzSnapshotExport could overwrite the blobs writable by the
+	// identity of the driver, so only the storage accounts allowed by the admin are written
+	if accountName, _, _ := strings.Cut(destination.Host, "."); sasToken == "" && !d.snapshotExportStorageAccounts[strings.ToLower(accountName)] {
+		d.failSnapshotExport(export, fmt.Sprintf("destinationSecretName must be set since storage account %s is not in --snapshot-export-storage-accounts of the driver", accountName))
+		return nil
+	}
+	snapshotID, err := d.getVolumeSnapshotID(ctx, export.Namespace, export.Spec.VolumeSnapshotName)
+	if err != nil {
+		export.Status.Message = err.Error()
+		return err
+	}
+	if snapshotID == "" {
+		export.Status.Message = fmt.Sprintf("waiting for VolumeSnapshot %s to be ready", export.Spec.VolumeSnapshotName)
+		return nil
+	}
+
+	key := strings.ToLower(snapshotID)
+	sourceURL, err := d.snapshotExportClient.GrantAccess(ctx, snapshotID, snapshotExportAccessDuration)
+	if err != nil {
+		export.Status.Message = fmt.Sprintf("failed to grant access to snapshot %s: %v", snapshotID, err)
+		return err
+	}
+	copyID, err := d.snapshotExportClient.StartCopy(ctx, export.Spec.DestinationURI, sasToken, sourceURL)
+	if err != nil {
+		if copying[key] == 0 {
+			if err := d.snapshotExportClient.RevokeAccess(ctx, snapshotID); err != nil {
+				klog.Errorf("failed to revoke access to snapshot %s: %v", snapshotID, err)
+			}
+		}
+		export.Status.Message = fmt.Sprintf("failed to copy snapshot %s to %s: %v", snapshotID, export.Spec.DestinationURI, err)
+		return err
+	}
+	copying[key]++
+	export.Status = snapshotExportStatus{
+		Phase:      snapshotExportPhaseCopying,
+		SnapshotID: snapshotID,
+		CopyID:     copyID,
+		StartTime:  ptr.To(metav1.Now()),
+	}
+	klog.V(2).Infof("copying snapshot %s of %s %s/%s to %s", snapshotID, azSnapshotExportKind, export.Namespace, export.Name, export.Spec.DestinationURI)
+	d.recordEvent(getSnapshotExportReference(export), v1.EventTypeNormal, snapshotExportStartedReason, "copying snapshot %s to %s", snapshotID, export.Spec.DestinationURI)
+	return nil
+}
+
+// updateSnapshotExportProgress updates the status of export with the status of its copy, the access to the snapshot
+// is revoked after the copy is completed if no other copy of the snapshot is in progress
+func (d *Driver) updateSnapshotExportProgress(ctx context.Context, export *azSnapshotExport, sasToken string, copying map[string]int) error {
+	copyStatus, err := d.snapshotExportClient.GetCopyStatus(ctx, export.Spec.DestinationURI, sasToken)
+	if err != nil {
+		export.Status.Message = fmt.Sprintf("failed to get copy status of %s: %v", export.Spec.DestinationURI, err)
+		return err
+	}
+	if copyStatus.CopyID != export.Status.CopyID {
+		copyStatus.Status = "aborted"
+		copyStatus.Description = fmt.Sprintf("blob is overwritten by copy %s", copyStatus.CopyID)
+	}
+	export.Status.Progress = copyStatus.Progress
+	if copyStatus.Status == "pending" {
+		export.Status.Message = ""
+		return nil
+	}
+
+	key := strings.ToLower(export.Status.SnapshotID)
+	if copying[key] <= 1 {
+		if err := d.snapshotExportClient.RevokeAccess(ctx, export.Status.SnapshotID); err != nil {
+			// retried in the next round before the copy is marked as completed
+			export.Status.Message = fmt.Sprintf("failed to revoke access to snapshot %s: %v", export.Status.SnapshotID, err)
+			return err
+		}
+	}
+	copying[key]--
+	if copyStatus.Status != "success" {
+		d.failSnapshotExport(export, fmt.Sprintf("copy %s: %s", copyStatus.Status, copyStatus.Description))
+		return nil
+	}
+	export.Status.Phase = snapshotExportPhaseSucceeded
+	export.Status.Message = ""
+	export.Status.CompletionTime = ptr.To(metav1.Now())
+	klog.V(2).Infof("copied snapshot %s of %s %s/%s to %s", export.Status.SnapshotID, azSnapshotExportKind, export.Namespace, export.Name, export.Spec.DestinationURI)
+	d.recordEvent(getSnapshotExportReference(export), v1.EventTypeNormal, snapshotExportSucceededReason, "copied snapshot %s to %s", export.Status.SnapshotID, export.Spec.DestinationURI)
+	return nil
+}
+
+func (d *Driver) failSnapshotExport(export *azSnapshotExport, message string) {
+	export.Status.Phase = snapshotExportPhaseFailed
+	export.Status.Message = message
+	export.Status.CompletionTime = ptr.To(metav1.Now())
+	klog.Errorf("failed to export snapshot of %s %s/%s: %s", azSnapshotExportKind, export.Namespace, export.Name, message)
+	d.recordEvent(getSnapshotExportReference(export), v1.EventTypeWarning, snapshotExportFailedReason, "%s", message)
+}
+
+// getSnapshotExportSASToken returns the SAS token of the destination secret of export, empty if it's not set
+func (d *Driver) getSnapshotExportSASToken(ctx context.Context, export *azSnapshotExport) (string, error) {
+	if export.Spec.DestinationSecretName == "" {
+		return "", nil
+	}
+	secret, err := d.kubeClient.CoreV1().Secrets(export.Namespace).Get(ctx, export.Spec.DestinationSecretName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", export.Spec.DestinationSecretName, err)
+	}
+	sasToken := string(secret.Data[snapshotExportSASTokenKey])
+	if sasToken == "" {
+		return "", fmt.Errorf("%s of secret %s is empty", snapshotExportSASTokenKey, export.Spec.DestinationSecretName)
+	}
+	return sasToken, nil
+}
+
+// getVolumeSnapshotID returns the ID of the snapshot of the VolumeSnapshot created by the driver, empty if the
+// VolumeSnapshot is not ready. Only the snapshots of the VolumeSnapshots in the namespace of the export are exported,
+// so that an export could not read the snapshots of other namespaces.
+func (d *Driver) getVolumeSnapshotID(ctx context.Context, namespace, name string) (string, error) {
+	volumeSnapshot, err := d.volumeSnapshotClient.SnapshotV1().VolumeSnapshots(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get VolumeSnapshot %s: %w", name, err)
+	}
+	if volumeSnapshot.Status == nil || !ptr.Deref(volumeSnapshot.Status.ReadyToUse, false) || ptr.Deref(volumeSnapshot.Status.BoundVolumeSnapshotContentName, "") == "" {
+		return "", nil
+	}
+	contentName := *volumeSnapshot.Status.BoundVolumeSnapshotContentName
+	content, err := d.volumeSnapshotClient.SnapshotV1().VolumeSnapshotContents().Get(ctx, contentName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get VolumeSnapshotContent %s: %w", contentName, err)
+	}
+	if content.Spec.Driver != d.Name {
+		return "", fmt.Errorf("VolumeSnapshot %s is not created by %s", name, d.Name)
+	}
+	if content.Status == nil || ptr.Deref(content.Status.SnapshotHandle, "") == "" {
+		return "", nil
+	}
+	snapshotID := *content.Status.SnapshotHandle
+	snapshotName, resourceGroup, subsID, err := d.getSnapshotInfo(snapshotID)
+	if err != nil {
+		return "", err
+	}
+	snapshotClient, err := d.getClientFactory().GetSnapshotClientForSub(subsID)
+	if err != nil {
+		return "", fmt.Errorf("could not get snapshot client for subscription(%s) with error(%w)", subsID, err)
+	}
+	snapshot, err := snapshotClient.Get(ctx, resourceGroup, snapshotName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get snapshot %s: %w", snapshotID, err)
+	}
+	if ptr.Deref(snapshot.Tags[azureconsts.CreatedByTag], "") != consts.AzureDiskDriverTag {
+		return "", fmt.Errorf("snapshot %s is not created by %s", snapshotID, d.Name)
+	}
+	if !azureutils.IsSnapshotCopyCompleted(snapshot) {
+		return "", nil
+	}
+	return *snapshot.ID, nil
+}
+
+func (d *Driver) updateSnapshotExportStatus(ctx context.Context, export *azSnapshotExport) error {
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&export.Status)
+	if err != nil {
+		return err
+	}
+	obj, err := d.dynamicClient.Resource(azSnapshotExportResource).Namespace(export.Namespace).Get(ctx, export.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if obj.GetUID() != export.UID {
+		return nil
+	}
+	if err := unstructured.SetNestedMap(obj.Object, status, "status"); err != nil {
+		return err
+	}
+	_, err = d.dynamicClient.Resource(azSnapshotExportResource).Namespace(export.Namespace).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+func getSnapshotExportReference(export *azSnapshotExport) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: azSnapshotExportResource.GroupVersion().String(),
+		Kind:       azSnapshotExportKind,
+		Namespace:  export.Namespace,
+		Name:       export.Name,
+		UID:        export.UID,
+	}
+}
@@ -0,0 +1,274 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/snapshotclient/mock_snapshotclient"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+const testExportDestinationURI = "https://account.blob.core.windows.net/backups/snapshot.vhd"
+
+type fakeSnapshotExportClient struct {
+	granted    []string
+	revoked    []string
+	copies     map[string]string
+	copyStatus *blobCopyStatus
+}
+
+func (c *fakeSnapshotExportClient) GrantAccess(_ context.Context, snapshotID string, _ time.Duration) (string, error) {
+	c.granted = append(c.granted, snapshotID)
+	return "https://md-xxx.blob.core.windows.net/abcd/abcd?sv=2018-03-28&sig=secret", nil
+}
+
+func (c *fakeSnapshotExportClient) RevokeAccess(_ context.Context, snapshotID string) error {
+	c.revoked = append(c.revoked, snapshotID)
+	return nil
+}
+
+func (c *fakeSnapshotExportClient) StartCopy(_ context.Context, destinationURI, sasToken, _ string) (string, error) {
+	c.copies[destinationURI] = sasToken
+	return "copy1", nil
+}
+
+func (c *fakeSnapshotExportClient) GetCopyStatus(_ context.Context, _, _ string) (*blobCopyStatus, error) {
+	return c.copyStatus, nil
+}
+
+func TestAzureSnapshotExportClientCopy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/backups/snapshot.vhd", r.URL.Path)
+		assert.Equal(t, blobAPIVersion, r.Header.Get("x-ms-version"))
+		if r.URL.RawQuery == "" {
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		} else {
+			assert.Equal(t, "sv=2022-11-02&sig=secret", r.URL.RawQuery)
+			assert.Empty(t, r.Header.Get("Authorization"))
+		}
+		w.Header().Set("x-ms-copy-id", "copy1")
+		switch r.Method {
+		case http.MethodPut:
+			assert.Equal(t, "https://source/snapshot?sig=source", r.Header.Get("x-ms-copy-source"))
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodHead:
+			w.Header().Set("x-ms-copy-status", "pending")
+			w.Header().Set("x-ms-copy-progress", "512/1024")
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	c := newSnapshotExportClientWithOptions(fakeTokenCredential{}, &policy.ClientOptions{
+		Transport: server.Client(),
+		Retry:     policy.RetryOptions{MaxRetries: -1},
+	})
+	ctx := context.Background()
+	destinationURI := strings.Replace(testExportDestinationURI, "https://account.blob.core.windows.net", server.URL, 1)
+
+	for _, sasToken := range []string{"", "?sv=2022-11-02&sig=secret"} {
+		copyID, err := c.StartCopy(ctx, destinationURI, sasToken, "https://source/snapshot?sig=source")
+		require.NoError(t, err)
+		assert.Equal(t, "copy1", copyID)
+
+		copyStatus, err := c.GetCopyStatus(ctx, destinationURI, sasToken)
+		require.NoError(t, err)
+		assert.Equal(t, &blobCopyStatus{CopyID: "copy1", Status: "pending", Progress: "512/1024"}, copyStatus)
+	}
+}
+
+func newTestSnapshotExportDriver(t *testing.T, cntl *gomock.Controller) (*fakeDriverV1, *fakeSnapshotExportClient, string) {
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	subsID, resourceGroup := d.getCloud().SubscriptionID, d.getCloud().ResourceGroup
+	snapshotID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/snapshots/snapshot", subsID, resourceGroup)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/apis/snapshot.storage.k8s.io/v1/namespaces/default/volumesnapshots/"):
+			_ = json.NewEncoder(w).Encode(&snapshotv1.VolumeSnapshot{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Status:     &snapshotv1.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: ptr.To("content-" + name), ReadyToUse: ptr.To(true)},
+			})
+		case strings.HasPrefix(r.URL.Path, "/apis/snapshot.storage.k8s.io/v1/volumesnapshotcontents/"):
+			driver := d.Name
+			if name == "content-vs-other" {
+				driver = "other.csi.azure.com"
+			}
+			_ = json.NewEncoder(w).Encode(&snapshotv1.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Spec:       snapshotv1.VolumeSnapshotContentSpec{Driver: driver},
+				Status:     &snapshotv1.VolumeSnapshotContentStatus{SnapshotHandle: ptr.To(snapshotID)},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	d.volumeSnapshotClient = snapshotclientset.NewForConfigOrDie(&rest.Config{Host: server.URL})
+
+	mockSnapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub(subsID).Return(mockSnapshotClient, nil).AnyTimes()
+	mockSnapshotClient.EXPECT().Get(gomock.Any(), resourceGroup, "snapshot").Return(&armcompute.Snapshot{
+		ID:   ptr.To(snapshotID),
+		Name: ptr.To("snapshot"),
+		Tags: map[string]*string{azureconsts.CreatedByTag: ptr.To(consts.AzureDiskDriverTag)},
+	}, nil).AnyTimes()
+
+	exportClient := &fakeSnapshotExportClient{copies: map[string]string{}}
+	d.snapshotExportClient = exportClient
+	d.kubeClient = fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup-sas", Namespace: "default"},
+		Data:       map[string][]byte{snapshotExportSASTokenKey: []byte("sv=2022-11-02&sig=secret")},
+	})
+	return d, exportClient, snapshotID
+}
+
+func TestReconcileSnapshotExport(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, exportClient, snapshotID := newTestSnapshotExportDriver(t, cntl)
+	ctx := context.Background()
+	key := strings.ToLower(snapshotID)
+
+	export := &azSnapshotExport{
+		ObjectMeta: metav1.ObjectMeta{Name: "export", Namespace: "default"},
+		Spec:       snapshotExportSpec{VolumeSnapshotName: "vs", DestinationURI: testExportDestinationURI, DestinationSecretName: "backup-sas"},
+	}
+	copying := map[string]int{}
+	require.NoError(t, d.reconcileSnapshotExport(ctx, export, copying))
+	assert.Equal(t, snapshotExportPhaseCopying, export.Status.Phase)
+	assert.Equal(t, snapshotID, export.Status.SnapshotID)
+	assert.Equal(t, "copy1", export.Status.CopyID)
+	assert.Equal(t, 1, copying[key])
+	assert.Equal(t, []string{snapshotID}, exportClient.granted)
+	assert.Equal(t, map[string]string{testExportDestinationURI: "sv=2022-11-02&sig=secret"}, exportClient.copies)
+
+	exportClient.copyStatus = &blobCopyStatus{CopyID: "copy1", Status: "pending", Progress: "512/1024"}
+	require.NoError(t, d.reconcileSnapshotExport(ctx, export, copying))
+	assert.Equal(t, snapshotExportPhaseCopying, export.Status.Phase)
+	assert.Equal(t, "512/1024", export.Status.Progress)
+
+	// access to the snapshot is kept for the other copy in progress
+	copying[key] = 2
+	exportClient.copyStatus = &blobCopyStatus{CopyID: "copy1", Status: "success", Progress: "1024/1024"}
+	require.NoError(t, d.reconcileSnapshotExport(ctx, export, copying))
+	assert.Equal(t, snapshotExportPhaseSucceeded, export.Status.Phase)
+	assert.NotNil(t, export.Status.CompletionTime)
+	assert.Empty(t, exportClient.revoked)
+	assert.Equal(t, 1, copying[key])
+
+	export.Status = snapshotExportStatus{Phase: snapshotExportPhaseCopying, SnapshotID: snapshotID, CopyID: "copy1"}
+	exportClient.copyStatus = &blobCopyStatus{CopyID: "copy2", Status: "pending"}
+	require.NoError(t, d.reconcileSnapshotExport(ctx, export, copying))
+	assert.Equal(t, snapshotExportPhaseFailed, export.Status.Phase)
+	assert.Equal(t, "copy aborted: blob is overwritten by copy copy2", export.Status.Message)
+	assert.Equal(t, []string{snapshotID}, exportClient.revoked)
+	assert.Equal(t, 0, copying[key])
+
+	export = &azSnapshotExport{
+		ObjectMeta: metav1.ObjectMeta{Name: "export", Namespace: "default"},
+		Spec:       snapshotExportSpec{VolumeSnapshotName: "vs", DestinationURI: testExportDestinationURI + "?sig=secret"},
+	}
+	require.NoError(t, d.reconcileSnapshotExport(ctx, export, copying))
+	assert.Equal(t, snapshotExportPhaseFailed, export.Status.Phase)
+
+	// the identity of the driver only writes the storage accounts allowed by the admin
+	export = &azSnapshotExport{
+		ObjectMeta: metav1.ObjectMeta{Name: "export", Namespace: "default"},
+		Spec:       snapshotExportSpec{VolumeSnapshotName: "vs", DestinationURI: testExportDestinationURI},
+	}
+	require.NoError(t, d.reconcileSnapshotExport(ctx, export, copying))
+	assert.Equal(t, snapshotExportPhaseFailed, export.Status.Phase)
+	assert.Equal(t, "destinationSecretName must be set since storage account account is not in --snapshot-export-storage-accounts of the driver", export.Status.Message)
+	assert.Equal(t, []string{snapshotID}, exportClient.granted)
+
+	d.snapshotExportStorageAccounts = map[string]bool{"account": true}
+	export = &azSnapshotExport{
+		ObjectMeta: metav1.ObjectMeta{Name: "export", Namespace: "default"},
+		Spec:       snapshotExportSpec{VolumeSnapshotName: "vs-other", DestinationURI: testExportDestinationURI},
+	}
+	assert.Error(t, d.reconcileSnapshotExport(ctx, export, copying))
+	assert.Equal(t, snapshotExportPhasePending, export.Status.Phase)
+	assert.Equal(t, fmt.Sprintf("VolumeSnapshot vs-other is not created by %s", d.Name), export.Status.Message)
+}
+
+func TestExportSnapshots(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, exportClient, snapshotID := newTestSnapshotExportDriver(t, cntl)
+	d.snapshotExportStorageAccounts = map[string]bool{"account": true}
+	ctx := context.Background()
+
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{azSnapshotExportResource: "AzSnapshotExportList"},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "disk.csi.azure.com/v1alpha1",
+			"kind":       azSnapshotExportKind,
+			"metadata":   map[string]interface{}{"name": "export", "namespace": "default"},
+			"spec":       map[string]interface{}{"volumeSnapshotName": "vs", "destinationURI": testExportDestinationURI},
+		}})
+
+	require.NoError(t, d.exportSnapshots(ctx))
+	obj, err := d.dynamicClient.Resource(azSnapshotExportResource).Namespace("default").Get(ctx, "export", metav1.GetOptions{})
+	require.NoError(t, err)
+	status, _, _ := unstructured.NestedStringMap(obj.Object, "status")
+	assert.Equal(t, snapshotExportPhaseCopying, status["phase"])
+	assert.Equal(t, snapshotID, status["snapshotID"])
+	assert.Equal(t, "copy1", status["copyID"])
+	assert.Equal(t, map[string]string{testExportDestinationURI: ""}, exportClient.copies)
+
+	exportClient.copyStatus = &blobCopyStatus{CopyID: "copy1", Status: "success", Progress: "1024/1024"}
+	require.NoError(t, d.exportSnapshots(ctx))
+	obj, err = d.dynamicClient.Resource(azSnapshotExportResource).Namespace("default").Get(ctx, "export", metav1.GetOptions{})
+	require.NoError(t, err)
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	assert.Equal(t, snapshotExportPhaseSucceeded, phase)
+	assert.Equal(t, []string{snapshotID}, exportClient.revoked)
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+const snapshotPrunedReason = "SnapshotPruned"
+
+// expiredSnapshot is a snapshot to prune and the reason it violates the retention policy
+type expiredSnapshot struct {
+	snapshot *armcompute.Snapshot
+	reason   string
+}
+
+// runSnapshotRetentionController prunes the snapshots created by the driver according to their retention policies
+// every interval
+func (d *Driver) runSnapshotRetentionController(ctx context.Context, interval time.Duration) {
+	klog.V(2).Infof("pruning snapshots by retention policies every %v", interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := d.pruneSnapshots(ctx); err != nil {
+			klog.Errorf("failed to prune snapshots: %v", err)
+		}
+	}, interval)
+}
+
+// pruneSnapshots lists the snapshots in the resource group of the cluster and the resource groups of the
+// VolumeSnapshotContents of the driver, and prunes the ones violating the retention policies tagged by CreateSnapshot
+func (d *Driver) pruneSnapshots(ctx context.Context) error {
+	contentList, err := d.volumeSnapshotClient.SnapshotV1().VolumeSnapshotContents().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list VolumeSnapshotContents: %w", err)
+	}
+	// contents are keyed by the lower case snapshot ID, the resource groups by the lower case subscription/resource group
+	contents := map[string]*snapshotv1.VolumeSnapshotContent{}
+	resourceGroups := map[string][2]string{
+		strings.ToLower(d.getCloud().SubscriptionID + "/" + d.getCloud().ResourceGroup): {d.getCloud().SubscriptionID, d.getCloud().ResourceGroup},
+	}
+	for i := range contentList.Items {
+		content := &contentList.Items[i]
+		snapshotID := ptr.Deref(content.Spec.Source.SnapshotHandle, "")
+		if content.Status != nil && content.Status.SnapshotHandle != nil {
+			snapshotID = *content.Status.SnapshotHandle
+		}
+		if snapshotID == "" || content.Spec.Driver != d.Name {
+			continue
+		}
+		contents[strings.ToLower(snapshotID)] = content
+		if _, resourceGroup, subsID, err := d.getSnapshotInfo(snapshotID); err == nil {
+			resourceGroups[strings.ToLower(subsID+"/"+resourceGroup)] = [2]string{subsID, resourceGroup}
+		}
+	}
+
+	var snapshots []*armcompute.Snapshot
+	for _, rg := range resourceGroups {
+		snapshotClient, err := d.getClientFactory().GetSnapshotClientForSub(rg[0])
+		if err != nil {
+			klog.Errorf("could not get snapshot client for subscription(%s) with error(%v)", rg[0], err)
+			continue
+		}
+		list, err := snapshotClient.List(ctx, rg[1])
+		if err != nil {
+			klog.Errorf("failed to list snapshots under rg(%s) of subscription(%s): %v", rg[1], rg[0], err)
+			continue
+		}
+		snapshots = append(snapshots, list...)
+	}
+
+	for _, expired := range getExpiredSnapshots(snapshots, time.Now()) {
+		if err := d.pruneSnapshot(ctx, expired, contents[strings.ToLower(*expired.snapshot.ID)]); err != nil {
+			klog.Errorf("failed to prune snapshot %s: %v", *expired.snapshot.ID, err)
+		}
+	}
+	return nil
+}
+
+// pruneSnapshot deletes the VolumeSnapshot bound to content so that the snapshot is deleted by the snapshotter
+// according to the deletion policy of content. A snapshot without VolumeSnapshotContent of the driver is never
+// deleted, since it could be a snapshot of another cluster in the resource group, a snapshot whose content is
+// not created yet, or a snapshot retained by the deletion policy of its deleted content.
+func (d *Driver) pruneSnapshot(ctx context.Context, expired expiredSnapshot, content *snapshotv1.VolumeSnapshotContent) error {
+	snapshotID := *expired.snapshot.ID
+	if content == nil {
+		klog.V(4).Infof("snapshot %s is not pruned since it has no VolumeSnapshotContent of %s: %s", snapshotID, d.Name, expired.reason)
+		return nil
+	}
+	ref := content.Spec.VolumeSnapshotRef
+	if ref.Name == "" {
+		return nil
+	}
+	err := d.volumeSnapshotClient.SnapshotV1().VolumeSnapshots(ref.Namespace).Delete(ctx, ref.Name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		// the VolumeSnapshot is deleted, the snapshot is kept if the deletion policy is Retain
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete VolumeSnapshot %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	klog.V(2).Infof("deleted VolumeSnapshot %s/%s of snapshot %s: %s", ref.Namespace, ref.Name, snapshotID, expired.reason)
+	d.recordEvent(getVolumeSnapshotReference(ref.Namespace, ref.Name), v1.EventTypeNormal, snapshotPrunedReason, "deleted VolumeSnapshot of snapshot %s: %s", snapshotID, expired.reason)
+	return nil
+}
+
+// getExpiredSnapshots returns the snapshots created by the driver which are older than their retentionDays, or have
+// at least maxSnapshotsPerVolume newer snapshots of the same source volume. Snapshots being copied are skipped.
+func getExpiredSnapshots(snapshots []*armcompute.Snapshot, now time.Time) []expiredSnapshot {
+	volumeSnapshots := map[string][]*armcompute.Snapshot{}
+	for _, snapshot := range snapshots {
+		if snapshot == nil || snapshot.ID == nil || snapshot.Properties == nil || snapshot.Properties.TimeCreated == nil {
+			continue
+		}
+		if ptr.Deref(snapshot.Tags[azureconsts.CreatedByTag], "") != consts.AzureDiskDriverTag {
+			continue
+		}
+		sourceVolumeID := strings.ToLower(ptr.Deref(snapshot.Tags[consts.SourceVolumeIDTag], ""))
+		if sourceVolumeID == "" {
+			continue
+		}
+		volumeSnapshots[sourceVolumeID] = append(volumeSnapshots[sourceVolumeID], snapshot)
+	}
+
+	var expired []expiredSnapshot
+	for _, snapshots := range volumeSnapshots {
+		sort.Slice(snapshots, func(i, j int) bool {
+			return snapshots[i].Properties.TimeCreated.After(*snapshots[j].Properties.TimeCreated)
+		})
+		for i, snapshot := range snapshots {
+			if !azureutils.IsSnapshotCopyCompleted(snapshot) {
+				continue
+			}
+			days, _ := strconv.Atoi(ptr.Deref(snapshot.Tags[consts.SnapshotRetentionDaysTag], ""))
+			maxSnapshots, _ := strconv.Atoi(ptr.Deref(snapshot.Tags[consts.MaxSnapshotsPerVolumeTag], ""))
+			switch {
+			case days > 0 && snapshot.Properties.TimeCreated.Add(time.Duration(days)*24*time.Hour).Before(now):
+				expired = append(expired, expiredSnapshot{snapshot: snapshot, reason: fmt.Sprintf("snapshot is older than retentionDays %d", days)})
+			case maxSnapshots > 0 && i >= maxSnapshots:
+				expired = append(expired, expiredSnapshot{snapshot: snapshot, reason: fmt.Sprintf("source volume has %d newer snapshots, maxSnapshotsPerVolume is %d", i, maxSnapshots)})
+			}
+		}
+	}
+	return expired
+}
+
+// getVolumeSnapshotReference returns the object reference of a VolumeSnapshot, nil is returned if name is empty
+func getVolumeSnapshotReference(namespace, name string) *v1.ObjectReference {
+	if name == "" {
+		return nil
+	}
+	return &v1.ObjectReference{APIVersion: "snapshot.storage.k8s.io/v1", Kind: "VolumeSnapshot", Namespace: namespace, Name: name}
+}
@@ -0,0 +1,198 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/snapshotclient/mock_snapshotclient"
+)
+
+func newTestRetainedSnapshot(subsID, resourceGroup, name, sourceVolumeID string, created time.Time, tags map[string]string) *armcompute.Snapshot {
+	snapshot := &armcompute.Snapshot{
+		ID:   ptr.To(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/snapshots/%s", subsID, resourceGroup, name)),
+		Name: ptr.To(name),
+		Tags: map[string]*string{
+			azureconsts.CreatedByTag: ptr.To(consts.AzureDiskDriverTag),
+			consts.SourceVolumeIDTag: ptr.To(sourceVolumeID),
+		},
+		Properties: &armcompute.SnapshotProperties{TimeCreated: ptr.To(created)},
+	}
+	for k, v := range tags {
+		snapshot.Tags[k] = ptr.To(v)
+	}
+	return snapshot
+}
+
+func TestCreateSnapshotRetentionParameters(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	for _, parameters := range []map[string]string{
+		{"retentionDays": "-1"},
+		{"maxSnapshotsPerVolume": "many"},
+	} {
+		_, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+			SourceVolumeId: "vol_1",
+			Name:           "snapname",
+			Parameters:     parameters,
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "parameters: %v, error: %v", parameters, err)
+	}
+}
+
+func TestGetExpiredSnapshots(t *testing.T) {
+	now := time.Now()
+	policy := map[string]string{consts.SnapshotRetentionDaysTag: "7", consts.MaxSnapshotsPerVolumeTag: "2"}
+	snapshots := []*armcompute.Snapshot{
+		newTestRetainedSnapshot("sub", "rg", "disk1-3", "disk1", now.Add(-3*time.Hour), policy),
+		newTestRetainedSnapshot("sub", "rg", "disk1-1", "disk1", now.Add(-1*time.Hour), policy),
+		newTestRetainedSnapshot("sub", "rg", "disk1-2", "DISK1", now.Add(-2*time.Hour), policy),
+		newTestRetainedSnapshot("sub", "rg", "disk2-old", "disk2", now.Add(-8*24*time.Hour), policy),
+		newTestRetainedSnapshot("sub", "rg", "disk2-no-policy", "disk2", now.Add(-30*24*time.Hour), nil),
+		newTestRetainedSnapshot("sub", "rg", "disk3-invalid", "disk3", now.Add(-30*24*time.Hour), map[string]string{consts.SnapshotRetentionDaysTag: "x"}),
+		nil,
+		{ID: ptr.To("no-properties")},
+	}
+	copying := newTestRetainedSnapshot("sub", "rg", "disk2-copying", "disk2", now.Add(-30*24*time.Hour), policy)
+	copying.Properties.CreationData = &armcompute.CreationData{CreateOption: ptr.To(armcompute.DiskCreateOptionCopyStart)}
+	copying.Properties.CompletionPercent = ptr.To[float32](50)
+	otherCreator := newTestRetainedSnapshot("sub", "rg", "other", "disk2", now.Add(-30*24*time.Hour), policy)
+	otherCreator.Tags[azureconsts.CreatedByTag] = ptr.To("other")
+	snapshots = append(snapshots, copying, otherCreator)
+
+	expired := getExpiredSnapshots(snapshots, now)
+	reasons := map[string]string{}
+	for _, e := range expired {
+		reasons[*e.snapshot.Name] = e.reason
+	}
+	assert.Equal(t, map[string]string{
+		"disk1-3":   "source volume has 2 newer snapshots, maxSnapshotsPerVolume is 2",
+		"disk2-old": "snapshot is older than retentionDays 7",
+	}, reasons)
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	ctx := context.Background()
+	subsID, resourceGroup := d.getCloud().SubscriptionID, d.getCloud().ResourceGroup
+
+	now := time.Now()
+	policy := map[string]string{consts.MaxSnapshotsPerVolumeTag: "1"}
+	bound := newTestRetainedSnapshot(subsID, resourceGroup, "bound", "disk1", now.Add(-2*time.Hour), policy)
+	orphan := newTestRetainedSnapshot(subsID, resourceGroup, "orphan", "disk1", now.Add(-3*time.Hour), map[string]string{
+		consts.MaxSnapshotsPerVolumeTag: "1",
+		consts.SnapshotNameTag:          "vs-orphan",
+		consts.SnapshotNamespaceTag:     "default",
+	})
+	snapshots := []*armcompute.Snapshot{
+		newTestRetainedSnapshot(subsID, resourceGroup, "latest", "disk1", now.Add(-1*time.Hour), policy),
+		bound,
+		orphan,
+	}
+
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/snapshot.storage.k8s.io/v1/volumesnapshotcontents":
+			_ = json.NewEncoder(w).Encode(&snapshotv1.VolumeSnapshotContentList{Items: []snapshotv1.VolumeSnapshotContent{{
+				ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-bound"},
+				Spec: snapshotv1.VolumeSnapshotContentSpec{
+					Driver:            d.Name,
+					VolumeSnapshotRef: v1.ObjectReference{Namespace: "default", Name: "vs-bound"},
+				},
+				Status: &snapshotv1.VolumeSnapshotContentStatus{SnapshotHandle: bound.ID},
+			}, {
+				// the VolumeSnapshots of the contents of other drivers are not deleted
+				ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-other"},
+				Spec: snapshotv1.VolumeSnapshotContentSpec{
+					Driver:            "other.csi.azure.com",
+					VolumeSnapshotRef: v1.ObjectReference{Namespace: "default", Name: "vs-other"},
+				},
+				Status: &snapshotv1.VolumeSnapshotContentStatus{SnapshotHandle: orphan.ID},
+			}}})
+		case r.Method == http.MethodDelete && r.URL.Path == "/apis/snapshot.storage.k8s.io/v1/namespaces/default/volumesnapshots/vs-bound":
+			_ = json.NewEncoder(w).Encode(&metav1.Status{Status: metav1.StatusSuccess})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	d.volumeSnapshotClient = snapshotclientset.NewForConfigOrDie(&rest.Config{Host: server.URL})
+
+	mockSnapshotClient := mock_snapshotclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetSnapshotClientForSub(subsID).Return(mockSnapshotClient, nil).AnyTimes()
+	mockSnapshotClient.EXPECT().List(gomock.Any(), resourceGroup).Return(snapshots, nil).Times(1)
+	// the snapshot without VolumeSnapshotContent of the driver is not deleted
+	mockSnapshotClient.EXPECT().Delete(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	require.NoError(t, d.pruneSnapshots(ctx))
+	assert.Equal(t, []string{
+		"GET /apis/snapshot.storage.k8s.io/v1/volumesnapshotcontents",
+		"DELETE /apis/snapshot.storage.k8s.io/v1/namespaces/default/volumesnapshots/vs-bound",
+	}, requests)
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	sort.Strings(events)
+	assert.Equal(t, []string{
+		fmt.Sprintf("Normal SnapshotPruned deleted VolumeSnapshot of snapshot %s: source volume has 1 newer snapshots, maxSnapshotsPerVolume is 1", *bound.ID),
+	}, events)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
+
+	csicommon "sigs.k8s.io/azuredisk-csi-driver/pkg/csi-common"
+)
+
+// socketDialTimeout is the timeout of dialing the endpoint socket to check that it's still served
+var socketDialTimeout = 5 * time.Second
+
+// getEndpointSocketPath returns the path of the unix socket of endpoint, empty if endpoint is not a unix socket
+func getEndpointSocketPath(endpoint string) string {
+	proto, addr, err := csicommon.ParseEndpoint(endpoint)
+	if err != nil || proto != "unix" {
+		return ""
+	}
+	if runtime.GOOS != "windows" {
+		addr = filepath.Clean("/" + addr)
+	}
+	return addr
+}
+
+// checkEndpointSocket returns an error if the socket of path is deleted, replaced by another file or stale, i.e. not
+// accepting connections any more
+func checkEndpointSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS != "windows" && info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, socketDialTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// runSocketWatchdog checks the endpoint socket of the node every interval and serves s on a new socket if it's lost,
+// e.g. the plugin directory is cleaned up during a node image upgrade, so that kubelet and the node driver registrar
+// reach the driver again without restarting the pod
+func (d *Driver) runSocketWatchdog(ctx context.Context, s *grpc.Server, interval time.Duration) {
+	path := getEndpointSocketPath(d.endpoint)
+	if path == "" {
+		klog.V(2).Infof("endpoint %s is not a unix socket, socket watchdog is disabled", d.endpoint)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := checkEndpointSocket(path); err != nil {
+				klog.Warningf("endpoint socket %s is lost, listening on a new socket: %v", path, err)
+				listener, err := csicommon.Listen(ctx, d.endpoint)
+				if err != nil {
+					klog.Errorf("failed to listen to endpoint %s: %v", d.endpoint, err)
+					continue
+				}
+				go func() {
+					if err := s.Serve(listener); err != nil {
+						klog.V(2).Infof("gRPC server stopped serving on the recreated socket %s: %v", path, err)
+					}
+				}()
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+
+	csicommon "sigs.k8s.io/azuredisk-csi-driver/pkg/csi-common"
+)
+
+func TestGetEndpointSocketPath(t *testing.T) {
+	assert.Equal(t, "/csi/csi.sock", getEndpointSocketPath("unix:///csi/csi.sock"))
+	assert.Equal(t, "", getEndpointSocketPath("tcp://127.0.0.1:10000"))
+	assert.Equal(t, "", getEndpointSocketPath("invalid"))
+}
+
+func TestCheckEndpointSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "csi.sock")
+	assert.Error(t, checkEndpointSocket(path), "deleted socket")
+
+	listener, err := csicommon.Listen(context.Background(), "unix://"+path)
+	require.NoError(t, err)
+	assert.NoError(t, checkEndpointSocket(path))
+	listener.Close()
+	assert.Error(t, checkEndpointSocket(path), "closed socket")
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0600))
+	assert.Error(t, checkEndpointSocket(file), "regular file")
+}
+
+func TestRunSocketWatchdog(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "csi.sock")
+	d.endpoint = "unix://" + path
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := grpc.NewServer()
+	defer s.Stop()
+	listener, err := csicommon.Listen(ctx, d.endpoint)
+	require.NoError(t, err)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	go d.runSocketWatchdog(ctx, s, 10*time.Millisecond)
+
+	require.NoError(t, os.Remove(path))
+	assert.Eventually(t, func() bool {
+		return checkEndpointSocket(path) == nil
+	}, 10*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+const (
+	// kubelet stages the filesystem volumes of a CSI driver on <kubelet dir>/plugins/kubernetes.io/csi/<driver>/<sha256
+	// of the volume handle>/globalmount, older kubelets on <kubelet dir>/plugins/kubernetes.io/csi/pv/<PV name>/globalmount
+	kubeletCSIPluginDir    = "/plugins/kubernetes.io/csi/"
+	kubeletLegacyStageDir  = "pv"
+	kubeletGlobalMountName = "globalmount"
+)
+
+// stagedMount is a filesystem volume staged on the node, found by its staging path in the mount table
+type stagedMount struct {
+	volumeID string
+	// volumeContext is the volume attributes of the PV with the PV name
+	volumeContext map[string]string
+	stagingPath   string
+}
+
+// getStagedMounts returns the filesystem volumes of the driver in pvs which are staged on the node, so that the state
+// kept in memory for the staged volumes can be rebuilt after a restart of the node plugin. Block volumes are not
+// staged on a mount and are not returned.
+func (d *DriverCore) getStagedMounts(pvs []*v1.PersistentVolume) ([]stagedMount, error) {
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mount points: %w", err)
+	}
+	// <driver or pv/sha256 or PV name, staging path>
+	stagingPaths := map[string]string{}
+	for _, mountPoint := range mountPoints {
+		mountPath := filepath.ToSlash(mountPoint.Path)
+		if !strings.Contains(mountPath, kubeletCSIPluginDir) || path.Base(mountPath) != kubeletGlobalMountName {
+			continue
+		}
+		volumeDir := path.Dir(mountPath)
+		stagingPaths[path.Base(path.Dir(volumeDir))+"/"+path.Base(volumeDir)] = mountPoint.Path
+	}
+
+	staged := []stagedMount{}
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name {
+			continue
+		}
+		stagingPath, ok := stagingPaths[fmt.Sprintf("%s/%x", d.Name, sha256.Sum256([]byte(pv.Spec.CSI.VolumeHandle)))]
+		if !ok {
+			stagingPath, ok = stagingPaths[kubeletLegacyStageDir+"/"+pv.Name]
+		}
+		if !ok {
+			continue
+		}
+		volumeContext := map[string]string{}
+		for k, v := range pv.Spec.CSI.VolumeAttributes {
+			volumeContext[k] = v
+		}
+		volumeContext[consts.PvNameKey] = pv.Name
+		staged = append(staged, stagedMount{volumeID: pv.Spec.CSI.VolumeHandle, volumeContext: volumeContext, stagingPath: stagingPath})
+	}
+	return staged, nil
+}
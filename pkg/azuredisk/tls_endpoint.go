@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"k8s.io/klog/v2"
+
+	csicommon "sigs.k8s.io/azuredisk-csi-driver/pkg/csi-common"
+)
+
+// csiServer is implemented by the drivers serving all the CSI services
+type csiServer interface {
+	csi.IdentityServer
+	csi.ControllerServer
+	csi.NodeServer
+}
+
+// newGRPCServer returns a gRPC server serving the CSI services of the driver role implemented by srv
+func (d *DriverCore) newGRPCServer(srv csiServer, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	csi.RegisterIdentityServer(s, srv)
+	if d.servesController() {
+		csi.RegisterControllerServer(s, srv)
+	}
+	if d.servesNode() {
+		csi.RegisterNodeServer(s, srv)
+	}
+	return s
+}
+
+// loadCertificateAndCA returns the certificate of tls.crt and tls.key in certDir and the pool of ca.crt in certDir
+func loadCertificateAndCA(certDir string) (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certDir, "tls.crt"), filepath.Join(certDir, "tls.key"))
+	if err != nil {
+		return cert, nil, fmt.Errorf("failed to load certificate from %s: %w", certDir, err)
+	}
+	caFile := filepath.Join(certDir, "ca.crt")
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return cert, nil, fmt.Errorf("failed to read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return cert, nil, fmt.Errorf("no certificate found in CA %s", caFile)
+	}
+	return cert, pool, nil
+}
+
+// newMutualTLSConfig returns the TLS config of a server presenting tls.crt and tls.key in certDir and requiring
+// client certificates signed by ca.crt in certDir
+func newMutualTLSConfig(certDir string) (*tls.Config, error) {
+	cert, clientCAs, err := loadCertificateAndCA(certDir)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// runTLSEndpoint serves the CSI services of srv on the TCP endpoint with mTLS until ctx is done, opts are the
+// server options of the unix socket endpoint
+func (d *DriverCore) runTLSEndpoint(ctx context.Context, srv csiServer, opts []grpc.ServerOption) {
+	if !strings.HasPrefix(strings.ToLower(d.tlsEndpoint), "tcp://") {
+		klog.Errorf("TLS endpoint %s is not a tcp:// endpoint, CSI services are only served on %s", d.tlsEndpoint, d.endpoint)
+		return
+	}
+	tlsConfig, err := newMutualTLSConfig(d.tlsCertDir)
+	if err != nil {
+		klog.Errorf("failed to serve TLS endpoint %s: %v", d.tlsEndpoint, err)
+		return
+	}
+	listener, err := csicommon.Listen(ctx, d.tlsEndpoint)
+	if err != nil {
+		klog.Errorf("failed to listen to TLS endpoint %s: %v", d.tlsEndpoint, err)
+		return
+	}
+	s := d.newGRPCServer(srv, append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))...)
+	go func() {
+		<-ctx.Done()
+		s.GracefulStop()
+	}()
+	klog.V(2).Infof("serving CSI services with mTLS on %s", listener.Addr())
+	if err := s.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		klog.Errorf("TLS endpoint %s stopped with error: %v", d.tlsEndpoint, err)
+	}
+}
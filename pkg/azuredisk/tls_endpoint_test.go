@@ -0,0 +1,155 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// newTestCert returns a PEM encoded certificate and key signed by parent, the certificate is self-signed if parent is nil
+func newTestCert(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, []byte, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), cert, key
+}
+
+func TestNewGRPCServer(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	tests := []struct {
+		role             string
+		expectController bool
+		expectNode       bool
+	}{
+		{role: "", expectController: true, expectNode: true},
+		{role: consts.DriverRoleController, expectController: true},
+		{role: consts.DriverRoleNode, expectNode: true},
+	}
+	for _, test := range tests {
+		d.setDriverRole(test.role)
+		services := d.newGRPCServer(d).GetServiceInfo()
+		assert.Contains(t, services, "csi.v1.Identity", test.role)
+		_, ok := services["csi.v1.Controller"]
+		assert.Equal(t, test.expectController, ok, test.role)
+		_, ok = services["csi.v1.Node"]
+		assert.Equal(t, test.expectNode, ok, test.role)
+	}
+}
+
+func TestRunTLSEndpoint(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	notAfter := time.Now().Add(time.Hour)
+	caPEM, _, ca, caKey := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test-ca"}, NotAfter: notAfter,
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}, nil, nil)
+	serverPEM, serverKeyPEM, _, _ := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "server"}, NotAfter: notAfter,
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	clientPEM, clientKeyPEM, _, _ := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "client"}, NotAfter: notAfter,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	certDir := t.TempDir()
+	for name, data := range map[string][]byte{"ca.crt": caPEM, "tls.crt": serverPEM, "tls.key": serverKeyPEM} {
+		require.NoError(t, os.WriteFile(filepath.Join(certDir, name), data, 0600))
+	}
+	_, err = newMutualTLSConfig(t.TempDir())
+	assert.Error(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	d.tlsEndpoint = "tcp://" + addr
+	d.tlsCertDir = certDir
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		d.runTLSEndpoint(ctx, d, nil)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AppendCertsFromPEM(caPEM)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	require.NoError(t, err)
+	getPluginInfo := func(certificates []tls.Certificate, callOpts ...grpc.CallOption) error {
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      rootCAs,
+			Certificates: certificates,
+			MinVersion:   tls.VersionTLS12,
+		})))
+		require.NoError(t, err)
+		defer conn.Close()
+		callCtx, callCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer callCancel()
+		_, err = csi.NewIdentityClient(conn).GetPluginInfo(callCtx, &csi.GetPluginInfoRequest{}, callOpts...)
+		return err
+	}
+	// wait until the endpoint is served
+	assert.NoError(t, getPluginInfo([]tls.Certificate{clientCert}, grpc.WaitForReady(true)))
+
+	// clients without a certificate signed by the CA are rejected
+	assert.Error(t, getPluginInfo(nil))
+}
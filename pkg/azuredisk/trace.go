@@ -21,17 +21,53 @@ import (
 	"fmt"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"k8s.io/klog/v2"
 )
 
-func InitOtelTracing() (*otlptrace.Exporter, error) {
+const (
+	tracerName = "sigs.k8s.io/azuredisk-csi-driver"
+
+	diskURIAttribute  = attribute.Key("azure.disk.uri")
+	nodeNameAttribute = attribute.Key("k8s.node.name")
+)
+
+// OtelTracingOptions configures the OTLP exporter of the traces, unset options fall back to the
+// OTEL_* environment variables
+type OtelTracingOptions struct {
+	// Endpoint is the host:port of the OTLP gRPC collector
+	Endpoint string
+	// Insecure disables the client transport security of the exporter connection
+	Insecure bool
+	// SampleRatio is the ratio of the sampled root traces, the sampler of the environment is used if not in (0, 1)
+	SampleRatio float64
+}
+
+func newOtelTracingOptions(options *DriverOptions) OtelTracingOptions {
+	return OtelTracingOptions{
+		Endpoint:    options.OtelExporterEndpoint,
+		Insecure:    options.OtelExporterInsecure,
+		SampleRatio: options.OtelTraceSampleRatio,
+	}
+}
+
+func InitOtelTracing(opts OtelTracingOptions) (*otlptrace.Exporter, error) {
 	// Setup OTLP exporter
 	ctx := context.Background()
-	exporter, err := otlptracegrpc.New(ctx)
+	exporterOpts := []otlptracegrpc.Option{}
+	if opts.Endpoint != "" {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+	}
+	if opts.Insecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
 	}
@@ -50,10 +86,34 @@ func InitOtelTracing() (*otlptrace.Exporter, error) {
 
 	// Create a trace provider with the exporter.
 	// Use propagator and sampler defined in environment variables.
-	traceProvider := trace.NewTracerProvider(trace.WithBatcher(exporter), trace.WithResource(resource))
+	providerOpts := []trace.TracerProviderOption{trace.WithBatcher(exporter), trace.WithResource(resource)}
+	if opts.SampleRatio > 0 && opts.SampleRatio < 1 {
+		providerOpts = append(providerOpts, trace.WithSampler(trace.ParentBased(trace.TraceIDRatioBased(opts.SampleRatio))))
+	}
+	traceProvider := trace.NewTracerProvider(providerOpts...)
 
 	// Register the trace provider as global.
 	otel.SetTracerProvider(traceProvider)
 
 	return exporter, nil
 }
+
+// startARMSpan starts a child span of the span in ctx for an operation calling ARM, ctx is returned unchanged with
+// a no-op span if it is not part of a trace, e.g. tracing is disabled or the operation is not called by a CSI RPC
+func startARMSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, oteltrace.Span) {
+	if !oteltrace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, oteltrace.SpanFromContext(ctx)
+	}
+	return otel.Tracer(tracerName).Start(ctx, operation,
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(attrs...))
+}
+
+// endSpan ends span and records err as the status of the span
+func endSpan(span oteltrace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/mock/gomock"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// spanRecorder is a span exporter keeping the exported spans in memory
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (r *spanRecorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func TestARMSpans(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := &spanRecorder{}
+	traceProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(traceProvider)
+	defer otel.SetTracerProvider(previous)
+
+	testCloud := provider.GetTestCloud(ctrl)
+	managedDiskController := &ManagedDiskController{&controllerCommon{
+		cloud:         testCloud,
+		lockMap:       newLockMap(),
+		clientFactory: testCloud.ComputeClientFactory,
+	}}
+	diskURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/%s",
+		testCloud.SubscriptionID, testCloud.ResourceGroup, disk1Name)
+	mockDisksClient := mock_diskclient.NewMockInterface(ctrl)
+	testCloud.ComputeClientFactory.(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(testCloud.SubscriptionID).Return(mockDisksClient, nil).AnyTimes()
+	mockDisksClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, disk1Name).Return(&armcompute.Disk{Name: ptr.To(disk1Name)}, nil).Times(2)
+	mockDisksClient.EXPECT().Delete(gomock.Any(), testCloud.ResourceGroup, disk1Name).Return(nil)
+	mockDisksClient.EXPECT().Delete(gomock.Any(), testCloud.ResourceGroup, disk1Name).Return(fmt.Errorf("delete failed"))
+
+	ctx, parent := otel.Tracer(tracerName).Start(context.Background(), "DeleteVolume")
+	require.NoError(t, managedDiskController.DeleteManagedDisk(ctx, diskURI))
+	require.Error(t, managedDiskController.DeleteManagedDisk(ctx, diskURI))
+	parent.End()
+
+	require.Len(t, recorder.spans, 3)
+	for i, expectedCode := range []codes.Code{codes.Unset, codes.Error} {
+		span := recorder.spans[i]
+		assert.Equal(t, "DeleteManagedDisk", span.Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Contains(t, span.Attributes(), diskURIAttribute.String(diskURI))
+		assert.Equal(t, expectedCode, span.Status().Code)
+	}
+	assert.Equal(t, "delete failed", recorder.spans[1].Status().Description)
+}
+
+func TestNewOtelTracingOptions(t *testing.T) {
+	options := &DriverOptions{OtelExporterEndpoint: "collector:4317", OtelExporterInsecure: true, OtelTraceSampleRatio: 0.1}
+	assert.Equal(t, OtelTracingOptions{Endpoint: "collector:4317", Insecure: true, SampleRatio: 0.1}, newOtelTracingOptions(options))
+}
+
+func TestStartARMSpanWithoutTrace(t *testing.T) {
+	ctx := context.Background()
+	spanCtx, span := startARMSpan(ctx, "AttachDisk")
+	assert.Equal(t, ctx, spanCtx)
+	assert.False(t, span.SpanContext().IsValid())
+	endSpan(span, fmt.Errorf("attach failed"))
+}
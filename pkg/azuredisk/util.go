@@ -18,30 +18,58 @@ package azuredisk
 
 import "sync"
 
-// lockMap used to lock on entries
+// lockMap used to lock on entries, the waiters with priority acquire an entry before the others
 type lockMap struct {
 	sync.Mutex
-	mutexMap map[string]*sync.Mutex
+	mutexMap map[string]*lockEntry
+}
+
+// lockEntry is the lock of an entry, it's handed over to the next waiter on unlock
+type lockEntry struct {
+	held bool
+	// waiters of the entry with and without priority, in the order they started waiting
+	priorityWaiters []chan struct{}
+	waiters         []chan struct{}
 }
 
 // NewLockMap returns a new lock map
 func newLockMap() *lockMap {
 	return &lockMap{
-		mutexMap: make(map[string]*sync.Mutex),
+		mutexMap: make(map[string]*lockEntry),
 	}
 }
 
 // LockEntry acquires a lock associated with the specific entry
 func (lm *lockMap) LockEntry(entry string) {
+	lm.lockEntry(entry, false)
+}
+
+// LockEntryWithPriority acquires a lock associated with the specific entry before the waiters of LockEntry
+func (lm *lockMap) LockEntryWithPriority(entry string) {
+	lm.lockEntry(entry, true)
+}
+
+func (lm *lockMap) lockEntry(entry string, priority bool) {
 	lm.Lock()
 	// check if entry does not exists, then add entry
-	mutex, exists := lm.mutexMap[entry]
+	l, exists := lm.mutexMap[entry]
 	if !exists {
-		mutex = &sync.Mutex{}
-		lm.mutexMap[entry] = mutex
+		l = &lockEntry{}
+		lm.mutexMap[entry] = l
+	}
+	if !l.held {
+		l.held = true
+		lm.Unlock()
+		return
+	}
+	acquired := make(chan struct{})
+	if priority {
+		l.priorityWaiters = append(l.priorityWaiters, acquired)
+	} else {
+		l.waiters = append(l.waiters, acquired)
 	}
 	lm.Unlock()
-	mutex.Lock()
+	<-acquired
 }
 
 // UnlockEntry release the lock associated with the specific entry
@@ -49,9 +77,18 @@ func (lm *lockMap) UnlockEntry(entry string) {
 	lm.Lock()
 	defer lm.Unlock()
 
-	mutex, exists := lm.mutexMap[entry]
-	if !exists {
+	l, exists := lm.mutexMap[entry]
+	if !exists || !l.held {
 		return
 	}
-	mutex.Unlock()
+	switch {
+	case len(l.priorityWaiters) > 0:
+		close(l.priorityWaiters[0])
+		l.priorityWaiters = l.priorityWaiters[1:]
+	case len(l.waiters) > 0:
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	default:
+		l.held = false
+	}
 }
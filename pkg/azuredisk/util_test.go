@@ -0,0 +1,65 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockMapPriority(t *testing.T) {
+	lm := newLockMap()
+	lm.LockEntry("node")
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	lock := func(name string, priority bool) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if priority {
+				lm.LockEntryWithPriority("node")
+			} else {
+				lm.LockEntry("node")
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			lm.UnlockEntry("node")
+		}()
+		// wait for the waiter to be queued
+		time.Sleep(50 * time.Millisecond)
+	}
+	lock("normal1", false)
+	lock("normal2", false)
+	lock("critical", true)
+
+	lm.UnlockEntry("node")
+	wg.Wait()
+	assert.Equal(t, []string{"critical", "normal1", "normal2"}, order)
+
+	// the entry is free again once all the waiters released it
+	lm.LockEntry("node")
+	lm.UnlockEntry("node")
+	// unlocking an entry which is not held is a no-op
+	lm.UnlockEntry("node")
+	lm.UnlockEntry("other")
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+var (
+	volumeMetricsLabels = []string{"persistentvolume", "volume_id"}
+
+	volumeCapacityBytesDesc  = newVolumeMetricDesc("volume_capacity_bytes", "Capacity in bytes of the volume")
+	volumeAvailableBytesDesc = newVolumeMetricDesc("volume_available_bytes", "Number of available bytes in the volume")
+	volumeUsedBytesDesc      = newVolumeMetricDesc("volume_used_bytes", "Number of used bytes in the volume")
+	volumeInodesDesc         = newVolumeMetricDesc("volume_inodes", "Maximum number of inodes in the volume")
+	volumeInodesUsedDesc     = newVolumeMetricDesc("volume_inodes_used", "Number of used inodes in the volume")
+	volumeReadsDesc          = newVolumeMetricDesc("volume_reads_completed_total", "Number of reads completed on the device of the volume")
+	volumeWritesDesc         = newVolumeMetricDesc("volume_writes_completed_total", "Number of writes completed on the device of the volume")
+	volumeReadBytesDesc      = newVolumeMetricDesc("volume_read_bytes_total", "Number of bytes read from the device of the volume")
+	volumeWriteBytesDesc     = newVolumeMetricDesc("volume_written_bytes_total", "Number of bytes written to the device of the volume")
+	volumeReadTimeDesc       = newVolumeMetricDesc("volume_read_time_seconds_total", "Time spent reading from the device of the volume")
+	volumeWriteTimeDesc      = newVolumeMetricDesc("volume_write_time_seconds_total", "Time spent writing to the device of the volume")
+)
+
+func newVolumeMetricDesc(name, help string) *metrics.Desc {
+	return metrics.NewDesc(consts.AzureDiskCSIDriverName+"_"+name, help, volumeMetricsLabels, nil, metrics.ALPHA, "")
+}
+
+// diskIOStats are the cumulative IO statistics of a block device
+type diskIOStats struct {
+	readsCompleted   uint64
+	writesCompleted  uint64
+	bytesRead        uint64
+	bytesWritten     uint64
+	readTimeSeconds  float64
+	writeTimeSeconds float64
+}
+
+// stagedVolume is a volume staged on the node, stagingPath is empty for block volumes
+type stagedVolume struct {
+	pvName      string
+	stagingPath string
+	devicePath  string
+}
+
+// volumeMetricsCollector exports the usage and IO statistics of the volumes staged on the node keyed by PV name,
+// the statistics are sampled when the metrics are scraped
+type volumeMetricsCollector struct {
+	metrics.BaseStableCollector
+	d *DriverCore
+	// <volumeID, stagedVolume>
+	volumes sync.Map
+}
+
+func newVolumeMetricsCollector(d *DriverCore) *volumeMetricsCollector {
+	return &volumeMetricsCollector{d: d}
+}
+
+// trackStagedVolume adds the staged volume to the volume metrics, the PV name falls back to the disk name
+// if it's not in the volume context. It's a no-op if the volume metrics are disabled.
+func (d *DriverCore) trackStagedVolume(volumeID string, volumeContext map[string]string, stagingPath, devicePath string) {
+	if d.volumeMetrics == nil {
+		return
+	}
+	pvName := volumeContext[consts.PvNameKey]
+	if pvName == "" {
+		pvName, _ = azureutils.GetDiskName(volumeID)
+	}
+	d.volumeMetrics.volumes.Store(volumeID, stagedVolume{pvName: pvName, stagingPath: stagingPath, devicePath: devicePath})
+}
+
+// untrackStagedVolume removes the unstaged volume from the volume metrics
+func (d *DriverCore) untrackStagedVolume(volumeID string) {
+	if d.volumeMetrics == nil {
+		return
+	}
+	d.volumeMetrics.volumes.Delete(volumeID)
+}
+
+// DescribeWithStability implements metrics.StableCollector
+func (c *volumeMetricsCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	for _, desc := range []*metrics.Desc{volumeCapacityBytesDesc, volumeAvailableBytesDesc, volumeUsedBytesDesc, volumeInodesDesc,
+		volumeInodesUsedDesc, volumeReadsDesc, volumeWritesDesc, volumeReadBytesDesc, volumeWriteBytesDesc, volumeReadTimeDesc, volumeWriteTimeDesc} {
+		ch <- desc
+	}
+}
+
+// CollectWithStability implements metrics.StableCollector
+func (c *volumeMetricsCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	c.volumes.Range(func(key, value interface{}) bool {
+		volumeID := key.(string)
+		volume := value.(stagedVolume)
+		labels := []string{volume.pvName, volumeID}
+
+		if volume.stagingPath != "" {
+			usages, err := c.d.GetVolumeStats(context.Background(), c.d.mounter, volumeID, volume.stagingPath, c.d.hostUtil)
+			if err != nil {
+				klog.V(4).Infof("failed to get usage of volume %s on %s: %v", volumeID, volume.stagingPath, err)
+			}
+			for _, usage := range usages {
+				switch usage.Unit {
+				case csi.VolumeUsage_BYTES:
+					ch <- metrics.NewLazyConstMetric(volumeCapacityBytesDesc, metrics.GaugeValue, float64(usage.Total), labels...)
+					ch <- metrics.NewLazyConstMetric(volumeAvailableBytesDesc, metrics.GaugeValue, float64(usage.Available), labels...)
+					ch <- metrics.NewLazyConstMetric(volumeUsedBytesDesc, metrics.GaugeValue, float64(usage.Used), labels...)
+				case csi.VolumeUsage_INODES:
+					ch <- metrics.NewLazyConstMetric(volumeInodesDesc, metrics.GaugeValue, float64(usage.Total), labels...)
+					ch <- metrics.NewLazyConstMetric(volumeInodesUsedDesc, metrics.GaugeValue, float64(usage.Used), labels...)
+				}
+			}
+		}
+
+		if volume.devicePath != "" {
+			stats, err := getDiskIOStats(volume.devicePath)
+			if err != nil {
+				klog.V(4).Infof("failed to get IO statistics of volume %s on %s: %v", volumeID, volume.devicePath, err)
+				return true
+			}
+			ch <- metrics.NewLazyConstMetric(volumeReadsDesc, metrics.CounterValue, float64(stats.readsCompleted), labels...)
+			ch <- metrics.NewLazyConstMetric(volumeWritesDesc, metrics.CounterValue, float64(stats.writesCompleted), labels...)
+			ch <- metrics.NewLazyConstMetric(volumeReadBytesDesc, metrics.CounterValue, float64(stats.bytesRead), labels...)
+			ch <- metrics.NewLazyConstMetric(volumeWriteBytesDesc, metrics.CounterValue, float64(stats.bytesWritten), labels...)
+			ch <- metrics.NewLazyConstMetric(volumeReadTimeDesc, metrics.CounterValue, stats.readTimeSeconds, labels...)
+			ch <- metrics.NewLazyConstMetric(volumeWriteTimeDesc, metrics.CounterValue, stats.writeTimeSeconds, labels...)
+		}
+		return true
+	})
+}
+
+// registerVolumeMetrics registers the volume metrics collector, it's exposed on the metrics address of the node plugin
+func (d *DriverCore) registerVolumeMetrics() {
+	d.volumeMetrics = newVolumeMetricsCollector(d)
+	legacyregistry.CustomMustRegister(d.volumeMetrics)
+}
+
+// trackStagedMount adds the volume staged on the existing mount of stagingPath to the volume metrics,
+// the IO statistics are not exported if the device of the mount is not found
+func (d *DriverCore) trackStagedMount(volumeID string, volumeContext map[string]string, stagingPath string) {
+	if d.volumeMetrics == nil {
+		return
+	}
+	devicePath, err := getDevicePathWithMountPath(stagingPath, d.mounter)
+	if err != nil {
+		klog.V(4).Infof("failed to get device of staging path %s: %v", stagingPath, err)
+	}
+	d.trackStagedVolume(volumeID, volumeContext, stagingPath, devicePath)
+}
+
+// rebuildStagedVolumeMetrics adds the filesystem volumes staged on the node before the node plugin was restarted to
+// the volume metrics, kubelet does not stage them again. The PVs are listed every interval until it succeeds. Block
+// volumes are not staged on a mount, they are exported once they are staged again.
+func (d *Driver) rebuildStagedVolumeMetrics(ctx context.Context, interval time.Duration) {
+	_ = wait.PollUntilContextCancel(ctx, interval, true, func(ctx context.Context) (bool, error) {
+		list, err := d.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			klog.Warningf("failed to list PVs to rebuild the volume metrics: %v", err)
+			return false, nil
+		}
+		pvs := make([]*v1.PersistentVolume, 0, len(list.Items))
+		for i := range list.Items {
+			pvs = append(pvs, &list.Items[i])
+		}
+		mounts, err := d.getStagedMounts(pvs)
+		if err != nil {
+			klog.Warningf("failed to find the staged volumes of the volume metrics: %v", err)
+			return false, nil
+		}
+		for _, mount := range mounts {
+			d.trackStagedMetricsMount(mount)
+		}
+		return true, nil
+	})
+}
+
+// trackStagedMetricsMount adds the volume of a staging path found in the mount table to the volume metrics unless
+// it's being staged or unstaged, NodeStageVolume and NodeUnstageVolume update the metrics in that case
+func (d *Driver) trackStagedMetricsMount(mount stagedMount) {
+	if acquired := d.volumeLocks.TryAcquire(mount.volumeID); !acquired {
+		return
+	}
+	defer d.volumeLocks.Release(mount.volumeID)
+	if _, ok := d.volumeMetrics.volumes.Load(mount.volumeID); ok {
+		return
+	}
+	if notMnt, err := d.mounter.IsLikelyNotMountPoint(mount.stagingPath); err != nil || notMnt {
+		return
+	}
+	klog.V(2).Infof("exporting metrics of volume %s staged on %s", mount.volumeID, mount.stagingPath)
+	d.trackStagedMount(mount.volumeID, mount.volumeContext, mount.stagingPath)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics"
+	"k8s.io/mount-utils"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+)
+
+func TestVolumeMetricsCollector(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	// volume metrics are disabled
+	d.trackStagedVolume("vol", nil, t.TempDir(), "")
+	d.untrackStagedVolume("vol")
+
+	d.volumeMetrics = newVolumeMetricsCollector(&d.DriverCore)
+	// the descriptions are initialized when the collector is registered
+	metrics.NewKubeRegistry().CustomMustRegister(d.volumeMetrics)
+	fsVolumeID := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/fs-disk"
+	blockVolumeID := "/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/block-disk"
+	d.trackStagedVolume(fsVolumeID, map[string]string{consts.PvNameKey: "pv-fs"}, t.TempDir(), "")
+	d.trackStagedVolume(blockVolumeID, nil, "", "/dev/not-exist")
+
+	volume, ok := d.volumeMetrics.volumes.Load(blockVolumeID)
+	require.True(t, ok)
+	assert.Equal(t, "block-disk", volume.(stagedVolume).pvName, "PV name falls back to the disk name")
+
+	// the filesystem usage is exported, the IO statistics of a missing device are skipped
+	names := collectVolumeMetricNames(d.volumeMetrics)
+	assert.Len(t, names, 5)
+	for _, name := range []string{"volume_capacity_bytes", "volume_available_bytes", "volume_used_bytes", "volume_inodes", "volume_inodes_used"} {
+		assert.Contains(t, names, consts.AzureDiskCSIDriverName+"_"+name)
+	}
+
+	d.untrackStagedVolume(fsVolumeID)
+	d.untrackStagedVolume(blockVolumeID)
+	assert.Empty(t, collectVolumeMetricNames(d.volumeMetrics))
+}
+
+func TestRebuildStagedVolumeMetrics(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.volumeMetrics = newVolumeMetricsCollector(&d.DriverCore)
+	d.kubeClient = fake.NewSimpleClientset(
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv1"},
+			Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{
+				Driver: d.Name, VolumeHandle: testVolumeID,
+			}}},
+		},
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv2"},
+			Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{
+				Driver: d.Name, VolumeHandle: testVolumeID + "-2",
+			}}},
+		},
+	)
+
+	// the fake mounter only reports the paths with false_is_likely as mount points
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	stagingPath := fmt.Sprintf("/false_is_likely/plugins/kubernetes.io/csi/%s/%x/globalmount", d.Name, sha256.Sum256([]byte(testVolumeID)))
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{
+		{Device: "/dev/sdc", Path: stagingPath},
+		// unmounted after the mount table was listed
+		{Device: "/dev/sdd", Path: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv2/globalmount"},
+	}
+	d.setNextCommandOutputScripts(func() ([]byte, []byte, error) { return []byte("/dev/sdc\n"), []byte{}, nil })
+	d.rebuildStagedVolumeMetrics(context.Background(), time.Millisecond)
+
+	value, ok := d.volumeMetrics.volumes.Load(testVolumeID)
+	require.True(t, ok)
+	assert.Equal(t, stagedVolume{pvName: "pv1", stagingPath: stagingPath, devicePath: "/dev/sdc"}, value.(stagedVolume))
+	_, ok = d.volumeMetrics.volumes.Load(testVolumeID + "-2")
+	assert.False(t, ok)
+}
+
+func collectVolumeMetricNames(c *volumeMetricsCollector) []string {
+	ch := make(chan metrics.Metric, 100)
+	c.CollectWithStability(ch)
+	close(ch)
+	var names []string
+	for m := range ch {
+		// Desc{fqName: "<name>", ...}
+		desc := m.Desc().String()
+		desc = desc[strings.Index(desc, `"`)+1:]
+		names = append(names, desc[:strings.Index(desc, `"`)])
+	}
+	return names
+}
+
+func TestParseDiskIOStats(t *testing.T) {
+	stats, err := parseDiskIOStats("    1200        3   20480     1500      800        5   16384     2500        0     3000     4000\n")
+	require.NoError(t, err)
+	assert.Equal(t, &diskIOStats{
+		readsCompleted:   1200,
+		writesCompleted:  800,
+		bytesRead:        20480 * 512,
+		bytesWritten:     16384 * 512,
+		readTimeSeconds:  1.5,
+		writeTimeSeconds: 2.5,
+	}, stats)
+
+	_, err = parseDiskIOStats("1 2 3")
+	assert.Error(t, err)
+	_, err = parseDiskIOStats("1 2 3 4 x 6 7 8")
+	assert.Error(t, err)
+}
@@ -0,0 +1,395 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+const (
+	volumePopulatedReason      = "VolumePopulated"
+	volumePopulateFailedReason = "VolumePopulateFailed"
+
+	azDiskImportKind = "AzDiskImport"
+	// selectedNodeAnnotation is set on the PVCs of WaitForFirstConsumer StorageClasses by the scheduler
+	selectedNodeAnnotation  = "volume.kubernetes.io/selected-node"
+	provisionedByAnnotation = "pv.kubernetes.io/provisioned-by"
+	csiParameterPrefix      = "csi.storage.k8s.io/"
+	csiFsTypeParameter      = csiParameterPrefix + "fstype"
+	// the deletion secret annotations and the finalizer set on the PVs by the external provisioner, the PVs of
+	// imported disks are deleted by the external provisioner as well
+	provisionerSecretNameParameter      = csiParameterPrefix + "provisioner-secret-name"
+	provisionerSecretNamespaceParameter = csiParameterPrefix + "provisioner-secret-namespace"
+	deletionSecretNameAnnotation        = "volume.kubernetes.io/provisioner-deletion-secret-name"
+	deletionSecretNamespaceAnnotation   = "volume.kubernetes.io/provisioner-deletion-secret-namespace"
+	provisionerFinalizer                = "external-provisioner.volume.kubernetes.io/finalizer"
+)
+
+var (
+	// azDiskImportResource is the resource of the namespaced AzDiskImport custom resource, the data source of PVCs
+	// whose disks are imported from a VHD blob
+	azDiskImportResource = schema.GroupVersionResource{Group: "disk.csi.azure.com", Version: "v1alpha1", Resource: "azdiskimports"}
+
+	// volumePopulatorResync is the interval the pending PVCs are populated again, e.g. after a failed import
+	volumePopulatorResync = time.Minute
+	// volumePopulateTimeout is the timeout of importing a disk, the same PVC is populated again after the resync
+	volumePopulateTimeout = 10 * time.Minute
+)
+
+// volumeImportSource is the VHD blob a disk is imported from
+type volumeImportSource struct {
+	// SourceURI is the URI of the VHD blob, a SAS URL if the blob is not readable by the identity of the driver
+	SourceURI string `json:"sourceURI"`
+	// StorageAccountID is the resource ID of the storage account of the blob, required if it's in another subscription
+	StorageAccountID string `json:"storageAccountID,omitempty"`
+}
+
+// azDiskImport is the AzDiskImport referenced by the dataSourceRef of a PVC
+type azDiskImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec volumeImportSource `json:"spec"`
+}
+
+type volumeImportSourceKey struct{}
+
+// withVolumeImportSource returns a context making CreateVolume import the disk from source
+func withVolumeImportSource(ctx context.Context, source *volumeImportSource) context.Context {
+	return context.WithValue(ctx, volumeImportSourceKey{}, source)
+}
+
+// getVolumeImportSource returns the VHD blob the disk of a CreateVolume request is imported from, nil if it's not imported
+func getVolumeImportSource(ctx context.Context) *volumeImportSource {
+	source, _ := ctx.Value(volumeImportSourceKey{}).(*volumeImportSource)
+	return source
+}
+
+// redactSourceURI removes the query of uri, i.e. the SAS token, so that it's not logged or recorded in events
+func redactSourceURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "<invalid URI>"
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+// validateVolumeImportSource returns an error unless the VHD blob of source is readable without the identity of the
+// driver, i.e. sourceURI is a SAS URL, or its storage account is allowed by the importStorageAccounts parameter of
+// the StorageClass. Otherwise anyone creating an AzDiskImport could import the blobs readable by the driver.
+func validateVolumeImportSource(source *volumeImportSource, parameters map[string]string) error {
+	u, err := url.Parse(source.SourceURI)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("sourceURI %s is not a valid https URL of a blob", redactSourceURI(source.SourceURI))
+	}
+	accountName, _, _ := strings.Cut(u.Host, ".")
+	if source.StorageAccountID != "" && !strings.EqualFold(path.Base(source.StorageAccountID), accountName) {
+		return fmt.Errorf("storageAccountID %s is not the storage account of sourceURI %s", source.StorageAccountID, redactSourceURI(source.SourceURI))
+	}
+	// the storage account of a blob in another subscription is accessed by the identity of the driver
+	if u.Query().Get("sig") != "" && source.StorageAccountID == "" {
+		return nil
+	}
+	for k, v := range parameters {
+		if !strings.EqualFold(k, consts.ImportStorageAccountsField) {
+			continue
+		}
+		for _, allowed := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(allowed), accountName) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("sourceURI %s must be a SAS URL without storageAccountID, or storage account %s must be in the %s parameter of the StorageClass",
+		redactSourceURI(source.SourceURI), accountName, consts.ImportStorageAccountsField)
+}
+
+// isAzDiskImportClaim returns whether the data source of pvc is an AzDiskImport
+func isAzDiskImportClaim(pvc *v1.PersistentVolumeClaim) bool {
+	ref := pvc.Spec.DataSourceRef
+	return ref != nil && ptr.Deref(ref.APIGroup, "") == azDiskImportResource.Group && ref.Kind == azDiskImportKind
+}
+
+// runVolumePopulator populates the pending PVCs of the driver whose dataSourceRef is an AzDiskImport, the external
+// provisioner leaves these PVCs to a volume populator, so the disks are imported and the PVs are created here
+func (d *Driver) runVolumePopulator(ctx context.Context) {
+	factory := informers.NewSharedInformerFactory(d.kubeClient, volumePopulatorResync)
+	informer := factory.Core().V1().PersistentVolumeClaims().Informer()
+	// <PVC UID, true> of the PVCs being populated, importing a VHD could take minutes
+	var populating sync.Map
+	handle := func(obj interface{}) {
+		pvc, ok := obj.(*v1.PersistentVolumeClaim)
+		if !ok || !isAzDiskImportClaim(pvc) {
+			return
+		}
+		if _, loaded := populating.LoadOrStore(pvc.UID, true); loaded {
+			return
+		}
+		go func() {
+			defer populating.Delete(pvc.UID)
+			if err := d.populateVolume(ctx, pvc); err != nil {
+				klog.Errorf("failed to populate PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+				d.recordEvent(getPVCReference(pvc), v1.EventTypeWarning, volumePopulateFailedReason, "failed to import the disk: %v", err)
+			}
+		}()
+	}
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: handle,
+		UpdateFunc: func(_, obj interface{}) {
+			handle(obj)
+		},
+	}); err != nil {
+		klog.Errorf("failed to add PVC event handler: %v", err)
+		return
+	}
+	klog.V(2).Infof("populating PVCs of %s from %s", d.Name, azDiskImportResource.Resource)
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
+
+func getPVCReference(pvc *v1.PersistentVolumeClaim) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion:      "v1",
+		Kind:            "PersistentVolumeClaim",
+		Namespace:       pvc.Namespace,
+		Name:            pvc.Name,
+		UID:             pvc.UID,
+		ResourceVersion: pvc.ResourceVersion,
+	}
+}
+
+// populateVolume imports the disk of pvc from the VHD blob of its AzDiskImport with CreateVolume, and creates the PV
+// bound to pvc. PVCs which are bound, provisioned by other drivers or not scheduled yet with WaitForFirstConsumer
+// are skipped.
+func (d *Driver) populateVolume(ctx context.Context, pvc *v1.PersistentVolumeClaim) error {
+	if pvc.Status.Phase != v1.ClaimPending || pvc.Spec.VolumeName != "" || pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return nil
+	}
+	// the same PV name as the external provisioner, so the disk is not imported twice
+	pvName := "pvc-" + string(pvc.UID)
+	if _, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{}); err == nil {
+		return nil
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+	sc, err := d.kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get StorageClass %s: %w", *pvc.Spec.StorageClassName, err)
+	}
+	if sc.Provisioner != d.Name {
+		return nil
+	}
+	requirement, err := d.getPVCTopologyRequirement(ctx, pvc, sc)
+	if err != nil || requirement == nil && ptr.Deref(sc.VolumeBindingMode, storagev1.VolumeBindingImmediate) == storagev1.VolumeBindingWaitForFirstConsumer {
+		return err
+	}
+
+	ref := pvc.Spec.DataSourceRef
+	if ref.Namespace != nil && *ref.Namespace != "" && *ref.Namespace != pvc.Namespace {
+		return fmt.Errorf("%s %s/%s is not in the namespace of the PVC", azDiskImportKind, *ref.Namespace, ref.Name)
+	}
+	obj, err := d.dynamicClient.Resource(azDiskImportResource).Namespace(pvc.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s: %w", azDiskImportKind, ref.Name, err)
+	}
+	diskImport := azDiskImport{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &diskImport); err != nil {
+		return fmt.Errorf("failed to convert %s %s: %w", azDiskImportKind, ref.Name, err)
+	}
+	if diskImport.Spec.SourceURI == "" {
+		return fmt.Errorf("sourceURI of %s %s is empty", azDiskImportKind, ref.Name)
+	}
+	if err := validateVolumeImportSource(&diskImport.Spec, sc.Parameters); err != nil {
+		return fmt.Errorf("%s %s is not allowed: %w", azDiskImportKind, ref.Name, err)
+	}
+
+	parameters := map[string]string{}
+	for k, v := range sc.Parameters {
+		if !strings.HasPrefix(strings.ToLower(k), csiParameterPrefix) {
+			parameters[k] = v
+		}
+	}
+	parameters[consts.PvcNameKey] = pvc.Name
+	parameters[consts.PvcNamespaceKey] = pvc.Namespace
+	parameters[consts.PvNameKey] = pvName
+	fsType := ""
+	if ptr.Deref(pvc.Spec.VolumeMode, v1.PersistentVolumeFilesystem) == v1.PersistentVolumeFilesystem {
+		fsType = sc.Parameters[csiFsTypeParameter]
+	}
+	capacity := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	req := &csi.CreateVolumeRequest{
+		Name:                      pvName,
+		CapacityRange:             &csi.CapacityRange{RequiredBytes: capacity.Value()},
+		VolumeCapabilities:        getPVCVolumeCapabilities(pvc, fsType),
+		Parameters:                parameters,
+		AccessibilityRequirements: requirement,
+	}
+	klog.V(2).Infof("importing disk of PVC %s/%s from %s", pvc.Namespace, pvc.Name, redactSourceURI(diskImport.Spec.SourceURI))
+	createCtx, cancel := context.WithTimeout(ctx, volumePopulateTimeout)
+	defer cancel()
+	resp, err := d.CreateVolume(withVolumeImportSource(createCtx, &diskImport.Spec), req)
+	if err != nil {
+		return err
+	}
+
+	pv := newPopulatedPV(d.Name, pvName, pvc, sc, fsType, resp.GetVolume())
+	if _, err := d.kubeClient.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create PV %s of disk %s: %w", pvName, resp.GetVolume().GetVolumeId(), err)
+	}
+	klog.V(2).Infof("created PV %s of PVC %s/%s imported from %s", pvName, pvc.Namespace, pvc.Name, redactSourceURI(diskImport.Spec.SourceURI))
+	d.recordEvent(getPVCReference(pvc), v1.EventTypeNormal, volumePopulatedReason, "imported disk %s from %s", resp.GetVolume().GetVolumeId(), redactSourceURI(diskImport.Spec.SourceURI))
+	return nil
+}
+
+// getPVCTopologyRequirement returns the zone of the node selected by the scheduler, or the allowed topologies of sc if
+// no node is selected, nil is returned if neither is set
+func (d *Driver) getPVCTopologyRequirement(ctx context.Context, pvc *v1.PersistentVolumeClaim, sc *storagev1.StorageClass) (*csi.TopologyRequirement, error) {
+	var topologies []*csi.Topology
+	if nodeName := pvc.Annotations[selectedNodeAnnotation]; nodeName != "" {
+		node, err := d.kubeClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get selected node %s: %w", nodeName, err)
+		}
+		zone, ok := node.Labels[topologyKey]
+		if !ok {
+			zone = node.Labels[consts.WellKnownTopologyKey]
+		}
+		topologies = append(topologies, &csi.Topology{Segments: map[string]string{topologyKey: zone}})
+	} else {
+		for _, term := range sc.AllowedTopologies {
+			for _, expression := range term.MatchLabelExpressions {
+				if expression.Key != topologyKey && expression.Key != consts.WellKnownTopologyKey {
+					continue
+				}
+				for _, zone := range expression.Values {
+					topologies = append(topologies, &csi.Topology{Segments: map[string]string{topologyKey: zone}})
+				}
+			}
+		}
+	}
+	if len(topologies) == 0 {
+		return nil, nil
+	}
+	return &csi.TopologyRequirement{Requisite: topologies, Preferred: topologies}, nil
+}
+
+// getPVCVolumeCapabilities returns the volume capabilities of the access modes of pvc, the same as the external provisioner
+func getPVCVolumeCapabilities(pvc *v1.PersistentVolumeClaim, fsType string) []*csi.VolumeCapability {
+	capabilities := make([]*csi.VolumeCapability, 0, len(pvc.Spec.AccessModes))
+	for _, accessMode := range pvc.Spec.AccessModes {
+		capability := &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{}}
+		switch accessMode {
+		case v1.ReadWriteOnce:
+			capability.AccessMode.Mode = csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+		case v1.ReadWriteOncePod:
+			capability.AccessMode.Mode = csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER
+		case v1.ReadOnlyMany:
+			capability.AccessMode.Mode = csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+		case v1.ReadWriteMany:
+			capability.AccessMode.Mode = csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+		}
+		if ptr.Deref(pvc.Spec.VolumeMode, v1.PersistentVolumeFilesystem) == v1.PersistentVolumeBlock {
+			capability.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+		} else {
+			capability.AccessType = &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}}
+		}
+		capabilities = append(capabilities, capability)
+	}
+	return capabilities
+}
+
+// newPopulatedPV returns the PV of volume pre-bound to pvc, the PV is deleted by the external provisioner as a
+// dynamically provisioned PV of driverName with the same finalizer and deletion secret annotations
+func newPopulatedPV(driverName, pvName string, pvc *v1.PersistentVolumeClaim, sc *storagev1.StorageClass, fsType string, volume *csi.Volume) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pvName,
+			Annotations: map[string]string{provisionedByAnnotation: driverName},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity:    v1.ResourceList{v1.ResourceStorage: *resource.NewQuantity(volume.GetCapacityBytes(), resource.BinarySI)},
+			AccessModes: pvc.Spec.AccessModes,
+			ClaimRef: &v1.ObjectReference{
+				APIVersion: "v1",
+				Kind:       "PersistentVolumeClaim",
+				Namespace:  pvc.Namespace,
+				Name:       pvc.Name,
+				UID:        pvc.UID,
+			},
+			PersistentVolumeReclaimPolicy: ptr.Deref(sc.ReclaimPolicy, v1.PersistentVolumeReclaimDelete),
+			StorageClassName:              sc.Name,
+			MountOptions:                  sc.MountOptions,
+			VolumeMode:                    pvc.Spec.VolumeMode,
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:           driverName,
+					VolumeHandle:     volume.GetVolumeId(),
+					FSType:           fsType,
+					VolumeAttributes: volume.GetVolumeContext(),
+				},
+			},
+		},
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy == v1.PersistentVolumeReclaimDelete {
+		pv.Finalizers = []string{provisionerFinalizer}
+	}
+	if secretName, secretNamespace := sc.Parameters[provisionerSecretNameParameter], sc.Parameters[provisionerSecretNamespaceParameter]; secretName != "" && secretNamespace != "" {
+		pv.Annotations[deletionSecretNameAnnotation] = resolveSecretTemplate(secretName, pvName, pvc)
+		pv.Annotations[deletionSecretNamespaceAnnotation] = resolveSecretTemplate(secretNamespace, pvName, pvc)
+	}
+	if len(volume.GetAccessibleTopology()) > 0 {
+		terms := make([]v1.NodeSelectorTerm, 0, len(volume.GetAccessibleTopology()))
+		for _, topology := range volume.GetAccessibleTopology() {
+			term := v1.NodeSelectorTerm{}
+			for key, value := range topology.GetSegments() {
+				term.MatchExpressions = append(term.MatchExpressions, v1.NodeSelectorRequirement{Key: key, Operator: v1.NodeSelectorOpIn, Values: []string{value}})
+			}
+			terms = append(terms, term)
+		}
+		pv.Spec.NodeAffinity = &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: terms}}
+	}
+	return pv
+}
+
+// resolveSecretTemplate resolves the ${pv.name}, ${pvc.namespace} and ${pvc.name} templates of the provisioner secret
+// parameters of a StorageClass
+func resolveSecretTemplate(template, pvName string, pvc *v1.PersistentVolumeClaim) string {
+	return strings.NewReplacer("${pv.name}", pvName, "${pvc.namespace}", pvc.Namespace, "${pvc.name}", pvc.Name).Replace(template)
+}
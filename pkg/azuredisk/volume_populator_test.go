@@ -0,0 +1,246 @@
+//go:build !azurediskv2
+// +build !azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+)
+
+const testSourceURI = "https://account.blob.core.windows.net/vhds/data.vhd?sv=2022-11-02&sig=secret"
+
+func newTestImportPVC(name, storageClassName string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: ptr.To(storageClassName),
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+			DataSourceRef: &v1.TypedObjectReference{APIGroup: ptr.To("disk.csi.azure.com"), Kind: azDiskImportKind, Name: "vhd-import"},
+		},
+		Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending},
+	}
+}
+
+func newTestAzDiskImport(name, sourceURI string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "disk.csi.azure.com/v1alpha1",
+		"kind":       azDiskImportKind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec":       map[string]interface{}{"sourceURI": sourceURI},
+	}}
+}
+
+func TestIsAzDiskImportClaim(t *testing.T) {
+	pvc := newTestImportPVC("pvc", "sc")
+	assert.True(t, isAzDiskImportClaim(pvc))
+
+	pvc.Spec.DataSourceRef = &v1.TypedObjectReference{APIGroup: ptr.To("snapshot.storage.k8s.io"), Kind: "VolumeSnapshot", Name: "snapshot"}
+	assert.False(t, isAzDiskImportClaim(pvc))
+
+	pvc.Spec.DataSourceRef = nil
+	assert.False(t, isAzDiskImportClaim(pvc))
+}
+
+func TestRedactSourceURI(t *testing.T) {
+	assert.Equal(t, "https://account.blob.core.windows.net/vhds/data.vhd", redactSourceURI(testSourceURI))
+	assert.Equal(t, "<invalid URI>", redactSourceURI("://invalid"))
+}
+
+func TestValidateVolumeImportSource(t *testing.T) {
+	const plainURI = "https://account.blob.core.windows.net/vhds/data.vhd"
+	allowed := map[string]string{"importStorageAccounts": "other, Account"}
+	tests := []struct {
+		desc       string
+		source     volumeImportSource
+		parameters map[string]string
+		expectErr  bool
+	}{
+		{desc: "SAS URL", source: volumeImportSource{SourceURI: testSourceURI}},
+		{desc: "URL without SAS token", source: volumeImportSource{SourceURI: plainURI}, expectErr: true},
+		{desc: "URL of an allowed storage account", source: volumeImportSource{SourceURI: plainURI}, parameters: allowed},
+		{
+			desc:      "SAS URL with storage account ID",
+			source:    volumeImportSource{SourceURI: testSourceURI, StorageAccountID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account"},
+			expectErr: true,
+		},
+		{
+			desc:       "storage account ID of an allowed storage account",
+			source:     volumeImportSource{SourceURI: plainURI, StorageAccountID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account"},
+			parameters: allowed,
+		},
+		{
+			desc:       "storage account ID of another storage account",
+			source:     volumeImportSource{SourceURI: plainURI, StorageAccountID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/other"},
+			parameters: allowed,
+			expectErr:  true,
+		},
+		{desc: "http URL", source: volumeImportSource{SourceURI: "http://account.blob.core.windows.net/vhds/data.vhd?sig=secret"}, expectErr: true},
+	}
+	for _, test := range tests {
+		err := validateVolumeImportSource(&test.source, test.parameters)
+		assert.Equal(t, test.expectErr, err != nil, "%s: %v", test.desc, err)
+		if err != nil {
+			assert.NotContains(t, err.Error(), "secret", test.desc)
+		}
+	}
+}
+
+func TestGetPVCVolumeCapabilities(t *testing.T) {
+	pvc := newTestImportPVC("pvc", "sc")
+	pvc.Spec.AccessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany}
+	capabilities := getPVCVolumeCapabilities(pvc, "xfs")
+	require.Len(t, capabilities, 2)
+	assert.Equal(t, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, capabilities[0].GetAccessMode().GetMode())
+	assert.Equal(t, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, capabilities[1].GetAccessMode().GetMode())
+	assert.Equal(t, "xfs", capabilities[0].GetMount().GetFsType())
+
+	pvc.Spec.VolumeMode = ptr.To(v1.PersistentVolumeBlock)
+	capabilities = getPVCVolumeCapabilities(pvc, "")
+	assert.NotNil(t, capabilities[0].GetBlock())
+}
+
+func TestCreateVolumeImportSourceConflict(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	ctx := withVolumeImportSource(context.Background(), &volumeImportSource{SourceURI: testSourceURI})
+	_, err = d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "disk",
+		VolumeCapabilities: createVolumeCapabilities(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+		VolumeContentSource: &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snapshot"},
+		}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "CreateVolume: %v", err)
+}
+
+func TestPopulateVolume(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	ctx := context.Background()
+
+	d.kubeClient = fake.NewSimpleClientset(
+		&storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: "managed-csi"},
+			Provisioner: d.Name,
+			Parameters: map[string]string{"skuName": "Premium_LRS", csiFsTypeParameter: "xfs",
+				provisionerSecretNameParameter: "secret-${pvc.name}", provisionerSecretNamespaceParameter: "${pvc.namespace}"},
+			ReclaimPolicy: ptr.To(v1.PersistentVolumeReclaimRetain),
+		},
+		&storagev1.StorageClass{
+			ObjectMeta:        metav1.ObjectMeta{Name: "wait-for-consumer"},
+			Provisioner:       d.Name,
+			VolumeBindingMode: ptr.To(storagev1.VolumeBindingWaitForFirstConsumer),
+		},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "other-driver"}, Provisioner: "file.csi.azure.com"},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "no-sas"}, Provisioner: d.Name},
+	)
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{azDiskImportResource: "AzDiskImportList"},
+		newTestAzDiskImport("vhd-import", testSourceURI),
+		newTestAzDiskImport("no-sas-import", "https://account.blob.core.windows.net/vhds/data.vhd"))
+
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	diskClient.EXPECT().CreateOrUpdate(gomock.Any(), gomock.Any(), "pvc-uid-pvc", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ string, disk armcompute.Disk) (*armcompute.Disk, error) {
+			assert.Equal(t, armcompute.DiskCreateOptionImport, *disk.Properties.CreationData.CreateOption)
+			assert.Equal(t, testSourceURI, *disk.Properties.CreationData.SourceURI)
+			return &disk, nil
+		}).Times(1)
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), "pvc-uid-pvc").Return(&armcompute.Disk{
+		ID:         ptr.To("/subscriptions/subscription/resourceGroups/rg/providers/Microsoft.Compute/disks/pvc-uid-pvc"),
+		Properties: &armcompute.DiskProperties{ProvisioningState: ptr.To("Succeeded")},
+	}, nil).AnyTimes()
+
+	// PVCs of other drivers and unscheduled PVCs of WaitForFirstConsumer StorageClasses are skipped
+	for _, pvc := range []*v1.PersistentVolumeClaim{newTestImportPVC("other", "other-driver"), newTestImportPVC("unscheduled", "wait-for-consumer")} {
+		assert.NoError(t, d.populateVolume(ctx, pvc))
+		_, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pvc-"+string(pvc.UID), metav1.GetOptions{})
+		assert.Error(t, err)
+	}
+
+	pvc := newTestImportPVC("pvc", "managed-csi")
+	require.NoError(t, d.populateVolume(ctx, pvc))
+	pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pvc-uid-pvc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, d.Name, pv.Annotations[provisionedByAnnotation])
+	assert.Equal(t, pvc.UID, pv.Spec.ClaimRef.UID)
+	assert.Equal(t, v1.PersistentVolumeReclaimRetain, pv.Spec.PersistentVolumeReclaimPolicy)
+	assert.Equal(t, "xfs", pv.Spec.CSI.FSType)
+	assert.Contains(t, pv.Spec.CSI.VolumeHandle, "pvc-uid-pvc")
+	assert.Equal(t, "secret-pvc", pv.Annotations[deletionSecretNameAnnotation])
+	assert.Equal(t, pvc.Namespace, pv.Annotations[deletionSecretNamespaceAnnotation])
+	// the finalizer is only set on the PVs deleted by the external provisioner
+	assert.Empty(t, pv.Finalizers)
+
+	// the PV is not created again
+	require.NoError(t, d.populateVolume(ctx, pvc))
+
+	require.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	assert.Contains(t, event, volumePopulatedReason)
+	assert.False(t, strings.Contains(event, "secret"), "SAS token is recorded in event %q", event)
+
+	pv = newPopulatedPV(d.Name, "pv", pvc, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc"}}, "", &csi.Volume{VolumeId: "disk"})
+	assert.Equal(t, []string{provisionerFinalizer}, pv.Finalizers)
+
+	// blobs without SAS token are only imported from the storage accounts allowed by the StorageClass
+	pvc = newTestImportPVC("no-sas", "no-sas")
+	pvc.Spec.DataSourceRef.Name = "no-sas-import"
+	assert.ErrorContains(t, d.populateVolume(ctx, pvc), "importstorageaccounts")
+
+	// AzDiskImports in other namespaces are not used
+	pvc = newTestImportPVC("cross-namespace", "managed-csi")
+	pvc.Spec.DataSourceRef.Namespace = ptr.To("other")
+	assert.Error(t, d.populateVolume(ctx, pvc))
+}
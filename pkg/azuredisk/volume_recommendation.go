@@ -0,0 +1,567 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	provider "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
+)
+
+const (
+	azVolumeRecommendationKind = "AzVolumeRecommendation"
+
+	volumeRecommendationIncreasePerformance = "IncreasePerformance"
+	volumeRecommendationDowngrade           = "Downgrade"
+	volumeRecommendationExpand              = "Expand"
+
+	// volumeRecommendationLookback is the period of the disk metrics the recommendations are based on
+	volumeRecommendationLookback = 7 * 24 * time.Hour
+	// a disk is throttled if its peak IOPS or throughput reaches this ratio of its provisioned performance
+	volumeThrottledRatio = 0.9
+	// the recommended performance and size keep the peak IOPS, throughput and usage below this ratio
+	volumeTargetRatio = 0.7
+	// a premium SSD is downgraded if its peak IOPS and throughput are below this ratio of the standard SSD of its size
+	volumeDowngradeRatio = 0.5
+	// a volume is expanded if its usage reaches this ratio of its capacity
+	volumeFullRatio = 0.9
+
+	metricsAPIVersion = "2018-01-01"
+	// the IOPS and the throughput of a disk are the sums of its read and write metrics
+	diskReadOperationsMetric  = "Composite Disk Read Operations/sec"
+	diskWriteOperationsMetric = "Composite Disk Write Operations/sec"
+	diskReadBytesMetric       = "Composite Disk Read Bytes/sec"
+	diskWriteBytesMetric      = "Composite Disk Write Bytes/sec"
+)
+
+// azVolumeRecommendationResource is the resource of the namespaced AzVolumeRecommendation custom resource, which is
+// created by the driver for each PVC of the driver with the same name
+var azVolumeRecommendationResource = schema.GroupVersionResource{Group: "disk.csi.azure.com", Version: "v1alpha1", Resource: "azvolumerecommendations"}
+
+// volumeRecommendationSpec is the volume of an AzVolumeRecommendation
+type volumeRecommendationSpec struct {
+	PersistentVolumeName string `json:"persistentVolumeName"`
+	DiskURI              string `json:"diskURI"`
+}
+
+// volumeRecommendation is a change of the SKU, the size or the performance of a volume
+type volumeRecommendation struct {
+	// Type is IncreasePerformance, Downgrade or Expand
+	Type              string `json:"type"`
+	Message           string `json:"message"`
+	SkuName           string `json:"skuName,omitempty"`
+	SizeGiB           int64  `json:"sizeGiB,omitempty"`
+	DiskIOPSReadWrite int64  `json:"diskIOPSReadWrite,omitempty"`
+	DiskMBpsReadWrite int64  `json:"diskMBpsReadWrite,omitempty"`
+}
+
+// volumeRecommendationStatus is the performance and the usage of a volume and the recommendations based on them
+type volumeRecommendationStatus struct {
+	SkuName         string `json:"skuName,omitempty"`
+	SizeGiB         int64  `json:"sizeGiB,omitempty"`
+	ProvisionedIOPS int64  `json:"provisionedIOPS,omitempty"`
+	ProvisionedMBps int64  `json:"provisionedMBps,omitempty"`
+	// PeakIOPS and PeakMBps are the peaks in the last 7 days, not set if the disk has no metrics
+	PeakIOPS int64 `json:"peakIOPS,omitempty"`
+	PeakMBps int64 `json:"peakMBps,omitempty"`
+	// UsedBytes and CapacityBytes are the usage of the filesystem of the volume, not set if it's not mounted by a pod
+	UsedBytes       int64                  `json:"usedBytes,omitempty"`
+	CapacityBytes   int64                  `json:"capacityBytes,omitempty"`
+	Recommendations []volumeRecommendation `json:"recommendations,omitempty"`
+	// Summary is the messages of the recommendations, None if the volume fits its usage
+	Summary          string       `json:"summary,omitempty"`
+	Message          string       `json:"message,omitempty"`
+	LastAnalyzedTime *metav1.Time `json:"lastAnalyzedTime,omitempty"`
+}
+
+// azVolumeRecommendation is an AzVolumeRecommendation custom resource
+type azVolumeRecommendation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   volumeRecommendationSpec   `json:"spec"`
+	Status volumeRecommendationStatus `json:"status,omitempty"`
+}
+
+// volumeUsage is the usage of the filesystem of a volume
+type volumeUsage struct {
+	UsedBytes     int64
+	CapacityBytes int64
+}
+
+// diskPeakPerformance is the peak IOPS and throughput of a disk
+type diskPeakPerformance struct {
+	IOPS float64
+	MBps float64
+}
+
+// volumeRecommendationMetricsClient reads the usage and the performance metrics of the volumes
+type volumeRecommendationMetricsClient interface {
+	// GetNodeVolumeUsages returns the usage of the PVCs mounted on the node from the summary API of its kubelet
+	// <namespace/PVC name, usage>
+	GetNodeVolumeUsages(ctx context.Context, nodeName string) (map[string]volumeUsage, error)
+	// GetDiskPeakPerformance returns the peak IOPS and throughput of the disk since start from the Azure Monitor
+	// metrics of the disk, nil if the disk has no metrics
+	GetDiskPeakPerformance(ctx context.Context, diskURI string, start, end time.Time) (*diskPeakPerformance, error)
+}
+
+// azureVolumeRecommendationMetricsClient reads the kubelet summary API through the node proxy of the API server and
+// calls the Microsoft.Insights/metrics REST API, the monitor SDK is not a dependency of the driver
+type azureVolumeRecommendationMetricsClient struct {
+	kubeClient kubernetes.Interface
+	client     *arm.Client
+}
+
+func newAzureVolumeRecommendationMetricsClient(kubeClient kubernetes.Interface, cloud *provider.Cloud) (volumeRecommendationMetricsClient, error) {
+	if cloud == nil || cloud.AuthProvider == nil {
+		return nil, fmt.Errorf("azure credential is not initialized")
+	}
+	clientOption, err := azclient.GetAzCoreClientOption(&cloud.ARMClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	cred := cloud.AuthProvider.GetAzIdentity()
+	if cloud.AuthProvider.IsMultiTenantModeEnabled() {
+		cred = cloud.AuthProvider.GetMultiTenantIdentity()
+	}
+	client, err := arm.NewClient("azuredisk-csi-driver.volumerecommendation", "v1.0.0", cred, &arm.ClientOptions{ClientOptions: *clientOption})
+	if err != nil {
+		return nil, err
+	}
+	return &azureVolumeRecommendationMetricsClient{kubeClient: kubeClient, client: client}, nil
+}
+
+// kubeletStatsSummary is the part of the response of the kubelet summary API read by the driver
+type kubeletStatsSummary struct {
+	Pods []struct {
+		Volumes []struct {
+			CapacityBytes *int64 `json:"capacityBytes"`
+			UsedBytes     *int64 `json:"usedBytes"`
+			PVCRef        *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+func (c *azureVolumeRecommendationMetricsClient) GetNodeVolumeUsages(ctx context.Context, nodeName string) (map[string]volumeUsage, error) {
+	data, err := c.kubeClient.CoreV1().RESTClient().Get().Resource("nodes").Name(nodeName).SubResource("proxy").Suffix("stats/summary").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary := &kubeletStatsSummary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		return nil, fmt.Errorf("failed to parse the summary of node %s: %w", nodeName, err)
+	}
+	usages := map[string]volumeUsage{}
+	for _, pod := range summary.Pods {
+		for _, volume := range pod.Volumes {
+			if volume.PVCRef == nil || volume.CapacityBytes == nil || volume.UsedBytes == nil {
+				continue
+			}
+			usages[volume.PVCRef.Namespace+"/"+volume.PVCRef.Name] = volumeUsage{UsedBytes: *volume.UsedBytes, CapacityBytes: *volume.CapacityBytes}
+		}
+	}
+	return usages, nil
+}
+
+// metricsResponse is the part of the response of the Microsoft.Insights/metrics REST API read by the driver
+type metricsResponse struct {
+	Value []struct {
+		Name struct {
+			Value string `json:"value"`
+		} `json:"name"`
+		Timeseries []struct {
+			Data []struct {
+				TimeStamp string   `json:"timeStamp"`
+				Maximum   *float64 `json:"maximum"`
+			} `json:"data"`
+		} `json:"timeseries"`
+	} `json:"value"`
+}
+
+func (c *azureVolumeRecommendationMetricsClient) GetDiskPeakPerformance(ctx context.Context, diskURI string, start, end time.Time) (*diskPeakPerformance, error) {
+	req, err := azruntime.NewRequest(ctx, http.MethodGet, azruntime.JoinPaths(c.client.Endpoint(), diskURI, "providers/Microsoft.Insights/metrics"))
+	if err != nil {
+		return nil, err
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", metricsAPIVersion)
+	query.Set("metricnames", strings.Join([]string{diskReadOperationsMetric, diskWriteOperationsMetric, diskReadBytesMetric, diskWriteBytesMetric}, ","))
+	query.Set("timespan", start.UTC().Format(time.RFC3339)+"/"+end.UTC().Format(time.RFC3339))
+	query.Set("interval", "PT1H")
+	query.Set("aggregation", "Maximum")
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !azruntime.HasStatusCode(resp, http.StatusOK) {
+		return nil, azruntime.NewResponseError(resp)
+	}
+	metrics := &metricsResponse{}
+	if err := azruntime.UnmarshalAsJSON(resp, metrics); err != nil {
+		return nil, err
+	}
+	return getDiskPeakPerformance(metrics), nil
+}
+
+// getDiskPeakPerformance returns the peak of the sums of the read and write metrics of the same time, the maximums
+// of the reads and the writes in an interval may be at different times, so the peak is an upper bound
+func getDiskPeakPerformance(metrics *metricsResponse) *diskPeakPerformance {
+	// <time stamp, sum>
+	operations, bytes := map[string]float64{}, map[string]float64{}
+	for _, metric := range metrics.Value {
+		sums := operations
+		if metric.Name.Value == diskReadBytesMetric || metric.Name.Value == diskWriteBytesMetric {
+			sums = bytes
+		}
+		for _, timeseries := range metric.Timeseries {
+			for _, data := range timeseries.Data {
+				if data.Maximum != nil {
+					sums[data.TimeStamp] += *data.Maximum
+				}
+			}
+		}
+	}
+	if len(operations) == 0 && len(bytes) == 0 {
+		return nil
+	}
+	peak := &diskPeakPerformance{}
+	for _, iops := range operations {
+		peak.IOPS = math.Max(peak.IOPS, iops)
+	}
+	for _, bps := range bytes {
+		peak.MBps = math.Max(peak.MBps, bps/(1024*1024))
+	}
+	return peak
+}
+
+// runVolumeRecommender analyzes the performance and the usage of the volumes of the driver and updates their
+// AzVolumeRecommendations every interval
+func (d *Driver) runVolumeRecommender(ctx context.Context, interval time.Duration) {
+	if d.volumeRecommendationMetricsClient == nil {
+		client, err := newAzureVolumeRecommendationMetricsClient(d.kubeClient, d.getCloud())
+		if err != nil {
+			klog.Errorf("volume recommendation is disabled since metrics client is not available: %v", err)
+			return
+		}
+		d.volumeRecommendationMetricsClient = client
+	}
+	klog.V(2).Infof("analyzing volumes for %s every %v", azVolumeRecommendationResource.Resource, interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := d.recommendVolumes(ctx); err != nil {
+			klog.Errorf("failed to analyze volumes: %v", err)
+		}
+	}, interval)
+}
+
+// recommendVolumes creates or updates the AzVolumeRecommendation of each bound PVC of the driver, the
+// AzVolumeRecommendation is owned by the PVC and deleted with it
+func (d *Driver) recommendVolumes(ctx context.Context) error {
+	pvs, err := d.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list PVs: %w", err)
+	}
+	list, err := d.dynamicClient.Resource(azVolumeRecommendationResource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", azVolumeRecommendationResource.Resource, err)
+	}
+	// <namespace/name, AzVolumeRecommendation>
+	recommendations := map[string]*unstructured.Unstructured{}
+	for i := range list.Items {
+		recommendations[list.Items[i].GetNamespace()+"/"+list.Items[i].GetName()] = &list.Items[i]
+	}
+	usages := d.getVolumeUsages(ctx)
+	now := time.Now()
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.Status.Phase != v1.VolumeBound || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		key := pv.Spec.ClaimRef.Namespace + "/" + pv.Spec.ClaimRef.Name
+		status := volumeRecommendationStatus{LastAnalyzedTime: ptr.To(metav1.NewTime(now))}
+		if err := d.analyzeVolume(ctx, pv, usages, now, &status); err != nil {
+			klog.Errorf("failed to analyze volume %s of PVC %s: %v", pv.Name, key, err)
+			status.Message = err.Error()
+		}
+		if err := d.updateVolumeRecommendation(ctx, pv, recommendations[key], status); err != nil {
+			klog.Errorf("failed to update %s %s: %v", azVolumeRecommendationKind, key, err)
+		}
+	}
+	return nil
+}
+
+// getVolumeUsages returns the usage of the PVCs mounted on the nodes <namespace/PVC name, usage>, the nodes whose
+// summary could not be read are skipped
+func (d *Driver) getVolumeUsages(ctx context.Context) map[string]volumeUsage {
+	usages := map[string]volumeUsage{}
+	nodes, err := d.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("failed to list nodes, the usage of the volumes is not analyzed: %v", err)
+		return usages
+	}
+	for _, node := range nodes.Items {
+		nodeUsages, err := d.volumeRecommendationMetricsClient.GetNodeVolumeUsages(ctx, node.Name)
+		if err != nil {
+			klog.Warningf("failed to get the usage of the volumes on node %s: %v", node.Name, err)
+			continue
+		}
+		for key, usage := range nodeUsages {
+			usages[key] = usage
+		}
+	}
+	return usages
+}
+
+// analyzeVolume sets the performance and the usage of the disk of pv and the recommendations based on them in status
+func (d *Driver) analyzeVolume(ctx context.Context, pv *v1.PersistentVolume, usages map[string]volumeUsage, now time.Time, status *volumeRecommendationStatus) error {
+	diskURI := pv.Spec.CSI.VolumeHandle
+	diskName, err := azureutils.GetDiskName(diskURI)
+	if err != nil {
+		return err
+	}
+	resourceGroup, subsID, err := getInfoFromDiskURI(diskURI)
+	if err != nil {
+		return err
+	}
+	diskClient, err := d.getClientFactory().GetDiskClientForSub(subsID)
+	if err != nil {
+		return err
+	}
+	disk, err := diskClient.Get(ctx, resourceGroup, diskName)
+	if err != nil {
+		return fmt.Errorf("failed to get disk %s: %w", diskURI, err)
+	}
+	if disk.SKU == nil || disk.SKU.Name == nil || disk.Properties == nil || disk.Properties.DiskSizeGB == nil {
+		return fmt.Errorf("SKU or size of disk %s is not set", diskURI)
+	}
+	status.SkuName = string(*disk.SKU.Name)
+	status.SizeGiB = int64(*disk.Properties.DiskSizeGB)
+	iops, mbps, err := getProvisionedDiskPerformance(disk)
+	if err != nil {
+		return err
+	}
+	status.ProvisionedIOPS, status.ProvisionedMBps = int64(iops), int64(mbps)
+
+	var usage *volumeUsage
+	if u, ok := usages[pv.Spec.ClaimRef.Namespace+"/"+pv.Spec.ClaimRef.Name]; ok {
+		usage = &u
+		status.UsedBytes, status.CapacityBytes = u.UsedBytes, u.CapacityBytes
+	}
+	peak, err := d.volumeRecommendationMetricsClient.GetDiskPeakPerformance(ctx, diskURI, now.Add(-volumeRecommendationLookback), now)
+	if err != nil {
+		return fmt.Errorf("failed to get metrics of disk %s: %w", diskURI, err)
+	}
+	if peak != nil {
+		status.PeakIOPS, status.PeakMBps = int64(math.Ceil(peak.IOPS)), int64(math.Ceil(peak.MBps))
+	}
+	status.Recommendations = getVolumeRecommendations(status.SkuName, status.SizeGiB, iops, mbps, peak, usage)
+	messages := make([]string, 0, len(status.Recommendations))
+	for _, recommendation := range status.Recommendations {
+		messages = append(messages, recommendation.Message)
+	}
+	status.Summary = "None"
+	if len(messages) > 0 {
+		status.Summary = strings.Join(messages, "; ")
+	}
+	return nil
+}
+
+// getProvisionedDiskPerformance returns the provisioned IOPS and throughput of the disk, the limits of the performance
+// tier of the disk are returned if it's set
+func getProvisionedDiskPerformance(disk *armcompute.Disk) (int, int, error) {
+	skuName := string(*disk.SKU.Name)
+	if tier := ptr.Deref(disk.Properties.Tier, ""); tier != "" {
+		if sku, ok := optimization.GetDiskSkuInfoMap()[strings.ToLower(skuName)][strings.ToLower(tier)]; ok {
+			return sku.MaxIops, sku.MaxBwMbps, nil
+		}
+	}
+	return optimization.GetDiskPerformance(skuName, int(*disk.Properties.DiskSizeGB),
+		int(ptr.Deref(disk.Properties.DiskIOPSReadWrite, 0)), int(ptr.Deref(disk.Properties.DiskMBpsReadWrite, 0)))
+}
+
+// getVolumeRecommendations returns the recommendations of a disk of skuName and sizeGiB with the provisioned iops
+// and mbps, according to its peak performance and the usage of its filesystem, which are nil if not known:
+//   - Expand if the usage reaches 90% of the capacity, to the size at which it's 70%
+//   - IncreasePerformance if the peak IOPS or throughput reaches 90% of the provisioned ones, to the smallest tier of
+//     the SKU at which they are 70%, or to the IOPS and throughput at which they are 70% for UltraSSD_LRS and
+//     PremiumV2_LRS, PremiumV2_LRS is recommended if no tier of the SKU is large enough
+//   - Downgrade a premium SSD to a standard SSD if the peak IOPS and throughput are below 50% of the ones of the
+//     standard SSD of the same size
+func getVolumeRecommendations(skuName string, sizeGiB int64, iops, mbps int, peak *diskPeakPerformance, usage *volumeUsage) []volumeRecommendation {
+	var recommendations []volumeRecommendation
+	targetSizeGiB := sizeGiB
+	if usage != nil && usage.CapacityBytes > 0 && float64(usage.UsedBytes) >= volumeFullRatio*float64(usage.CapacityBytes) {
+		// the capacity of the filesystem is smaller than the disk, the size is scaled by the usage ratio of the filesystem
+		targetSizeGiB = int64(math.Ceil(float64(sizeGiB) * float64(usage.UsedBytes) / float64(usage.CapacityBytes) / volumeTargetRatio))
+		recommendations = append(recommendations, volumeRecommendation{
+			Type:    volumeRecommendationExpand,
+			SizeGiB: targetSizeGiB,
+			Message: fmt.Sprintf("expand to %dGiB, %d%% of the capacity is used", targetSizeGiB, usage.UsedBytes*100/usage.CapacityBytes),
+		})
+	}
+	if peak == nil {
+		return recommendations
+	}
+
+	targetIOPS := int64(math.Ceil(peak.IOPS / volumeTargetRatio))
+	targetMBps := int64(math.Ceil(peak.MBps / volumeTargetRatio))
+	if peak.IOPS >= volumeThrottledRatio*float64(iops) || peak.MBps >= volumeThrottledRatio*float64(mbps) {
+		targetIOPS, targetMBps = max(targetIOPS, int64(iops)), max(targetMBps, int64(mbps))
+		if strings.EqualFold(skuName, string(armcompute.DiskStorageAccountTypesUltraSSDLRS)) || strings.EqualFold(skuName, string(armcompute.DiskStorageAccountTypesPremiumV2LRS)) {
+			return append(recommendations, volumeRecommendation{
+				Type:              volumeRecommendationIncreasePerformance,
+				DiskIOPSReadWrite: targetIOPS,
+				DiskMBpsReadWrite: targetMBps,
+				Message:           fmt.Sprintf("increase diskIOPSReadWrite to %d and diskMBpsReadWrite to %d", targetIOPS, targetMBps),
+			})
+		}
+		if tier := getSmallestDiskTier(skuName, targetSizeGiB, targetIOPS, targetMBps); tier != nil {
+			return append(recommendations, volumeRecommendation{
+				Type:    volumeRecommendationIncreasePerformance,
+				SkuName: skuName,
+				SizeGiB: int64(tier.MaxSizeGiB),
+				Message: fmt.Sprintf("increase to %s (%dGiB)", tier.DiskSize, tier.MaxSizeGiB),
+			})
+		}
+		return append(recommendations, volumeRecommendation{
+			Type:              volumeRecommendationIncreasePerformance,
+			SkuName:           string(armcompute.DiskStorageAccountTypesPremiumV2LRS),
+			DiskIOPSReadWrite: targetIOPS,
+			DiskMBpsReadWrite: targetMBps,
+			Message: fmt.Sprintf("move to %s with diskIOPSReadWrite %d and diskMBpsReadWrite %d",
+				armcompute.DiskStorageAccountTypesPremiumV2LRS, targetIOPS, targetMBps),
+		})
+	}
+
+	if redundancy, ok := strings.CutPrefix(strings.ToLower(skuName), "premium_"); ok {
+		standardSkuName := "StandardSSD_" + strings.ToUpper(redundancy)
+		standardIOPS, standardMBps, err := optimization.GetDiskPerformance(standardSkuName, int(targetSizeGiB), 0, 0)
+		if err == nil && peak.IOPS < volumeDowngradeRatio*float64(standardIOPS) && peak.MBps < volumeDowngradeRatio*float64(standardMBps) {
+			recommendations = append(recommendations, volumeRecommendation{
+				Type:    volumeRecommendationDowngrade,
+				SkuName: standardSkuName,
+				Message: fmt.Sprintf("downgrade to %s, the peak of %d IOPS and %dMB/s fits its %d IOPS and %dMB/s",
+					standardSkuName, int64(math.Ceil(peak.IOPS)), int64(math.Ceil(peak.MBps)), standardIOPS, standardMBps),
+			})
+		}
+	}
+	return recommendations
+}
+
+// getSmallestDiskTier returns the smallest tier of skuName of at least sizeGiB with at least iops and mbps, nil if
+// there is none
+func getSmallestDiskTier(skuName string, sizeGiB, iops, mbps int64) *optimization.DiskSkuInfo {
+	var smallest *optimization.DiskSkuInfo
+	for _, sku := range optimization.GetDiskSkuInfoMap()[strings.ToLower(skuName)] {
+		if int64(sku.MaxSizeGiB) < sizeGiB || int64(sku.MaxIops) < iops || int64(sku.MaxBwMbps) < mbps {
+			continue
+		}
+		if smallest == nil || sku.MaxSizeGiB < smallest.MaxSizeGiB {
+			tier := sku
+			smallest = &tier
+		}
+	}
+	return smallest
+}
+
+// updateVolumeRecommendation creates the AzVolumeRecommendation of the PVC of pv if obj is nil, and updates its spec
+// and status
+func (d *Driver) updateVolumeRecommendation(ctx context.Context, pv *v1.PersistentVolume, obj *unstructured.Unstructured, status volumeRecommendationStatus) error {
+	client := d.dynamicClient.Resource(azVolumeRecommendationResource).Namespace(pv.Spec.ClaimRef.Namespace)
+	spec := volumeRecommendationSpec{PersistentVolumeName: pv.Name, DiskURI: pv.Spec.CSI.VolumeHandle}
+	ownerReferences := []metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Name:       pv.Spec.ClaimRef.Name,
+		UID:        pv.Spec.ClaimRef.UID,
+	}}
+	var err error
+	if obj == nil {
+		recommendation := &azVolumeRecommendation{
+			TypeMeta: metav1.TypeMeta{APIVersion: azVolumeRecommendationResource.GroupVersion().String(), Kind: azVolumeRecommendationKind},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            pv.Spec.ClaimRef.Name,
+				Namespace:       pv.Spec.ClaimRef.Namespace,
+				OwnerReferences: ownerReferences,
+			},
+			Spec: spec,
+		}
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(recommendation)
+		if err != nil {
+			return err
+		}
+		delete(content, "status")
+		if obj, err = client.Create(ctx, &unstructured.Unstructured{Object: content}, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	} else {
+		current := volumeRecommendationSpec{}
+		if content, ok, _ := unstructured.NestedMap(obj.Object, "spec"); ok {
+			_ = runtime.DefaultUnstructuredConverter.FromUnstructured(content, &current)
+		}
+		// the PVC is recreated with the same name before the AzVolumeRecommendation of the previous one is collected
+		if !reflect.DeepEqual(current, spec) || !reflect.DeepEqual(obj.GetOwnerReferences(), ownerReferences) {
+			obj.SetOwnerReferences(ownerReferences)
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&spec)
+			if err != nil {
+				return err
+			}
+			if err := unstructured.SetNestedMap(obj.Object, content, "spec"); err != nil {
+				return err
+			}
+			if obj, err = client.Update(ctx, obj, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedMap(obj.Object, content, "status"); err != nil {
+		return err
+	}
+	_, err = client.UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
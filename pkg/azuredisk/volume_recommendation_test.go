@@ -0,0 +1,285 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+)
+
+type fakeVolumeRecommendationMetricsClient struct {
+	usages map[string]map[string]volumeUsage
+	peaks  map[string]*diskPeakPerformance
+}
+
+func (c *fakeVolumeRecommendationMetricsClient) GetNodeVolumeUsages(_ context.Context, nodeName string) (map[string]volumeUsage, error) {
+	usages, ok := c.usages[nodeName]
+	if !ok {
+		return nil, fmt.Errorf("node %s is not ready", nodeName)
+	}
+	return usages, nil
+}
+
+func (c *fakeVolumeRecommendationMetricsClient) GetDiskPeakPerformance(_ context.Context, diskURI string, _, _ time.Time) (*diskPeakPerformance, error) {
+	return c.peaks[diskURI], nil
+}
+
+func TestAzureVolumeRecommendationMetricsClient(t *testing.T) {
+	diskURI := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk1"
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/nodes/node1/proxy/stats/summary":
+			_, _ = w.Write([]byte(`{"pods":[{"volume":[
+				{"name":"data","capacityBytes":1000,"usedBytes":950,"pvcRef":{"name":"pvc1","namespace":"default"}},
+				{"name":"tmp","capacityBytes":100,"usedBytes":10}]}]}`))
+		case diskURI + "/providers/Microsoft.Insights/metrics":
+			assert.Equal(t, metricsAPIVersion, r.URL.Query().Get("api-version"))
+			assert.Equal(t, "Maximum", r.URL.Query().Get("aggregation"))
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte(`{"value":[
+				{"name":{"value":"Composite Disk Read Operations/sec"},"timeseries":[{"data":[{"timeStamp":"t1","maximum":100},{"timeStamp":"t2","maximum":300}]}]},
+				{"name":{"value":"Composite Disk Write Operations/sec"},"timeseries":[{"data":[{"timeStamp":"t1","maximum":250},{"timeStamp":"t2","maximum":20}]}]},
+				{"name":{"value":"Composite Disk Read Bytes/sec"},"timeseries":[{"data":[{"timeStamp":"t1","maximum":1048576},{"timeStamp":"t2"}]}]},
+				{"name":{"value":"Composite Disk Write Bytes/sec"},"timeseries":[{"data":[{"timeStamp":"t1","maximum":2097152}]}]}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL, TLSClientConfig: rest.TLSClientConfig{Insecure: true}})
+	require.NoError(t, err)
+	client, err := arm.NewClient("test", "v1.0.0", fakeTokenCredential{}, &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+		Cloud: cloud.Configuration{Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {Endpoint: server.URL, Audience: "https://management.azure.com"},
+		}},
+		Transport: server.Client(),
+		Retry:     policy.RetryOptions{MaxRetries: -1},
+	}})
+	require.NoError(t, err)
+	c := &azureVolumeRecommendationMetricsClient{kubeClient: kubeClient, client: client}
+	ctx := context.Background()
+
+	usages, err := c.GetNodeVolumeUsages(ctx, "node1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]volumeUsage{"default/pvc1": {UsedBytes: 950, CapacityBytes: 1000}}, usages)
+	_, err = c.GetNodeVolumeUsages(ctx, "node2")
+	assert.Error(t, err)
+
+	// the read and the write metrics of the same time are summed
+	peak, err := c.GetDiskPeakPerformance(ctx, diskURI, time.Now().Add(-time.Hour), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, &diskPeakPerformance{IOPS: 350, MBps: 3}, peak)
+
+	assert.Nil(t, getDiskPeakPerformance(&metricsResponse{}))
+}
+
+func TestGetVolumeRecommendations(t *testing.T) {
+	tests := []struct {
+		desc     string
+		skuName  string
+		sizeGiB  int64
+		iops     int
+		mbps     int
+		peak     *diskPeakPerformance
+		usage    *volumeUsage
+		expected []volumeRecommendation
+	}{
+		{
+			desc:    "no metrics",
+			skuName: "Premium_LRS",
+			sizeGiB: 1024,
+			iops:    5000,
+			mbps:    200,
+		},
+		{
+			desc:    "fits its usage",
+			skuName: "Premium_LRS",
+			sizeGiB: 1024,
+			iops:    5000,
+			mbps:    200,
+			peak:    &diskPeakPerformance{IOPS: 2000, MBps: 100},
+			usage:   &volumeUsage{UsedBytes: 50, CapacityBytes: 100},
+		},
+		{
+			desc:    "throttled premium SSD",
+			skuName: "Premium_LRS",
+			sizeGiB: 1024,
+			iops:    5000,
+			mbps:    200,
+			peak:    &diskPeakPerformance{IOPS: 4800, MBps: 100},
+			expected: []volumeRecommendation{
+				{Type: volumeRecommendationIncreasePerformance, SkuName: "Premium_LRS", SizeGiB: 2048, Message: "increase to P40 (2048GiB)"},
+			},
+		},
+		{
+			desc:    "throttled premium SSD beyond the largest tier",
+			skuName: "Premium_LRS",
+			sizeGiB: 1024,
+			iops:    5000,
+			mbps:    200,
+			peak:    &diskPeakPerformance{IOPS: 4800, MBps: 800},
+			expected: []volumeRecommendation{
+				{Type: volumeRecommendationIncreasePerformance, SkuName: "PremiumV2_LRS", DiskIOPSReadWrite: 6858, DiskMBpsReadWrite: 1143,
+					Message: "move to PremiumV2_LRS with diskIOPSReadWrite 6858 and diskMBpsReadWrite 1143"},
+			},
+		},
+		{
+			desc:    "throttled PremiumV2_LRS",
+			skuName: "PremiumV2_LRS",
+			sizeGiB: 100,
+			iops:    3000,
+			mbps:    125,
+			peak:    &diskPeakPerformance{IOPS: 1000, MBps: 125},
+			expected: []volumeRecommendation{
+				{Type: volumeRecommendationIncreasePerformance, DiskIOPSReadWrite: 3000, DiskMBpsReadWrite: 179,
+					Message: "increase diskIOPSReadWrite to 3000 and diskMBpsReadWrite to 179"},
+			},
+		},
+		{
+			desc:    "idle premium SSD",
+			skuName: "Premium_ZRS",
+			sizeGiB: 128,
+			iops:    500,
+			mbps:    100,
+			peak:    &diskPeakPerformance{IOPS: 100, MBps: 10},
+			expected: []volumeRecommendation{
+				{Type: volumeRecommendationDowngrade, SkuName: "StandardSSD_ZRS", Message: "downgrade to StandardSSD_ZRS, the peak of 100 IOPS and 10MB/s fits its 500 IOPS and 60MB/s"},
+			},
+		},
+		{
+			desc:    "full volume",
+			skuName: "StandardSSD_LRS",
+			sizeGiB: 100,
+			iops:    500,
+			mbps:    60,
+			peak:    &diskPeakPerformance{IOPS: 10, MBps: 1},
+			usage:   &volumeUsage{UsedBytes: 95, CapacityBytes: 100},
+			expected: []volumeRecommendation{
+				{Type: volumeRecommendationExpand, SizeGiB: 136, Message: "expand to 136GiB, 95% of the capacity is used"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expected, getVolumeRecommendations(test.skuName, test.sizeGiB, test.iops, test.mbps, test.peak, test.usage))
+		})
+	}
+}
+
+func TestRecommendVolumes(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	diskURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/disk1", d.getCloud().SubscriptionID, d.getCloud().ResourceGroup)
+	newPV := func(name, driver, claimName string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: diskURI}},
+				ClaimRef:               &v1.ObjectReference{Namespace: "default", Name: claimName, UID: types.UID("uid-" + claimName)},
+			},
+			Status: v1.PersistentVolumeStatus{Phase: v1.VolumeBound},
+		}
+	}
+	d.kubeClient = fake.NewSimpleClientset(
+		newPV("pv1", d.Name, "pvc1"),
+		newPV("pv2", "other.csi.azure.com", "pvc2"),
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+	)
+	d.dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{azVolumeRecommendationResource: "AzVolumeRecommendationList"})
+	d.volumeRecommendationMetricsClient = &fakeVolumeRecommendationMetricsClient{
+		usages: map[string]map[string]volumeUsage{"node1": {"default/pvc1": {UsedBytes: 95, CapacityBytes: 100}}},
+		peaks:  map[string]*diskPeakPerformance{diskURI: {IOPS: 4800, MBps: 100}},
+	}
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	disk := &armcompute.Disk{
+		SKU:        &armcompute.DiskSKU{Name: ptr.To(armcompute.DiskStorageAccountTypesPremiumLRS)},
+		Properties: &armcompute.DiskProperties{DiskSizeGB: ptr.To(int32(1024))},
+	}
+	diskClient.EXPECT().Get(gomock.Any(), d.getCloud().ResourceGroup, "disk1").Return(disk, nil).Times(1)
+
+	// the AzVolumeRecommendation of the PVC is created and owned by the PVC
+	require.NoError(t, d.recommendVolumes(ctx))
+	list, err := d.dynamicClient.Resource(azVolumeRecommendationResource).Namespace("default").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	recommendation := &azVolumeRecommendation{}
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[0].Object, recommendation))
+	assert.Equal(t, "pvc1", recommendation.Name)
+	require.Len(t, recommendation.OwnerReferences, 1)
+	assert.Equal(t, "uid-pvc1", string(recommendation.OwnerReferences[0].UID))
+	assert.Equal(t, volumeRecommendationSpec{PersistentVolumeName: "pv1", DiskURI: diskURI}, recommendation.Spec)
+	assert.Equal(t, "Premium_LRS", recommendation.Status.SkuName)
+	assert.Equal(t, int64(5000), recommendation.Status.ProvisionedIOPS)
+	assert.Equal(t, int64(4800), recommendation.Status.PeakIOPS)
+	assert.Equal(t, int64(95), recommendation.Status.UsedBytes)
+	assert.Equal(t, "expand to 1390GiB, 95% of the capacity is used; increase to P40 (2048GiB)", recommendation.Status.Summary)
+	assert.Empty(t, recommendation.Status.Message)
+
+	// the status is updated with the error of the analysis
+	diskClient.EXPECT().Get(gomock.Any(), d.getCloud().ResourceGroup, "disk1").Return(nil, fmt.Errorf("throttled")).Times(1)
+	require.NoError(t, d.recommendVolumes(ctx))
+	obj, err := d.dynamicClient.Resource(azVolumeRecommendationResource).Namespace("default").Get(ctx, "pvc1", metav1.GetOptions{})
+	require.NoError(t, err)
+	message, _, _ := unstructured.NestedString(obj.Object, "status", "message")
+	assert.Contains(t, message, "throttled")
+
+	// the spec and the owner are updated if the PVC is recreated with the same name
+	_, err = d.kubeClient.CoreV1().PersistentVolumes().Update(ctx, func() *v1.PersistentVolume {
+		pv := newPV("pv1", d.Name, "pvc1")
+		pv.Spec.ClaimRef.UID = types.UID("uid-new")
+		return pv
+	}(), metav1.UpdateOptions{})
+	require.NoError(t, err)
+	diskClient.EXPECT().Get(gomock.Any(), d.getCloud().ResourceGroup, "disk1").Return(disk, nil).Times(1)
+	require.NoError(t, d.recommendVolumes(ctx))
+	obj, err = d.dynamicClient.Resource(azVolumeRecommendationResource).Namespace("default").Get(ctx, "pvc1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "uid-new", string(obj.GetOwnerReferences()[0].UID))
+}
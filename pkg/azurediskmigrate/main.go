@@ -0,0 +1,92 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/diskmigration"
+)
+
+var (
+	kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file, the in-cluster config is used if empty")
+	driverName = flag.String("drivername", consts.DefaultDriverName, "name of the driver of the CSI PVs")
+	pvNames    = flag.String("pvs", "", "comma separated names of the in-tree azure disk PVs to migrate, all in-tree azure disk PVs are migrated if empty")
+	dryRun     = flag.Bool("dry-run", true, "only report the PVs which would be migrated without changing them")
+	reportFile = flag.String("report", "", "path of the JSON report including the generated CSI PVs, not written if empty")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		klog.Fatalf("failed to get kubeconfig: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("failed to create kubernetes client: %v", err)
+	}
+
+	var names []string
+	for _, name := range strings.Split(*pvNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	results, err := diskmigration.NewMigrator(*driverName, kubeClient, *dryRun).Run(ctx, names)
+	if err != nil {
+		klog.Fatalf("migration failed: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PV\tCLAIM\tSTATUS\tMESSAGE")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.PersistentVolume, result.Claim, result.Status, result.Message)
+	}
+	w.Flush()
+	fmt.Printf("\n%v\n", diskmigration.Summary(results))
+
+	if *reportFile != "" {
+		report, err := diskmigration.MarshalReport(results)
+		if err != nil {
+			klog.Fatalf("failed to marshal report: %v", err)
+		}
+		if err := os.WriteFile(*reportFile, report, 0600); err != nil {
+			klog.Fatalf("failed to write report to %s: %v", *reportFile, err)
+		}
+	}
+	if diskmigration.Summary(results)[diskmigration.StatusFailed] > 0 {
+		os.Exit(1)
+	}
+}
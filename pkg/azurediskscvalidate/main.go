@@ -0,0 +1,110 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/scvalidation"
+)
+
+var (
+	manifestDir    = flag.String("dir", "", "directory of the StorageClass and VolumeSnapshotClass manifests to validate, required")
+	driverName     = flag.String("drivername", consts.DefaultDriverName, "name of the driver of the classes to validate, classes of other drivers are skipped")
+	kubeconfig     = flag.String("kubeconfig", "", "absolute path to the kubeconfig file of the target cluster, the cluster checks are skipped if empty")
+	subscriptionID = flag.String("subscription-id", "", "target subscription of the disks, the subscription checks are skipped if empty, the credential is read from the environment, workload identity, managed identity or the Azure CLI")
+	location       = flag.String("location", "", "location of the disks of the StorageClasses without the location parameter")
+	output         = flag.String("output", "table", "output format: table or json")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	if *manifestDir == "" {
+		klog.Fatalf("--dir is required")
+	}
+	if *output != "table" && *output != "json" {
+		klog.Fatalf("unsupported output format %q", *output)
+	}
+
+	manifests, err := scvalidation.LoadManifests(*manifestDir)
+	if err != nil {
+		klog.Fatalf("failed to load manifests: %v", err)
+	}
+
+	var kubeClient kubernetes.Interface
+	if *kubeconfig != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+		if err != nil {
+			klog.Fatalf("failed to get kubeconfig: %v", err)
+		}
+		if kubeClient, err = kubernetes.NewForConfig(config); err != nil {
+			klog.Fatalf("failed to create kubernetes client: %v", err)
+		}
+	}
+	var azureClient scvalidation.AzureClient
+	if *subscriptionID != "" {
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			klog.Fatalf("failed to get azure credential: %v", err)
+		}
+		azureClient = scvalidation.NewAzureClient(*subscriptionID, cred, nil)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	results, err := scvalidation.NewValidator(*driverName, *location, kubeClient, azureClient).Validate(ctx, manifests)
+	if err != nil {
+		klog.Fatalf("validation failed: %v", err)
+	}
+
+	if *output == "json" {
+		report, err := scvalidation.MarshalReport(results)
+		if err != nil {
+			klog.Fatalf("failed to marshal report: %v", err)
+		}
+		fmt.Println(string(report))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tKIND\tNAME\tVALID\tFINDING")
+		for _, result := range results {
+			if len(result.Findings) == 0 {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%t\t\n", result.File, result.Kind, result.Name, result.Valid)
+			}
+			for _, finding := range result.Findings {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s: %s\n", result.File, result.Kind, result.Name, result.Valid, finding.Severity, finding.Message)
+			}
+		}
+		w.Flush()
+		fmt.Printf("\n%v\n", scvalidation.Summary(results))
+	}
+	if scvalidation.Summary(results)["invalid"] > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/scvalidation"
+)
+
+var (
+	driverName = flag.String("drivername", consts.DefaultDriverName, "name of the driver whose StorageClasses are validated")
+	port       = flag.Int("port", 9443, "HTTPS port of the webhook")
+	certDir    = flag.String("cert-dir", "/etc/webhook/certs", "directory containing tls.crt and tls.key served by the webhook")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := scvalidation.NewWebhook(*driverName).Run(ctx, *port, *certDir); err != nil {
+		klog.Fatalf("StorageClass webhook stopped with error: %v", err)
+	}
+}
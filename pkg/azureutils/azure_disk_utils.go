@@ -23,18 +23,22 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"unicode"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/dynamic"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
@@ -62,6 +66,8 @@ const (
 	diskNameMaxLength         = 80
 	diskNameGenerateMaxLength = 76 // maxLength = 80 - (4 for ".vhd") = 76
 	MaxPathLengthWindows      = 260
+	// FSGroupChangeNone skips applying the volume mount group in NodePublishVolume
+	FSGroupChangeNone = "None"
 )
 
 var (
@@ -87,6 +93,15 @@ var (
 		{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
 	}
 
+	// mkfsFlags are the mkfs flags of mkfsOptions per fsType, the other flags are rejected since they could
+	// create an unusable filesystem, e.g. -n of mke2fs and -N of mkfs.xfs do not create the filesystem
+	mkfsFlags = map[string]sets.Set[string]{
+		"ext2": sets.New("-b", "-C", "-E", "-g", "-G", "-i", "-I", "-j", "-J", "-L", "-m", "-M", "-N", "-o", "-O", "-q", "-r", "-T", "-U"),
+		"ext3": sets.New("-b", "-C", "-E", "-g", "-G", "-i", "-I", "-j", "-J", "-L", "-m", "-M", "-N", "-o", "-O", "-q", "-r", "-T", "-U"),
+		"ext4": sets.New("-b", "-C", "-E", "-g", "-G", "-i", "-I", "-j", "-J", "-L", "-m", "-M", "-N", "-o", "-O", "-q", "-r", "-T", "-U"),
+		"xfs":  sets.New("-b", "-d", "-i", "-K", "-l", "-L", "-m", "-n", "-q", "-r", "-s"),
+	}
+
 	// lock mutex for RunPowerShellCommand
 	mutex = &sync.Mutex{}
 )
@@ -101,6 +116,7 @@ type ManagedDiskParameters struct {
 	DiskIOPSReadWrite       string
 	DiskMBPSReadWrite       string
 	DiskName                string
+	DiskPool                string
 	EnableBursting          *bool
 	PerformancePlus         *bool
 	FsType                  string
@@ -110,6 +126,7 @@ type ManagedDiskParameters struct {
 	NetworkAccessPolicy     string
 	PublicNetworkAccess     string
 	PerfProfile             string
+	SkuFallback             []string
 	SubscriptionID          string
 	ResourceGroup           string
 	Tags                    map[string]string
@@ -117,6 +134,28 @@ type ManagedDiskParameters struct {
 	VolumeContext           map[string]string
 	WriteAcceleratorEnabled string
 	Zoned                   string
+
+	// CreateResourceGroupIfNotExist creates ResourceGroup with ResourceGroupTags if it does not exist
+	CreateResourceGroupIfNotExist bool
+	ResourceGroupTags             map[string]string
+
+	// disk flags required by some VM lifecycle features, not set on the disk if nil
+	SupportsHibernation        *bool
+	OptimizedForFrequentAttach *bool
+
+	// EnableAzureMonitor exports the metrics of the disk to the Log Analytics workspace of WorkspaceID
+	EnableAzureMonitor bool
+	WorkspaceID        string
+
+	// GalleryImageReferenceID is the gallery image version the disk is created from, GalleryImageLun is the lun of
+	// the data disk image of the image version, the OS disk image is used if nil
+	GalleryImageReferenceID string
+	GalleryImageLun         *int32
+
+	// SecurityType is the security type of the VMs the disk is attached to, SecureVMDiskEncryptionSetID is the disk
+	// encryption set of ConfidentialVM_DiskEncryptedWithCustomerKey disks
+	SecurityType                string
+	SecureVMDiskEncryptionSetID string
 }
 
 func GetCachingMode(attributes map[string]string) (armcompute.CachingTypes, error) {
@@ -150,9 +189,147 @@ func GetAttachDiskInitialDelay(attributes map[string]string) int {
 	return -1
 }
 
+// ClientRateLimitOptions overrides the rate limits of the Azure API clients used by the driver,
+// a client keeps the rate limit from the cloud config if its QPS is not positive.
+type ClientRateLimitOptions struct {
+	DiskQPS       float32
+	DiskBurst     int
+	VMQPS         float32
+	VMBurst       int
+	VMSSQPS       float32
+	VMSSBurst     int
+	SnapshotQPS   float32
+	SnapshotBurst int
+	// ThrottlingPolicy is shared by the clients of all clouds created with the options, nil disables it
+	ThrottlingPolicy *ThrottlingPolicy
+}
+
+// apply sets the per client rate limits into the cloud config, a burst of 0 falls back to the default bucket size
+func (o *ClientRateLimitOptions) apply(config *azure.Config) {
+	if o == nil {
+		return
+	}
+	if limit := newRateLimitConfig(o.DiskQPS, o.DiskBurst); limit != nil {
+		config.DiskRateLimit = limit
+	}
+	if limit := newRateLimitConfig(o.VMQPS, o.VMBurst); limit != nil {
+		config.VirtualMachineRateLimit = limit
+	}
+	if limit := newRateLimitConfig(o.VMSSQPS, o.VMSSBurst); limit != nil {
+		config.VirtualMachineScaleSetRateLimit = limit
+	}
+	if limit := newRateLimitConfig(o.SnapshotQPS, o.SnapshotBurst); limit != nil {
+		config.SnapshotRateLimit = limit
+	}
+}
+
+func newRateLimitConfig(qps float32, burst int) *azclients.RateLimitConfig {
+	if qps <= 0 {
+		return nil
+	}
+	return &azclients.RateLimitConfig{
+		CloudProviderRateLimit:            true,
+		CloudProviderRateLimitQPS:         qps,
+		CloudProviderRateLimitBucket:      burst,
+		CloudProviderRateLimitQPSWrite:    qps,
+		CloudProviderRateLimitBucketWrite: burst,
+	}
+}
+
+// CloudCredentials are the credentials of another tenant in the provisioner or snapshotter secrets of a StorageClass or
+// VolumeSnapshotClass, which are used instead of the cluster identity to manage the disks and snapshots in that tenant
+type CloudCredentials struct {
+	TenantID           string
+	ClientID           string
+	ClientSecret       string
+	FederatedTokenFile string
+	// SubscriptionID and ResourceGroup replace the default subscription and resource group of the cloud config
+	SubscriptionID string
+	ResourceGroup  string
+}
+
+// ParseCloudCredentials returns the credentials in secrets, nil is returned if secrets has no credentials
+func ParseCloudCredentials(secrets map[string]string) (*CloudCredentials, error) {
+	creds := &CloudCredentials{}
+	for k, v := range secrets {
+		switch strings.ToLower(k) {
+		case consts.TenantIDSecretKey:
+			creds.TenantID = v
+		case consts.ClientIDSecretKey:
+			creds.ClientID = v
+		case consts.ClientSecretSecretKey:
+			creds.ClientSecret = v
+		case consts.FederatedTokenFileSecretKey:
+			creds.FederatedTokenFile = v
+		case consts.SubscriptionIDField:
+			creds.SubscriptionID = v
+		case consts.ResourceGroupField:
+			creds.ResourceGroup = v
+		}
+	}
+	if *creds == (CloudCredentials{}) {
+		return nil, nil
+	}
+	// never return the values of the secrets in the errors
+	if creds.TenantID == "" || creds.ClientID == "" {
+		return nil, fmt.Errorf("%s and %s must be set in the secrets", consts.TenantIDSecretKey, consts.ClientIDSecretKey)
+	}
+	if (creds.ClientSecret == "") == (creds.FederatedTokenFile == "") {
+		return nil, fmt.Errorf("either %s or %s must be set in the secrets", consts.ClientSecretSecretKey, consts.FederatedTokenFileSecretKey)
+	}
+	return creds, nil
+}
+
+// apply replaces the identity of config with the credentials
+func (creds *CloudCredentials) apply(config *azure.Config) {
+	config.TenantID = creds.TenantID
+	config.AADClientID = creds.ClientID
+	config.AADClientSecret = creds.ClientSecret
+	config.AADClientCertPath = ""
+	config.AADFederatedTokenFile = creds.FederatedTokenFile
+	config.UseFederatedWorkloadIdentityExtension = creds.FederatedTokenFile != ""
+	config.UseManagedIdentityExtension = false
+	config.UserAssignedIdentityID = ""
+	if creds.SubscriptionID != "" {
+		config.SubscriptionID = creds.SubscriptionID
+	}
+	if creds.ResourceGroup != "" {
+		config.ResourceGroup = creds.ResourceGroup
+	}
+}
+
 // GetCloudProviderFromClient get Azure Cloud Provider
 func GetCloudProviderFromClient(ctx context.Context, kubeClient clientset.Interface, secretName, secretNamespace, userAgent string,
-	allowEmptyCloudConfig bool, enableTrafficMgr bool, trafficMgrPort int64) (*azure.Cloud, error) {
+	allowEmptyCloudConfig bool, enableTrafficMgr bool, trafficMgrPort int64, rateLimitOptions *ClientRateLimitOptions) (*azure.Cloud, error) {
+	return GetCloudProviderWithCredentials(ctx, kubeClient, secretName, secretNamespace, userAgent, allowEmptyCloudConfig, enableTrafficMgr,
+		trafficMgrPort, rateLimitOptions, nil)
+}
+
+// GetCloudProviderWithCredentials gets Azure Cloud Provider using creds instead of the identity in the cloud config,
+// the identity in the cloud config is used if creds is nil
+func GetCloudProviderWithCredentials(ctx context.Context, kubeClient clientset.Interface, secretName, secretNamespace, userAgent string,
+	allowEmptyCloudConfig bool, enableTrafficMgr bool, trafficMgrPort int64, rateLimitOptions *ClientRateLimitOptions, creds *CloudCredentials) (*azure.Cloud, error) {
+	return getCloudProvider(ctx, kubeClient, secretName, secretNamespace, userAgent, allowEmptyCloudConfig, enableTrafficMgr, trafficMgrPort,
+		rateLimitOptions, func(config *azure.Config) {
+			if creds != nil {
+				creds.apply(config)
+			}
+		})
+}
+
+// GetCloudProviderForResourceGroup gets Azure Cloud Provider whose default resource group is resourceGroup instead of
+// the one in the cloud config, e.g. to manage the VMs of the nodes in another resource group
+func GetCloudProviderForResourceGroup(ctx context.Context, kubeClient clientset.Interface, secretName, secretNamespace, userAgent string,
+	allowEmptyCloudConfig bool, enableTrafficMgr bool, trafficMgrPort int64, rateLimitOptions *ClientRateLimitOptions, resourceGroup string) (*azure.Cloud, error) {
+	return getCloudProvider(ctx, kubeClient, secretName, secretNamespace, userAgent, allowEmptyCloudConfig, enableTrafficMgr, trafficMgrPort,
+		rateLimitOptions, func(config *azure.Config) {
+			config.ResourceGroup = resourceGroup
+		})
+}
+
+// getCloudProvider gets Azure Cloud Provider, configure overrides the cloud config before the cloud is initialized
+func getCloudProvider(ctx context.Context, kubeClient clientset.Interface, secretName, secretNamespace, userAgent string,
+	allowEmptyCloudConfig bool, enableTrafficMgr bool, trafficMgrPort int64, rateLimitOptions *ClientRateLimitOptions, configure func(*azure.Config)) (*azure.Cloud, error) {
 	var config *azure.Config
 	var fromSecret bool
 	var err error
@@ -214,6 +391,7 @@ func GetCloudProviderFromClient(ctx context.Context, kubeClient clientset.Interf
 		config.SnapshotRateLimit = &azclients.RateLimitConfig{
 			CloudProviderRateLimit: false,
 		}
+		rateLimitOptions.apply(config)
 		config.UserAgent = userAgent
 		if enableTrafficMgr && trafficMgrPort > 0 {
 			trafficMgrAddr := fmt.Sprintf("http://localhost:%d/", trafficMgrPort)
@@ -231,6 +409,7 @@ func GetCloudProviderFromClient(ctx context.Context, kubeClient clientset.Interf
 			config.AADFederatedTokenFile = federatedTokenFile
 			config.UseFederatedWorkloadIdentityExtension = true
 		}
+		configure(config)
 		if len(config.AADClientCertPath) > 0 {
 			// Watch the certificate for changes; if the certificate changes, the pod will be restarted
 			err = filewatcher.WatchFileForChanges(config.AADClientCertPath)
@@ -238,6 +417,8 @@ func GetCloudProviderFromClient(ctx context.Context, kubeClient clientset.Interf
 		}
 		if err = az.InitializeCloudFromConfig(ctx, config, fromSecret, false); err != nil {
 			klog.Warningf("InitializeCloudFromConfig failed with error: %v", err)
+		} else if err = rateLimitOptions.applyToClientFactory(az); err != nil {
+			klog.Warningf("failed to add the throttling policy and rate limits to the Azure clients: %v", err)
 		}
 	}
 
@@ -257,6 +438,26 @@ func GetKubeClient(kubeconfig string) (clientset.Interface, error) {
 	return clientset.NewForConfig(config)
 }
 
+// GetSnapshotClient returns the client of the VolumeSnapshot APIs of kubeconfig
+func GetSnapshotClient(kubeconfig string) (snapshotclientset.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotclientset.NewForConfig(config)
+}
+
+// GetDynamicClient returns the dynamic client of kubeconfig, e.g. for the custom resources without a typed client
+func GetDynamicClient(kubeconfig string) (dynamic.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamic.NewForConfig(config)
+}
+
 // GetDiskLUN : deviceInfo could be a LUN number or a device path, e.g. /dev/disk/azure/scsi1/lun2
 func GetDiskLUN(deviceInfo string) (int32, error) {
 	var diskLUN string
@@ -321,6 +522,179 @@ func GetFStype(attributes map[string]string) string {
 	return ""
 }
 
+// GetIntegrityStreams returns whether integrity streams are enabled on a new ReFS volume by the mount options,
+// nil if neither integritystreams nor nointegritystreams is set
+func GetIntegrityStreams(mountOptions []string) (*bool, error) {
+	var integrityStreams *bool
+	for _, option := range mountOptions {
+		var enabled bool
+		switch strings.ToLower(option) {
+		case consts.IntegrityStreamsMountOption:
+			enabled = true
+		case consts.NoIntegrityStreamsMountOption:
+			enabled = false
+		default:
+			continue
+		}
+		if integrityStreams != nil && *integrityStreams != enabled {
+			return nil, fmt.Errorf("mount options %s and %s are mutually exclusive", consts.IntegrityStreamsMountOption, consts.NoIntegrityStreamsMountOption)
+		}
+		integrityStreams = &enabled
+	}
+	return integrityStreams, nil
+}
+
+// GetAllocationUnitSizeMountOption returns the allocation unit size of a new NTFS or ReFS volume in the mount options,
+// 0 if allocationunitsize is not set
+func GetAllocationUnitSizeMountOption(mountOptions []string) (uint32, error) {
+	for _, option := range mountOptions {
+		key, value, found := strings.Cut(option, "=")
+		if !found || !strings.EqualFold(key, consts.AllocationUnitSizeMountOption) {
+			continue
+		}
+		size, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || size == 0 {
+			return 0, fmt.Errorf("invalid mount option %s", option)
+		}
+		return uint32(size), nil
+	}
+	return 0, nil
+}
+
+// GetMkfsOptions returns the options of mkfs formatting a new volume of fsType, nil if not set. Only the flags of
+// mkfsFlags of the fsType are supported, the flags of all fsTypes are accepted if fsType is empty.
+func GetMkfsOptions(attributes map[string]string, fsType string) ([]string, error) {
+	for k, v := range attributes {
+		if !strings.EqualFold(k, consts.MkfsOptionsField) {
+			continue
+		}
+		var flags sets.Set[string]
+		if fsType == "" {
+			flags = sets.New[string]()
+			for _, f := range mkfsFlags {
+				flags = flags.Union(f)
+			}
+		} else if flags = mkfsFlags[strings.ToLower(fsType)]; flags == nil {
+			return nil, fmt.Errorf("%s is not supported by fsType %s", k, fsType)
+		}
+		options := strings.Fields(v)
+		for _, option := range options {
+			if strings.HasPrefix(option, "-") && (len(option) < 2 || !flags.Has(option[:2])) {
+				return nil, fmt.Errorf("option %s in %s is not supported, supported options are %v", option, k, sets.List(flags))
+			}
+		}
+		return options, nil
+	}
+	return nil, nil
+}
+
+// GetAllocationUnitSize returns the allocation unit size in bytes of a new NTFS or ReFS volume of fsType, 0 if not set.
+// It must be a power of 2 between 512 bytes and 2MB for NTFS, 4096 or 65536 for ReFS, fsType is not checked if empty.
+func GetAllocationUnitSize(attributes map[string]string, fsType string) (uint32, error) {
+	for k, v := range attributes {
+		if !strings.EqualFold(k, consts.AllocationUnitSizeField) {
+			continue
+		}
+		size, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || size < 512 || size > 2*1024*1024 || size&(size-1) != 0 {
+			return 0, fmt.Errorf("invalid %s: %s, should be a power of 2 between 512 and 2097152", k, v)
+		}
+		switch strings.ToLower(fsType) {
+		case "", "ntfs":
+		case consts.FsTypeReFS:
+			if size != 4096 && size != 65536 {
+				return 0, fmt.Errorf("invalid %s: %s, should be 4096 or 65536 for fsType %s", k, v, fsType)
+			}
+		default:
+			return 0, fmt.Errorf("%s is not supported by fsType %s", k, fsType)
+		}
+		return uint32(size), nil
+	}
+	return 0, nil
+}
+
+// GetFsGroupChangePolicy returns the policy of applying the volume mount group in NodePublishVolume,
+// return Always if not set, which is the default of kubelet
+func GetFsGroupChangePolicy(attributes map[string]string) (string, error) {
+	for k, v := range attributes {
+		if strings.EqualFold(k, consts.FsGroupChangePolicyField) {
+			for _, policy := range []string{string(v1.FSGroupChangeAlways), string(v1.FSGroupChangeOnRootMismatch), FSGroupChangeNone} {
+				if strings.EqualFold(v, policy) {
+					return policy, nil
+				}
+			}
+			return "", fmt.Errorf("invalid %s: %s, supported values are %s, %s and %s", k, v, v1.FSGroupChangeAlways, v1.FSGroupChangeOnRootMismatch, FSGroupChangeNone)
+		}
+	}
+	return string(v1.FSGroupChangeAlways), nil
+}
+
+// ParseNodeClassValues parses the per node class disk performance in format "class1=value1,class2=value2",
+// values must be positive integers
+func ParseNodeClassValues(value string) (map[string]int64, error) {
+	result := map[string]int64{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		class, v, found := strings.Cut(pair, "=")
+		class = strings.TrimSpace(class)
+		if !found || class == "" {
+			return nil, fmt.Errorf("invalid node class value %q, expected format is class=value", pair)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid value %q of node class %s, should be a positive integer", v, class)
+		}
+		result[class] = n
+	}
+	return result, nil
+}
+
+// GetReservedBlocksPercentage returns the percentage of the filesystem blocks reserved for the super-user,
+// return empty string if not set
+func GetReservedBlocksPercentage(attributes map[string]string) (string, error) {
+	for k, v := range attributes {
+		if strings.EqualFold(k, consts.ReservedBlocksPercentageField) {
+			percentage, err := strconv.ParseFloat(v, 64)
+			if err != nil || percentage < 0 || percentage > 50 {
+				return "", fmt.Errorf("invalid %s: %s, should be a number between 0 and 50", k, v)
+			}
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// GetHostEncryption returns whether the volume is encrypted with dm-crypt/LUKS on the node, false if not set
+func GetHostEncryption(attributes map[string]string) (bool, error) {
+	for k, v := range attributes {
+		if strings.EqualFold(k, consts.HostEncryptionField) {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return false, fmt.Errorf("invalid %s: %s, should be true or false", k, v)
+			}
+			return enabled, nil
+		}
+	}
+	return false, nil
+}
+
+// GetLogicalSectorSize returns the logical sector size in the volume or publish context, 0 if it's not set
+func GetLogicalSectorSize(attributes map[string]string) (int, error) {
+	for k, v := range attributes {
+		if strings.EqualFold(k, consts.LogicalSectorSizeField) {
+			logicalSectorSize, err := strconv.Atoi(v)
+			if err != nil || (logicalSectorSize != consts.LogicalSectorSize512 && logicalSectorSize != consts.LogicalSectorSize4096) {
+				return 0, fmt.Errorf("invalid %s: %s, should be %d or %d", k, v, consts.LogicalSectorSize512, consts.LogicalSectorSize4096)
+			}
+			return logicalSectorSize, nil
+		}
+	}
+	return 0, nil
+}
+
 func GetMaxShares(attributes map[string]string) (int, error) {
 	for k, v := range attributes {
 		switch strings.ToLower(k) {
@@ -465,6 +839,11 @@ func IsValidVolumeCapabilities(volCaps []*csi.VolumeCapability, maxShares int) e
 	return nil
 }
 
+// IsSingleNodeSingleWriter returns true if the volume capability is SINGLE_NODE_SINGLE_WRITER (ReadWriteOncePod)
+func IsSingleNodeSingleWriter(volCap *csi.VolumeCapability) bool {
+	return volCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER
+}
+
 func IsValidAccessModes(volCaps []*csi.VolumeCapability) bool {
 	hasSupport := func(cap *csi.VolumeCapability) bool {
 		for _, c := range volumeCaps {
@@ -557,6 +936,85 @@ func ValidateDiskEncryptionType(encryptionType string) error {
 	return fmt.Errorf("DiskEncryptionType(%s) is not supported", encryptionType)
 }
 
+// ValidateDiskEncryption checks that the disk encryption set is set with the encryption types using a customer key,
+// EncryptionAtRestWithPlatformKey could not be used with a disk encryption set
+func ValidateDiskEncryption(encryptionType, diskEncryptionSetID string) error {
+	if err := ValidateDiskEncryptionType(encryptionType); err != nil {
+		return err
+	}
+	switch armcompute.EncryptionType(encryptionType) {
+	case armcompute.EncryptionTypeEncryptionAtRestWithPlatformKey:
+		if diskEncryptionSetID != "" {
+			return fmt.Errorf("%s could not be set with %s(%s)", consts.DesIDField, consts.DiskEncryptionTypeField, encryptionType)
+		}
+	case armcompute.EncryptionTypeEncryptionAtRestWithCustomerKey, armcompute.EncryptionTypeEncryptionAtRestWithPlatformAndCustomerKeys:
+		if diskEncryptionSetID == "" {
+			return fmt.Errorf("%s must be set with %s(%s)", consts.DesIDField, consts.DiskEncryptionTypeField, encryptionType)
+		}
+	}
+	return nil
+}
+
+// ValidateLogicalSectorSize checks that the logical sector size is 512 or 4096 and that the sku supports it,
+// only UltraSSD_LRS and PremiumV2_LRS disks could be created with a logical sector size
+func ValidateLogicalSectorSize(logicalSectorSize int, skuName armcompute.DiskStorageAccountTypes) error {
+	if logicalSectorSize == 0 {
+		return nil
+	}
+	if logicalSectorSize != consts.LogicalSectorSize512 && logicalSectorSize != consts.LogicalSectorSize4096 {
+		return fmt.Errorf("logicalSectorSize(%d) is not supported, supported values are %d and %d", logicalSectorSize, consts.LogicalSectorSize512, consts.LogicalSectorSize4096)
+	}
+	if skuName != armcompute.DiskStorageAccountTypesUltraSSDLRS && skuName != armcompute.DiskStorageAccountTypesPremiumV2LRS {
+		return fmt.Errorf("logicalSectorSize(%d) is not supported by %s disk, only %s and %s disks support it", logicalSectorSize, skuName,
+			armcompute.DiskStorageAccountTypesUltraSSDLRS, armcompute.DiskStorageAccountTypesPremiumV2LRS)
+	}
+	return nil
+}
+
+// ValidateDiskLifecycleFlags checks the supportsHibernation and optimizedForFrequentAttach flags against the sku and maxShares of the disk
+func ValidateDiskLifecycleFlags(supportsHibernation, optimizedForFrequentAttach *bool, maxShares int, skuName armcompute.DiskStorageAccountTypes) error {
+	if ptr.Deref(supportsHibernation, false) {
+		if skuName == armcompute.DiskStorageAccountTypesUltraSSDLRS || skuName == armcompute.DiskStorageAccountTypesPremiumV2LRS {
+			return fmt.Errorf("%s is not supported by %s disk", consts.SupportsHibernationField, skuName)
+		}
+		if maxShares > 1 {
+			return fmt.Errorf("%s is not supported by shared disk(maxShares: %d)", consts.SupportsHibernationField, maxShares)
+		}
+	}
+	if ptr.Deref(optimizedForFrequentAttach, false) && maxShares > 1 {
+		return fmt.Errorf("%s is not supported by shared disk(maxShares: %d)", consts.OptimizedForFrequentAttachField, maxShares)
+	}
+	return nil
+}
+
+// ValidateDiskSecurityProfile checks the security type and the secure VM disk encryption set of a disk, the disk
+// encryption set is required by ConfidentialVM_DiskEncryptedWithCustomerKey disks and not supported by the others.
+// The security type only applies to OS disks, so it's only supported with the OS disk image of a gallery image version.
+func ValidateDiskSecurityProfile(securityType, secureVMDiskEncryptionSetID, galleryImageReferenceID string, galleryImageLun *int32) error {
+	if securityType != "" && !slices.Contains(armcompute.PossibleDiskSecurityTypesValues(), armcompute.DiskSecurityTypes(securityType)) {
+		return fmt.Errorf("%s(%s) is not supported, supported values are %v", consts.SecurityTypeField, securityType, armcompute.PossibleDiskSecurityTypesValues())
+	}
+	if securityType != "" && (galleryImageReferenceID == "" || galleryImageLun != nil) {
+		return fmt.Errorf("%s is only supported by an OS disk created from %s without %s", consts.SecurityTypeField, consts.GalleryImageReferenceIDField, consts.GalleryImageLunField)
+	}
+	customerKey := securityType == string(armcompute.DiskSecurityTypesConfidentialVMDiskEncryptedWithCustomerKey)
+	if secureVMDiskEncryptionSetID == "" {
+		if customerKey {
+			return fmt.Errorf("%s must be set with %s(%s)", consts.SecureVMDiskEncryptionSetIDField, consts.SecurityTypeField, securityType)
+		}
+		return nil
+	}
+	if !customerKey {
+		return fmt.Errorf("%s is only supported with %s(%s)", consts.SecureVMDiskEncryptionSetIDField, consts.SecurityTypeField,
+			armcompute.DiskSecurityTypesConfidentialVMDiskEncryptedWithCustomerKey)
+	}
+	desID, err := arm.ParseResourceID(secureVMDiskEncryptionSetID)
+	if err != nil || !strings.EqualFold(desID.ResourceType.String(), "Microsoft.Compute/diskEncryptionSets") {
+		return fmt.Errorf("invalid %s: %s, it must be the resource ID of a disk encryption set", consts.SecureVMDiskEncryptionSetIDField, secureVMDiskEncryptionSetID)
+	}
+	return nil
+}
+
 func ValidateDataAccessAuthMode(dataAccessAuthMode string) error {
 	if dataAccessAuthMode == "" {
 		return nil
@@ -570,6 +1028,37 @@ func ValidateDataAccessAuthMode(dataAccessAuthMode string) error {
 	return fmt.Errorf("dataAccessAuthMode(%s) is not supported", dataAccessAuthMode)
 }
 
+// ValidateDiskParameters checks the disk parameters parsed by ParseDiskParameters the same way as CreateVolume,
+// the normalized sku name is returned
+func ValidateDiskParameters(diskParams *ManagedDiskParameters, cloud string, disableAzureStackCloud bool) (armcompute.DiskStorageAccountTypes, error) {
+	skuName, err := NormalizeStorageAccountType(diskParams.AccountType, cloud, disableAzureStackCloud)
+	if err != nil {
+		return skuName, err
+	}
+	if _, err := NormalizeCachingMode(diskParams.CachingMode); err != nil {
+		return skuName, err
+	}
+	if err := ValidateDiskEncryption(diskParams.DiskEncryptionType, diskParams.DiskEncryptionSetID); err != nil {
+		return skuName, err
+	}
+	if err := ValidateLogicalSectorSize(diskParams.LogicalSectorSize, skuName); err != nil {
+		return skuName, err
+	}
+	if err := ValidateDiskSecurityProfile(diskParams.SecurityType, diskParams.SecureVMDiskEncryptionSetID, diskParams.GalleryImageReferenceID, diskParams.GalleryImageLun); err != nil {
+		return skuName, err
+	}
+	if err := ValidateDiskLifecycleFlags(diskParams.SupportsHibernation, diskParams.OptimizedForFrequentAttach, diskParams.MaxShares, skuName); err != nil {
+		return skuName, err
+	}
+	if _, err := NormalizeNetworkAccessPolicy(diskParams.NetworkAccessPolicy); err != nil {
+		return skuName, err
+	}
+	if _, err := NormalizePublicNetworkAccess(diskParams.PublicNetworkAccess); err != nil {
+		return skuName, err
+	}
+	return skuName, nil
+}
+
 func ParseDiskParameters(parameters map[string]string) (ManagedDiskParameters, error) {
 	var err error
 	if parameters == nil {
@@ -581,11 +1070,18 @@ func ParseDiskParameters(parameters map[string]string) (ManagedDiskParameters, e
 		Tags:           make(map[string]string),
 		VolumeContext:  parameters,
 	}
-	var originTags, tagValueDelimiter string
+	var originTags, originResourceGroupTags, tagValueDelimiter string
+	var hasNodeClassDiskIOPS, hasNodeClassDiskMBps bool
 	for k, v := range parameters {
 		switch strings.ToLower(k) {
 		case consts.SkuNameField:
 			diskParams.AccountType = v
+		case consts.SkuFallbackField:
+			for _, sku := range strings.Split(v, ",") {
+				if sku = strings.TrimSpace(sku); sku != "" {
+					diskParams.SkuFallback = append(diskParams.SkuFallback, sku)
+				}
+			}
 		case consts.LocationField:
 			diskParams.Location = v
 		case consts.StorageAccountTypeField:
@@ -596,6 +1092,12 @@ func ParseDiskParameters(parameters map[string]string) (ManagedDiskParameters, e
 			diskParams.SubscriptionID = v
 		case consts.ResourceGroupField:
 			diskParams.ResourceGroup = v
+		case consts.CreateResourceGroupIfNotExist:
+			if diskParams.CreateResourceGroupIfNotExist, err = strconv.ParseBool(v); err != nil {
+				return diskParams, fmt.Errorf("invalid %s: %s in storage class", k, v)
+			}
+		case consts.ResourceGroupTagsField:
+			originResourceGroupTags = v
 		case consts.DiskIOPSReadWriteField:
 			if _, err = strconv.Atoi(v); err != nil {
 				return diskParams, fmt.Errorf("parse %s:%s failed with error: %v", consts.DiskIOPSReadWriteField, v, err)
@@ -613,10 +1115,16 @@ func ParseDiskParameters(parameters map[string]string) (ManagedDiskParameters, e
 			}
 		case consts.DiskNameField:
 			diskParams.DiskName = v
+		case consts.DiskPoolField:
+			diskParams.DiskPool = v
 		case consts.DesIDField:
 			diskParams.DiskEncryptionSetID = v
 		case consts.DiskEncryptionTypeField:
 			diskParams.DiskEncryptionType = v
+		case consts.SecurityTypeField:
+			diskParams.SecurityType = v
+		case consts.SecureVMDiskEncryptionSetIDField:
+			diskParams.SecureVMDiskEncryptionSetID = v
 		case consts.TagsField:
 			originTags = v
 		case azure.WriteAcceleratorEnabled:
@@ -667,12 +1175,81 @@ func ParseDiskParameters(parameters map[string]string) (ManagedDiskParameters, e
 				return diskParams, fmt.Errorf("invalid %s: %s in storage class", consts.PerformancePlusField, v)
 			}
 			diskParams.PerformancePlus = &value
+		case consts.SupportsHibernationField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return diskParams, fmt.Errorf("invalid %s: %s in storage class", consts.SupportsHibernationField, v)
+			}
+			diskParams.SupportsHibernation = &value
+		case consts.OptimizedForFrequentAttachField:
+			value, err := strconv.ParseBool(v)
+			if err != nil {
+				return diskParams, fmt.Errorf("invalid %s: %s in storage class", consts.OptimizedForFrequentAttachField, v)
+			}
+			diskParams.OptimizedForFrequentAttach = &value
 		case consts.AttachDiskInitialDelayField:
 			if _, err = strconv.Atoi(v); err != nil {
 				return diskParams, fmt.Errorf("parse %s failed with error: %v", v, err)
 			}
+		case consts.EnableAzureMonitorField:
+			if diskParams.EnableAzureMonitor, err = strconv.ParseBool(v); err != nil {
+				return diskParams, fmt.Errorf("invalid %s: %s in storage class", consts.EnableAzureMonitorField, v)
+			}
+		case consts.WorkspaceIDField:
+			workspaceID, err := arm.ParseResourceID(v)
+			if err != nil || !strings.EqualFold(workspaceID.ResourceType.String(), "Microsoft.OperationalInsights/workspaces") {
+				return diskParams, fmt.Errorf("invalid %s: %s in storage class, it must be the resource ID of a Log Analytics workspace", consts.WorkspaceIDField, v)
+			}
+			diskParams.WorkspaceID = v
+		case consts.GalleryImageReferenceIDField:
+			imageID, err := arm.ParseResourceID(v)
+			if err != nil || !strings.EqualFold(imageID.ResourceType.String(), "Microsoft.Compute/galleries/images/versions") {
+				return diskParams, fmt.Errorf("invalid %s: %s in storage class, it must be the resource ID of a gallery image version", consts.GalleryImageReferenceIDField, v)
+			}
+			diskParams.GalleryImageReferenceID = v
+		case consts.GalleryImageLunField:
+			lun, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || lun < 0 {
+				return diskParams, fmt.Errorf("invalid %s: %s in storage class, it must be a non-negative integer", consts.GalleryImageLunField, v)
+			}
+			diskParams.GalleryImageLun = ptr.To(int32(lun))
 		case consts.TagValueDelimiterField:
 			tagValueDelimiter = v
+		case consts.ImportStorageAccountsField:
+			// only used by the volume populator to validate the sources of the imported disks
+		case consts.ReservedBlocksPercentageField:
+			// only validate here, reserved blocks are set on the node
+			if _, err = GetReservedBlocksPercentage(map[string]string{k: v}); err != nil {
+				return diskParams, err
+			}
+		case consts.MkfsOptionsField, consts.AllocationUnitSizeField:
+			// validated against the fsType after all parameters are parsed, the volume is formatted on the node
+		case consts.HostEncryptionField:
+			// only validate here, the volume is encrypted on the node
+			if _, err = GetHostEncryption(map[string]string{k: v}); err != nil {
+				return diskParams, err
+			}
+		case consts.NodeClassLabelField:
+			// only validate here, node class performance is applied on attach
+			if v == "" {
+				return diskParams, fmt.Errorf("%s must not be empty", k)
+			}
+		case consts.NodeClassDiskIOPSField, consts.NodeClassDiskMBPSField:
+			if _, err = ParseNodeClassValues(v); err != nil {
+				return diskParams, fmt.Errorf("parse %s failed with error: %v", k, err)
+			}
+			hasNodeClassDiskIOPS = hasNodeClassDiskIOPS || strings.EqualFold(k, consts.NodeClassDiskIOPSField)
+			hasNodeClassDiskMBps = hasNodeClassDiskMBps || strings.EqualFold(k, consts.NodeClassDiskMBPSField)
+		case consts.FsGroupChangePolicyField:
+			// only validate here, volume ownership is set on the node
+			if _, err = GetFsGroupChangePolicy(map[string]string{k: v}); err != nil {
+				return diskParams, err
+			}
+		case consts.IopsLimitField, consts.BandwidthLimitField:
+			// only validate here, io limits are applied on the node
+			if _, err = optimization.GetIOThrottleFromAttributes(map[string]string{k: v}); err != nil {
+				return diskParams, err
+			}
 		default:
 			// accept all device settings params
 			// device settings need to start with azureconstants.DeviceSettingsKeyPrefix
@@ -690,6 +1267,33 @@ func ParseDiskParameters(parameters map[string]string) (ManagedDiskParameters, e
 	for k, v := range customTagsMap {
 		diskParams.Tags[k] = v
 	}
+	if originResourceGroupTags != "" {
+		if diskParams.ResourceGroupTags, err = util.ConvertTagsToMap(originResourceGroupTags, tagValueDelimiter); err != nil {
+			return diskParams, err
+		}
+	}
+	if diskParams.EnableAzureMonitor && diskParams.WorkspaceID == "" {
+		return diskParams, fmt.Errorf("%s must be set with %s", consts.WorkspaceIDField, consts.EnableAzureMonitorField)
+	}
+	if diskParams.GalleryImageLun != nil && diskParams.GalleryImageReferenceID == "" {
+		return diskParams, fmt.Errorf("%s must be set with %s", consts.GalleryImageReferenceIDField, consts.GalleryImageLunField)
+	}
+	if diskParams.CreateResourceGroupIfNotExist && diskParams.ResourceGroup == "" {
+		return diskParams, fmt.Errorf("%s must be set with %s", consts.ResourceGroupField, consts.CreateResourceGroupIfNotExist)
+	}
+	// nodes without a matching class get the performance of the storage class, so that it's restored on promotion
+	if hasNodeClassDiskIOPS && diskParams.DiskIOPSReadWrite == "" {
+		return diskParams, fmt.Errorf("%s must be set with %s", consts.DiskIOPSReadWriteField, consts.NodeClassDiskIOPSField)
+	}
+	if hasNodeClassDiskMBps && diskParams.DiskMBPSReadWrite == "" {
+		return diskParams, fmt.Errorf("%s must be set with %s", consts.DiskMBPSReadWriteField, consts.NodeClassDiskMBPSField)
+	}
+	if _, err = GetMkfsOptions(parameters, diskParams.FsType); err != nil {
+		return diskParams, err
+	}
+	if _, err = GetAllocationUnitSize(parameters, diskParams.FsType); err != nil {
+		return diskParams, err
+	}
 
 	if strings.EqualFold(diskParams.AccountType, string(armcompute.DiskStorageAccountTypesPremiumV2LRS)) {
 		if diskParams.CachingMode != "" && !strings.EqualFold(string(diskParams.CachingMode), string(v1.AzureDataDiskCachingNone)) {
@@ -733,6 +1337,32 @@ func PickAvailabilityZone(requirement *csi.TopologyRequirement, region, topology
 	return ""
 }
 
+// GetRequestedAvailabilityZone returns the requested zone in the format of <region>-<zone-id>, a zone id without the
+// region is prefixed with region. The zone must be one of the requisite topologies of requirement if there is any,
+// which are the allowedTopologies of the StorageClass.
+func GetRequestedAvailabilityZone(zone string, requirement *csi.TopologyRequirement, region, topologyKey string) (string, error) {
+	zone = strings.ToLower(strings.TrimSpace(zone))
+	region = strings.ToLower(region)
+	if _, err := strconv.Atoi(zone); err == nil && region != "" {
+		zone = fmt.Sprintf("%s-%s", region, zone)
+	}
+	if !IsValidAvailabilityZone(zone, region) {
+		return "", fmt.Errorf("zone %s is not a valid availability zone of region %s", zone, region)
+	}
+	requisite := requirement.GetRequisite()
+	if len(requisite) == 0 {
+		return zone, nil
+	}
+	for _, topology := range requisite {
+		for _, key := range []string{consts.WellKnownTopologyKey, topologyKey} {
+			if strings.EqualFold(topology.GetSegments()[key], zone) {
+				return zone, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("zone %s is not in the allowed topologies %v", zone, requisite)
+}
+
 func checkDiskName(diskName string) bool {
 	length := len(diskName)
 
@@ -778,6 +1408,43 @@ func InsertDiskProperties(disk *armcompute.Disk, publishConext map[string]string
 	}
 }
 
+// GetDiskPropertiesAnnotations returns the realized properties of the disk as PV annotations
+func GetDiskPropertiesAnnotations(disk *armcompute.Disk) map[string]string {
+	annotations := map[string]string{}
+	if disk == nil {
+		return annotations
+	}
+	if disk.SKU != nil && disk.SKU.Name != nil {
+		annotations[consts.DiskSkuAnnotation] = string(*disk.SKU.Name)
+	}
+	if len(disk.Zones) > 0 {
+		zones := make([]string, 0, len(disk.Zones))
+		for _, zone := range disk.Zones {
+			if zone != nil {
+				zones = append(zones, *zone)
+			}
+		}
+		annotations[consts.DiskZonesAnnotation] = strings.Join(zones, ",")
+	}
+	prop := disk.Properties
+	if prop == nil {
+		return annotations
+	}
+	if prop.Tier != nil {
+		annotations[consts.DiskTierAnnotation] = *prop.Tier
+	}
+	if prop.Encryption != nil && prop.Encryption.Type != nil {
+		annotations[consts.DiskEncryptionTypeAnnotation] = string(*prop.Encryption.Type)
+	}
+	if prop.CreationData != nil && prop.CreationData.LogicalSectorSize != nil {
+		annotations[consts.DiskLogicalSectorSizeAnnotation] = strconv.Itoa(int(*prop.CreationData.LogicalSectorSize))
+	}
+	if prop.BurstingEnabled != nil {
+		annotations[consts.DiskBurstingEnabledAnnotation] = strconv.FormatBool(*prop.BurstingEnabled)
+	}
+	return annotations
+}
+
 func SleepIfThrottled(err error, defaultSleepSec int) {
 	if err != nil && IsThrottlingError(err) {
 		retryAfter := getRetryAfterSeconds(err)
@@ -797,6 +1464,26 @@ func IsThrottlingError(err error) bool {
 	return false
 }
 
+// IsSkuNotAvailableError returns true if the disk could not be created since the sku is not available in the region or zone
+func IsSkuNotAvailableError(err error) bool {
+	if err != nil {
+		errMsg := strings.ToLower(err.Error())
+		return strings.Contains(errMsg, strings.ToLower(consts.SkuNotAvailable)) ||
+			strings.Contains(errMsg, "not supported in") ||
+			strings.Contains(errMsg, "not available in")
+	}
+	return false
+}
+
+// IsQuotaExceededError returns true if the disk could not be created since the disk quota of the subscription is exhausted
+func IsQuotaExceededError(err error) bool {
+	if err != nil {
+		errMsg := strings.ToLower(err.Error())
+		return strings.Contains(errMsg, "quotaexceeded") || strings.Contains(errMsg, "exceeding approved")
+	}
+	return false
+}
+
 // getRetryAfterSeconds returns the number of seconds to wait from the error message
 func getRetryAfterSeconds(err error) int {
 	if err == nil {
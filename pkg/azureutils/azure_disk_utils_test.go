@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
@@ -36,6 +37,9 @@ import (
 	"k8s.io/utils/ptr"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/test/utils/testutil"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/policy/ratelimit"
+	azclients "sigs.k8s.io/cloud-provider-azure/pkg/azureclients"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 )
 
 func TestCheckDiskName(t *testing.T) {
@@ -314,7 +318,7 @@ users:
 				t.Errorf("desc: %s,\n input: %q, GetCloudProvider err: %v, expectedErr: %v", test.desc, test.kubeconfig, err, test.expectedErr)
 			}
 		}
-		cloud, err := GetCloudProviderFromClient(context.Background(), kubeClient, "", "", test.userAgent, test.allowEmptyCloudConfig, false, -1)
+		cloud, err := GetCloudProviderFromClient(context.Background(), kubeClient, "", "", test.userAgent, test.allowEmptyCloudConfig, false, -1, nil)
 		if ((err == nil) == (test.expectedErr == nil)) && !reflect.DeepEqual(err, test.expectedErr) && !strings.Contains(err.Error(), test.expectedErr.Error()) {
 			t.Errorf("desc: %s,\n input: %q, GetCloudProvider err: %v, expectedErr: %v", test.desc, test.kubeconfig, err, test.expectedErr)
 		}
@@ -327,6 +331,22 @@ users:
 	}
 }
 
+func TestGetCloudProviderForResourceGroup(t *testing.T) {
+	credFile := filepath.Join(t.TempDir(), "azure.json")
+	if err := os.WriteFile(credFile, []byte("resourceGroup: \"rg\"\nlocation: \"eastus\"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(consts.DefaultAzureCredentialFileEnv, credFile)
+
+	cloud, err := GetCloudProviderForResourceGroup(context.Background(), nil, "", "", "useragent", true, false, -1, nil, "node-rg")
+	assert.NoError(t, err)
+	assert.Equal(t, "node-rg", cloud.ResourceGroup)
+
+	cloud, err = GetCloudProviderFromClient(context.Background(), nil, "", "", "useragent", true, false, -1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "rg", cloud.ResourceGroup)
+}
+
 func TestGetDiskLUN(t *testing.T) {
 	tests := []struct {
 		deviceInfo  string
@@ -474,6 +494,218 @@ func TestGetFStype(t *testing.T) {
 	}
 }
 
+func TestGetIntegrityStreams(t *testing.T) {
+	tests := []struct {
+		options     []string
+		expected    *bool
+		expectedErr bool
+	}{
+		{
+			options:  nil,
+			expected: nil,
+		},
+		{
+			options:  []string{"ro"},
+			expected: nil,
+		},
+		{
+			options:  []string{"ro", "IntegrityStreams"},
+			expected: ptr.To(true),
+		},
+		{
+			options:  []string{"nointegritystreams", "nointegritystreams"},
+			expected: ptr.To(false),
+		},
+		{
+			options:     []string{"integritystreams", "nointegritystreams"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := GetIntegrityStreams(test.options)
+		assert.Equal(t, test.expected, result, "input: %q", test.options)
+		assert.Equal(t, test.expectedErr, err != nil, "input: %q, error: %v", test.options, err)
+	}
+}
+
+func TestGetReservedBlocksPercentage(t *testing.T) {
+	tests := []struct {
+		options       map[string]string
+		expectedValue string
+		expectedError bool
+	}{
+		{nil, "", false},
+		{map[string]string{"fstype": "ext4"}, "", false},
+		{map[string]string{"reservedBlocksPercentage": "0"}, "0", false},
+		{map[string]string{"reservedblockspercentage": "0.5"}, "0.5", false},
+		{map[string]string{"reservedBlocksPercentage": "50"}, "50", false},
+		{map[string]string{"reservedBlocksPercentage": "51"}, "", true},
+		{map[string]string{"reservedBlocksPercentage": "-1"}, "", true},
+		{map[string]string{"reservedBlocksPercentage": "abc"}, "", true},
+	}
+
+	for _, test := range tests {
+		result, err := GetReservedBlocksPercentage(test.options)
+		assert.Equal(t, test.expectedError, err != nil, test.options)
+		assert.Equal(t, test.expectedValue, result, test.options)
+	}
+}
+
+func TestGetMkfsOptions(t *testing.T) {
+	tests := []struct {
+		options       map[string]string
+		fsType        string
+		expectedValue []string
+		expectedError bool
+	}{
+		{nil, "ext4", nil, false},
+		{map[string]string{"mkfsOptions": "-m 0  -T largefile4"}, "ext4", []string{"-m", "0", "-T", "largefile4"}, false},
+		{map[string]string{"mkfsoptions": "-K"}, "XFS", []string{"-K"}, false},
+		{map[string]string{"mkfsOptions": "-K"}, "", []string{"-K"}, false},
+		{map[string]string{"mkfsOptions": "-K"}, "ext4", nil, true},
+		{map[string]string{"mkfsOptions": "-n"}, "ext4", nil, true},
+		{map[string]string{"mkfsOptions": "- 0"}, "ext4", nil, true},
+		{map[string]string{"mkfsOptions": "-m 0"}, "ntfs", nil, true},
+	}
+
+	for _, test := range tests {
+		result, err := GetMkfsOptions(test.options, test.fsType)
+		assert.Equal(t, test.expectedError, err != nil, test.options)
+		assert.Equal(t, test.expectedValue, result, test.options)
+	}
+}
+
+func TestGetAllocationUnitSize(t *testing.T) {
+	tests := []struct {
+		options       map[string]string
+		fsType        string
+		expectedValue uint32
+		expectedError bool
+	}{
+		{nil, "ntfs", 0, false},
+		{map[string]string{"allocationUnitSize": "65536"}, "ntfs", 65536, false},
+		{map[string]string{"allocationunitsize": "512"}, "", 512, false},
+		{map[string]string{"allocationUnitSize": "4096"}, "ReFS", 4096, false},
+		{map[string]string{"allocationUnitSize": "8192"}, "refs", 0, true},
+		{map[string]string{"allocationUnitSize": "3000"}, "ntfs", 0, true},
+		{map[string]string{"allocationUnitSize": "4194304"}, "ntfs", 0, true},
+		{map[string]string{"allocationUnitSize": "abc"}, "ntfs", 0, true},
+		{map[string]string{"allocationUnitSize": "4096"}, "ext4", 0, true},
+	}
+
+	for _, test := range tests {
+		result, err := GetAllocationUnitSize(test.options, test.fsType)
+		assert.Equal(t, test.expectedError, err != nil, test.options)
+		assert.Equal(t, test.expectedValue, result, test.options)
+	}
+}
+
+func TestGetAllocationUnitSizeMountOption(t *testing.T) {
+	tests := []struct {
+		options       []string
+		expectedValue uint32
+		expectedError bool
+	}{
+		{nil, 0, false},
+		{[]string{"integritystreams"}, 0, false},
+		{[]string{"ro", "allocationunitsize=65536"}, 65536, false},
+		{[]string{"allocationunitsize=0"}, 0, true},
+		{[]string{"allocationunitsize=abc"}, 0, true},
+	}
+
+	for _, test := range tests {
+		result, err := GetAllocationUnitSizeMountOption(test.options)
+		assert.Equal(t, test.expectedError, err != nil, test.options)
+		assert.Equal(t, test.expectedValue, result, test.options)
+	}
+}
+
+func TestGetHostEncryption(t *testing.T) {
+	tests := []struct {
+		options       map[string]string
+		expectedValue bool
+		expectedError bool
+	}{
+		{nil, false, false},
+		{map[string]string{"fstype": "ext4"}, false, false},
+		{map[string]string{"hostEncryption": "true"}, true, false},
+		{map[string]string{"hostencryption": "false"}, false, false},
+		{map[string]string{"hostEncryption": "yes"}, false, true},
+	}
+
+	for _, test := range tests {
+		result, err := GetHostEncryption(test.options)
+		assert.Equal(t, test.expectedError, err != nil, test.options)
+		assert.Equal(t, test.expectedValue, result, test.options)
+	}
+}
+
+func TestGetLogicalSectorSize(t *testing.T) {
+	tests := []struct {
+		options       map[string]string
+		expectedValue int
+		expectedError bool
+	}{
+		{nil, 0, false},
+		{map[string]string{"fstype": "xfs"}, 0, false},
+		{map[string]string{"logicalSectorSize": "4096"}, 4096, false},
+		{map[string]string{"logicalsectorsize": "512"}, 512, false},
+		{map[string]string{"logicalSectorSize": "1024"}, 0, true},
+		{map[string]string{"logicalSectorSize": "abc"}, 0, true},
+	}
+
+	for _, test := range tests {
+		result, err := GetLogicalSectorSize(test.options)
+		assert.Equal(t, test.expectedError, err != nil, test.options)
+		assert.Equal(t, test.expectedValue, result, test.options)
+	}
+}
+
+func TestGetFsGroupChangePolicy(t *testing.T) {
+	tests := []struct {
+		options       map[string]string
+		expectedValue string
+		expectedError bool
+	}{
+		{nil, "Always", false},
+		{map[string]string{"fstype": "ext4"}, "Always", false},
+		{map[string]string{"fsGroupChangePolicy": "Always"}, "Always", false},
+		{map[string]string{"fsgroupchangepolicy": "onrootmismatch"}, "OnRootMismatch", false},
+		{map[string]string{"fsGroupChangePolicy": "none"}, "None", false},
+		{map[string]string{"fsGroupChangePolicy": ""}, "", true},
+		{map[string]string{"fsGroupChangePolicy": "invalid"}, "", true},
+	}
+
+	for _, test := range tests {
+		result, err := GetFsGroupChangePolicy(test.options)
+		assert.Equal(t, test.expectedError, err != nil, test.options)
+		assert.Equal(t, test.expectedValue, result, test.options)
+	}
+}
+
+func TestParseNodeClassValues(t *testing.T) {
+	tests := []struct {
+		value         string
+		expectedValue map[string]int64
+		expectedError bool
+	}{
+		{"", map[string]int64{}, false},
+		{"standby=100", map[string]int64{"standby": 100}, false},
+		{" standby = 100, primary=2000, ", map[string]int64{"standby": 100, "primary": 2000}, false},
+		{"standby", nil, true},
+		{"=100", nil, true},
+		{"standby=0", nil, true},
+		{"standby=abc", nil, true},
+	}
+
+	for _, test := range tests {
+		result, err := ParseNodeClassValues(test.value)
+		assert.Equal(t, test.expectedError, err != nil, test.value)
+		assert.Equal(t, test.expectedValue, result, test.value)
+	}
+}
+
 func TestGetMaxShares(t *testing.T) {
 	tests := []struct {
 		options       map[string]string
@@ -1148,6 +1380,79 @@ func TestNormalizeCachingMode(t *testing.T) {
 	}
 }
 
+func TestValidateLogicalSectorSize(t *testing.T) {
+	tests := []struct {
+		logicalSectorSize int
+		skuName           armcompute.DiskStorageAccountTypes
+		expectedErr       bool
+	}{
+		{0, armcompute.DiskStorageAccountTypesPremiumLRS, false},
+		{512, armcompute.DiskStorageAccountTypesUltraSSDLRS, false},
+		{4096, armcompute.DiskStorageAccountTypesUltraSSDLRS, false},
+		{4096, armcompute.DiskStorageAccountTypesPremiumV2LRS, false},
+		{4096, armcompute.DiskStorageAccountTypesPremiumLRS, true},
+		{512, armcompute.DiskStorageAccountTypesStandardSSDLRS, true},
+		{1024, armcompute.DiskStorageAccountTypesUltraSSDLRS, true},
+	}
+	for _, test := range tests {
+		err := ValidateLogicalSectorSize(test.logicalSectorSize, test.skuName)
+		assert.Equal(t, test.expectedErr, err != nil, "logicalSectorSize: %d, skuName: %s", test.logicalSectorSize, test.skuName)
+	}
+}
+
+func TestValidateDiskLifecycleFlags(t *testing.T) {
+	tests := []struct {
+		supportsHibernation        *bool
+		optimizedForFrequentAttach *bool
+		maxShares                  int
+		skuName                    armcompute.DiskStorageAccountTypes
+		expectedErr                bool
+	}{
+		{nil, nil, 2, armcompute.DiskStorageAccountTypesUltraSSDLRS, false},
+		{ptr.To(true), ptr.To(true), 1, armcompute.DiskStorageAccountTypesPremiumLRS, false},
+		{ptr.To(false), ptr.To(false), 2, armcompute.DiskStorageAccountTypesPremiumV2LRS, false},
+		{ptr.To(true), nil, 1, armcompute.DiskStorageAccountTypesUltraSSDLRS, true},
+		{ptr.To(true), nil, 1, armcompute.DiskStorageAccountTypesPremiumV2LRS, true},
+		{ptr.To(true), nil, 2, armcompute.DiskStorageAccountTypesPremiumLRS, true},
+		{nil, ptr.To(true), 2, armcompute.DiskStorageAccountTypesPremiumLRS, true},
+	}
+	for i, test := range tests {
+		err := ValidateDiskLifecycleFlags(test.supportsHibernation, test.optimizedForFrequentAttach, test.maxShares, test.skuName)
+		assert.Equal(t, test.expectedErr, err != nil, "TestCase[%d], err: %v", i, err)
+	}
+}
+
+func TestValidateDiskSecurityProfile(t *testing.T) {
+	desID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/diskEncryptionSets/des"
+	imageID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/image/versions/1.0.0"
+	tests := []struct {
+		securityType                string
+		secureVMDiskEncryptionSetID string
+		galleryImageReferenceID     string
+		galleryImageLun             *int32
+		expectedErr                 bool
+	}{
+		{"", "", "", nil, false},
+		{"", "", imageID, ptr.To(int32(0)), false},
+		{"TrustedLaunch", "", imageID, nil, false},
+		{"ConfidentialVM_DiskEncryptedWithPlatformKey", "", imageID, nil, false},
+		{"ConfidentialVM_DiskEncryptedWithCustomerKey", desID, imageID, nil, false},
+		{"TrustedLaunch", "", "", nil, true},
+		{"TrustedLaunch", "", imageID, ptr.To(int32(0)), true},
+		{"trustedLaunch", "", imageID, nil, true},
+		{"invalid", "", imageID, nil, true},
+		{"ConfidentialVM_DiskEncryptedWithCustomerKey", "", imageID, nil, true},
+		{"ConfidentialVM_DiskEncryptedWithPlatformKey", desID, imageID, nil, true},
+		{"", desID, "", nil, true},
+		{"ConfidentialVM_DiskEncryptedWithCustomerKey", "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk", imageID, nil, true},
+		{"ConfidentialVM_DiskEncryptedWithCustomerKey", "invalid", imageID, nil, true},
+	}
+	for i, test := range tests {
+		err := ValidateDiskSecurityProfile(test.securityType, test.secureVMDiskEncryptionSetID, test.galleryImageReferenceID, test.galleryImageLun)
+		assert.Equal(t, test.expectedErr, err != nil, "TestCase[%d], err: %v", i, err)
+	}
+}
+
 func TestValidateDiskEncryptionType(t *testing.T) {
 	tests := []struct {
 		diskEncryptionType string
@@ -1184,6 +1489,29 @@ func TestValidateDiskEncryptionType(t *testing.T) {
 	}
 }
 
+func TestValidateDiskEncryption(t *testing.T) {
+	desID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/diskEncryptionSets/des"
+	tests := []struct {
+		diskEncryptionType  string
+		diskEncryptionSetID string
+		expectedErr         bool
+	}{
+		{"", "", false},
+		{"", desID, false},
+		{"EncryptionAtRestWithPlatformKey", "", false},
+		{"EncryptionAtRestWithCustomerKey", desID, false},
+		{"EncryptionAtRestWithPlatformAndCustomerKeys", desID, false},
+		{"EncryptionAtRestWithPlatformKey", desID, true},
+		{"EncryptionAtRestWithCustomerKey", "", true},
+		{"EncryptionAtRestWithPlatformAndCustomerKeys", "", true},
+		{"invalid", desID, true},
+	}
+	for i, test := range tests {
+		err := ValidateDiskEncryption(test.diskEncryptionType, test.diskEncryptionSetID)
+		assert.Equal(t, test.expectedErr, err != nil, "TestCase[%d], err: %v", i, err)
+	}
+}
+
 func TestValidateDataAccessAuthMode(t *testing.T) {
 	tests := []struct {
 		dataAccessAuthMode string
@@ -1377,6 +1705,72 @@ func TestNormalizeStorageAccountType(t *testing.T) {
 	}
 }
 
+func TestParseCloudCredentials(t *testing.T) {
+	tests := []struct {
+		desc          string
+		secrets       map[string]string
+		expected      *CloudCredentials
+		expectedError error
+	}{
+		{
+			desc: "no credentials",
+		},
+		{
+			desc:    "unrelated secrets",
+			secrets: map[string]string{"foo": "bar"},
+		},
+		{
+			desc:     "client secret",
+			secrets:  map[string]string{"tenantID": "tenant", "clientID": "client", "clientSecret": "secret", "subscriptionID": "subs", "resourceGroup": "rg"},
+			expected: &CloudCredentials{TenantID: "tenant", ClientID: "client", ClientSecret: "secret", SubscriptionID: "subs", ResourceGroup: "rg"},
+		},
+		{
+			desc:     "federated token",
+			secrets:  map[string]string{"tenantID": "tenant", "clientID": "client", "federatedTokenFile": "/var/run/secrets/token"},
+			expected: &CloudCredentials{TenantID: "tenant", ClientID: "client", FederatedTokenFile: "/var/run/secrets/token"},
+		},
+		{
+			desc:          "no tenant",
+			secrets:       map[string]string{"clientID": "client", "clientSecret": "secret"},
+			expectedError: fmt.Errorf("tenantid and clientid must be set in the secrets"),
+		},
+		{
+			desc:          "both client secret and federated token",
+			secrets:       map[string]string{"tenantID": "tenant", "clientID": "client", "clientSecret": "secret", "federatedTokenFile": "/var/run/secrets/token"},
+			expectedError: fmt.Errorf("either clientsecret or federatedtokenfile must be set in the secrets"),
+		},
+	}
+	for _, test := range tests {
+		creds, err := ParseCloudCredentials(test.secrets)
+		assert.Equal(t, test.expected, creds, test.desc)
+		assert.Equal(t, test.expectedError, err, test.desc)
+	}
+}
+
+func TestCloudCredentialsApply(t *testing.T) {
+	config := &azure.Config{}
+	config.TenantID = "cluster-tenant"
+	config.AADClientID = "cluster-client"
+	config.AADClientCertPath = "/etc/kubernetes/cert"
+	config.UseManagedIdentityExtension = true
+	config.UserAssignedIdentityID = "identity"
+	config.SubscriptionID = "cluster-subs"
+	config.ResourceGroup = "cluster-rg"
+
+	creds := &CloudCredentials{TenantID: "tenant", ClientID: "client", FederatedTokenFile: "/var/run/secrets/token", SubscriptionID: "subs"}
+	creds.apply(config)
+	assert.Equal(t, "tenant", config.TenantID)
+	assert.Equal(t, "client", config.AADClientID)
+	assert.Equal(t, "", config.AADClientSecret)
+	assert.Equal(t, "", config.AADClientCertPath)
+	assert.Equal(t, "/var/run/secrets/token", config.AADFederatedTokenFile)
+	assert.True(t, config.UseFederatedWorkloadIdentityExtension)
+	assert.False(t, config.UseManagedIdentityExtension)
+	assert.Equal(t, "", config.UserAssignedIdentityID)
+	assert.Equal(t, "subs", config.SubscriptionID)
+	assert.Equal(t, "cluster-rg", config.ResourceGroup)
+}
+
 func TestParseDiskParameters(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -1424,6 +1818,68 @@ func TestParseDiskParameters(t *testing.T) {
 			},
 			expectedError: fmt.Errorf("parse invalidValue failed with error: strconv.Atoi: parsing \"invalidValue\": invalid syntax"),
 		},
+		{
+			name:        "invalid iopsLimit value in parameters",
+			inputParams: map[string]string{consts.IopsLimitField: "invalidValue"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.IopsLimitField: "invalidValue"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("parse iopslimit:invalidValue failed with error: strconv.ParseInt: parsing \"invalidValue\": invalid syntax"),
+		},
+		{
+			name:        "valid iopsLimit and bandwidthLimit in parameters",
+			inputParams: map[string]string{consts.IopsLimitField: "500", consts.BandwidthLimitField: "100"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.IopsLimitField: "500", consts.BandwidthLimitField: "100"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: nil,
+		},
+		{
+			name:        "invalid reservedBlocksPercentage in parameters",
+			inputParams: map[string]string{consts.ReservedBlocksPercentageField: "100"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.ReservedBlocksPercentageField: "100"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("invalid reservedblockspercentage: 100, should be a number between 0 and 50"),
+		},
+		{
+			name:        "valid reservedBlocksPercentage in parameters",
+			inputParams: map[string]string{consts.ReservedBlocksPercentageField: "1"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.ReservedBlocksPercentageField: "1"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: nil,
+		},
+		{
+			name:        "invalid fsGroupChangePolicy in parameters",
+			inputParams: map[string]string{consts.FsGroupChangePolicyField: "invalid"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.FsGroupChangePolicyField: "invalid"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("invalid fsgroupchangepolicy: invalid, supported values are Always, OnRootMismatch and None"),
+		},
+		{
+			name:        "skuFallback in parameters",
+			inputParams: map[string]string{consts.SkuNameField: "UltraSSD_LRS", consts.SkuFallbackField: "PremiumV2_LRS, Premium_LRS,"},
+			expectedOutput: ManagedDiskParameters{
+				AccountType:    "UltraSSD_LRS",
+				SkuFallback:    []string{"PremiumV2_LRS", "Premium_LRS"},
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.SkuNameField: "UltraSSD_LRS", consts.SkuFallbackField: "PremiumV2_LRS, Premium_LRS,"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: nil,
+		},
 		{
 			name:        "disk parameters with PremiumV2_LRS",
 			inputParams: map[string]string{consts.SkuNameField: "PremiumV2_LRS"},
@@ -1491,6 +1947,187 @@ func TestParseDiskParameters(t *testing.T) {
 			},
 			expectedError: fmt.Errorf("parse diskmbpsreadwrite:diskMBPSReadWrite failed with error: strconv.Atoi: parsing \"diskMBPSReadWrite\": invalid syntax"),
 		},
+		{
+			name:        "invalid NodeClassDiskIOPSField value in parameters",
+			inputParams: map[string]string{consts.NodeClassDiskIOPSField: "standby"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.NodeClassDiskIOPSField: "standby"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("parse nodeclassdiskiopsreadwrite failed with error: invalid node class value \"standby\", expected format is class=value"),
+		},
+		{
+			name:        "NodeClassDiskIOPSField without DiskIOPSReadWriteField in parameters",
+			inputParams: map[string]string{consts.NodeClassDiskIOPSField: "standby=100"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.NodeClassDiskIOPSField: "standby=100"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("diskiopsreadwrite must be set with nodeclassdiskiopsreadwrite"),
+		},
+		{
+			name: "resource group created if not exist",
+			inputParams: map[string]string{
+				consts.ResourceGroupField:            "team-rg",
+				consts.CreateResourceGroupIfNotExist: "true",
+				consts.ResourceGroupTagsField:        "team=storage,costcenter=1234",
+			},
+			expectedOutput: ManagedDiskParameters{
+				ResourceGroup:                 "team-rg",
+				CreateResourceGroupIfNotExist: true,
+				ResourceGroupTags:             map[string]string{"team": "storage", "costcenter": "1234"},
+				Tags:                          make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.ResourceGroupField:            "team-rg",
+					consts.CreateResourceGroupIfNotExist: "true",
+					consts.ResourceGroupTagsField:        "team=storage,costcenter=1234",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+		},
+		{
+			name:        "resource group created if not exist without resource group",
+			inputParams: map[string]string{consts.CreateResourceGroupIfNotExist: "true"},
+			expectedOutput: ManagedDiskParameters{
+				CreateResourceGroupIfNotExist: true,
+				Tags:                          make(map[string]string),
+				VolumeContext:                 map[string]string{consts.CreateResourceGroupIfNotExist: "true"},
+				DeviceSettings:                make(map[string]string),
+			},
+			expectedError: fmt.Errorf("resourcegroup must be set with createresourcegroupifnotexist"),
+		},
+		{
+			name: "hibernation and frequent attach flags",
+			inputParams: map[string]string{
+				consts.SupportsHibernationField:        "true",
+				consts.OptimizedForFrequentAttachField: "false",
+			},
+			expectedOutput: ManagedDiskParameters{
+				SupportsHibernation:        ptr.To(true),
+				OptimizedForFrequentAttach: ptr.To(false),
+				Tags:                       make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.SupportsHibernationField:        "true",
+					consts.OptimizedForFrequentAttachField: "false",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+		},
+		{
+			name: "azure monitor with workspace",
+			inputParams: map[string]string{
+				consts.EnableAzureMonitorField: "true",
+				consts.WorkspaceIDField:        "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+			},
+			expectedOutput: ManagedDiskParameters{
+				EnableAzureMonitor: true,
+				WorkspaceID:        "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+				Tags:               make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.EnableAzureMonitorField: "true",
+					consts.WorkspaceIDField:        "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+		},
+		{
+			name:        "azure monitor without workspace",
+			inputParams: map[string]string{consts.EnableAzureMonitorField: "true"},
+			expectedOutput: ManagedDiskParameters{
+				EnableAzureMonitor: true,
+				Tags:               make(map[string]string),
+				VolumeContext:      map[string]string{consts.EnableAzureMonitorField: "true"},
+				DeviceSettings:     make(map[string]string),
+			},
+			expectedError: fmt.Errorf("workspaceid must be set with enableazuremonitor"),
+		},
+		{
+			name:        "workspace of another resource type",
+			inputParams: map[string]string{consts.WorkspaceIDField: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.WorkspaceIDField: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("invalid workspaceid: /subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa in storage class, it must be the resource ID of a Log Analytics workspace"),
+		},
+		{
+			name: "confidential VM security profile",
+			inputParams: map[string]string{
+				"securityType":                "ConfidentialVM_DiskEncryptedWithCustomerKey",
+				"secureVMDiskEncryptionSetID": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/diskEncryptionSets/des",
+			},
+			expectedOutput: ManagedDiskParameters{
+				SecurityType:                "ConfidentialVM_DiskEncryptedWithCustomerKey",
+				SecureVMDiskEncryptionSetID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/diskEncryptionSets/des",
+				Tags:                        make(map[string]string),
+				VolumeContext: map[string]string{
+					"securityType":                "ConfidentialVM_DiskEncryptedWithCustomerKey",
+					"secureVMDiskEncryptionSetID": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/diskEncryptionSets/des",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+		},
+		{
+			name: "gallery image version with lun",
+			inputParams: map[string]string{
+				consts.GalleryImageReferenceIDField: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/dataset/versions/1.0.0",
+				consts.GalleryImageLunField:         "1",
+			},
+			expectedOutput: ManagedDiskParameters{
+				GalleryImageReferenceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/dataset/versions/1.0.0",
+				GalleryImageLun:         ptr.To(int32(1)),
+				Tags:                    make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.GalleryImageReferenceIDField: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/dataset/versions/1.0.0",
+					consts.GalleryImageLunField:         "1",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+		},
+		{
+			name:        "gallery image of another resource type",
+			inputParams: map[string]string{consts.GalleryImageReferenceIDField: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/image"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.GalleryImageReferenceIDField: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/image"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("invalid galleryimagereferenceid: /subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/image in storage class, it must be the resource ID of a gallery image version"),
+		},
+		{
+			name:        "invalid gallery image lun",
+			inputParams: map[string]string{consts.GalleryImageLunField: "-1"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.GalleryImageLunField: "-1"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("invalid galleryimagelun: -1 in storage class, it must be a non-negative integer"),
+		},
+		{
+			name:        "gallery image lun without gallery image",
+			inputParams: map[string]string{consts.GalleryImageLunField: "0"},
+			expectedOutput: ManagedDiskParameters{
+				GalleryImageLun: ptr.To(int32(0)),
+				Tags:            make(map[string]string),
+				VolumeContext:   map[string]string{consts.GalleryImageLunField: "0"},
+				DeviceSettings:  make(map[string]string),
+			},
+			expectedError: fmt.Errorf("galleryimagereferenceid must be set with galleryimagelun"),
+		},
+		{
+			name:        "invalid supportsHibernation",
+			inputParams: map[string]string{consts.SupportsHibernationField: "yes please"},
+			expectedOutput: ManagedDiskParameters{
+				Tags:           make(map[string]string),
+				VolumeContext:  map[string]string{consts.SupportsHibernationField: "yes please"},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("invalid supportshibernation: yes please in storage class"),
+		},
 		{
 			name: "valid parameters input",
 			inputParams: map[string]string{
@@ -1502,6 +2139,7 @@ func TestParseDiskParameters(t *testing.T) {
 				consts.DiskMBPSReadWriteField:   "1000",
 				consts.LogicalSectorSizeField:   "1",
 				consts.DiskNameField:            "diskName",
+				consts.DiskPoolField:            "diskPool",
 				consts.DesIDField:               "diskEncyptionSetID",
 				consts.TagsField:                "key0=value0, key1=value1",
 				consts.WriteAcceleratorEnabled:  "writeAcceleratorEnabled",
@@ -1527,6 +2165,7 @@ func TestParseDiskParameters(t *testing.T) {
 				DiskIOPSReadWrite:   "4000",
 				DiskMBPSReadWrite:   "1000",
 				DiskName:            "diskName",
+				DiskPool:            "diskPool",
 				DiskEncryptionSetID: "diskEncyptionSetID",
 				Tags: map[string]string{
 					consts.PvcNameTag:      "pvcName",
@@ -1551,6 +2190,7 @@ func TestParseDiskParameters(t *testing.T) {
 					consts.DiskMBPSReadWriteField:   "1000",
 					consts.LogicalSectorSizeField:   "1",
 					consts.DiskNameField:            "diskName",
+					consts.DiskPoolField:            "diskPool",
 					consts.DesIDField:               "diskEncyptionSetID",
 					consts.TagsField:                "key0=value0, key1=value1",
 					consts.WriteAcceleratorEnabled:  "writeAcceleratorEnabled",
@@ -1585,6 +2225,39 @@ func TestParseDiskParameters(t *testing.T) {
 	}
 }
 
+func TestGetRequestedAvailabilityZone(t *testing.T) {
+	requirement := &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{
+			{Segments: map[string]string{consts.WellKnownTopologyKey: "eastus-1"}},
+			{Segments: map[string]string{"topology.disk.csi.azure.com/zone": "eastus-2"}},
+		},
+	}
+	tests := []struct {
+		desc         string
+		zone         string
+		requirement  *csi.TopologyRequirement
+		region       string
+		expectedZone string
+		expectErr    bool
+	}{
+		{desc: "zone without requisite topologies", zone: "eastus-3", region: "eastus", expectedZone: "eastus-3"},
+		{desc: "zone id prefixed with region", zone: " 1 ", requirement: requirement, region: "EastUS", expectedZone: "eastus-1"},
+		{desc: "zone in topology key of the driver", zone: "EastUS-2", requirement: requirement, region: "eastus", expectedZone: "eastus-2"},
+		{desc: "zone not in requisite topologies", zone: "eastus-3", requirement: requirement, region: "eastus", expectErr: true},
+		{desc: "zone of another region", zone: "westus-1", region: "eastus", expectErr: true},
+		{desc: "zone id without region", zone: "1", expectErr: true},
+	}
+	for _, test := range tests {
+		zone, err := GetRequestedAvailabilityZone(test.zone, test.requirement, test.region, "topology.disk.csi.azure.com/zone")
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expectedZone, zone, test.desc)
+	}
+}
+
 func TestPickAvailabilityZone(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -1777,6 +2450,52 @@ func TestInsertDiskProperties(t *testing.T) {
 	}
 }
 
+func TestGetDiskPropertiesAnnotations(t *testing.T) {
+	tests := []struct {
+		desc     string
+		disk     *armcompute.Disk
+		expected map[string]string
+	}{
+		{
+			desc:     "nil pointer",
+			expected: map[string]string{},
+		},
+		{
+			desc:     "empty",
+			disk:     &armcompute.Disk{},
+			expected: map[string]string{},
+		},
+		{
+			desc: "all properties",
+			disk: &armcompute.Disk{
+				SKU:   &armcompute.DiskSKU{Name: to.Ptr(armcompute.DiskStorageAccountTypesPremiumLRS)},
+				Zones: []*string{ptr.To("1"), ptr.To("2")},
+				Properties: &armcompute.DiskProperties{
+					Tier:            ptr.To("P30"),
+					BurstingEnabled: ptr.To(true),
+					CreationData: &armcompute.CreationData{
+						LogicalSectorSize: ptr.To(int32(4096)),
+					},
+					Encryption: &armcompute.Encryption{Type: to.Ptr(armcompute.EncryptionTypeEncryptionAtRestWithCustomerKey)},
+				},
+			},
+			expected: map[string]string{
+				consts.DiskSkuAnnotation:               string(armcompute.DiskStorageAccountTypesPremiumLRS),
+				consts.DiskZonesAnnotation:             "1,2",
+				consts.DiskTierAnnotation:              "P30",
+				consts.DiskBurstingEnabledAnnotation:   "true",
+				consts.DiskLogicalSectorSizeAnnotation: "4096",
+				consts.DiskEncryptionTypeAnnotation:    string(armcompute.EncryptionTypeEncryptionAtRestWithCustomerKey),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result := GetDiskPropertiesAnnotations(test.disk)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
 func TestSleepIfThrottled(t *testing.T) {
 	const sleepDuration = 1 * time.Second
 
@@ -1985,6 +2704,85 @@ func TestIsThrottlingError(t *testing.T) {
 	}
 }
 
+func TestIsSkuNotAvailableError(t *testing.T) {
+	tests := []struct {
+		desc     string
+		err      error
+		expected bool
+	}{
+		{
+			desc:     "nil error",
+			err:      nil,
+			expected: false,
+		},
+		{
+			desc:     "no match",
+			err:      errors.New("no match"),
+			expected: false,
+		},
+		{
+			desc:     "match SkuNotAvailable error code",
+			err:      errors.New("Code=\"SkuNotAvailable\" Message=\"The requested size for resource is currently not available in location 'eastus' zones '3'\""),
+			expected: true,
+		},
+		{
+			desc:     "match sku not supported in zone",
+			err:      errors.New("Code=\"InvalidParameter\" Message=\"Disks with storage account type UltraSSD_LRS are not supported in zone 3 of region eastus\""),
+			expected: true,
+		},
+		{
+			desc:     "match sku is not supported in region",
+			err:      errors.New("Code=\"BadRequest\" Message=\"Storage account type UltraSSD_LRS is not supported in region westus\""),
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		result := IsSkuNotAvailableError(test.err)
+		if result != test.expected {
+			t.Errorf("desc: (%s), input: err(%v), IsSkuNotAvailableError returned with bool(%t), not equal to expected(%t)",
+				test.desc, test.err, result, test.expected)
+		}
+	}
+}
+
+func TestIsQuotaExceededError(t *testing.T) {
+	tests := []struct {
+		desc     string
+		err      error
+		expected bool
+	}{
+		{
+			desc:     "nil error",
+			err:      nil,
+			expected: false,
+		},
+		{
+			desc:     "no match",
+			err:      errors.New("no match"),
+			expected: false,
+		},
+		{
+			desc:     "match disk quota error",
+			err:      errors.New("Code=\"OperationNotAllowed\" Message=\"Operation could not be completed as it results in exceeding approved PremiumDiskCount quota\""),
+			expected: true,
+		},
+		{
+			desc:     "match QuotaExceeded error code",
+			err:      errors.New("Code=\"QuotaExceeded\" Message=\"quota exceeded\""),
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		result := IsQuotaExceededError(test.err)
+		if result != test.expected {
+			t.Errorf("desc: (%s), input: err(%v), IsQuotaExceededError returned with bool(%t), not equal to expected(%t)",
+				test.desc, test.err, result, test.expected)
+		}
+	}
+}
+
 func TestGenerateVolumeName(t *testing.T) {
 	// Normal operation, no truncate
 	v1 := GenerateVolumeName("kubernetes", "pv-cinder-abcde", 255)
@@ -2005,3 +2803,62 @@ func TestGenerateVolumeName(t *testing.T) {
 		t.Errorf("Expected %s, got %s", expect, v3)
 	}
 }
+
+func TestClientRateLimitOptionsApply(t *testing.T) {
+	config := &azure.Config{}
+	config.DiskRateLimit = &azclients.RateLimitConfig{CloudProviderRateLimit: false}
+	config.VirtualMachineRateLimit = &azclients.RateLimitConfig{CloudProviderRateLimit: true, CloudProviderRateLimitQPS: 3}
+
+	var nilOptions *ClientRateLimitOptions
+	nilOptions.apply(config)
+	assert.False(t, config.DiskRateLimit.CloudProviderRateLimit)
+
+	options := &ClientRateLimitOptions{
+		DiskQPS:     20,
+		DiskBurst:   40,
+		VMSSQPS:     5,
+		SnapshotQPS: 0,
+	}
+	options.apply(config)
+	assert.Equal(t, &azclients.RateLimitConfig{
+		CloudProviderRateLimit:            true,
+		CloudProviderRateLimitQPS:         20,
+		CloudProviderRateLimitBucket:      40,
+		CloudProviderRateLimitQPSWrite:    20,
+		CloudProviderRateLimitBucketWrite: 40,
+	}, config.DiskRateLimit)
+	assert.Equal(t, &azclients.RateLimitConfig{CloudProviderRateLimit: true, CloudProviderRateLimitQPS: 3}, config.VirtualMachineRateLimit)
+	assert.Equal(t, &azclients.RateLimitConfig{
+		CloudProviderRateLimit:         true,
+		CloudProviderRateLimitQPS:      5,
+		CloudProviderRateLimitQPSWrite: 5,
+	}, config.VirtualMachineScaleSetRateLimit)
+	assert.Nil(t, config.SnapshotRateLimit)
+}
+
+func TestClientFactoryRateLimitConfig(t *testing.T) {
+	config := &azure.Config{}
+	config.DiskRateLimit = &azclients.RateLimitConfig{
+		CloudProviderRateLimit:            true,
+		CloudProviderRateLimitQPS:         20,
+		CloudProviderRateLimitBucket:      5,
+		CloudProviderRateLimitQPSWrite:    20,
+		CloudProviderRateLimitBucketWrite: 5,
+	}
+	config.VirtualMachineRateLimit = &azclients.RateLimitConfig{CloudProviderRateLimit: true, CloudProviderRateLimitQPS: 3}
+
+	assert.Nil(t, (&ClientRateLimitOptions{}).clientFactoryRateLimitConfig(config))
+
+	// only the clients whose QPS is set are limited, with the rate limits of the initialized config
+	rateLimitConfig := (&ClientRateLimitOptions{DiskQPS: 20, SnapshotQPS: 10}).clientFactoryRateLimitConfig(config)
+	assert.Equal(t, map[string]*ratelimit.Config{
+		"diskRateLimit": {
+			CloudProviderRateLimit:            true,
+			CloudProviderRateLimitQPS:         20,
+			CloudProviderRateLimitBucket:      5,
+			CloudProviderRateLimitQPSWrite:    20,
+			CloudProviderRateLimitBucketWrite: 5,
+		},
+	}, rateLimitConfig.Entries)
+	assert.False(t, rateLimitConfig.GetRateLimitConfig("virtualMachineRateLimit").CloudProviderRateLimit)
+}
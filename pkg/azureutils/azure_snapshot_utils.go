@@ -26,6 +26,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/utils/ptr"
 	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
 )
 
@@ -43,6 +44,10 @@ func GenerateCSISnapshot(sourceVolumeID string, snapshot *armcompute.Snapshot) (
 	}
 
 	ready, _ := isCSISnapshotReady(*snapshot.Properties.ProvisioningState)
+	if ready && !IsSnapshotCopyCompleted(snapshot) {
+		// the snapshot created by CopyStart is provisioned before its data is copied in background
+		ready = false
+	}
 	if sourceVolumeID == "" {
 		sourceVolumeID = GetSourceVolumeID(snapshot)
 	}
@@ -56,6 +61,27 @@ func GenerateCSISnapshot(sourceVolumeID string, snapshot *armcompute.Snapshot) (
 	}, nil
 }
 
+// IsSnapshotCopyCompleted returns whether the background copy of a snapshot created by CopyStart is completed,
+// true is returned for the snapshots not created by CopyStart
+func IsSnapshotCopyCompleted(snapshot *armcompute.Snapshot) bool {
+	if snapshot == nil || snapshot.Properties == nil || snapshot.Properties.CreationData == nil ||
+		!strings.EqualFold(string(ptr.Deref(snapshot.Properties.CreationData.CreateOption, "")), string(armcompute.DiskCreateOptionCopyStart)) {
+		return true
+	}
+	if snapshot.Properties.CopyCompletionError != nil {
+		return false
+	}
+	return snapshot.Properties.CompletionPercent == nil || *snapshot.Properties.CompletionPercent >= 100.0
+}
+
+// GetCopyCompletionErrorMessage returns the error message of a failed CopyStart copy
+func GetCopyCompletionErrorMessage(copyErr *armcompute.CopyCompletionError) string {
+	if copyErr == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s", ptr.Deref(copyErr.ErrorCode, ""), ptr.Deref(copyErr.ErrorMessage, ""))
+}
+
 // There are 4 scenarios for listing snapshots.
 // 1. StartingToken is null, and MaxEntries is null. Return all snapshots from zero.
 // 2. StartingToken is null, and MaxEntries is not null. Return `MaxEntries` snapshots from zero.
@@ -22,6 +22,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
@@ -97,6 +98,30 @@ func TestGenerateCSISnapshot(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "copy of snapshot in progress",
+			testFunc: func(t *testing.T) {
+				provisioningState := "succeeded"
+				DiskSize := int32(10)
+				snapshotID := "test"
+				completionPercent := float32(40)
+				snapshot := &armcompute.Snapshot{
+					Properties: &armcompute.SnapshotProperties{
+						TimeCreated:       &time.Time{},
+						ProvisioningState: &provisioningState,
+						DiskSizeGB:        &DiskSize,
+						CreationData: &armcompute.CreationData{
+							CreateOption: to.Ptr(armcompute.DiskCreateOptionCopyStart),
+						},
+						CompletionPercent: &completionPercent,
+					},
+					ID: &snapshotID,
+				}
+				response, err := GenerateCSISnapshot("unit-test", snapshot)
+				assert.NoError(t, err)
+				assert.False(t, response.ReadyToUse)
+			},
+		},
 		{
 			name: "sourceVolumeID property is missed",
 			testFunc: func(t *testing.T) {
@@ -312,3 +337,53 @@ func TestIsCSISnapshotReady(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+func TestIsSnapshotCopyCompleted(t *testing.T) {
+	copyStart := armcompute.DiskCreateOptionCopyStart
+	copyOption := armcompute.DiskCreateOptionCopy
+	percent := func(p float32) *float32 { return &p }
+	tests := []struct {
+		desc     string
+		snapshot *armcompute.Snapshot
+		expected bool
+	}{
+		{
+			desc:     "nil snapshot",
+			expected: true,
+		},
+		{
+			desc:     "snapshot not created by CopyStart",
+			snapshot: &armcompute.Snapshot{Properties: &armcompute.SnapshotProperties{CreationData: &armcompute.CreationData{CreateOption: &copyOption}, CompletionPercent: percent(10)}},
+			expected: true,
+		},
+		{
+			desc:     "copy in progress",
+			snapshot: &armcompute.Snapshot{Properties: &armcompute.SnapshotProperties{CreationData: &armcompute.CreationData{CreateOption: &copyStart}, CompletionPercent: percent(10)}},
+			expected: false,
+		},
+		{
+			desc:     "copy completed",
+			snapshot: &armcompute.Snapshot{Properties: &armcompute.SnapshotProperties{CreationData: &armcompute.CreationData{CreateOption: &copyStart}, CompletionPercent: percent(100)}},
+			expected: true,
+		},
+		{
+			desc: "copy failed",
+			snapshot: &armcompute.Snapshot{Properties: &armcompute.SnapshotProperties{
+				CreationData:        &armcompute.CreationData{CreateOption: &copyStart},
+				CompletionPercent:   percent(100),
+				CopyCompletionError: &armcompute.CopyCompletionError{},
+			}},
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, IsSnapshotCopyCompleted(test.snapshot), test.desc)
+	}
+}
+
+func TestGetCopyCompletionErrorMessage(t *testing.T) {
+	assert.Equal(t, "", GetCopyCompletionErrorMessage(nil))
+	reason := armcompute.CopyCompletionErrorReasonCopySourceNotFound
+	message := "source snapshot was deleted"
+	assert.Equal(t, "CopySourceNotFound: source snapshot was deleted", GetCopyCompletionErrorMessage(&armcompute.CopyCompletionError{ErrorCode: &reason, ErrorMessage: &message}))
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/policy/ratelimit"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/policy/retryrepectthrottled"
+	azclients "sigs.k8s.io/cloud-provider-azure/pkg/azureclients"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// throttlingBaseBackoff is the duration a circuit breaker is first opened for if ARM returns no Retry-After
+const throttlingBaseBackoff = 10 * time.Second
+
+var (
+	// ErrCircuitBreakerOpen is returned without sending the request while the circuit breaker of its API is open,
+	// it wraps the throttling error of the Azure clients
+	ErrCircuitBreakerOpen = fmt.Errorf("%w: circuit breaker is open", retryrepectthrottled.ErrTooManyRequest)
+
+	armThrottledRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      consts.AzureDiskCSIDriverName,
+			Name:           "arm_throttled_requests_total",
+			Help:           "Number of ARM requests throttled with a 429 response",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"subscription", "api"},
+	)
+	armCircuitBreakerOpen = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      consts.AzureDiskCSIDriverName,
+			Name:           "arm_circuit_breaker_open",
+			Help:           "Whether the circuit breaker of an ARM API is open, i.e. its requests fail without being sent",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"subscription", "api"},
+	)
+	armCircuitBreakerRejectedRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      consts.AzureDiskCSIDriverName,
+			Name:           "arm_circuit_breaker_rejected_requests_total",
+			Help:           "Number of ARM requests failed without being sent since the circuit breaker of their API is open",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"subscription", "api"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(armThrottledRequests)
+	legacyregistry.MustRegister(armCircuitBreakerOpen)
+	legacyregistry.MustRegister(armCircuitBreakerRejectedRequests)
+}
+
+// armAPI identifies the throttling bucket of ARM requests, i.e. the reads or writes of a resource type in a subscription
+type armAPI struct {
+	subscription string
+	api          string
+}
+
+// circuitBreaker is the throttling state of an ARM API
+type circuitBreaker struct {
+	// throttled is the number of consecutive throttled responses
+	throttled int
+	// trips is the number of consecutive times the breaker is opened, the backoff doubles on each trip
+	trips     int
+	openUntil time.Time
+}
+
+// ThrottlingPolicy is an ARM client policy shared by all Azure clients of the driver, it opens a circuit breaker per
+// subscription and API after threshold consecutive throttled responses, so that the requests of the API fail without
+// being sent until the Retry-After of the last response, or an exponential backoff up to maxBackoff, is over
+type ThrottlingPolicy struct {
+	threshold  int
+	maxBackoff time.Duration
+	now        func() time.Time
+
+	mu       sync.Mutex
+	breakers map[armAPI]*circuitBreaker
+}
+
+// NewThrottlingPolicy returns a throttling policy opening the circuit breaker of an API after threshold consecutive
+// throttled responses, nil is returned if threshold is not positive
+func NewThrottlingPolicy(threshold int, maxBackoff time.Duration) *ThrottlingPolicy {
+	if threshold <= 0 {
+		return nil
+	}
+	if maxBackoff < throttlingBaseBackoff {
+		maxBackoff = throttlingBaseBackoff
+	}
+	return &ThrottlingPolicy{
+		threshold:  threshold,
+		maxBackoff: maxBackoff,
+		now:        time.Now,
+		breakers:   map[armAPI]*circuitBreaker{},
+	}
+}
+
+// Do implements policy.Policy
+func (p *ThrottlingPolicy) Do(req *policy.Request) (*http.Response, error) {
+	api := getARMAPI(req.Raw())
+	if openUntil, ok := p.isOpen(api); ok {
+		armCircuitBreakerRejectedRequests.WithLabelValues(api.subscription, api.api).Inc()
+		return nil, fmt.Errorf("%w, %s requests in subscription %s are throttled until %s", ErrCircuitBreakerOpen, api.api, api.subscription, openUntil.Format(time.RFC3339))
+	}
+	resp, err := req.Next()
+	switch {
+	case errors.Is(err, retryrepectthrottled.ErrTooManyRequest) || (resp != nil && resp.StatusCode == http.StatusTooManyRequests):
+		p.recordThrottled(api, resp)
+	case resp != nil:
+		p.recordSuccess(api)
+	}
+	return resp, err
+}
+
+// isOpen returns whether the circuit breaker of api is open and until when
+func (p *ThrottlingPolicy) isOpen(api armAPI) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	breaker, ok := p.breakers[api]
+	if !ok || breaker.openUntil.IsZero() {
+		return time.Time{}, false
+	}
+	if p.now().Before(breaker.openUntil) {
+		return breaker.openUntil, true
+	}
+	// let the requests through, the breaker is opened again by the next throttled response
+	breaker.openUntil = time.Time{}
+	armCircuitBreakerOpen.WithLabelValues(api.subscription, api.api).Set(0)
+	return time.Time{}, false
+}
+
+func (p *ThrottlingPolicy) recordThrottled(api armAPI, resp *http.Response) {
+	armThrottledRequests.WithLabelValues(api.subscription, api.api).Inc()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	breaker, ok := p.breakers[api]
+	if !ok {
+		breaker = &circuitBreaker{}
+		p.breakers[api] = breaker
+	}
+	breaker.throttled++
+	if breaker.throttled < p.threshold {
+		return
+	}
+	breaker.trips++
+	backoff := p.maxBackoff
+	if breaker.trips <= 16 {
+		backoff = min(throttlingBaseBackoff<<(breaker.trips-1), p.maxBackoff)
+	}
+	if retryAfter := getRetryAfter(resp, p.now()); retryAfter > backoff {
+		backoff = retryAfter
+	}
+	breaker.openUntil = p.now().Add(backoff)
+	armCircuitBreakerOpen.WithLabelValues(api.subscription, api.api).Set(1)
+	klog.Warningf("opening the circuit breaker of %s requests in subscription %s for %v after %d consecutive throttled responses",
+		api.api, api.subscription, backoff, breaker.throttled)
+}
+
+func (p *ThrottlingPolicy) recordSuccess(api armAPI) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.breakers[api]; ok {
+		delete(p.breakers, api)
+		armCircuitBreakerOpen.WithLabelValues(api.subscription, api.api).Set(0)
+	}
+}
+
+// getARMAPI returns the API of an ARM request, e.g. the writes of microsoft.compute/disks, the reads and writes are
+// throttled separately by ARM
+func getARMAPI(req *http.Request) armAPI {
+	segments := []string{}
+	for _, segment := range strings.Split(strings.ToLower(req.URL.Path), "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	api := armAPI{}
+	types := []string{}
+	for i := 0; i < len(segments); i++ {
+		switch {
+		case segments[i] == "subscriptions" && i+1 < len(segments) && api.subscription == "":
+			api.subscription = segments[i+1]
+		case segments[i] == "providers" && i+1 < len(segments):
+			// the resource types after the last provider namespace, e.g. virtualmachinescalesets/virtualmachines
+			types = []string{segments[i+1]}
+		default:
+			types = append(types, segments[i])
+		}
+		// skip the name of the resource or provider namespace
+		i++
+	}
+	api.api = strings.Join(types, "/")
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		api.api += " read"
+	} else {
+		api.api += " write"
+	}
+	return api
+}
+
+// getRetryAfter returns the duration in the Retry-After header of resp, 0 if not set
+func getRetryAfter(resp *http.Response, now time.Time) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	value := resp.Header.Get(retryrepectthrottled.HeaderRetryAfter)
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// applyToClientFactory recreates the compute client factory of az with the throttling policy and the client rate
+// limits, the cloud provider creates the factory without them. The clients of the cloud provider not created by the
+// factory are not throttled by the policy.
+func (o *ClientRateLimitOptions) applyToClientFactory(az *azure.Cloud) error {
+	if o == nil || az.ComputeClientFactory == nil || az.AuthProvider == nil {
+		return nil
+	}
+	rateLimitConfig := o.clientFactoryRateLimitConfig(&az.Config)
+	if o.ThrottlingPolicy == nil && rateLimitConfig == nil {
+		return nil
+	}
+	cred := az.AuthProvider.GetAzIdentity()
+	if az.AuthProvider.IsMultiTenantModeEnabled() {
+		cred = az.AuthProvider.GetMultiTenantIdentity()
+	}
+	factoryConfig := &azclient.ClientFactoryConfig{SubscriptionID: az.SubscriptionID}
+	if rateLimitConfig != nil {
+		factoryConfig.CloudProviderRateLimitConfig = *rateLimitConfig
+	}
+	factory, err := azclient.NewClientFactory(factoryConfig, &az.ARMClientConfig, cred, func(option *arm.ClientOptions) {
+		if o.ThrottlingPolicy != nil {
+			option.PerCallPolicies = append(option.PerCallPolicies, o.ThrottlingPolicy)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	az.ComputeClientFactory = factory
+	return nil
+}
+
+// clientFactoryRateLimitConfig returns the rate limits of the clients of the compute client factory whose QPS is set,
+// they are the rate limits of the same clients in the initialized config, i.e. with the default bucket size of the
+// config if the burst is not set. nil is returned if no QPS is set.
+func (o *ClientRateLimitOptions) clientFactoryRateLimitConfig(config *azure.Config) *ratelimit.CloudProviderRateLimitConfig {
+	rateLimitConfig := ratelimit.NewCloudProviderRateLimitConfig()
+	for name, limit := range map[string]struct {
+		qps    float32
+		config *azclients.RateLimitConfig
+	}{
+		"diskRateLimit":                   {o.DiskQPS, config.DiskRateLimit},
+		"snapshotRateLimit":               {o.SnapshotQPS, config.SnapshotRateLimit},
+		"virtualMachineRateLimit":         {o.VMQPS, config.VirtualMachineRateLimit},
+		"virtualMachineScaleSetRateLimit": {o.VMSSQPS, config.VirtualMachineScaleSetRateLimit},
+	} {
+		if limit.qps <= 0 || limit.config == nil {
+			continue
+		}
+		rateLimitConfig.Entries[name] = &ratelimit.Config{
+			CloudProviderRateLimit:            limit.config.CloudProviderRateLimit,
+			CloudProviderRateLimitQPS:         limit.config.CloudProviderRateLimitQPS,
+			CloudProviderRateLimitBucket:      limit.config.CloudProviderRateLimitBucket,
+			CloudProviderRateLimitQPSWrite:    limit.config.CloudProviderRateLimitQPSWrite,
+			CloudProviderRateLimitBucketWrite: limit.config.CloudProviderRateLimitBucketWrite,
+		}
+	}
+	if len(rateLimitConfig.Entries) == 0 {
+		return nil
+	}
+	return rateLimitConfig
+}
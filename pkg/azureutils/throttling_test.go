@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/policy/retryrepectthrottled"
+)
+
+// fakeTransport returns the responses of statusCodes in order, the last one is repeated
+type fakeTransport struct {
+	statusCodes []int
+	retryAfter  string
+	requests    int
+}
+
+func (t *fakeTransport) Do(req *http.Request) (*http.Response, error) {
+	statusCode := t.statusCodes[min(t.requests, len(t.statusCodes)-1)]
+	t.requests++
+	resp := &http.Response{StatusCode: statusCode, Header: http.Header{}, Body: http.NoBody, Request: req}
+	if statusCode == http.StatusTooManyRequests && t.retryAfter != "" {
+		resp.Header.Set(retryrepectthrottled.HeaderRetryAfter, t.retryAfter)
+	}
+	return resp, nil
+}
+
+func TestNewThrottlingPolicy(t *testing.T) {
+	assert.Nil(t, NewThrottlingPolicy(0, time.Minute))
+	p := NewThrottlingPolicy(3, time.Second)
+	require.NotNil(t, p)
+	assert.Equal(t, throttlingBaseBackoff, p.maxBackoff)
+}
+
+func TestGetARMAPI(t *testing.T) {
+	tests := []struct {
+		method   string
+		path     string
+		expected armAPI
+	}{
+		{
+			method:   http.MethodPut,
+			path:     "/subscriptions/SUB/resourceGroups/rg/providers/Microsoft.Compute/disks/disk",
+			expected: armAPI{subscription: "sub", api: "microsoft.compute/disks write"},
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines/0",
+			expected: armAPI{subscription: "sub", api: "microsoft.compute/virtualmachinescalesets/virtualmachines read"},
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/subscriptions/sub/providers/Microsoft.Compute/locations/eastus/operations/id",
+			expected: armAPI{subscription: "sub", api: "microsoft.compute/locations/operations read"},
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks",
+			expected: armAPI{subscription: "sub", api: "microsoft.compute/disks read"},
+		},
+		{
+			method:   http.MethodHead,
+			path:     "/subscriptions/sub/resourcegroups/rg",
+			expected: armAPI{subscription: "sub", api: "resourcegroups read"},
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, "https://management.azure.com"+test.path+"?api-version=2023-01-02", nil)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, getARMAPI(req), test.path)
+	}
+}
+
+func TestGetRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newResp := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{retryrepectthrottled.HeaderRetryAfter: []string{value}}}
+	}
+	assert.Equal(t, time.Duration(0), getRetryAfter(nil, now))
+	assert.Equal(t, time.Duration(0), getRetryAfter(newResp(""), now))
+	assert.Equal(t, 30*time.Second, getRetryAfter(newResp("30"), now))
+	assert.Equal(t, time.Minute, getRetryAfter(newResp(now.Add(time.Minute).Format(http.TimeFormat)), now))
+	assert.Equal(t, time.Duration(0), getRetryAfter(newResp(now.Add(-time.Minute).Format(http.TimeFormat)), now))
+}
+
+func TestThrottlingPolicy(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := NewThrottlingPolicy(2, time.Minute)
+	p.now = func() time.Time { return now }
+	transport := &fakeTransport{statusCodes: []int{http.StatusTooManyRequests}}
+	pipeline := runtime.NewPipeline("test", "v1.0.0", runtime.PipelineOptions{PerCall: []policy.Policy{p}},
+		&policy.ClientOptions{Transport: transport, Retry: policy.RetryOptions{MaxRetries: -1}})
+	send := func(path string) error {
+		req, err := runtime.NewRequest(context.Background(), http.MethodPut, "https://management.azure.com"+path)
+		require.NoError(t, err)
+		_, err = pipeline.Do(req)
+		return err
+	}
+	diskPath := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk"
+	snapshotPath := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/snapshots/snapshot"
+
+	// the breaker is opened after 2 throttled responses
+	assert.NoError(t, send(diskPath))
+	assert.NoError(t, send(diskPath))
+	err := send(diskPath)
+	assert.True(t, errors.Is(err, ErrCircuitBreakerOpen), "%v", err)
+	assert.True(t, errors.Is(err, retryrepectthrottled.ErrTooManyRequest))
+	assert.Equal(t, 2, transport.requests)
+	// other APIs are not affected
+	assert.NoError(t, send(snapshotPath))
+	assert.Equal(t, 3, transport.requests)
+
+	// the backoff doubles if the API is still throttled once the breaker is closed
+	now = now.Add(throttlingBaseBackoff)
+	assert.NoError(t, send(diskPath))
+	now = now.Add(throttlingBaseBackoff)
+	assert.True(t, errors.Is(send(diskPath), ErrCircuitBreakerOpen))
+	now = now.Add(throttlingBaseBackoff)
+	transport.statusCodes = []int{http.StatusOK}
+	assert.NoError(t, send(diskPath))
+	assert.Equal(t, 5, transport.requests)
+
+	// a longer Retry-After is honored and the breaker is reset by a successful response
+	transport.statusCodes, transport.retryAfter = []int{http.StatusTooManyRequests}, "120"
+	assert.NoError(t, send(diskPath))
+	assert.NoError(t, send(diskPath))
+	now = now.Add(time.Minute)
+	err = send(diskPath)
+	assert.True(t, errors.Is(err, ErrCircuitBreakerOpen))
+	assert.True(t, strings.Contains(err.Error(), "microsoft.compute/disks write requests in subscription sub are throttled until 2024-01-01T00:02:30Z"), "%v", err)
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diskmigration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+const (
+	// StatusPlanned is the status of a PV which would be migrated without --dry-run
+	StatusPlanned = "Planned"
+	// StatusMigrated is the status of a PV replaced by its CSI PV
+	StatusMigrated = "Migrated"
+	// StatusSkipped is the status of a PV which is not safe to migrate now
+	StatusSkipped = "Skipped"
+	// StatusFailed is the status of a PV whose migration failed
+	StatusFailed = "Failed"
+
+	inTreePluginName         = "kubernetes.io/azure-disk"
+	provisionedByAnnotation  = "pv.kubernetes.io/provisioned-by"
+	migratedToAnnotation     = "pv.kubernetes.io/migrated-to"
+	defaultDeletionTimeout   = 2 * time.Minute
+	defaultDeletionPollDelay = time.Second
+)
+
+// Result is the migration result of an in-tree azure disk PV
+type Result struct {
+	PersistentVolume string `json:"persistentVolume"`
+	// Claim is namespace/name of the bound PVC, empty if the PV is not bound
+	Claim        string `json:"claim,omitempty"`
+	VolumeHandle string `json:"volumeHandle"`
+	Status       string `json:"status"`
+	Message      string `json:"message,omitempty"`
+	// CSIPersistentVolume is the CSI PV replacing the in-tree PV, kept in the report so that it could be created
+	// manually if the migration fails after the in-tree PV is deleted
+	CSIPersistentVolume *v1.PersistentVolume `json:"csiPersistentVolume,omitempty"`
+}
+
+// Migrator replaces the in-tree kubernetes.io/azure-disk PVs with equivalent CSI PVs of the same name bound to the same
+// PVCs, the managed disks are kept since the in-tree PVs are retained before they are deleted
+type Migrator struct {
+	driverName string
+	kubeClient kubernetes.Interface
+	dryRun     bool
+
+	deletionTimeout   time.Duration
+	deletionPollDelay time.Duration
+}
+
+// NewMigrator returns a migrator to PVs of driverName, no PV is changed if dryRun is true
+func NewMigrator(driverName string, kubeClient kubernetes.Interface, dryRun bool) *Migrator {
+	return &Migrator{
+		driverName:        driverName,
+		kubeClient:        kubeClient,
+		dryRun:            dryRun,
+		deletionTimeout:   defaultDeletionTimeout,
+		deletionPollDelay: defaultDeletionPollDelay,
+	}
+}
+
+// Run migrates the in-tree azure disk PVs of names, or all in-tree azure disk PVs if names is empty, one by one and
+// returns the result of each PV, a PV failed to migrate does not stop the migration of the others
+func (m *Migrator) Run(ctx context.Context, names []string) ([]Result, error) {
+	pvs, err := m.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PVs: %w", err)
+	}
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+	results := []Result{}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.AzureDisk == nil || (len(selected) > 0 && !selected[pv.Name]) {
+			continue
+		}
+		result := m.migrate(ctx, pv)
+		klog.V(2).Infof("PV %s: %s %s", result.PersistentVolume, result.Status, result.Message)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// migrate replaces pv with its CSI PV: the in-tree PV is retained, deleted and recreated as a CSI PV with the same
+// name and claimRef, so the PVC, which lost its PV for a moment, is bound again without being recreated
+func (m *Migrator) migrate(ctx context.Context, pv *v1.PersistentVolume) Result {
+	result := Result{PersistentVolume: pv.Name, VolumeHandle: pv.Spec.AzureDisk.DataDiskURI}
+	if pv.Spec.ClaimRef != nil {
+		result.Claim = pv.Spec.ClaimRef.Namespace + "/" + pv.Spec.ClaimRef.Name
+	}
+	fail := func(status, format string, args ...interface{}) Result {
+		result.Status, result.Message = status, fmt.Sprintf(format, args...)
+		return result
+	}
+
+	csiPV, err := TranslateInTreePV(pv, m.driverName)
+	if err != nil {
+		return fail(StatusSkipped, "%v", err)
+	}
+	result.CSIPersistentVolume = csiPV
+	if pv.DeletionTimestamp != nil {
+		return fail(StatusSkipped, "PV is being deleted")
+	}
+	if reason, err := InUse(ctx, m.kubeClient, pv); err != nil {
+		return fail(StatusFailed, "%v", err)
+	} else if reason != "" {
+		return fail(StatusSkipped, "%s, stop the workload first", reason)
+	}
+	if m.dryRun {
+		result.Status = StatusPlanned
+		return result
+	}
+
+	if err := ReplacePV(ctx, m.kubeClient, pv, csiPV, m.deletionPollDelay, m.deletionTimeout); err != nil {
+		return fail(StatusFailed, "%v, create the csiPersistentVolume in the report if the PV is deleted", err)
+	}
+	result.Status = StatusMigrated
+	return result
+}
+
+// InUse returns why pv could not be replaced now: it's attached to a node or its PVC is used by a pod, empty if not in use
+func InUse(ctx context.Context, kubeClient kubernetes.Interface, pv *v1.PersistentVolume) (string, error) {
+	attachments, err := kubeClient.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list VolumeAttachments: %w", err)
+	}
+	for _, attachment := range attachments.Items {
+		if source := attachment.Spec.Source.PersistentVolumeName; source != nil && *source == pv.Name {
+			return fmt.Sprintf("PV is attached to node %s", attachment.Spec.NodeName), nil
+		}
+	}
+	if pv.Spec.ClaimRef == nil {
+		return "", nil
+	}
+	pods, err := kubeClient.CoreV1().Pods(pv.Spec.ClaimRef.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods in namespace %s: %w", pv.Spec.ClaimRef.Namespace, err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pv.Spec.ClaimRef.Name {
+				return fmt.Sprintf("PVC is used by pod %s", pod.Name), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// ReplacePV replaces pv with newPV of the same name: pv is retained, deleted and newPV is created with the same claimRef,
+// so the PVC, which is Lost for a moment, is bound again without being recreated and the disk is never deleted.
+// The caller should make sure pv is not in use.
+func ReplacePV(ctx context.Context, kubeClient kubernetes.Interface, pv, newPV *v1.PersistentVolume, pollInterval, timeout time.Duration) error {
+	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+		patch := []byte(fmt.Sprintf(`{"spec":{"persistentVolumeReclaimPolicy":"%s"}}`, v1.PersistentVolumeReclaimRetain))
+		if _, err := kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to retain PV %s: %w", pv.Name, err)
+		}
+	}
+	if err := kubeClient.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete retained PV %s: %w", pv.Name, err)
+	}
+	// the pv-protection finalizer is only removed once the PV is not bound
+	patch := []byte(`{"metadata":{"finalizers":null}}`)
+	if _, err := kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to remove finalizers of deleted PV %s: %w", pv.Name, err)
+	}
+	if err := wait.PollUntilContextTimeout(ctx, pollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		_, err := kubeClient.CoreV1().PersistentVolumes().Get(ctx, pv.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}); err != nil {
+		return fmt.Errorf("failed to wait for the deletion of PV %s: %w", pv.Name, err)
+	}
+	if _, err := kubeClient.CoreV1().PersistentVolumes().Create(ctx, newPV, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create PV %s after deleting the old one: %w", pv.Name, err)
+	}
+	return nil
+}
+
+// TranslateInTreePV returns the CSI PV of driverName equivalent to the in-tree azure disk pv: the disk URI becomes the
+// volume handle, caching mode, kind and fsType are kept, and the deprecated zone and region labels of the node affinity
+// are replaced by the well-known topology labels
+func TranslateInTreePV(pv *v1.PersistentVolume, driverName string) (*v1.PersistentVolume, error) {
+	azureDisk := pv.Spec.AzureDisk
+	if azureDisk == nil {
+		return nil, fmt.Errorf("PV %s is not an in-tree azure disk PV", pv.Name)
+	}
+	if azureDisk.Kind != nil && *azureDisk.Kind != v1.AzureManagedDisk {
+		return nil, fmt.Errorf("%s disk of PV %s is not a managed disk", *azureDisk.Kind, pv.Name)
+	}
+	if !consts.ManagedDiskPathRE.MatchString(azureDisk.DataDiskURI) {
+		return nil, fmt.Errorf("disk URI %q of PV %s is not a managed disk URI", azureDisk.DataDiskURI, pv.Name)
+	}
+
+	csiPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pv.Name,
+			Labels:      pv.Labels,
+			Annotations: map[string]string{},
+		},
+		Spec: *pv.Spec.DeepCopy(),
+	}
+	for k, v := range pv.Annotations {
+		if k != migratedToAnnotation {
+			csiPV.Annotations[k] = v
+		}
+	}
+	if csiPV.Annotations[provisionedByAnnotation] == inTreePluginName {
+		csiPV.Annotations[provisionedByAnnotation] = driverName
+	}
+
+	volumeAttributes := map[string]string{}
+	if azureDisk.CachingMode != nil {
+		volumeAttributes[consts.CachingModeField] = string(*azureDisk.CachingMode)
+	}
+	if azureDisk.Kind != nil {
+		volumeAttributes[consts.KindField] = string(*azureDisk.Kind)
+	}
+	var fsType string
+	if azureDisk.FSType != nil {
+		fsType = *azureDisk.FSType
+		volumeAttributes[consts.FsTypeField] = fsType
+	}
+	csiPV.Spec.AzureDisk = nil
+	csiPV.Spec.CSI = &v1.CSIPersistentVolumeSource{
+		Driver:           driverName,
+		VolumeHandle:     azureDisk.DataDiskURI,
+		ReadOnly:         azureDisk.ReadOnly != nil && *azureDisk.ReadOnly,
+		FSType:           fsType,
+		VolumeAttributes: volumeAttributes,
+	}
+	if csiPV.Spec.ClaimRef != nil {
+		csiPV.Spec.ClaimRef.ResourceVersion = ""
+	}
+	csiPV.Spec.NodeAffinity = translateNodeAffinity(pv)
+	return csiPV, nil
+}
+
+// translateNodeAffinity returns the node affinity of pv with the well-known topology labels, the affinity is built
+// from the zone label of the PV if the PV has no node affinity
+func translateNodeAffinity(pv *v1.PersistentVolume) *v1.VolumeNodeAffinity {
+	keys := map[string]string{
+		v1.LabelFailureDomainBetaZone:   v1.LabelTopologyZone,
+		v1.LabelFailureDomainBetaRegion: v1.LabelTopologyRegion,
+	}
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		zone := pv.Labels[v1.LabelTopologyZone]
+		if zone == "" {
+			zone = pv.Labels[v1.LabelFailureDomainBetaZone]
+		}
+		// zone label of a disk out of availability zones is the fault domain, e.g. "0"
+		if zone == "" || !strings.Contains(zone, "-") {
+			return nil
+		}
+		return &v1.VolumeNodeAffinity{
+			Required: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{{
+						Key:      v1.LabelTopologyZone,
+						Operator: v1.NodeSelectorOpIn,
+						Values:   strings.Split(zone, "__"),
+					}},
+				}},
+			},
+		}
+	}
+	affinity := pv.Spec.NodeAffinity.DeepCopy()
+	for i := range affinity.Required.NodeSelectorTerms {
+		for j := range affinity.Required.NodeSelectorTerms[i].MatchExpressions {
+			expression := &affinity.Required.NodeSelectorTerms[i].MatchExpressions[j]
+			if key, ok := keys[expression.Key]; ok {
+				expression.Key = key
+			}
+		}
+	}
+	return affinity
+}
+
+// Summary returns the number of PVs per status of results
+func Summary(results []Result) map[string]int {
+	summary := map[string]int{}
+	for _, result := range results {
+		summary[result.Status]++
+	}
+	return summary
+}
+
+// MarshalReport returns the JSON report of results
+func MarshalReport(results []Result) ([]byte, error) {
+	return json.MarshalIndent(results, "", "  ")
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diskmigration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	testDriverName = "disk.csi.azure.com"
+	testDiskURI    = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk1"
+)
+
+func newInTreePV(name, claimName string) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{v1.LabelFailureDomainBetaZone: "eastus-1"},
+			Annotations: map[string]string{provisionedByAnnotation: inTreePluginName, migratedToAnnotation: testDriverName},
+			Finalizers:  []string{"kubernetes.io/pv-protection"},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity:                      v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")},
+			AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			StorageClassName:              "default",
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				AzureDisk: &v1.AzureDiskVolumeSource{
+					DiskName:    "disk1",
+					DataDiskURI: testDiskURI,
+					CachingMode: ptr.To(v1.AzureDataDiskCachingReadOnly),
+					FSType:      ptr.To("ext4"),
+					ReadOnly:    ptr.To(false),
+					Kind:        ptr.To(v1.AzureManagedDisk),
+				},
+			},
+		},
+	}
+	if claimName != "" {
+		pv.Spec.ClaimRef = &v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: "default", Name: claimName, UID: "uid", ResourceVersion: "1"}
+	}
+	return pv
+}
+
+func TestTranslateInTreePV(t *testing.T) {
+	csiPV, err := TranslateInTreePV(newInTreePV("pv-1", "pvc-1"), testDriverName)
+	assert.NoError(t, err)
+	assert.Nil(t, csiPV.Spec.AzureDisk)
+	assert.Equal(t, &v1.CSIPersistentVolumeSource{
+		Driver:       testDriverName,
+		VolumeHandle: testDiskURI,
+		FSType:       "ext4",
+		VolumeAttributes: map[string]string{
+			"cachingmode": "ReadOnly",
+			"kind":        "Managed",
+			"fstype":      "ext4",
+		},
+	}, csiPV.Spec.CSI)
+	assert.Equal(t, map[string]string{provisionedByAnnotation: testDriverName}, csiPV.Annotations)
+	assert.Empty(t, csiPV.Finalizers)
+	assert.Equal(t, v1.PersistentVolumeReclaimDelete, csiPV.Spec.PersistentVolumeReclaimPolicy)
+	assert.Equal(t, types.UID("uid"), csiPV.Spec.ClaimRef.UID)
+	assert.Empty(t, csiPV.Spec.ClaimRef.ResourceVersion)
+	assert.Equal(t, []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"eastus-1"}}},
+		csiPV.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions)
+
+	// the deprecated zone label in the node affinity is replaced
+	pv := newInTreePV("pv-1", "")
+	pv.Spec.NodeAffinity = &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+		MatchExpressions: []v1.NodeSelectorRequirement{{Key: v1.LabelFailureDomainBetaZone, Operator: v1.NodeSelectorOpIn, Values: []string{"eastus-2"}}},
+	}}}}
+	csiPV, err = TranslateInTreePV(pv, testDriverName)
+	assert.NoError(t, err)
+	assert.Equal(t, v1.LabelTopologyZone, csiPV.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Key)
+	assert.Equal(t, v1.LabelFailureDomainBetaZone, pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Key, "in-tree PV is not changed")
+
+	// disks out of availability zones have no node affinity
+	pv = newInTreePV("pv-1", "")
+	pv.Labels[v1.LabelFailureDomainBetaZone] = "0"
+	csiPV, err = TranslateInTreePV(pv, testDriverName)
+	assert.NoError(t, err)
+	assert.Nil(t, csiPV.Spec.NodeAffinity)
+
+	pv = newInTreePV("pv-1", "")
+	pv.Spec.AzureDisk.Kind = ptr.To(v1.AzureDedicatedBlobDisk)
+	_, err = TranslateInTreePV(pv, testDriverName)
+	assert.Error(t, err)
+
+	pv = newInTreePV("pv-1", "")
+	pv.Spec.AzureDisk.DataDiskURI = "https://account.blob.core.windows.net/vhds/disk1.vhd"
+	_, err = TranslateInTreePV(pv, testDriverName)
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	objects := []runtime.Object{
+		newInTreePV("pv-idle", "pvc-idle"),
+		newInTreePV("pv-used", "pvc-used"),
+		newInTreePV("pv-attached", ""),
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-csi"},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: testDriverName, VolumeHandle: testDiskURI}},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-used", Namespace: "default"},
+			Spec: v1.PodSpec{Volumes: []v1.Volume{{
+				Name:         "data",
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-used"}},
+			}}},
+			Status: v1.PodStatus{Phase: v1.PodRunning},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-completed", Namespace: "default"},
+			Spec: v1.PodSpec{Volumes: []v1.Volume{{
+				Name:         "data",
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-idle"}},
+			}}},
+			Status: v1.PodStatus{Phase: v1.PodSucceeded},
+		},
+		&storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: "va-1"},
+			Spec: storagev1.VolumeAttachmentSpec{
+				NodeName: "node-1",
+				Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: ptr.To("pv-attached")},
+			},
+		},
+	}
+
+	kubeClient := fake.NewSimpleClientset(objects...)
+	results, err := NewMigrator(testDriverName, kubeClient, true).Run(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{StatusPlanned: 1, StatusSkipped: 2}, Summary(results))
+	for _, result := range results {
+		switch result.PersistentVolume {
+		case "pv-idle":
+			assert.Equal(t, StatusPlanned, result.Status)
+			assert.Equal(t, "default/pvc-idle", result.Claim)
+			assert.NotNil(t, result.CSIPersistentVolume)
+		case "pv-used":
+			assert.Equal(t, "PVC is used by pod pod-used, stop the workload first", result.Message)
+		case "pv-attached":
+			assert.Equal(t, "PV is attached to node node-1, stop the workload first", result.Message)
+		}
+	}
+	pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), "pv-idle", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotNil(t, pv.Spec.AzureDisk, "dry run does not change PVs")
+
+	m := NewMigrator(testDriverName, kubeClient, false)
+	m.deletionPollDelay = time.Millisecond
+	results, err = m.Run(context.Background(), []string{"pv-idle"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, StatusMigrated, results[0].Status)
+	pv, err = kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), "pv-idle", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, pv.Spec.AzureDisk)
+	assert.Equal(t, testDiskURI, pv.Spec.CSI.VolumeHandle)
+	assert.Equal(t, "pvc-idle", pv.Spec.ClaimRef.Name)
+	assert.Equal(t, v1.PersistentVolumeReclaimDelete, pv.Spec.PersistentVolumeReclaimPolicy, "reclaim policy is restored")
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diskquota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/admission"
+)
+
+// WebhookPath is the path of the PVC validating webhook
+const WebhookPath = "/validate-pvc"
+
+// AzDiskQuotaResource is the resource of the namespaced AzDiskQuota custom resource
+var AzDiskQuotaResource = schema.GroupVersionResource{Group: "disk.csi.azure.com", Version: "v1alpha1", Resource: "azdiskquotas"}
+
+// AzDiskQuotaSpec limits the azure disk PVCs of a namespace, unset limits are not enforced
+type AzDiskQuotaSpec struct {
+	// MaxCapacity is the maximum total requested capacity of the PVCs
+	MaxCapacity *resource.Quantity `json:"maxCapacity,omitempty"`
+	// MaxDiskCount is the maximum number of the PVCs
+	MaxDiskCount *int64 `json:"maxDiskCount,omitempty"`
+	// StorageClassNames limits the quota to the PVCs of these StorageClasses, the PVCs of all StorageClasses
+	// of the driver are counted if empty
+	StorageClassNames []string `json:"storageClassNames,omitempty"`
+}
+
+// AzDiskQuota is the quota of the azure disk PVCs in its namespace
+type AzDiskQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AzDiskQuotaSpec `json:"spec"`
+}
+
+// admittedPVCTimeout is how long a PVC admitted by the webhook is counted if it's not observed in the PVC cache,
+// e.g. because it's rejected by another admission plugin after the webhook
+const admittedPVCTimeout = time.Minute
+
+// Webhook is a validating admission webhook rejecting PVCs of the driver which exceed the AzDiskQuotas of their namespace.
+// The PVCs, StorageClasses and AzDiskQuotas are read from informer caches. The validations are serialized and the PVCs
+// admitted by the webhook are counted until they are observed in the PVC cache, so that concurrent PVCs could not
+// exceed a quota together; the webhook must run in one replica for this.
+type Webhook struct {
+	driverName      string
+	pvcLister       corelisters.PersistentVolumeClaimLister
+	scLister        storagelisters.StorageClassLister
+	quotaLister     cache.GenericLister
+	informersSynced []cache.InformerSynced
+	startInformers  func(stopCh <-chan struct{})
+
+	// mutex serializes the validations
+	mutex sync.Mutex
+	// admitted are the PVCs admitted by the webhook which are not observed in the PVC cache yet <namespace/name, admittedPVC>
+	admitted map[string]admittedPVC
+	now      func() time.Time
+}
+
+// admittedPVC is the requested capacity of a PVC admitted by the webhook
+type admittedPVC struct {
+	storageClassName string
+	capacity         resource.Quantity
+	expiration       time.Time
+}
+
+// NewWebhook returns a quota webhook of the PVCs provisioned by driverName, the informers are started by Run
+func NewWebhook(driverName string, kubeClient kubernetes.Interface, dynamicClient dynamic.Interface) *Webhook {
+	factory := informers.NewSharedInformerFactory(kubeClient, 0)
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+	pvcInformer := factory.Core().V1().PersistentVolumeClaims()
+	scInformer := factory.Storage().V1().StorageClasses()
+	quotaInformer := dynamicFactory.ForResource(AzDiskQuotaResource)
+	return &Webhook{
+		driverName:      driverName,
+		pvcLister:       pvcInformer.Lister(),
+		scLister:        scInformer.Lister(),
+		quotaLister:     quotaInformer.Lister(),
+		informersSynced: []cache.InformerSynced{pvcInformer.Informer().HasSynced, scInformer.Informer().HasSynced, quotaInformer.Informer().HasSynced},
+		startInformers: func(stopCh <-chan struct{}) {
+			factory.Start(stopCh)
+			dynamicFactory.Start(stopCh)
+		},
+		admitted: map[string]admittedPVC{},
+		now:      time.Now,
+	}
+}
+
+// namespaceUsage is the usage of the PVCs of one StorageClass
+type namespaceUsage struct {
+	capacity resource.Quantity
+	count    int64
+}
+
+// validate returns an error describing the exceeded quota if pvc could not be admitted, oldPVC is set on update
+func (w *Webhook) validate(pvc, oldPVC *v1.PersistentVolumeClaim) (string, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return "", nil
+	}
+	storageClassName := *pvc.Spec.StorageClassName
+	isDriverStorageClass := func(name string) (bool, error) {
+		sc, err := w.scLister.Get(name)
+		if err != nil {
+			return false, fmt.Errorf("failed to get StorageClass %s: %w", name, err)
+		}
+		return sc.Provisioner == w.driverName, nil
+	}
+	if ok, err := isDriverStorageClass(storageClassName); err != nil || !ok {
+		return "", err
+	}
+
+	items, err := w.quotaLister.ByNamespace(pvc.Namespace).List(labels.Everything())
+	if err != nil {
+		return "", fmt.Errorf("failed to list AzDiskQuotas in namespace %s: %w", pvc.Namespace, err)
+	}
+	quotas := make([]AzDiskQuota, 0, len(items))
+	for _, item := range items {
+		u, ok := item.(*unstructured.Unstructured)
+		if !ok {
+			return "", fmt.Errorf("unexpected AzDiskQuota type %T", item)
+		}
+		quota := AzDiskQuota{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &quota); err != nil {
+			return "", fmt.Errorf("failed to convert AzDiskQuota %s/%s: %w", u.GetNamespace(), u.GetName(), err)
+		}
+		quotas = append(quotas, quota)
+	}
+	if len(quotas) == 0 {
+		return "", nil
+	}
+
+	requested := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	added := namespaceUsage{capacity: requested.DeepCopy(), count: 1}
+	if oldPVC != nil {
+		// only the expansion of an existing PVC is counted
+		added.capacity.Sub(oldPVC.Spec.Resources.Requests[v1.ResourceStorage])
+		added.count = 0
+		if added.capacity.Sign() <= 0 {
+			return "", nil
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	pvcs, err := w.getNamespacePVCs(pvc.Namespace)
+	if err != nil {
+		return "", err
+	}
+	usages := map[string]*namespaceUsage{}
+	for name, existing := range pvcs {
+		if name == pvc.Name {
+			continue
+		}
+		ok, err := isDriverStorageClass(existing.storageClassName)
+		if err != nil {
+			// PVCs of deleted StorageClasses are still counted
+			klog.V(4).Infof("%v, counting PVC %s/%s", err, pvc.Namespace, name)
+		} else if !ok {
+			continue
+		}
+		if usages[existing.storageClassName] == nil {
+			usages[existing.storageClassName] = &namespaceUsage{}
+		}
+		usages[existing.storageClassName].capacity.Add(existing.capacity)
+		usages[existing.storageClassName].count++
+	}
+	// the requested capacity of the PVC being updated is counted in added
+	if oldPVC != nil {
+		if usages[storageClassName] == nil {
+			usages[storageClassName] = &namespaceUsage{}
+		}
+		usages[storageClassName].capacity.Add(oldPVC.Spec.Resources.Requests[v1.ResourceStorage])
+		usages[storageClassName].count++
+	}
+
+	for _, quota := range quotas {
+		if len(quota.Spec.StorageClassNames) > 0 && !slices.Contains(quota.Spec.StorageClassNames, storageClassName) {
+			continue
+		}
+		used := namespaceUsage{}
+		for name, usage := range usages {
+			if len(quota.Spec.StorageClassNames) == 0 || slices.Contains(quota.Spec.StorageClassNames, name) {
+				used.capacity.Add(usage.capacity)
+				used.count += usage.count
+			}
+		}
+		if quota.Spec.MaxDiskCount != nil && used.count+added.count > *quota.Spec.MaxDiskCount {
+			return fmt.Sprintf("exceeded AzDiskQuota %s: requested disk count %d, used %d, limited %d",
+				quota.Name, added.count, used.count, *quota.Spec.MaxDiskCount), nil
+		}
+		total := used.capacity.DeepCopy()
+		total.Add(added.capacity)
+		if quota.Spec.MaxCapacity != nil && total.Cmp(*quota.Spec.MaxCapacity) > 0 {
+			return fmt.Sprintf("exceeded AzDiskQuota %s: requested capacity %s, used %s, limited %s",
+				quota.Name, added.capacity.String(), used.capacity.String(), quota.Spec.MaxCapacity.String()), nil
+		}
+	}
+
+	w.admitted[pvc.Namespace+"/"+pvc.Name] = admittedPVC{
+		storageClassName: storageClassName,
+		capacity:         requested.DeepCopy(),
+		expiration:       w.now().Add(admittedPVCTimeout),
+	}
+	return "", nil
+}
+
+// getNamespacePVCs returns the StorageClass and requested capacity of the PVCs of namespace by name, the PVCs in the
+// cache are overridden by the PVCs admitted by the webhook which are not observed in the cache yet
+func (w *Webhook) getNamespacePVCs(namespace string) (map[string]admittedPVC, error) {
+	list, err := w.pvcLister.PersistentVolumeClaims(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PVCs in namespace %s: %w", namespace, err)
+	}
+	pvcs := make(map[string]admittedPVC, len(list))
+	for _, pvc := range list {
+		if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+			continue
+		}
+		pvcs[pvc.Name] = admittedPVC{storageClassName: *pvc.Spec.StorageClassName, capacity: pvc.Spec.Resources.Requests[v1.ResourceStorage]}
+	}
+	now := w.now()
+	for key, admitted := range w.admitted {
+		if now.After(admitted.expiration) {
+			delete(w.admitted, key)
+			continue
+		}
+		ns, name, _ := strings.Cut(key, "/")
+		if ns != namespace {
+			continue
+		}
+		if cached, ok := pvcs[name]; ok && cached.capacity.Cmp(admitted.capacity) >= 0 {
+			delete(w.admitted, key)
+			continue
+		}
+		pvcs[name] = admitted
+	}
+	return pvcs, nil
+}
+
+// review returns the admission response of a PVC admission request, PVCs are admitted if the quotas could not be evaluated
+func (w *Webhook) review(_ context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if (req.Operation != admissionv1.Create && req.Operation != admissionv1.Update) || req.Kind.Kind != "PersistentVolumeClaim" {
+		return resp
+	}
+	pvc := &v1.PersistentVolumeClaim{}
+	if err := json.Unmarshal(req.Object.Raw, pvc); err != nil {
+		klog.Errorf("failed to decode PVC in admission request %s: %v", req.UID, err)
+		return resp
+	}
+	if pvc.Namespace == "" {
+		pvc.Namespace = req.Namespace
+	}
+	var oldPVC *v1.PersistentVolumeClaim
+	if req.Operation == admissionv1.Update {
+		oldPVC = &v1.PersistentVolumeClaim{}
+		if err := json.Unmarshal(req.OldObject.Raw, oldPVC); err != nil {
+			klog.Errorf("failed to decode old PVC in admission request %s: %v", req.UID, err)
+			return resp
+		}
+	}
+	reason, err := w.validate(pvc, oldPVC)
+	if err != nil {
+		klog.Errorf("failed to validate quota of PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		return resp
+	}
+	if reason != "" {
+		klog.V(2).Infof("rejecting PVC %s/%s: %s", pvc.Namespace, pvc.Name, reason)
+		admission.Deny(resp, reason)
+	}
+	return resp
+}
+
+// ServeHTTP handles the AdmissionReview requests sent by the API server
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	admission.ReviewFunc(w.review).ServeHTTP(rw, r)
+}
+
+// Run starts the informers and serves the webhook over HTTPS with tls.crt and tls.key in certDir until ctx is done
+func (w *Webhook) Run(ctx context.Context, port int, certDir string) error {
+	w.startInformers(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), w.informersSynced...) {
+		return fmt.Errorf("failed to sync the informer caches")
+	}
+	return admission.Serve(ctx, fmt.Sprintf("disk quota webhook of %s", w.driverName), port, certDir, WebhookPath, w)
+}
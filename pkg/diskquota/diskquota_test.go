@@ -0,0 +1,216 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diskquota
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+const testDriverName = "disk.csi.azure.com"
+
+func newTestPVC(name, storageClassName, size string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: ptr.To(storageClassName),
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(size)},
+			},
+		},
+	}
+}
+
+func newTestQuota(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "disk.csi.azure.com/v1alpha1",
+		"kind":       "AzDiskQuota",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec":       spec,
+	}}
+}
+
+func newTestWebhook(t *testing.T, quotas ...runtime.Object) *Webhook {
+	kubeClient := fake.NewSimpleClientset(
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "managed-csi"}, Provisioner: testDriverName},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "managed-csi-premium"}, Provisioner: testDriverName},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "azurefile-csi"}, Provisioner: "file.csi.azure.com"},
+		newTestPVC("existing-1", "managed-csi", "100Gi"),
+		newTestPVC("existing-2", "managed-csi-premium", "50Gi"),
+		newTestPVC("existing-3", "azurefile-csi", "1Ti"),
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{AzDiskQuotaResource: "AzDiskQuotaList"}, quotas...)
+	w := NewWebhook(testDriverName, kubeClient, dynamicClient)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	w.startInformers(ctx.Done())
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), w.informersSynced...))
+	return w
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		desc       string
+		quotas     []runtime.Object
+		pvc        *v1.PersistentVolumeClaim
+		oldPVC     *v1.PersistentVolumeClaim
+		expectDeny bool
+	}{
+		{
+			desc: "no quota",
+			pvc:  newTestPVC("pvc", "managed-csi", "1Ti"),
+		},
+		{
+			desc:   "within capacity",
+			quotas: []runtime.Object{newTestQuota("quota", map[string]interface{}{"maxCapacity": "200Gi"})},
+			pvc:    newTestPVC("pvc", "managed-csi", "50Gi"),
+		},
+		{
+			desc:       "exceeded capacity",
+			quotas:     []runtime.Object{newTestQuota("quota", map[string]interface{}{"maxCapacity": "200Gi"})},
+			pvc:        newTestPVC("pvc", "managed-csi", "51Gi"),
+			expectDeny: true,
+		},
+		{
+			desc:       "exceeded disk count",
+			quotas:     []runtime.Object{newTestQuota("quota", map[string]interface{}{"maxDiskCount": int64(2)})},
+			pvc:        newTestPVC("pvc", "managed-csi", "1Gi"),
+			expectDeny: true,
+		},
+		{
+			desc:   "quota of other StorageClass",
+			quotas: []runtime.Object{newTestQuota("quota", map[string]interface{}{"maxDiskCount": int64(1), "storageClassNames": []interface{}{"managed-csi-premium"}})},
+			pvc:    newTestPVC("pvc", "managed-csi", "1Gi"),
+		},
+		{
+			desc:       "quota counts PVCs of its StorageClasses only",
+			quotas:     []runtime.Object{newTestQuota("quota", map[string]interface{}{"maxCapacity": "100Gi", "storageClassNames": []interface{}{"managed-csi-premium"}})},
+			pvc:        newTestPVC("pvc", "managed-csi-premium", "51Gi"),
+			expectDeny: true,
+		},
+		{
+			desc:   "PVC of other driver",
+			quotas: []runtime.Object{newTestQuota("quota", map[string]interface{}{"maxDiskCount": int64(0)})},
+			pvc:    newTestPVC("pvc", "azurefile-csi", "1Gi"),
+		},
+		{
+			desc:   "shrunk or unchanged PVC",
+			quotas: []runtime.Object{newTestQuota("quota", map[string]interface{}{"maxCapacity": "100Gi"})},
+			pvc:    newTestPVC("existing-1", "managed-csi", "100Gi"),
+			oldPVC: newTestPVC("existing-1", "managed-csi", "100Gi"),
+		},
+		{
+			desc:       "expanded PVC",
+			quotas:     []runtime.Object{newTestQuota("quota", map[string]interface{}{"maxCapacity": "200Gi"})},
+			pvc:        newTestPVC("existing-1", "managed-csi", "151Gi"),
+			oldPVC:     newTestPVC("existing-1", "managed-csi", "100Gi"),
+			expectDeny: true,
+		},
+	}
+	for _, test := range tests {
+		w := newTestWebhook(t, test.quotas...)
+		reason, err := w.validate(test.pvc, test.oldPVC)
+		require.NoError(t, err, test.desc)
+		assert.Equal(t, test.expectDeny, reason != "", "%s: %s", test.desc, reason)
+	}
+}
+
+func TestValidateCountsAdmittedPVCs(t *testing.T) {
+	w := newTestWebhook(t, newTestQuota("quota", map[string]interface{}{"maxDiskCount": int64(3), "maxCapacity": "300Gi"}))
+	now := time.Now()
+	w.now = func() time.Time { return now }
+
+	// the first PVC is admitted, the second one is rejected although the first one is not in the PVC cache yet
+	reason, err := w.validate(newTestPVC("pvc-1", "managed-csi", "10Gi"), nil)
+	require.NoError(t, err)
+	assert.Empty(t, reason)
+	reason, err = w.validate(newTestPVC("pvc-2", "managed-csi", "10Gi"), nil)
+	require.NoError(t, err)
+	assert.Contains(t, reason, "requested disk count 1, used 3, limited 3")
+
+	// a retry of the admitted PVC is not counted twice
+	reason, err = w.validate(newTestPVC("pvc-1", "managed-csi", "10Gi"), nil)
+	require.NoError(t, err)
+	assert.Empty(t, reason)
+
+	// an admitted PVC which is never created is not counted after the timeout
+	now = now.Add(admittedPVCTimeout + time.Second)
+	reason, err = w.validate(newTestPVC("pvc-2", "managed-csi", "10Gi"), nil)
+	require.NoError(t, err)
+	assert.Empty(t, reason)
+
+	// an admitted expansion is counted until the PVC cache has the new capacity
+	reason, err = w.validate(newTestPVC("existing-1", "managed-csi", "200Gi"), newTestPVC("existing-1", "managed-csi", "100Gi"))
+	require.NoError(t, err)
+	assert.Empty(t, reason)
+	reason, err = w.validate(newTestPVC("existing-2", "managed-csi-premium", "100Gi"), newTestPVC("existing-2", "managed-csi-premium", "50Gi"))
+	require.NoError(t, err)
+	assert.Contains(t, reason, "requested capacity 50Gi, used 260Gi, limited 300Gi")
+}
+
+func TestServeHTTP(t *testing.T) {
+	w := newTestWebhook(t, newTestQuota("quota", map[string]interface{}{"maxDiskCount": int64(2)}))
+	raw, err := json.Marshal(newTestPVC("pvc", "managed-csi", "1Gi"))
+	require.NoError(t, err)
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "uid",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"},
+			Namespace: "default",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, WebhookPath, bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	result := admissionv1.AdmissionReview{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.NotNil(t, result.Response)
+	assert.Equal(t, "uid", string(result.Response.UID))
+	assert.False(t, result.Response.Allowed)
+	assert.Contains(t, result.Response.Result.Message, "exceeded AzDiskQuota quota")
+
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, WebhookPath, bytes.NewReader([]byte("invalid"))))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
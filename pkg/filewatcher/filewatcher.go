@@ -17,6 +17,7 @@ limitations under the License.
 package filewatcher
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"sync"
@@ -76,3 +77,58 @@ func checkForFileChanges(path string) error {
 
 	return watcher.Add(path)
 }
+
+// WatchFilesForChanges calls onChange with the name of the changed file when one of the files is written, created,
+// removed or renamed, until ctx is done. The parent directories of the files are watched so that files replaced by
+// a rename, like the files of a secret volume updated by kubelet through the ..data symlink, are also detected.
+func WatchFilesForChanges(ctx context.Context, files []string, onChange func(name string)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		file = filepath.Clean(file)
+		names[file] = true
+		dirs[filepath.Dir(file)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+		klog.V(2).Infof("Watching directory, %s", dir)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+					continue
+				}
+				// all the files of a secret or configmap volume are replaced when its ..data symlink is swapped
+				if names[filepath.Clean(event.Name)] || filepath.Base(event.Name) == "..data" {
+					onChange(event.Name)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				klog.Errorf("file watcher error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchFilesForChanges(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "azure.json")
+	require.NoError(t, os.WriteFile(file, []byte("{}"), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan string, 10)
+	require.NoError(t, WatchFilesForChanges(ctx, []string{file, ""}, func(name string) {
+		changed <- name
+	}))
+
+	// changes of other files in the directory are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.json"), []byte("{}"), 0600))
+	require.NoError(t, os.WriteFile(file, []byte(`{"cloud":"AzureStackCloud"}`), 0600))
+	select {
+	case name := <-changed:
+		assert.Equal(t, file, name)
+	case <-time.After(10 * time.Second):
+		t.Fatal("change of the file is not detected")
+	}
+
+	assert.Error(t, WatchFilesForChanges(ctx, []string{filepath.Join(dir, "missing", "azure.json")}, func(string) {}))
+}
@@ -33,6 +33,8 @@ import (
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/os/disk"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/os/filesystem"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/os/volume"
@@ -155,6 +157,14 @@ func (mounter *winMounter) FormatAndMount(source, target, fstype string, options
 	if err != nil {
 		return fmt.Errorf("parse %s failed with error: %v", source, err)
 	}
+	integrityStreams, err := azureutils.GetIntegrityStreams(options)
+	if err != nil {
+		return err
+	}
+	allocationUnitSize, err := azureutils.GetAllocationUnitSizeMountOption(options)
+	if err != nil {
+		return err
+	}
 
 	// set disk as online and clear readonly flag if there is any.
 	if err := disk.SetDiskState(uint32(diskNum), true); err != nil {
@@ -189,7 +199,19 @@ func (mounter *winMounter) FormatAndMount(source, target, fstype string, options
 
 	// If the volume is not formatted, then format it, else proceed to mount.
 	if !formatted {
-		if err := volume.FormatVolume(volumeID); err != nil {
+		// NTFS cluster must not be smaller than the logical sector size of 4k sector disks
+		sectorSize, err := disk.GetDiskLogicalSectorSize(uint32(diskNum))
+		if err != nil {
+			klog.Warningf("GetDiskLogicalSectorSize on disk(%d) failed with %v, format with default allocation unit size", diskNum, err)
+		} else if sectorSize == consts.LogicalSectorSize4096 {
+			if allocationUnitSize != 0 && allocationUnitSize < sectorSize {
+				return fmt.Errorf("allocation unit size %d is smaller than the logical sector size %d of disk %d", allocationUnitSize, sectorSize, diskNum)
+			}
+			if allocationUnitSize == 0 {
+				allocationUnitSize = sectorSize
+			}
+		}
+		if err := volume.FormatVolume(volumeID, fstype, allocationUnitSize, integrityStreams); err != nil {
 			return err
 		}
 	}
@@ -211,10 +233,21 @@ func (mounter *winMounter) FindDiskByLun(lun string) (diskNum string, err error)
 		return "", err
 	}
 
+	// the LUN of a disk on the remote NVMe controller is its namespace id minus 1,
+	// and the namespace id of a data disk is its lun plus consts.NVMeDataDiskNamespaceOffset
+	nvmeLun := lun
+	if l, err := strconv.Atoi(lun); err == nil {
+		nvmeLun = strconv.Itoa(l + consts.NVMeDataDiskNamespaceOffset - 1)
+	}
+
 	// List all disk locations and match the lun id being requested for.
 	// If match is found then return back the disk number.
 	for diskID, location := range diskLocations {
-		if strings.EqualFold(location.LUNID, lun) {
+		expectedLun := lun
+		if location.BusType == disk.BusTypeNVMe {
+			expectedLun = nvmeLun
+		}
+		if strings.EqualFold(location.LUNID, expectedLun) {
 			return strconv.Itoa(int(diskID)), nil
 		}
 	}
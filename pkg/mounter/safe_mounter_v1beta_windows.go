@@ -257,6 +257,9 @@ func (mounter *csiProxyMounterV1Beta) FindDiskByLun(lun string) (diskNum string,
 
 // FormatAndMount - accepts the source disk number, target path to mount, the fstype to format with and options to be used.
 func (mounter *csiProxyMounterV1Beta) FormatAndMount(source string, target string, fstype string, options []string) error {
+	if err := checkCSIProxyFormatOptions(fstype, options); err != nil {
+		return err
+	}
 	// Call PartitionDisk CSI proxy call to partition the disk and return the volume id
 	partionDiskRequest := &disk.PartitionDiskRequest{
 		DiskID: source,
@@ -37,9 +37,14 @@ import (
 	volume "github.com/kubernetes-csi/csi-proxy/client/api/volume/v1"
 	volumeclient "github.com/kubernetes-csi/csi-proxy/client/groups/volume/v1"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
 	utilexec "k8s.io/utils/exec"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 )
 
 // CSIProxyMounter extends the mount.Interface interface with CSI Proxy methods.
@@ -281,7 +286,27 @@ func (mounter *csiProxyMounter) FindDiskByLun(lun string) (diskNum string, err e
 }
 
 // FormatAndMount - accepts the source disk number, target path to mount, the fstype to format with and options to be used.
+// checkCSIProxyFormatOptions returns an InvalidArgument error for the format options only supported by the host process
+// node plugin. CSI proxy only formats volumes with NTFS and its default allocation unit size, which is never smaller
+// than the 4096 bytes logical sector size of a disk, so the 4k sector disks need no allocation unit size.
+func checkCSIProxyFormatOptions(fstype string, options []string) error {
+	if strings.EqualFold(fstype, consts.FsTypeReFS) {
+		return status.Errorf(codes.InvalidArgument, "fsType %s is only supported by the host process node plugin", fstype)
+	}
+	allocationUnitSize, err := azureutils.GetAllocationUnitSizeMountOption(options)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if allocationUnitSize > 0 {
+		return status.Errorf(codes.InvalidArgument, "%s is only supported by the host process node plugin", consts.AllocationUnitSizeField)
+	}
+	return nil
+}
+
 func (mounter *csiProxyMounter) FormatAndMount(source, target, fstype string, options []string) error {
+	if err := checkCSIProxyFormatOptions(fstype, options); err != nil {
+		return err
+	}
 	diskNum, err := strconv.Atoi(source)
 	if err != nil {
 		return fmt.Errorf("parse %s failed with error: %v", source, err)
@@ -24,9 +24,8 @@ import (
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 )
 
-// IsValidPerfProfile Checks to see if perf profile passed is correct
-// Right now we are only supporting basic profile
-// Other advanced profiles to come later
+// IsValidPerfProfile Checks to see if perf profile passed is correct,
+// either a builtin profile or one of the loaded tuning profiles
 func IsValidPerfProfile(profile string) bool {
 	return isPerfTuningEnabled(profile) || strings.EqualFold(profile, consts.PerfProfileNone)
 }
@@ -79,7 +78,8 @@ func isPerfTuningEnabled(profile string) bool {
 	case consts.PerfProfileAdvanced:
 		return true
 	default:
-		return false
+		_, ok := GetTuningProfile(profile)
+		return ok
 	}
 }
 
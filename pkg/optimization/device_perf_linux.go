@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -67,7 +68,11 @@ func (deviceHelper *DeviceHelper) OptimizeDiskPerformance(nodeInfo *NodeInfo, de
 	case consts.PerfProfileAdvanced:
 		deviceSettings, err = getDeviceSettingsForAdvancedProfile(deviceRoot, deviceSettingsFromCtx)
 	default:
-		return fmt.Errorf("OptimizeDiskPerformance: Invalid perfProfile %s", perfProfile)
+		profileSettings, ok := GetTuningProfile(perfProfile)
+		if !ok {
+			return fmt.Errorf("OptimizeDiskPerformance: Invalid perfProfile %s", perfProfile)
+		}
+		deviceSettings, err = getDeviceSettingsForTuningProfile(deviceRoot, profileSettings, deviceSettingsFromCtx)
 	}
 
 	if err != nil {
@@ -114,24 +119,90 @@ func getDeviceSettingsForAdvancedProfile(deviceRoot string, deviceSettingsFromCt
 	return deviceSettings, nil
 }
 
+// getDeviceSettingsForTuningProfile returns the settings of a tuning profile, device-setting/* parameters override the profile settings
+func getDeviceSettingsForTuningProfile(deviceRoot string, profileSettings, deviceSettingsFromCtx map[string]string) (deviceSettings map[string]string, err error) {
+	klog.V(2).Infof("getDeviceSettingsForTuningProfile: Getting settings for deviceRoot %s profileSettings %v deviceSettingsFromCtx %v",
+		deviceRoot,
+		profileSettings,
+		deviceSettingsFromCtx)
+	deviceSettings = make(map[string]string)
+	for setting, value := range profileSettings {
+		deviceSettings[filepath.Join(deviceRoot, setting)] = value
+	}
+	for setting, value := range deviceSettingsFromCtx {
+		deviceSettings[filepath.Join(deviceRoot, setting)] = value
+	}
+
+	return deviceSettings, nil
+}
+
+// applyDeviceSettings writes the device settings in a deterministic order,
+// the settings already written are restored to their previous values if any setting fails
 func applyDeviceSettings(deviceRoot string, deviceSettings map[string]string) (err error) {
 	if err = AreDeviceSettingsValid(deviceRoot, deviceSettings); err != nil {
 		return err
 	}
 
-	for setting, value := range deviceSettings {
+	settings := make([]string, 0, len(deviceSettings))
+	for setting := range deviceSettings {
+		settings = append(settings, setting)
+	}
+	sort.Strings(settings)
+
+	previousValues := map[string]string{}
+	applied := []string{}
+	for _, setting := range settings {
+		value := deviceSettings[setting]
+		if previousValue, readErr := readDeviceSetting(setting); readErr == nil {
+			previousValues[setting] = previousValue
+		}
 		err = echoToFile(value, setting)
 		if err != nil {
+			rollbackDeviceSettings(applied, previousValues)
 			return fmt.Errorf("applyDeviceSettings: Could not set %s with value %s. Error: %v",
 				setting,
 				value,
 				err)
 		}
+		applied = append(applied, setting)
 	}
 
 	return nil
 }
 
+// rollbackDeviceSettings restores the applied settings in reverse order, settings without a previous value are left as is
+func rollbackDeviceSettings(applied []string, previousValues map[string]string) {
+	for i := len(applied) - 1; i >= 0; i-- {
+		setting := applied[i]
+		previousValue, ok := previousValues[setting]
+		if !ok {
+			klog.Warningf("rollbackDeviceSettings: previous value of %s is unknown, skip rolling it back", setting)
+			continue
+		}
+		if err := echoToFile(previousValue, setting); err != nil {
+			klog.Errorf("rollbackDeviceSettings: Could not restore %s to %s. Error: %v", setting, previousValue, err)
+		} else {
+			klog.V(2).Infof("rollbackDeviceSettings: restored %s to %s", setting, previousValue)
+		}
+	}
+}
+
+// readDeviceSetting returns the current value of a device setting, for a list of choices
+// like "mq-deadline [none]" in queue/scheduler the selected one is returned
+func readDeviceSetting(setting string) (string, error) {
+	content, err := os.ReadFile(setting)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(content))
+	if start := strings.Index(value, "["); start >= 0 {
+		if end := strings.Index(value[start:], "]"); end > 0 {
+			return value[start+1 : start+end], nil
+		}
+	}
+	return value, nil
+}
+
 // getDeviceName gets the device name from the device lunpath
 // Device lun path is of the format /dev/disk/azure/scsi1/lun0
 func getDeviceName(lunPath string) (deviceName string, err error) {
@@ -537,3 +537,58 @@ func Test_echoToFile(t *testing.T) {
 		})
 	}
 }
+
+func Test_applyDeviceSettingsRollback(t *testing.T) {
+	deviceRoot := t.TempDir()
+	scheduler := path.Join(deviceRoot, "queue/scheduler")
+	nrRequests := path.Join(deviceRoot, "queue/nr_requests")
+	require.NoError(t, os.MkdirAll(path.Join(deviceRoot, "queue"), os.ModePerm))
+	require.NoError(t, os.WriteFile(scheduler, []byte("[mq-deadline] none\n"), 0600))
+	require.NoError(t, os.WriteFile(nrRequests, []byte("64\n"), 0600))
+
+	// zz/queue_depth is written last and fails since zz dir does not exist
+	err := applyDeviceSettings(deviceRoot, map[string]string{
+		scheduler:                               "none",
+		nrRequests:                              "256",
+		path.Join(deviceRoot, "zz/queue_depth"): "32",
+	})
+	assert.Error(t, err)
+	value, err := readDeviceSetting(scheduler)
+	assert.NoError(t, err)
+	assert.Equal(t, "mq-deadline", value)
+	value, err = readDeviceSetting(nrRequests)
+	assert.NoError(t, err)
+	assert.Equal(t, "64", value)
+
+	assert.NoError(t, applyDeviceSettings(deviceRoot, map[string]string{scheduler: "none", nrRequests: "256"}))
+	value, err = readDeviceSetting(scheduler)
+	assert.NoError(t, err)
+	assert.Equal(t, "none", value)
+}
+
+func Test_OptimizeDiskPerformanceWithTuningProfile(t *testing.T) {
+	SetTuningProfiles(&TuningProfiles{Profiles: map[string]map[string]string{
+		"database": {"queue/scheduler": "none", "queue/nr_requests": "256"},
+	}})
+	defer SetTuningProfiles(nil)
+
+	deviceRoot := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(deviceRoot, "sdx/queue"), os.ModePerm))
+	devicePath := path.Join(deviceRoot, "lun0")
+	require.NoError(t, os.Symlink(path.Join(deviceRoot, "sdx"), devicePath))
+	deviceHelper := &DeviceHelper{blockDeviceRootPath: deviceRoot}
+
+	assert.True(t, deviceHelper.DiskSupportsPerfOptimization("Database", "Premium_LRS"))
+	assert.False(t, deviceHelper.DiskSupportsPerfOptimization("database", "Standard_LRS"))
+	err := deviceHelper.OptimizeDiskPerformance(&NodeInfo{}, devicePath, "Database", "Premium_LRS", "", "", "", map[string]string{"queue/nr_requests": "128"})
+	assert.NoError(t, err)
+	value, err := readDeviceSetting(path.Join(deviceRoot, "sdx/queue/scheduler"))
+	assert.NoError(t, err)
+	assert.Equal(t, "none", value)
+	value, err = readDeviceSetting(path.Join(deviceRoot, "sdx/queue/nr_requests"))
+	assert.NoError(t, err)
+	assert.Equal(t, "128", value)
+
+	err = deviceHelper.OptimizeDiskPerformance(&NodeInfo{}, devicePath, "unknown", "Premium_LRS", "", "", "", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package optimization
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// IOThrottle is the per-volume IO limit applied to the consuming pod via cgroup v2 io.max
+type IOThrottle struct {
+	// IopsLimit is the maximum read and write IOPS, 0 means no limit
+	IopsLimit int64
+	// BandwidthLimitMBps is the maximum read and write throughput in MB/s, 0 means no limit
+	BandwidthLimitMBps int64
+}
+
+// IsEnabled returns true if any IO limit is set
+func (t IOThrottle) IsEnabled() bool {
+	return t.IopsLimit > 0 || t.BandwidthLimitMBps > 0
+}
+
+// GetIOThrottleFromAttributes gets the iopsLimit and bandwidthLimit set in attributes
+func GetIOThrottleFromAttributes(attributes map[string]string) (IOThrottle, error) {
+	throttle := IOThrottle{}
+	for k, v := range attributes {
+		switch strings.ToLower(k) {
+		case consts.IopsLimitField:
+			value, err := parseIOLimit(k, v)
+			if err != nil {
+				return throttle, err
+			}
+			throttle.IopsLimit = value
+		case consts.BandwidthLimitField:
+			value, err := parseIOLimit(k, v)
+			if err != nil {
+				return throttle, err
+			}
+			throttle.BandwidthLimitMBps = value
+		}
+	}
+	return throttle, nil
+}
+
+func parseIOLimit(key, value string) (int64, error) {
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s:%s failed with error: %v", key, value, err)
+	}
+	if limit <= 0 {
+		return 0, fmt.Errorf("%s:%s must be a positive integer", key, value)
+	}
+	return limit, nil
+}
+
+// getIOMaxLine returns the io.max entry for the device, e.g. "8:16 riops=500 wiops=500 rbps=max wbps=max"
+func getIOMaxLine(major, minor uint32, throttle IOThrottle) string {
+	iops, bps := "max", "max"
+	if throttle.IopsLimit > 0 {
+		iops = strconv.FormatInt(throttle.IopsLimit, 10)
+	}
+	if throttle.BandwidthLimitMBps > 0 {
+		bps = strconv.FormatInt(throttle.BandwidthLimitMBps*1024*1024, 10)
+	}
+	return fmt.Sprintf("%d:%d riops=%s wiops=%s rbps=%s wbps=%s", major, minor, iops, iops, bps, bps)
+}
@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package optimization
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const ioMaxFile = "io.max"
+
+// ApplyIOThrottle caps the IO of the pod on the device by writing to io.max of the pod cgroup (cgroup v2 only)
+func ApplyIOThrottle(cgroupRoot, podUID, devicePath string, throttle IOThrottle) error {
+	if !throttle.IsEnabled() {
+		return nil
+	}
+
+	podCgroupPath, err := getPodCgroupPath(cgroupRoot, podUID)
+	if err != nil {
+		return err
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Stat(devicePath, &stat); err != nil {
+		return fmt.Errorf("ApplyIOThrottle: could not stat device %s. Error: %v", devicePath, err)
+	}
+
+	ioMaxLine := getIOMaxLine(unix.Major(stat.Rdev), unix.Minor(stat.Rdev), throttle)
+	ioMaxPath := filepath.Join(podCgroupPath, ioMaxFile)
+	klog.V(2).Infof("ApplyIOThrottle: setting %q in %s for device %s", ioMaxLine, ioMaxPath, devicePath)
+	// io.max only accepts a single device entry per write
+	if err := os.WriteFile(ioMaxPath, []byte(ioMaxLine), 0644); err != nil {
+		return fmt.Errorf("ApplyIOThrottle: could not write %q to %s. Error: %v", ioMaxLine, ioMaxPath, err)
+	}
+	return nil
+}
+
+// getPodCgroupPath returns the cgroup v2 path of the pod, both cgroupfs and systemd cgroup drivers are supported
+func getPodCgroupPath(cgroupRoot, podUID string) (string, error) {
+	if podUID == "" {
+		return "", fmt.Errorf("getPodCgroupPath: pod UID is not provided")
+	}
+
+	systemdPodUID := strings.ReplaceAll(podUID, "-", "_")
+	candidates := []string{
+		// cgroupfs driver
+		filepath.Join("kubepods", "pod"+podUID),
+		filepath.Join("kubepods", "burstable", "pod"+podUID),
+		filepath.Join("kubepods", "besteffort", "pod"+podUID),
+		// systemd driver
+		filepath.Join("kubepods.slice", "kubepods-pod"+systemdPodUID+".slice"),
+		filepath.Join("kubepods.slice", "kubepods-burstable.slice", "kubepods-burstable-pod"+systemdPodUID+".slice"),
+		filepath.Join("kubepods.slice", "kubepods-besteffort.slice", "kubepods-besteffort-pod"+systemdPodUID+".slice"),
+	}
+	for _, candidate := range candidates {
+		path := filepath.Join(cgroupRoot, candidate)
+		if _, err := os.Stat(filepath.Join(path, ioMaxFile)); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("getPodCgroupPath: could not find cgroup v2 %s of pod %s under %s", ioMaxFile, podUID, cgroupRoot)
+}
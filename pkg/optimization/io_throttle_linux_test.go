@@ -0,0 +1,107 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package optimization
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyIOThrottle(t *testing.T) {
+	podUID := "2bd3a7d4-3c0e-4a3f-9e0f-6e7d2c1b1a11"
+	tests := []struct {
+		name          string
+		podCgroupPath string
+		podUID        string
+		devicePath    string
+		throttle      IOThrottle
+		expected      string
+		wantErr       bool
+	}{
+		{
+			name:     "no limits is a no-op",
+			podUID:   podUID,
+			throttle: IOThrottle{},
+		},
+		{
+			name:          "cgroupfs burstable pod",
+			podCgroupPath: filepath.Join("kubepods", "burstable", "pod"+podUID),
+			podUID:        podUID,
+			devicePath:    "/dev/null",
+			throttle:      IOThrottle{IopsLimit: 200},
+			expected:      "1:3 riops=200 wiops=200 rbps=max wbps=max",
+		},
+		{
+			name:          "systemd guaranteed pod",
+			podCgroupPath: filepath.Join("kubepods.slice", "kubepods-pod2bd3a7d4_3c0e_4a3f_9e0f_6e7d2c1b1a11.slice"),
+			podUID:        podUID,
+			devicePath:    "/dev/null",
+			throttle:      IOThrottle{BandwidthLimitMBps: 2},
+			expected:      "1:3 riops=max wiops=max rbps=2097152 wbps=2097152",
+		},
+		{
+			name:     "pod UID not provided",
+			throttle: IOThrottle{IopsLimit: 200},
+			wantErr:  true,
+		},
+		{
+			name:     "pod cgroup not found",
+			podUID:   podUID,
+			throttle: IOThrottle{IopsLimit: 200},
+			wantErr:  true,
+		},
+		{
+			name:          "device not found",
+			podCgroupPath: filepath.Join("kubepods", "pod"+podUID),
+			podUID:        podUID,
+			devicePath:    "/dev/not-exist",
+			throttle:      IOThrottle{IopsLimit: 200},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cgroupRoot := t.TempDir()
+			ioMaxPath := ""
+			if tt.podCgroupPath != "" {
+				require.NoError(t, os.MkdirAll(filepath.Join(cgroupRoot, tt.podCgroupPath), 0755))
+				ioMaxPath = filepath.Join(cgroupRoot, tt.podCgroupPath, ioMaxFile)
+				require.NoError(t, os.WriteFile(ioMaxPath, []byte{}, 0644))
+			}
+
+			err := ApplyIOThrottle(cgroupRoot, tt.podUID, tt.devicePath, tt.throttle)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if ioMaxPath != "" {
+				content, err := os.ReadFile(ioMaxPath)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, string(content))
+			}
+		})
+	}
+}
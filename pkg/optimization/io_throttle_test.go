@@ -0,0 +1,90 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package optimization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetIOThrottleFromAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		expected   IOThrottle
+		wantErr    bool
+	}{
+		{
+			name:       "no limits",
+			attributes: map[string]string{"skuName": "Premium_LRS"},
+			expected:   IOThrottle{},
+		},
+		{
+			name:       "iops and bandwidth limits",
+			attributes: map[string]string{"iopsLimit": "500", "BandwidthLimit": "50"},
+			expected:   IOThrottle{IopsLimit: 500, BandwidthLimitMBps: 50},
+		},
+		{
+			name:       "invalid iops limit",
+			attributes: map[string]string{"iopsLimit": "abc"},
+			wantErr:    true,
+		},
+		{
+			name:       "zero bandwidth limit",
+			attributes: map[string]string{"bandwidthLimit": "0"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throttle, err := GetIOThrottleFromAttributes(tt.attributes)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, throttle)
+			assert.Equal(t, tt.expected.IsEnabled(), throttle.IsEnabled())
+		})
+	}
+}
+
+func TestGetIOMaxLine(t *testing.T) {
+	tests := []struct {
+		throttle IOThrottle
+		expected string
+	}{
+		{
+			throttle: IOThrottle{IopsLimit: 500},
+			expected: "8:16 riops=500 wiops=500 rbps=max wbps=max",
+		},
+		{
+			throttle: IOThrottle{BandwidthLimitMBps: 10},
+			expected: "8:16 riops=max wiops=max rbps=10485760 wbps=10485760",
+		},
+		{
+			throttle: IOThrottle{IopsLimit: 100, BandwidthLimitMBps: 1},
+			expected: "8:16 riops=100 wiops=100 rbps=1048576 wbps=1048576",
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, getIOMaxLine(8, 16, tt.throttle))
+	}
+}
@@ -154,6 +154,26 @@ func GetDiskSkuInfoMap() map[string]map[string]DiskSkuInfo {
 	return DiskSkuMap
 }
 
+// GetDiskPerformance returns the provisioned IOPS and bandwidth of a disk, diskIops and diskBwMbps are returned as is
+// if both are set (e.g. UltraSSD_LRS, PremiumV2_LRS), otherwise the limits of the smallest tier of the account type
+// fitting the disk size are returned
+func GetDiskPerformance(accountType string, diskSizeGiB, diskIops, diskBwMbps int) (iops, bwMbps int, err error) {
+	if diskIops > 0 && diskBwMbps > 0 {
+		return diskIops, diskBwMbps, nil
+	}
+	var matchingSku *DiskSkuInfo
+	for _, sku := range DiskSkuMap[strings.ToLower(accountType)] {
+		if sku.MaxSizeGiB >= diskSizeGiB && (matchingSku == nil || sku.MaxSizeGiB < matchingSku.MaxSizeGiB) {
+			tempSku := sku
+			matchingSku = &tempSku
+		}
+	}
+	if matchingSku == nil {
+		return 0, 0, fmt.Errorf("could not find the tier of %s disk with size %dGiB", accountType, diskSizeGiB)
+	}
+	return matchingSku.MaxIops, matchingSku.MaxBwMbps, nil
+}
+
 // GetRandomIOLatencyInSec gets the estimated random IP latency for a small write for a disk size
 // These latencies are manually calculated and stored
 // ToDo: Make this estimation dynamic
@@ -127,3 +127,47 @@ func TestNewNodeInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestGetDiskPerformance(t *testing.T) {
+	tests := []struct {
+		description    string
+		accountType    string
+		diskSizeGiB    int
+		diskIops       int
+		diskBwMbps     int
+		expectedIops   int
+		expectedBwMbps int
+		wantErr        bool
+	}{
+		{
+			description:    "[Success] Should return the provisioned performance of the disk.",
+			accountType:    "PremiumV2_LRS",
+			diskSizeGiB:    100,
+			diskIops:       5000,
+			diskBwMbps:     200,
+			expectedIops:   5000,
+			expectedBwMbps: 200,
+		},
+		{
+			description:    "[Success] Should return the performance of the smallest tier fitting the disk size.",
+			accountType:    "Premium_LRS",
+			diskSizeGiB:    100,
+			expectedIops:   500,
+			expectedBwMbps: 100,
+		},
+		{
+			description: "[Failure] Should return an error for an unknown account type.",
+			accountType: "Unknown_LRS",
+			diskSizeGiB: 100,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			iops, bwMbps, err := GetDiskPerformance(tt.accountType, tt.diskSizeGiB, tt.diskIops, tt.diskBwMbps)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.expectedIops, iops)
+			assert.Equal(t, tt.expectedBwMbps, bwMbps)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package optimization
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// TuningProfiles are named sets of device settings selected by the perfProfile parameter, e.g.
+//
+//	profiles:
+//	  database:
+//	    queue/scheduler: none
+//	    queue/nr_requests: "256"
+//
+// setting keys are paths relative to the block device directory, same as device-setting/* parameters
+type TuningProfiles struct {
+	Profiles map[string]map[string]string `json:"profiles"`
+}
+
+var (
+	tuningProfilesMutex sync.RWMutex
+	tuningProfiles      = map[string]map[string]string{}
+)
+
+// LoadTuningProfiles reads the tuning profiles from a YAML or JSON file, which is usually mounted from a configmap
+func LoadTuningProfiles(path string) (*TuningProfiles, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profiles := &TuningProfiles{}
+	if err := yaml.UnmarshalStrict(content, profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse tuning profiles %s: %w", path, err)
+	}
+	if err := profiles.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid tuning profiles %s: %w", path, err)
+	}
+	return profiles, nil
+}
+
+// Validate checks that profile names do not override the builtin profiles and all settings are under the device directory
+func (p *TuningProfiles) Validate() error {
+	names := map[string]bool{}
+	for name, settings := range p.Profiles {
+		lowerName := strings.ToLower(name)
+		switch lowerName {
+		case "", consts.PerfProfileNone, consts.PerfProfileBasic, consts.PerfProfileAdvanced:
+			return fmt.Errorf("profile name %q is reserved", name)
+		}
+		if names[lowerName] {
+			return fmt.Errorf("profile %s is defined more than once", name)
+		}
+		names[lowerName] = true
+
+		deviceSettings := make(map[string]string, len(settings))
+		for setting, value := range settings {
+			if value == "" {
+				return fmt.Errorf("value of setting %s in profile %s is empty", setting, name)
+			}
+			deviceSettings[filepath.Join(consts.DummyBlockDevicePathLinux, setting)] = value
+		}
+		if err := AreDeviceSettingsValid(consts.DummyBlockDevicePathLinux, deviceSettings); err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// SetTuningProfiles replaces the tuning profiles accepted by the perfProfile parameter
+func SetTuningProfiles(p *TuningProfiles) {
+	profiles := map[string]map[string]string{}
+	if p != nil {
+		for name, settings := range p.Profiles {
+			profiles[strings.ToLower(name)] = settings
+		}
+	}
+	tuningProfilesMutex.Lock()
+	defer tuningProfilesMutex.Unlock()
+	tuningProfiles = profiles
+}
+
+// GetTuningProfile returns the device settings of a tuning profile, the profile name is case insensitive
+func GetTuningProfile(profile string) (map[string]string, bool) {
+	tuningProfilesMutex.RLock()
+	defer tuningProfilesMutex.RUnlock()
+	settings, ok := tuningProfiles[strings.ToLower(profile)]
+	return settings, ok
+}
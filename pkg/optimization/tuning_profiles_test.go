@@ -0,0 +1,114 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package optimization
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTuningProfiles = `
+profiles:
+  database:
+    queue/scheduler: none
+    queue/nr_requests: "256"
+  throughputHeavy:
+    queue/max_sectors_kb: "1024"
+    queue/read_ahead_kb: "4096"
+`
+
+func TestLoadTuningProfiles(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		desc        string
+		content     string
+		expectedErr bool
+	}{
+		{
+			desc:    "valid profiles",
+			content: testTuningProfiles,
+		},
+		{
+			desc:        "unknown field",
+			content:     "profile:\n  database:\n    queue/scheduler: none\n",
+			expectedErr: true,
+		},
+		{
+			desc:        "builtin profile name",
+			content:     "profiles:\n  Basic:\n    queue/scheduler: none\n",
+			expectedErr: true,
+		},
+		{
+			desc:        "duplicated profile name",
+			content:     "profiles:\n  database:\n    queue/scheduler: none\n  Database:\n    queue/scheduler: none\n",
+			expectedErr: true,
+		},
+		{
+			desc:        "setting outside of the device directory",
+			content:     "profiles:\n  database:\n    ../sdb/queue/scheduler: none\n",
+			expectedErr: true,
+		},
+		{
+			desc:        "empty setting value",
+			content:     "profiles:\n  database:\n    queue/scheduler: \"\"\n",
+			expectedErr: true,
+		},
+		{
+			desc:        "profile without settings",
+			content:     "profiles:\n  database: {}\n",
+			expectedErr: true,
+		},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, string(rune('a'+i))+".yaml")
+		require.NoError(t, os.WriteFile(path, []byte(test.content), 0600))
+		profiles, err := LoadTuningProfiles(path)
+		assert.Equal(t, test.expectedErr, err != nil, test.desc)
+		if !test.expectedErr {
+			assert.Len(t, profiles.Profiles, 2, test.desc)
+		}
+	}
+
+	_, err := LoadTuningProfiles(filepath.Join(dir, "notexist.yaml"))
+	assert.Error(t, err)
+}
+
+func TestSetTuningProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testTuningProfiles), 0600))
+	profiles, err := LoadTuningProfiles(path)
+	require.NoError(t, err)
+
+	SetTuningProfiles(profiles)
+	defer SetTuningProfiles(nil)
+
+	settings, ok := GetTuningProfile("ThroughputHeavy")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"queue/max_sectors_kb": "1024", "queue/read_ahead_kb": "4096"}, settings)
+	assert.True(t, IsValidPerfProfile("database"))
+	assert.True(t, isPerfTuningEnabled("database"))
+	assert.False(t, IsValidPerfProfile("latencySensitive"))
+
+	SetTuningProfiles(nil)
+	_, ok = GetTuningProfile("database")
+	assert.False(t, ok)
+	assert.False(t, IsValidPerfProfile("database"))
+}
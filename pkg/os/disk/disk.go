@@ -40,6 +40,11 @@ var (
 const (
 	IOCTL_STORAGE_GET_DEVICE_NUMBER = 0x2D1080
 	IOCTL_STORAGE_QUERY_PROPERTY    = 0x002d1400
+
+	// BusTypeNVMe is the bus type of the disks served by the remote NVMe controller on NVMe VM sizes
+	BusTypeNVMe = "NVMe"
+	// busTypeNVMeValue is the numeric value of the NVMe bus type of MSFT_Disk
+	busTypeNVMeValue = 17
 )
 
 // ListDiskLocations - constructs a map with the disk number as the key and the DiskLocation structure
@@ -48,9 +53,14 @@ func ListDiskLocations() (map[uint32]Location, error) {
 	// sample response
 	// [{
 	//    "number":  0,
-	//    "location":  "PCI Slot 3 : Adapter 0 : Port 0 : Target 1 : LUN 0"
+	//    "location":  "PCI Slot 3 : Adapter 0 : Port 0 : Target 1 : LUN 0",
+	//    "busType":  10
+	// }, {
+	//    "number":  2,
+	//    "location":  "Integrated : Adapter 1 : Port 0 : Target 0 : LUN 2",
+	//    "busType":  17
 	// }, ...]
-	cmd := fmt.Sprintf("ConvertTo-Json @(Get-Disk | select Number, Location)")
+	cmd := fmt.Sprintf("ConvertTo-Json @(Get-Disk | select Number, Location, BusType)")
 	out, err := azureutils.RunPowershellCmd(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list disk location. cmd: %q, output: %q, err %v", cmd, string(out), err)
@@ -90,6 +100,7 @@ func ListDiskLocations() (map[uint32]Location, error) {
 			}
 
 			if found {
+				d.BusType = getBusType(v["BusType"])
 				m[uint32(num)] = d
 			}
 		}
@@ -97,6 +108,23 @@ func ListDiskLocations() (map[uint32]Location, error) {
 	return m, nil
 }
 
+// getBusType returns BusTypeNVMe for the NVMe bus type of MSFT_Disk, which is either serialized as its numeric value or its name
+func getBusType(busType interface{}) string {
+	switch v := busType.(type) {
+	case float64:
+		if v == busTypeNVMeValue {
+			return BusTypeNVMe
+		}
+		return strconv.Itoa(int(v))
+	case string:
+		if strings.EqualFold(v, BusTypeNVMe) || v == strconv.Itoa(busTypeNVMeValue) {
+			return BusTypeNVMe
+		}
+		return v
+	}
+	return ""
+}
+
 func Rescan() error {
 	cmd := "Update-HostStorageCache"
 	out, err := azureutils.RunPowershellCmd(cmd)
@@ -141,8 +169,10 @@ func BasicPartitionsExist(diskNumber uint32) (bool, error) {
 	return false, nil
 }
 
+// CreateBasicPartition creates a partition using the whole disk, the partition is aligned to 1MiB
+// so that it's aligned to both 512 and 4096 bytes logical sectors
 func CreateBasicPartition(diskNumber uint32) error {
-	cmd := fmt.Sprintf("New-Partition -DiskNumber %d -UseMaximumSize", diskNumber)
+	cmd := fmt.Sprintf("New-Partition -DiskNumber %d -UseMaximumSize -Alignment 1048576", diskNumber)
 	out, err := azureutils.RunPowershellCmd(cmd)
 	if err != nil {
 		return fmt.Errorf("error creating partition on disk %d: %v, %v", diskNumber, out, err)
@@ -150,6 +180,20 @@ func CreateBasicPartition(diskNumber uint32) error {
 	return nil
 }
 
+// GetDiskLogicalSectorSize returns the logical sector size of the disk in bytes
+func GetDiskLogicalSectorSize(diskNumber uint32) (uint32, error) {
+	cmd := fmt.Sprintf("(Get-Disk -Number %d).LogicalSectorSize", diskNumber)
+	out, err := azureutils.RunPowershellCmd(cmd)
+	if err != nil {
+		return 0, fmt.Errorf("error getting logical sector size of disk %d: %v, %v", diskNumber, string(out), err)
+	}
+	sectorSize, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing logical sector size(%s) of disk %d: %v", string(out), diskNumber, err)
+	}
+	return uint32(sectorSize), nil
+}
+
 func GetDiskNumberByName(page83ID string) (uint32, error) {
 	return GetDiskNumberWithID(page83ID)
 }
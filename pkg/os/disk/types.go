@@ -136,6 +136,7 @@ type Location struct {
 	Bus     string
 	Target  string
 	LUNID   string
+	BusType string
 }
 
 // IDs definition
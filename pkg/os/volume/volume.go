@@ -25,6 +25,7 @@ import (
 	"strings"
 
 	"k8s.io/klog/v2"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 )
 
@@ -74,10 +75,23 @@ func ListVolumesOnDisk(diskNumber uint32, partitionNumber uint32) (volumeIDs []s
 	return volumeIDs, nil
 }
 
-// FormatVolume - Formats a volume with the NTFS format.
-func FormatVolume(volumeID string) (err error) {
-	cmd := "Get-Volume -UniqueId \"$Env:volumeID\" | Format-Volume -FileSystem ntfs -Confirm:$false"
-	out, err := azureutils.RunPowershellCmd(cmd, fmt.Sprintf("volumeID=%s", volumeID))
+// FormatVolume - Formats a volume with the given filesystem, NTFS is used unless fsType is ReFS. The default cluster size is
+// used if allocationUnitSize is 0, the default integrity streams setting of ReFS is used if integrityStreams is nil.
+func FormatVolume(volumeID, fsType string, allocationUnitSize uint32, integrityStreams *bool) (err error) {
+	fileSystem := "ntfs"
+	if strings.EqualFold(fsType, consts.FsTypeReFS) {
+		fileSystem = consts.FsTypeReFS
+	}
+	cmd := "Get-Volume -UniqueId \"$Env:volumeID\" | Format-Volume -FileSystem $Env:fileSystem -Confirm:$false"
+	envs := []string{fmt.Sprintf("volumeID=%s", volumeID), fmt.Sprintf("fileSystem=%s", fileSystem)}
+	if allocationUnitSize > 0 {
+		cmd += " -AllocationUnitSize $Env:allocationUnitSize"
+		envs = append(envs, fmt.Sprintf("allocationUnitSize=%d", allocationUnitSize))
+	}
+	if fileSystem == consts.FsTypeReFS && integrityStreams != nil {
+		cmd += fmt.Sprintf(" -SetIntegrityStreams $%t", *integrityStreams)
+	}
+	out, err := azureutils.RunPowershellCmd(cmd, envs...)
 	if err != nil {
 		return fmt.Errorf("error formatting volume. cmd: %s, output: %s, error: %v", cmd, string(out), err)
 	}
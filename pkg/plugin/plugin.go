@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin is the entrypoint shared by the combined azuredisk plugin binary and the role-specific binaries.
+package plugin
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azuredisk"
+)
+
+// Main parses the flags and runs the driver until it stops, role is the driver role of a role-specific binary,
+// the role is set by the driver-role flag if empty
+func Main(role string) {
+	klog.InitFlags(nil)
+	driverOptions := azuredisk.DriverOptions{}
+	driverOptions.AddFlags().VisitAll(func(f *flag.Flag) {
+		flag.CommandLine.Var(f.Value, f.Name, f.Usage)
+	})
+	version := flag.Bool("version", false, "Print the version and exit.")
+	metricsAddress := flag.String("metrics-address", "", "export the metrics")
+	configFile := flag.String(azuredisk.ConfigFileFlag, "", "path of the versioned YAML driver configuration file setting the options by their flag names, flags set on the command line override the file")
+	flag.Parse()
+	if *configFile != "" {
+		if err := azuredisk.LoadConfigFile(*configFile, flag.CommandLine); err != nil {
+			klog.Fatalln(err)
+		}
+	}
+
+	if *version {
+		info, err := azuredisk.GetVersionYAML(driverOptions.DriverName)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+		fmt.Println(info) // nolint
+		os.Exit(0)
+	}
+
+	if role != "" {
+		if driverOptions.DriverRole != "" && driverOptions.DriverRole != role {
+			klog.Fatalf("driver-role %s could not be set on the %s binary", driverOptions.DriverRole, role)
+		}
+		driverOptions.DriverRole = role
+	}
+	if err := driverOptions.ValidateDriverRole(); err != nil {
+		klog.Fatalln(err)
+	}
+
+	exportMetrics(*metricsAddress)
+	handle(&driverOptions)
+	os.Exit(0)
+}
+
+func handle(driverOptions *azuredisk.DriverOptions) {
+	driver, err := azuredisk.NewDriver(driverOptions)
+	if err != nil {
+		klog.Fatalf("Failed to initialize azuredisk CSI Driver: %v", err)
+	}
+	if err := driver.Run(context.Background()); err != nil {
+		klog.Fatalf("Failed to run azuredisk CSI Driver: %v", err)
+	}
+}
+
+func exportMetrics(metricsAddress string) {
+	if metricsAddress == "" {
+		return
+	}
+	l, err := net.Listen("tcp", metricsAddress)
+	if err != nil {
+		klog.Warningf("failed to get listener for metrics endpoint: %v", err)
+		return
+	}
+	serve(context.Background(), l, serveMetrics)
+}
+
+func serve(_ context.Context, l net.Listener, serveFunc func(net.Listener) error) {
+	path := l.Addr().String()
+	klog.V(2).Infof("set up prometheus server on %v", path)
+	go func() {
+		defer l.Close()
+		if err := serveFunc(l); err != nil {
+			klog.Fatalf("serve failure(%v), address(%v)", err, path)
+		}
+	}()
+}
+
+func serveMetrics(l net.Listener) error {
+	m := http.NewServeMux()
+	m.Handle("/metrics", legacyregistry.Handler()) //nolint, because azure cloud provider uses legacyregistry currently
+	return trapClosedConnErr(http.Serve(l, m))
+}
+
+func trapClosedConnErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(err.Error(), "use of closed network connection") {
+		return nil
+	}
+	return err
+}
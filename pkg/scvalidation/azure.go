@@ -0,0 +1,165 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scvalidation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"k8s.io/utils/ptr"
+)
+
+// DiskSKU is a disk SKU of a location
+type DiskSKU struct {
+	Name string
+	// Zones are the availability zones the SKU is available in, e.g. "1", empty if the location has no zones
+	Zones []string
+	// Restriction is the reason the SKU is not available to the subscription in the location, empty if available
+	Restriction string
+}
+
+// Usage is the usage and limit of a compute quota in a location
+type Usage struct {
+	Current int64
+	Limit   int64
+}
+
+// AzureClient gets the resources of the target subscription used by the validation
+type AzureClient interface {
+	// ListDiskSKUs returns the disk SKUs of location keyed by the lower case SKU name
+	ListDiskSKUs(ctx context.Context, location string) (map[string]DiskSKU, error)
+	// ListUsages returns the compute quota usages of location keyed by the usage name, e.g. PremiumDiskCount
+	ListUsages(ctx context.Context, location string) (map[string]Usage, error)
+	// GetDiskEncryptionSetLocation returns the location of the disk encryption set of id
+	GetDiskEncryptionSetLocation(ctx context.Context, id string) (string, error)
+}
+
+type armAzureClient struct {
+	subscriptionID string
+	cred           azcore.TokenCredential
+	options        *arm.ClientOptions
+}
+
+// NewAzureClient returns the client of subscriptionID using cred
+func NewAzureClient(subscriptionID string, cred azcore.TokenCredential, options *arm.ClientOptions) AzureClient {
+	return &armAzureClient{subscriptionID: subscriptionID, cred: cred, options: options}
+}
+
+func (c *armAzureClient) ListDiskSKUs(ctx context.Context, location string) (map[string]DiskSKU, error) {
+	client, err := armcompute.NewResourceSKUsClient(c.subscriptionID, c.cred, c.options)
+	if err != nil {
+		return nil, err
+	}
+	skus := map[string]DiskSKU{}
+	pager := client.NewListPager(&armcompute.ResourceSKUsClientListOptions{Filter: ptr.To(fmt.Sprintf("location eq '%s'", location))})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, sku := range page.Value {
+			if sku == nil || sku.Name == nil || !strings.EqualFold(ptr.Deref(sku.ResourceType, ""), "disks") {
+				continue
+			}
+			skus[strings.ToLower(*sku.Name)] = newDiskSKU(sku, location)
+		}
+	}
+	return skus, nil
+}
+
+// newDiskSKU returns the disk SKU of location, zones restricted to the subscription are removed
+func newDiskSKU(sku *armcompute.ResourceSKU, location string) DiskSKU {
+	diskSKU := DiskSKU{Name: *sku.Name}
+	for _, info := range sku.LocationInfo {
+		if info == nil || !strings.EqualFold(ptr.Deref(info.Location, ""), location) {
+			continue
+		}
+		for _, zone := range info.Zones {
+			if zone != nil {
+				diskSKU.Zones = append(diskSKU.Zones, *zone)
+			}
+		}
+	}
+	restrictedZones := map[string]bool{}
+	for _, restriction := range sku.Restrictions {
+		if restriction == nil || restriction.Type == nil {
+			continue
+		}
+		switch *restriction.Type {
+		case armcompute.ResourceSKURestrictionsTypeLocation:
+			diskSKU.Restriction = string(ptr.Deref(restriction.ReasonCode, ""))
+		case armcompute.ResourceSKURestrictionsTypeZone:
+			if restriction.RestrictionInfo != nil {
+				for _, zone := range restriction.RestrictionInfo.Zones {
+					if zone != nil {
+						restrictedZones[*zone] = true
+					}
+				}
+			}
+		}
+	}
+	zones := diskSKU.Zones[:0]
+	for _, zone := range diskSKU.Zones {
+		if !restrictedZones[zone] {
+			zones = append(zones, zone)
+		}
+	}
+	diskSKU.Zones = zones
+	return diskSKU
+}
+
+func (c *armAzureClient) ListUsages(ctx context.Context, location string) (map[string]Usage, error) {
+	client, err := armcompute.NewUsageClient(c.subscriptionID, c.cred, c.options)
+	if err != nil {
+		return nil, err
+	}
+	usages := map[string]Usage{}
+	pager := client.NewListPager(location, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, usage := range page.Value {
+			if usage == nil || usage.Name == nil || usage.Name.Value == nil {
+				continue
+			}
+			usages[*usage.Name.Value] = Usage{Current: int64(ptr.Deref(usage.CurrentValue, 0)), Limit: ptr.Deref(usage.Limit, 0)}
+		}
+	}
+	return usages, nil
+}
+
+func (c *armAzureClient) GetDiskEncryptionSetLocation(ctx context.Context, id string) (string, error) {
+	resourceID, err := arm.ParseResourceID(id)
+	if err != nil {
+		return "", err
+	}
+	client, err := armcompute.NewDiskEncryptionSetsClient(resourceID.SubscriptionID, c.cred, c.options)
+	if err != nil {
+		return "", err
+	}
+	des, err := client.Get(ctx, resourceID.ResourceGroupName, resourceID.Name, nil)
+	if err != nil {
+		return "", err
+	}
+	return ptr.Deref(des.Location, ""), nil
+}
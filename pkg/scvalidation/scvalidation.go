@@ -0,0 +1,487 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scvalidation validates StorageClass and VolumeSnapshotClass manifests of the driver before they are applied,
+// against the parameters accepted by the driver, the nodes of a target cluster and the disk SKUs, quota and disk
+// encryption sets of a target subscription.
+package scvalidation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+)
+
+const (
+	// SeverityError fails the validation, the class would not work as expected
+	SeverityError = "Error"
+	// SeverityWarning does not fail the validation
+	SeverityWarning = "Warning"
+
+	// quotaWarningRatio is the ratio of the used disk quota in the location reported as a warning
+	quotaWarningRatio = 0.9
+)
+
+// Finding is a problem of a class found by the validation
+type Finding struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Result is the validation result of a StorageClass or VolumeSnapshotClass of the driver
+type Result struct {
+	File     string    `json:"file"`
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Valid    bool      `json:"valid"`
+	Findings []Finding `json:"findings,omitempty"`
+}
+
+func (r *Result) errorf(format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Result) warningf(format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// Manifest is a StorageClass or VolumeSnapshotClass read from File
+type Manifest struct {
+	File                string
+	StorageClass        *storagev1.StorageClass
+	VolumeSnapshotClass *snapshotv1.VolumeSnapshotClass
+}
+
+// LoadManifests reads the StorageClasses and VolumeSnapshotClasses in the YAML and JSON files of dir and its
+// subdirectories, a file could contain multiple documents, other kinds are ignored
+func LoadManifests(dir string) ([]Manifest, error) {
+	var manifests []Manifest
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fileManifests, err := parseManifests(path, data)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		manifests = append(manifests, fileManifests...)
+		return nil
+	})
+	return manifests, err
+}
+
+func parseManifests(file string, data []byte) ([]Manifest, error) {
+	var manifests []Manifest
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return manifests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		typeMeta := metav1.TypeMeta{}
+		if err := yaml.Unmarshal(doc, &typeMeta); err != nil {
+			return nil, err
+		}
+		switch {
+		case typeMeta.Kind == "StorageClass" && strings.HasPrefix(typeMeta.APIVersion, storagev1.GroupName+"/"):
+			sc := &storagev1.StorageClass{}
+			if err := yaml.UnmarshalStrict(doc, sc); err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, Manifest{File: file, StorageClass: sc})
+		case typeMeta.Kind == "VolumeSnapshotClass" && strings.HasPrefix(typeMeta.APIVersion, snapshotv1.GroupName+"/"):
+			vsc := &snapshotv1.VolumeSnapshotClass{}
+			if err := yaml.UnmarshalStrict(doc, vsc); err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, Manifest{File: file, VolumeSnapshotClass: vsc})
+		}
+	}
+}
+
+// Validator validates the classes of a driver, the cluster checks are skipped if kubeClient is nil and the
+// subscription checks are skipped if azureClient is nil
+type Validator struct {
+	driverName  string
+	location    string
+	kubeClient  kubernetes.Interface
+	azureClient AzureClient
+
+	// disk SKUs and usages per location, listed once per validation
+	skus   map[string]map[string]DiskSKU
+	usages map[string]map[string]Usage
+	nodes  []v1.Node
+}
+
+// NewValidator returns a validator of the classes of driverName, location is the location of the disks of the
+// StorageClasses without the location parameter, i.e. the location of the cluster
+func NewValidator(driverName, location string, kubeClient kubernetes.Interface, azureClient AzureClient) *Validator {
+	return &Validator{
+		driverName:  driverName,
+		location:    strings.ToLower(location),
+		kubeClient:  kubeClient,
+		azureClient: azureClient,
+	}
+}
+
+// Validate returns the results of the classes of the driver in manifests, classes of other drivers are skipped
+func (v *Validator) Validate(ctx context.Context, manifests []Manifest) ([]Result, error) {
+	v.skus = map[string]map[string]DiskSKU{}
+	v.usages = map[string]map[string]Usage{}
+	v.nodes = nil
+	if v.kubeClient != nil {
+		nodes, err := v.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes: %w", err)
+		}
+		v.nodes = nodes.Items
+	}
+
+	results := []Result{}
+	for _, manifest := range manifests {
+		var result Result
+		switch {
+		case manifest.StorageClass != nil && manifest.StorageClass.Provisioner == v.driverName:
+			result = Result{File: manifest.File, Kind: "StorageClass", Name: manifest.StorageClass.Name}
+			v.validateStorageClass(ctx, manifest.StorageClass, &result)
+		case manifest.VolumeSnapshotClass != nil && manifest.VolumeSnapshotClass.Driver == v.driverName:
+			result = Result{File: manifest.File, Kind: "VolumeSnapshotClass", Name: manifest.VolumeSnapshotClass.Name}
+			v.validateVolumeSnapshotClass(ctx, manifest.VolumeSnapshotClass, &result)
+		default:
+			continue
+		}
+		result.Valid = true
+		for _, finding := range result.Findings {
+			if finding.Severity == SeverityError {
+				result.Valid = false
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (v *Validator) validateStorageClass(ctx context.Context, sc *storagev1.StorageClass, result *Result) {
+	// ParseDiskParameters keeps the parameters as the volume context which could be changed
+	parameters := make(map[string]string, len(sc.Parameters))
+	for k, value := range sc.Parameters {
+		parameters[k] = value
+	}
+	diskParams, err := azureutils.ParseDiskParameters(parameters)
+	if err != nil {
+		result.errorf("%v", err)
+		return
+	}
+	skuName, err := azureutils.ValidateDiskParameters(&diskParams, "", false)
+	if err != nil {
+		result.errorf("%v", err)
+		return
+	}
+	validateDiskFeatures(&diskParams, skuName, result)
+	isZRS := strings.HasSuffix(strings.ToLower(string(skuName)), "zrs")
+	allowedZones := v.getAllowedZones(sc)
+	if len(allowedZones) == 0 && !isZRS && (sc.VolumeBindingMode == nil || *sc.VolumeBindingMode == storagev1.VolumeBindingImmediate) {
+		result.warningf("volumeBindingMode Immediate without allowedTopologies creates zonal disks in a zone chosen without the pods, use WaitForFirstConsumer")
+	}
+	location := strings.ToLower(diskParams.Location)
+	if location == "" {
+		location = v.location
+	}
+
+	if v.kubeClient != nil {
+		v.validateStorageClassInCluster(ctx, sc, isZRS, allowedZones, location, result)
+	}
+	if v.azureClient != nil {
+		if location == "" {
+			result.warningf("subscription checks are skipped since the location is neither set in the parameters nor by the validator")
+			return
+		}
+		v.validateStorageClassInSubscription(ctx, &diskParams, string(skuName), allowedZones, location, result)
+	}
+}
+
+// validateDiskFeatures reports the parameters accepted by the driver but rejected by Azure, or by the attach of the
+// disks, with the SKU of the disks
+func validateDiskFeatures(diskParams *azureutils.ManagedDiskParameters, skuName armcompute.DiskStorageAccountTypes, result *Result) {
+	if ptr.Deref(diskParams.EnableBursting, false) && skuName != armcompute.DiskStorageAccountTypesPremiumLRS && skuName != armcompute.DiskStorageAccountTypesPremiumZRS {
+		result.errorf("enableBursting is only supported by Premium_LRS and Premium_ZRS disks, not %s, remove it or change skuName", skuName)
+	}
+	if (diskParams.DiskIOPSReadWrite != "" || diskParams.DiskMBPSReadWrite != "") &&
+		skuName != armcompute.DiskStorageAccountTypesUltraSSDLRS && skuName != armcompute.DiskStorageAccountTypesPremiumV2LRS {
+		result.errorf("DiskIOPSReadWrite and DiskMBpsReadWrite are only supported by UltraSSD_LRS and PremiumV2_LRS disks, not %s, remove them or change skuName", skuName)
+	}
+	// the driver sets cachingMode None on PremiumV2_LRS disks
+	if diskParams.MaxShares > 1 && skuName != armcompute.DiskStorageAccountTypesPremiumV2LRS {
+		if cachingMode, _ := azureutils.NormalizeCachingMode(diskParams.CachingMode); cachingMode != v1.AzureDataDiskCachingNone {
+			result.errorf("cachingMode %s is not supported by shared disks (maxShares %d), set cachingMode to None", cachingMode, diskParams.MaxShares)
+		}
+	}
+}
+
+func (v *Validator) validateStorageClassInCluster(ctx context.Context, sc *storagev1.StorageClass, isZRS bool, allowedZones []string, location string, result *Result) {
+	existing, err := v.kubeClient.StorageV1().StorageClasses().Get(ctx, sc.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		result.warningf("failed to get StorageClass %s in the cluster: %v", sc.Name, err)
+	case existing.Provisioner != sc.Provisioner || !reflect.DeepEqual(existing.Parameters, sc.Parameters) ||
+		!reflect.DeepEqual(existing.ReclaimPolicy, sc.ReclaimPolicy) || !reflect.DeepEqual(existing.VolumeBindingMode, sc.VolumeBindingMode):
+		result.errorf("StorageClass %s in the cluster has different provisioner, parameters, reclaimPolicy or volumeBindingMode, which are immutable, delete it before applying the manifest", sc.Name)
+	}
+
+	if len(v.nodes) == 0 {
+		return
+	}
+	nodeZones := map[string]bool{}
+	hasZonalNode := false
+	for _, node := range v.nodes {
+		zone := node.Labels[v.topologyKey()]
+		if zone == "" {
+			zone = node.Labels[consts.WellKnownTopologyKey]
+		}
+		nodeZones[zone] = true
+		if azureutils.IsValidAvailabilityZone(zone, location) {
+			hasZonalNode = true
+		}
+	}
+	if isZRS && !hasZonalNode {
+		result.errorf("zone redundant disks require availability zones, but no node is in an availability zone of %s", location)
+	}
+	if len(allowedZones) == 0 {
+		return
+	}
+	for _, zone := range allowedZones {
+		if nodeZones[zone] {
+			return
+		}
+	}
+	result.errorf("no node is in zones %v in allowedTopologies", allowedZones)
+}
+
+func (v *Validator) validateStorageClassInSubscription(ctx context.Context, diskParams *azureutils.ManagedDiskParameters, skuName string, allowedZones []string, location string, result *Result) {
+	skus, err := v.getDiskSKUs(ctx, location)
+	if err != nil {
+		result.errorf("failed to list disk SKUs in %s: %v", location, err)
+		return
+	}
+	v.validateSKU(skus, skuName, allowedZones, location, SeverityError, result)
+	for _, fallback := range diskParams.SkuFallback {
+		v.validateSKU(skus, fallback, allowedZones, location, SeverityWarning, result)
+	}
+
+	usages, err := v.getUsages(ctx, location)
+	if err != nil {
+		result.warningf("failed to list the quota usages in %s: %v", location, err)
+	} else if usageName := getDiskCountUsageName(skuName); usageName != "" {
+		if usage, ok := usages[usageName]; ok && usage.Limit > 0 {
+			switch {
+			case usage.Current >= usage.Limit:
+				result.errorf("quota %s in %s is exhausted: %d of %d", usageName, location, usage.Current, usage.Limit)
+			case float64(usage.Current) >= quotaWarningRatio*float64(usage.Limit):
+				result.warningf("quota %s in %s is almost exhausted: %d of %d", usageName, location, usage.Current, usage.Limit)
+			}
+		}
+	}
+
+	if diskParams.DiskEncryptionSetID != "" {
+		desLocation, err := v.azureClient.GetDiskEncryptionSetLocation(ctx, diskParams.DiskEncryptionSetID)
+		if err != nil {
+			result.errorf("could not get disk encryption set %s: %v", diskParams.DiskEncryptionSetID, err)
+		} else if !strings.EqualFold(desLocation, location) {
+			result.errorf("disk encryption set %s is in %s, but the disks are created in %s", diskParams.DiskEncryptionSetID, desLocation, location)
+		}
+	}
+}
+
+// validateSKU reports the SKU not available in the location, or in the zones in allowedTopologies, with severity
+func (v *Validator) validateSKU(skus map[string]DiskSKU, skuName string, allowedZones []string, location, severity string, result *Result) {
+	add := result.errorf
+	if severity == SeverityWarning {
+		add = result.warningf
+	}
+	sku, ok := skus[strings.ToLower(skuName)]
+	if !ok {
+		add("sku %s is not available in %s", skuName, location)
+		return
+	}
+	if sku.Restriction != "" {
+		add("sku %s is restricted in %s: %s", skuName, location, sku.Restriction)
+		return
+	}
+	if len(sku.Zones) == 0 {
+		return
+	}
+	zones := map[string]bool{}
+	for _, zone := range sku.Zones {
+		zones[fmt.Sprintf("%s-%s", location, zone)] = true
+	}
+	for _, zone := range allowedZones {
+		if zone != "" && !zones[zone] {
+			add("sku %s is not available in zone %s", skuName, zone)
+		}
+	}
+}
+
+func (v *Validator) getDiskSKUs(ctx context.Context, location string) (map[string]DiskSKU, error) {
+	if skus, ok := v.skus[location]; ok {
+		return skus, nil
+	}
+	skus, err := v.azureClient.ListDiskSKUs(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	v.skus[location] = skus
+	return skus, nil
+}
+
+func (v *Validator) getUsages(ctx context.Context, location string) (map[string]Usage, error) {
+	if usages, ok := v.usages[location]; ok {
+		return usages, nil
+	}
+	usages, err := v.azureClient.ListUsages(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	v.usages[location] = usages
+	return usages, nil
+}
+
+// getDiskCountUsageName returns the compute usage counting the disks of skuName, empty if the disks are not counted
+func getDiskCountUsageName(skuName string) string {
+	switch strings.ToLower(strings.SplitN(skuName, "_", 2)[0]) {
+	case "premium":
+		return "PremiumDiskCount"
+	case "standardssd":
+		return "StandardSSDDiskCount"
+	case "standard":
+		return "StandardDiskCount"
+	}
+	return ""
+}
+
+func (v *Validator) validateVolumeSnapshotClass(_ context.Context, vsc *snapshotv1.VolumeSnapshotClass, result *Result) {
+	var tags, tagValueDelimiter string
+	for k, value := range vsc.Parameters {
+		switch strings.ToLower(k) {
+		case consts.IncrementalField, consts.FsFreezeField:
+			if _, err := strconv.ParseBool(value); err != nil {
+				result.errorf("invalid %s: %s in VolumeSnapshotClass", k, value)
+			}
+		case consts.DataAccessAuthModeField:
+			if err := azureutils.ValidateDataAccessAuthMode(value); err != nil {
+				result.errorf("%v", err)
+			}
+		case consts.SnapshotRetentionDaysField, consts.MaxSnapshotsPerVolumeField:
+			if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+				result.errorf("invalid %s: %s in VolumeSnapshotClass, it must be a positive integer", k, value)
+			}
+		case consts.TagsField:
+			tags = value
+		case consts.TagValueDelimiterField:
+			tagValueDelimiter = value
+		case consts.ResourceGroupField, consts.LocationField, consts.UserAgentField, consts.SubscriptionIDField:
+		default:
+			result.errorf("invalid option %s in VolumeSnapshotClass", k)
+		}
+	}
+	if _, err := util.ConvertTagsToMap(tags, tagValueDelimiter); err != nil {
+		result.errorf("%v", err)
+	}
+}
+
+// topologyKey is the zone label of the nodes set by the driver
+func (v *Validator) topologyKey() string {
+	return fmt.Sprintf("topology.%s/zone", v.driverName)
+}
+
+// getAllowedZones returns the sorted zones in allowedTopologies of the StorageClass
+func (v *Validator) getAllowedZones(sc *storagev1.StorageClass) []string {
+	zones := map[string]bool{}
+	for _, term := range sc.AllowedTopologies {
+		for _, expr := range term.MatchLabelExpressions {
+			if expr.Key != consts.WellKnownTopologyKey && expr.Key != v.topologyKey() {
+				continue
+			}
+			for _, zone := range expr.Values {
+				zones[strings.ToLower(zone)] = true
+			}
+		}
+	}
+	allowedZones := make([]string, 0, len(zones))
+	for zone := range zones {
+		allowedZones = append(allowedZones, zone)
+	}
+	sort.Strings(allowedZones)
+	return allowedZones
+}
+
+// Summary returns the number of valid and invalid classes of results
+func Summary(results []Result) map[string]int {
+	summary := map[string]int{"valid": 0, "invalid": 0}
+	for _, result := range results {
+		if result.Valid {
+			summary["valid"]++
+		} else {
+			summary["invalid"]++
+		}
+	}
+	return summary
+}
+
+// MarshalReport returns the JSON report of results
+func MarshalReport(results []Result) ([]byte, error) {
+	return json.MarshalIndent(results, "", "  ")
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scvalidation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+type fakeAzureClient struct {
+	skus    map[string]DiskSKU
+	usages  map[string]Usage
+	desLocs map[string]string
+}
+
+func (c *fakeAzureClient) ListDiskSKUs(_ context.Context, _ string) (map[string]DiskSKU, error) {
+	return c.skus, nil
+}
+
+func (c *fakeAzureClient) ListUsages(_ context.Context, _ string) (map[string]Usage, error) {
+	return c.usages, nil
+}
+
+func (c *fakeAzureClient) GetDiskEncryptionSetLocation(_ context.Context, id string) (string, error) {
+	location, ok := c.desLocs[id]
+	if !ok {
+		return "", fmt.Errorf("not found")
+	}
+	return location, nil
+}
+
+func TestLoadManifests(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "classes.yaml"), []byte(`apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: managed-csi
+provisioner: disk.csi.azure.com
+parameters:
+  skuName: Premium_LRS
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+---
+apiVersion: snapshot.storage.k8s.io/v1
+kind: VolumeSnapshotClass
+metadata:
+  name: csi-azuredisk-vsc
+driver: disk.csi.azure.com
+deletionPolicy: Delete
+`), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "sc.json"), []byte(`{"apiVersion": "storage.k8s.io/v1", "kind": "StorageClass", "metadata": {"name": "zrs"}, "provisioner": "disk.csi.azure.com"}`), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a manifest"), 0600))
+
+	manifests, err := LoadManifests(dir)
+	require.NoError(t, err)
+	require.Len(t, manifests, 3)
+	assert.Equal(t, "managed-csi", manifests[0].StorageClass.Name)
+	assert.Equal(t, "Premium_LRS", manifests[0].StorageClass.Parameters["skuName"])
+	assert.Equal(t, "csi-azuredisk-vsc", manifests[1].VolumeSnapshotClass.Name)
+	assert.Equal(t, filepath.Join(dir, "sub", "sc.json"), manifests[2].File)
+	assert.Equal(t, "zrs", manifests[2].StorageClass.Name)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.yaml"), []byte(`apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: bad
+provisioner: disk.csi.azure.com
+unknownField: true
+`), 0600))
+	_, err = LoadManifests(dir)
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	waitForFirstConsumer := storagev1.VolumeBindingWaitForFirstConsumer
+	newSC := func(name string, parameters map[string]string, zones ...string) *storagev1.StorageClass {
+		sc := &storagev1.StorageClass{
+			ObjectMeta:        metav1.ObjectMeta{Name: name},
+			Provisioner:       consts.DefaultDriverName,
+			Parameters:        parameters,
+			VolumeBindingMode: &waitForFirstConsumer,
+		}
+		if len(zones) > 0 {
+			sc.AllowedTopologies = []v1.TopologySelectorTerm{{MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{{
+				Key:    consts.WellKnownTopologyKey,
+				Values: zones,
+			}}}}
+		}
+		return sc
+	}
+	desID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/diskEncryptionSets/des"
+
+	tests := []struct {
+		desc             string
+		sc               *storagev1.StorageClass
+		vsc              *snapshotv1.VolumeSnapshotClass
+		expectedValid    bool
+		expectedFindings []string
+	}{
+		{
+			desc:          "valid StorageClass",
+			sc:            newSC("valid", map[string]string{"skuName": "Premium_LRS"}, "eastus-1"),
+			expectedValid: true,
+		},
+		{
+			desc:             "invalid parameter",
+			sc:               newSC("invalid-parameter", map[string]string{"unknown": "value"}),
+			expectedFindings: []string{"Error: invalid parameter unknown in storage class"},
+		},
+		{
+			desc:             "bursting on standard SSD",
+			sc:               newSC("bursting", map[string]string{"skuName": "StandardSSD_LRS", "enableBursting": "true"}),
+			expectedFindings: []string{"Error: enableBursting is only supported by Premium_LRS and Premium_ZRS disks, not StandardSSD_LRS", "Error: quota StandardSSDDiskCount"},
+		},
+		{
+			desc:             "IOPS on premium SSD",
+			sc:               newSC("iops", map[string]string{"skuName": "Premium_LRS", "DiskIOPSReadWrite": "5000"}),
+			expectedFindings: []string{"Error: DiskIOPSReadWrite and DiskMBpsReadWrite are only supported by UltraSSD_LRS and PremiumV2_LRS disks, not Premium_LRS"},
+		},
+		{
+			desc:             "shared disk with default cachingMode",
+			sc:               newSC("shared", map[string]string{"skuName": "Premium_LRS", "maxShares": "2"}),
+			expectedFindings: []string{"Error: cachingMode ReadOnly is not supported by shared disks (maxShares 2), set cachingMode to None"},
+		},
+		{
+			desc:          "shared disk without caching",
+			sc:            newSC("shared-none", map[string]string{"skuName": "Premium_LRS", "maxShares": "2", "cachingMode": "None"}),
+			expectedValid: true,
+		},
+		{
+			desc:             "immediate binding without allowedTopologies",
+			sc:               &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "immediate"}, Provisioner: consts.DefaultDriverName, Parameters: map[string]string{"skuName": "Premium_LRS"}},
+			expectedValid:    true,
+			expectedFindings: []string{"Warning: volumeBindingMode Immediate"},
+		},
+		{
+			desc:             "sku not available",
+			sc:               newSC("ultra", map[string]string{"skuName": "UltraSSD_LRS"}),
+			expectedFindings: []string{"Error: sku UltraSSD_LRS is not available in eastus"},
+		},
+		{
+			desc:             "sku not available in zone",
+			sc:               newSC("zone", map[string]string{"skuName": "Premium_LRS"}, "eastus-3"),
+			expectedFindings: []string{"Error: no node is in zones [eastus-3]", "Error: sku Premium_LRS is not available in zone eastus-3"},
+		},
+		{
+			desc:             "fallback sku restricted",
+			sc:               newSC("fallback", map[string]string{"skuName": "Premium_LRS", "skuFallback": "PremiumV2_LRS"}),
+			expectedValid:    true,
+			expectedFindings: []string{"Warning: sku PremiumV2_LRS is restricted in eastus: NotAvailableForSubscription"},
+		},
+		{
+			desc:             "quota exhausted",
+			sc:               newSC("standard", map[string]string{"skuName": "StandardSSD_LRS"}),
+			expectedFindings: []string{"Error: quota StandardSSDDiskCount in eastus is exhausted: 100 of 100"},
+		},
+		{
+			desc:             "quota almost exhausted",
+			sc:               newSC("hdd", map[string]string{"skuName": "Standard_LRS"}),
+			expectedValid:    true,
+			expectedFindings: []string{"Warning: quota StandardDiskCount in eastus is almost exhausted: 95 of 100"},
+		},
+		{
+			desc:             "disk encryption set in another location",
+			sc:               newSC("des", map[string]string{"skuName": "Premium_LRS", "diskEncryptionSetID": desID}),
+			expectedFindings: []string{"Error: disk encryption set " + desID + " is in westus, but the disks are created in eastus"},
+		},
+		{
+			desc:             "existing StorageClass with different parameters",
+			sc:               newSC("existing", map[string]string{"skuName": "Premium_LRS"}),
+			expectedFindings: []string{"Error: StorageClass existing in the cluster has different"},
+		},
+		{
+			desc: "VolumeSnapshotClass with invalid option",
+			vsc: &snapshotv1.VolumeSnapshotClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "vsc"},
+				Driver:     consts.DefaultDriverName,
+				Parameters: map[string]string{"incremental": "maybe", "unknown": "value"},
+			},
+			expectedFindings: []string{"Error: invalid incremental: maybe in VolumeSnapshotClass", "Error: invalid option unknown in VolumeSnapshotClass"},
+		},
+		{
+			desc: "VolumeSnapshotClass with invalid retention policy",
+			vsc: &snapshotv1.VolumeSnapshotClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "vsc"},
+				Driver:     consts.DefaultDriverName,
+				Parameters: map[string]string{"retentionDays": "0", "maxSnapshotsPerVolume": "3"},
+			},
+			expectedFindings: []string{"Error: invalid retentionDays: 0 in VolumeSnapshotClass, it must be a positive integer"},
+		},
+	}
+
+	kubeClient := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{consts.WellKnownTopologyKey: "eastus-1"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{consts.WellKnownTopologyKey: "eastus-2"}}},
+		newSC("existing", map[string]string{"skuName": "StandardSSD_LRS"}),
+	)
+	azureClient := &fakeAzureClient{
+		skus: map[string]DiskSKU{
+			"premium_lrs":     {Name: "Premium_LRS", Zones: []string{"1", "2"}},
+			"premiumv2_lrs":   {Name: "PremiumV2_LRS", Restriction: "NotAvailableForSubscription"},
+			"standardssd_lrs": {Name: "StandardSSD_LRS"},
+			"standard_lrs":    {Name: "Standard_LRS"},
+		},
+		usages: map[string]Usage{
+			"StandardSSDDiskCount": {Current: 100, Limit: 100},
+			"StandardDiskCount":    {Current: 95, Limit: 100},
+			"PremiumDiskCount":     {Current: 1, Limit: 100},
+		},
+		desLocs: map[string]string{desID: "westus"},
+	}
+	validator := NewValidator(consts.DefaultDriverName, "eastus", kubeClient, azureClient)
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			results, err := validator.Validate(context.Background(), []Manifest{{File: "test.yaml", StorageClass: test.sc, VolumeSnapshotClass: test.vsc}})
+			require.NoError(t, err)
+			require.Len(t, results, 1)
+			assert.Equal(t, test.expectedValid, results[0].Valid, "%v", results[0].Findings)
+			findings := []string{}
+			for _, finding := range results[0].Findings {
+				findings = append(findings, fmt.Sprintf("%s: %s", finding.Severity, finding.Message))
+			}
+			require.Len(t, findings, len(test.expectedFindings), "%v", findings)
+			for _, expected := range test.expectedFindings {
+				found := false
+				for _, finding := range findings {
+					if strings.HasPrefix(finding, expected) {
+						found = true
+					}
+				}
+				assert.True(t, found, "%q not in %v", expected, findings)
+			}
+		})
+	}
+}
+
+func TestValidateSkipsOtherDrivers(t *testing.T) {
+	manifests := []Manifest{
+		{StorageClass: &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "file"}, Provisioner: "file.csi.azure.com", Parameters: map[string]string{"unknown": "value"}}},
+		{VolumeSnapshotClass: &snapshotv1.VolumeSnapshotClass{ObjectMeta: metav1.ObjectMeta{Name: "file"}, Driver: "file.csi.azure.com"}},
+		{StorageClass: &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "disk"}, Provisioner: consts.DefaultDriverName, VolumeBindingMode: ptr.To(storagev1.VolumeBindingWaitForFirstConsumer)}},
+	}
+	results, err := NewValidator(consts.DefaultDriverName, "", nil, nil).Validate(context.Background(), manifests)
+	require.NoError(t, err)
+	assert.Equal(t, []Result{{File: "", Kind: "StorageClass", Name: "disk", Valid: true}}, results)
+	assert.Equal(t, map[string]int{"valid": 1, "invalid": 0}, Summary(results))
+}
+
+func TestGetDiskCountUsageName(t *testing.T) {
+	assert.Equal(t, "PremiumDiskCount", getDiskCountUsageName("Premium_ZRS"))
+	assert.Equal(t, "StandardSSDDiskCount", getDiskCountUsageName("StandardSSD_LRS"))
+	assert.Equal(t, "StandardDiskCount", getDiskCountUsageName("Standard_LRS"))
+	assert.Equal(t, "", getDiskCountUsageName("UltraSSD_LRS"))
+}
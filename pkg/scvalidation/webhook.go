@@ -0,0 +1,91 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scvalidation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/admission"
+)
+
+// WebhookPath is the path of the StorageClass validating webhook
+const WebhookPath = "/validate-storageclass"
+
+// Webhook is a validating admission webhook rejecting StorageClasses of the driver whose parameters would fail
+// the provisioning of every PVC, only the checks of the parameters are done since the cluster and subscription
+// could change after the StorageClass is created
+type Webhook struct {
+	validator *Validator
+}
+
+// NewWebhook returns a StorageClass webhook of the StorageClasses provisioned by driverName
+func NewWebhook(driverName string) *Webhook {
+	return &Webhook{validator: NewValidator(driverName, "", nil, nil)}
+}
+
+// review returns the admission response of a StorageClass admission request, StorageClasses are admitted if they
+// could not be validated, warnings are returned to the client without rejecting the StorageClass
+func (w *Webhook) review(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Operation != admissionv1.Create || req.Kind.Kind != "StorageClass" {
+		return resp
+	}
+	sc := &storagev1.StorageClass{}
+	if err := json.Unmarshal(req.Object.Raw, sc); err != nil {
+		klog.Errorf("failed to decode StorageClass in admission request %s: %v", req.UID, err)
+		return resp
+	}
+	results, err := w.validator.Validate(ctx, []Manifest{{StorageClass: sc}})
+	if err != nil {
+		klog.Errorf("failed to validate StorageClass %s: %v", sc.Name, err)
+		return resp
+	}
+	var errs []string
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			if finding.Severity == SeverityError {
+				errs = append(errs, finding.Message)
+			} else {
+				resp.Warnings = append(resp.Warnings, finding.Message)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		reason := fmt.Sprintf("invalid StorageClass %s: %s", sc.Name, strings.Join(errs, "; "))
+		klog.V(2).Infof("rejecting %s", reason)
+		admission.Deny(resp, reason)
+	}
+	return resp
+}
+
+// ServeHTTP handles the AdmissionReview requests sent by the API server
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	admission.ReviewFunc(w.review).ServeHTTP(rw, r)
+}
+
+// Run serves the webhook over HTTPS with tls.crt and tls.key in certDir until ctx is done
+func (w *Webhook) Run(ctx context.Context, port int, certDir string) error {
+	return admission.Serve(ctx, fmt.Sprintf("StorageClass webhook of %s", w.validator.driverName), port, certDir, WebhookPath, w)
+}
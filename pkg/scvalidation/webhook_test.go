@@ -0,0 +1,151 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scvalidation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func newTestReview(t *testing.T, operation admissionv1.Operation, sc *storagev1.StorageClass) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(sc)
+	require.NoError(t, err)
+	return &admissionv1.AdmissionRequest{
+		UID:       "uid",
+		Kind:      metav1.GroupVersionKind{Group: storagev1.GroupName, Version: "v1", Kind: "StorageClass"},
+		Operation: operation,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestWebhookReview(t *testing.T) {
+	newSC := func(provisioner string, parameters map[string]string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{
+			ObjectMeta:        metav1.ObjectMeta{Name: "sc"},
+			Provisioner:       provisioner,
+			Parameters:        parameters,
+			VolumeBindingMode: ptr.To(storagev1.VolumeBindingWaitForFirstConsumer),
+		}
+	}
+	tests := []struct {
+		desc             string
+		operation        admissionv1.Operation
+		sc               *storagev1.StorageClass
+		expectedAllowed  bool
+		expectedMessage  string
+		expectedWarnings []string
+	}{
+		{
+			desc:            "valid StorageClass",
+			operation:       admissionv1.Create,
+			sc:              newSC(consts.DefaultDriverName, map[string]string{"skuName": "Premium_LRS", "enableBursting": "true"}),
+			expectedAllowed: true,
+		},
+		{
+			desc:            "invalid skuName",
+			operation:       admissionv1.Create,
+			sc:              newSC(consts.DefaultDriverName, map[string]string{"skuName": "Premium"}),
+			expectedMessage: "invalid StorageClass sc: azureDisk - Premium is not supported sku/storageaccounttype",
+		},
+		{
+			desc:            "bursting and IOPS on standard SSD",
+			operation:       admissionv1.Create,
+			sc:              newSC(consts.DefaultDriverName, map[string]string{"skuName": "StandardSSD_LRS", "enableBursting": "true", "DiskMBpsReadWrite": "200"}),
+			expectedMessage: "invalid StorageClass sc: enableBursting is only supported by Premium_LRS and Premium_ZRS disks, not StandardSSD_LRS, remove it or change skuName; DiskIOPSReadWrite and DiskMBpsReadWrite",
+		},
+		{
+			desc:      "warning",
+			operation: admissionv1.Create,
+			sc: &storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "sc"},
+				Provisioner: consts.DefaultDriverName,
+			},
+			expectedAllowed:  true,
+			expectedWarnings: []string{"volumeBindingMode Immediate without allowedTopologies creates zonal disks in a zone chosen without the pods, use WaitForFirstConsumer"},
+		},
+		{
+			desc:            "StorageClass of another driver",
+			operation:       admissionv1.Create,
+			sc:              newSC("file.csi.azure.com", map[string]string{"skuName": "Premium"}),
+			expectedAllowed: true,
+		},
+		{
+			desc:            "delete",
+			operation:       admissionv1.Delete,
+			sc:              newSC(consts.DefaultDriverName, map[string]string{"skuName": "Premium"}),
+			expectedAllowed: true,
+		},
+	}
+
+	w := NewWebhook(consts.DefaultDriverName)
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp := w.review(context.Background(), newTestReview(t, test.operation, test.sc))
+			assert.Equal(t, "uid", string(resp.UID))
+			assert.Equal(t, test.expectedAllowed, resp.Allowed)
+			if test.expectedAllowed {
+				assert.Nil(t, resp.Result)
+			} else {
+				require.NotNil(t, resp.Result)
+				assert.Equal(t, int32(http.StatusForbidden), resp.Result.Code)
+				assert.Contains(t, resp.Result.Message, test.expectedMessage)
+			}
+			assert.Equal(t, test.expectedWarnings, resp.Warnings)
+		})
+	}
+}
+
+func TestWebhookServeHTTP(t *testing.T) {
+	w := NewWebhook(consts.DefaultDriverName)
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: newTestReview(t, admissionv1.Create, &storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: "shared"},
+			Provisioner: consts.DefaultDriverName,
+			Parameters:  map[string]string{"maxShares": "2", "cachingMode": "ReadOnly"},
+		}),
+	}
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, WebhookPath, bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	result := admissionv1.AdmissionReview{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.NotNil(t, result.Response)
+	assert.False(t, result.Response.Allowed)
+	assert.Contains(t, result.Response.Result.Message, "cachingMode ReadOnly is not supported by shared disks (maxShares 2), set cachingMode to None")
+
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, WebhookPath, bytes.NewReader([]byte("invalid"))))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -133,7 +133,7 @@ func (t *dynamicProvisioningTestSuite) defineTests(isMultiZone bool) {
 			},
 		}
 
-		if isMultiZone && !isUsingInTreeVolumePlugin && !isCapzTest {
+		if isMultiZone && !isUsingInTreeVolumePlugin && !isCapzTest && len(ultraSSDZones) > 0 {
 			test.StorageClassParameters = map[string]string{
 				"skuName":           "UltraSSD_LRS",
 				"cachingmode":       "None",
@@ -148,6 +148,38 @@ func (t *dynamicProvisioningTestSuite) defineTests(isMultiZone bool) {
 		test.Run(ctx, cs, ns)
 	})
 
+	ginkgo.It("should create a ReFS volume with integrity streams on demand [disk.csi.azure.com] [Windows]", func(ctx ginkgo.SpecContext) {
+		skipIfUsingInTreeVolumePlugin()
+		skipIfReFSNotSupported()
+		pods := []testsuites.PodDetails{
+			{
+				Cmd: convertToPowershellorCmdCommandIfNecessary("echo 'hello world' > /mnt/test-1/data && grep 'hello world' /mnt/test-1/data"),
+				Volumes: t.normalizeVolumes([]testsuites.VolumeDetails{
+					{
+						FSType:       "refs",
+						ClaimSize:    "10Gi",
+						MountOptions: []string{"integritystreams"},
+						VolumeMount: testsuites.VolumeMountDetails{
+							NameGenerate:      "test-volume-",
+							MountPathGenerate: "/mnt/test-",
+						},
+						VolumeAccessMode: v1.ReadWriteOnce,
+					},
+				}, isMultiZone),
+				IsWindows:    isWindowsCluster,
+				WinServerVer: winServerVer,
+			},
+		}
+		test := testsuites.DynamicallyProvisionedCmdVolumeTest{
+			CSIDriver: testDriver,
+			Pods:      pods,
+			StorageClassParameters: map[string]string{
+				"skuName": "StandardSSD_LRS",
+			},
+		}
+		test.Run(ctx, cs, ns)
+	})
+
 	ginkgo.It("should create a pod with volume mount subpath [disk.csi.azure.com] [Windows]", func(ctx ginkgo.SpecContext) {
 		skipIfUsingInTreeVolumePlugin()
 
@@ -250,7 +282,7 @@ func (t *dynamicProvisioningTestSuite) defineTests(isMultiZone bool) {
 			Pods:                   pods,
 			StorageClassParameters: map[string]string{"skuName": "StandardSSD_LRS"},
 		}
-		if !isUsingInTreeVolumePlugin && (location == "westus2" || location == "westeurope") {
+		if !isUsingInTreeVolumePlugin && supportsZRS {
 			test.StorageClassParameters = map[string]string{"skuName": "StandardSSD_ZRS"}
 		}
 		if isAzureStackCloud {
@@ -259,6 +291,34 @@ func (t *dynamicProvisioningTestSuite) defineTests(isMultiZone bool) {
 		test.Run(ctx, cs, ns)
 	})
 
+	ginkgo.It("should not publish a ReadWriteOncePod volume to a second pod on the same node [disk.csi.azure.com]", func(ctx ginkgo.SpecContext) {
+		skipIfTestingInWindowsCluster()
+		skipIfUsingInTreeVolumePlugin()
+
+		pod := testsuites.PodDetails{
+			Cmd: "echo 'hello world' > /mnt/test-1/data && while true; do sleep 3600; done",
+			Volumes: t.normalizeVolumes([]testsuites.VolumeDetails{
+				{
+					ClaimSize: "10Gi",
+					VolumeMount: testsuites.VolumeMountDetails{
+						NameGenerate:      "test-volume-",
+						MountPathGenerate: "/mnt/test-",
+					},
+					VolumeAccessMode: v1.ReadWriteOncePod,
+				},
+			}, isMultiZone),
+		}
+		test := testsuites.DynamicallyProvisionedSingleWriterTest{
+			CSIDriver:              testDriver,
+			Pod:                    pod,
+			StorageClassParameters: map[string]string{"skuName": "StandardSSD_LRS"},
+		}
+		if supportsZRS {
+			test.StorageClassParameters = map[string]string{"skuName": "StandardSSD_ZRS"}
+		}
+		test.Run(ctx, cs, ns)
+	})
+
 	ginkgo.It("should create a raw block volume on demand [kubernetes.io/azure-disk] [disk.csi.azure.com]", func(ctx ginkgo.SpecContext) {
 		skipIfTestingInWindowsCluster()
 
@@ -389,7 +449,7 @@ func (t *dynamicProvisioningTestSuite) defineTests(isMultiZone bool) {
 			Pods:                   pods,
 			StorageClassParameters: map[string]string{"skuName": "StandardSSD_LRS"},
 		}
-		if !isUsingInTreeVolumePlugin && (location == "westus2" || location == "westeurope") {
+		if !isUsingInTreeVolumePlugin && supportsZRS {
 			test.StorageClassParameters = map[string]string{"skuName": "Premium_ZRS"}
 		}
 		if isAzureStackCloud {
@@ -763,6 +823,79 @@ func (t *dynamicProvisioningTestSuite) defineTests(isMultiZone bool) {
 		test.Run(ctx, cs, snapshotrcs, ns)
 	})
 
+	ginkgo.It("should restore a volume snapshot into a volume of larger size and make sure the filesystem is appropriately adjusted [disk.csi.azure.com]", func(ctx ginkgo.SpecContext) {
+		skipIfUsingInTreeVolumePlugin()
+		skipIfTestingInWindowsCluster()
+
+		pod := testsuites.PodDetails{
+			Cmd: convertToPowershellorCmdCommandIfNecessary("echo 'hello world' > /mnt/test-1/data && grep 'hello world' /mnt/test-1/data && sync"),
+			Volumes: t.normalizeVolumes([]testsuites.VolumeDetails{
+				{
+					FSType:    "xfs",
+					ClaimSize: "10Gi",
+					VolumeMount: testsuites.VolumeMountDetails{
+						NameGenerate:      "test-volume-",
+						MountPathGenerate: "/mnt/test-",
+					},
+					VolumeAccessMode: v1.ReadWriteOnce,
+				},
+			}, isMultiZone),
+		}
+		podWithSnapshot := testsuites.PodDetails{
+			Cmd: convertToPowershellorCmdCommandIfNecessary("grep 'hello world' /mnt/test-1/data && df -h | grep /mnt/test- | awk '{print $2}' | grep -E '19|20'"),
+		}
+		test := testsuites.DynamicallyProvisionedVolumeSnapshotTest{
+			CSIDriver:              testDriver,
+			Pod:                    pod,
+			PodWithSnapshot:        podWithSnapshot,
+			RestoredVolumeSize:     "20Gi",
+			StorageClassParameters: map[string]string{"skuName": "StandardSSD_LRS", "fsType": "xfs"},
+		}
+		if isAzureStackCloud {
+			test.StorageClassParameters = map[string]string{"skuName": "Standard_LRS", "fsType": "xfs"}
+		}
+		test.Run(ctx, cs, snapshotrcs, ns)
+	})
+
+	ginkgo.It("should create a pod, write to its pv, take a volume snapshot, and restore the snapshot into a different zone [disk.csi.azure.com]", func(ctx ginkgo.SpecContext) {
+		skipIfUsingInTreeVolumePlugin()
+		skipIfTestingInWindowsCluster()
+		skipIfOnAzureStackCloud()
+		if !isMultiZone {
+			ginkgo.Skip("test case is only available for multi-zone cluster")
+		}
+
+		pod := testsuites.PodDetails{
+			Cmd: convertToPowershellorCmdCommandIfNecessary("echo 'hello world' > /mnt/test-1/data"),
+			Volumes: t.normalizeVolumes([]testsuites.VolumeDetails{
+				{
+					FSType:    getFSType(isWindowsCluster),
+					ClaimSize: "10Gi",
+					VolumeMount: testsuites.VolumeMountDetails{
+						NameGenerate:      "test-volume-",
+						MountPathGenerate: "/mnt/test-",
+					},
+					VolumeAccessMode: v1.ReadWriteOnce,
+				},
+			}, isMultiZone),
+		}
+		podWithSnapshot := testsuites.PodDetails{
+			Cmd: convertToPowershellorCmdCommandIfNecessary("grep 'hello world' /mnt/test-1/data"),
+		}
+		test := testsuites.DynamicallyProvisionedVolumeSnapshotCrossZoneTest{
+			CSIDriver:       testDriver,
+			Pod:             pod,
+			PodWithSnapshot: podWithSnapshot,
+			// LRS disks are zonal, so the restored disk is pinned to a different zone than the source disk
+			StorageClassParameters: map[string]string{"skuName": "StandardSSD_LRS"},
+			SnapshotStorageClassParameters: map[string]string{
+				"incremental": "true",
+			},
+			AllowedTopologyValues: t.allowedTopologyValues,
+		}
+		test.Run(ctx, cs, snapshotrcs, ns)
+	})
+
 	ginkgo.It("should create a pod, write to its pv, take a volume snapshot with xfs fs, overwrite data in original pv, create another pod from the snapshot, and read unaltered original data from original pv[disk.csi.azure.com]", func(ctx ginkgo.SpecContext) {
 		skipIfUsingInTreeVolumePlugin()
 		skipIfTestingInWindowsCluster()
@@ -850,12 +983,9 @@ func (t *dynamicProvisioningTestSuite) defineTests(isMultiZone bool) {
 			PodWithSnapshot:        podWithSnapshot,
 			StorageClassParameters: map[string]string{"skuName": "StandardSSD_LRS"},
 			SnapshotStorageClassParameters: map[string]string{
-				"incremental": "true", "dataAccessAuthMode": "AzureActiveDirectory", "location": "westus2",
+				"incremental": "true", "dataAccessAuthMode": "AzureActiveDirectory", "location": getSecondaryLocation(),
 			},
 		}
-		if location == "westus2" {
-			test.SnapshotStorageClassParameters["location"] = "westeurope"
-		}
 		if isAzureStackCloud {
 			test.StorageClassParameters = map[string]string{"skuName": "Standard_LRS"}
 		}
@@ -894,12 +1024,9 @@ func (t *dynamicProvisioningTestSuite) defineTests(isMultiZone bool) {
 			PodWithSnapshot:        podWithSnapshot,
 			StorageClassParameters: map[string]string{"skuName": "StandardSSD_LRS"},
 			SnapshotStorageClassParameters: map[string]string{
-				"incremental": "true", "dataAccessAuthMode": "AzureActiveDirectory", "location": "westus2",
+				"incremental": "true", "dataAccessAuthMode": "AzureActiveDirectory", "location": getSecondaryLocation(),
 			},
 		}
-		if location == "westus2" {
-			test.SnapshotStorageClassParameters["location"] = "westeurope"
-		}
 		if isAzureStackCloud {
 			test.StorageClassParameters = map[string]string{"skuName": "Standard_LRS"}
 		}
@@ -1131,6 +1258,38 @@ func (t *dynamicProvisioningTestSuite) defineTests(isMultiZone bool) {
 		test.Run(ctx, cs, ns)
 	})
 
+	ginkgo.DescribeTable("should hold the volume until the pod is gone and detach the disk when deleting objects out of order [disk.csi.azure.com]", func(ctx ginkgo.SpecContext, scenario testsuites.VolumeProtectionScenario) {
+		pod := testsuites.PodDetails{
+			Cmd: convertToPowershellorCmdCommandIfNecessary("while true; do echo $(date -u) >> /mnt/test-1/data; sleep 3600; done"),
+			Volumes: t.normalizeVolumes([]testsuites.VolumeDetails{
+				{
+					ClaimSize: "10Gi",
+					VolumeMount: testsuites.VolumeMountDetails{
+						NameGenerate:      "test-volume-",
+						MountPathGenerate: "/mnt/test-",
+					},
+					VolumeAccessMode: v1.ReadWriteOnce,
+				},
+			}, isMultiZone),
+			IsWindows:    isWindowsCluster,
+			WinServerVer: winServerVer,
+		}
+		test := testsuites.DynamicallyProvisionedVolumeProtectionTest{
+			CSIDriver:              testDriver,
+			Pod:                    pod,
+			StorageClassParameters: map[string]string{"skuName": "StandardSSD_LRS"},
+			Scenario:               scenario,
+		}
+		if !isUsingInTreeVolumePlugin && supportsZRS {
+			test.StorageClassParameters = map[string]string{"skuName": "StandardSSD_ZRS"}
+		}
+		test.Run(ctx, cs, ns)
+	},
+		ginkgo.Entry("deleting PVC with running pod", testsuites.DeletePVCWithRunningPod),
+		ginkgo.Entry("deleting PV out of band with running pod", testsuites.DeletePVWithRunningPod),
+		ginkgo.Entry("deleting namespace during attach", testsuites.DeleteNamespaceDuringAttach),
+	)
+
 	ginkgo.It("should create a statefulset object, write and read to it, delete the pod and write and read to it again [kubernetes.io/azure-disk] [disk.csi.azure.com] [Windows]", func(ctx ginkgo.SpecContext) {
 		pod := testsuites.PodDetails{
 			Cmd: convertToPowershellorCmdCommandIfNecessary("echo 'hello world' >> /mnt/test-1/data && while true; do sleep 3600; done"),
@@ -149,7 +149,7 @@ var _ = ginkgo.Describe("Pre-Provisioned", func() {
 				"skuName":     "Premium_LRS",
 				"maxShares":   "2",
 				"cachingMode": "None",
-				"location":    "eastus2",
+				"location":    location,
 			}
 			req.VolumeCapabilities[0].AccessType = &csi.VolumeCapability_Block{
 				Block: &csi.VolumeCapability_BlockVolume{},
@@ -239,10 +239,11 @@ var _ = ginkgo.Describe("Pre-Provisioned", func() {
 		ginkgo.It("should succeed when creating a PremiumV2_LRS disk [disk.csi.azure.com][windows]", func(ctx ginkgo.SpecContext) {
 			skipIfUsingInTreeVolumePlugin()
 			skipIfOnAzureStackCloud()
+			skipIfNotPremiumV2Supported()
 			req := makeCreateVolumeReq("premium-v2-disk", 100)
 			req.Parameters = map[string]string{
 				"skuName":           "PremiumV2_LRS",
-				"location":          "eastus", // eastus2euap, swedencentral, westeurope
+				"location":          location,
 				"DiskIOPSReadWrite": "3000",
 				"DiskMBpsReadWrite": "200",
 			}
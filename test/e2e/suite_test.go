@@ -50,6 +50,8 @@ const (
 	cloudNameEnvVar        = "AZURE_CLOUD_NAME"
 	defaultReportDir       = "/workspace/_artifacts"
 	inTreeStorageClass     = "kubernetes.io/azure-disk"
+	// region of cross region tests, a default is used for the public cloud if empty
+	secondaryLocationEnvVar = "SECONDARY_LOCATION"
 )
 
 var (
@@ -63,6 +65,8 @@ var (
 	isCapzTest                = os.Getenv("NODE_MACHINE_TYPE") != ""
 	location                  string
 	supportsZRS               bool
+	ultraSSDZones             []string
+	premiumV2Zones            []string
 )
 
 type testCmd struct {
@@ -96,39 +100,19 @@ var _ = ginkgo.BeforeSuite(func(ctx ginkgo.SpecContext) {
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		location = creds.Location
-		supportZRSRegions := []string{
-			"southafricanorth",
-			"eastasia",
-			"southeastasia",
-			"australiaeast",
-			"brazilsouth",
-			"westeurope",
-			"northeurope",
-			"francecentral",
-			"centralindia",
-			"italynorth",
-			"japaneast",
-			"koreacentral",
-			"norwayeast",
-			"polandcentral",
-			"qatarcentral",
-			"swedencentral",
-			"switzerlandnorth",
-			"uaenorth",
-			"uksouth",
-			"eastus",
-			"eastus2",
-			"southcentralus",
-			"westus2",
-			"westus3",
-		}
-		supportsZRS = false
-		for _, region := range supportZRSRegions {
-			if location == region {
-				supportsZRS = true
-				break
+		// probe the disk SKUs of the region instead of relying on a list of regions,
+		// so that the suites run against any region and sovereign cloud
+		if !isAzureStackCloud {
+			diskFeatures, err := azureClient.GetDiskFeatures(ctx, location)
+			if err != nil {
+				log.Printf("failed to probe disk features in %s, tests of ZRS, UltraSSD and PremiumV2 disks are skipped: %v", location, err)
+			} else {
+				supportsZRS = diskFeatures.ZRS
+				ultraSSDZones = diskFeatures.UltraSSDZones
+				premiumV2Zones = diskFeatures.PremiumV2Zones
 			}
 		}
+		log.Printf("disk features in %s: ZRS(%v) UltraSSD zones(%v) PremiumV2 zones(%v)", location, supportsZRS, ultraSSDZones, premiumV2Zones)
 
 		// Install Azure Disk CSI Driver on cluster from project root
 		e2eBootstrap := testCmd{
@@ -155,7 +139,8 @@ var _ = ginkgo.BeforeSuite(func(ctx ginkgo.SpecContext) {
 			Endpoint:               fmt.Sprintf("unix:///tmp/csi-%s.sock", string(uuid.NewUUID())),
 		}
 		os.Setenv("AZURE_CREDENTIAL_FILE", credentials.TempAzureCredentialFilePath)
-		azurediskDriver = azuredisk.NewDriver(&driverOptions)
+		azurediskDriver, err = azuredisk.NewDriver(&driverOptions)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		go func() {
 			err := azurediskDriver.Run(context.Background())
@@ -318,6 +303,32 @@ func skipIfNotZRSSupported() {
 	}
 }
 
+func skipIfNotPremiumV2Supported() {
+	if len(premiumV2Zones) == 0 {
+		ginkgo.Skip("test case not supported on regions without PremiumV2 disks")
+	}
+}
+
+// skipIfReFSNotSupported skips the test unless the nodes are Windows Server with the host process node plugin,
+// CSI proxy only formats NTFS volumes
+func skipIfReFSNotSupported() {
+	if !isWindowsCluster || !isWindowsHPCDeployment {
+		ginkgo.Skip("test case only supported by Windows clusters with host process deployment drivers")
+	}
+}
+
+// getSecondaryLocation returns the region other than the cluster region used by cross region tests,
+// it's set by SECONDARY_LOCATION for regions not paired below
+func getSecondaryLocation() string {
+	if secondaryLocation := os.Getenv(secondaryLocationEnvVar); secondaryLocation != "" {
+		return secondaryLocation
+	}
+	if location == "westus2" {
+		return "westeurope"
+	}
+	return "westus2"
+}
+
 func convertToPowershellorCmdCommandIfNecessary(command string) string {
 	if !isWindowsCluster {
 		return command
@@ -0,0 +1,61 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testsuites
+
+import (
+	"context"
+
+	"sigs.k8s.io/azuredisk-csi-driver/test/e2e/driver"
+
+	"github.com/onsi/ginkgo/v2"
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+// DynamicallyProvisionedSingleWriterTest will provision required StorageClass, ReadWriteOncePod PVC and Pod
+// Testing that a second Pod using the PVC is not started on the node of the first Pod
+type DynamicallyProvisionedSingleWriterTest struct {
+	CSIDriver              driver.DynamicPVTestDriver
+	Pod                    PodDetails
+	StorageClassParameters map[string]string
+}
+
+func (t *DynamicallyProvisionedSingleWriterTest) Run(ctx context.Context, client clientset.Interface, namespace *v1.Namespace) {
+	tpod, cleanup := t.Pod.SetupWithDynamicVolumes(ctx, client, namespace, t.CSIDriver, t.StorageClassParameters)
+	// defer must be called here for resources not get removed before using them
+	for i := range cleanup {
+		defer cleanup[i](ctx)
+	}
+
+	ginkgo.By("deploying the pod")
+	tpod.Create(ctx)
+	defer tpod.Cleanup(ctx)
+	ginkgo.By("checking that the pod is running")
+	tpod.WaitForRunningLong(ctx)
+
+	// the scheduler does not place a second pod of a ReadWriteOncePod PVC, so the node is set
+	// to check that the driver fences the volume on NodePublishVolume
+	ginkgo.By("deploying a second pod using the same PVC on the same node")
+	tpod2 := NewTestPod(client, namespace, t.Pod.Cmd, t.Pod.IsWindows, t.Pod.WinServerVer)
+	tpod2.pod.Spec.Volumes = tpod.pod.Spec.Volumes
+	tpod2.pod.Spec.Containers[0].VolumeMounts = tpod.pod.Spec.Containers[0].VolumeMounts
+	tpod2.pod.Spec.NodeName = tpod.pod.Spec.NodeName
+	tpod2.Create(ctx)
+	defer tpod2.Cleanup(ctx)
+	ginkgo.By("checking that the second pod has 'FailedMount' event")
+	tpod2.WaitForFailedMountEvent(ctx, "MountVolume.SetUp failed for volume")
+}
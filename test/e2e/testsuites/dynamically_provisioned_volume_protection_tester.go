@@ -0,0 +1,226 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testsuites
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/kubernetes/test/e2e/framework"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/test/e2e/driver"
+	"sigs.k8s.io/azuredisk-csi-driver/test/utils/azure"
+	"sigs.k8s.io/azuredisk-csi-driver/test/utils/credentials"
+)
+
+const (
+	pvcProtectionFinalizer = "kubernetes.io/pvc-protection"
+	pvProtectionFinalizer  = "kubernetes.io/pv-protection"
+)
+
+// VolumeProtectionScenario is the out of order deletion exercised by DynamicallyProvisionedVolumeProtectionTest
+type VolumeProtectionScenario int
+
+const (
+	// DeletePVCWithRunningPod deletes the PVC while the pod using it is still running
+	DeletePVCWithRunningPod VolumeProtectionScenario = iota
+	// DeletePVWithRunningPod deletes the bound PV out of band while the pod using it is still running
+	DeletePVWithRunningPod
+	// DeleteNamespaceDuringAttach deletes the namespace while the volume is being attached to the pod
+	DeleteNamespaceDuringAttach
+)
+
+// DynamicallyProvisionedVolumeProtectionTest will provision required StorageClass, PVC and Pod
+// Deleting the PVC, PV or namespace while the volume is in use
+// Testing if the PV/PVC protection finalizers hold the objects until the pod is gone
+// and the disk is detached (and deleted when reclaimPolicy is Delete) afterwards
+type DynamicallyProvisionedVolumeProtectionTest struct {
+	CSIDriver              driver.DynamicPVTestDriver
+	Pod                    PodDetails
+	StorageClassParameters map[string]string
+	Scenario               VolumeProtectionScenario
+}
+
+func (t *DynamicallyProvisionedVolumeProtectionTest) Run(ctx context.Context, client clientset.Interface, namespace *v1.Namespace) {
+	volume := t.Pod.Volumes[0]
+	// Force volume binding mode to immediate so the disk URI is known before the pod is created
+	volumeBindingMode := storagev1.VolumeBindingImmediate
+	volume.VolumeBindingMode = &volumeBindingMode
+	tpvc, cleanup := volume.SetupDynamicPersistentVolumeClaim(ctx, client, namespace, t.CSIDriver, t.StorageClassParameters)
+	for i := range cleanup {
+		defer cleanup[i](ctx)
+	}
+
+	tpod := NewTestPod(client, namespace, t.Pod.Cmd, t.Pod.IsWindows, t.Pod.WinServerVer)
+	tpod.SetupVolume(tpvc.persistentVolumeClaim, fmt.Sprintf("%s%d", volume.VolumeMount.NameGenerate, 1), fmt.Sprintf("%s%d", volume.VolumeMount.MountPathGenerate, 1), volume.VolumeMount.ReadOnly)
+
+	diskURI := tpvc.persistentVolume.Spec.CSI.VolumeHandle
+	disksClient, resourceGroup, diskName := getDisksClientForDiskURI(diskURI)
+
+	ginkgo.By("deploying the pod")
+	tpod.Create(ctx)
+
+	switch t.Scenario {
+	case DeletePVCWithRunningPod:
+		ginkgo.By("checking that the pod is running")
+		tpod.WaitForRunning(ctx)
+
+		ginkgo.By(fmt.Sprintf("deleting PVC %q while the pod is running", tpvc.persistentVolumeClaim.Name))
+		err := client.CoreV1().PersistentVolumeClaims(namespace.Name).Delete(ctx, tpvc.persistentVolumeClaim.Name, metav1.DeleteOptions{})
+		framework.ExpectNoError(err)
+
+		ginkgo.By("checking that the PVC is held by the pvc-protection finalizer")
+		pvc, err := client.CoreV1().PersistentVolumeClaims(namespace.Name).Get(ctx, tpvc.persistentVolumeClaim.Name, metav1.GetOptions{})
+		framework.ExpectNoError(err)
+		gomega.Expect(pvc.DeletionTimestamp).NotTo(gomega.BeNil())
+		gomega.Expect(pvc.Finalizers).To(gomega.ContainElement(pvcProtectionFinalizer))
+		waitForDiskState(ctx, disksClient, resourceGroup, diskName, armcompute.DiskStateAttached, false)
+
+		ginkgo.By("deleting the pod")
+		tpod.Cleanup(ctx)
+	case DeletePVWithRunningPod:
+		ginkgo.By("checking that the pod is running")
+		tpod.WaitForRunning(ctx)
+
+		ginkgo.By(fmt.Sprintf("deleting PV %q while the pod is running", tpvc.persistentVolume.Name))
+		err := client.CoreV1().PersistentVolumes().Delete(ctx, tpvc.persistentVolume.Name, metav1.DeleteOptions{})
+		framework.ExpectNoError(err)
+
+		ginkgo.By("checking that the PV is held by the pv-protection finalizer")
+		pv, err := client.CoreV1().PersistentVolumes().Get(ctx, tpvc.persistentVolume.Name, metav1.GetOptions{})
+		framework.ExpectNoError(err)
+		gomega.Expect(pv.DeletionTimestamp).NotTo(gomega.BeNil())
+		gomega.Expect(pv.Finalizers).To(gomega.ContainElement(pvProtectionFinalizer))
+		waitForDiskState(ctx, disksClient, resourceGroup, diskName, armcompute.DiskStateAttached, false)
+
+		ginkgo.By("deleting the pod")
+		tpod.Cleanup(ctx)
+	case DeleteNamespaceDuringAttach:
+		ginkgo.By(fmt.Sprintf("deleting namespace %q while the volume is being attached", namespace.Name))
+		err := client.CoreV1().Namespaces().Delete(ctx, namespace.Name, metav1.DeleteOptions{})
+		framework.ExpectNoError(err)
+		err = waitForNamespaceDeleted(ctx, client, namespace.Name, 5*time.Second, 10*time.Minute)
+		framework.ExpectNoError(err)
+	}
+
+	// with reclaimPolicy Delete, the disk may already be deleted once the PVC or namespace is gone
+	ginkgo.By("checking that the disk is detached")
+	waitForDiskState(ctx, disksClient, resourceGroup, diskName, armcompute.DiskStateUnattached, tpvc.ReclaimPolicy() == v1.PersistentVolumeReclaimDelete)
+
+	// the PVC cleanup waits for the PV to be deleted
+	tpvc.Cleanup(ctx)
+
+	if t.Scenario == DeletePVWithRunningPod {
+		// the PV object may be gone before the provisioner deletes the disk, clean it up to avoid leaking it
+		ginkgo.By(fmt.Sprintf("deleting disk %s if it still exists", diskName))
+		if err := disksClient.Delete(ctx, resourceGroup, diskName); err != nil && !isDiskNotFoundError(err) {
+			framework.ExpectNoError(err)
+		}
+		return
+	}
+
+	if tpvc.ReclaimPolicy() == v1.PersistentVolumeReclaimDelete {
+		ginkgo.By("checking that the disk is deleted")
+		err := wait.PollUntilContextTimeout(ctx, 15*time.Second, 10*time.Minute, true, func(ctx context.Context) (bool, error) {
+			if _, err := disksClient.Get(ctx, resourceGroup, diskName); err != nil {
+				if isDiskNotFoundError(err) {
+					return true, nil
+				}
+				return false, err
+			}
+			ginkgo.By(fmt.Sprintf("disk %s still exists, wait and recheck", diskName))
+			return false, nil
+		})
+		framework.ExpectNoError(err, fmt.Sprintf("waiting for disk %s deletion returned with error: %v", diskName, err))
+	}
+}
+
+func getDisksClientForDiskURI(diskURI string) (diskclient.Interface, string, string) {
+	diskName, err := azureutils.GetDiskName(diskURI)
+	framework.ExpectNoError(err, fmt.Sprintf("Error getting diskName for azuredisk %v", err))
+	resourceGroup, err := azureutils.GetResourceGroupFromURI(diskURI)
+	framework.ExpectNoError(err, fmt.Sprintf("Error getting resourceGroup for azuredisk %v", err))
+
+	creds, err := credentials.CreateAzureCredentialFile()
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	azureClient, err := azure.GetAzureClient(creds.Cloud, creds.SubscriptionID, creds.AADClientID, creds.TenantID, creds.AADClientSecret, creds.AADFederatedTokenFile)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	disksClient, err := azureClient.GetAzureDisksClient()
+	framework.ExpectNoError(err, fmt.Sprintf("Error getting client for azuredisk %v", err))
+	return disksClient, resourceGroup, diskName
+}
+
+// waitForDiskState waits for the disk to be in state, a deleted disk is also accepted if deletionExpected is true
+func waitForDiskState(ctx context.Context, disksClient diskclient.Interface, resourceGroup, diskName string, state armcompute.DiskState, deletionExpected bool) {
+	err := wait.PollUntilContextTimeout(ctx, 15*time.Second, 10*time.Minute, true, func(ctx context.Context) (bool, error) {
+		disk, err := disksClient.Get(ctx, resourceGroup, diskName)
+		if err != nil {
+			if deletionExpected && isDiskNotFoundError(err) {
+				ginkgo.By(fmt.Sprintf("disk %s is already deleted", diskName))
+				return true, nil
+			}
+			return false, fmt.Errorf("Error getting disk for azuredisk %v", err)
+		}
+		if disk.Properties.DiskState != nil && *disk.Properties.DiskState == state {
+			return true, nil
+		}
+		ginkgo.By(fmt.Sprintf("current disk state(%v) is not in %v state, wait and recheck", ptrToString(disk.Properties.DiskState), state))
+		return false, nil
+	})
+	framework.ExpectNoError(err, fmt.Sprintf("waiting for disk %s to be %v returned with error: %v", diskName, state, err))
+}
+
+func waitForNamespaceDeleted(ctx context.Context, c clientset.Interface, namespace string, poll, timeout time.Duration) error {
+	framework.Logf("Waiting up to %v for namespace %s to be removed", timeout, namespace)
+	return wait.PollUntilContextTimeout(ctx, poll, timeout, true, func(ctx context.Context) (bool, error) {
+		if _, err := c.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{}); err != nil {
+			if apierrs.IsNotFound(err) {
+				return true, nil
+			}
+			framework.Logf("Failed to get namespace %q, retrying in %v. Error: %v", namespace, poll, err)
+		}
+		return false, nil
+	})
+}
+
+func isDiskNotFoundError(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+func ptrToString(state *armcompute.DiskState) string {
+	if state == nil {
+		return ""
+	}
+	return string(*state)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testsuites
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	restclientset "k8s.io/client-go/rest"
+
+	"sigs.k8s.io/azuredisk-csi-driver/test/e2e/driver"
+)
+
+// DynamicallyProvisionedVolumeSnapshotCrossZoneTest will provision required StorageClass(es),VolumeSnapshotClass(es), PVC(s) and Pod(s)
+// Write data to a zonal disk, take a snapshot of it and restore the snapshot into a disk in a different zone
+// Testing if the Pod in the other zone can read the data from the restored volume
+// This test only supports a single volume
+type DynamicallyProvisionedVolumeSnapshotCrossZoneTest struct {
+	CSIDriver                      driver.PVTestDriver
+	Pod                            PodDetails
+	PodWithSnapshot                PodDetails
+	StorageClassParameters         map[string]string
+	SnapshotStorageClassParameters map[string]string
+	// Zones the restored volume could be placed in, the zone of the source volume is excluded at runtime
+	AllowedTopologyValues []string
+}
+
+func (t *DynamicallyProvisionedVolumeSnapshotCrossZoneTest) Run(ctx context.Context, client clientset.Interface, restclient restclientset.Interface, namespace *v1.Namespace) {
+	tpod := NewTestPod(client, namespace, t.Pod.Cmd, t.Pod.IsWindows, t.Pod.WinServerVer)
+	volume := t.Pod.Volumes[0]
+	tpvc, pvcCleanup := volume.SetupDynamicPersistentVolumeClaim(ctx, client, namespace, t.CSIDriver, t.StorageClassParameters)
+	for i := range pvcCleanup {
+		defer pvcCleanup[i](ctx)
+	}
+	tpod.SetupVolume(tpvc.persistentVolumeClaim, volume.VolumeMount.NameGenerate+"1", volume.VolumeMount.MountPathGenerate+"1", volume.VolumeMount.ReadOnly)
+	ginkgo.By("deploying the pod")
+	tpod.Create(ctx)
+	defer tpod.Cleanup(ctx)
+	ginkgo.By("checking that the pod's command exits with no error")
+	tpod.WaitForSuccess(ctx)
+	ginkgo.By("sleep 10s to make sure the data is written to the disk")
+	time.Sleep(time.Millisecond * 10000)
+
+	sourceZone := tpod.GetZoneForVolume(ctx, 0)
+	gomega.Expect(sourceZone).NotTo(gomega.BeEmpty(), "source volume is expected to be zonal")
+
+	targetZones := []string{}
+	for _, zone := range t.AllowedTopologyValues {
+		if zone != sourceZone {
+			targetZones = append(targetZones, zone)
+		}
+	}
+	if len(targetZones) == 0 {
+		ginkgo.Skip(fmt.Sprintf("no zone other than %s is available to restore the snapshot into", sourceZone))
+	}
+
+	ginkgo.By("creating volume snapshot class")
+	tvsc, cleanup := CreateVolumeSnapshotClass(restclient, namespace, t.SnapshotStorageClassParameters, t.CSIDriver)
+	tvsc.Create(ctx)
+	defer cleanup()
+
+	ginkgo.By("taking snapshots")
+	snapshot := tvsc.CreateSnapshot(ctx, tpvc.persistentVolumeClaim)
+	defer tvsc.DeleteSnapshot(ctx, snapshot)
+	tvsc.ReadyToUse(ctx, snapshot)
+
+	snapshotVolume := volume
+	snapshotVolume.AllowedTopologyValues = targetZones
+	snapshotVolume.DataSource = &DataSource{
+		Kind: VolumeSnapshotKind,
+		Name: snapshot.Name,
+	}
+	t.PodWithSnapshot.Volumes = []VolumeDetails{snapshotVolume}
+	tPodWithSnapshot, tPodWithSnapshotCleanup := t.PodWithSnapshot.SetupWithDynamicVolumes(ctx, client, namespace, t.CSIDriver, t.StorageClassParameters)
+	for i := range tPodWithSnapshotCleanup {
+		defer tPodWithSnapshotCleanup[i](ctx)
+	}
+
+	ginkgo.By(fmt.Sprintf("deploying a pod with a volume restored from the snapshot into zones %v", targetZones))
+	tPodWithSnapshot.Create(ctx)
+	defer tPodWithSnapshot.Cleanup(ctx)
+	ginkgo.By("checking that the pod's command exits with no error")
+	tPodWithSnapshot.WaitForSuccess(ctx)
+
+	ginkgo.By("checking that the restored volume is in a different zone")
+	restoredZone := tPodWithSnapshot.GetZoneForVolume(ctx, 0)
+	gomega.Expect(restoredZone).NotTo(gomega.Equal(sourceZone))
+	gomega.Expect(targetZones).To(gomega.ContainElement(restoredZone))
+}
@@ -50,6 +50,8 @@ type DynamicallyProvisionedVolumeSnapshotTest struct {
 	StorageClassParameters         map[string]string
 	SnapshotStorageClassParameters map[string]string
 	IsWindowsHPCDeployment         bool
+	// RestoredVolumeSize optional for when testing for restored volume with larger size than the original volume
+	RestoredVolumeSize string
 }
 
 func (t *DynamicallyProvisionedVolumeSnapshotTest) Run(ctx context.Context, client clientset.Interface, restclient restclientset.Interface, namespace *v1.Namespace) {
@@ -127,6 +129,9 @@ func (t *DynamicallyProvisionedVolumeSnapshotTest) Run(ctx context.Context, clie
 		Kind: VolumeSnapshotKind,
 		Name: snapshot.Name,
 	}
+	if t.RestoredVolumeSize != "" {
+		snapshotVolume.ClaimSize = t.RestoredVolumeSize
+	}
 	t.PodWithSnapshot.Volumes = []VolumeDetails{snapshotVolume}
 	tPodWithSnapshot, tPodWithSnapshotCleanup := t.PodWithSnapshot.SetupWithDynamicVolumes(ctx, client, namespace, t.CSIDriver, t.StorageClassParameters)
 	for i := range tPodWithSnapshotCleanup {
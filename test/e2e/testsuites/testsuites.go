@@ -316,7 +316,7 @@ func (t *TestPersistentVolumeClaim) WaitForBound(ctx context.Context) v1.Persist
 }
 
 func generatePVC(namespace, storageClassName, name, claimSize string, volumeMode v1.PersistentVolumeMode, accessMode v1.PersistentVolumeAccessMode, dataSource *v1.TypedLocalObjectReference) *v1.PersistentVolumeClaim {
-	if accessMode != v1.ReadWriteOnce && accessMode != v1.ReadOnlyMany && accessMode != v1.ReadWriteMany {
+	if accessMode != v1.ReadWriteOnce && accessMode != v1.ReadOnlyMany && accessMode != v1.ReadWriteMany && accessMode != v1.ReadWriteOncePod {
 		accessMode = v1.ReadWriteOnce
 	}
 
@@ -833,12 +833,17 @@ func (t *TestPod) WaitForRunningLong(ctx context.Context) {
 }
 
 func (t *TestPod) WaitForFailedMountError(ctx context.Context) {
+	t.WaitForFailedMountEvent(ctx, "MountVolume.MountDevice failed for volume")
+}
+
+// WaitForFailedMountEvent waits for a FailedMount event containing msg in the namespace of the pod
+func (t *TestPod) WaitForFailedMountEvent(ctx context.Context, msg string) {
 	err := e2eevents.WaitTimeoutForEvent(
 		ctx,
 		t.client,
 		t.namespace.Name,
 		fields.Set{"reason": events.FailedMountVolume}.AsSelector().String(),
-		"MountVolume.MountDevice failed for volume",
+		msg,
 		pollLongTimeout)
 	framework.ExpectNoError(err)
 }
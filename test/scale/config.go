@@ -0,0 +1,158 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scale
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	imageutils "k8s.io/kubernetes/test/utils/image"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+const (
+	volumeCountEnvVar             = "SCALE_VOLUME_COUNT"
+	volumesPerPodEnvVar           = "SCALE_VOLUMES_PER_POD"
+	concurrencyEnvVar             = "SCALE_CONCURRENCY"
+	driverNameEnvVar              = "SCALE_DRIVER_NAME"
+	storageClassEnvVar            = "SCALE_STORAGE_CLASS"
+	skuNameEnvVar                 = "SCALE_SKU_NAME"
+	podImageEnvVar                = "SCALE_POD_IMAGE"
+	timeoutMinutesEnvVar          = "SCALE_TIMEOUT_MINUTES"
+	maxProvisionP99EnvVar         = "SCALE_MAX_PROVISION_P99_SECONDS"
+	maxAttachP99EnvVar            = "SCALE_MAX_ATTACH_P99_SECONDS"
+	maxPodStartupP99EnvVar        = "SCALE_MAX_POD_STARTUP_P99_SECONDS"
+	maxCleanupSecondsEnvVar       = "SCALE_MAX_CLEANUP_SECONDS"
+	reportFileEnvVar              = "SCALE_REPORT_FILE"
+	reportDirEnvVar               = "ARTIFACTS"
+	defaultReportFileName         = "scale-report.json"
+	defaultVolumeCount            = 500
+	defaultVolumesPerPod          = 1
+	defaultConcurrency            = 50
+	defaultSkuName                = "StandardSSD_LRS"
+	defaultTimeoutMinutes         = 60
+	defaultMaxCleanupSeconds      = 1800
+	defaultMaxLatencyThresholdSec = 0
+)
+
+// scaleConfig is the configuration of the scale test read from the environment, so that the same test runs in CI
+// with a small cluster and in perf labs with large ones
+type scaleConfig struct {
+	// VolumeCount is the number of PVCs provisioned
+	VolumeCount int `json:"volumeCount"`
+	// VolumesPerPod is the number of PVCs mounted by each pod
+	VolumesPerPod int `json:"volumesPerPod"`
+	// Concurrency is the number of PVCs or pods created in parallel
+	Concurrency int `json:"concurrency"`
+	// DriverName is the provisioner of the StorageClass created by the test
+	DriverName string `json:"driverName"`
+	// StorageClass is an existing StorageClass of the PVCs, a StorageClass of SkuName is created if empty
+	StorageClass string `json:"storageClass,omitempty"`
+	SkuName      string `json:"skuName,omitempty"`
+	PodImage     string `json:"podImage"`
+	// Timeout is the timeout of provisioning the volumes and starting the pods
+	Timeout time.Duration `json:"-"`
+	// MaxCleanup is the timeout of deleting the pods, volumes and their attachments, leaks are reported after it
+	MaxCleanup time.Duration `json:"-"`
+	// the p99 latency thresholds in seconds, 0 disables the check
+	MaxProvisionP99Seconds  float64 `json:"maxProvisionP99Seconds,omitempty"`
+	MaxAttachP99Seconds     float64 `json:"maxAttachP99Seconds,omitempty"`
+	MaxPodStartupP99Seconds float64 `json:"maxPodStartupP99Seconds,omitempty"`
+	ReportFile              string  `json:"-"`
+}
+
+// getScaleConfig returns the configuration of the scale test in the environment
+func getScaleConfig() (*scaleConfig, error) {
+	cfg := &scaleConfig{
+		DriverName:   getEnv(driverNameEnvVar, consts.DefaultDriverName),
+		StorageClass: os.Getenv(storageClassEnvVar),
+		SkuName:      getEnv(skuNameEnvVar, defaultSkuName),
+		PodImage:     getEnv(podImageEnvVar, imageutils.GetE2EImage(imageutils.BusyBox)),
+		ReportFile:   os.Getenv(reportFileEnvVar),
+	}
+	if cfg.StorageClass != "" {
+		cfg.SkuName = ""
+	}
+	if cfg.ReportFile == "" {
+		cfg.ReportFile = filepath.Join(getEnv(reportDirEnvVar, "."), defaultReportFileName)
+	}
+	var err error
+	if cfg.VolumeCount, err = getPositiveIntEnv(volumeCountEnvVar, defaultVolumeCount); err != nil {
+		return nil, err
+	}
+	if cfg.VolumesPerPod, err = getPositiveIntEnv(volumesPerPodEnvVar, defaultVolumesPerPod); err != nil {
+		return nil, err
+	}
+	if cfg.Concurrency, err = getPositiveIntEnv(concurrencyEnvVar, defaultConcurrency); err != nil {
+		return nil, err
+	}
+	timeoutMinutes, err := getPositiveIntEnv(timeoutMinutesEnvVar, defaultTimeoutMinutes)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Timeout = time.Duration(timeoutMinutes) * time.Minute
+	maxCleanupSeconds, err := getPositiveIntEnv(maxCleanupSecondsEnvVar, defaultMaxCleanupSeconds)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxCleanup = time.Duration(maxCleanupSeconds) * time.Second
+	for envVar, threshold := range map[string]*float64{
+		maxProvisionP99EnvVar:  &cfg.MaxProvisionP99Seconds,
+		maxAttachP99EnvVar:     &cfg.MaxAttachP99Seconds,
+		maxPodStartupP99EnvVar: &cfg.MaxPodStartupP99Seconds,
+	} {
+		if *threshold, err = getFloatEnv(envVar, defaultMaxLatencyThresholdSec); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getPositiveIntEnv(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s, should be a positive integer", key, value)
+	}
+	return n, nil
+}
+
+func getFloatEnv(key string, defaultValue float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid %s: %s, should be a non-negative number", key, value)
+	}
+	return f, nil
+}
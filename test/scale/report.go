@@ -0,0 +1,167 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scale
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyRecorder records the start and end of an operation per object, e.g. the creation and binding of a PVC,
+// only the first end of an object is recorded
+type latencyRecorder struct {
+	mu     sync.Mutex
+	starts map[string]time.Time
+	ends   map[string]time.Time
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{starts: map[string]time.Time{}, ends: map[string]time.Time{}}
+}
+
+func (r *latencyRecorder) start(key string, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.starts[key]; !ok {
+		r.starts[key] = t
+	}
+}
+
+func (r *latencyRecorder) end(key string, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ends[key]; !ok {
+		r.ends[key] = t
+	}
+}
+
+// completed returns the number of objects whose operation ended
+func (r *latencyRecorder) completed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.ends)
+}
+
+// stats returns the latency statistics of the objects whose operation started and ended
+func (r *latencyRecorder) stats() latencyStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latencies := []time.Duration{}
+	for key, start := range r.starts {
+		if end, ok := r.ends[key]; ok {
+			latencies = append(latencies, max(end.Sub(start), 0))
+		}
+	}
+	return newLatencyStats(latencies, len(r.starts))
+}
+
+// latencyStats is the latency distribution of an operation in the report
+type latencyStats struct {
+	Started    int     `json:"started"`
+	Completed  int     `json:"completed"`
+	P50Seconds float64 `json:"p50Seconds"`
+	P90Seconds float64 `json:"p90Seconds"`
+	P99Seconds float64 `json:"p99Seconds"`
+	MaxSeconds float64 `json:"maxSeconds"`
+}
+
+func newLatencyStats(latencies []time.Duration, started int) latencyStats {
+	stats := latencyStats{Started: started, Completed: len(latencies)}
+	if len(latencies) == 0 {
+		return stats
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) float64 {
+		// nearest-rank percentile
+		rank := int(math.Ceil(p / 100 * float64(len(latencies))))
+		return latencies[max(rank, 1)-1].Seconds()
+	}
+	stats.P50Seconds = percentile(50)
+	stats.P90Seconds = percentile(90)
+	stats.P99Seconds = percentile(99)
+	stats.MaxSeconds = latencies[len(latencies)-1].Seconds()
+	return stats
+}
+
+// scaleReport is the machine readable result of a scale test run
+type scaleReport struct {
+	Config     *scaleConfig `json:"config"`
+	StartTime  time.Time    `json:"startTime"`
+	EndTime    time.Time    `json:"endTime"`
+	NodeCount  int          `json:"nodeCount"`
+	Provision  latencyStats `json:"provision"`
+	Attach     latencyStats `json:"attach"`
+	PodStartup latencyStats `json:"podStartup"`
+	// CleanupSeconds is the duration of deleting the pods and PVCs until their PVs and VolumeAttachments are gone
+	CleanupSeconds float64 `json:"cleanupSeconds"`
+	// the objects left after the cleanup timeout
+	LeakedPersistentVolumes []string `json:"leakedPersistentVolumes"`
+	LeakedVolumeAttachments []string `json:"leakedVolumeAttachments"`
+	// Violations are the failed checks of the run, the run passes if it's empty
+	Violations []string `json:"violations"`
+}
+
+// check records the violations of the report against the thresholds of its config
+func (r *scaleReport) check() {
+	r.Violations = []string{}
+	for _, stats := range []struct {
+		name      string
+		stats     latencyStats
+		expected  int
+		threshold float64
+	}{
+		{"provision", r.Provision, r.Config.VolumeCount, r.Config.MaxProvisionP99Seconds},
+		{"attach", r.Attach, r.Config.VolumeCount, r.Config.MaxAttachP99Seconds},
+		{"pod startup", r.PodStartup, podCount(r.Config), r.Config.MaxPodStartupP99Seconds},
+	} {
+		if stats.stats.Completed < stats.expected {
+			r.Violations = append(r.Violations, fmt.Sprintf("%s completed for %d of %d objects", stats.name, stats.stats.Completed, stats.expected))
+		}
+		if stats.threshold > 0 && stats.stats.P99Seconds > stats.threshold {
+			r.Violations = append(r.Violations, fmt.Sprintf("%s p99 latency %.1fs exceeds %.1fs", stats.name, stats.stats.P99Seconds, stats.threshold))
+		}
+	}
+	if len(r.LeakedPersistentVolumes) > 0 {
+		r.Violations = append(r.Violations, fmt.Sprintf("%d PersistentVolumes leaked: %v", len(r.LeakedPersistentVolumes), r.LeakedPersistentVolumes))
+	}
+	if len(r.LeakedVolumeAttachments) > 0 {
+		r.Violations = append(r.Violations, fmt.Sprintf("%d VolumeAttachments leaked: %v", len(r.LeakedVolumeAttachments), r.LeakedVolumeAttachments))
+	}
+}
+
+// write writes the report to path as JSON
+func (r *scaleReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// podCount returns the number of pods mounting the volumes of cfg
+func podCount(cfg *scaleConfig) int {
+	return (cfg.VolumeCount + cfg.VolumesPerPod - 1) / cfg.VolumesPerPod
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	scaleLabelKey   = "azuredisk-scale-test"
+	pollInterval    = 5 * time.Second
+	progressLogStep = time.Minute
+)
+
+var _ = ginkgo.Describe("[scale] Dynamic Provisioning", func() {
+	ginkgo.It("should provision, attach and clean up volumes at scale", func(ctx ginkgo.SpecContext) {
+		report := &scaleReport{Config: cfg, StartTime: time.Now()}
+		defer func() {
+			report.EndTime = time.Now()
+			if err := report.write(cfg.ReportFile); err != nil {
+				log.Printf("failed to write report %s: %v", cfg.ReportFile, err)
+			} else {
+				log.Printf("wrote report %s", cfg.ReportFile)
+			}
+		}()
+
+		ns, err := clientSet.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "azuredisk-scale-", Labels: map[string]string{scaleLabelKey: "true"}},
+		}, metav1.CreateOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		defer func() {
+			if err := clientSet.CoreV1().Namespaces().Delete(context.Background(), ns.Name, metav1.DeleteOptions{}); err != nil {
+				log.Printf("failed to delete namespace %s: %v", ns.Name, err)
+			}
+		}()
+
+		storageClassName := cfg.StorageClass
+		if storageClassName == "" {
+			sc, err := clientSet.StorageV1().StorageClasses().Create(ctx, &storagev1.StorageClass{
+				ObjectMeta:        metav1.ObjectMeta{GenerateName: "azuredisk-scale-", Labels: map[string]string{scaleLabelKey: "true"}},
+				Provisioner:       cfg.DriverName,
+				Parameters:        map[string]string{"skuName": cfg.SkuName},
+				ReclaimPolicy:     ptr.To(v1.PersistentVolumeReclaimDelete),
+				VolumeBindingMode: ptr.To(storagev1.VolumeBindingImmediate),
+			}, metav1.CreateOptions{})
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			storageClassName = sc.Name
+			defer func() {
+				if err := clientSet.StorageV1().StorageClasses().Delete(context.Background(), sc.Name, metav1.DeleteOptions{}); err != nil {
+					log.Printf("failed to delete StorageClass %s: %v", sc.Name, err)
+				}
+			}()
+		}
+
+		provision, attach, podStartup := newLatencyRecorder(), newLatencyRecorder(), newLatencyRecorder()
+		// <PV name> of the PVCs of the test, set once the PVCs are bound
+		pvNames := sets.New[string]()
+		nodes := sets.New[string]()
+		stopInformers := sync.OnceFunc(startInformers(ns.Name, provision, attach, podStartup, pvNames, nodes))
+		defer stopInformers()
+
+		ginkgo.By(fmt.Sprintf("creating %d PVCs of StorageClass %s", cfg.VolumeCount, storageClassName))
+		workqueue.ParallelizeUntil(ctx, cfg.Concurrency, cfg.VolumeCount, func(i int) {
+			name := fmt.Sprintf("pvc-%d", i)
+			provision.start(name, time.Now())
+			if _, err := clientSet.CoreV1().PersistentVolumeClaims(ns.Name).Create(ctx, newScalePVC(name, storageClassName), metav1.CreateOptions{}); err != nil {
+				log.Printf("failed to create PVC %s: %v", name, err)
+			}
+		})
+		waitForCompletion(ctx, "provisioning", provision, cfg.VolumeCount, cfg.Timeout)
+
+		ginkgo.By(fmt.Sprintf("creating %d pods mounting %d PVCs each", podCount(cfg), cfg.VolumesPerPod))
+		workqueue.ParallelizeUntil(ctx, cfg.Concurrency, podCount(cfg), func(i int) {
+			name := fmt.Sprintf("pod-%d", i)
+			podStartup.start(name, time.Now())
+			if _, err := clientSet.CoreV1().Pods(ns.Name).Create(ctx, newScalePod(name, i), metav1.CreateOptions{}); err != nil {
+				log.Printf("failed to create pod %s: %v", name, err)
+			}
+		})
+		waitForCompletion(ctx, "pod startup", podStartup, podCount(cfg), cfg.Timeout)
+
+		report.Provision, report.Attach, report.PodStartup = provision.stats(), attach.stats(), podStartup.stats()
+		// the informers are stopped so that the PV names and nodes are not changed any more
+		stopInformers()
+		report.NodeCount = nodes.Len()
+
+		ginkgo.By("deleting the pods and PVCs")
+		cleanupStart := time.Now()
+		gomega.Expect(clientSet.CoreV1().Pods(ns.Name).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{})).To(gomega.Succeed())
+		gomega.Expect(clientSet.CoreV1().PersistentVolumeClaims(ns.Name).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{})).To(gomega.Succeed())
+		report.LeakedPersistentVolumes, report.LeakedVolumeAttachments = waitForCleanup(ctx, sets.List(pvNames), cfg.MaxCleanup)
+		report.CleanupSeconds = time.Since(cleanupStart).Seconds()
+
+		report.check()
+		gomega.Expect(report.Violations).To(gomega.BeEmpty())
+	})
+})
+
+// startInformers records the binding of the PVCs and the startup of the pods in namespace, and the attachment of
+// their PVs, until the returned function is called
+func startInformers(namespace string, provision, attach, podStartup *latencyRecorder, pvNames, nodes sets.Set[string]) func() {
+	nsFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, 0, informers.WithNamespace(namespace))
+	factory := informers.NewSharedInformerFactory(clientSet, 0)
+	// guards pvNames and nodes
+	var mu sync.Mutex
+	lock := func() func() {
+		mu.Lock()
+		return mu.Unlock
+	}
+
+	onPVC := func(obj interface{}) {
+		if pvc, ok := obj.(*v1.PersistentVolumeClaim); ok && pvc.Status.Phase == v1.ClaimBound {
+			provision.end(pvc.Name, time.Now())
+			defer lock()()
+			pvNames.Insert(pvc.Spec.VolumeName)
+		}
+	}
+	_, _ = nsFactory.Core().V1().PersistentVolumeClaims().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    onPVC,
+		UpdateFunc: func(_, obj interface{}) { onPVC(obj) },
+	})
+	onPod := func(obj interface{}) {
+		pod, ok := obj.(*v1.Pod)
+		if !ok {
+			return
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodReady && condition.Status == v1.ConditionTrue {
+				podStartup.end(pod.Name, time.Now())
+				defer lock()()
+				nodes.Insert(pod.Spec.NodeName)
+			}
+		}
+	}
+	_, _ = nsFactory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    onPod,
+		UpdateFunc: func(_, obj interface{}) { onPod(obj) },
+	})
+	// VolumeAttachments are cluster scoped, the attachments of other PVs are filtered out when reporting
+	onVolumeAttachment := func(obj interface{}) {
+		va, ok := obj.(*storagev1.VolumeAttachment)
+		if !ok || va.Spec.Attacher != cfg.DriverName || va.Spec.Source.PersistentVolumeName == nil {
+			return
+		}
+		unlock := lock()
+		ours := pvNames.Has(*va.Spec.Source.PersistentVolumeName)
+		unlock()
+		if !ours {
+			return
+		}
+		attach.start(va.Name, time.Now())
+		if va.Status.Attached {
+			attach.end(va.Name, time.Now())
+		}
+	}
+	_, _ = factory.Storage().V1().VolumeAttachments().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    onVolumeAttachment,
+		UpdateFunc: func(_, obj interface{}) { onVolumeAttachment(obj) },
+	})
+	stopCh := make(chan struct{})
+	nsFactory.Start(stopCh)
+	factory.Start(stopCh)
+	nsFactory.WaitForCacheSync(stopCh)
+	factory.WaitForCacheSync(stopCh)
+	return func() {
+		close(stopCh)
+		nsFactory.Shutdown()
+		factory.Shutdown()
+	}
+}
+
+// waitForCompletion waits until the operation of recorder completes for expected objects or timeout, the progress
+// is logged every minute and the report records the incomplete objects
+func waitForCompletion(ctx context.Context, operation string, recorder *latencyRecorder, expected int, timeout time.Duration) {
+	lastLog := time.Now()
+	err := wait.PollUntilContextTimeout(ctx, pollInterval, timeout, true, func(context.Context) (bool, error) {
+		completed := recorder.completed()
+		if time.Since(lastLog) >= progressLogStep {
+			log.Printf("%s completed for %d of %d objects", operation, completed, expected)
+			lastLog = time.Now()
+		}
+		return completed >= expected, nil
+	})
+	if err != nil {
+		log.Printf("%s completed for %d of %d objects in %v: %v", operation, recorder.completed(), expected, timeout, err)
+	}
+}
+
+// waitForCleanup waits until pvNames and their VolumeAttachments are deleted or timeout, and returns the leaked ones
+func waitForCleanup(ctx context.Context, pvNames []string, timeout time.Duration) ([]string, []string) {
+	var leakedPVs, leakedAttachments []string
+	_ = wait.PollUntilContextTimeout(ctx, pollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		leakedPVs, leakedAttachments = []string{}, []string{}
+		remaining := sets.New[string]()
+		for _, name := range pvNames {
+			if _, err := clientSet.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{}); err == nil {
+				leakedPVs = append(leakedPVs, name)
+				remaining.Insert(name)
+			} else if !apierrors.IsNotFound(err) {
+				return false, nil
+			}
+		}
+		attachments, err := clientSet.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, nil
+		}
+		names := sets.New(pvNames...)
+		for _, va := range attachments.Items {
+			if va.Spec.Source.PersistentVolumeName != nil && names.Has(*va.Spec.Source.PersistentVolumeName) {
+				leakedAttachments = append(leakedAttachments, va.Name)
+			}
+		}
+		return len(leakedPVs) == 0 && len(leakedAttachments) == 0, nil
+	})
+	sort.Strings(leakedPVs)
+	sort.Strings(leakedAttachments)
+	return leakedPVs, leakedAttachments
+}
+
+func newScalePVC(name, storageClassName string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{scaleLabelKey: "true"}},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: ptr.To(storageClassName),
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+	}
+}
+
+// newScalePod returns the i-th pod of the test, mounting the PVCs from i*VolumesPerPod
+func newScalePod(name string, i int) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{scaleLabelKey: "true"}},
+		Spec: v1.PodSpec{
+			NodeSelector: map[string]string{v1.LabelOSStable: "linux"},
+			Containers: []v1.Container{{
+				Name:    "volume-tester",
+				Image:   cfg.PodImage,
+				Command: []string{"/bin/sh", "-c", "sleep 3600"},
+			}},
+			TerminationGracePeriodSeconds: ptr.To(int64(0)),
+		},
+	}
+	for j := i * cfg.VolumesPerPod; j < min((i+1)*cfg.VolumesPerPod, cfg.VolumeCount); j++ {
+		volume := fmt.Sprintf("volume-%d", j)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+			Name: volume,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: fmt.Sprintf("pvc-%d", j)},
+			},
+		})
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, v1.VolumeMount{Name: volume, MountPath: "/mnt/" + volume})
+	}
+	return pod
+}
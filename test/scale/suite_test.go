@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scale
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const kubeconfigEnvVar = "KUBECONFIG"
+
+var (
+	cfg       *scaleConfig
+	clientSet clientset.Interface
+)
+
+var _ = ginkgo.BeforeSuite(func() {
+	kubeconfig := os.Getenv(kubeconfigEnvVar)
+	if kubeconfig == "" {
+		kubeconfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	}
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	// the default client side rate limit would dominate the latencies of hundreds of objects
+	restConfig.QPS = 100
+	restConfig.Burst = 200
+	clientSet, err = clientset.NewForConfig(restConfig)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+	cfg, err = getScaleConfig()
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	log.Printf("scale test config: %+v", *cfg)
+})
+
+func TestScale(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "AzureDisk CSI Driver Scale Tests")
+}
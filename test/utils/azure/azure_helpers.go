@@ -45,6 +45,7 @@ type Client struct {
 	vnetClient          virtualnetworkclient.Interface
 	disksClient         diskclient.Interface
 	sshPublicKeysClient sshpublickeyresourceclient.Interface
+	resourceSKUsClient  *compute.ResourceSKUsClient
 }
 
 func GetAzureClient(cloud, subscriptionID, clientID, tenantID, clientSecret, aadFederatedTokenFile string) (*Client, error) {
@@ -72,6 +73,14 @@ func GetAzureClient(cloud, subscriptionID, clientID, tenantID, clientSecret, aad
 	if err != nil {
 		return nil, err
 	}
+	clientOption, err := azclient.GetDefaultResourceClientOption(armConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	resourceSKUsClient, err := compute.NewResourceSKUsClient(subscriptionID, cred, clientOption)
+	if err != nil {
+		return nil, err
+	}
 	return &Client{
 		groupsClient:        factory.GetResourceGroupClient(),
 		vmClient:            factory.GetVirtualMachineClient(),
@@ -80,6 +89,7 @@ func GetAzureClient(cloud, subscriptionID, clientID, tenantID, clientSecret, aad
 		vnetClient:          factory.GetVirtualNetworkClient(),
 		disksClient:         factory.GetDiskClient(),
 		sshPublicKeysClient: factory.GetSSHPublicKeyResourceClient(),
+		resourceSKUsClient:  resourceSKUsClient,
 	}, nil
 }
 func (az *Client) GetAzureDisksClient() (diskclient.Interface, error) {
@@ -0,0 +1,107 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	compute "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"k8s.io/utils/ptr"
+)
+
+// DiskFeatures are the disk SKUs available to the subscription in a region
+type DiskFeatures struct {
+	// ZRS is true if Premium_ZRS and StandardSSD_ZRS disks are available
+	ZRS bool
+	// UltraSSDZones are the zones of UltraSSD_LRS disks, empty if not available
+	UltraSSDZones []string
+	// PremiumV2Zones are the zones of PremiumV2_LRS disks, empty if not available
+	PremiumV2Zones []string
+}
+
+// GetDiskFeatures probes the disk SKUs available to the subscription in location
+func (az *Client) GetDiskFeatures(ctx context.Context, location string) (*DiskFeatures, error) {
+	var skus []*compute.ResourceSKU
+	pager := az.resourceSKUsClient.NewListPager(&compute.ResourceSKUsClientListOptions{
+		Filter: to.Ptr(fmt.Sprintf("location eq '%s'", location)),
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list resource SKUs in %s: %w", location, err)
+		}
+		skus = append(skus, page.Value...)
+	}
+	return getDiskFeatures(skus, location), nil
+}
+
+func getDiskFeatures(skus []*compute.ResourceSKU, location string) *DiskFeatures {
+	features := &DiskFeatures{}
+	available := map[string][]string{}
+	for _, sku := range skus {
+		if sku == nil || !strings.EqualFold(ptr.Deref(sku.ResourceType, ""), "disks") {
+			continue
+		}
+		if zones, ok := getAvailableZones(sku, location); ok {
+			available[ptr.Deref(sku.Name, "")] = zones
+		}
+	}
+	_, premiumZRS := available["Premium_ZRS"]
+	_, standardSSDZRS := available["StandardSSD_ZRS"]
+	features.ZRS = premiumZRS && standardSSDZRS
+	features.UltraSSDZones = available["UltraSSD_LRS"]
+	features.PremiumV2Zones = available["PremiumV2_LRS"]
+	return features
+}
+
+// getAvailableZones returns the zones of sku in location without the zones restricted for the subscription,
+// false is returned if sku is not available in location
+func getAvailableZones(sku *compute.ResourceSKU, location string) ([]string, bool) {
+	restrictedZones := map[string]bool{}
+	for _, restriction := range sku.Restrictions {
+		if restriction == nil || restriction.Type == nil {
+			continue
+		}
+		switch *restriction.Type {
+		case compute.ResourceSKURestrictionsTypeLocation:
+			return nil, false
+		case compute.ResourceSKURestrictionsTypeZone:
+			if restriction.RestrictionInfo != nil {
+				for _, zone := range restriction.RestrictionInfo.Zones {
+					restrictedZones[ptr.Deref(zone, "")] = true
+				}
+			}
+		}
+	}
+
+	for _, info := range sku.LocationInfo {
+		if info == nil || !strings.EqualFold(ptr.Deref(info.Location, ""), location) {
+			continue
+		}
+		zones := []string{}
+		for _, zone := range info.Zones {
+			if !restrictedZones[ptr.Deref(zone, "")] {
+				zones = append(zones, ptr.Deref(zone, ""))
+			}
+		}
+		return zones, true
+	}
+	return nil, false
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	compute "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDiskSKU(name, location string, zones []string, restrictions ...*compute.ResourceSKURestrictions) *compute.ResourceSKU {
+	return &compute.ResourceSKU{
+		Name:         to.Ptr(name),
+		ResourceType: to.Ptr("disks"),
+		LocationInfo: []*compute.ResourceSKULocationInfo{{Location: to.Ptr(location), Zones: to.SliceOfPtrs(zones...)}},
+		Restrictions: restrictions,
+	}
+}
+
+func TestGetDiskFeatures(t *testing.T) {
+	skus := []*compute.ResourceSKU{
+		newDiskSKU("Premium_ZRS", "eastus", nil),
+		newDiskSKU("StandardSSD_ZRS", "eastus", nil),
+		newDiskSKU("UltraSSD_LRS", "eastus", []string{"1", "2", "3"}, &compute.ResourceSKURestrictions{
+			Type:            to.Ptr(compute.ResourceSKURestrictionsTypeZone),
+			RestrictionInfo: &compute.ResourceSKURestrictionInfo{Zones: to.SliceOfPtrs("2")},
+		}),
+		newDiskSKU("PremiumV2_LRS", "eastus", []string{"1"}, &compute.ResourceSKURestrictions{
+			Type: to.Ptr(compute.ResourceSKURestrictionsTypeLocation),
+		}),
+		{Name: to.Ptr("UltraSSD_LRS"), ResourceType: to.Ptr("virtualMachines")},
+	}
+	assert.Equal(t, &DiskFeatures{ZRS: true, UltraSSDZones: []string{"1", "3"}}, getDiskFeatures(skus, "eastus"))
+	assert.Equal(t, &DiskFeatures{}, getDiskFeatures(skus, "westus"))
+	assert.Equal(t, &DiskFeatures{}, getDiskFeatures(skus[1:2], "eastus"), "ZRS requires both ZRS SKUs")
+}